@@ -66,14 +66,18 @@ func New(b *beat.Beat, rawConfig *common.Config) (beat.Beater, error) {
 		}
 	}
 
-	if !config.ConfigProspector.Enabled() && !haveEnabledProspectors {
-		return nil, errors.New("No modules or prospectors enabled and configuration reloading disabled. What files do you want me to watch?")
+	if !config.ConfigProspector.Enabled() && !config.Autodiscover.Enabled() && !haveEnabledProspectors {
+		return nil, errors.New("No modules or prospectors enabled, configuration reloading and autodiscover disabled. What files do you want me to watch?")
 	}
 
 	if *once && config.ConfigProspector.Enabled() {
 		return nil, errors.New("prospector configs and -once cannot be used together")
 	}
 
+	if *once && config.Autodiscover.Enabled() {
+		return nil, errors.New("autodiscover and -once cannot be used together")
+	}
+
 	fb := &Filebeat{
 		done:           make(chan struct{}),
 		config:         &config,
@@ -191,7 +195,7 @@ func (fb *Filebeat) Run(b *beat.Beat) error {
 		spooler.Stop()
 	}()
 
-	err = crawler.Start(registrar, config.ConfigProspector)
+	err = crawler.Start(registrar, config.ConfigProspector, config.Autodiscover)
 	if err != nil {
 		crawler.Stop()
 		return err
@@ -8,6 +8,7 @@ import (
 	"github.com/elastic/beats/filebeat/input/file"
 	"github.com/elastic/beats/filebeat/prospector"
 	"github.com/elastic/beats/filebeat/registrar"
+	"github.com/elastic/beats/libbeat/autodiscover"
 	"github.com/elastic/beats/libbeat/cfgfile"
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
@@ -19,6 +20,7 @@ type Crawler struct {
 	out               channel.Outleter
 	wg                sync.WaitGroup
 	reloader          *cfgfile.Reloader
+	autodiscover      *autodiscover.Autodiscover
 	once              bool
 	beatDone          chan struct{}
 }
@@ -35,7 +37,7 @@ func New(out channel.Outleter, prospectorConfigs []*common.Config, beatDone chan
 }
 
 // Start starts the crawler with all prospectors
-func (c *Crawler) Start(r *registrar.Registrar, configProspectors *common.Config) error {
+func (c *Crawler) Start(r *registrar.Registrar, configProspectors *common.Config, configAutodiscover *common.Config) error {
 
 	logp.Info("Loading Prospectors: %v", len(c.prospectorConfigs))
 
@@ -57,6 +59,25 @@ func (c *Crawler) Start(r *registrar.Registrar, configProspectors *common.Config
 		}()
 	}
 
+	if configAutodiscover.Enabled() {
+		logp.Beta("Autodiscover is enabled.")
+
+		config := autodiscover.Config{}
+		if err := configAutodiscover.Unpack(&config); err != nil {
+			return fmt.Errorf("Error reading autodiscover config: %v", err)
+		}
+
+		factory := prospector.NewFactory(c.out, r, c.beatDone)
+		adiscover, err := autodiscover.NewAutodiscover("filebeat", factory, &config)
+		if err != nil {
+			return err
+		}
+		if err := adiscover.Start(); err != nil {
+			return err
+		}
+		c.autodiscover = adiscover
+	}
+
 	logp.Info("Loading and starting Prospectors completed. Enabled prospectors: %v", len(c.prospectors))
 
 	return nil
@@ -109,6 +130,10 @@ func (c *Crawler) Stop() {
 		asyncWaitStop(c.reloader.Stop)
 	}
 
+	if c.autodiscover != nil {
+		asyncWaitStop(c.autodiscover.Stop)
+	}
+
 	c.WaitForCompletion()
 
 	logp.Info("Crawler stopped")
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/elastic/beats/libbeat/api"
 	"github.com/elastic/beats/libbeat/beat"
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
@@ -42,11 +43,16 @@ func New(b *beat.Beat, cfg *common.Config) (beat.Beater, error) {
 
 	client := b.Publisher.Connect()
 	sched := scheduler.NewWithLocation(limit, location)
-	manager, err := newMonitorManager(client, sched, monitors.Registry, config.Monitors)
+	manager, err := newMonitorManager(client, sched, monitors.Registry, config.Monitors, config.State)
 	if err != nil {
 		return nil, err
 	}
 
+	// report current monitor states via the http endpoint
+	if err := api.RegisterHandler("/state", manager.states.snapshot); err != nil {
+		logp.Warn("Failed to register monitor state endpoint: %v", err)
+	}
+
 	bt := &Heartbeat{
 		done:      make(chan struct{}),
 		client:    client,
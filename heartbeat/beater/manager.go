@@ -11,6 +11,7 @@ import (
 	"github.com/elastic/beats/libbeat/logp"
 	"github.com/elastic/beats/libbeat/publisher"
 
+	"github.com/elastic/beats/heartbeat/config"
 	"github.com/elastic/beats/heartbeat/monitors"
 	"github.com/elastic/beats/heartbeat/scheduler"
 	"github.com/elastic/beats/heartbeat/scheduler/schedule"
@@ -21,6 +22,10 @@ type MonitorManager struct {
 	monitors   []Monitor
 	jobControl JobControl
 	client     publisher.Client
+
+	// default state tracking settings and current monitor states
+	state  config.State
+	states *stateTracker
 }
 
 type Monitor struct {
@@ -40,6 +45,7 @@ type MonitorTask struct {
 
 	job      monitors.Job
 	schedule scheduler.Schedule
+	state    config.State
 	cancel   JobCanceller
 }
 
@@ -58,6 +64,7 @@ func newMonitorManager(
 	jobControl JobControl,
 	registry *monitors.Registrar,
 	configs []*common.Config,
+	state config.State,
 ) (*MonitorManager, error) {
 	type watchConfig struct {
 		Path string        `config:"watch.poll_file.path"`
@@ -70,6 +77,8 @@ func newMonitorManager(
 	m := &MonitorManager{
 		client:     client,
 		jobControl: jobControl,
+		state:      state,
+		states:     newStateTracker(),
 	}
 
 	if len(configs) == 0 {
@@ -148,7 +157,7 @@ func newMonitorManager(
 func (m *Monitor) Update(configs []*common.Config) error {
 	all := map[string]MonitorTask{}
 	for i, upd := range configs {
-		config, err := common.MergeConfigs(m.config, upd)
+		cfg, err := common.MergeConfigs(m.config, upd)
 		if err != nil {
 			logp.Err("Failed merging monitor config with updates: %v", err)
 			return err
@@ -158,13 +167,16 @@ func (m *Monitor) Update(configs []*common.Config) error {
 			Name     string             `config:"name"`
 			Type     string             `config:"type"`
 			Schedule *schedule.Schedule `config:"schedule" validate:"required"`
-		}{}
-		if err := config.Unpack(&shared); err != nil {
+			State    config.State       `config:"state"`
+		}{
+			State: m.manager.state,
+		}
+		if err := cfg.Unpack(&shared); err != nil {
 			logp.Err("Failed parsing job schedule: ", err)
 			return err
 		}
 
-		jobs, err := m.factory(config)
+		jobs, err := m.factory(cfg)
 		if err != nil {
 			err = fmt.Errorf("%v when initializing monitor %v(%v)", err, m.name, i)
 			return err
@@ -181,19 +193,23 @@ func (m *Monitor) Update(configs []*common.Config) error {
 				typ:      shared.Type,
 				job:      job,
 				schedule: shared.Schedule,
+				state:    shared.State,
 			}
 		}
 	}
 
 	// stop all active jobs
-	for _, job := range m.active {
+	for id, job := range m.active {
 		job.cancel()
+		if _, exists := all[id]; !exists {
+			m.manager.states.remove(id)
+		}
 	}
 	m.active = map[string]MonitorTask{}
 
 	// start new and reconfigured tasks
 	for id, t := range all {
-		job := createJob(m.manager.client, t.job, t.name, t.typ)
+		job := createJob(m.manager.client, m.manager.states, t.job, t.name, t.typ, t.state)
 		t.cancel = m.manager.jobControl.Add(t.schedule, id, job)
 		m.active[id] = t
 	}
@@ -234,11 +250,23 @@ func createWatchUpdater(monitor *Monitor) func(content []byte) {
 	}
 }
 
-func createJob(client publisher.Client, r monitors.Job, name, typ string) scheduler.TaskFunc {
-	return createJobTask(client, r, name, typ)
+func createJob(
+	client publisher.Client,
+	states *stateTracker,
+	r monitors.Job,
+	name, typ string,
+	state config.State,
+) scheduler.TaskFunc {
+	return createJobTask(client, states, r, name, typ, state)
 }
 
-func createJobTask(client publisher.Client, r monitors.TaskRunner, name, typ string) scheduler.TaskFunc {
+func createJobTask(
+	client publisher.Client,
+	states *stateTracker,
+	r monitors.TaskRunner,
+	name, typ string,
+	state config.State,
+) scheduler.TaskFunc {
 	return func() []scheduler.TaskFunc {
 		event, next, err := r.Run()
 		if err != nil {
@@ -256,7 +284,13 @@ func createJobTask(client publisher.Client, r monitors.TaskRunner, name, typ str
 			if _, exists := event["type"]; !exists {
 				event["type"] = defaultEventType
 			}
+			// The event belongs to the publisher pipeline once published, so
+			// the state is updated first.
+			change := states.update(event, state)
 			client.PublishEvent(event)
+			if change != nil {
+				client.PublishEvent(change)
+			}
 		}
 
 		if len(next) == 0 {
@@ -265,7 +299,7 @@ func createJobTask(client publisher.Client, r monitors.TaskRunner, name, typ str
 
 		cont := make([]scheduler.TaskFunc, len(next))
 		for i, n := range next {
-			cont[i] = createJobTask(client, n, name, typ)
+			cont[i] = createJobTask(client, states, n, name, typ, state)
 		}
 		return cont
 	}
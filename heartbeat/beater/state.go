@@ -0,0 +1,226 @@
+package beater
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+
+	"github.com/elastic/beats/heartbeat/config"
+	"github.com/elastic/beats/heartbeat/look"
+)
+
+const (
+	stateEventType = "monitor_state"
+
+	statusUp      = "up"
+	statusDown    = "down"
+	statusUnknown = "unknown"
+)
+
+// stateTracker keeps track of the current up/down state of every monitored
+// endpoint. An endpoint is identified by the monitors job ID and the IP being
+// checked, such that every IP pinged in `mode: all` has its own state.
+type stateTracker struct {
+	mu     sync.Mutex
+	states map[endpointKey]*endpointState
+}
+
+type endpointKey struct {
+	id, ip string
+}
+
+type endpointState struct {
+	name, typ, host string
+
+	// status is the currently reported status. Status changes are only
+	// reported after the configured thresholds of consecutive checks have been
+	// reached.
+	status string
+	since  time.Time
+
+	checks               uint64
+	consecutiveSuccesses uint
+	consecutiveFailures  uint
+
+	lastCheck time.Time
+	lastError common.MapStr
+}
+
+func newStateTracker() *stateTracker {
+	return &stateTracker{
+		states: map[endpointKey]*endpointState{},
+	}
+}
+
+// update records the check result reported by event. If the update results in
+// the endpoint its state to change, a new state change event is returned.
+func (t *stateTracker) update(event common.MapStr, cfg config.State) common.MapStr {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	id := getString(event, "monitor.id")
+	status := getString(event, "monitor.status")
+	if id == "" || status == "" {
+		return nil
+	}
+
+	ts := time.Now()
+	if v, err := event.GetValue("@timestamp"); err == nil {
+		if tmp, ok := v.(common.Time); ok {
+			ts = time.Time(tmp)
+		}
+	}
+
+	key := endpointKey{id: id, ip: getString(event, "monitor.ip")}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st := t.states[key]
+	if st == nil {
+		st = &endpointState{status: statusUnknown, since: ts}
+		t.states[key] = st
+	}
+
+	st.name = getString(event, "monitor.name")
+	st.typ = getString(event, "monitor.type")
+	st.host = getString(event, "monitor.host")
+	st.checks++
+	st.lastCheck = ts
+
+	var next string
+	if status == statusUp {
+		st.consecutiveSuccesses++
+		st.consecutiveFailures = 0
+		st.lastError = nil
+		if st.status != statusUp && st.consecutiveSuccesses >= cfg.UpThreshold {
+			next = statusUp
+		}
+	} else {
+		st.consecutiveFailures++
+		st.consecutiveSuccesses = 0
+		if v, err := event.GetValue("error"); err == nil {
+			if errField, ok := v.(common.MapStr); ok {
+				st.lastError = errField.Clone()
+			}
+		}
+		if st.status != statusDown && st.consecutiveFailures >= cfg.DownThreshold {
+			next = statusDown
+		}
+	}
+
+	if next == "" {
+		return nil
+	}
+
+	previous, previousSince := st.status, st.since
+	st.status = next
+	st.since = ts
+	return st.changeEvent(key, previous, previousSince)
+}
+
+// remove drops the states of all endpoints checked by the job id.
+func (t *stateTracker) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key := range t.states {
+		if key.id == id {
+			delete(t.states, key)
+		}
+	}
+}
+
+// snapshot reports the current state table, sorted by job ID and IP.
+func (t *stateTracker) snapshot() common.MapStr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := make([]endpointKey, 0, len(t.states))
+	for key := range t.states {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].id != keys[j].id {
+			return keys[i].id < keys[j].id
+		}
+		return keys[i].ip < keys[j].ip
+	})
+
+	monitors := make([]common.MapStr, len(keys))
+	for i, key := range keys {
+		st := t.states[key]
+		entry := st.monitorFields(key)
+		entry.DeepUpdate(common.MapStr{
+			"since":                 look.Timestamp(st.since),
+			"checks":                st.checks,
+			"consecutive_successes": st.consecutiveSuccesses,
+			"consecutive_failures":  st.consecutiveFailures,
+			"last_check":            look.Timestamp(st.lastCheck),
+		})
+		if st.lastError != nil {
+			entry["error"] = st.lastError.Clone()
+		}
+		monitors[i] = entry
+	}
+
+	return common.MapStr{"monitors": monitors}
+}
+
+func (st *endpointState) changeEvent(
+	key endpointKey,
+	previous string,
+	previousSince time.Time,
+) common.MapStr {
+	state := common.MapStr{
+		"status":                st.status,
+		"previous":              previous,
+		"checks":                st.checks,
+		"consecutive_successes": st.consecutiveSuccesses,
+		"consecutive_failures":  st.consecutiveFailures,
+	}
+	if previous != statusUnknown {
+		state["previous_duration"] = look.RTT(st.since.Sub(previousSince))
+	}
+
+	event := common.MapStr{
+		"@timestamp": look.Timestamp(st.since),
+		"type":       stateEventType,
+		"monitor":    st.monitorFields(key),
+		"state":      state,
+	}
+	if st.status == statusDown && st.lastError != nil {
+		event["error"] = st.lastError.Clone()
+	}
+	return event
+}
+
+func (st *endpointState) monitorFields(key endpointKey) common.MapStr {
+	fields := common.MapStr{
+		"id":     key.id,
+		"status": st.status,
+	}
+	for k, v := range map[string]string{
+		"name": st.name,
+		"type": st.typ,
+		"host": st.host,
+		"ip":   key.ip,
+	} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+func getString(event common.MapStr, key string) string {
+	v, err := event.GetValue(key)
+	if err != nil {
+		return ""
+	}
+	s, _ := v.(string)
+	return s
+}
@@ -0,0 +1,166 @@
+package beater
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/publisher"
+
+	"github.com/elastic/beats/heartbeat/config"
+	"github.com/elastic/beats/heartbeat/look"
+	"github.com/elastic/beats/heartbeat/monitors"
+)
+
+func checkEvent(id, ip string, ts time.Time, err error) common.MapStr {
+	event := common.MapStr{
+		"@timestamp": look.Timestamp(ts),
+		"monitor": common.MapStr{
+			"id":     id,
+			"name":   "test",
+			"type":   "tcp",
+			"status": look.Status(err),
+		},
+	}
+	if ip != "" {
+		event.Put("monitor.ip", ip)
+	}
+	if err != nil {
+		event["error"] = look.Reason(err)
+	}
+	return event
+}
+
+func TestStateTrackerTransitions(t *testing.T) {
+	tracker := newStateTracker()
+	cfg := config.DefaultState
+	start := time.Now()
+	fail := errors.New("connection refused")
+
+	change := tracker.update(checkEvent("tcp-1", "", start, nil), cfg)
+	if assert.NotNil(t, change) {
+		assert.Equal(t, stateEventType, change["type"])
+		status, _ := change.GetValue("state.status")
+		previous, _ := change.GetValue("state.previous")
+		assert.Equal(t, statusUp, status)
+		assert.Equal(t, statusUnknown, previous)
+	}
+
+	// no change event if status is unchanged
+	assert.Nil(t, tracker.update(checkEvent("tcp-1", "", start.Add(time.Second), nil), cfg))
+
+	change = tracker.update(checkEvent("tcp-1", "", start.Add(2*time.Second), fail), cfg)
+	if assert.NotNil(t, change) {
+		status, _ := change.GetValue("state.status")
+		duration, _ := change.GetValue("state.previous_duration.us")
+		msg, _ := change.GetValue("error.message")
+		assert.Equal(t, statusDown, status)
+		assert.Equal(t, 2*time.Second/time.Microsecond, duration)
+		assert.Equal(t, fail.Error(), msg)
+	}
+}
+
+func TestStateTrackerFlapSuppression(t *testing.T) {
+	tracker := newStateTracker()
+	cfg := config.DefaultState
+	cfg.DownThreshold = 3
+	now := time.Now()
+	fail := errors.New("timeout")
+
+	assert.NotNil(t, tracker.update(checkEvent("http-1", "", now, nil), cfg))
+
+	// single failures are suppressed
+	assert.Nil(t, tracker.update(checkEvent("http-1", "", now, fail), cfg))
+	assert.Nil(t, tracker.update(checkEvent("http-1", "", now, fail), cfg))
+	assert.Nil(t, tracker.update(checkEvent("http-1", "", now, nil), cfg))
+	assert.Nil(t, tracker.update(checkEvent("http-1", "", now, fail), cfg))
+	assert.Nil(t, tracker.update(checkEvent("http-1", "", now, fail), cfg))
+
+	change := tracker.update(checkEvent("http-1", "", now, fail), cfg)
+	if assert.NotNil(t, change) {
+		status, _ := change.GetValue("state.status")
+		failures, _ := change.GetValue("state.consecutive_failures")
+		assert.Equal(t, statusDown, status)
+		assert.Equal(t, uint(3), failures)
+	}
+}
+
+func TestStateTrackerDisabled(t *testing.T) {
+	tracker := newStateTracker()
+	cfg := config.DefaultState
+	cfg.Enabled = false
+
+	assert.Nil(t, tracker.update(checkEvent("icmp-1", "", time.Now(), nil), cfg))
+	assert.Len(t, tracker.snapshot()["monitors"], 0)
+}
+
+func TestStateTrackerEndpoints(t *testing.T) {
+	tracker := newStateTracker()
+	cfg := config.DefaultState
+	now := time.Now()
+
+	tracker.update(checkEvent("icmp-1", "10.0.0.2", now, nil), cfg)
+	tracker.update(checkEvent("icmp-1", "10.0.0.1", now, errors.New("unreachable")), cfg)
+	tracker.update(checkEvent("icmp-2", "10.0.0.1", now, nil), cfg)
+
+	monitors := tracker.snapshot()["monitors"].([]common.MapStr)
+	if assert.Len(t, monitors, 3) {
+		assert.Equal(t, "10.0.0.1", monitors[0]["ip"])
+		assert.Equal(t, statusDown, monitors[0]["status"])
+		assert.Equal(t, "10.0.0.2", monitors[1]["ip"])
+		assert.Equal(t, statusUp, monitors[1]["status"])
+		assert.Equal(t, "icmp-2", monitors[2]["id"])
+	}
+
+	tracker.remove("icmp-1")
+	assert.Len(t, tracker.snapshot()["monitors"], 1)
+}
+
+// mutatingClient removes the monitor fields of the published events, like a
+// processor of the publisher pipeline would.
+type mutatingClient struct {
+	events []common.MapStr
+}
+
+func (c *mutatingClient) Close() error { return nil }
+
+func (c *mutatingClient) PublishEvent(event common.MapStr, opts ...publisher.ClientOption) bool {
+	c.events = append(c.events, event.Clone())
+	delete(event, "monitor")
+	return true
+}
+
+func (c *mutatingClient) PublishEvents(events []common.MapStr, opts ...publisher.ClientOption) bool {
+	for _, event := range events {
+		c.PublishEvent(event, opts...)
+	}
+	return true
+}
+
+type checkRunner struct {
+	event common.MapStr
+}
+
+func (r checkRunner) Run() (common.MapStr, []monitors.TaskRunner, error) {
+	return r.event, nil, nil
+}
+
+// Verify that the state is updated from the event before it is handed to the
+// publisher pipeline.
+func TestJobTaskUpdatesStateBeforePublish(t *testing.T) {
+	client := &mutatingClient{}
+	tracker := newStateTracker()
+	runner := checkRunner{event: checkEvent("tcp-1", "", time.Now(), nil)}
+
+	task := createJobTask(client, tracker, runner, "test", "tcp", config.DefaultState)
+	task()
+
+	if assert.Len(t, client.events, 2) {
+		assert.Equal(t, stateEventType, client.events[1]["type"])
+		status, _ := client.events[1].GetValue("state.status")
+		assert.Equal(t, statusUp, status)
+	}
+}
@@ -9,6 +9,7 @@ type Config struct {
 	// Modules is a list of module specific configuration data.
 	Monitors  []*common.Config `config:"monitors"         validate:"required"`
 	Scheduler Scheduler        `config:"scheduler"`
+	State     State            `config:"state"`
 }
 
 type Scheduler struct {
@@ -16,4 +17,20 @@ type Scheduler struct {
 	Location string `config:"location"`
 }
 
-var DefaultConfig = Config{}
+// State configures tracking of monitor up/down states. The thresholds can be
+// overwritten per monitor.
+type State struct {
+	Enabled       bool `config:"enabled"`
+	DownThreshold uint `config:"down_threshold" validate:"min=1"`
+	UpThreshold   uint `config:"up_threshold"   validate:"min=1"`
+}
+
+var DefaultConfig = Config{
+	State: DefaultState,
+}
+
+var DefaultState = State{
+	Enabled:       true,
+	DownThreshold: 1,
+	UpThreshold:   1,
+}
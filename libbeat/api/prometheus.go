@@ -0,0 +1,112 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/monitoring"
+)
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricsHandler reports the libbeat/monitoring metrics in the Prometheus text
+// exposition format, so the Beat can be scraped by Prometheus.
+func metricsHandler(info common.BeatInfo) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		writePrometheus(&buf, nil, info)
+
+		w.Header().Set("Content-Type", prometheusContentType)
+		w.Write(buf.Bytes())
+	}
+}
+
+// writePrometheus writes the metrics of the registry, or of the default
+// registry if nil, in the Prometheus text format. The names of the metrics are
+// their path in the registry, with the invalid characters replaced by
+// underscores. Booleans are reported as 0 or 1, and strings are not reported.
+// The Beat information is reported as the labels of the beat_info metric.
+func writePrometheus(w io.Writer, r *monitoring.Registry, info common.BeatInfo) {
+	fmt.Fprintln(w, "# HELP beat_info Information about the Beat.")
+	fmt.Fprintln(w, "# TYPE beat_info gauge")
+	fmt.Fprintf(w, "beat_info{beat=%s,name=%s,version=%s,uuid=%s} 1\n",
+		quoteLabelValue(info.Beat),
+		quoteLabelValue(info.Name),
+		quoteLabelValue(info.Version),
+		quoteLabelValue(info.UUID.String()))
+
+	snapshot := monitoring.CollectFlatSnapshot(r, monitoring.Full, false)
+
+	values := map[string]float64{}
+	for name, v := range snapshot.Ints {
+		values[name] = float64(v)
+	}
+	for name, v := range snapshot.Floats {
+		values[name] = v
+	}
+	for name, v := range snapshot.Bools {
+		if v {
+			values[name] = 1
+		} else {
+			values[name] = 0
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	written := map[string]bool{"beat_info": true}
+	for _, name := range names {
+		metric := metricName(name)
+		if written[metric] {
+			// Two names of the registry map to the same metric name
+			continue
+		}
+		written[metric] = true
+
+		fmt.Fprintf(w, "# TYPE %s untyped\n", metric)
+		fmt.Fprintf(w, "%s %s\n", metric, formatValue(values[name]))
+	}
+}
+
+// metricName converts a dotted name of the registry to a valid metric name.
+func metricName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabelValue(v string) string {
+	return `"` + labelValueReplacer.Replace(v) + `"`
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/monitoring"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	r := monitoring.NewRegistry()
+	output := r.NewRegistry("libbeat.output")
+	monitoring.NewInt(output, "events.acked").Set(42)
+	monitoring.NewFloat(output, "write.latency").Set(0.25)
+	monitoring.NewString(output, "type").Set("elasticsearch")
+	monitoring.NewFunc(r, "beat", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnRegistryStart()
+		v.OnKey("running")
+		v.OnBool(true)
+		v.OnRegistryFinished()
+	})
+	monitoring.NewInt(r, "2xx-responses").Set(3)
+
+	info := common.BeatInfo{
+		Beat:    "metricbeat",
+		Name:    `host "1"`,
+		Version: "6.0.0",
+		UUID:    uuid.FromStringOrNil("a5d4bd4a-9cbb-4bce-a1b2-1a7bc74f6e7c"),
+	}
+
+	var buf bytes.Buffer
+	writePrometheus(&buf, r, info)
+
+	expected := `# HELP beat_info Information about the Beat.
+# TYPE beat_info gauge
+beat_info{beat="metricbeat",name="host \"1\"",version="6.0.0",uuid="a5d4bd4a-9cbb-4bce-a1b2-1a7bc74f6e7c"} 1
+# TYPE _xx_responses untyped
+_xx_responses 3
+# TYPE beat_running untyped
+beat_running 1
+# TYPE libbeat_output_events_acked untyped
+libbeat_output_events_acked 42
+# TYPE libbeat_output_write_latency untyped
+libbeat_output_write_latency 0.25
+`
+	assert.Equal(t, expected, buf.String())
+}
@@ -5,12 +5,37 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
 
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
 	"github.com/elastic/beats/libbeat/monitoring"
 )
 
+// HandlerFunc returns the document to be reported by a custom endpoint.
+type HandlerFunc func() common.MapStr
+
+var (
+	handlersMu sync.Mutex
+	handlers   = map[string]HandlerFunc{}
+)
+
+// RegisterHandler adds a custom JSON endpoint to be served under path by the
+// http endpoint. Handlers must be registered before the endpoint is started.
+func RegisterHandler(path string, h HandlerFunc) error {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+
+	if path == "/" || path == "/stats" || path == "/metrics" {
+		return fmt.Errorf("api path %v is reserved", path)
+	}
+	if _, exists := handlers[path]; exists {
+		return fmt.Errorf("api handler for path %v already registered", path)
+	}
+	handlers[path] = h
+	return nil
+}
+
 // Start starts the metrics api endpoint on the configured host and port
 func Start(cfg *common.Config, info common.BeatInfo) {
 	logp.Beta("Metrics endpoint is enabled.")
@@ -24,6 +49,13 @@ func Start(cfg *common.Config, info common.BeatInfo) {
 		// register handlers
 		mux.HandleFunc("/", rootHandler(info))
 		mux.HandleFunc("/stats", statsHandler)
+		mux.HandleFunc("/metrics", metricsHandler(info))
+
+		handlersMu.Lock()
+		for path, h := range handlers {
+			mux.HandleFunc(path, customHandler(h))
+		}
+		handlersMu.Unlock()
 
 		url := config.Host + ":" + strconv.Itoa(config.Port)
 		logp.Info("URL: %s", url)
@@ -63,6 +95,13 @@ func statsHandler(w http.ResponseWriter, r *http.Request) {
 	print(w, data, r.URL)
 }
 
+func customHandler(h HandlerFunc) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		print(w, h(), r.URL)
+	}
+}
+
 func print(w http.ResponseWriter, data common.MapStr, u *url.URL) {
 	query := u.Query()
 	if _, ok := query["pretty"]; ok {
@@ -0,0 +1,231 @@
+package autodiscover
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/cfgfile"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+)
+
+var (
+	debugf = logp.MakeDebug("autodiscover")
+
+	autodiscoverEvents = monitoring.NewInt(nil, "libbeat.autodiscover.events")
+	runnerStarts       = monitoring.NewInt(nil, "libbeat.autodiscover.runner.starts")
+	runnerStops        = monitoring.NewInt(nil, "libbeat.autodiscover.runner.stops")
+	runnerRunning      = monitoring.NewInt(nil, "libbeat.autodiscover.runner.running")
+)
+
+// Config is the autodiscover configuration of a beat.
+type Config struct {
+	Providers []*common.Config `config:"providers"`
+}
+
+// Autodiscover starts and stops the runners of the configs rendered by the
+// providers, as the discovered targets come and go.
+type Autodiscover struct {
+	name      string
+	factory   cfgfile.RunnerFactory
+	providers []Provider
+	events    chan Event
+
+	runners *cfgfile.Registry
+
+	// IDs of the runners started for each target, by event ID. A runner is
+	// stopped once no target needs it anymore.
+	targets map[string]map[uint64]bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// providerBus prefixes the IDs of the events of a provider, such that the
+// targets of different providers never collide.
+type providerBus struct {
+	prefix string
+	events chan<- Event
+	done   <-chan struct{}
+}
+
+func (b *providerBus) Publish(event Event) {
+	event.ID = b.prefix + event.ID
+	select {
+	case b.events <- event:
+	case <-b.done:
+	}
+}
+
+// NewAutodiscover creates the providers of the config. The runners are
+// created by factory.
+func NewAutodiscover(name string, factory cfgfile.RunnerFactory, config *Config) (*Autodiscover, error) {
+	a := &Autodiscover{
+		name:    name,
+		factory: factory,
+		events:  make(chan Event),
+		runners: cfgfile.NewRegistry(),
+		targets: map[string]map[uint64]bool{},
+		done:    make(chan struct{}),
+	}
+
+	for i, cfg := range config.Providers {
+		if !cfg.Enabled() {
+			continue
+		}
+		bus := &providerBus{
+			prefix: fmt.Sprintf("%d:", i),
+			events: a.events,
+			done:   a.done,
+		}
+		provider, err := Registry.BuildProvider(bus, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("error creating autodiscover provider: %v", err)
+		}
+		a.providers = append(a.providers, provider)
+	}
+	return a, nil
+}
+
+// Start starts the providers and handles their events.
+func (a *Autodiscover) Start() error {
+	if len(a.providers) == 0 {
+		return nil
+	}
+
+	logp.Info("Starting autodiscover manager for %s", a.name)
+	a.wg.Add(1)
+	go a.worker()
+
+	for i, provider := range a.providers {
+		if err := provider.Start(); err != nil {
+			for _, started := range a.providers[:i] {
+				started.Stop()
+			}
+			close(a.done)
+			a.wg.Wait()
+			a.providers = nil
+			return fmt.Errorf("error starting autodiscover provider %v: %v", provider, err)
+		}
+		logp.Info("Autodiscover provider started: %v", provider)
+	}
+	return nil
+}
+
+func (a *Autodiscover) worker() {
+	defer a.wg.Done()
+
+	// Stop all runners when the manager stops
+	defer func() {
+		a.stopRunners(a.runners.CopyList())
+	}()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case event := <-a.events:
+			autodiscoverEvents.Add(1)
+			a.handle(event)
+		}
+	}
+}
+
+// handle starts the runners of the configs of the event, and stops the
+// runners the target does not need anymore.
+func (a *Autodiscover) handle(event Event) {
+	needed := map[uint64]bool{}
+	startList := map[uint64]cfgfile.Runner{}
+
+	if !event.Stop {
+		for _, c := range event.Configs {
+			if !c.Enabled() {
+				continue
+			}
+
+			runner, err := a.factory.Create(c)
+			if err != nil {
+				// In case the runner already is running, do not stop it
+				if runner != nil && a.runners.Has(runner.ID()) {
+					needed[runner.ID()] = true
+				}
+				logp.Err("Autodiscover failed to create runner for %s: %v", event.ID, err)
+				continue
+			}
+
+			needed[runner.ID()] = true
+			if !a.runners.Has(runner.ID()) {
+				startList[runner.ID()] = runner
+			}
+		}
+	}
+
+	debugf("Event %s (stop=%v) needs %v runners", event.ID, event.Stop, len(needed))
+
+	previous := a.targets[event.ID]
+	if len(needed) > 0 {
+		a.targets[event.ID] = needed
+	} else {
+		delete(a.targets, event.ID)
+	}
+
+	stopList := map[uint64]cfgfile.Runner{}
+	running := a.runners.CopyList()
+	for id := range previous {
+		if !needed[id] && !a.isNeeded(id) {
+			if runner, found := running[id]; found {
+				stopList[id] = runner
+			}
+		}
+	}
+
+	a.stopRunners(stopList)
+	a.startRunners(startList)
+}
+
+// isNeeded checks if a runner is still used by a target.
+func (a *Autodiscover) isNeeded(id uint64) bool {
+	for _, runners := range a.targets {
+		if runners[id] {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Autodiscover) startRunners(list map[uint64]cfgfile.Runner) {
+	for id, runner := range list {
+		runner.Start()
+		a.runners.Add(id, runner)
+
+		runnerStarts.Add(1)
+		runnerRunning.Add(1)
+		debugf("Autodiscover runner started: %v", id)
+	}
+}
+
+func (a *Autodiscover) stopRunners(list map[uint64]cfgfile.Runner) {
+	for id, runner := range list {
+		runner.Stop()
+		a.runners.Remove(id)
+
+		runnerStops.Add(1)
+		runnerRunning.Add(-1)
+		debugf("Autodiscover runner stopped: %v", id)
+	}
+}
+
+// Stop stops the providers and all the runners.
+func (a *Autodiscover) Stop() {
+	if len(a.providers) == 0 {
+		return
+	}
+
+	for _, provider := range a.providers {
+		provider.Stop()
+	}
+	close(a.done)
+	a.wg.Wait()
+	logp.Info("Stopped autodiscover manager for %s", a.name)
+}
@@ -0,0 +1,234 @@
+package autodiscover
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/cfgfile"
+	"github.com/elastic/beats/libbeat/common"
+)
+
+type mockRunner struct {
+	mutex   sync.Mutex
+	id      uint64
+	started bool
+	stopped bool
+}
+
+func (m *mockRunner) Start() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.started = true
+}
+
+func (m *mockRunner) Stop() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.stopped = true
+}
+
+func (m *mockRunner) ID() uint64 {
+	return m.id
+}
+
+func (m *mockRunner) state() (started, stopped bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.started, m.stopped
+}
+
+// mockFactory creates runners identified by the `id` setting of their config.
+type mockFactory struct {
+	mutex   sync.Mutex
+	runners []*mockRunner
+}
+
+func (f *mockFactory) Create(c *common.Config) (cfgfile.Runner, error) {
+	var config struct {
+		ID uint64 `config:"id"`
+	}
+	if err := c.Unpack(&config); err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	runner := &mockRunner{id: config.ID}
+	f.runners = append(f.runners, runner)
+	return runner, nil
+}
+
+func (f *mockFactory) created() []*mockRunner {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]*mockRunner{}, f.runners...)
+}
+
+type mockProvider struct {
+	bus     Bus
+	started bool
+	stopped bool
+}
+
+var mockProviders = make(chan *mockProvider, 1)
+
+func init() {
+	Registry.AddProvider("mock", func(bus Bus, c *common.Config) (Provider, error) {
+		p := &mockProvider{bus: bus}
+		mockProviders <- p
+		return p, nil
+	})
+}
+
+func (p *mockProvider) Start() error {
+	p.started = true
+	return nil
+}
+
+func (p *mockProvider) Stop() {
+	p.stopped = true
+}
+
+func (p *mockProvider) String() string {
+	return "mock"
+}
+
+func configs(t *testing.T, ids ...uint64) []*common.Config {
+	var configs []*common.Config
+	for _, id := range ids {
+		c, err := common.NewConfigFrom(map[string]interface{}{"id": id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		configs = append(configs, c)
+	}
+	return configs
+}
+
+func newTestAutodiscover(t *testing.T, factory cfgfile.RunnerFactory) *Autodiscover {
+	a, err := NewAutodiscover("test", factory, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAutodiscoverStartStop(t *testing.T) {
+	factory := &mockFactory{}
+	a := newTestAutodiscover(t, factory)
+
+	a.handle(Event{ID: "1", Configs: configs(t, 1, 2)})
+	runners := factory.created()
+	if assert.Len(t, runners, 2) {
+		for _, r := range runners {
+			started, stopped := r.state()
+			assert.True(t, started)
+			assert.False(t, stopped)
+		}
+	}
+	assert.Len(t, a.runners.CopyList(), 2)
+
+	a.handle(Event{ID: "1", Stop: true})
+	for _, r := range runners {
+		_, stopped := r.state()
+		assert.True(t, stopped)
+	}
+	assert.Len(t, a.runners.CopyList(), 0)
+	assert.Len(t, a.targets, 0)
+}
+
+func TestAutodiscoverSharedRunner(t *testing.T) {
+	factory := &mockFactory{}
+	a := newTestAutodiscover(t, factory)
+
+	a.handle(Event{ID: "1", Configs: configs(t, 1)})
+	a.handle(Event{ID: "2", Configs: configs(t, 1)})
+
+	// The second target reuses the running runner
+	runners := factory.created()
+	if !assert.Len(t, runners, 2) {
+		return
+	}
+	started, _ := runners[1].state()
+	assert.False(t, started)
+	assert.Len(t, a.runners.CopyList(), 1)
+
+	// The runner is still needed by the second target
+	a.handle(Event{ID: "1", Stop: true})
+	_, stopped := runners[0].state()
+	assert.False(t, stopped)
+	assert.Len(t, a.runners.CopyList(), 1)
+
+	a.handle(Event{ID: "2", Stop: true})
+	_, stopped = runners[0].state()
+	assert.True(t, stopped)
+	assert.Len(t, a.runners.CopyList(), 0)
+}
+
+func TestAutodiscoverUpdate(t *testing.T) {
+	factory := &mockFactory{}
+	a := newTestAutodiscover(t, factory)
+
+	a.handle(Event{ID: "1", Configs: configs(t, 1, 2)})
+	a.handle(Event{ID: "1", Configs: configs(t, 2, 3)})
+
+	running := a.runners.CopyList()
+	assert.Len(t, running, 2)
+	assert.Contains(t, running, uint64(2))
+	assert.Contains(t, running, uint64(3))
+
+	runners := factory.created()
+	_, stopped := runners[0].state()
+	assert.True(t, stopped, "runner 1 must be stopped")
+	_, stopped = runners[1].state()
+	assert.False(t, stopped, "runner 2 must keep running")
+}
+
+func TestAutodiscoverProviders(t *testing.T) {
+	provider, err := common.NewConfigFrom(map[string]interface{}{"type": "mock"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	factory := &mockFactory{}
+	a, err := NewAutodiscover("test", factory, &Config{
+		Providers: []*common.Config{provider},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := <-mockProviders
+
+	if err := a.Start(); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, p.started)
+
+	// Publish blocks until the worker receives the event, publish a second
+	// event to wait for the first one to be handled.
+	p.bus.Publish(Event{ID: "1", Configs: configs(t, 1)})
+	p.bus.Publish(Event{ID: "2"})
+	assert.Len(t, a.runners.CopyList(), 1)
+
+	a.Stop()
+	assert.True(t, p.stopped)
+	runners := factory.created()
+	if assert.Len(t, runners, 1) {
+		_, stopped := runners[0].state()
+		assert.True(t, stopped)
+	}
+}
+
+func TestAutodiscoverUnknownProvider(t *testing.T) {
+	provider, err := common.NewConfigFrom(map[string]interface{}{"type": "unknown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewAutodiscover("test", &mockFactory{}, &Config{
+		Providers: []*common.Config{provider},
+	})
+	assert.Error(t, err)
+}
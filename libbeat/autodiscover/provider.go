@@ -0,0 +1,87 @@
+package autodiscover
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// Event signals the start or the stop of a target discovered by a provider,
+// like a container.
+type Event struct {
+	// ID identifies the target, it is unique within the provider.
+	ID string
+
+	// Stop is set when the target is gone. The runners started for the target
+	// are stopped.
+	Stop bool
+
+	// Configs are the configs of the runners to start for the target, as
+	// rendered by the templates of the provider.
+	Configs []*common.Config
+}
+
+// Bus receives the events published by a provider.
+type Bus interface {
+	Publish(event Event)
+}
+
+// Provider discovers the targets and publishes their events on its bus.
+type Provider interface {
+	Start() error
+	Stop()
+	String() string
+}
+
+// ProviderBuilder creates a provider from its config.
+type ProviderBuilder func(bus Bus, config *common.Config) (Provider, error)
+
+type providerRegistry struct {
+	sync.RWMutex
+	builders map[string]ProviderBuilder
+}
+
+// Registry holds the available autodiscover providers.
+var Registry = &providerRegistry{
+	builders: map[string]ProviderBuilder{},
+}
+
+// AddProvider registers a provider builder by name.
+func (r *providerRegistry) AddProvider(name string, builder ProviderBuilder) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if name == "" {
+		return fmt.Errorf("provider name is required")
+	}
+	if _, exists := r.builders[name]; exists {
+		return fmt.Errorf("provider '%s' is already registered", name)
+	}
+	if builder == nil {
+		return fmt.Errorf("provider '%s' cannot be registered with a nil builder", name)
+	}
+
+	r.builders[name] = builder
+	logp.Debug("autodiscover", "Provider registered: %s", name)
+	return nil
+}
+
+// BuildProvider creates the provider named by the `type` setting of config.
+func (r *providerRegistry) BuildProvider(bus Bus, config *common.Config) (Provider, error) {
+	var settings struct {
+		Type string `config:"type" validate:"required"`
+	}
+	if err := config.Unpack(&settings); err != nil {
+		return nil, err
+	}
+
+	r.RLock()
+	builder, found := r.builders[settings.Type]
+	r.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("unknown autodiscover provider '%s'", settings.Type)
+	}
+	return builder(bus, config)
+}
@@ -0,0 +1,215 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Container holds the details of a container used by the templates.
+type Container struct {
+	ID        string
+	Name      string
+	Image     string
+	Labels    map[string]string
+	IPAddress string
+	Ports     []int
+}
+
+// engineEvent is a container event of the Docker engine.
+type engineEvent struct {
+	Action string `json:"Action"`
+	Actor  struct {
+		ID string `json:"ID"`
+	} `json:"Actor"`
+}
+
+// engine is the subset of the Docker engine API used by the provider.
+type engine interface {
+	// ListContainers returns the IDs of the running containers.
+	ListContainers() ([]string, error)
+	InspectContainer(id string) (*Container, error)
+
+	// Events streams the start and die events of the containers, until
+	// the context is done or an error occurs.
+	Events(ctx context.Context) (<-chan engineEvent, <-chan error)
+}
+
+// client is a minimal client of the Docker engine API, over a unix socket
+// or TCP.
+type client struct {
+	http *http.Client
+	base string
+}
+
+const requestTimeout = 10 * time.Second
+
+func newClient(host string) (*client, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host '%s': %v", host, err)
+	}
+
+	transport := &http.Transport{}
+	c := &client{http: &http.Client{Transport: transport}}
+	switch u.Scheme {
+	case "unix":
+		path := u.Path
+		transport.Dial = func(_, _ string) (net.Conn, error) {
+			return net.DialTimeout("unix", path, requestTimeout)
+		}
+		c.base = "http://docker"
+	case "tcp", "http":
+		c.base = "http://" + u.Host
+	default:
+		return nil, fmt.Errorf("unsupported docker host scheme '%s'", u.Scheme)
+	}
+	return c, nil
+}
+
+func (c *client) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("docker API %s returned %s", path, resp.Status)
+	}
+	return resp, nil
+}
+
+func (c *client) getJSON(path string, to interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	resp, err := c.get(ctx, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(to)
+}
+
+func (c *client) ListContainers() ([]string, error) {
+	var containers []struct {
+		ID string `json:"Id"`
+	}
+	if err := c.getJSON("/containers/json", &containers); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(containers))
+	for i, container := range containers {
+		ids[i] = container.ID
+	}
+	return ids, nil
+}
+
+func (c *client) InspectContainer(id string) (*Container, error) {
+	var info struct {
+		ID     string `json:"Id"`
+		Name   string `json:"Name"`
+		Config struct {
+			Image        string              `json:"Image"`
+			Labels       map[string]string   `json:"Labels"`
+			ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+		} `json:"Config"`
+		NetworkSettings struct {
+			IPAddress string `json:"IPAddress"`
+			Networks  map[string]struct {
+				IPAddress string `json:"IPAddress"`
+			} `json:"Networks"`
+		} `json:"NetworkSettings"`
+	}
+	if err := c.getJSON("/containers/"+url.PathEscape(id)+"/json", &info); err != nil {
+		return nil, err
+	}
+
+	container := &Container{
+		ID:        info.ID,
+		Name:      strings.TrimPrefix(info.Name, "/"),
+		Image:     info.Config.Image,
+		Labels:    info.Config.Labels,
+		IPAddress: info.NetworkSettings.IPAddress,
+	}
+	if container.IPAddress == "" {
+		// user defined networks, use the first one by name
+		var names []string
+		for name := range info.NetworkSettings.Networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if ip := info.NetworkSettings.Networks[name].IPAddress; ip != "" {
+				container.IPAddress = ip
+				break
+			}
+		}
+	}
+
+	// exposed ports are formatted as port/protocol
+	for exposed := range info.Config.ExposedPorts {
+		port, err := strconv.Atoi(strings.SplitN(exposed, "/", 2)[0])
+		if err == nil {
+			container.Ports = append(container.Ports, port)
+		}
+	}
+	sort.Ints(container.Ports)
+	return container, nil
+}
+
+func (c *client) Events(ctx context.Context) (<-chan engineEvent, <-chan error) {
+	events := make(chan engineEvent)
+	errors := make(chan error, 1)
+
+	go func() {
+		defer close(events)
+
+		filters, _ := json.Marshal(map[string][]string{
+			"type":  {"container"},
+			"event": {"start", "die"},
+		})
+		resp, err := c.get(ctx, "/events", url.Values{"filters": {string(filters)}})
+		if err != nil {
+			errors <- err
+			return
+		}
+		defer resp.Body.Close()
+
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			var event engineEvent
+			if err := decoder.Decode(&event); err != nil {
+				if ctx.Err() == nil {
+					errors <- err
+				}
+				return
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, errors
+}
@@ -0,0 +1,17 @@
+package docker
+
+import (
+	"github.com/elastic/beats/libbeat/autodiscover/template"
+)
+
+// Config is the configuration of the docker autodiscover provider.
+type Config struct {
+	Host      string                  `config:"host"`
+	Templates template.MapperSettings `config:"templates"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Host: "unix:///var/run/docker.sock",
+	}
+}
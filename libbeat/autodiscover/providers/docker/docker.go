@@ -0,0 +1,217 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/autodiscover"
+	"github.com/elastic/beats/libbeat/autodiscover/template"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// delay before subscribing again to the engine events after an error
+const retryPeriod = 5 * time.Second
+
+var debugf = logp.MakeDebug("autodiscover.docker")
+
+func init() {
+	autodiscover.Registry.AddProvider("docker", AutodiscoverBuilder)
+}
+
+// Provider publishes the start and stop of the Docker containers, from the
+// events of the Docker engine.
+type Provider struct {
+	config    *Config
+	bus       autodiscover.Bus
+	engine    engine
+	templates template.Mapper
+
+	// event IDs published for each running container
+	containers map[string][]string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// AutodiscoverBuilder builds and returns an autodiscover provider
+func AutodiscoverBuilder(bus autodiscover.Bus, c *common.Config) (autodiscover.Provider, error) {
+	config := defaultConfig()
+	if err := c.Unpack(config); err != nil {
+		return nil, err
+	}
+
+	client, err := newClient(config.Host)
+	if err != nil {
+		return nil, err
+	}
+	return newProvider(bus, config, client)
+}
+
+func newProvider(bus autodiscover.Bus, config *Config, engine engine) (*Provider, error) {
+	templates, err := template.NewConfigMapper(config.Templates)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Provider{
+		config:     config,
+		bus:        bus,
+		engine:     engine,
+		templates:  templates,
+		containers: map[string][]string{},
+		ctx:        ctx,
+		cancel:     cancel,
+	}, nil
+}
+
+// Start publishes the running containers, and follows the engine events.
+func (d *Provider) Start() error {
+	// subscribe first, so that no container started meanwhile is missed
+	events, errors := d.engine.Events(d.ctx)
+
+	ids, err := d.engine.ListContainers()
+	if err != nil {
+		d.cancel()
+		return fmt.Errorf("error listing docker containers: %v", err)
+	}
+	for _, id := range ids {
+		d.startContainer(id)
+	}
+
+	d.wg.Add(1)
+	go d.watch(events, errors)
+	return nil
+}
+
+func (d *Provider) watch(events <-chan engineEvent, errors <-chan error) {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+
+		case event, ok := <-events:
+			if !ok {
+				// the stream ended, subscribe again after the error is
+				// reported
+				events = nil
+				continue
+			}
+			switch event.Action {
+			case "start":
+				d.startContainer(event.Actor.ID)
+			case "die":
+				d.stopContainer(event.Actor.ID)
+			}
+
+		case err := <-errors:
+			logp.Err("Error watching docker events: %v", err)
+			select {
+			case <-d.ctx.Done():
+				return
+			case <-time.After(retryPeriod):
+			}
+			events, errors = d.engine.Events(d.ctx)
+		}
+	}
+}
+
+func (d *Provider) startContainer(id string) {
+	if _, found := d.containers[id]; found {
+		return
+	}
+
+	container, err := d.engine.InspectContainer(id)
+	if err != nil {
+		logp.Err("Error inspecting docker container %s: %v", id, err)
+		return
+	}
+
+	var eventIDs []string
+	for _, target := range targets(container) {
+		eventIDs = append(eventIDs, target.id)
+
+		debugf("Container %s started: %v", id, target.data)
+		d.bus.Publish(autodiscover.Event{
+			ID:      target.id,
+			Configs: d.templates.GetConfig(target.data),
+		})
+	}
+	d.containers[id] = eventIDs
+}
+
+func (d *Provider) stopContainer(id string) {
+	eventIDs, found := d.containers[id]
+	if !found {
+		return
+	}
+	delete(d.containers, id)
+
+	debugf("Container %s stopped", id)
+	for _, eventID := range eventIDs {
+		d.bus.Publish(autodiscover.Event{ID: eventID, Stop: true})
+	}
+}
+
+// target is an endpoint of a container, and the data used by the templates.
+type target struct {
+	id   string
+	data common.MapStr
+}
+
+// targets returns the endpoints of a container, one per exposed port, or one
+// without port if the container exposes none.
+func targets(container *Container) []target {
+	labels := common.MapStr{}
+	for k, v := range container.Labels {
+		labels[k] = v
+	}
+	meta := common.MapStr{
+		"container": common.MapStr{
+			"id":     container.ID,
+			"name":   container.Name,
+			"image":  container.Image,
+			"labels": labels,
+		},
+	}
+
+	if len(container.Ports) == 0 {
+		return []target{{
+			id: container.ID,
+			data: common.MapStr{
+				"host":   container.IPAddress,
+				"docker": meta,
+			},
+		}}
+	}
+
+	targets := make([]target, len(container.Ports))
+	for i, port := range container.Ports {
+		targets[i] = target{
+			id: container.ID + ":" + strconv.Itoa(port),
+			data: common.MapStr{
+				"host":   container.IPAddress,
+				"port":   port,
+				"docker": meta.Clone(),
+			},
+		}
+	}
+	return targets
+}
+
+// Stop stops following the engine events.
+func (d *Provider) Stop() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+func (d *Provider) String() string {
+	return "docker"
+}
@@ -0,0 +1,204 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/autodiscover"
+	"github.com/elastic/beats/libbeat/common"
+)
+
+type mockBus struct {
+	events chan autodiscover.Event
+}
+
+func (b *mockBus) Publish(event autodiscover.Event) {
+	b.events <- event
+}
+
+func (b *mockBus) next(t *testing.T) autodiscover.Event {
+	select {
+	case event := <-b.events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+	return autodiscover.Event{}
+}
+
+// mockEngine is a fake Docker engine, its events are sent by the tests.
+type mockEngine struct {
+	mutex      sync.Mutex
+	containers map[string]*Container
+	events     chan engineEvent
+}
+
+func (e *mockEngine) ListContainers() ([]string, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var ids []string
+	for id := range e.containers {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (e *mockEngine) InspectContainer(id string) (*Container, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	container, found := e.containers[id]
+	if !found {
+		return nil, errors.New("no such container")
+	}
+	return container, nil
+}
+
+func (e *mockEngine) Events(ctx context.Context) (<-chan engineEvent, <-chan error) {
+	return e.events, make(chan error)
+}
+
+func (e *mockEngine) start(container *Container) {
+	e.mutex.Lock()
+	e.containers[container.ID] = container
+	e.mutex.Unlock()
+
+	event := engineEvent{Action: "start"}
+	event.Actor.ID = container.ID
+	e.events <- event
+}
+
+func (e *mockEngine) die(id string) {
+	event := engineEvent{Action: "die"}
+	event.Actor.ID = id
+	e.events <- event
+}
+
+func newTestProvider(t *testing.T, engine engine, templates string) (*Provider, *mockBus) {
+	config := defaultConfig()
+	c, err := common.NewConfigWithYAML([]byte(templates), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Unpack(config); err != nil {
+		t.Fatal(err)
+	}
+
+	bus := &mockBus{events: make(chan autodiscover.Event, 10)}
+	p, err := newProvider(bus, config, engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, bus
+}
+
+const redisTemplate = `
+templates:
+  - condition.equals:
+      docker.container.image: redis
+    config:
+      - module: redis
+        hosts: ["${data.host}:${data.port}"]
+`
+
+func TestDockerProvider(t *testing.T) {
+	engine := &mockEngine{
+		containers: map[string]*Container{
+			"abc": {
+				ID:        "abc",
+				Name:      "cache",
+				Image:     "redis",
+				IPAddress: "172.17.0.2",
+				Ports:     []int{6379},
+			},
+		},
+		events: make(chan engineEvent),
+	}
+	p, bus := newTestProvider(t, engine, redisTemplate)
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	// Running containers are published on start
+	event := bus.next(t)
+	assert.Equal(t, "abc:6379", event.ID)
+	assert.False(t, event.Stop)
+	if assert.Len(t, event.Configs, 1) {
+		var config struct {
+			Module string   `config:"module"`
+			Hosts  []string `config:"hosts"`
+		}
+		assert.NoError(t, event.Configs[0].Unpack(&config))
+		assert.Equal(t, "redis", config.Module)
+		assert.Equal(t, []string{"172.17.0.2:6379"}, config.Hosts)
+	}
+
+	// Containers not matching any template are published without configs
+	engine.start(&Container{
+		ID:        "def",
+		Name:      "web",
+		Image:     "nginx",
+		IPAddress: "172.17.0.3",
+	})
+	event = bus.next(t)
+	assert.Equal(t, "def", event.ID)
+	assert.Len(t, event.Configs, 0)
+
+	engine.die("abc")
+	event = bus.next(t)
+	assert.Equal(t, "abc:6379", event.ID)
+	assert.True(t, event.Stop)
+
+	// Unknown containers are ignored
+	engine.die("abc")
+	engine.die("def")
+	event = bus.next(t)
+	assert.Equal(t, "def", event.ID)
+	assert.True(t, event.Stop)
+}
+
+func TestTargets(t *testing.T) {
+	container := &Container{
+		ID:        "abc",
+		Name:      "cache",
+		Image:     "redis",
+		Labels:    map[string]string{"app": "cache"},
+		IPAddress: "172.17.0.2",
+		Ports:     []int{6379, 16379},
+	}
+
+	res := targets(container)
+	if !assert.Len(t, res, 2) {
+		return
+	}
+	assert.Equal(t, "abc:6379", res[0].id)
+	assert.Equal(t, "abc:16379", res[1].id)
+	assert.Equal(t, common.MapStr{
+		"host": "172.17.0.2",
+		"port": 6379,
+		"docker": common.MapStr{
+			"container": common.MapStr{
+				"id":     "abc",
+				"name":   "cache",
+				"image":  "redis",
+				"labels": common.MapStr{"app": "cache"},
+			},
+		},
+	}, res[0].data)
+
+	container.Ports = nil
+	res = targets(container)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, "abc", res[0].id)
+		_, err := res[0].data.GetValue("port")
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,35 @@
+package kubernetes
+
+import (
+	"errors"
+	"time"
+
+	"github.com/elastic/beats/libbeat/autodiscover/template"
+)
+
+// Config is the configuration of the kubernetes autodiscover provider.
+type Config struct {
+	InCluster  bool          `config:"in_cluster"`
+	KubeConfig string        `config:"kube_config"`
+	Host       string        `config:"host"`
+	Namespace  string        `config:"namespace"`
+	SyncPeriod time.Duration `config:"sync_period"`
+
+	Templates template.MapperSettings `config:"templates"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		InCluster:  true,
+		SyncPeriod: 1 * time.Second,
+		Namespace:  "kube-system",
+	}
+}
+
+// Validate ensures the kube config is set when not running in cluster.
+func (c *Config) Validate() error {
+	if !c.InCluster && c.KubeConfig == "" {
+		return errors.New("`kube_config` path can't be empty when in_cluster is set to false")
+	}
+	return nil
+}
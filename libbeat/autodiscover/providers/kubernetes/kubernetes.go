@@ -0,0 +1,224 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/autodiscover"
+	"github.com/elastic/beats/libbeat/autodiscover/template"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	kube "github.com/elastic/beats/libbeat/processors/kubernetes"
+)
+
+var debugf = logp.MakeDebug("autodiscover.kubernetes")
+
+func init() {
+	autodiscover.Registry.AddProvider("kubernetes", AutodiscoverBuilder)
+}
+
+// Provider publishes the start and stop of the containers of the pods
+// running on the node, from the pod watch of the Kubernetes API.
+type Provider struct {
+	config    *Config
+	bus       autodiscover.Bus
+	watcher   *kube.PodWatcher
+	templates template.Mapper
+
+	mutex sync.Mutex
+	// event IDs published for each pod, by pod UID
+	pods map[string][]string
+}
+
+// AutodiscoverBuilder builds and returns an autodiscover provider
+func AutodiscoverBuilder(bus autodiscover.Bus, c *common.Config) (autodiscover.Provider, error) {
+	config := defaultConfig()
+	if err := c.Unpack(config); err != nil {
+		return nil, err
+	}
+
+	p, err := newProvider(bus, config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kube.GetKubernetesClient(config.InCluster, config.KubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	host := kube.DiscoverKubernetesNode(config.Host, config.Namespace, client)
+
+	debugf("Initializing pod watcher on node %s", host)
+	p.watcher = kube.NewPodWatcher(client, &kube.Indexers{}, config.SyncPeriod, host)
+	p.watcher.AddEventHandler(p)
+	return p, nil
+}
+
+func newProvider(bus autodiscover.Bus, config *Config) (*Provider, error) {
+	templates, err := template.NewConfigMapper(config.Templates)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		config:    config,
+		bus:       bus,
+		templates: templates,
+		pods:      map[string][]string{},
+	}, nil
+}
+
+// Start syncs the pods of the node, and watches the pod changes.
+func (p *Provider) Start() error {
+	if !p.watcher.Run() {
+		return fmt.Errorf("timeout syncing the kubernetes pods")
+	}
+	return nil
+}
+
+// Stop stops the pod watch.
+func (p *Provider) Stop() {
+	p.watcher.Stop()
+}
+
+func (p *Provider) String() string {
+	return "kubernetes"
+}
+
+// OnAdd publishes the containers of a new pod.
+func (p *Provider) OnAdd(pod *kube.Pod) {
+	p.publish(pod)
+}
+
+// OnUpdate publishes the containers of an updated pod, and the stop of the
+// containers gone. The pods are only reachable once they have an IP address.
+func (p *Provider) OnUpdate(pod *kube.Pod) {
+	p.publish(pod)
+}
+
+// OnDelete publishes the stop of the containers of a pod.
+func (p *Provider) OnDelete(pod *kube.Pod) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, id := range p.pods[pod.Metadata.UID] {
+		p.bus.Publish(autodiscover.Event{ID: id, Stop: true})
+	}
+	delete(p.pods, pod.Metadata.UID)
+}
+
+func (p *Provider) publish(pod *kube.Pod) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	current := map[string]bool{}
+	var ids []string
+	for _, target := range targets(pod) {
+		current[target.id] = true
+		ids = append(ids, target.id)
+
+		debugf("Pod %s container started: %v", pod.Metadata.Name, target.data)
+		p.bus.Publish(autodiscover.Event{
+			ID:      target.id,
+			Configs: p.templates.GetConfig(target.data),
+		})
+	}
+
+	for _, id := range p.pods[pod.Metadata.UID] {
+		if !current[id] {
+			p.bus.Publish(autodiscover.Event{ID: id, Stop: true})
+		}
+	}
+
+	if len(ids) > 0 {
+		p.pods[pod.Metadata.UID] = ids
+	} else {
+		delete(p.pods, pod.Metadata.UID)
+	}
+}
+
+// target is an endpoint of a container, and the data used by the templates.
+type target struct {
+	id   string
+	data common.MapStr
+}
+
+// targets returns the endpoints of the containers of a pod, one per container
+// port, or one without port if the container has none.
+func targets(pod *kube.Pod) []target {
+	if pod.Status.PodIP == "" {
+		return nil
+	}
+
+	containerIDs := map[string]string{}
+	for _, status := range pod.Status.ContainerStatuses {
+		// the container ID is prefixed by the runtime, like docker://
+		id := status.ContainerID
+		if sep := strings.Index(id, "://"); sep >= 0 {
+			id = id[sep+3:]
+		}
+		containerIDs[status.Name] = id
+	}
+
+	var targets []target
+	for _, container := range pod.Spec.Containers {
+		meta := func() common.MapStr {
+			meta := common.MapStr{
+				"pod": common.MapStr{
+					"name": pod.Metadata.Name,
+					"uid":  pod.Metadata.UID,
+				},
+				"namespace": pod.Metadata.Namespace,
+				"node": common.MapStr{
+					"name": pod.Spec.NodeName,
+				},
+				"container": common.MapStr{
+					"name":  container.Name,
+					"image": container.Image,
+					"id":    containerIDs[container.Name],
+				},
+			}
+			if len(pod.Metadata.Labels) > 0 {
+				labels := common.MapStr{}
+				for k, v := range pod.Metadata.Labels {
+					labels[k] = v
+				}
+				meta["labels"] = labels
+			}
+			if len(pod.Metadata.Annotations) > 0 {
+				annotations := common.MapStr{}
+				for k, v := range pod.Metadata.Annotations {
+					annotations[k] = v
+				}
+				meta["annotations"] = annotations
+			}
+			return meta
+		}
+
+		id := pod.Metadata.UID + "." + container.Name
+		if len(container.Ports) == 0 {
+			targets = append(targets, target{
+				id: id,
+				data: common.MapStr{
+					"host":       pod.Status.PodIP,
+					"kubernetes": meta(),
+				},
+			})
+			continue
+		}
+
+		for _, port := range container.Ports {
+			targets = append(targets, target{
+				id: id + ":" + strconv.FormatInt(port.ContainerPort, 10),
+				data: common.MapStr{
+					"host":       pod.Status.PodIP,
+					"port":       port.ContainerPort,
+					"kubernetes": meta(),
+				},
+			})
+		}
+	}
+	return targets
+}
@@ -0,0 +1,156 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/autodiscover"
+	"github.com/elastic/beats/libbeat/common"
+	kube "github.com/elastic/beats/libbeat/processors/kubernetes"
+)
+
+type mockBus struct {
+	events []autodiscover.Event
+}
+
+func (b *mockBus) Publish(event autodiscover.Event) {
+	b.events = append(b.events, event)
+}
+
+func (b *mockBus) pop() []autodiscover.Event {
+	events := b.events
+	b.events = nil
+	return events
+}
+
+func newTestProvider(t *testing.T) (*Provider, *mockBus) {
+	config := defaultConfig()
+	c, err := common.NewConfigWithYAML([]byte(`
+templates:
+  - condition.equals:
+      kubernetes.container.image: redis
+    config:
+      - module: redis
+        hosts: ["${data.host}:${data.port}"]
+`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Unpack(config); err != nil {
+		t.Fatal(err)
+	}
+
+	bus := &mockBus{}
+	p, err := newProvider(bus, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, bus
+}
+
+func newPod(ip string, containers ...kube.Container) *kube.Pod {
+	pod := &kube.Pod{}
+	pod.Metadata.Name = "cache"
+	pod.Metadata.UID = "uid"
+	pod.Metadata.Namespace = "default"
+	pod.Metadata.Labels = map[string]string{"app": "cache"}
+	pod.Spec.NodeName = "node"
+	pod.Spec.Containers = containers
+	pod.Status.PodIP = ip
+	for _, c := range containers {
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, kube.PodContainerStatus{
+			Name:        c.Name,
+			ContainerID: "docker://" + c.Name + "-id",
+		})
+	}
+	return pod
+}
+
+func TestKubernetesProvider(t *testing.T) {
+	p, bus := newTestProvider(t)
+
+	redis := kube.Container{
+		Name:  "redis",
+		Image: "redis",
+		Ports: []kube.ContainerPort{{ContainerPort: 6379}},
+	}
+	sidecar := kube.Container{
+		Name:  "sidecar",
+		Image: "busybox",
+	}
+
+	// Pods without IP are not reachable yet
+	p.OnAdd(newPod("", redis, sidecar))
+	assert.Len(t, bus.pop(), 0)
+
+	p.OnUpdate(newPod("10.0.0.2", redis, sidecar))
+	events := bus.pop()
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "uid.redis:6379", events[0].ID)
+		assert.False(t, events[0].Stop)
+		if assert.Len(t, events[0].Configs, 1) {
+			var config struct {
+				Hosts []string `config:"hosts"`
+			}
+			assert.NoError(t, events[0].Configs[0].Unpack(&config))
+			assert.Equal(t, []string{"10.0.0.2:6379"}, config.Hosts)
+		}
+
+		assert.Equal(t, "uid.sidecar", events[1].ID)
+		assert.Len(t, events[1].Configs, 0)
+	}
+
+	// The containers removed from the pod are stopped
+	p.OnUpdate(newPod("10.0.0.2", redis))
+	events = bus.pop()
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "uid.redis:6379", events[0].ID)
+		assert.False(t, events[0].Stop)
+		assert.Equal(t, "uid.sidecar", events[1].ID)
+		assert.True(t, events[1].Stop)
+	}
+
+	p.OnDelete(newPod("10.0.0.2", redis))
+	events = bus.pop()
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "uid.redis:6379", events[0].ID)
+		assert.True(t, events[0].Stop)
+	}
+	assert.Len(t, p.pods, 0)
+}
+
+func TestTargets(t *testing.T) {
+	pod := newPod("10.0.0.2", kube.Container{
+		Name:  "redis",
+		Image: "redis",
+		Ports: []kube.ContainerPort{{ContainerPort: 6379}},
+	})
+
+	res := targets(pod)
+	if !assert.Len(t, res, 1) {
+		return
+	}
+	assert.Equal(t, common.MapStr{
+		"host": "10.0.0.2",
+		"port": int64(6379),
+		"kubernetes": common.MapStr{
+			"pod": common.MapStr{
+				"name": "cache",
+				"uid":  "uid",
+			},
+			"namespace": "default",
+			"node": common.MapStr{
+				"name": "node",
+			},
+			"container": common.MapStr{
+				"name":  "redis",
+				"image": "redis",
+				"id":    "redis-id",
+			},
+			"labels": common.MapStr{
+				"app": "cache",
+			},
+		},
+	}, res[0].data)
+}
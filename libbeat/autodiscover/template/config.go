@@ -0,0 +1,97 @@
+package template
+
+import (
+	"github.com/elastic/go-ucfg"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/processors"
+)
+
+// MapperSettings are the templates of an autodiscover provider. The configs
+// of the first template whose condition matches the event are used.
+type MapperSettings []*struct {
+	Condition *processors.ConditionConfig `config:"condition"`
+	Configs   []*common.Config            `config:"config"`
+}
+
+// Mapper renders the configs of the discovered targets from their events.
+type Mapper []*conditionMap
+
+type conditionMap struct {
+	condition *processors.Condition
+	configs   []*common.Config
+}
+
+// NewConfigMapper compiles the conditions of the templates.
+func NewConfigMapper(settings MapperSettings) (Mapper, error) {
+	var mapper Mapper
+	for _, s := range settings {
+		condition, err := processors.NewCondition(s.Condition)
+		if err != nil {
+			return nil, err
+		}
+		mapper = append(mapper, &conditionMap{
+			condition: condition,
+			configs:   s.Configs,
+		})
+	}
+	return mapper, nil
+}
+
+// GetConfig returns the configs of the first template matching the event.
+// The variables of the configs, like `${data.host}`, are expanded from the
+// fields of the event.
+func (m Mapper) GetConfig(event common.MapStr) []*common.Config {
+	for _, mapping := range m {
+		if mapping.condition != nil && !mapping.condition.Check(event) {
+			continue
+		}
+
+		configs, err := ApplyConfigTemplate(event, mapping.configs)
+		if err != nil {
+			logp.Err("Autodiscover failed to render config template: %v", err)
+			return nil
+		}
+		return configs
+	}
+	return nil
+}
+
+// ApplyConfigTemplate expands the `${data.*}` variables of the configs from
+// the fields of the event.
+func ApplyConfigTemplate(event common.MapStr, configs []*common.Config) ([]*common.Config, error) {
+	vars, err := ucfg.NewFrom(map[string]interface{}{
+		"data": map[string]interface{}(event),
+	}, ucfg.PathSep("."))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []ucfg.Option{
+		ucfg.PathSep("."),
+		ucfg.Env(vars),
+		ucfg.ResolveEnv,
+		ucfg.VarExp,
+	}
+
+	var result []*common.Config
+	for _, config := range configs {
+		c, err := ucfg.NewFrom((*ucfg.Config)(config), opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		var unpacked map[string]interface{}
+		if err := c.Unpack(&unpacked, opts...); err != nil {
+			return nil, err
+		}
+
+		rendered, err := common.NewConfigFrom(unpacked)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, rendered)
+	}
+	return result, nil
+}
@@ -0,0 +1,143 @@
+package template
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestConfigsMapping(t *testing.T) {
+	rendered := map[string]interface{}{
+		"hosts": []interface{}{"1.2.3.4:8080"},
+	}
+
+	tests := []struct {
+		mapping  string
+		event    common.MapStr
+		expected []map[string]interface{}
+	}{
+		// No match
+		{
+			mapping: `
+- condition.equals:
+    foo: 3
+  config:
+  - type: config1`,
+			event: common.MapStr{
+				"foo": "no match",
+			},
+			expected: nil,
+		},
+		// Match config
+		{
+			mapping: `
+- condition.equals:
+    foo: 3
+  config:
+  - hosts: ["${data.host}:${data.port}"]`,
+			event: common.MapStr{
+				"foo":  3,
+				"host": "1.2.3.4",
+				"port": 8080,
+			},
+			expected: []map[string]interface{}{rendered},
+		},
+		// First match wins
+		{
+			mapping: `
+- condition.equals:
+    foo: 3
+  config:
+  - hosts: ["${data.host}:${data.port}"]
+- config:
+  - type: config2`,
+			event: common.MapStr{
+				"foo":  3,
+				"host": "1.2.3.4",
+				"port": 8080,
+			},
+			expected: []map[string]interface{}{rendered},
+		},
+	}
+
+	for _, test := range tests {
+		var settings MapperSettings
+		c, err := common.NewConfigWithYAML([]byte(test.mapping), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Unpack(&settings); err != nil {
+			t.Fatal(err)
+		}
+
+		mapper, err := NewConfigMapper(settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := mapper.GetConfig(test.event)
+		if !assert.Len(t, res, len(test.expected)) {
+			continue
+		}
+		for i, config := range res {
+			var got map[string]interface{}
+			assert.NoError(t, config.Unpack(&got))
+			assert.Equal(t, test.expected[i], got)
+		}
+	}
+}
+
+func TestApplyConfigTemplateNested(t *testing.T) {
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"module": "redis",
+		"hosts":  []string{"${data.host}:${data.port}"},
+		"tags":   []string{"${data.docker.container.name}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := common.MapStr{
+		"host": "10.0.0.2",
+		"port": 6379,
+		"docker": common.MapStr{
+			"container": common.MapStr{
+				"name": "redis",
+			},
+		},
+	}
+
+	configs, err := ApplyConfigTemplate(event, []*common.Config{config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Len(t, configs, 1) {
+		return
+	}
+
+	var rendered struct {
+		Module string   `config:"module"`
+		Hosts  []string `config:"hosts"`
+		Tags   []string `config:"tags"`
+	}
+	if err := configs[0].Unpack(&rendered); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "redis", rendered.Module)
+	assert.Equal(t, []string{"10.0.0.2:6379"}, rendered.Hosts)
+	assert.Equal(t, []string{"redis"}, rendered.Tags)
+}
+
+func TestApplyConfigTemplateMissingVariable(t *testing.T) {
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"hosts": []string{"${data.host}:${data.port}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ApplyConfigTemplate(common.MapStr{"host": "10.0.0.2"}, []*common.Config{config})
+	assert.Error(t, err)
+}
@@ -0,0 +1,144 @@
+// Package flowhash computes the Community ID of network flows, used to
+// correlate the flows reported by different monitoring tools.
+//
+// See https://github.com/corelight/community-id-spec for the specification.
+package flowhash
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+)
+
+// IP protocol numbers
+const (
+	ICMP   = 1
+	TCP    = 6
+	UDP    = 17
+	ICMPv6 = 58
+	SCTP   = 132
+)
+
+// Flow identifies a flow. For ICMP flows, the type and code are used
+// instead of the ports.
+type Flow struct {
+	SourceIP, DestinationIP     net.IP
+	SourcePort, DestinationPort uint16
+	Protocol                    uint8
+	ICMPType, ICMPCode          uint8
+}
+
+// CommunityID computes Community IDs with a given seed.
+type CommunityID struct {
+	seed uint16
+}
+
+// DefaultCommunityID uses the default seed 0, also used by default by Zeek
+// and Suricata.
+var DefaultCommunityID = NewCommunityID(0)
+
+// NewCommunityID creates a Community ID hasher. The seed must be the same
+// in all the tools reporting flows to be correlated.
+func NewCommunityID(seed uint16) CommunityID {
+	return CommunityID{seed: seed}
+}
+
+// Hash returns the version 1 Community ID of the flow, or an empty string
+// if the addresses are invalid.
+func (c CommunityID) Hash(flow Flow) string {
+	src, dst := normalizeIP(flow.SourceIP), normalizeIP(flow.DestinationIP)
+	if src == nil || dst == nil || len(src) != len(dst) {
+		return ""
+	}
+
+	srcPort, dstPort := flow.SourcePort, flow.DestinationPort
+	oneWay := false
+	switch flow.Protocol {
+	case ICMP:
+		srcPort, dstPort, oneWay = icmpPorts(flow.ICMPType, flow.ICMPCode, icmpv4Pairs)
+	case ICMPv6:
+		srcPort, dstPort, oneWay = icmpPorts(flow.ICMPType, flow.ICMPCode, icmpv6Pairs)
+	}
+
+	// the endpoints are ordered, so that both directions have the same ID
+	if !oneWay && isGreater(src, srcPort, dst, dstPort) {
+		src, dst = dst, src
+		srcPort, dstPort = dstPort, srcPort
+	}
+
+	h := sha1.New()
+	binary.Write(h, binary.BigEndian, c.seed)
+	h.Write(src)
+	h.Write(dst)
+	h.Write([]byte{flow.Protocol, 0})
+	if hasPorts(flow.Protocol) {
+		binary.Write(h, binary.BigEndian, srcPort)
+		binary.Write(h, binary.BigEndian, dstPort)
+	}
+	return "1:" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	if len(ip) == net.IPv6len {
+		return ip
+	}
+	return nil
+}
+
+func isGreater(src net.IP, srcPort uint16, dst net.IP, dstPort uint16) bool {
+	switch bytes.Compare(src, dst) {
+	case 1:
+		return true
+	case 0:
+		return srcPort > dstPort
+	}
+	return false
+}
+
+func hasPorts(protocol uint8) bool {
+	switch protocol {
+	case ICMP, TCP, UDP, ICMPv6, SCTP:
+		return true
+	}
+	return false
+}
+
+// The ICMP messages having a counterpart are mapped to a pair of
+// request/response types, like ports. Other messages are one-way.
+var icmpv4Pairs = map[uint8]uint8{
+	8:  0,  // echo
+	0:  8,  // echo reply
+	13: 14, // timestamp
+	14: 13, // timestamp reply
+	15: 16, // info
+	16: 15, // info reply
+	10: 9,  // router solicitation
+	9:  10, // router advertisement
+	17: 18, // address mask
+	18: 17, // address mask reply
+}
+
+var icmpv6Pairs = map[uint8]uint8{
+	128: 129, // echo
+	129: 128, // echo reply
+	133: 134, // router solicitation
+	134: 133, // router advertisement
+	135: 136, // neighbor solicitation
+	136: 135, // neighbor advertisement
+	130: 131, // multicast listener query
+	131: 130, // multicast listener report
+	144: 145, // home agent address discovery
+	145: 144, // home agent address discovery reply
+}
+
+func icmpPorts(typ, code uint8, pairs map[uint8]uint8) (uint16, uint16, bool) {
+	if counterpart, found := pairs[typ]; found {
+		return uint16(typ), uint16(counterpart), false
+	}
+	return uint16(typ), uint16(code), true
+}
@@ -0,0 +1,90 @@
+// +build !integration
+
+package flowhash
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommunityID(t *testing.T) {
+	tests := []struct {
+		name     string
+		flow     Flow
+		expected string
+	}{
+		{
+			"tcp",
+			Flow{
+				SourceIP: net.ParseIP("128.232.110.120"), DestinationIP: net.ParseIP("66.35.250.204"),
+				SourcePort: 34855, DestinationPort: 80, Protocol: TCP,
+			},
+			"1:LQU9qZlK+B5F3KDmev6m5PMibrg=",
+		},
+		{
+			"tcp reversed",
+			Flow{
+				SourceIP: net.ParseIP("66.35.250.204"), DestinationIP: net.ParseIP("128.232.110.120"),
+				SourcePort: 80, DestinationPort: 34855, Protocol: TCP,
+			},
+			"1:LQU9qZlK+B5F3KDmev6m5PMibrg=",
+		},
+		{
+			"udp",
+			Flow{
+				SourceIP: net.ParseIP("192.168.1.52"), DestinationIP: net.ParseIP("8.8.8.8"),
+				SourcePort: 54585, DestinationPort: 53, Protocol: UDP,
+			},
+			"1:d/FP5EW3wiY1vCndhwleRRKHowQ=",
+		},
+		{
+			"icmp echo",
+			Flow{
+				SourceIP: net.ParseIP("192.168.0.89"), DestinationIP: net.ParseIP("192.168.0.1"),
+				Protocol: ICMP, ICMPType: 8,
+			},
+			"1:X0snYXpgwiv9TZtqg64sgzUn6Dk=",
+		},
+		{
+			"icmp echo reply",
+			Flow{
+				SourceIP: net.ParseIP("192.168.0.1"), DestinationIP: net.ParseIP("192.168.0.89"),
+				Protocol: ICMP, ICMPType: 0,
+			},
+			"1:X0snYXpgwiv9TZtqg64sgzUn6Dk=",
+		},
+		{
+			"icmpv6 neighbor solicitation",
+			Flow{
+				SourceIP: net.ParseIP("fe80::200:86ff:fe05:80da"), DestinationIP: net.ParseIP("fe80::260:97ff:fe07:69ea"),
+				Protocol: ICMPv6, ICMPType: 135,
+			},
+			"1:dGHyGvjMfljg6Bppwm3bg0LO8TY=",
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, DefaultCommunityID.Hash(test.flow), test.name)
+	}
+}
+
+func TestCommunityID_seed(t *testing.T) {
+	flow := Flow{
+		SourceIP: net.ParseIP("128.232.110.120"), DestinationIP: net.ParseIP("66.35.250.204"),
+		SourcePort: 34855, DestinationPort: 80, Protocol: TCP,
+	}
+	assert.Equal(t, "1:3V71V58M3Ksw/yuFALMcW0LAHvc=", NewCommunityID(1).Hash(flow))
+}
+
+func TestCommunityID_invalid(t *testing.T) {
+	flow := Flow{
+		SourceIP: net.ParseIP("10.0.0.1"), DestinationIP: net.ParseIP("fe80::1"),
+		Protocol: TCP,
+	}
+	assert.Equal(t, "", DefaultCommunityID.Hash(flow))
+
+	flow.DestinationIP = nil
+	assert.Equal(t, "", DefaultCommunityID.Hash(flow))
+}
@@ -11,17 +11,34 @@ import (
 // We're introducing the HashableIpPortTuple and the HashableTcpTuple
 // types which are internally simple byte arrays.
 
-const MaxIPPortTupleRawSize = 16 + 16 + 2 + 2
+const MaxIPPortTupleRawSize = 16 + 16 + 2 + 2 + tunnelIDRawSize
 
 type HashableIPPortTuple [MaxIPPortTupleRawSize]byte
 
+// TunnelID identifies the tunnel the packets of a tuple are encapsulated in,
+// by the type of the encapsulation and the identifier of the tunnel (e.g. the
+// VXLAN network identifier or the GRE key). The zero value is used for the
+// packets not encapsulated.
+type TunnelID struct {
+	Type uint8
+	ID   uint32
+}
+
+const tunnelIDRawSize = 1 + 4
+
+func (t TunnelID) putRaw(b []byte) {
+	b[0] = t.Type
+	copy(b[1:5], []byte{byte(t.ID >> 24), byte(t.ID >> 16), byte(t.ID >> 8), byte(t.ID)})
+}
+
 type IPPortTuple struct {
 	IPLength         int
 	SrcIP, DstIP     net.IP
 	SrcPort, DstPort uint16
+	Tunnel           TunnelID
 
-	raw    HashableIPPortTuple // Src_ip:Src_port:Dst_ip:Dst_port
-	revRaw HashableIPPortTuple // Dst_ip:Dst_port:Src_ip:Src_port
+	raw    HashableIPPortTuple // Src_ip:Src_port:Dst_ip:Dst_port:Tunnel
+	revRaw HashableIPPortTuple // Dst_ip:Dst_port:Src_ip:Src_port:Tunnel
 }
 
 func NewIPPortTuple(ipLength int, srcIP net.IP, srcPort uint16,
@@ -44,11 +61,13 @@ func (t *IPPortTuple) ComputeHashebles() {
 	copy(t.raw[16:18], []byte{byte(t.SrcPort >> 8), byte(t.SrcPort)})
 	copy(t.raw[18:34], t.DstIP)
 	copy(t.raw[34:36], []byte{byte(t.DstPort >> 8), byte(t.DstPort)})
+	t.Tunnel.putRaw(t.raw[36:41])
 
 	copy(t.revRaw[0:16], t.DstIP)
 	copy(t.revRaw[16:18], []byte{byte(t.DstPort >> 8), byte(t.DstPort)})
 	copy(t.revRaw[18:34], t.SrcIP)
 	copy(t.revRaw[34:36], []byte{byte(t.SrcPort >> 8), byte(t.SrcPort)})
+	t.Tunnel.putRaw(t.revRaw[36:41])
 }
 
 func (t *IPPortTuple) String() string {
@@ -71,7 +90,7 @@ func (t *IPPortTuple) RevHashable() HashableIPPortTuple {
 	return t.revRaw
 }
 
-const MaxTCPTupleRawSize = 16 + 16 + 2 + 2 + 4
+const MaxTCPTupleRawSize = 16 + 16 + 2 + 2 + 4 + tunnelIDRawSize
 
 type HashableTCPTuple [MaxTCPTupleRawSize]byte
 
@@ -80,8 +99,9 @@ type TCPTuple struct {
 	SrcIP, DstIP     net.IP
 	SrcPort, DstPort uint16
 	StreamID         uint32
+	Tunnel           TunnelID
 
-	raw HashableTCPTuple // Src_ip:Src_port:Dst_ip:Dst_port:stream_id
+	raw HashableTCPTuple // Src_ip:Src_port:Dst_ip:Dst_port:stream_id:Tunnel
 }
 
 func TCPTupleFromIPPort(t *IPPortTuple, streamID uint32) TCPTuple {
@@ -92,6 +112,7 @@ func TCPTupleFromIPPort(t *IPPortTuple, streamID uint32) TCPTuple {
 		SrcPort:  t.SrcPort,
 		DstPort:  t.DstPort,
 		StreamID: streamID,
+		Tunnel:   t.Tunnel,
 	}
 	tuple.ComputeHashebles()
 
@@ -105,6 +126,7 @@ func (t *TCPTuple) ComputeHashebles() {
 	copy(t.raw[34:36], []byte{byte(t.DstPort >> 8), byte(t.DstPort)})
 	copy(t.raw[36:40], []byte{byte(t.StreamID >> 24), byte(t.StreamID >> 16),
 		byte(t.StreamID >> 8), byte(t.StreamID)})
+	t.Tunnel.putRaw(t.raw[40:45])
 }
 
 func (t TCPTuple) String() string {
@@ -118,8 +140,15 @@ func (t TCPTuple) String() string {
 
 // Returns a pointer to the equivalent IpPortTuple.
 func (t TCPTuple) IPPort() *IPPortTuple {
-	ipport := NewIPPortTuple(t.IPLength, t.SrcIP, t.SrcPort,
-		t.DstIP, t.DstPort)
+	ipport := IPPortTuple{
+		IPLength: t.IPLength,
+		SrcIP:    t.SrcIP,
+		DstIP:    t.DstIP,
+		SrcPort:  t.SrcPort,
+		DstPort:  t.DstPort,
+		Tunnel:   t.Tunnel,
+	}
+	ipport.ComputeHashebles()
 	return &ipport
 }
 
@@ -26,7 +26,7 @@ func TestTuples_tuples_ipv4(t *testing.T) {
 	assert.Equal(v4InV6Prefix, tuple.raw[18:30], "prefix_dst")
 	assert.Equal([]byte{192, 168, 0, 2}, tuple.raw[30:34], "dst_ip")
 	assert.Equal([]byte{0x23, 0xf1}, tuple.raw[34:36], "dst_port")
-	assert.Equal(36, len(tuple.raw))
+	assert.Equal(41, len(tuple.raw))
 
 	assert.Equal(v4InV6Prefix, tuple.revRaw[0:12], "rev prefix_dst")
 	assert.Equal([]byte{192, 168, 0, 2}, tuple.revRaw[12:16], "rev dst_ip")
@@ -35,10 +35,10 @@ func TestTuples_tuples_ipv4(t *testing.T) {
 	assert.Equal(v4InV6Prefix, tuple.revRaw[18:30], "rev prefix_src")
 	assert.Equal([]byte{192, 168, 0, 1}, tuple.revRaw[30:34], "rev src_ip")
 	assert.Equal([]byte{0x23, 0xf0}, tuple.revRaw[34:36], "rev src_port")
-	assert.Equal(36, len(tuple.revRaw))
+	assert.Equal(41, len(tuple.revRaw))
 
 	tcpTuple := TCPTupleFromIPPort(&tuple, 1)
-	assert.Equal(tuple.raw[:], tcpTuple.raw[0:36], "Wrong TCP tuple hashable")
+	assert.Equal(tuple.raw[:36], tcpTuple.raw[0:36], "Wrong TCP tuple hashable")
 	assert.Equal([]byte{0, 0, 0, 1}, tcpTuple.raw[36:40], "stream_id")
 }
 
@@ -58,16 +58,34 @@ func TestTuples_tuples_ipv6(t *testing.T) {
 
 	assert.Equal(ip2, tuple.raw[18:34], "dst_ip")
 	assert.Equal([]byte{0x23, 0xf1}, tuple.raw[34:36], "dst_port")
-	assert.Equal(36, len(tuple.raw))
+	assert.Equal(41, len(tuple.raw))
 
 	assert.Equal(ip2, tuple.revRaw[0:16], "rev dst_ip")
 	assert.Equal([]byte{0x23, 0xf1}, tuple.revRaw[16:18], "rev dst_port")
 
 	assert.Equal(ip1, tuple.revRaw[18:34], "rev src_ip")
 	assert.Equal([]byte{0x23, 0xf0}, tuple.revRaw[34:36], "rev src_port")
-	assert.Equal(36, len(tuple.revRaw))
+	assert.Equal(41, len(tuple.revRaw))
 
 	tcpTuple := TCPTupleFromIPPort(&tuple, 1)
-	assert.Equal(tuple.raw[:], tcpTuple.raw[0:36], "Wrong TCP tuple hashable")
+	assert.Equal(tuple.raw[:36], tcpTuple.raw[0:36], "Wrong TCP tuple hashable")
 	assert.Equal([]byte{0, 0, 0, 1}, tcpTuple.raw[36:40], "stream_id")
 }
+
+func TestTuples_tunnel(t *testing.T) {
+	assert := assert.New(t)
+
+	tuple := NewIPPortTuple(4, net.IPv4(10, 0, 0, 1), 9200, net.IPv4(10, 0, 0, 2), 9201)
+	tunneled := tuple
+	tunneled.Tunnel = TunnelID{Type: 1, ID: 0x010203}
+	tunneled.ComputeHashebles()
+
+	assert.Equal([]byte{1, 0, 1, 2, 3}, tunneled.raw[36:41], "tunnel")
+	assert.Equal([]byte{1, 0, 1, 2, 3}, tunneled.revRaw[36:41], "rev tunnel")
+	assert.NotEqual(tuple.Hashable(), tunneled.Hashable())
+
+	tcpTuple := TCPTupleFromIPPort(&tunneled, 1)
+	assert.Equal(tunneled.Tunnel, tcpTuple.Tunnel)
+	assert.Equal([]byte{1, 0, 1, 2, 3}, tcpTuple.raw[40:45], "tcp tunnel")
+	assert.Equal(tunneled.Hashable(), tcpTuple.IPPort().Hashable())
+}
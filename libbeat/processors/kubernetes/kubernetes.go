@@ -126,49 +126,14 @@ func newKubernetesAnnotator(cfg common.Config) (processors.Processor, error) {
 		return nil, fmt.Errorf("Can not initialize kubernetes plugin with zero matcher plugins")
 	}
 
-	var client *k8s.Client
-	if config.InCluster == true {
-		client, err = k8s.NewInClusterClient()
-		if err != nil {
-			return nil, fmt.Errorf("Unable to get in cluster configuration")
-		}
-	} else {
-		data, err := ioutil.ReadFile(config.KubeConfig)
-		if err != nil {
-			return nil, fmt.Errorf("read kubeconfig: %v", err)
-		}
-
-		// Unmarshal YAML into a Kubernetes config object.
-		var config k8s.Config
-		if err = yaml.Unmarshal(data, &config); err != nil {
-			return nil, fmt.Errorf("unmarshal kubeconfig: %v", err)
-		}
-		client, err = k8s.NewClient(&config)
-		if err != nil {
-			return nil, err
-		}
+	client, err := GetKubernetesClient(config.InCluster, config.KubeConfig)
+	if err != nil {
+		return nil, err
 	}
 
-	ctx := context.Background()
-	if config.Host == "" {
-		podName := os.Getenv("HOSTNAME")
-		logp.Info("Using pod name %s and namespace %s", podName, config.Namespace)
-		if podName == "localhost" {
-			config.Host = "localhost"
-		} else {
-			pod, error := client.CoreV1().GetPod(ctx, podName, config.Namespace)
-			if error != nil {
-				logp.Err("Querying for pod failed with error: ", error.Error())
-				logp.Info("Unable to find pod, setting host to localhost")
-				config.Host = "localhost"
-			} else {
-				config.Host = pod.Spec.GetNodeName()
-			}
+	config.Host = DiscoverKubernetesNode(config.Host, config.Namespace, client)
 
-		}
-	}
-
-	logp.Debug("kubernetes", "Using host ", config.Host)
+	logp.Debug("kubernetes", "Using host %s", config.Host)
 	logp.Debug("kubernetes", "Initializing watcher")
 	if client != nil {
 		watcher := NewPodWatcher(client, &indexers, config.SyncPeriod, config.Host)
@@ -210,6 +175,55 @@ func (k kubernetesAnnotator) Run(event common.MapStr) (common.MapStr, error) {
 
 func (k kubernetesAnnotator) String() string { return "kubernetes" }
 
+// GetKubernetesClient returns a client to the Kubernetes API, using the
+// service account of the pod when running in cluster, or the given kube
+// config file otherwise.
+func GetKubernetesClient(inCluster bool, kubeConfig string) (*k8s.Client, error) {
+	if inCluster {
+		client, err := k8s.NewInClusterClient()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get in cluster configuration")
+		}
+		return client, nil
+	}
+
+	data, err := ioutil.ReadFile(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("read kubeconfig: %v", err)
+	}
+
+	// Unmarshal YAML into a Kubernetes config object.
+	var config k8s.Config
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal kubeconfig: %v", err)
+	}
+	return k8s.NewClient(&config)
+}
+
+// DiscoverKubernetesNode returns the node the beat is running on, when host
+// is not set. The node is looked up from the pod named after the hostname,
+// in the given namespace.
+func DiscoverKubernetesNode(host, namespace string, client *k8s.Client) string {
+	if host != "" {
+		return host
+	}
+
+	ctx := context.Background()
+	podName := os.Getenv("HOSTNAME")
+	logp.Info("Using pod name %s and namespace %s", podName, namespace)
+	if podName == "localhost" {
+		return "localhost"
+	}
+
+	pod, err := client.CoreV1().GetPod(ctx, podName, namespace)
+	if err != nil {
+		logp.Err("Querying for pod failed with error: %v", err)
+		logp.Info("Unable to find pod, setting host to localhost")
+		return "localhost"
+	}
+	return pod.Spec.GetNodeName()
+}
+
 func validate(config kubeAnnotatorConfig) error {
 	if !config.InCluster && config.KubeConfig == "" {
 		return errors.New("`kube_config` path can't be empty when in_cluster is set to false")
@@ -24,6 +24,15 @@ type PodWatcher struct {
 	stop                context.CancelFunc
 	annotationCache     annotationCache
 	indexers            *Indexers
+	handlers            []PodEventHandler
+}
+
+// PodEventHandler is notified of the pods added, updated and deleted, after
+// the pod cache of the watcher is updated.
+type PodEventHandler interface {
+	OnAdd(pod *Pod)
+	OnUpdate(pod *Pod)
+	OnDelete(pod *Pod)
 }
 
 type annotationCache struct {
@@ -54,6 +63,12 @@ func NewPodWatcher(kubeClient *k8s.Client, indexers *Indexers, syncPeriod time.D
 	}
 }
 
+// AddEventHandler registers a handler notified of the pod changes. Handlers
+// must be added before the watcher is run.
+func (p *PodWatcher) AddEventHandler(h PodEventHandler) {
+	p.handlers = append(p.handlers, h)
+}
+
 func (p *PodWatcher) syncPods() error {
 	logp.Info("kubernetes: %s", "Performing a pod sync")
 	pods, err := p.kubeClient.CoreV1().ListPods(
@@ -178,14 +193,26 @@ func (p *PodWatcher) getPodMeta(pod *corev1.Pod) *Pod {
 func (p *PodWatcher) worker() {
 	for po := range p.podQueue {
 		pod := p.getPodMeta(po)
+		if pod == nil {
+			continue
+		}
 		if pod.Metadata.DeletionTimestamp != "" {
 			p.onPodDelete(pod)
+			for _, h := range p.handlers {
+				h.OnDelete(pod)
+			}
 		} else {
 			existing := p.GetPod(pod.Metadata.UID)
 			if existing != nil {
 				p.onPodUpdate(pod)
+				for _, h := range p.handlers {
+					h.OnUpdate(pod)
+				}
 			} else {
 				p.onPodAdd(pod)
+				for _, h := range p.handlers {
+					h.OnAdd(pod)
+				}
 			}
 		}
 	}
@@ -7,4 +7,12 @@ type Config struct {
 	// Modules is a list of module specific configuration data.
 	Modules       []*common.Config `config:"modules"`
 	ReloadModules *common.Config   `config:"config.modules"`
+
+	// LightModules loads the definitions of the modules defined in YAML files
+	// at startup.
+	LightModules *common.Config `config:"light_modules"`
+
+	// Autodiscover starts and stops modules as the discovered targets, like
+	// containers, come and go.
+	Autodiscover *common.Config `config:"autodiscover"`
 }
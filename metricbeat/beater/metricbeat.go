@@ -3,11 +3,13 @@ package beater
 import (
 	"sync"
 
+	"github.com/elastic/beats/libbeat/autodiscover"
 	"github.com/elastic/beats/libbeat/beat"
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
 	"github.com/elastic/beats/libbeat/publisher"
 	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/elastic/beats/metricbeat/mb/lightmodules"
 	"github.com/elastic/beats/metricbeat/mb/module"
 
 	"github.com/elastic/beats/libbeat/cfgfile"
@@ -23,13 +25,12 @@ type Metricbeat struct {
 	modules []*module.Wrapper // Active list of modules.
 	client  publisher.Client  // Publisher client.
 	config  Config
+
+	autodiscover *autodiscover.Autodiscover // Autodiscover manager, nil if disabled.
 }
 
 // New creates and returns a new Metricbeat instance.
 func New(b *beat.Beat, rawConfig *common.Config) (beat.Beater, error) {
-	// List all registered modules and metricsets.
-	logp.Info("%s", mb.Registry.String())
-
 	config := Config{}
 
 	err := rawConfig.Unpack(&config)
@@ -37,10 +38,40 @@ func New(b *beat.Beat, rawConfig *common.Config) (beat.Beater, error) {
 		return nil, errors.Wrap(err, "error reading configuration file")
 	}
 
+	if config.LightModules.Enabled() {
+		logp.Beta("feature light modules is enabled.")
+		lmConfig := lightmodules.Config{}
+		if err := config.LightModules.Unpack(&lmConfig); err != nil {
+			return nil, errors.Wrap(err, "error reading light modules configuration")
+		}
+		if err := lightmodules.Load(mb.Registry, lmConfig); err != nil {
+			return nil, errors.Wrap(err, "error loading light modules")
+		}
+	}
+
+	// List all registered modules and metricsets.
+	logp.Info("%s", mb.Registry.String())
+
+	if *testModule != "" {
+		return nil, runTestModule(*testModule, config.Modules)
+	}
+
+	var adiscover *autodiscover.Autodiscover
+	if config.Autodiscover.Enabled() {
+		adConfig := autodiscover.Config{}
+		if err := config.Autodiscover.Unpack(&adConfig); err != nil {
+			return nil, errors.Wrap(err, "error reading autodiscover configuration")
+		}
+		adiscover, err = autodiscover.NewAutodiscover("metricbeat", module.NewFactory(b.Publisher), &adConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	modules, err := module.NewWrappers(config.Modules, mb.Registry)
 	if err != nil {
-		// Empty config is fine if dynamic config is enabled
-		if !config.ReloadModules.Enabled() {
+		// Empty config is fine if dynamic config or autodiscover is enabled
+		if !config.ReloadModules.Enabled() && adiscover == nil {
 			return nil, err
 		} else if err != mb.ErrEmptyConfig && err != mb.ErrAllModulesDisabled {
 			return nil, err
@@ -48,9 +79,10 @@ func New(b *beat.Beat, rawConfig *common.Config) (beat.Beater, error) {
 	}
 
 	mb := &Metricbeat{
-		done:    make(chan struct{}),
-		modules: modules,
-		config:  config,
+		done:         make(chan struct{}),
+		modules:      modules,
+		config:       config,
+		autodiscover: adiscover,
 	}
 	return mb, nil
 }
@@ -64,6 +96,19 @@ func (bt *Metricbeat) Run(b *beat.Beat) error {
 
 	var wg sync.WaitGroup
 
+	if bt.autodiscover != nil {
+		logp.Beta("feature autodiscover is enabled.")
+		if err := bt.autodiscover.Start(); err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-bt.done
+			bt.autodiscover.Stop()
+		}()
+	}
+
 	for _, m := range bt.modules {
 		r := module.NewRunner(b.Publisher.Connect, m)
 		r.Start()
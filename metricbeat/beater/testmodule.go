@@ -0,0 +1,121 @@
+package beater
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/elastic/beats/metricbeat/mb/module"
+)
+
+var testModule = flag.String("test-module", "", "Fetch the metricsets of a module once, given as module or module/metricset, print the events and exit")
+
+// runTestModule fetches the metricsets of the module selected with the
+// -test-module flag once, and prints the events and the fetch durations. The
+// module is configured by the modules of the configuration with the same name,
+// or by its default configuration if there is none. It returns an error if any
+// fetch failed, beat.GracefulExit otherwise.
+func runTestModule(name string, configs []*common.Config) error {
+	moduleConfigs, err := testModuleConfigs(name, configs)
+	if err != nil {
+		return err
+	}
+
+	modules, err := module.NewWrappers(moduleConfigs, mb.Registry)
+	if err != nil {
+		return errors.Wrapf(err, "error creating module %s", name)
+	}
+
+	failures := 0
+	for _, m := range modules {
+		for _, result := range m.FetchOnce() {
+			if err := printFetchResult(os.Stdout, result); err != nil {
+				return err
+			}
+			failures += result.Errors
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d errors fetching module %s", failures, name)
+	}
+	return beat.GracefulExit
+}
+
+// testModuleConfigs returns the configurations of the module to test, given as
+// module or module/metricset. When a metricset is given, the configurations
+// are restricted to it.
+func testModuleConfigs(name string, configs []*common.Config) ([]*common.Config, error) {
+	moduleName, metricSetName := name, ""
+	if i := strings.Index(name, "/"); i >= 0 {
+		moduleName, metricSetName = name[:i], name[i+1:]
+	}
+
+	var selected []map[string]interface{}
+	for _, c := range configs {
+		var settings map[string]interface{}
+		if err := c.Unpack(&settings); err != nil {
+			return nil, err
+		}
+		if settings["module"] == moduleName {
+			selected = append(selected, settings)
+		}
+	}
+
+	if len(selected) == 0 {
+		metricSets := mb.Registry.MetricSets(moduleName)
+		if len(metricSets) == 0 {
+			return nil, fmt.Errorf("module %s is not available", moduleName)
+		}
+		selected = append(selected, map[string]interface{}{
+			"module":     moduleName,
+			"metricsets": metricSets,
+		})
+	}
+
+	// Test the modules disabled in the configuration too, and only the given
+	// metricset.
+	moduleConfigs := make([]*common.Config, 0, len(selected))
+	for _, settings := range selected {
+		settings["enabled"] = true
+		if metricSetName != "" {
+			settings["metricsets"] = []string{metricSetName}
+		}
+
+		c, err := common.NewConfigFrom(settings)
+		if err != nil {
+			return nil, err
+		}
+		moduleConfigs = append(moduleConfigs, c)
+	}
+	return moduleConfigs, nil
+}
+
+// printFetchResult prints the events of the fetch as indented JSON, followed by
+// a summary of the fetch.
+func printFetchResult(w io.Writer, result module.FetchResult) error {
+	for _, event := range result.Events {
+		// Add the fields and tags of the module like the publisher does.
+		if meta, ok := event[common.EventMetadataKey].(common.EventMetadata); ok {
+			common.AddTags(event, meta.Tags)
+			common.MergeFields(event, meta.Fields, meta.FieldsUnderRoot)
+			delete(event, common.EventMetadataKey)
+		}
+		fmt.Fprintln(w, event.StringToPrint())
+	}
+
+	host := ""
+	if result.Host != "" {
+		host = " for host " + result.Host
+	}
+	_, err := fmt.Fprintf(w, "%s%s: %d events, %d errors, fetched in %v\n",
+		result.MetricSet, host, len(result.Events), result.Errors, result.Duration)
+	return err
+}
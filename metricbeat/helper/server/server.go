@@ -0,0 +1,157 @@
+// Package server provides the UDP and TCP listeners of the metricsets that
+// receive the metrics pushed by their clients.
+package server
+
+import (
+	"bufio"
+	"net"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+const (
+	// Maximum size of a UDP datagram.
+	maxPacketSize = 65535
+
+	// Maximum size of a message read from a TCP connection.
+	maxMessageSize = 1024 * 1024
+)
+
+var debugf = logp.MakeDebug("server")
+
+// Server receives the messages of the clients.
+type Server interface {
+	Addr() net.Addr
+
+	// Start calls handler with the received messages, until Stop is called.
+	// The handler must not retain the message.
+	Start(handler func(message []byte))
+	Stop()
+}
+
+// NewUDPServer listens on address. Each datagram is a message.
+func NewUDPServer(address string) (Server, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &udpServer{conn: conn}, nil
+}
+
+// NewTCPServer listens on address. The messages of the connections are
+// delimited by split, like bufio.ScanLines.
+func NewTCPServer(address string, split bufio.SplitFunc) (Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpServer{
+		listener: listener,
+		split:    split,
+		conns:    map[net.Conn]struct{}{},
+	}, nil
+}
+
+type udpServer struct {
+	conn net.PacketConn
+	wg   sync.WaitGroup
+}
+
+func (s *udpServer) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *udpServer) Start(handler func(message []byte)) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, _, err := s.conn.ReadFrom(buf)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+					continue
+				}
+				// closed
+				return
+			}
+			handler(buf[:n])
+		}
+	}()
+}
+
+func (s *udpServer) Stop() {
+	s.conn.Close()
+	s.wg.Wait()
+}
+
+type tcpServer struct {
+	listener net.Listener
+	split    bufio.SplitFunc
+	wg       sync.WaitGroup
+
+	mutex sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (s *tcpServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *tcpServer) Start(handler func(message []byte)) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+					continue
+				}
+				return
+			}
+
+			s.mutex.Lock()
+			s.conns[conn] = struct{}{}
+			s.mutex.Unlock()
+
+			s.wg.Add(1)
+			go s.read(conn, handler)
+		}
+	}()
+}
+
+func (s *tcpServer) read(conn net.Conn, handler func(message []byte)) {
+	defer s.wg.Done()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, maxMessageSize)
+	scanner.Split(s.split)
+	for scanner.Scan() {
+		handler(scanner.Bytes())
+	}
+	if err := scanner.Err(); err != nil {
+		debugf("Error reading from %v: %v", conn.RemoteAddr(), err)
+	}
+}
+
+func (s *tcpServer) Stop() {
+	s.listener.Close()
+
+	s.mutex.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+}
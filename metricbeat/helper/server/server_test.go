@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func receive(t *testing.T, s Server, send func(conn net.Conn), expected ...string) {
+	messages := make(chan string, 10)
+	s.Start(func(message []byte) {
+		messages <- string(message)
+	})
+	defer s.Stop()
+
+	conn, err := net.Dial(s.Addr().Network(), s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	send(conn)
+
+	for _, e := range expected {
+		select {
+		case message := <-messages:
+			assert.Equal(t, e, message)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for message %s", e)
+		}
+	}
+}
+
+func TestUDPServer(t *testing.T) {
+	s, err := NewUDPServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receive(t, s, func(conn net.Conn) {
+		conn.Write([]byte("a:1|c\nb:2|c"))
+	}, "a:1|c\nb:2|c")
+}
+
+func TestTCPServerLines(t *testing.T) {
+	s, err := NewTCPServer("127.0.0.1:0", bufio.ScanLines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receive(t, s, func(conn net.Conn) {
+		conn.Write([]byte("first\nsec"))
+		conn.Write([]byte("ond\n"))
+	}, "first", "second")
+}
+
+func TestTCPServerSplit(t *testing.T) {
+	// Messages prefixed by their length
+	split := func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) < 4 {
+			return 0, nil, nil
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if len(data) < 4+size {
+			return 0, nil, nil
+		}
+		return 4 + size, data[4 : 4+size], nil
+	}
+
+	s, err := NewTCPServer("127.0.0.1:0", split)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receive(t, s, func(conn net.Conn) {
+		conn.Write([]byte{0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o', 0, 0, 0, 2, 'o'})
+		conn.Write([]byte{'k'})
+	}, "hello", "ok")
+}
+
+func TestStopClosesConnections(t *testing.T) {
+	s, err := NewTCPServer("127.0.0.1:0", bufio.ScanLines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start(func([]byte) {})
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("open\n"))
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked by an open connection")
+	}
+}
@@ -36,6 +36,8 @@ import (
 	_ "github.com/elastic/beats/metricbeat/module/golang"
 	_ "github.com/elastic/beats/metricbeat/module/golang/expvar"
 	_ "github.com/elastic/beats/metricbeat/module/golang/heap"
+	_ "github.com/elastic/beats/metricbeat/module/graphite"
+	_ "github.com/elastic/beats/metricbeat/module/graphite/server"
 	_ "github.com/elastic/beats/metricbeat/module/haproxy"
 	_ "github.com/elastic/beats/metricbeat/module/haproxy/info"
 	_ "github.com/elastic/beats/metricbeat/module/haproxy/stat"
@@ -71,10 +73,15 @@ import (
 	_ "github.com/elastic/beats/metricbeat/module/postgresql/database"
 	_ "github.com/elastic/beats/metricbeat/module/prometheus"
 	_ "github.com/elastic/beats/metricbeat/module/prometheus/collector"
+	_ "github.com/elastic/beats/metricbeat/module/prometheus/remote_write"
 	_ "github.com/elastic/beats/metricbeat/module/prometheus/stats"
 	_ "github.com/elastic/beats/metricbeat/module/redis"
 	_ "github.com/elastic/beats/metricbeat/module/redis/info"
 	_ "github.com/elastic/beats/metricbeat/module/redis/keyspace"
+	_ "github.com/elastic/beats/metricbeat/module/sql"
+	_ "github.com/elastic/beats/metricbeat/module/sql/query"
+	_ "github.com/elastic/beats/metricbeat/module/statsd"
+	_ "github.com/elastic/beats/metricbeat/module/statsd/metrics"
 	_ "github.com/elastic/beats/metricbeat/module/system"
 	_ "github.com/elastic/beats/metricbeat/module/system/core"
 	_ "github.com/elastic/beats/metricbeat/module/system/cpu"
@@ -83,9 +90,13 @@ import (
 	_ "github.com/elastic/beats/metricbeat/module/system/fsstat"
 	_ "github.com/elastic/beats/metricbeat/module/system/load"
 	_ "github.com/elastic/beats/metricbeat/module/system/memory"
+	_ "github.com/elastic/beats/metricbeat/module/system/netstat"
 	_ "github.com/elastic/beats/metricbeat/module/system/network"
+	_ "github.com/elastic/beats/metricbeat/module/system/pressure"
 	_ "github.com/elastic/beats/metricbeat/module/system/process"
+	_ "github.com/elastic/beats/metricbeat/module/system/schedstat"
 	_ "github.com/elastic/beats/metricbeat/module/system/socket"
+	_ "github.com/elastic/beats/metricbeat/module/system/vmstat"
 	_ "github.com/elastic/beats/metricbeat/module/vsphere"
 	_ "github.com/elastic/beats/metricbeat/module/vsphere/datastore"
 	_ "github.com/elastic/beats/metricbeat/module/vsphere/host"
@@ -0,0 +1,148 @@
+package lightmodules
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/paths"
+	"github.com/elastic/beats/metricbeat/schema"
+	"github.com/elastic/beats/metricbeat/schema/mapstriface"
+)
+
+// Config is the configuration of the light modules.
+type Config struct {
+	// Path is a glob pattern of the definition files. If it is a relative path,
+	// it is relative to the ${path.config}.
+	Path string `config:"path" validate:"required"`
+}
+
+// Definition is a module defined in a YAML file.
+type Definition struct {
+	Module     string                `config:"module"     validate:"required"`
+	MetricSets []MetricSetDefinition `config:"metricsets" validate:"required"`
+}
+
+// MetricSetDefinition defines a MetricSet fetching a JSON document over HTTP,
+// and mapping its fields to the event.
+type MetricSetDefinition struct {
+	Name      string            `config:"name"   validate:"required"`
+	Path      string            `config:"path"`   // Default path of the endpoint, can be overridden in the module config.
+	Method    string            `config:"method"` // HTTP method of the request, defaults to GET.
+	Body      string            `config:"body"`   // Body of the request.
+	Namespace string            `config:"namespace"`
+	Split     string            `config:"split"` // Key of an array of objects, each object is reported as an event.
+	Fields    []FieldDefinition `config:"fields" validate:"required"`
+}
+
+// FieldDefinition maps a field of the JSON document to a field of the event.
+type FieldDefinition struct {
+	Name     string `config:"name" validate:"required"` // Name of the field in the event, dots create objects.
+	Key      string `config:"key"`                      // Key of the field in the document, defaults to the name.
+	Type     string `config:"type"`                     // keyword (default), text, long, float or boolean.
+	Optional bool   `config:"optional"`                 // Missing optional fields are not reported as errors.
+}
+
+// converters are the mapstriface converters of the field types.
+var converters = map[string]func(string, ...schema.SchemaOption) schema.Conv{
+	"":        mapstriface.Str,
+	"keyword": mapstriface.Str,
+	"text":    mapstriface.Str,
+	"long":    mapstriface.Int,
+	"float":   mapstriface.Float,
+	"boolean": mapstriface.Bool,
+}
+
+// Validate validates the type of the field.
+func (f *FieldDefinition) Validate() error {
+	if _, found := converters[f.Type]; !found {
+		return fmt.Errorf("invalid type '%s' of field '%s'", f.Type, f.Name)
+	}
+	return nil
+}
+
+// LoadDefinitions loads the definitions of the files matching the path of the
+// config.
+func LoadDefinitions(config Config) ([]Definition, error) {
+	path := config.Path
+	if !filepath.IsAbs(path) {
+		path = paths.Resolve(paths.Config, path)
+	}
+
+	files, err := filepath.Glob(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid light modules path '%s'", path)
+	}
+
+	definitions := make([]Definition, 0, len(files))
+	for _, file := range files {
+		c, err := common.LoadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error loading light module file %s", file)
+		}
+
+		var d Definition
+		if err := c.Unpack(&d); err != nil {
+			return nil, errors.Wrapf(err, "error reading light module file %s", file)
+		}
+		definitions = append(definitions, d)
+	}
+	return definitions, nil
+}
+
+// buildSchema builds the schema mapping the fields of a JSON document to an
+// event.
+func buildSchema(fields []FieldDefinition) (schema.Schema, error) {
+	s := schema.Schema{}
+	for _, field := range fields {
+		key := field.Key
+		if key == "" {
+			key = field.Name
+		}
+
+		var opts []schema.SchemaOption
+		if field.Optional {
+			opts = append(opts, schema.Optional)
+		}
+		conv := nested(converters[field.Type](key, opts...))
+
+		// Create the objects of the dotted name.
+		mappers := map[string]schema.Mapper(s)
+		parts := strings.Split(field.Name, ".")
+		for _, part := range parts[:len(parts)-1] {
+			object, ok := mappers[part].(schema.Object)
+			if !ok {
+				if _, exists := mappers[part]; exists {
+					return nil, fmt.Errorf("field '%s' conflicts with field '%s'", field.Name, part)
+				}
+				object = schema.Object{}
+				mappers[part] = object
+			}
+			mappers = object
+		}
+
+		name := parts[len(parts)-1]
+		if _, exists := mappers[name]; exists {
+			return nil, fmt.Errorf("duplicate field '%s'", field.Name)
+		}
+		mappers[name] = conv
+	}
+	return s, nil
+}
+
+// nested makes a converter look up dotted keys in the nested objects of the
+// document.
+func nested(conv schema.Conv) schema.Conv {
+	convert := conv.Func
+	conv.Func = func(key string, data map[string]interface{}) (interface{}, error) {
+		value, err := common.MapStr(data).GetValue(key)
+		if err != nil {
+			return nil, fmt.Errorf("Key %s not found", key)
+		}
+		return convert("value", map[string]interface{}{"value": value})
+	}
+	return conv
+}
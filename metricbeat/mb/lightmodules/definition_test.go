@@ -0,0 +1,111 @@
+// +build !integration
+
+package lightmodules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestLoadDefinitions(t *testing.T) {
+	definitions, err := LoadDefinitions(Config{Path: "testdata/*.yml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Len(t, definitions, 1) {
+		return
+	}
+
+	d := definitions[0]
+	assert.Equal(t, "myservice", d.Module)
+	if assert.Len(t, d.MetricSets, 2) {
+		assert.Equal(t, "stats", d.MetricSets[0].Name)
+		assert.Equal(t, "/api/stats", d.MetricSets[0].Path)
+		assert.Len(t, d.MetricSets[0].Fields, 5)
+		assert.Equal(t, FieldDefinition{Name: "requests.total", Key: "stats.requests", Type: "long"}, d.MetricSets[0].Fields[0])
+		assert.Equal(t, FieldDefinition{Name: "build", Optional: true}, d.MetricSets[0].Fields[4])
+
+		assert.Equal(t, "POST", d.MetricSets[1].Method)
+		assert.Equal(t, `{"all": true}`, d.MetricSets[1].Body)
+		assert.Equal(t, "queues", d.MetricSets[1].Split)
+		assert.Equal(t, "queue", d.MetricSets[1].Namespace)
+	}
+}
+
+func TestInvalidFieldType(t *testing.T) {
+	c, err := common.NewConfigFrom(map[string]interface{}{
+		"module": "myservice",
+		"metricsets": []map[string]interface{}{
+			{
+				"name":   "stats",
+				"fields": []map[string]interface{}{{"name": "total", "type": "integer"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d Definition
+	err = c.Unpack(&d)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid type 'integer' of field 'total'")
+	}
+}
+
+func TestBuildSchema(t *testing.T) {
+	s, err := buildSchema([]FieldDefinition{
+		{Name: "requests.total", Key: "stats.requests", Type: "long"},
+		{Name: "requests.latency", Key: "stats.latency", Type: "float"},
+		{Name: "up", Key: "stats.up", Type: "boolean"},
+		{Name: "version"},
+		{Name: "build", Optional: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, errs := s.Apply(map[string]interface{}{
+		"stats": map[string]interface{}{
+			"requests": 1234.0,
+			"latency":  2.5,
+			"up":       true,
+		},
+		"version": "1.2.3",
+	})
+	assert.False(t, errs.HasRequiredErrors())
+	assert.Equal(t, common.MapStr{
+		"requests": common.MapStr{
+			"total":   int64(1234),
+			"latency": 2.5,
+		},
+		"up":      true,
+		"version": "1.2.3",
+	}, event)
+
+	_, errs = s.Apply(map[string]interface{}{"version": "1.2.3"})
+	assert.True(t, errs.HasRequiredErrors())
+}
+
+func TestBuildSchemaErrors(t *testing.T) {
+	tests := map[string][]FieldDefinition{
+		"duplicate field 'version'": {
+			{Name: "version"},
+			{Name: "version", Key: "build"},
+		},
+		"field 'version.major' conflicts with field 'version'": {
+			{Name: "version"},
+			{Name: "version.major", Type: "long"},
+		},
+	}
+
+	for expected, fields := range tests {
+		_, err := buildSchema(fields)
+		if assert.Error(t, err) {
+			assert.Equal(t, expected, err.Error())
+		}
+	}
+}
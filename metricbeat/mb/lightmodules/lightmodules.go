@@ -0,0 +1,64 @@
+/*
+Package lightmodules loads the light modules, which are modules defined in YAML
+files instead of Go code. Each MetricSet of a light module fetches a JSON
+document from an HTTP endpoint, and maps its fields to the events with a
+schema, optionally splitting an array of the document into multiple events.
+
+A light module is defined by a file like this one:
+
+	module: myservice
+	metricsets:
+	  - name: stats
+	    path: /api/stats
+	    fields:
+	      - name: requests.total
+	        key: stats.requests
+	        type: long
+	      - name: version
+	        optional: true
+	  - name: queues
+	    path: /api/queues
+	    split: queues
+	    fields:
+	      - name: name
+	      - name: messages
+	        type: long
+
+Once registered, the modules are configured like the other modules.
+*/
+package lightmodules
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+// Register registers the MetricSets of the definitions in the registry.
+func Register(r *mb.Register, definitions []Definition) error {
+	for _, d := range definitions {
+		for _, ms := range d.MetricSets {
+			factory, hostParser, err := newMetricSetFactory(ms)
+			if err != nil {
+				return errors.Wrapf(err, "invalid light metricset %s/%s", d.Module, ms.Name)
+			}
+
+			if err := r.AddMetricSet(d.Module, ms.Name, factory, hostParser); err != nil {
+				return err
+			}
+			logp.Info("Light metricset %s/%s registered", d.Module, ms.Name)
+		}
+	}
+	return nil
+}
+
+// Load loads the definitions of the light modules matching the configuration,
+// and registers them in the registry.
+func Load(r *mb.Register, config Config) error {
+	definitions, err := LoadDefinitions(config)
+	if err != nil {
+		return err
+	}
+	return Register(r, definitions)
+}
@@ -0,0 +1,139 @@
+package lightmodules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/helper"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/elastic/beats/metricbeat/mb/parse"
+	"github.com/elastic/beats/metricbeat/schema"
+)
+
+// MetricSet fetches a JSON document over HTTP, and reports the fields mapped
+// by the schema of its definition.
+type MetricSet struct {
+	mb.BaseMetricSet
+	http      *helper.HTTP
+	schema    schema.Schema
+	namespace string
+	split     string
+}
+
+// newMetricSetFactory returns the factory and the host parser of the MetricSet
+// of a definition.
+func newMetricSetFactory(d MetricSetDefinition) (mb.MetricSetFactory, mb.HostParser, error) {
+	s, err := buildSchema(d.Fields)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	method := d.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	factory := func(base mb.BaseMetricSet) (mb.MetricSet, error) {
+		http := helper.NewHTTP(base)
+		if http == nil {
+			return nil, fmt.Errorf("error creating the HTTP client of %s/%s", base.Module().Name(), base.Name())
+		}
+		http.SetMethod(method)
+		if d.Body != "" {
+			http.SetBody([]byte(d.Body))
+		}
+
+		return &MetricSet{
+			BaseMetricSet: base,
+			http:          http,
+			schema:        s,
+			namespace:     d.Namespace,
+			split:         d.Split,
+		}, nil
+	}
+
+	hostParser := parse.URLHostParserBuilder{
+		DefaultScheme: "http",
+		PathConfigKey: "path",
+		DefaultPath:   d.Path,
+	}.Build()
+
+	return factory, hostParser, nil
+}
+
+// Fetch fetches the JSON document, and returns its event, or the events of the
+// objects of its split array.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	content, err := m.http.FetchContent()
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errors.Wrap(err, "error decoding the JSON response")
+	}
+
+	items, err := m.splitDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []common.MapStr
+	errs := schema.NewErrors()
+	for _, item := range items {
+		event, itemErrs := m.schema.Apply(item)
+		if m.namespace != "" {
+			event["_namespace"] = m.namespace
+		}
+		events = append(events, event)
+		errs.AddErrors(itemErrs)
+	}
+
+	if errs.HasRequiredErrors() {
+		return events, errs
+	}
+	return events, nil
+}
+
+// splitDocument returns the objects to report as events: the objects of the
+// split array if set, or of the document if it is an array, or the document
+// itself.
+func (m *MetricSet) splitDocument(doc interface{}) ([]map[string]interface{}, error) {
+	if m.split != "" {
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected a JSON object, found %T", doc)
+		}
+		value, err := common.MapStr(obj).GetValue(m.split)
+		if err != nil {
+			return nil, errors.Wrapf(err, "split key '%s' not found", m.split)
+		}
+		doc = value
+	}
+
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		if m.split != "" {
+			return nil, fmt.Errorf("expected an array in split key '%s', found an object", m.split)
+		}
+		return []map[string]interface{}{v}, nil
+	case []interface{}:
+		items := make([]map[string]interface{}, 0, len(v))
+		for _, item := range v {
+			obj, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("expected an array of objects, found %T", item)
+			}
+			items = append(items, obj)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("expected a JSON object or array, found %T", doc)
+}
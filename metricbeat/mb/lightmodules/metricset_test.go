@@ -0,0 +1,131 @@
+// +build !integration
+
+package lightmodules
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+	mbtest "github.com/elastic/beats/metricbeat/mb/testing"
+)
+
+func init() {
+	definitions, err := LoadDefinitions(Config{Path: "testdata/*.yml"})
+	if err != nil {
+		panic(err)
+	}
+	if err := Register(mb.Registry, definitions); err != nil {
+		panic(err)
+	}
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/stats":
+			w.Write([]byte(`{"stats": {"requests": 1234, "latency": 2.5, "up": true}, "version": "1.2.3"}`))
+		case "/api/queues":
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.Method != "POST" || string(body) != `{"all": true}` {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"queues": [{"name": "a", "messages": 3}, {"name": "b", "messages": 7}]}`))
+		case "/api/missing":
+			w.Write([]byte(`{"stats": {}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestFetch(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	f := mbtest.NewEventsFetcher(t, map[string]interface{}{
+		"module":     "myservice",
+		"metricsets": []string{"stats"},
+		"hosts":      []string{server.URL},
+	})
+	events, err := f.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []common.MapStr{
+		{
+			"requests": common.MapStr{
+				"total":   int64(1234),
+				"latency": 2.5,
+			},
+			"up":      true,
+			"version": "1.2.3",
+		},
+	}, events)
+}
+
+func TestFetchSplit(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	f := mbtest.NewEventsFetcher(t, map[string]interface{}{
+		"module":     "myservice",
+		"metricsets": []string{"queues"},
+		"hosts":      []string{server.URL},
+	})
+	events, err := f.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []common.MapStr{
+		{"_namespace": "queue", "name": "a", "messages": int64(3)},
+		{"_namespace": "queue", "name": "b", "messages": int64(7)},
+	}, events)
+}
+
+func TestFetchMissingFields(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	// The path of the definition is overridden by the path of the config.
+	f := mbtest.NewEventsFetcher(t, map[string]interface{}{
+		"module":     "myservice",
+		"metricsets": []string{"stats"},
+		"hosts":      []string{server.URL},
+		"path":       "/api/missing",
+	})
+	events, err := f.Fetch()
+	assert.Len(t, events, 1)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "version")
+	}
+}
+
+func TestSplitDocument(t *testing.T) {
+	m := &MetricSet{}
+
+	items, err := m.splitDocument([]interface{}{
+		map[string]interface{}{"name": "a"},
+		map[string]interface{}{"name": "b"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, items, 2)
+
+	_, err = m.splitDocument([]interface{}{"a", "b"})
+	assert.Error(t, err)
+
+	m.split = "items"
+	_, err = m.splitDocument(map[string]interface{}{"items": map[string]interface{}{}})
+	assert.Error(t, err)
+
+	_, err = m.splitDocument(map[string]interface{}{})
+	assert.Error(t, err)
+}
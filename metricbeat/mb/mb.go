@@ -77,6 +77,35 @@ type Closer interface {
 	Close() error
 }
 
+// CounterMetricSet is an optional interface that a MetricSet can implement to
+// declare the monotonically increasing counters of its events. The per-second
+// rates of the counters are added to the events by the framework.
+type CounterMetricSet interface {
+	MetricSet
+	Counters() Counters
+}
+
+// Counters declares the counter fields of the events of a MetricSet. The rate
+// of each counter is added in a field named after it with the RateSuffix, like
+// read.bytes_per_sec for read.bytes. No rate is reported for the first event
+// of an entity, or when the counter was reset.
+type Counters struct {
+	// Fields are the names of the counter fields, in dotted notation.
+	Fields []string
+
+	// Keys are the names of the fields identifying the entity of an event, like
+	// the name of a disk. The rates are computed between the events of the same
+	// entity and host.
+	Keys []string
+
+	// Derive is an optional function called on each event after its rates were
+	// added, to add the metrics derived from the rates.
+	Derive func(event common.MapStr)
+}
+
+// RateSuffix is the suffix of the name of the rate fields of the counters.
+const RateSuffix = "_per_sec"
+
 // EventFetcher is a MetricSet that returns a single event when collecting data.
 // Use ReportingMetricSet for new MetricSet implementations.
 type EventFetcher interface {
@@ -208,16 +237,26 @@ type ModuleConfig struct {
 	Enabled    bool                    `config:"enabled"`
 	Filters    processors.PluginConfig `config:"filters"`
 	Raw        bool                    `config:"raw"`
+	Jitter     time.Duration           `config:"jitter"     validate:"positive"`
+	Backoff    BackoffConfig           `config:"backoff"`
 
 	common.EventMetadata `config:",inline"` // Fields and tags to add to events.
 }
 
+// BackoffConfig configures the delay of the fetches of the hosts that keep
+// failing. The delay is doubled after each consecutive failed fetch, from the
+// period up to the maximum.
+type BackoffConfig struct {
+	Enabled bool          `config:"enabled"`
+	Max     time.Duration `config:"max" validate:"nonzero,positive"`
+}
+
 func (c ModuleConfig) String() string {
 	return fmt.Sprintf(`{Module:"%v", MetricSets:%v, Enabled:%v, `+
-		`Hosts:[%v hosts], Period:"%v", Timeout:"%v", Raw:%v, Fields:%v, `+
-		`FieldsUnderRoot:%v, Tags:%v}`,
+		`Hosts:[%v hosts], Period:"%v", Timeout:"%v", Raw:%v, Jitter:"%v", `+
+		`Backoff:%+v, Fields:%v, FieldsUnderRoot:%v, Tags:%v}`,
 		c.Module, c.MetricSets, c.Enabled, len(c.Hosts), c.Period, c.Timeout,
-		c.Raw, c.Fields, c.FieldsUnderRoot, c.Tags)
+		c.Raw, c.Jitter, c.Backoff, c.Fields, c.FieldsUnderRoot, c.Tags)
 }
 
 func (c ModuleConfig) GoString() string { return c.String() }
@@ -226,6 +265,9 @@ func (c ModuleConfig) GoString() string { return c.String() }
 var defaultModuleConfig = ModuleConfig{
 	Enabled: true,
 	Period:  time.Second * 10,
+	Backoff: BackoffConfig{
+		Max: time.Minute * 5,
+	},
 }
 
 // DefaultModuleConfig returns a ModuleConfig with the default values populated.
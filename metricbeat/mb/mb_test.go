@@ -90,6 +90,7 @@ func TestModuleConfig(t *testing.T) {
 				Enabled:    true,
 				Period:     time.Second * 10,
 				Timeout:    0,
+				Backoff:    BackoffConfig{Max: time.Minute * 5},
 			},
 		},
 		{
@@ -108,6 +109,22 @@ func TestModuleConfig(t *testing.T) {
 			},
 			err: "negative value accessing 'timeout'",
 		},
+		{
+			in: map[string]interface{}{
+				"module":     "example",
+				"metricsets": []string{"test"},
+				"jitter":     -1,
+			},
+			err: "negative value accessing 'jitter'",
+		},
+		{
+			in: map[string]interface{}{
+				"module":      "example",
+				"metricsets":  []string{"test"},
+				"backoff.max": 0,
+			},
+			err: "zero value accessing 'backoff.max'",
+		},
 	}
 
 	for i, test := range tests {
@@ -156,6 +173,9 @@ func TestModuleConfigDefaults(t *testing.T) {
 	assert.Equal(t, true, mc.Enabled)
 	assert.Equal(t, time.Second*10, mc.Period)
 	assert.Equal(t, time.Second*0, mc.Timeout)
+	assert.Equal(t, time.Second*0, mc.Jitter)
+	assert.Equal(t, false, mc.Backoff.Enabled)
+	assert.Equal(t, time.Minute*5, mc.Backoff.Max)
 	assert.Empty(t, mc.Hosts)
 }
 
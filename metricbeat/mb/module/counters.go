@@ -0,0 +1,136 @@
+package module
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+// counterTTLPeriods is the number of periods after which the last sample of an
+// entity that is no longer reported is dropped.
+const counterTTLPeriods = 3
+
+// counterRates computes the rates of the counters of a MetricSet between the
+// successive events of each entity.
+type counterRates struct {
+	sync.Mutex
+	counters   mb.Counters
+	ttl        time.Duration
+	samples    map[string]counterSample
+	lastExpire time.Time
+}
+
+// counterSample is the last values of the counters of an entity.
+type counterSample struct {
+	timestamp time.Time
+	values    map[string]float64
+}
+
+func newCounterRates(counters mb.Counters, period time.Duration) *counterRates {
+	return &counterRates{
+		counters: counters,
+		ttl:      counterTTLPeriods * period,
+		samples:  map[string]counterSample{},
+	}
+}
+
+// apply adds the rates of the counters to the event, and stores its values for
+// the next event of the same entity.
+func (c *counterRates) apply(event common.MapStr, timestamp time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	key := c.entityKey(event)
+	prev, hasPrev := c.samples[key]
+	elapsed := timestamp.Sub(prev.timestamp).Seconds()
+
+	sample := counterSample{
+		timestamp: timestamp,
+		values:    make(map[string]float64, len(c.counters.Fields)),
+	}
+	for _, field := range c.counters.Fields {
+		v, err := event.GetValue(field)
+		if err != nil {
+			continue
+		}
+		value, ok := toFloat(v)
+		if !ok {
+			continue
+		}
+		sample.values[field] = value
+
+		if !hasPrev || elapsed <= 0 {
+			continue
+		}
+		prevValue, found := prev.values[field]
+		if !found || value < prevValue {
+			// The counter appeared or was reset, the rate is unknown until
+			// the next event.
+			continue
+		}
+		event.Put(field+mb.RateSuffix, (value-prevValue)/elapsed)
+	}
+	c.samples[key] = sample
+
+	if c.counters.Derive != nil {
+		c.counters.Derive(event)
+	}
+
+	c.expire(timestamp)
+}
+
+// entityKey returns the values of the key fields of the event.
+func (c *counterRates) entityKey(event common.MapStr) string {
+	if len(c.counters.Keys) == 0 {
+		return ""
+	}
+
+	values := make([]string, len(c.counters.Keys))
+	for i, field := range c.counters.Keys {
+		if v, err := event.GetValue(field); err == nil {
+			values[i] = fmt.Sprint(v)
+		}
+	}
+	return strings.Join(values, "\x00")
+}
+
+// expire drops the samples of the entities that were not reported during the
+// ttl, at most once per ttl.
+func (c *counterRates) expire(now time.Time) {
+	if c.ttl <= 0 || now.Sub(c.lastExpire) < c.ttl {
+		return
+	}
+	c.lastExpire = now
+
+	for key, sample := range c.samples {
+		if now.Sub(sample.timestamp) > c.ttl {
+			delete(c.samples, key)
+		}
+	}
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,113 @@
+// +build !integration
+
+package module
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+func TestCounterRates(t *testing.T) {
+	c := newCounterRates(mb.Counters{
+		Fields: []string{"read.bytes", "read.count"},
+		Keys:   []string{"name"},
+	}, 10*time.Second)
+
+	start := time.Unix(1500000000, 0)
+	event := func(name string, bytes, count interface{}) common.MapStr {
+		return common.MapStr{
+			"name": name,
+			"read": common.MapStr{"bytes": bytes, "count": count},
+		}
+	}
+
+	// First sample of each entity, no rates.
+	sda := event("sda", uint64(1000), int64(10))
+	c.apply(sda, start)
+	assert.Equal(t, event("sda", uint64(1000), int64(10)), sda)
+
+	sdb := event("sdb", uint64(5000), int64(50))
+	c.apply(sdb, start)
+	assert.Equal(t, event("sdb", uint64(5000), int64(50)), sdb)
+
+	// Rates are computed per entity.
+	sda = event("sda", uint64(3000), int64(30))
+	c.apply(sda, start.Add(10*time.Second))
+	assert.Equal(t, 200.0, sda["read"].(common.MapStr)["bytes_per_sec"])
+	assert.Equal(t, 2.0, sda["read"].(common.MapStr)["count_per_sec"])
+
+	sdb = event("sdb", uint64(5500), int64(50))
+	c.apply(sdb, start.Add(5*time.Second))
+	assert.Equal(t, 100.0, sdb["read"].(common.MapStr)["bytes_per_sec"])
+	assert.Equal(t, 0.0, sdb["read"].(common.MapStr)["count_per_sec"])
+
+	// A reset counter has no rate, the other counters still do.
+	sda = event("sda", uint64(100), int64(40))
+	c.apply(sda, start.Add(20*time.Second))
+	assert.NotContains(t, sda["read"], "bytes_per_sec")
+	assert.Equal(t, 1.0, sda["read"].(common.MapStr)["count_per_sec"])
+
+	// The rate restarts from the reset value.
+	sda = event("sda", uint64(1100), int64(50))
+	c.apply(sda, start.Add(30*time.Second))
+	assert.Equal(t, 100.0, sda["read"].(common.MapStr)["bytes_per_sec"])
+}
+
+func TestCounterRatesMissingFields(t *testing.T) {
+	c := newCounterRates(mb.Counters{Fields: []string{"in.bytes", "in.errors"}}, 10*time.Second)
+	start := time.Unix(1500000000, 0)
+
+	c.apply(common.MapStr{"in": common.MapStr{"bytes": 10, "errors": "n/a"}}, start)
+
+	// Counters that are missing, or were missing before, have no rate.
+	event := common.MapStr{"in": common.MapStr{"errors": 4}}
+	c.apply(event, start.Add(time.Second))
+	assert.Equal(t, common.MapStr{"in": common.MapStr{"errors": 4}}, event)
+
+	event = common.MapStr{"in": common.MapStr{"bytes": 30, "errors": 6}}
+	c.apply(event, start.Add(2*time.Second))
+	assert.Equal(t, common.MapStr{"in": common.MapStr{"bytes": 30, "errors": 6, "errors_per_sec": 2.0}}, event)
+
+	// Events with the same timestamp have no rate.
+	event = common.MapStr{"in": common.MapStr{"bytes": 40}}
+	c.apply(event, start.Add(2*time.Second))
+	assert.Equal(t, common.MapStr{"in": common.MapStr{"bytes": 40}}, event)
+}
+
+func TestCounterRatesDerive(t *testing.T) {
+	var derived []common.MapStr
+	c := newCounterRates(mb.Counters{
+		Fields: []string{"count"},
+		Derive: func(event common.MapStr) { derived = append(derived, event) },
+	}, 10*time.Second)
+	start := time.Unix(1500000000, 0)
+
+	c.apply(common.MapStr{"count": 1}, start)
+	c.apply(common.MapStr{"count": 3}, start.Add(time.Second))
+
+	assert.Equal(t, []common.MapStr{
+		{"count": 1},
+		{"count": 3, "count_per_sec": 2.0},
+	}, derived)
+}
+
+func TestCounterRatesExpire(t *testing.T) {
+	c := newCounterRates(mb.Counters{Fields: []string{"count"}, Keys: []string{"name"}}, 10*time.Second)
+	start := time.Unix(1500000000, 0)
+
+	c.apply(common.MapStr{"name": "a", "count": 1}, start)
+	c.apply(common.MapStr{"name": "b", "count": 1}, start)
+	for i := 1; i <= 7; i++ {
+		c.apply(common.MapStr{"name": "a", "count": 1 + i}, start.Add(time.Duration(i)*10*time.Second))
+	}
+
+	// b was not reported for more than 3 periods, and is dropped by the
+	// expiration that runs once every 3 periods.
+	assert.Len(t, c.samples, 1)
+	assert.Contains(t, c.samples, "a")
+}
@@ -0,0 +1,103 @@
+package module
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+// FetchResult is the result of a single fetch of a MetricSet for one host.
+type FetchResult struct {
+	MetricSet string          // Name of the MetricSet, prefixed by the module name.
+	Host      string          // Host of the MetricSet, if any.
+	Duration  time.Duration   // Duration of the fetch.
+	Events    []common.MapStr // Events built from the fetch, including the error events.
+	Errors    int             // Number of error events.
+}
+
+// FetchOnce runs a single fetch of each MetricSet of the module and returns the
+// events that would be published. PushMetricSets are run until they report
+// their first event, or for one period at most. The MetricSets are closed
+// afterwards.
+//
+// FetchOnce is used to test the configuration of a module, it must not be
+// called on a Wrapper that was started.
+func (mw *Wrapper) FetchOnce() []FetchResult {
+	results := make([]FetchResult, 0, len(mw.metricSets))
+	for _, msw := range mw.metricSets {
+		results = append(results, msw.fetchOnce())
+		if err := msw.close(); err != nil {
+			logp.Err("Error closing %s: %v", msw, err)
+		}
+		msw.stats.releaseHostHealth(msw.Host())
+		releaseStats(msw.stats)
+	}
+	return results
+}
+
+func (msw *metricSetWrapper) fetchOnce() FetchResult {
+	result := FetchResult{
+		MetricSet: msw.module.Name() + "/" + msw.Name(),
+		Host:      msw.Host(),
+	}
+
+	done := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() { stopOnce.Do(func() { close(done) }) }
+
+	out := make(chan common.MapStr)
+	reporter := &eventReporter{
+		msw:  msw,
+		out:  out,
+		done: done,
+	}
+
+	var timeout <-chan time.Time
+	ms, isPush := msw.MetricSet.(mb.PushMetricSet)
+	if isPush {
+		timer := time.NewTimer(msw.Module().Config().Period)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	start := time.Now()
+	go func() {
+		defer close(out)
+		defer logp.Recover(fmt.Sprintf("recovered from panic while fetching "+
+			"'%s/%s' for host '%s'", msw.module.Name(), msw.Name(), msw.Host()))
+
+		if isPush {
+			ms.Run(reporter)
+		} else {
+			msw.fetch(reporter)
+		}
+	}()
+
+	for {
+		select {
+		case event, ok := <-out:
+			if !ok {
+				result.Duration = time.Since(start)
+				return result
+			}
+			if isPush {
+				stop()
+			}
+			// The event is nil if it was dropped by the filters.
+			if event == nil {
+				continue
+			}
+			if _, failed := event["error"]; failed {
+				result.Errors++
+			}
+			result.Events = append(result.Events, event)
+		case <-timeout:
+			timeout = nil
+			stop()
+		}
+	}
+}
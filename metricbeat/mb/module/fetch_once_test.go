@@ -0,0 +1,39 @@
+// +build !integration
+
+package module_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/metricbeat/mb/module"
+)
+
+func TestFetchOnce(t *testing.T) {
+	c := newConfig(t, map[string]interface{}{
+		"module":     moduleName,
+		"metricsets": []string{eventFetcherName, reportingFetcherName, pushMetricSetName},
+		"hosts":      []string{"alpha", "beta"},
+	})
+
+	m, err := module.NewWrapper(c, newTestRegistry(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := m.FetchOnce()
+	if !assert.Len(t, results, 6) {
+		return
+	}
+
+	for _, result := range results {
+		assert.Contains(t, []string{"alpha", "beta"}, result.Host)
+		assert.Contains(t, result.MetricSet, moduleName+"/")
+		assert.Equal(t, 0, result.Errors)
+		if assert.Len(t, result.Events, 1, result.MetricSet) {
+			host, _ := result.Events[0].GetValue("metricset.host")
+			assert.Equal(t, result.Host, host)
+		}
+	}
+}
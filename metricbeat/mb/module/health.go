@@ -0,0 +1,106 @@
+package module
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/monitoring"
+)
+
+// hostHealth is the health of the fetches of a MetricSet for a host.
+type hostHealth struct {
+	sync.Mutex
+	ref               uint32    // number of metricsets reusing the instance
+	lastSuccess       time.Time // time of the last successful fetch
+	lastError         string    // error of the last failed fetch
+	consecutiveErrors int64     // number of failed fetches since the last success
+}
+
+// success records a successful fetch.
+func (h *hostHealth) success(t time.Time) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.lastSuccess = t
+	h.consecutiveErrors = 0
+}
+
+// failure records a failed fetch, and returns the number of consecutive
+// failed fetches.
+func (h *hostHealth) failure(err string) int64 {
+	h.Lock()
+	defer h.Unlock()
+
+	h.lastError = err
+	h.consecutiveErrors++
+	return h.consecutiveErrors
+}
+
+func (h *hostHealth) visit(vs monitoring.Visitor) {
+	h.Lock()
+	defer h.Unlock()
+
+	vs.OnRegistryStart()
+	defer vs.OnRegistryFinished()
+
+	monitoring.ReportInt(vs, "consecutive_errors", h.consecutiveErrors)
+	if !h.lastSuccess.IsZero() {
+		monitoring.ReportString(vs, "last_success", h.lastSuccess.UTC().Format(time.RFC3339))
+	}
+	if h.lastError != "" {
+		monitoring.ReportString(vs, "last_error", h.lastError)
+	}
+}
+
+// getHostHealth returns the health of a host of the MetricSet stats. It must
+// be released with releaseHostHealth.
+func (s *stats) getHostHealth(host string) *hostHealth {
+	s.hostsLock.Lock()
+	defer s.hostsLock.Unlock()
+
+	h := s.hosts[host]
+	if h == nil {
+		h = &hostHealth{}
+		s.hosts[host] = h
+	}
+	h.ref++
+	return h
+}
+
+func (s *stats) releaseHostHealth(host string) {
+	s.hostsLock.Lock()
+	defer s.hostsLock.Unlock()
+
+	h := s.hosts[host]
+	if h == nil {
+		return
+	}
+	h.ref--
+	if h.ref == 0 {
+		delete(s.hosts, host)
+	}
+}
+
+// visitHosts reports the health of the hosts of the MetricSet, keyed by host.
+// The MetricSets without host are not reported.
+func (s *stats) visitHosts(_ monitoring.Mode, vs monitoring.Visitor) {
+	s.hostsLock.Lock()
+	defer s.hostsLock.Unlock()
+
+	hosts := make([]string, 0, len(s.hosts))
+	for host := range s.hosts {
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+
+	vs.OnRegistryStart()
+	defer vs.OnRegistryFinished()
+
+	for _, host := range hosts {
+		vs.OnKey(host)
+		s.hosts[host].visit(vs)
+	}
+}
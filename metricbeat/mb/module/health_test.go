@@ -0,0 +1,72 @@
+// +build !integration
+
+package module
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/monitoring"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+func TestFetchDelay(t *testing.T) {
+	config := mb.ModuleConfig{
+		Period: 10 * time.Second,
+		Backoff: mb.BackoffConfig{
+			Enabled: true,
+			Max:     time.Minute,
+		},
+	}
+
+	for failures, expected := range []time.Duration{
+		10 * time.Second,
+		10 * time.Second,
+		20 * time.Second,
+		40 * time.Second,
+		time.Minute,
+		time.Minute,
+	} {
+		assert.Equal(t, expected, fetchDelay(config, int64(failures)), "%d failures", failures)
+	}
+
+	// The maximum doesn't shorten the period.
+	config.Backoff.Max = time.Second
+	assert.Equal(t, 10*time.Second, fetchDelay(config, 3))
+
+	config.Backoff.Enabled = false
+	assert.Equal(t, 10*time.Second, fetchDelay(config, 3))
+}
+
+func TestHostHealth(t *testing.T) {
+	s := getMetricSetStats("health", "test")
+	defer releaseStats(s)
+
+	alpha := s.getHostHealth("alpha")
+	beta := s.getHostHealth("beta")
+	local := s.getHostHealth("")
+	defer s.releaseHostHealth("")
+
+	assert.Equal(t, int64(1), alpha.failure("timeout"))
+	assert.Equal(t, int64(2), alpha.failure("connection refused"))
+	beta.failure("timeout")
+	beta.success(time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC))
+	local.failure("timeout")
+
+	snapshot := monitoring.CollectFlatSnapshot(monitoring.Default, monitoring.Full, false)
+	assert.Equal(t, int64(2), snapshot.Ints["metricbeat.health.test.hosts.alpha.consecutive_errors"])
+	assert.Equal(t, "connection refused", snapshot.Strings["metricbeat.health.test.hosts.alpha.last_error"])
+	assert.Equal(t, int64(0), snapshot.Ints["metricbeat.health.test.hosts.beta.consecutive_errors"])
+	assert.Equal(t, "2017-07-01T12:00:00Z", snapshot.Strings["metricbeat.health.test.hosts.beta.last_success"])
+	assert.NotContains(t, snapshot.Ints, "metricbeat.health.test.hosts..consecutive_errors",
+		"the MetricSets without host are not reported")
+
+	// The health of a host is removed when it is released.
+	s.releaseHostHealth("alpha")
+	s.releaseHostHealth("beta")
+	snapshot = monitoring.CollectFlatSnapshot(monitoring.Default, monitoring.Full, false)
+	assert.NotContains(t, snapshot.Ints, "metricbeat.health.test.hosts.alpha.consecutive_errors")
+	assert.NotContains(t, snapshot.Ints, "metricbeat.health.test.hosts.beta.consecutive_errors")
+}
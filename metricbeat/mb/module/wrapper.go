@@ -2,6 +2,7 @@ package module
 
 import (
 	"fmt"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -21,6 +22,7 @@ const (
 	successesKey = "success"
 	failuresKey  = "failures"
 	eventsKey    = "events"
+	hostsKey     = "hosts"
 )
 
 var (
@@ -45,8 +47,10 @@ type Wrapper struct {
 // running the MetricSet. It contains a pointer to the parent Module.
 type metricSetWrapper struct {
 	mb.MetricSet
-	module *Wrapper // Parent Module.
-	stats  *stats   // stats for this MetricSet.
+	module   *Wrapper      // Parent Module.
+	stats    *stats        // stats for this MetricSet.
+	health   *hostHealth   // Health of the fetches of the host.
+	counters *counterRates // Rates of the counters, if the MetricSet has any.
 }
 
 // stats bundles common metricset stats.
@@ -56,6 +60,9 @@ type stats struct {
 	success  *monitoring.Int // Total success events.
 	failures *monitoring.Int // Total error events.
 	events   *monitoring.Int // Total events published.
+
+	hostsLock sync.Mutex
+	hosts     map[string]*hostHealth // Health of the hosts of the MetricSet.
 }
 
 // NewWrapper create a new Module and its associated MetricSets based
@@ -110,6 +117,10 @@ func NewWrappers(modulesConfig []*common.Config, r *mb.Register) ([]*Wrapper, er
 				module:    mw,
 				stats:     getMetricSetStats(mw.Name(), ms.Name()),
 			}
+			msw.health = msw.stats.getHostHealth(ms.Host())
+			if cms, ok := ms.(mb.CounterMetricSet); ok {
+				msw.counters = newCounterRates(cms.Counters(), k.Config().Period)
+			}
 			msws = append(msws, msw)
 		}
 		mw.metricSets = msws
@@ -141,6 +152,7 @@ func (mw *Wrapper) Start(done <-chan struct{}) <-chan common.MapStr {
 	for _, msw := range mw.metricSets {
 		go func(msw *metricSetWrapper) {
 			defer releaseStats(msw.stats)
+			defer msw.stats.releaseHostHealth(msw.Host())
 			defer wg.Done()
 			defer msw.close()
 			msw.run(done, out)
@@ -191,18 +203,17 @@ func (msw *metricSetWrapper) run(done <-chan struct{}, out chan<- common.MapStr)
 	debugf("Starting %s", msw)
 	defer debugf("Stopped %s", msw)
 
-	// Events and errors are reported through this.
-	reporter := &eventReporter{
-		msw:  msw,
-		out:  out,
-		done: done,
-	}
-
 	switch ms := msw.MetricSet.(type) {
 	case mb.PushMetricSet:
+		// Events and errors are reported through this.
+		reporter := &eventReporter{
+			msw:  msw,
+			out:  out,
+			done: done,
+		}
 		ms.Run(reporter)
 	case mb.EventFetcher, mb.EventsFetcher, mb.ReportingMetricSet:
-		msw.startPeriodicFetching(reporter)
+		msw.startPeriodicFetching(done, out)
 	default:
 		// Earlier startup stages prevent this from happening.
 		logp.Err("MetricSet '%s/%s' does not implement an event producing interface",
@@ -210,26 +221,116 @@ func (msw *metricSetWrapper) run(done <-chan struct{}, out chan<- common.MapStr)
 	}
 }
 
-// startPeriodicFetching performs an immediate fetch for the MetricSet then it
-// begins a continuous timer scheduled loop to fetch data. To stop the loop the
-// done channel should be closed.
-func (msw *metricSetWrapper) startPeriodicFetching(reporter *eventReporter) {
-	// Fetch immediately.
-	msw.fetch(reporter)
+// startPeriodicFetching performs a fetch for the MetricSet after a random
+// delay of up to the jitter of the module, then it begins a continuous timer
+// scheduled loop to fetch data. When backoff is enabled, the fetches of a host
+// that keeps failing are delayed. To stop the loop the done channel should be
+// closed.
+func (msw *metricSetWrapper) startPeriodicFetching(done <-chan struct{}, out chan<- common.MapStr) {
+	config := msw.Module().Config()
+
+	if config.Jitter > 0 {
+		if !wait(done, time.Duration(rand.Int63n(int64(config.Jitter)))) {
+			return
+		}
+	}
+
+	var running <-chan struct{}
+	defer func() {
+		// The MetricSet is closed after the return, wait for the last fetch.
+		if running != nil {
+			<-running
+		}
+	}()
 
-	// Start timer for future fetches.
-	t := time.NewTicker(msw.Module().Config().Period)
-	defer t.Stop()
 	for {
+		start := time.Now()
+		var failures int64
+		running, failures = msw.fetchWithTimeout(done, out)
+
+		delay := fetchDelay(config, failures)
+		if delay != config.Period {
+			debugf("Delaying the next fetch of %s by %v after %d failed fetches",
+				msw, delay, failures)
+		}
+		if !wait(done, delay-time.Since(start)) {
+			return
+		}
+
+		// Don't start a fetch while the previous one, which timed out, is
+		// still running.
 		select {
-		case <-reporter.done:
+		case <-done:
 			return
-		case <-t.C:
-			msw.fetch(reporter)
+		case <-running:
 		}
 	}
 }
 
+// fetchWithTimeout performs a fetch, and reports an error if the fetch doesn't
+// complete within the timeout of the module. The events reported by the fetch
+// after the timeout are dropped. It returns a channel that is closed when the
+// fetch completes, and the number of consecutive failed fetches of the host.
+func (msw *metricSetWrapper) fetchWithTimeout(done <-chan struct{}, out chan<- common.MapStr) (<-chan struct{}, int64) {
+	// Closing cancel stops the reporting of the fetch.
+	cancel := make(chan struct{})
+	reporter := &eventReporter{
+		msw:  msw,
+		out:  out,
+		done: cancel,
+	}
+
+	start := time.Now()
+	running := make(chan struct{})
+	go func() {
+		defer close(running)
+		defer logp.Recover(fmt.Sprintf("recovered from panic while fetching "+
+			"'%s/%s' for host '%s'", msw.module.Name(), msw.Name(), msw.Host()))
+
+		msw.fetch(reporter)
+	}()
+
+	timeout := msw.Module().Config().Timeout
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-running:
+	case <-done:
+		close(cancel)
+		return running, 0
+	case <-timer.C:
+		close(cancel)
+
+		err := fmt.Errorf("fetch timed out after %v", timeout)
+		reporter.recordError(err)
+		failures := msw.recordHealth(reporter)
+
+		timeoutReporter := &eventReporter{
+			msw:   msw,
+			out:   out,
+			done:  done,
+			start: start,
+		}
+		timeoutReporter.Error(err)
+		return running, failures
+	}
+
+	return running, msw.recordHealth(reporter)
+}
+
+// recordHealth records the result of a fetch in the health of the host, and
+// returns the number of consecutive failed fetches. A fetch failed if it
+// reported errors and no events.
+func (msw *metricSetWrapper) recordHealth(reporter *eventReporter) int64 {
+	events, errs, lastErr := reporter.result()
+	if errs > 0 && events == 0 {
+		return msw.health.failure(lastErr)
+	}
+	msw.health.success(time.Now())
+	return 0
+}
+
 // fetch invokes the appropriate Fetch method for the MetricSet and publishes
 // the result using the publisher client. This method will recover from panics
 // and log a stack track if one occurs.
@@ -294,6 +395,11 @@ type eventReporter struct {
 	done  <-chan struct{}
 	out   chan<- common.MapStr
 	start time.Time // Start time of the current fetch (or zero for push sources).
+
+	resultLock sync.Mutex
+	events     int    // Number of events reported without error.
+	errors     int    // Number of errors reported.
+	lastErr    string // Last error reported.
 }
 
 // startFetchTimer demarcates the start of a new fetch. The elapsed time of a
@@ -330,8 +436,13 @@ func (r *eventReporter) ErrorWith(err error, meta common.MapStr) bool {
 
 	if err == nil {
 		r.msw.stats.success.Add(1)
+		r.recordEvent()
+		if r.msw.counters != nil {
+			r.msw.counters.apply(meta, eventTime(meta, timestamp))
+		}
 	} else {
 		r.msw.stats.failures.Add(1)
+		r.recordError(err)
 	}
 
 	event, err := createEvent(r.msw, meta, err, timestamp, elapsed)
@@ -347,9 +458,85 @@ func (r *eventReporter) ErrorWith(err error, meta common.MapStr) bool {
 	return true
 }
 
+func (r *eventReporter) recordEvent() {
+	r.resultLock.Lock()
+	defer r.resultLock.Unlock()
+	r.events++
+}
+
+func (r *eventReporter) recordError(err error) {
+	r.resultLock.Lock()
+	defer r.resultLock.Unlock()
+	r.errors++
+	r.lastErr = err.Error()
+}
+
+// result returns the number of events and errors reported, and the last error.
+func (r *eventReporter) result() (events, errors int, lastErr string) {
+	r.resultLock.Lock()
+	defer r.resultLock.Unlock()
+	return r.events, r.errors, r.lastErr
+}
+
 // other utility functions
 
+// fetchDelay returns the delay until the next fetch. The period is doubled
+// for each consecutive failed fetch after the first one, up to the maximum
+// backoff, when backoff is enabled.
+func fetchDelay(config mb.ModuleConfig, failures int64) time.Duration {
+	delay := config.Period
+	if !config.Backoff.Enabled {
+		return delay
+	}
+
+	for i := int64(1); i < failures && delay < config.Backoff.Max; i++ {
+		delay *= 2
+	}
+	if delay > config.Backoff.Max && config.Backoff.Max > config.Period {
+		delay = config.Backoff.Max
+	}
+	return delay
+}
+
+// wait waits for the duration, and returns false if the done channel was
+// closed meanwhile.
+func wait(done <-chan struct{}, d time.Duration) bool {
+	if d <= 0 {
+		select {
+		case <-done:
+			return false
+		default:
+			return true
+		}
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-done:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// eventTime returns the timestamp set by the MetricSet in the event, or the
+// given default.
+func eventTime(event common.MapStr, defaultTime time.Time) time.Time {
+	if ts, ok := event["@timestamp"].(common.Time); ok {
+		return time.Time(ts)
+	}
+	return defaultTime
+}
+
 func writeEvent(done <-chan struct{}, out chan<- common.MapStr, event common.MapStr) bool {
+	// Don't write the event if done was already closed, even if out is ready.
+	select {
+	case <-done:
+		return false
+	default:
+	}
+
 	select {
 	case <-done:
 		return false
@@ -376,7 +563,9 @@ func getMetricSetStats(module, name string) *stats {
 		success:  monitoring.NewInt(reg, successesKey),
 		failures: monitoring.NewInt(reg, failuresKey),
 		events:   monitoring.NewInt(reg, eventsKey),
+		hosts:    map[string]*hostHealth{},
 	}
+	monitoring.NewFunc(reg, hostsKey, s.visitHosts)
 
 	fetches[key] = s
 	return s
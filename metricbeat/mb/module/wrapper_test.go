@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/monitoring"
 	"github.com/elastic/beats/metricbeat/mb"
 	"github.com/elastic/beats/metricbeat/mb/module"
 
@@ -18,6 +19,7 @@ const (
 	eventFetcherName     = "EventFetcher"
 	reportingFetcherName = "ReportingFetcher"
 	pushMetricSetName    = "PushMetricSet"
+	slowFetcherName      = "SlowFetcher"
 )
 
 // fakeMetricSet
@@ -32,6 +34,9 @@ func init() {
 	if err := mb.Registry.AddMetricSet(moduleName, pushMetricSetName, newFakePushMetricSet); err != nil {
 		panic(err)
 	}
+	if err := mb.Registry.AddMetricSet(moduleName, slowFetcherName, newFakeSlowFetcher); err != nil {
+		panic(err)
+	}
 }
 
 // EventFetcher
@@ -85,6 +90,21 @@ func newFakePushMetricSet(base mb.BaseMetricSet) (mb.MetricSet, error) {
 	return &fakePushMetricSet{BaseMetricSet: base}, nil
 }
 
+// SlowFetcher
+
+type fakeSlowFetcher struct {
+	mb.BaseMetricSet
+}
+
+func (ms *fakeSlowFetcher) Fetch() (common.MapStr, error) {
+	time.Sleep(time.Second)
+	return common.MapStr{"metric": 1}, nil
+}
+
+func newFakeSlowFetcher(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	return &fakeSlowFetcher{BaseMetricSet: base}, nil
+}
+
 // test utilities
 
 func newTestRegistry(t testing.TB) *mb.Register {
@@ -99,6 +119,9 @@ func newTestRegistry(t testing.TB) *mb.Register {
 	if err := r.AddMetricSet(moduleName, pushMetricSetName, newFakePushMetricSet); err != nil {
 		t.Fatal(err)
 	}
+	if err := r.AddMetricSet(moduleName, slowFetcherName, newFakeSlowFetcher); err != nil {
+		t.Fatal(err)
+	}
 
 	return r
 }
@@ -210,3 +233,37 @@ func TestWrapperOfPushMetricSet(t *testing.T) {
 		}
 	}
 }
+
+func TestWrapperFetchTimeout(t *testing.T) {
+	c := newConfig(t, map[string]interface{}{
+		"module":     moduleName,
+		"metricsets": []string{slowFetcherName},
+		"hosts":      []string{"alpha"},
+		"timeout":    "10ms",
+	})
+
+	m, err := module.NewWrapper(c, newTestRegistry(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	output := m.Start(done)
+
+	event := <-output
+	errorMessage, _ := event.GetValue("error.message")
+	assert.Equal(t, "fetch timed out after 10ms", errorMessage)
+
+	// The health of the host is reported in the stats of the MetricSet.
+	snapshot := monitoring.CollectFlatSnapshot(monitoring.Default, monitoring.Full, false)
+	key := "metricbeat." + moduleName + ".slowfetcher.hosts.alpha"
+	assert.Equal(t, int64(1), snapshot.Ints[key+".consecutive_errors"])
+	assert.Equal(t, "fetch timed out after 10ms", snapshot.Strings[key+".last_error"])
+
+	close(done)
+
+	// The late event of the fetch is dropped.
+	for event := range output {
+		assert.Fail(t, "received unexpected event", "%v", event)
+	}
+}
@@ -123,3 +123,17 @@ func NewEventsFetcher(t testing.TB, config interface{}) mb.EventsFetcher {
 
 	return fetcher
 }
+
+// NewReportingMetricSet instantiates a new ReportingMetricSet using the given
+// configuration. The ModuleFactory and MetricSetFactory are obtained from the
+// global Registry.
+func NewReportingMetricSet(t testing.TB, config interface{}) mb.ReportingMetricSet {
+	metricSet := newMetricSet(t, config)
+
+	reportingMetricSet, ok := metricSet.(mb.ReportingMetricSet)
+	if !ok {
+		t.Fatal("MetricSet does not implement ReportingMetricSet")
+	}
+
+	return reportingMetricSet
+}
@@ -0,0 +1,4 @@
+/*
+Package graphite is a Metricbeat module that contains MetricSets.
+*/
+package graphite
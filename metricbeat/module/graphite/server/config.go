@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Default ports of the protocols.
+const (
+	plaintextPort = 2003
+	picklePort    = 2004
+)
+
+type config struct {
+	Host     string `config:"host"`
+	Port     int    `config:"port"`
+	Protocol string `config:"protocol"`
+
+	// Templates map the metric paths to the events. The first template whose
+	// filter matches is used, or the default template.
+	Templates       []*templateConfig `config:"templates"`
+	DefaultTemplate templateConfig    `config:"default_template"`
+}
+
+type templateConfig struct {
+	Filter    string            `config:"filter"`
+	Namespace string            `config:"namespace"`
+	Template  string            `config:"template"`
+	Delimiter string            `config:"delimiter"`
+	Tags      map[string]string `config:"tags"`
+}
+
+func defaultConfig() config {
+	return config{
+		Host:     "localhost",
+		Protocol: "udp",
+		DefaultTemplate: templateConfig{
+			Filter:    "*",
+			Namespace: "graphite",
+			Template:  "metric*",
+			Delimiter: ".",
+		},
+	}
+}
+
+func (c *config) Validate() error {
+	switch c.Protocol {
+	case "udp", "tcp", "pickle":
+	default:
+		return fmt.Errorf("protocol must be udp, tcp or pickle, got '%s'", c.Protocol)
+	}
+	return nil
+}
+
+func (c *templateConfig) Validate() error {
+	if c.Namespace == "" {
+		return errors.New("template namespace is required")
+	}
+	if c.Template == "" {
+		return errors.New("template is required")
+	}
+	return nil
+}
+
+// port returns the configured port, or the default port of the protocol.
+func (c *config) port() int {
+	if c.Port != 0 {
+		return c.Port
+	}
+	if c.Protocol == "pickle" {
+		return picklePort
+	}
+	return plaintextPort
+}
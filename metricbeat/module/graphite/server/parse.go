@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maximum size of a pickle message, as accepted by carbon.
+const maxPickleSize = 1024 * 1024
+
+// point is a value of a metric path.
+type point struct {
+	path      string
+	value     float64
+	timestamp time.Time
+}
+
+// parsePlaintext parses the lines of the plaintext protocol:
+//
+//	<metric path> <value> [<timestamp>]
+//
+// The current time is used if the timestamp is missing or negative. The lines
+// that can't be parsed are reported with their error.
+func parsePlaintext(data []byte, now time.Time, onError func(line []byte, err error)) []point {
+	var points []point
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		p, err := parsePlaintextLine(string(line), now)
+		if err != nil {
+			onError(line, err)
+			continue
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+func parsePlaintextLine(line string, now time.Time) (point, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return point{}, errors.New("expected metric path, value and timestamp")
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return point{}, fmt.Errorf("invalid value '%s'", fields[1])
+	}
+
+	p := point{path: fields[0], value: value, timestamp: now}
+	if len(fields) == 3 {
+		ts, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return point{}, fmt.Errorf("invalid timestamp '%s'", fields[2])
+		}
+		if ts >= 0 {
+			p.timestamp = unixTime(ts)
+		}
+	}
+	return p, nil
+}
+
+// splitPickle splits the messages of the pickle protocol, prefixed by their
+// length as a 4 bytes big endian integer.
+func splitPickle(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) < 4 {
+		return 0, nil, nil
+	}
+	size := binary.BigEndian.Uint32(data)
+	if size > maxPickleSize {
+		return 0, nil, fmt.Errorf("pickle message of %d bytes exceeds %d bytes", size, maxPickleSize)
+	}
+	end := 4 + int(size)
+	if len(data) < end {
+		return 0, nil, nil
+	}
+	return end, data[4:end], nil
+}
+
+// parsePickle decodes a message of the pickle protocol, a list of
+// (path, (timestamp, value)) tuples.
+func parsePickle(data []byte, now time.Time) ([]point, error) {
+	v, err := unpickle(data)
+	if err != nil {
+		return nil, err
+	}
+
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("pickle message is not a list")
+	}
+
+	points := make([]point, 0, len(list))
+	for _, item := range list {
+		p, err := pickledPoint(item, now)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+func pickledPoint(item interface{}, now time.Time) (point, error) {
+	tuple, ok := item.([]interface{})
+	if !ok || len(tuple) != 2 {
+		return point{}, errors.New("expected (path, (timestamp, value)) tuple")
+	}
+	path, ok := tuple[0].(string)
+	if !ok {
+		return point{}, errors.New("metric path is not a string")
+	}
+	datapoint, ok := tuple[1].([]interface{})
+	if !ok || len(datapoint) != 2 {
+		return point{}, fmt.Errorf("expected (timestamp, value) tuple for '%s'", path)
+	}
+
+	ts, err := toFloat(datapoint[0])
+	if err != nil {
+		return point{}, fmt.Errorf("invalid timestamp for '%s': %v", path, err)
+	}
+	value, err := toFloat(datapoint[1])
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return point{}, fmt.Errorf("invalid value for '%s'", path)
+	}
+
+	p := point{path: path, value: value, timestamp: now}
+	if ts >= 0 {
+		p.timestamp = unixTime(ts)
+	}
+	return p, nil
+}
+
+// toFloat converts the numbers of a pickle, carbon accepts strings too.
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("unexpected %T", v)
+	}
+}
+
+func unixTime(ts float64) time.Time {
+	sec, frac := math.Modf(ts)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
@@ -0,0 +1,541 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Opcodes of the pickle protocols 0 to 4 needed to decode lists of tuples of
+// strings and numbers. The opcodes building arbitrary objects, like GLOBAL and
+// REDUCE, are not supported on purpose, as the data comes from the network.
+const (
+	opMark           = '('
+	opStop           = '.'
+	opPop            = '0'
+	opPopMark        = '1'
+	opDup            = '2'
+	opFloat          = 'F'
+	opInt            = 'I'
+	opBinInt         = 'J'
+	opBinInt1        = 'K'
+	opLong           = 'L'
+	opBinInt2        = 'M'
+	opNone           = 'N'
+	opString         = 'S'
+	opBinString      = 'T'
+	opShortBinString = 'U'
+	opUnicode        = 'V'
+	opBinUnicode     = 'X'
+	opAppend         = 'a'
+	opGet            = 'g'
+	opBinGet         = 'h'
+	opLongBinGet     = 'j'
+	opList           = 'l'
+	opPut            = 'p'
+	opBinPut         = 'q'
+	opLongBinPut     = 'r'
+	opTuple          = 't'
+	opEmptyList      = ']'
+	opAppends        = 'e'
+	opEmptyTuple     = ')'
+	opBinFloat       = 'G'
+
+	// protocol 2
+	opProto    = 0x80
+	opTuple1   = 0x85
+	opTuple2   = 0x86
+	opTuple3   = 0x87
+	opNewTrue  = 0x88
+	opNewFalse = 0x89
+	opLong1    = 0x8a
+	opLong4    = 0x8b
+
+	// protocol 3
+	opBinBytes      = 'B'
+	opShortBinBytes = 'C'
+
+	// protocol 4
+	opShortBinUnicode = 0x8c
+	opBinUnicode8     = 0x8d
+	opBinBytes8       = 0x8e
+	opMemoize         = 0x94
+	opFrame           = 0x95
+)
+
+// Maximum nesting of the decoded lists and tuples. It also stops the lists
+// containing themselves.
+const maxPickleDepth = 32
+
+// Maximum number of items in the decoded lists and tuples. The memo allows a
+// list to be referenced many times, so a small pickle can expand to a huge
+// number of items.
+const maxPickleItems = maxPickleSize
+
+var (
+	errTruncated = errors.New("truncated pickle data")
+	errTooDeep   = errors.New("pickle data nested too deeply")
+	errTooLarge  = errors.New("pickle data expands to too many items")
+)
+
+// pickleList is a mutable list, such that the lists retrieved from the memo
+// can be appended to.
+type pickleList struct {
+	items []interface{}
+}
+
+type unpickler struct {
+	data  []byte
+	pos   int
+	stack []interface{}
+	marks []int
+	memo  map[int]interface{}
+}
+
+// unpickle decodes pickled data. The lists are decoded as []interface{},
+// the tuples as []interface{}, the strings as string, the integers as int64
+// or *big.Int, and the floats as float64.
+func unpickle(data []byte) (interface{}, error) {
+	u := &unpickler{data: data, memo: map[int]interface{}{}}
+	v, err := u.run()
+	if err != nil {
+		return nil, err
+	}
+	budget := maxPickleItems
+	return resolveLists(v, 0, &budget)
+}
+
+func (u *unpickler) run() (interface{}, error) {
+	for u.pos < len(u.data) {
+		op := u.data[u.pos]
+		u.pos++
+
+		var err error
+		switch op {
+		case opStop:
+			return u.pop()
+
+		case opProto:
+			_, err = u.read(1)
+		case opFrame:
+			_, err = u.read(8)
+
+		case opMark:
+			u.marks = append(u.marks, len(u.stack))
+		case opPop:
+			_, err = u.pop()
+		case opPopMark:
+			_, err = u.popMark()
+		case opDup:
+			var v interface{}
+			if v, err = u.top(); err == nil {
+				u.push(v)
+			}
+
+		case opNone:
+			u.push(nil)
+		case opNewTrue:
+			u.push(true)
+		case opNewFalse:
+			u.push(false)
+
+		case opInt:
+			err = u.loadInt()
+		case opLong:
+			err = u.loadLong()
+		case opBinInt:
+			var b []byte
+			if b, err = u.read(4); err == nil {
+				u.push(int64(int32(binary.LittleEndian.Uint32(b))))
+			}
+		case opBinInt1:
+			var b []byte
+			if b, err = u.read(1); err == nil {
+				u.push(int64(b[0]))
+			}
+		case opBinInt2:
+			var b []byte
+			if b, err = u.read(2); err == nil {
+				u.push(int64(binary.LittleEndian.Uint16(b)))
+			}
+		case opLong1:
+			var b []byte
+			if b, err = u.read(1); err == nil {
+				err = u.loadBinLong(int(b[0]))
+			}
+		case opLong4:
+			var n int
+			if n, err = u.readSize(4); err == nil {
+				err = u.loadBinLong(n)
+			}
+
+		case opFloat:
+			var line string
+			if line, err = u.readLine(); err == nil {
+				var f float64
+				if f, err = strconv.ParseFloat(line, 64); err == nil {
+					u.push(f)
+				}
+			}
+		case opBinFloat:
+			var b []byte
+			if b, err = u.read(8); err == nil {
+				u.push(math.Float64frombits(binary.BigEndian.Uint64(b)))
+			}
+
+		case opString:
+			err = u.loadString()
+		case opUnicode:
+			var line string
+			if line, err = u.readLine(); err == nil {
+				u.push(line)
+			}
+		case opBinString, opBinUnicode, opBinBytes:
+			err = u.loadBinString(4)
+		case opShortBinString, opShortBinUnicode, opShortBinBytes:
+			err = u.loadBinString(1)
+		case opBinUnicode8, opBinBytes8:
+			err = u.loadBinString(8)
+
+		case opEmptyList:
+			u.push(&pickleList{})
+		case opList:
+			var items []interface{}
+			if items, err = u.popMark(); err == nil {
+				u.push(&pickleList{items: items})
+			}
+		case opAppend:
+			var v interface{}
+			if v, err = u.pop(); err == nil {
+				err = u.appendToList(v)
+			}
+		case opAppends:
+			var items []interface{}
+			if items, err = u.popMark(); err == nil {
+				err = u.appendToList(items...)
+			}
+
+		case opEmptyTuple:
+			u.push([]interface{}{})
+		case opTuple:
+			var items []interface{}
+			if items, err = u.popMark(); err == nil {
+				u.push(items)
+			}
+		case opTuple1, opTuple2, opTuple3:
+			err = u.loadTuple(int(op-opTuple1) + 1)
+
+		case opPut:
+			var line string
+			if line, err = u.readLine(); err == nil {
+				var i int
+				if i, err = strconv.Atoi(line); err == nil {
+					err = u.put(i)
+				}
+			}
+		case opBinPut:
+			var b []byte
+			if b, err = u.read(1); err == nil {
+				err = u.put(int(b[0]))
+			}
+		case opLongBinPut:
+			var i int
+			if i, err = u.readSize(4); err == nil {
+				err = u.put(i)
+			}
+		case opMemoize:
+			err = u.put(len(u.memo))
+
+		case opGet:
+			var line string
+			if line, err = u.readLine(); err == nil {
+				var i int
+				if i, err = strconv.Atoi(line); err == nil {
+					err = u.get(i)
+				}
+			}
+		case opBinGet:
+			var b []byte
+			if b, err = u.read(1); err == nil {
+				err = u.get(int(b[0]))
+			}
+		case opLongBinGet:
+			var i int
+			if i, err = u.readSize(4); err == nil {
+				err = u.get(i)
+			}
+
+		default:
+			return nil, fmt.Errorf("unsupported pickle opcode 0x%02x", op)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, errTruncated
+}
+
+func (u *unpickler) read(n int) ([]byte, error) {
+	if n < 0 || len(u.data)-u.pos < n {
+		return nil, errTruncated
+	}
+	b := u.data[u.pos : u.pos+n]
+	u.pos += n
+	return b, nil
+}
+
+func (u *unpickler) readSize(n int) (int, error) {
+	b, err := u.read(n)
+	if err != nil {
+		return 0, err
+	}
+	if n == 8 {
+		size := binary.LittleEndian.Uint64(b)
+		if size > uint64(len(u.data)) {
+			return 0, errTruncated
+		}
+		return int(size), nil
+	}
+	return int(binary.LittleEndian.Uint32(b)), nil
+}
+
+func (u *unpickler) readLine() (string, error) {
+	end := bytes.IndexByte(u.data[u.pos:], '\n')
+	if end < 0 {
+		return "", errTruncated
+	}
+	line := string(u.data[u.pos : u.pos+end])
+	u.pos += end + 1
+	return line, nil
+}
+
+func (u *unpickler) push(v interface{}) {
+	u.stack = append(u.stack, v)
+}
+
+func (u *unpickler) top() (interface{}, error) {
+	if len(u.stack) == 0 {
+		return nil, errors.New("pickle stack underflow")
+	}
+	return u.stack[len(u.stack)-1], nil
+}
+
+func (u *unpickler) pop() (interface{}, error) {
+	v, err := u.top()
+	if err != nil {
+		return nil, err
+	}
+	u.stack = u.stack[:len(u.stack)-1]
+	return v, nil
+}
+
+// popMark pops the items pushed since the last mark.
+func (u *unpickler) popMark() ([]interface{}, error) {
+	if len(u.marks) == 0 {
+		return nil, errors.New("pickle mark not found")
+	}
+	mark := u.marks[len(u.marks)-1]
+	u.marks = u.marks[:len(u.marks)-1]
+	if mark > len(u.stack) {
+		// The items pushed before the mark were popped since.
+		return nil, errors.New("pickle mark below the stack")
+	}
+
+	items := make([]interface{}, len(u.stack)-mark)
+	copy(items, u.stack[mark:])
+	u.stack = u.stack[:mark]
+	return items, nil
+}
+
+func (u *unpickler) appendToList(items ...interface{}) error {
+	v, err := u.top()
+	if err != nil {
+		return err
+	}
+	list, ok := v.(*pickleList)
+	if !ok {
+		return errors.New("pickle append to a non list")
+	}
+	list.items = append(list.items, items...)
+	return nil
+}
+
+func (u *unpickler) loadTuple(n int) error {
+	if len(u.stack) < n {
+		return errors.New("pickle stack underflow")
+	}
+	items := make([]interface{}, n)
+	copy(items, u.stack[len(u.stack)-n:])
+	u.stack = u.stack[:len(u.stack)-n]
+	u.push(items)
+	return nil
+}
+
+func (u *unpickler) put(i int) error {
+	v, err := u.top()
+	if err != nil {
+		return err
+	}
+	u.memo[i] = v
+	return nil
+}
+
+func (u *unpickler) get(i int) error {
+	v, found := u.memo[i]
+	if !found {
+		return fmt.Errorf("pickle memo key %d not found", i)
+	}
+	u.push(v)
+	return nil
+}
+
+// loadInt decodes the INT opcode of protocol 0, used for the booleans too.
+func (u *unpickler) loadInt() error {
+	line, err := u.readLine()
+	if err != nil {
+		return err
+	}
+	switch line {
+	case "00":
+		u.push(false)
+		return nil
+	case "01":
+		u.push(true)
+		return nil
+	}
+	i, err := strconv.ParseInt(line, 10, 64)
+	if err != nil {
+		return err
+	}
+	u.push(i)
+	return nil
+}
+
+func (u *unpickler) loadLong() error {
+	line, err := u.readLine()
+	if err != nil {
+		return err
+	}
+	line = strings.TrimSuffix(line, "L")
+	if i, err := strconv.ParseInt(line, 10, 64); err == nil {
+		u.push(i)
+		return nil
+	}
+	i, ok := new(big.Int).SetString(line, 10)
+	if !ok {
+		return fmt.Errorf("invalid pickle long '%s'", line)
+	}
+	u.push(i)
+	return nil
+}
+
+// loadBinLong decodes a little endian two's complement integer of n bytes.
+func (u *unpickler) loadBinLong(n int) error {
+	b, err := u.read(n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		u.push(int64(0))
+		return nil
+	}
+
+	// big.Int reads big endian bytes
+	be := make([]byte, n)
+	for i := range b {
+		be[n-1-i] = b[i]
+	}
+	i := new(big.Int).SetBytes(be)
+	if b[n-1]&0x80 != 0 {
+		i.Sub(i, new(big.Int).Lsh(big.NewInt(1), uint(8*n)))
+	}
+
+	if i.BitLen() < 64 {
+		u.push(i.Int64())
+	} else {
+		u.push(i)
+	}
+	return nil
+}
+
+// loadString decodes the quoted string of the STRING opcode of protocol 0.
+func (u *unpickler) loadString() error {
+	line, err := u.readLine()
+	if err != nil {
+		return err
+	}
+	if len(line) < 2 || line[0] != line[len(line)-1] || (line[0] != '\'' && line[0] != '"') {
+		return fmt.Errorf("invalid pickle string %s", line)
+	}
+
+	// Python quotes with single quotes, Go needs double quotes
+	inner := line[1 : len(line)-1]
+	if line[0] == '\'' {
+		inner = strings.Replace(inner, `\'`, `'`, -1)
+		inner = strings.Replace(inner, `"`, `\"`, -1)
+	}
+	s, err := strconv.Unquote(`"` + inner + `"`)
+	if err != nil {
+		return fmt.Errorf("invalid pickle string %s: %v", line, err)
+	}
+	u.push(s)
+	return nil
+}
+
+func (u *unpickler) loadBinString(sizeLen int) error {
+	var n int
+	if sizeLen == 1 {
+		b, err := u.read(1)
+		if err != nil {
+			return err
+		}
+		n = int(b[0])
+	} else {
+		var err error
+		if n, err = u.readSize(sizeLen); err != nil {
+			return err
+		}
+	}
+
+	b, err := u.read(n)
+	if err != nil {
+		return err
+	}
+	u.push(string(b))
+	return nil
+}
+
+// resolveLists replaces the mutable lists by slices. budget is the number of
+// items that can still be resolved.
+func resolveLists(v interface{}, depth int, budget *int) (interface{}, error) {
+	var items []interface{}
+	switch v := v.(type) {
+	case *pickleList:
+		items = v.items
+	case []interface{}:
+		items = v
+	default:
+		return v, nil
+	}
+
+	if depth >= maxPickleDepth {
+		return nil, errTooDeep
+	}
+	if *budget -= len(items); *budget < 0 {
+		return nil, errTooLarge
+	}
+	resolved := make([]interface{}, len(items))
+	for i, item := range items {
+		var err error
+		if resolved[i], err = resolveLists(item, depth+1, budget); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
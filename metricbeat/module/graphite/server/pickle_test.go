@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Pickles of the following list, generated with Python 3:
+//
+//	[('servers.web01.cpu.load', (1500000000, 0.5)),
+//	 ('servers.web02.cpu.load', (1500000000.5, 2)),
+//	 ('big', (1500000000, 2**70))]
+var pickles = map[string]string{
+	"protocol 0": "(lp0\n(Vservers.web01.cpu.load\np1\n(I1500000000\nF0.5\ntp2\ntp3\na(Vservers.web02.cpu.load\np4\n(F1500000000.5\nI2\ntp5\ntp6\na(Vbig\np7\n(I1500000000\nL1180591620717411303424L\ntp8\ntp9\na.",
+	"protocol 2": "\x80\x02]q\x00(X\x16\x00\x00\x00servers.web01.cpu.loadq\x01J\x00/hYG?\xe0\x00\x00\x00\x00\x00\x00\x86q\x02\x86q\x03X\x16\x00\x00\x00servers.web02.cpu.loadq\x04GA\xd6Z\x0b\xc0 \x00\x00K\x02\x86q\x05\x86q\x06X\x03\x00\x00\x00bigq\x07J\x00/hY\x8a\x09\x00\x00\x00\x00\x00\x00\x00\x00@\x86q\x08\x86q\x09e.",
+	"protocol 4": "\x80\x04\x95r\x00\x00\x00\x00\x00\x00\x00]\x94(\x8c\x16servers.web01.cpu.load\x94J\x00/hYG?\xe0\x00\x00\x00\x00\x00\x00\x86\x94\x86\x94\x8c\x16servers.web02.cpu.load\x94GA\xd6Z\x0b\xc0 \x00\x00K\x02\x86\x94\x86\x94\x8c\x03big\x94J\x00/hY\x8a\x09\x00\x00\x00\x00\x00\x00\x00\x00@\x86\x94\x86\x94e.",
+}
+
+func TestUnpickle(t *testing.T) {
+	big70 := new(big.Int).Lsh(big.NewInt(1), 70)
+
+	for name, data := range pickles {
+		v, err := unpickle([]byte(data))
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		assert.Equal(t, []interface{}{
+			[]interface{}{"servers.web01.cpu.load", []interface{}{int64(1500000000), 0.5}},
+			[]interface{}{"servers.web02.cpu.load", []interface{}{1500000000.5, int64(2)}},
+			[]interface{}{"big", []interface{}{int64(1500000000), big70}},
+		}, v, name)
+	}
+}
+
+func TestUnpickleStrings(t *testing.T) {
+	// Python 2 pickles the str with the STRING opcode
+	v, err := unpickle([]byte("(lp0\n(S'a.b'\np1\n(I1500000000\nF1.5\ntp2\ntp3\na."))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []interface{}{
+			[]interface{}{"a.b", []interface{}{int64(1500000000), 1.5}},
+		}, v)
+	}
+
+	v, err = unpickle([]byte("S'it\\'s \"quoted\"\\n'\n."))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "it's \"quoted\"\n", v)
+	}
+}
+
+func TestUnpickleLongs(t *testing.T) {
+	tests := map[string]int64{
+		"\x8a\x00.":         0,
+		"\x8a\x01\xff.":     -1,
+		"\x8a\x02\x00\x80.": -32768,
+		"\x8a\x02\xff\x7f.": 32767,
+		"L-12L\n.":          -12,
+	}
+	for data, expected := range tests {
+		v, err := unpickle([]byte(data))
+		if assert.NoError(t, err, "%q", data) {
+			assert.Equal(t, expected, v, "%q", data)
+		}
+	}
+}
+
+func TestUnpickleErrors(t *testing.T) {
+	tests := map[string]string{
+		"truncated":   pickles["protocol 2"][:40],
+		"no stop":     "]",
+		"underflow":   "a.",
+		"no mark":     "t.",
+		"unknown get": "h\x05.",
+		// POP below the mark
+		"popped mark": "N(0t.",
+		// A list containing itself
+		"cyclic": "\x80\x02]q\x00h\x00a.",
+		// Lists of the same memoized list twice, doubling the items at each level
+		"expansion": expansionPickle(28),
+		// os.system('true') must not be called
+		"reduce": "\x80\x02]q\x00cposix\nsystem\nq\x01X\x04\x00\x00\x00trueq\x02\x85q\x03Rq\x04a.",
+	}
+
+	for name, data := range tests {
+		_, err := unpickle([]byte(data))
+		assert.Error(t, err, name)
+	}
+}
+
+// expansionPickle builds a list of two references to the list of the previous
+// level, for the given number of levels.
+func expansionPickle(levels int) string {
+	var b bytes.Buffer
+	b.WriteString("\x80\x02]q\x00")
+	for i := 0; i < levels; i++ {
+		fmt.Fprintf(&b, "(h%ch%clq%c", byte(i), byte(i), byte(i+1))
+	}
+	b.WriteString(".")
+	return b.String()
+}
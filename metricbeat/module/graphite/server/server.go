@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/helper/server"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+var debugf = logp.MakeDebug("graphite")
+
+// init registers the MetricSet with the central registry.
+func init() {
+	if err := mb.Registry.AddMetricSet("graphite", "server", New); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet receives the metrics of Graphite clients, and reports one event
+// per value received.
+type MetricSet struct {
+	mb.BaseMetricSet
+	config    config
+	templates *templates
+}
+
+// New creates a new instance of the MetricSet.
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	logp.Beta("The graphite server metricset is beta")
+
+	config := defaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	templates, err := newTemplates(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		config:        config,
+		templates:     templates,
+	}, nil
+}
+
+// Run listens for the metrics until the reporter is done.
+func (m *MetricSet) Run(r mb.PushReporter) {
+	address := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.port()))
+
+	var s server.Server
+	var err error
+	switch m.config.Protocol {
+	case "tcp":
+		s, err = server.NewTCPServer(address, bufio.ScanLines)
+	case "pickle":
+		s, err = server.NewTCPServer(address, splitPickle)
+	default:
+		s, err = server.NewUDPServer(address)
+	}
+	if err != nil {
+		r.Error(err)
+		return
+	}
+	logp.Info("Graphite server listening on %s/%s", m.config.Protocol, s.Addr())
+
+	m.run(s, r)
+}
+
+func (m *MetricSet) run(s server.Server, r mb.PushReporter) {
+	s.Start(func(message []byte) {
+		for _, event := range m.events(message, time.Now()) {
+			if !r.Event(event) {
+				return
+			}
+		}
+	})
+	defer s.Stop()
+
+	<-r.Done()
+}
+
+// events decodes a message, and returns the events of its values.
+func (m *MetricSet) events(message []byte, now time.Time) []common.MapStr {
+	var points []point
+	if m.config.Protocol == "pickle" {
+		var err error
+		points, err = parsePickle(message, now)
+		if err != nil {
+			debugf("Dropping invalid pickle message: %v", err)
+			return nil
+		}
+	} else {
+		points = parsePlaintext(message, now, func(line []byte, err error) {
+			debugf("Dropping invalid metric '%s': %v", line, err)
+		})
+	}
+
+	events := make([]common.MapStr, 0, len(points))
+	for _, p := range points {
+		event, err := m.event(p)
+		if err != nil {
+			debugf("Dropping metric '%s': %v", p.path, err)
+			continue
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// event maps a value to an event with the template of its path.
+func (m *MetricSet) event(p point) (common.MapStr, error) {
+	segments := strings.Split(p.path, ".")
+	t := m.templates.find(segments)
+
+	metric, tags, err := t.apply(segments)
+	if err != nil {
+		return nil, err
+	}
+
+	event := common.MapStr{
+		"@timestamp": common.Time(p.timestamp),
+		"namespace":  t.namespace,
+		"metric":     metric,
+		"value":      p.value,
+	}
+	if len(tags) > 0 {
+		fields := common.MapStr{}
+		for k, v := range tags {
+			fields[k] = v
+		}
+		event["tags"] = fields
+	}
+	return event, nil
+}
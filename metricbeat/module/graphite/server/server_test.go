@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/helper/server"
+)
+
+func TestParsePlaintext(t *testing.T) {
+	now := time.Unix(1500000100, 0)
+
+	var invalid []string
+	points := parsePlaintext([]byte(
+		"servers.web01.cpu.load 0.5 1500000000\n"+
+			"servers.web01.cpu.idle 99.5 -1\n"+
+			"servers.web01.cpu.user 3\n"+
+			"servers.web01.cpu.nice abc 1500000000\n"+
+			"servers.web01.cpu.wait nan 1500000000\n"+
+			"invalid\n"), now, func(line []byte, err error) {
+		invalid = append(invalid, string(line))
+	})
+
+	assert.Equal(t, []point{
+		{path: "servers.web01.cpu.load", value: 0.5, timestamp: time.Unix(1500000000, 0)},
+		{path: "servers.web01.cpu.idle", value: 99.5, timestamp: now},
+		{path: "servers.web01.cpu.user", value: 3, timestamp: now},
+	}, points)
+	assert.Len(t, invalid, 3)
+}
+
+func TestParsePickle(t *testing.T) {
+	points, err := parsePickle([]byte(pickles["protocol 2"]), time.Now())
+	if !assert.NoError(t, err) || !assert.Len(t, points, 3) {
+		return
+	}
+	assert.Equal(t, point{
+		path:      "servers.web02.cpu.load",
+		value:     2,
+		timestamp: time.Unix(1500000000, 5e8),
+	}, points[1])
+	assert.Equal(t, 1180591620717411303424.0, points[2].value)
+}
+
+func TestSplitPickle(t *testing.T) {
+	advance, token, err := splitPickle([]byte{0, 0, 0, 2, 'a'}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, advance)
+	assert.Nil(t, token)
+
+	advance, token, err = splitPickle([]byte{0, 0, 0, 2, 'a', 'b', 0}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, advance)
+	assert.Equal(t, []byte("ab"), token)
+
+	_, _, err = splitPickle([]byte{0xff, 0, 0, 0}, false)
+	assert.Error(t, err)
+}
+
+func newTestMetricSet(t *testing.T, config config) *MetricSet {
+	templates, err := newTemplates(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &MetricSet{config: config, templates: templates}
+}
+
+func TestEvents(t *testing.T) {
+	config := defaultConfig()
+	config.Templates = []*templateConfig{
+		{
+			Filter:    "servers.*",
+			Namespace: "servers",
+			Template:  ".host.metric*",
+			Delimiter: "_",
+			Tags:      map[string]string{"dc": "ams"},
+		},
+	}
+	m := newTestMetricSet(t, config)
+
+	events := m.events([]byte("servers.web01.cpu.load 0.5 1500000000\nnetwork.eth0.bytes 42 1500000000\n"), time.Now())
+	if !assert.Len(t, events, 2) {
+		return
+	}
+	assert.Equal(t, common.MapStr{
+		"@timestamp": common.Time(time.Unix(1500000000, 0)),
+		"namespace":  "servers",
+		"metric":     "cpu_load",
+		"value":      0.5,
+		"tags": common.MapStr{
+			"host": "web01",
+			"dc":   "ams",
+		},
+	}, events[0])
+	assert.Equal(t, common.MapStr{
+		"@timestamp": common.Time(time.Unix(1500000000, 0)),
+		"namespace":  "graphite",
+		"metric":     "network.eth0.bytes",
+		"value":      42.0,
+	}, events[1])
+}
+
+type testReporter struct {
+	events chan common.MapStr
+	done   chan struct{}
+}
+
+func (r *testReporter) Event(event common.MapStr) bool {
+	r.events <- event
+	return true
+}
+
+func (r *testReporter) ErrorWith(err error, meta common.MapStr) bool { return true }
+func (r *testReporter) Error(err error) bool                         { return true }
+func (r *testReporter) Done() <-chan struct{}                        { return r.done }
+
+func TestPickleServer(t *testing.T) {
+	config := defaultConfig()
+	config.Protocol = "pickle"
+	m := newTestMetricSet(t, config)
+
+	s, err := server.NewTCPServer("127.0.0.1:0", splitPickle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &testReporter{
+		events: make(chan common.MapStr, 10),
+		done:   make(chan struct{}),
+	}
+	stopped := make(chan struct{})
+	go func() {
+		m.run(s, r)
+		close(stopped)
+	}()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	data := []byte(pickles["protocol 4"])
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	conn.Write(append(header, data...))
+
+	for _, metric := range []string{"servers.web01.cpu.load", "servers.web02.cpu.load", "big"} {
+		select {
+		case event := <-r.events:
+			assert.Equal(t, metric, event["metric"])
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %s", metric)
+		}
+	}
+
+	close(r.done)
+	<-stopped
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Parts of a template with a special meaning. The other parts are the names
+// of tags, empty parts skip the segment of the path.
+const (
+	partMetric       = "metric"
+	partMetricGreedy = "metric*"
+)
+
+var errNoMetric = errors.New("template produced an empty metric name")
+
+// template maps the segments of a dotted metric path to the metric name and
+// tags of an event. For example the template ".host.metric*" maps the path
+// "servers.web01.cpu.load" to the metric "cpu.load" with the tag
+// host: web01.
+type template struct {
+	filter    []string
+	namespace string
+	parts     []string
+	delimiter string
+	tags      map[string]string
+}
+
+func newTemplate(c *templateConfig) (*template, error) {
+	t := &template{
+		namespace: c.Namespace,
+		parts:     strings.Split(c.Template, "."),
+		delimiter: c.Delimiter,
+		tags:      c.Tags,
+	}
+	if t.delimiter == "" {
+		t.delimiter = "."
+	}
+	if c.Filter != "" {
+		t.filter = strings.Split(c.Filter, ".")
+		for _, pattern := range t.filter {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid template filter '%s': %v", c.Filter, err)
+			}
+		}
+	}
+
+	for i, part := range t.parts {
+		if part == partMetricGreedy && i != len(t.parts)-1 {
+			return nil, fmt.Errorf("'%s' must be the last part of template '%s'", partMetricGreedy, c.Template)
+		}
+	}
+	return t, nil
+}
+
+// match checks if the leading segments of a path match the filter. Each
+// segment of the filter is a pattern like "cpu*".
+func (t *template) match(segments []string) bool {
+	if len(segments) < len(t.filter) {
+		return false
+	}
+	for i, pattern := range t.filter {
+		if matched, _ := path.Match(pattern, segments[i]); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns the metric name and tags of a path. The segments beyond the
+// template are dropped, unless the template ends with "metric*".
+func (t *template) apply(segments []string) (string, map[string]string, error) {
+	var metric []string
+	tags := map[string]string{}
+	for k, v := range t.tags {
+		tags[k] = v
+	}
+
+	for i, segment := range segments {
+		if i >= len(t.parts) {
+			break
+		}
+
+		switch part := t.parts[i]; part {
+		case "":
+		case partMetric:
+			metric = append(metric, segment)
+		case partMetricGreedy:
+			metric = append(metric, segments[i:]...)
+		default:
+			if tag, found := tags[part]; found && i > 0 && t.parts[i-1] == part {
+				// consecutive parts of the same tag are joined
+				tags[part] = tag + t.delimiter + segment
+			} else {
+				tags[part] = segment
+			}
+		}
+	}
+
+	if len(metric) == 0 {
+		return "", nil, errNoMetric
+	}
+	return strings.Join(metric, t.delimiter), tags, nil
+}
+
+// templates selects the template of the paths.
+type templates struct {
+	templates []*template
+	fallback  *template
+}
+
+func newTemplates(c *config) (*templates, error) {
+	fallback, err := newTemplate(&c.DefaultTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := &templates{fallback: fallback}
+	for _, tc := range c.Templates {
+		t, err := newTemplate(tc)
+		if err != nil {
+			return nil, err
+		}
+		ts.templates = append(ts.templates, t)
+	}
+	return ts, nil
+}
+
+func (ts *templates) find(segments []string) *template {
+	for _, t := range ts.templates {
+		if t.match(segments) {
+			return t
+		}
+	}
+	return ts.fallback
+}
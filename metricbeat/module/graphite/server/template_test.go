@@ -0,0 +1,113 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateApply(t *testing.T) {
+	tests := []struct {
+		template  string
+		delimiter string
+		tags      map[string]string
+		path      string
+		metric    string
+		expected  map[string]string
+	}{
+		{
+			template: "metric*",
+			path:     "servers.web01.cpu.load",
+			metric:   "servers.web01.cpu.load",
+			expected: map[string]string{},
+		},
+		{
+			template: ".host.metric*",
+			path:     "servers.web01.cpu.load",
+			metric:   "cpu.load",
+			expected: map[string]string{"host": "web01"},
+		},
+		{
+			template:  "region.host.metric.metric",
+			delimiter: "_",
+			path:      "eu.web01.cpu.load.dropped",
+			metric:    "cpu_load",
+			expected:  map[string]string{"region": "eu", "host": "web01"},
+		},
+		{
+			// consecutive parts of a tag are joined
+			template: "host.host.metric",
+			path:     "web01.example.cpu",
+			metric:   "cpu",
+			expected: map[string]string{"host": "web01.example"},
+		},
+		{
+			template: ".metric",
+			tags:     map[string]string{"dc": "ams"},
+			path:     "servers.cpu",
+			metric:   "cpu",
+			expected: map[string]string{"dc": "ams"},
+		},
+	}
+
+	for _, test := range tests {
+		tmpl, err := newTemplate(&templateConfig{
+			Namespace: "test",
+			Template:  test.template,
+			Delimiter: test.delimiter,
+			Tags:      test.tags,
+		})
+		if !assert.NoError(t, err, test.template) {
+			continue
+		}
+
+		metric, tags, err := tmpl.apply(strings.Split(test.path, "."))
+		if assert.NoError(t, err, test.template) {
+			assert.Equal(t, test.metric, metric, test.template)
+			assert.Equal(t, test.expected, tags, test.template)
+		}
+	}
+}
+
+func TestTemplateNoMetric(t *testing.T) {
+	tmpl, err := newTemplate(&templateConfig{Namespace: "test", Template: "host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = tmpl.apply([]string{"web01", "cpu"})
+	assert.Equal(t, errNoMetric, err)
+}
+
+func TestTemplateErrors(t *testing.T) {
+	_, err := newTemplate(&templateConfig{Namespace: "test", Template: "metric*.host"})
+	assert.Error(t, err)
+
+	_, err = newTemplate(&templateConfig{Namespace: "test", Template: "metric", Filter: "servers.[.cpu"})
+	assert.Error(t, err)
+}
+
+func TestTemplatesFind(t *testing.T) {
+	config := defaultConfig()
+	config.Templates = []*templateConfig{
+		{Filter: "servers.*.cpu", Namespace: "cpu", Template: ".host.metric*"},
+		{Filter: "servers", Namespace: "servers", Template: ".host.metric*"},
+		{Filter: "app*", Namespace: "apps", Template: "app.metric*"},
+	}
+	ts, err := newTemplates(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"servers.web01.cpu.load":  "cpu",
+		"servers.web01.mem.free":  "servers",
+		"servers":                 "servers",
+		"application.requests":    "apps",
+		"network.eth0.bytes":      "graphite",
+		"servers.web01.cpu.extra": "cpu",
+	}
+	for path, namespace := range tests {
+		assert.Equal(t, namespace, ts.find(strings.Split(path, ".")).namespace, path)
+	}
+}
@@ -5,6 +5,7 @@ package mysql
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/elastic/beats/metricbeat/mb"
 
@@ -89,3 +90,17 @@ func NewDB(dsn string) (*sql.DB, error) {
 	}
 	return db, nil
 }
+
+// DSNWithTimeout returns the DSN with the dial, read and write timeouts set to
+// the timeout. The mysql driver doesn't support contexts, so the timeouts of
+// the connections are the only way to bound the duration of a query.
+func DSNWithTimeout(dsn string, timeout time.Duration) (string, error) {
+	config, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", errors.Wrapf(err, "error parsing mysql dsn")
+	}
+	config.Timeout = timeout
+	config.ReadTimeout = timeout
+	config.WriteTimeout = timeout
+	return config.FormatDSN(), nil
+}
@@ -51,3 +51,13 @@ func TestParseDSN(t *testing.T) {
 		}
 	}
 }
+
+func TestDSNWithTimeout(t *testing.T) {
+	dsn, err := DSNWithTimeout("root@tcp(127.0.0.1:3306)/?readTimeout=10s&timeout=10s&writeTimeout=10s", 500*time.Millisecond)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "root@tcp(127.0.0.1:3306)/?readTimeout=500ms&timeout=500ms&writeTimeout=500ms", dsn)
+	}
+
+	_, err = DSNWithTimeout("root@tcp(127.0.0.1:3306)", time.Second)
+	assert.Error(t, err)
+}
@@ -0,0 +1,15 @@
+package remote_write
+
+type config struct {
+	Host string `config:"host"`
+	Port int    `config:"port"`
+	Path string `config:"path"`
+}
+
+func defaultConfig() config {
+	return config{
+		Host: "localhost",
+		Port: 9201,
+		Path: "/write",
+	}
+}
@@ -0,0 +1,47 @@
+package remote_write
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// Messages of the Prometheus remote write protocol, as defined by
+// https://github.com/prometheus/prometheus/blob/master/storage/remote/remote.proto
+
+// WriteRequest is the body of a remote write request, compressed with snappy.
+type WriteRequest struct {
+	Timeseries []*TimeSeries `protobuf:"bytes,1,rep,name=timeseries" json:"timeseries,omitempty"`
+}
+
+func (m *WriteRequest) Reset()         { *m = WriteRequest{} }
+func (m *WriteRequest) String() string { return proto.CompactTextString(m) }
+func (*WriteRequest) ProtoMessage()    {}
+
+// TimeSeries is a list of samples of a series identified by its labels.
+type TimeSeries struct {
+	Labels  []*LabelPair `protobuf:"bytes,1,rep,name=labels" json:"labels,omitempty"`
+	Samples []*Sample    `protobuf:"bytes,2,rep,name=samples" json:"samples,omitempty"`
+}
+
+func (m *TimeSeries) Reset()         { *m = TimeSeries{} }
+func (m *TimeSeries) String() string { return proto.CompactTextString(m) }
+func (*TimeSeries) ProtoMessage()    {}
+
+// LabelPair is a label of a series. The metric name is the __name__ label.
+type LabelPair struct {
+	Name  string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+}
+
+func (m *LabelPair) Reset()         { *m = LabelPair{} }
+func (m *LabelPair) String() string { return proto.CompactTextString(m) }
+func (*LabelPair) ProtoMessage()    {}
+
+// Sample is a value of a series, with its timestamp in milliseconds.
+type Sample struct {
+	Value       float64 `protobuf:"fixed64,1,opt,name=value" json:"value,omitempty"`
+	TimestampMs int64   `protobuf:"varint,2,opt,name=timestamp_ms,json=timestampMs" json:"timestamp_ms,omitempty"`
+}
+
+func (m *Sample) Reset()         { *m = Sample{} }
+func (m *Sample) String() string { return proto.CompactTextString(m) }
+func (*Sample) ProtoMessage()    {}
@@ -0,0 +1,181 @@
+package remote_write
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+// maxRequestSize is the maximum size of a request, compressed or not.
+const maxRequestSize = 32 << 20
+
+var debugf = logp.MakeDebug("prometheus")
+
+// init registers the MetricSet with the central registry.
+func init() {
+	if err := mb.Registry.AddMetricSet("prometheus", "remote_write", New); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet receives the samples sent by Prometheus servers with the remote
+// write protocol.
+type MetricSet struct {
+	mb.BaseMetricSet
+	config config
+}
+
+// New creates a new instance of the MetricSet.
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	logp.Beta("The prometheus remote_write metricset is beta")
+
+	config := defaultConfig()
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		config:        config,
+	}, nil
+}
+
+// Run serves the remote write requests until the reporter is done.
+func (m *MetricSet) Run(r mb.PushReporter) {
+	address := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		r.Error(err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(m.config.Path, m.handler(r))
+	server := &http.Server{Handler: mux}
+
+	logp.Info("Prometheus remote write server listening on http://%s%s", listener.Addr(), m.config.Path)
+	go server.Serve(listener)
+	defer server.Close()
+
+	<-r.Done()
+}
+
+// handler decodes the write requests and reports their samples.
+func (m *MetricSet) handler(r mb.PushReporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxRequestSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		request, err := decodeWriteRequest(body)
+		if err != nil {
+			debugf("Invalid remote write request from %s: %v", req.RemoteAddr, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, event := range events(request) {
+			if !r.Event(event) {
+				http.Error(w, "metricset is stopping", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// decodeWriteRequest decodes the snappy compressed protobuf of a request.
+func decodeWriteRequest(body []byte) (*WriteRequest, error) {
+	size, err := snappy.DecodedLen(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy data: %v", err)
+	}
+	if size > maxRequestSize {
+		return nil, fmt.Errorf("request of %d bytes is too large", size)
+	}
+
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy data: %v", err)
+	}
+
+	request := &WriteRequest{}
+	if err := proto.Unmarshal(data, request); err != nil {
+		return nil, fmt.Errorf("invalid write request: %v", err)
+	}
+	return request, nil
+}
+
+// events groups the samples with the same labels and timestamp in one event,
+// like the collector metricset groups the metrics of a scrape.
+func events(request *WriteRequest) []common.MapStr {
+	var events []common.MapStr
+	index := map[string]common.MapStr{}
+
+	for _, series := range request.Timeseries {
+		name, labels := seriesLabels(series)
+		if name == "" {
+			continue
+		}
+
+		for _, sample := range series.Samples {
+			// Staleness markers are NaN, and none of these values can be
+			// encoded in JSON.
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				continue
+			}
+
+			key := labels.String() + "#" + strconv.FormatInt(sample.TimestampMs, 10)
+			event, found := index[key]
+			if !found {
+				event = common.MapStr{
+					"@timestamp": common.Time(msToTime(sample.TimestampMs)),
+				}
+				if len(labels) > 0 {
+					event["label"] = labels.Clone()
+				}
+				index[key] = event
+				events = append(events, event)
+			}
+			event[name] = common.MapStr{"value": sample.Value}
+		}
+	}
+	return events
+}
+
+// seriesLabels returns the metric name of a series, and its other labels.
+func seriesLabels(series *TimeSeries) (string, common.MapStr) {
+	var name string
+	labels := common.MapStr{}
+	for _, label := range series.Labels {
+		if label.Name == "__name__" {
+			name = label.Value
+		} else if label.Name != "" && label.Value != "" {
+			labels[label.Name] = label.Value
+		}
+	}
+	return name, labels
+}
+
+func msToTime(ms int64) time.Time {
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+}
@@ -0,0 +1,132 @@
+// +build !integration
+
+package remote_write
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+type testReporter struct {
+	events []common.MapStr
+	done   chan struct{}
+}
+
+func (r *testReporter) Event(event common.MapStr) bool {
+	r.events = append(r.events, event)
+	return true
+}
+
+func (r *testReporter) ErrorWith(err error, meta common.MapStr) bool { return true }
+func (r *testReporter) Error(err error) bool                         { return true }
+func (r *testReporter) Done() <-chan struct{}                        { return r.done }
+
+func TestRemoteWrite(t *testing.T) {
+	// write_request.snappy is a request with the up, scrape_duration_seconds
+	// and http_requests_total metrics, the latter with two samples.
+	body, err := ioutil.ReadFile("testdata/write_request.snappy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &MetricSet{config: defaultConfig()}
+	r := &testReporter{done: make(chan struct{})}
+	server := httptest.NewServer(m.handler(r))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/x-protobuf", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	ts := time.Unix(1500000000, 123*int64(time.Millisecond))
+	assert.Equal(t, []common.MapStr{
+		{
+			"@timestamp": common.Time(ts),
+			"label": common.MapStr{
+				"instance": "localhost:9090",
+				"job":      "prometheus",
+			},
+			"up":                      common.MapStr{"value": 1.0},
+			"scrape_duration_seconds": common.MapStr{"value": 0.004516},
+		},
+		{
+			"@timestamp": common.Time(ts),
+			"label": common.MapStr{
+				"code":     "200",
+				"handler":  "query",
+				"instance": "localhost:9090",
+				"job":      "prometheus",
+			},
+			"http_requests_total": common.MapStr{"value": 42.0},
+		},
+		{
+			"@timestamp": common.Time(ts.Add(15 * time.Second)),
+			"label": common.MapStr{
+				"code":     "200",
+				"handler":  "query",
+				"instance": "localhost:9090",
+				"job":      "prometheus",
+			},
+			"http_requests_total": common.MapStr{"value": 45.0},
+		},
+	}, r.events)
+}
+
+func TestRemoteWriteInvalid(t *testing.T) {
+	m := &MetricSet{config: defaultConfig()}
+	r := &testReporter{done: make(chan struct{})}
+	server := httptest.NewServer(m.handler(r))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/x-protobuf", bytes.NewReader([]byte("not snappy")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	assert.Empty(t, r.events)
+}
+
+func TestEventsSkipsInvalidSamples(t *testing.T) {
+	request := &WriteRequest{Timeseries: []*TimeSeries{
+		{
+			// No metric name
+			Labels:  []*LabelPair{{Name: "job", Value: "prometheus"}},
+			Samples: []*Sample{{Value: 1, TimestampMs: 1500000000000}},
+		},
+		{
+			Labels: []*LabelPair{{Name: "__name__", Value: "up"}},
+			Samples: []*Sample{
+				{Value: math.NaN(), TimestampMs: 1500000000000},
+				{Value: 0, TimestampMs: 1500000015000},
+			},
+		},
+	}}
+
+	assert.Equal(t, []common.MapStr{
+		{
+			"@timestamp": common.Time(time.Unix(1500000015, 0)),
+			"up":         common.MapStr{"value": 0.0},
+		},
+	}, events(request))
+}
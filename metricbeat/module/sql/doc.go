@@ -0,0 +1,4 @@
+/*
+Package sql is a Metricbeat module that contains MetricSets.
+*/
+package sql
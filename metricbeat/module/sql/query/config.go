@@ -0,0 +1,50 @@
+package query
+
+import (
+	"fmt"
+	"time"
+)
+
+// Response formats of the queries.
+const (
+	// tableFormat reports each row of the result as an event.
+	tableFormat = "table"
+
+	// variablesFormat reports the rows of the result as the key/value pairs of
+	// a single event. The rows must have two columns, the key and the value.
+	variablesFormat = "variables"
+)
+
+type config struct {
+	Driver  string        `config:"driver"  validate:"required"`
+	Queries []queryConfig `config:"queries" validate:"required"`
+}
+
+type queryConfig struct {
+	Namespace      string        `config:"namespace" validate:"required"`
+	Query          string        `config:"query"     validate:"required"`
+	ResponseFormat string        `config:"response_format"`
+	Timeout        time.Duration `config:"timeout"`
+}
+
+func (c *config) Validate() error {
+	switch c.Driver {
+	case "mysql", "postgres":
+	default:
+		return fmt.Errorf("driver must be mysql or postgres, got '%s'", c.Driver)
+	}
+	return nil
+}
+
+func (c *queryConfig) Validate() error {
+	switch c.ResponseFormat {
+	case "", tableFormat, variablesFormat:
+	default:
+		return fmt.Errorf("response_format must be %s or %s, got '%s'",
+			tableFormat, variablesFormat, c.ResponseFormat)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("query timeout must be positive, got %v", c.Timeout)
+	}
+	return nil
+}
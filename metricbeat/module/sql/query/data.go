@@ -0,0 +1,35 @@
+package query
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// coerce converts the value of a column to the type of the event field. Some
+// drivers, like mysql, return all the values as text, so the numeric texts
+// are converted to numbers. NULL values are returned as nil.
+func coerce(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return coerceString(string(v))
+	case string:
+		return coerceString(v)
+	case time.Time:
+		return common.Time(v)
+	default:
+		return v
+	}
+}
+
+func coerceString(s string) interface{} {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return f
+	}
+	return s
+}
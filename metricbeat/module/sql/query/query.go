@@ -0,0 +1,222 @@
+/*
+Package query runs custom SQL queries, and reports their results.
+*/
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/joeshaw/multierror"
+	"github.com/pkg/errors"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/elastic/beats/metricbeat/module/mysql"
+	"github.com/elastic/beats/metricbeat/module/postgresql"
+)
+
+var debugf = logp.MakeDebug("sql-query")
+
+func init() {
+	if err := mb.Registry.AddMetricSet("sql", "query", New, parseHost); err != nil {
+		panic(err)
+	}
+}
+
+// parseHost parses the DSN of the host with the parser of the driver module.
+func parseHost(mod mb.Module, host string) (mb.HostData, error) {
+	c := struct {
+		Driver string `config:"driver"`
+	}{}
+	if err := mod.UnpackConfig(&c); err != nil {
+		return mb.HostData{}, err
+	}
+
+	switch c.Driver {
+	case "mysql":
+		return mysql.ParseDSN(mod, host)
+	case "postgres":
+		return postgresql.ParseURL(mod, host)
+	}
+	return mb.HostData{}, fmt.Errorf("driver must be mysql or postgres, got '%s'", c.Driver)
+}
+
+// MetricSet runs the configured queries on each fetch.
+type MetricSet struct {
+	mb.BaseMetricSet
+	config config
+
+	// Database handles by query timeout. The mysql driver doesn't support
+	// contexts, so its queries are bounded by the timeouts of the connections
+	// of their handle.
+	dbs map[time.Duration]*sql.DB
+}
+
+// New creates and returns a new MetricSet instance.
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	logp.Beta("The sql query metricset is beta")
+
+	config := config{}
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		config:        config,
+		dbs:           map[time.Duration]*sql.DB{},
+	}, nil
+}
+
+// Fetch runs the queries, and reports the events of their results. A failing
+// query is reported as an error, and doesn't prevent the other queries from
+// running.
+func (m *MetricSet) Fetch(r mb.Reporter) {
+	for _, q := range m.config.Queries {
+		timeout := q.Timeout
+		if timeout == 0 {
+			timeout = m.Module().Config().Timeout
+		}
+
+		db, err := m.db(timeout)
+		if err != nil {
+			r.Error(errors.Wrap(err, "sql-query fetch failed"))
+			return
+		}
+
+		events, err := m.query(db, q, timeout)
+		if err != nil {
+			r.ErrorWith(errors.Wrapf(err, "query '%s' failed", q.Namespace),
+				common.MapStr{"_namespace": q.Namespace})
+			continue
+		}
+
+		for _, event := range events {
+			if !r.Event(event) {
+				return
+			}
+		}
+	}
+}
+
+// Close closes the database handles.
+func (m *MetricSet) Close() error {
+	var errs multierror.Errors
+	for timeout, db := range m.dbs {
+		if err := db.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(m.dbs, timeout)
+	}
+	return errs.Err()
+}
+
+// db returns the database handle of the queries with the timeout, and opens it
+// on first use.
+func (m *MetricSet) db(timeout time.Duration) (*sql.DB, error) {
+	if m.config.Driver != "mysql" {
+		// the queries are canceled with their context
+		timeout = 0
+	}
+	if db, found := m.dbs[timeout]; found {
+		return db, nil
+	}
+
+	db, err := m.openDB(timeout)
+	if err != nil {
+		return nil, err
+	}
+	m.dbs[timeout] = db
+	return db, nil
+}
+
+func (m *MetricSet) openDB(timeout time.Duration) (*sql.DB, error) {
+	if m.config.Driver == "mysql" {
+		dsn := m.HostData().URI
+		if timeout > 0 {
+			var err error
+			if dsn, err = mysql.DSNWithTimeout(dsn, timeout); err != nil {
+				return nil, err
+			}
+		}
+		return mysql.NewDB(dsn)
+	}
+	// The lib/pq driver is registered by the postgresql module.
+	return sql.Open(m.config.Driver, m.HostData().URI)
+}
+
+// query runs a query with its timeout, and returns the events of its result.
+func (m *MetricSet) query(db *sql.DB, q queryConfig, timeout time.Duration) ([]common.MapStr, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	rows, err := db.QueryContext(ctx, q.Query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, errors.Wrap(err, "scanning columns")
+	}
+
+	vals := make([]interface{}, len(columns))
+	valPointers := make([]interface{}, len(columns))
+	for i := range vals {
+		valPointers[i] = &vals[i]
+	}
+
+	if q.ResponseFormat == variablesFormat && len(columns) != 2 {
+		return nil, fmt.Errorf("variables response format requires 2 columns, got %d", len(columns))
+	}
+
+	var events []common.MapStr
+	variables := common.MapStr{}
+	for rows.Next() {
+		if err := rows.Scan(valPointers...); err != nil {
+			return nil, errors.Wrap(err, "scanning row")
+		}
+
+		if q.ResponseFormat == variablesFormat {
+			if key := fmt.Sprint(coerce(vals[0])); key != "" {
+				setValue(variables, key, vals[1])
+			}
+			continue
+		}
+
+		event := common.MapStr{}
+		for i, column := range columns {
+			setValue(event, column, vals[i])
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if q.ResponseFormat == variablesFormat && len(variables) > 0 {
+		events = append(events, variables)
+	}
+
+	for _, event := range events {
+		debugf("Result of query '%s': %v", q.Namespace, event)
+		event["_namespace"] = q.Namespace
+	}
+	return events, nil
+}
+
+// setValue sets the coerced value in the event, unless it is NULL.
+func setValue(event common.MapStr, key string, value interface{}) {
+	if v := coerce(value); v != nil {
+		event[key] = v
+	}
+}
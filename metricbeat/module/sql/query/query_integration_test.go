@@ -0,0 +1,105 @@
+// +build integration
+
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/metricbeat/mb"
+	mbtest "github.com/elastic/beats/metricbeat/mb/testing"
+	"github.com/elastic/beats/metricbeat/module/mysql"
+	"github.com/elastic/beats/metricbeat/module/postgresql"
+)
+
+func TestFetchMySQL(t *testing.T) {
+	m := mbtest.NewReportingMetricSet(t, map[string]interface{}{
+		"module":     "sql",
+		"metricsets": []string{"query"},
+		"hosts":      []string{mysql.GetMySQLEnvDSN()},
+		"driver":     "mysql",
+		"queries": []map[string]interface{}{
+			{
+				"namespace":       "status",
+				"query":           "SHOW GLOBAL STATUS LIKE 'Threads_%'",
+				"response_format": "variables",
+			},
+			{
+				"namespace": "tables",
+				"query":     "SELECT table_schema, COUNT(*) AS tables FROM information_schema.tables GROUP BY table_schema",
+			},
+		},
+	})
+
+	events := fetch(t, m)
+	if !assert.True(t, len(events) > 1) {
+		t.FailNow()
+	}
+
+	status := events[0]
+	assert.Equal(t, "status", status["_namespace"])
+	assert.True(t, status["Threads_connected"].(int64) > 0)
+
+	tables := events[1]
+	assert.Equal(t, "tables", tables["_namespace"])
+	assert.IsType(t, "", tables["table_schema"])
+	assert.True(t, tables["tables"].(int64) > 0)
+}
+
+func TestFetchPostgreSQL(t *testing.T) {
+	m := mbtest.NewReportingMetricSet(t, map[string]interface{}{
+		"module":     "sql",
+		"metricsets": []string{"query"},
+		"hosts":      []string{postgresql.GetEnvDSN()},
+		"username":   postgresql.GetEnvUsername(),
+		"password":   postgresql.GetEnvPassword(),
+		"driver":     "postgres",
+		"queries": []map[string]interface{}{
+			{
+				"namespace": "databases",
+				"query":     "SELECT datname, numbackends, xact_commit FROM pg_stat_database",
+			},
+		},
+	})
+
+	events := fetch(t, m)
+	if !assert.NotEmpty(t, events) {
+		t.FailNow()
+	}
+
+	event := events[0]
+	assert.Equal(t, "databases", event["_namespace"])
+	assert.Contains(t, event, "datname")
+	assert.IsType(t, int64(0), event["numbackends"])
+}
+
+type testReporter struct {
+	events []common.MapStr
+	errs   []error
+}
+
+func (r *testReporter) Event(event common.MapStr) bool {
+	r.events = append(r.events, event)
+	return true
+}
+
+func (r *testReporter) ErrorWith(err error, meta common.MapStr) bool {
+	r.errs = append(r.errs, err)
+	return true
+}
+
+func (r *testReporter) Error(err error) bool { return r.ErrorWith(err, nil) }
+
+func fetch(t *testing.T, ms mb.ReportingMetricSet) []common.MapStr {
+	m := ms.(*MetricSet)
+	defer m.Close()
+
+	r := &testReporter{}
+	m.Fetch(r)
+	for _, err := range r.errs {
+		t.Error(err)
+	}
+	return r.events
+}
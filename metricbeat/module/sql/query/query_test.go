@@ -0,0 +1,272 @@
+// +build !integration
+
+package query
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	mbtest "github.com/elastic/beats/metricbeat/mb/testing"
+)
+
+// testResults are the results of the queries of the test driver. The values
+// are returned as text, like the mysql driver does.
+var testResults = map[string]struct {
+	columns []string
+	rows    [][]driver.Value
+	delay   time.Duration
+}{
+	"SELECT schema, tables, size FROM schemas": {
+		columns: []string{"schema", "tables", "size"},
+		rows: [][]driver.Value{
+			{[]byte("app"), []byte("12"), []byte("1.5")},
+			{[]byte("logs"), []byte("3"), nil},
+		},
+	},
+	"SHOW STATUS": {
+		columns: []string{"Variable_name", "Value"},
+		rows: [][]driver.Value{
+			{[]byte("Threads_connected"), []byte("7")},
+			{[]byte("Uptime"), []byte("3600")},
+			{[]byte("Ssl_cipher"), []byte("")},
+		},
+	},
+	"SELECT now": {
+		columns: []string{"now", "active"},
+		rows: [][]driver.Value{
+			{time.Unix(1500000000, 0).UTC(), true},
+		},
+	},
+	"SELECT sleep": {
+		columns: []string{"sleep"},
+		rows:    [][]driver.Value{{int64(0)}},
+		delay:   time.Second,
+	},
+}
+
+func init() {
+	sql.Register("sqltest", testDriver{})
+}
+
+type testDriver struct{}
+
+func (testDriver) Open(name string) (driver.Conn, error) { return testConn{}, nil }
+
+type testConn struct{}
+
+func (testConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+func (testConn) Close() error              { return nil }
+func (testConn) Begin() (driver.Tx, error) { return nil, errors.New("transactions are not supported") }
+
+func (testConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result, found := testResults[query]
+	if !found {
+		return nil, errors.New("syntax error")
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(result.delay):
+	}
+	return &testRows{columns: result.columns, rows: result.rows}, nil
+}
+
+type testRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *testRows) Columns() []string { return r.columns }
+func (r *testRows) Close() error      { return nil }
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+type testReporter struct {
+	events []common.MapStr
+	errs   []error
+}
+
+func (r *testReporter) Event(event common.MapStr) bool {
+	r.events = append(r.events, event)
+	return true
+}
+
+func (r *testReporter) ErrorWith(err error, meta common.MapStr) bool {
+	r.errs = append(r.errs, err)
+	return true
+}
+
+func (r *testReporter) Error(err error) bool { return r.ErrorWith(err, nil) }
+
+// newTestMetricSet returns a metricset running the queries with the test
+// driver. Like the postgres driver, the test driver cancels the queries with
+// their context, so all the queries use the same database handle.
+func newTestMetricSet(t *testing.T, queries []map[string]interface{}) *MetricSet {
+	m := mbtest.NewReportingMetricSet(t, map[string]interface{}{
+		"module":     "sql",
+		"metricsets": []string{"query"},
+		"hosts":      []string{"postgres://127.0.0.1:5432/"},
+		"driver":     "postgres",
+		"queries":    queries,
+		"timeout":    "100ms",
+	}).(*MetricSet)
+
+	// Replace the database by the test driver before the first fetch.
+	db, err := sql.Open("sqltest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.dbs[0] = db
+	return m
+}
+
+func TestFetchTable(t *testing.T) {
+	m := newTestMetricSet(t, []map[string]interface{}{
+		{"namespace": "schemas", "query": "SELECT schema, tables, size FROM schemas"},
+		{"namespace": "now", "query": "SELECT now"},
+	})
+	defer m.Close()
+
+	r := &testReporter{}
+	m.Fetch(r)
+
+	assert.Empty(t, r.errs)
+	assert.Equal(t, []common.MapStr{
+		{"_namespace": "schemas", "schema": "app", "tables": int64(12), "size": 1.5},
+		{"_namespace": "schemas", "schema": "logs", "tables": int64(3)},
+		{"_namespace": "now", "now": common.Time(time.Unix(1500000000, 0).UTC()), "active": true},
+	}, r.events)
+}
+
+func TestFetchVariables(t *testing.T) {
+	m := newTestMetricSet(t, []map[string]interface{}{
+		{"namespace": "status", "query": "SHOW STATUS", "response_format": "variables"},
+		{"namespace": "invalid", "query": "SELECT schema, tables, size FROM schemas", "response_format": "variables"},
+	})
+	defer m.Close()
+
+	r := &testReporter{}
+	m.Fetch(r)
+
+	assert.Equal(t, []common.MapStr{
+		{
+			"_namespace":        "status",
+			"Threads_connected": int64(7),
+			"Uptime":            int64(3600),
+			"Ssl_cipher":        "",
+		},
+	}, r.events)
+	if assert.Len(t, r.errs, 1) {
+		assert.Contains(t, r.errs[0].Error(), "requires 2 columns")
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	m := newTestMetricSet(t, []map[string]interface{}{
+		{"namespace": "invalid", "query": "SELEC 1"},
+		{"namespace": "slow", "query": "SELECT sleep", "timeout": "10ms"},
+		{"namespace": "now", "query": "SELECT now"},
+	})
+	defer m.Close()
+
+	r := &testReporter{}
+	m.Fetch(r)
+
+	// The failing queries don't prevent the other queries from running.
+	assert.Len(t, r.events, 1)
+	if assert.Len(t, r.errs, 2) {
+		assert.Contains(t, r.errs[0].Error(), "query 'invalid' failed")
+		assert.Contains(t, r.errs[1].Error(), context.DeadlineExceeded.Error())
+	}
+}
+
+// Verify that the timeout of a query is enforced with the mysql driver, which
+// doesn't support contexts, against a server that never answers.
+func TestFetchMySQLTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	m := mbtest.NewReportingMetricSet(t, map[string]interface{}{
+		"module":     "sql",
+		"metricsets": []string{"query"},
+		"hosts":      []string{"root@tcp(" + l.Addr().String() + ")/"},
+		"driver":     "mysql",
+		"queries": []map[string]interface{}{
+			{"namespace": "slow", "query": "SELECT 1", "timeout": "50ms"},
+		},
+		"timeout": "1h",
+	}).(*MetricSet)
+	defer m.Close()
+
+	r := &testReporter{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Fetch(r)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("query did not time out")
+	}
+	assert.Empty(t, r.events)
+	if assert.Len(t, r.errs, 1) {
+		assert.Contains(t, r.errs[0].Error(), "query 'slow' failed")
+	}
+}
+
+func TestCoerce(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{[]byte("42"), int64(42)},
+		{[]byte("-3"), int64(-3)},
+		{[]byte("0.25"), 0.25},
+		{[]byte("1e3"), 1000.0},
+		{[]byte("NaN"), "NaN"},
+		{[]byte("Inf"), "Inf"},
+		{[]byte("ON"), "ON"},
+		{"12", int64(12)},
+		{int64(7), int64(7)},
+		{2.5, 2.5},
+		{true, true},
+		{nil, nil},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, coerce(test.value), "%v", test.value)
+	}
+}
@@ -0,0 +1,4 @@
+/*
+Package statsd is a Metricbeat module that contains MetricSets.
+*/
+package statsd
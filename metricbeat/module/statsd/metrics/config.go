@@ -0,0 +1,39 @@
+package metrics
+
+import "fmt"
+
+type config struct {
+	Host     string `config:"host"`
+	Port     int    `config:"port"`
+	Protocol string `config:"protocol"`
+
+	// Percentiles of the timers and histograms to report
+	Percentiles []float64 `config:"percentiles"`
+
+	// Number of periods without update after which a gauge is removed
+	GaugeExpirationPeriods int `config:"gauge_expiration_periods"`
+}
+
+var defaultConfig = config{
+	Host:        "localhost",
+	Port:        8125,
+	Protocol:    "udp",
+	Percentiles: []float64{50, 90, 95, 99},
+
+	GaugeExpirationPeriods: 5,
+}
+
+func (c *config) Validate() error {
+	if c.Protocol != "udp" && c.Protocol != "tcp" {
+		return fmt.Errorf("protocol must be udp or tcp, got '%s'", c.Protocol)
+	}
+	for _, p := range c.Percentiles {
+		if p <= 0 || p > 100 {
+			return fmt.Errorf("percentile %v out of range (0, 100]", p)
+		}
+	}
+	if c.GaugeExpirationPeriods < 1 {
+		return fmt.Errorf("gauge_expiration_periods must be >= 1, got %d", c.GaugeExpirationPeriods)
+	}
+	return nil
+}
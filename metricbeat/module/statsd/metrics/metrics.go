@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/helper/server"
+	"github.com/elastic/beats/metricbeat/mb"
+)
+
+var debugf = logp.MakeDebug("statsd")
+
+// init registers the MetricSet with the central registry.
+func init() {
+	if err := mb.Registry.AddMetricSet("statsd", "metrics", New); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet receives the metrics of StatsD clients, aggregates them over the
+// period of the module, and reports one event per metric on each period.
+type MetricSet struct {
+	mb.BaseMetricSet
+	config config
+	period time.Duration
+
+	mutex    sync.Mutex
+	registry *registry
+}
+
+// New creates a new instance of the MetricSet.
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	logp.Beta("The statsd metrics metricset is beta")
+
+	config := defaultConfig
+	if err := base.Module().UnpackConfig(&config); err != nil {
+		return nil, err
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		config:        config,
+		period:        base.Module().Config().Period,
+		registry:      newRegistry(config.Percentiles, config.GaugeExpirationPeriods),
+	}, nil
+}
+
+// Run listens for the metrics until the reporter is done.
+func (m *MetricSet) Run(r mb.PushReporter) {
+	address := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+	s, err := listen(m.config.Protocol, address)
+	if err != nil {
+		r.Error(err)
+		return
+	}
+	logp.Info("StatsD server listening on %s/%s", m.config.Protocol, s.Addr())
+
+	m.run(s, r)
+}
+
+func listen(protocol, address string) (server.Server, error) {
+	if protocol == "tcp" {
+		return server.NewTCPServer(address, bufio.ScanLines)
+	}
+	return server.NewUDPServer(address)
+}
+
+func (m *MetricSet) run(s server.Server, r mb.PushReporter) {
+	s.Start(m.handle)
+	defer s.Stop()
+
+	ticker := time.NewTicker(m.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Done():
+			return
+		case <-ticker.C:
+			m.mutex.Lock()
+			events := m.registry.flush(m.period)
+			m.mutex.Unlock()
+
+			for _, event := range events {
+				if !r.Event(event) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// handle aggregates the metrics of a packet.
+func (m *MetricSet) handle(packet []byte) {
+	metrics := parseLines(packet, func(line []byte, err error) {
+		debugf("Dropping invalid metric '%s': %v", line, err)
+	})
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, metric := range metrics {
+		m.registry.add(metric)
+	}
+}
@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func add(r *registry, lines ...string) {
+	for _, line := range lines {
+		m, err := parseLine(line)
+		if err != nil {
+			panic(err)
+		}
+		r.add(m)
+	}
+}
+
+func TestRegistryCounter(t *testing.T) {
+	r := newRegistry(nil, 5)
+	add(r, "requests:1|c", "requests:2|c", "requests:1|c|@0.5")
+
+	events := r.flush(10 * time.Second)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, common.MapStr{
+			"name":        "requests",
+			"metric_type": "counter",
+			"counter": common.MapStr{
+				"value": 5.0,
+				"rate":  0.5,
+			},
+		}, events[0])
+	}
+
+	// Counters are reset on flush
+	assert.Len(t, r.flush(10*time.Second), 0)
+}
+
+func TestRegistryGauge(t *testing.T) {
+	r := newRegistry(nil, 5)
+	add(r, "queue:10|g", "queue:+5|g", "queue:-3|g")
+
+	events := r.flush(10 * time.Second)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, common.MapStr{"value": 12.0}, events[0]["gauge"])
+	}
+
+	// Gauges keep their value
+	events = r.flush(10 * time.Second)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, common.MapStr{"value": 12.0}, events[0]["gauge"])
+	}
+
+	add(r, "queue:7|g")
+	events = r.flush(10 * time.Second)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, common.MapStr{"value": 7.0}, events[0]["gauge"])
+	}
+}
+
+func TestRegistryGaugeExpiration(t *testing.T) {
+	r := newRegistry(nil, 2)
+	add(r, "queue:10|g")
+	assert.Len(t, r.flush(10*time.Second), 1)
+	assert.Len(t, r.flush(10*time.Second), 1)
+
+	// updating the gauge resets its expiration
+	add(r, "queue:+1|g")
+	assert.Len(t, r.flush(10*time.Second), 1)
+	assert.Len(t, r.flush(10*time.Second), 1)
+
+	// not received for 2 periods
+	assert.Len(t, r.flush(10*time.Second), 0)
+	assert.Len(t, r.metrics, 0)
+}
+
+func TestRegistryTimer(t *testing.T) {
+	r := newRegistry([]float64{50, 90, 99.9}, 5)
+	for i := 10; i >= 1; i-- {
+		add(r, "latency:"+strconv.Itoa(i*10)+"|ms")
+	}
+	add(r, "latency:100|ms|@0.5")
+
+	events := r.flush(10 * time.Second)
+	if !assert.Len(t, events, 1) {
+		return
+	}
+	assert.Equal(t, common.MapStr{
+		"count": 12.0,
+		"sum":   650.0,
+		"min":   10.0,
+		"max":   100.0,
+		"mean":  650.0 / 11,
+		"percentiles": common.MapStr{
+			"p50":   60.0,
+			"p90":   100.0,
+			"p99_9": 100.0,
+		},
+	}, events[0]["timer"])
+}
+
+func TestRegistrySetAndTags(t *testing.T) {
+	r := newRegistry(nil, 5)
+	add(r,
+		"users:alice|s", "users:bob|s", "users:alice|s",
+		"requests:1|c|#region:eu,env:prod",
+		"requests:1|c|#env:prod,region:eu",
+		"requests:1|c|#region:us",
+	)
+
+	events := r.flush(10 * time.Second)
+	if !assert.Len(t, events, 3) {
+		return
+	}
+
+	// Events are sorted by type, name and tags
+	assert.Equal(t, common.MapStr{"env": "prod", "region": "eu"}, events[0]["tags"])
+	assert.Equal(t, 2.0, events[0]["counter"].(common.MapStr)["value"])
+	assert.Equal(t, common.MapStr{"region": "us"}, events[1]["tags"])
+	assert.Equal(t, common.MapStr{"count": 2}, events[2]["set"])
+}
+
+type testReporter struct {
+	events chan common.MapStr
+	done   chan struct{}
+}
+
+func (r *testReporter) Event(event common.MapStr) bool {
+	r.events <- event
+	return true
+}
+
+func (r *testReporter) ErrorWith(err error, meta common.MapStr) bool { return true }
+func (r *testReporter) Error(err error) bool                         { return true }
+func (r *testReporter) Done() <-chan struct{}                        { return r.done }
+
+func TestServer(t *testing.T) {
+	for _, protocol := range []string{"udp", "tcp"} {
+		s, err := listen(protocol, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m := &MetricSet{
+			period:   50 * time.Millisecond,
+			registry: newRegistry(nil, 5),
+		}
+		r := &testReporter{
+			events: make(chan common.MapStr, 10),
+			done:   make(chan struct{}),
+		}
+		stopped := make(chan struct{})
+		go func() {
+			m.run(s, r)
+			close(stopped)
+		}()
+
+		conn, err := net.Dial(protocol, s.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = conn.Write([]byte("requests:1|c\nrequests:2|c\n"))
+		assert.NoError(t, err)
+
+		select {
+		case event := <-r.events:
+			assert.Equal(t, "requests", event["name"], protocol)
+			assert.Equal(t, 3.0, event["counter"].(common.MapStr)["value"], protocol)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %s event", protocol)
+		}
+
+		conn.Close()
+		close(r.done)
+		<-stopped
+	}
+}
@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Types of the StatsD metrics.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeTimer     = "timer"
+	typeHistogram = "histogram"
+	typeSet       = "set"
+)
+
+var metricTypes = map[string]string{
+	"c":  typeCounter,
+	"g":  typeGauge,
+	"ms": typeTimer,
+	"h":  typeHistogram,
+	"s":  typeSet,
+}
+
+var errEmptyName = errors.New("empty metric name")
+
+// metric is a sample of the StatsD line protocol:
+//
+//	<name>:<value>|<type>[|@<sample rate>][|#<tag>:<value>,<tag>]
+//
+// The tags are the DogStatsD extension of the protocol.
+type metric struct {
+	name  string
+	typ   string
+	value string
+
+	// relative is set for the gauge updates with a sign, like "-10".
+	relative   bool
+	sampleRate float64
+	tags       map[string]string
+}
+
+// parseLines parses the metrics of a packet, one per line. The lines that
+// can't be parsed are reported with their error.
+func parseLines(packet []byte, onError func(line []byte, err error)) []metric {
+	var metrics []metric
+	for _, line := range bytes.Split(packet, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		m, err := parseLine(string(line))
+		if err != nil {
+			onError(line, err)
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func parseLine(line string) (metric, error) {
+	m := metric{sampleRate: 1}
+
+	// the tags after the first pipe may contain colons too
+	pipe := strings.Index(line, "|")
+	if pipe < 0 {
+		return m, fmt.Errorf("invalid metric '%s'", line)
+	}
+	colon := strings.Index(line[:pipe], ":")
+	if colon < 0 {
+		return m, fmt.Errorf("invalid metric '%s'", line)
+	}
+
+	m.name = line[:colon]
+	if m.name == "" {
+		return m, errEmptyName
+	}
+
+	fields := strings.Split(line[colon+1:], "|")
+	if len(fields) < 2 {
+		return m, fmt.Errorf("missing type of metric '%s'", m.name)
+	}
+
+	typ, found := metricTypes[fields[1]]
+	if !found {
+		return m, fmt.Errorf("unknown type '%s' of metric '%s'", fields[1], m.name)
+	}
+	m.typ = typ
+
+	m.value = fields[0]
+	if typ != typeSet {
+		if m.value == "" {
+			return m, fmt.Errorf("empty value of metric '%s'", m.name)
+		}
+		if typ == typeGauge && (m.value[0] == '+' || m.value[0] == '-') {
+			m.relative = true
+		}
+		if v, err := strconv.ParseFloat(m.value, 64); err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return m, fmt.Errorf("invalid value '%s' of metric '%s'", m.value, m.name)
+		}
+	}
+
+	for _, field := range fields[2:] {
+		switch {
+		case strings.HasPrefix(field, "@"):
+			rate, err := strconv.ParseFloat(field[1:], 64)
+			if err != nil || !(rate > 0 && rate <= 1) {
+				return m, fmt.Errorf("invalid sample rate '%s' of metric '%s'", field[1:], m.name)
+			}
+			m.sampleRate = rate
+
+		case strings.HasPrefix(field, "#"):
+			m.tags = parseTags(field[1:])
+		}
+	}
+	return m, nil
+}
+
+// parseTags parses the DogStatsD tags. The tags without value are set to
+// true.
+func parseTags(s string) map[string]string {
+	tags := map[string]string{}
+	for _, tag := range strings.Split(s, ",") {
+		if tag == "" {
+			continue
+		}
+		if sep := strings.Index(tag, ":"); sep >= 0 {
+			tags[tag[:sep]] = tag[sep+1:]
+		} else {
+			tags[tag] = "true"
+		}
+	}
+	return tags
+}
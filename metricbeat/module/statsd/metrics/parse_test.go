@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected metric
+	}{
+		{
+			line:     "api.requests:1|c",
+			expected: metric{name: "api.requests", typ: typeCounter, value: "1", sampleRate: 1},
+		},
+		{
+			line:     "api.requests:3|c|@0.1",
+			expected: metric{name: "api.requests", typ: typeCounter, value: "3", sampleRate: 0.1},
+		},
+		{
+			line:     "queue.size:42.5|g",
+			expected: metric{name: "queue.size", typ: typeGauge, value: "42.5", sampleRate: 1},
+		},
+		{
+			line:     "queue.size:-3|g",
+			expected: metric{name: "queue.size", typ: typeGauge, value: "-3", relative: true, sampleRate: 1},
+		},
+		{
+			line: "api.latency:320|ms|@0.5|#endpoint:/users,canary",
+			expected: metric{
+				name:       "api.latency",
+				typ:        typeTimer,
+				value:      "320",
+				sampleRate: 0.5,
+				tags:       map[string]string{"endpoint": "/users", "canary": "true"},
+			},
+		},
+		{
+			line:     "payload.size:2048|h",
+			expected: metric{name: "payload.size", typ: typeHistogram, value: "2048", sampleRate: 1},
+		},
+		{
+			line:     "users.unique:alice|s",
+			expected: metric{name: "users.unique", typ: typeSet, value: "alice", sampleRate: 1},
+		},
+	}
+
+	for _, test := range tests {
+		m, err := parseLine(test.line)
+		if assert.NoError(t, err, test.line) {
+			assert.Equal(t, test.expected, m, test.line)
+		}
+	}
+}
+
+func TestParseLineErrors(t *testing.T) {
+	lines := []string{
+		"api.requests",
+		"api.requests:1",
+		":1|c",
+		"api.requests:1|x",
+		"api.requests:abc|c",
+		"api.requests:|g",
+		"api.requests:1|c|@2",
+		"api.requests:1|c|@abc",
+		"api.requests:1|c|@NaN",
+		"queue:NaN|g",
+		"queue:+Inf|g",
+		"latency:-Inf|ms",
+		"latency:infinity|h",
+	}
+
+	for _, line := range lines {
+		_, err := parseLine(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestParseLines(t *testing.T) {
+	var invalid []string
+	metrics := parseLines([]byte("a:1|c\ninvalid\n\nb:2|g\n"), func(line []byte, err error) {
+		invalid = append(invalid, string(line))
+	})
+
+	if assert.Len(t, metrics, 2) {
+		assert.Equal(t, "a", metrics[0].name)
+		assert.Equal(t, "b", metrics[1].name)
+	}
+	assert.Equal(t, []string{"invalid"}, invalid)
+}
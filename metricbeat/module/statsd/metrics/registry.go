@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// registry aggregates the metrics received during a period.
+type registry struct {
+	percentiles     []float64
+	gaugeExpiration int // in periods
+	metrics         map[string]*aggregate
+}
+
+// aggregate is the state of a metric, identified by its name, type and tags.
+type aggregate struct {
+	name string
+	typ  string
+	tags map[string]string
+
+	// updated is set when the metric was received during the period
+	updated bool
+
+	// number of periods since the gauge was last received
+	idle int
+
+	counter float64
+	gauge   float64
+
+	// timer and histogram values, count is scaled by the sample rates
+	values []float64
+	count  float64
+
+	set map[string]struct{}
+}
+
+func newRegistry(percentiles []float64, gaugeExpiration int) *registry {
+	return &registry{
+		percentiles:     percentiles,
+		gaugeExpiration: gaugeExpiration,
+		metrics:         map[string]*aggregate{},
+	}
+}
+
+func metricKey(m *metric) string {
+	if len(m.tags) == 0 {
+		return m.typ + "|" + m.name
+	}
+
+	tags := make([]string, 0, len(m.tags))
+	for k, v := range m.tags {
+		tags = append(tags, k+":"+v)
+	}
+	sort.Strings(tags)
+	return m.typ + "|" + m.name + "|" + strings.Join(tags, ",")
+}
+
+func (r *registry) add(m metric) {
+	key := metricKey(&m)
+	a := r.metrics[key]
+	if a == nil {
+		a = &aggregate{
+			name: m.name,
+			typ:  m.typ,
+			tags: m.tags,
+		}
+		r.metrics[key] = a
+	}
+	a.updated = true
+
+	switch m.typ {
+	case typeCounter:
+		v, _ := strconv.ParseFloat(m.value, 64)
+		a.counter += v / m.sampleRate
+
+	case typeGauge:
+		v, _ := strconv.ParseFloat(m.value, 64)
+		if m.relative {
+			a.gauge += v
+		} else {
+			a.gauge = v
+		}
+
+	case typeTimer, typeHistogram:
+		v, _ := strconv.ParseFloat(m.value, 64)
+		a.values = append(a.values, v)
+		a.count += 1 / m.sampleRate
+
+	case typeSet:
+		if a.set == nil {
+			a.set = map[string]struct{}{}
+		}
+		a.set[m.value] = struct{}{}
+	}
+}
+
+// flush returns the events of the metrics of the period, and resets them.
+// The gauges keep their last value, and are reported on every period until
+// they are not received for gaugeExpiration periods.
+func (r *registry) flush(period time.Duration) []common.MapStr {
+	keys := make([]string, 0, len(r.metrics))
+	for key := range r.metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var events []common.MapStr
+	for _, key := range keys {
+		a := r.metrics[key]
+		if a.typ != typeGauge {
+			delete(r.metrics, key)
+		} else if a.updated {
+			a.idle = 0
+		} else if a.idle++; a.idle >= r.gaugeExpiration {
+			delete(r.metrics, key)
+			continue
+		}
+		events = append(events, a.event(period, r.percentiles))
+		a.updated = false
+	}
+	return events
+}
+
+func (a *aggregate) event(period time.Duration, percentiles []float64) common.MapStr {
+	// "type" is reserved for the type of the document
+	event := common.MapStr{
+		"name":        a.name,
+		"metric_type": a.typ,
+	}
+	if len(a.tags) > 0 {
+		tags := common.MapStr{}
+		for k, v := range a.tags {
+			tags[k] = v
+		}
+		event["tags"] = tags
+	}
+
+	switch a.typ {
+	case typeCounter:
+		counter := common.MapStr{"value": a.counter}
+		if period > 0 {
+			counter["rate"] = a.counter / period.Seconds()
+		}
+		event["counter"] = counter
+
+	case typeGauge:
+		event["gauge"] = common.MapStr{"value": a.gauge}
+
+	case typeTimer, typeHistogram:
+		event[a.typ] = summary(a.values, a.count, percentiles)
+
+	case typeSet:
+		event["set"] = common.MapStr{"count": len(a.set)}
+	}
+	return event
+}
+
+// summary returns the statistics of the values of a timer or histogram.
+func summary(values []float64, count float64, percentiles []float64) common.MapStr {
+	sort.Float64s(values)
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+
+	s := common.MapStr{
+		"count": count,
+		"sum":   sum,
+		"min":   values[0],
+		"max":   values[len(values)-1],
+		"mean":  sum / float64(len(values)),
+	}
+	if len(percentiles) > 0 {
+		p := common.MapStr{}
+		for _, percentile := range percentiles {
+			p[percentileKey(percentile)] = percentileOf(values, percentile)
+		}
+		s["percentiles"] = p
+	}
+	return s
+}
+
+// percentileOf returns the nearest-rank percentile of the sorted values.
+func percentileOf(sorted []float64, percentile float64) float64 {
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// percentileKey names the field of a percentile, like p99 or p99_9.
+func percentileKey(percentile float64) string {
+	return "p" + strings.Replace(strconv.FormatFloat(percentile, 'f', -1, 64), ".", "_", -1)
+}
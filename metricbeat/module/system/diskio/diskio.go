@@ -3,6 +3,8 @@
 package diskio
 
 import (
+	"math"
+
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/metricbeat/mb"
 	"github.com/elastic/beats/metricbeat/mb/parse"
@@ -49,7 +51,9 @@ func (m *MetricSet) Fetch() ([]common.MapStr, error) {
 				"bytes": counters.WriteBytes,
 			},
 			"io": common.MapStr{
-				"time": counters.IoTime,
+				"ops":           counters.IopsInProgress,
+				"time":          counters.IoTime,
+				"weighted_time": counters.WeightedIO,
 			},
 		}
 		events = append(events, event)
@@ -61,3 +65,59 @@ func (m *MetricSet) Fetch() ([]common.MapStr, error) {
 
 	return events, nil
 }
+
+// Counters declares the counters of the disks, for which the rates are
+// reported, and the iostat metrics derived from them.
+func (m *MetricSet) Counters() mb.Counters {
+	return mb.Counters{
+		Fields: []string{
+			"read.count", "read.bytes", "read.time",
+			"write.count", "write.bytes", "write.time",
+			"io.time", "io.weighted_time",
+		},
+		Keys:   []string{"name"},
+		Derive: iostat,
+	}
+}
+
+// iostat adds the extended statistics reported by iostat -x, computed from
+// the rates of the counters. The times are in milliseconds.
+func iostat(event common.MapStr) {
+	rate := func(field string) (float64, bool) {
+		v, err := event.GetValue(field + mb.RateSuffix)
+		if err != nil {
+			return 0, false
+		}
+		f, ok := v.(float64)
+		return f, ok
+	}
+
+	readCount, ok1 := rate("read.count")
+	readTime, ok2 := rate("read.time")
+	writeCount, ok3 := rate("write.count")
+	writeTime, ok4 := rate("write.time")
+	ioTime, ok5 := rate("io.time")
+	weightedTime, ok6 := rate("io.weighted_time")
+	if !(ok1 && ok2 && ok3 && ok4 && ok5 && ok6) {
+		// First sample or reset counters.
+		return
+	}
+
+	stats := common.MapStr{
+		// The disk is busy for at most 1000ms per second.
+		"utilization": common.MapStr{"pct": math.Min(ioTime/1000, 1)},
+		"queue":       common.MapStr{"avg_size": weightedTime / 1000},
+		"read":        common.MapStr{"await": await(readTime, readCount)},
+		"write":       common.MapStr{"await": await(writeTime, writeCount)},
+		"await":       await(readTime+writeTime, readCount+writeCount),
+	}
+	event["iostat"] = stats
+}
+
+// await returns the average time of the requests, or 0 without requests.
+func await(total, count float64) float64 {
+	if count == 0 {
+		return 0
+	}
+	return total / count
+}
@@ -8,7 +8,10 @@ import (
 
 	"time"
 
+	"github.com/elastic/beats/libbeat/common"
 	mbtest "github.com/elastic/beats/metricbeat/mb/testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestData(t *testing.T) {
@@ -30,3 +33,34 @@ func getConfig() map[string]interface{} {
 		"metricsets": []string{"diskio"},
 	}
 }
+
+func TestIostat(t *testing.T) {
+	event := common.MapStr{
+		"read": common.MapStr{
+			"count_per_sec": 100.0,
+			"time_per_sec":  200.0,
+		},
+		"write": common.MapStr{
+			"count_per_sec": 0.0,
+			"time_per_sec":  0.0,
+		},
+		"io": common.MapStr{
+			"time_per_sec":          500.0,
+			"weighted_time_per_sec": 1500.0,
+		},
+	}
+	iostat(event)
+
+	assert.Equal(t, common.MapStr{
+		"utilization": common.MapStr{"pct": 0.5},
+		"queue":       common.MapStr{"avg_size": 1.5},
+		"read":        common.MapStr{"await": 2.0},
+		"write":       common.MapStr{"await": 0.0},
+		"await":       2.0,
+	}, event["iostat"])
+
+	// No rates on the first sample.
+	event = common.MapStr{"read": common.MapStr{"count": 10}}
+	iostat(event)
+	assert.NotContains(t, event, "iostat")
+}
@@ -0,0 +1,58 @@
+package netstat
+
+import (
+	s "github.com/elastic/beats/metricbeat/schema"
+	c "github.com/elastic/beats/metricbeat/schema/mapstrstr"
+)
+
+var (
+	// schema maps the counters of the Tcp line of /proc/net/snmp, and of the
+	// TcpExt line of /proc/net/netstat. The keys are prefixed by the name of
+	// their line. The TcpExt counters depend on the kernel, they are optional.
+	schema = s.Schema{
+		"tcp": s.Object{
+			"active_opens":       c.Int("Tcp.ActiveOpens"),
+			"passive_opens":      c.Int("Tcp.PassiveOpens"),
+			"attempt_fails":      c.Int("Tcp.AttemptFails"),
+			"established_resets": c.Int("Tcp.EstabResets"),
+			"established":        c.Int("Tcp.CurrEstab"),
+			"segments": s.Object{
+				"in":            c.Int("Tcp.InSegs"),
+				"out":           c.Int("Tcp.OutSegs"),
+				"retransmitted": c.Int("Tcp.RetransSegs"),
+				"in_errors":     c.Int("Tcp.InErrs"),
+			},
+			"resets": s.Object{
+				"out": c.Int("Tcp.OutRsts"),
+			},
+			"listen": s.Object{
+				"overflows": c.Int("TcpExt.ListenOverflows", s.Optional),
+				"drops":     c.Int("TcpExt.ListenDrops", s.Optional),
+			},
+			"syn_retransmits": c.Int("TcpExt.TCPSynRetrans", s.Optional),
+			"timeouts":        c.Int("TcpExt.TCPTimeouts", s.Optional),
+			"aborts": s.Object{
+				"on_data":    c.Int("TcpExt.TCPAbortOnData", s.Optional),
+				"on_close":   c.Int("TcpExt.TCPAbortOnClose", s.Optional),
+				"on_memory":  c.Int("TcpExt.TCPAbortOnMemory", s.Optional),
+				"on_timeout": c.Int("TcpExt.TCPAbortOnTimeout", s.Optional),
+				"on_linger":  c.Int("TcpExt.TCPAbortOnLinger", s.Optional),
+				"failed":     c.Int("TcpExt.TCPAbortFailed", s.Optional),
+			},
+		},
+	}
+
+	// counters are the fields for which the rates are reported. The number of
+	// established connections is a gauge.
+	counters = []string{
+		"tcp.active_opens", "tcp.passive_opens",
+		"tcp.attempt_fails", "tcp.established_resets",
+		"tcp.segments.in", "tcp.segments.out",
+		"tcp.segments.retransmitted", "tcp.segments.in_errors",
+		"tcp.resets.out",
+		"tcp.listen.overflows", "tcp.listen.drops",
+		"tcp.syn_retransmits", "tcp.timeouts",
+		"tcp.aborts.on_data", "tcp.aborts.on_close", "tcp.aborts.on_memory",
+		"tcp.aborts.on_timeout", "tcp.aborts.on_linger", "tcp.aborts.failed",
+	}
+)
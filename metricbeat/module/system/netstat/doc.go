@@ -0,0 +1,6 @@
+/*
+Package netstat collects the TCP counters of /proc/net/snmp and
+/proc/net/netstat, like the retransmitted segments, the resets and the listen
+queue overflows. It is implemented for linux.
+*/
+package netstat
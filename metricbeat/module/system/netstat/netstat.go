@@ -0,0 +1,140 @@
+// +build linux
+
+package netstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/elastic/beats/metricbeat/mb/parse"
+	"github.com/elastic/beats/metricbeat/module/system"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	if err := mb.Registry.AddMetricSet("system", "netstat", New, parse.EmptyHostParser); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet for fetching the TCP counters.
+type MetricSet struct {
+	mb.BaseMetricSet
+	procRoot string
+}
+
+// New is a mb.MetricSetFactory that returns a new MetricSet.
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	logp.Beta("The system netstat metricset is beta")
+
+	systemModule, ok := base.Module().(*system.Module)
+	if !ok {
+		return nil, errors.New("unexpected module type")
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		procRoot:      filepath.Join(systemModule.HostFS, "/proc"),
+	}, nil
+}
+
+// Fetch fetches the TCP counters. /proc/net/netstat is optional, its
+// counters are not reported if it doesn't exist.
+func (m *MetricSet) Fetch() (common.MapStr, error) {
+	counters := map[string]interface{}{}
+	for _, name := range []string{"snmp", "netstat"} {
+		err := readCounters(filepath.Join(m.procRoot, "net", name), counters)
+		if err != nil {
+			if name == "netstat" && os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Wrap(err, name)
+		}
+	}
+
+	event, errs := schema.Apply(counters)
+	if errs.HasRequiredErrors() {
+		return nil, errs
+	}
+	return event, nil
+}
+
+// Counters declares the TCP counters, for which the rates are reported.
+func (m *MetricSet) Counters() mb.Counters {
+	return mb.Counters{
+		Fields: counters,
+		Derive: retransmits,
+	}
+}
+
+// retransmits adds the share of the segments sent that were retransmissions,
+// computed from the rates of the counters.
+func retransmits(event common.MapStr) {
+	rate := func(field string) (float64, bool) {
+		v, err := event.GetValue(field + mb.RateSuffix)
+		if err != nil {
+			return 0, false
+		}
+		f, ok := v.(float64)
+		return f, ok
+	}
+
+	retransmitted, ok1 := rate("tcp.segments.retransmitted")
+	out, ok2 := rate("tcp.segments.out")
+	if !(ok1 && ok2) {
+		// First sample or reset counters.
+		return
+	}
+
+	var pct float64
+	if out > 0 {
+		pct = system.Round(retransmitted/out, .5, 4)
+	}
+	event.Put("tcp.retransmits.pct", pct)
+}
+
+// readCounters reads the counters of a file of /proc/net, made of pairs of
+// lines with the names and the values of the counters of a protocol:
+//
+//	Tcp: RtoAlgorithm RtoMin RtoMax MaxConn ActiveOpens ...
+//	Tcp: 1 200 120000 -1 1287345 ...
+//
+// The counters are added by name prefixed by the protocol, like
+// Tcp.ActiveOpens.
+func readCounters(path string, counters map[string]interface{}) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		names := strings.Fields(scanner.Text())
+		if len(names) == 0 {
+			continue
+		}
+		if !scanner.Scan() {
+			return fmt.Errorf("missing values of '%s'", names[0])
+		}
+		values := strings.Fields(scanner.Text())
+		if len(values) != len(names) || values[0] != names[0] {
+			return fmt.Errorf("values '%s' don't match the names '%s'",
+				scanner.Text(), strings.Join(names, " "))
+		}
+
+		protocol := strings.TrimSuffix(names[0], ":")
+		for i := 1; i < len(names); i++ {
+			counters[protocol+"."+names[i]] = values[i]
+		}
+	}
+	return scanner.Err()
+}
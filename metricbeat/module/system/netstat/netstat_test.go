@@ -0,0 +1,157 @@
+// +build linux
+
+package netstat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	mbtest "github.com/elastic/beats/metricbeat/mb/testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// procRoot is a fake /proc with the net/snmp and net/netstat files.
+var procRoot = filepath.Join("..", "_meta", "testdata", "proc")
+
+func TestData(t *testing.T) {
+	f := mbtest.NewEventFetcher(t, getConfig())
+	f.(*MetricSet).procRoot = procRoot
+
+	err := mbtest.WriteEvent(f, t)
+	if err != nil {
+		t.Fatal("write", err)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	f := mbtest.NewEventFetcher(t, getConfig())
+	f.(*MetricSet).procRoot = procRoot
+
+	event, err := f.Fetch()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, common.MapStr{
+		"tcp": common.MapStr{
+			"active_opens":       int64(1287345),
+			"passive_opens":      int64(412876),
+			"attempt_fails":      int64(2341),
+			"established_resets": int64(8723),
+			"established":        int64(87),
+			"segments": common.MapStr{
+				"in":            int64(97812345),
+				"out":           int64(102938471),
+				"retransmitted": int64(51234),
+				"in_errors":     int64(17),
+			},
+			"resets": common.MapStr{
+				"out": int64(23871),
+			},
+			"listen": common.MapStr{
+				"overflows": int64(341),
+				"drops":     int64(352),
+			},
+			"syn_retransmits": int64(4123),
+			"timeouts":        int64(18723),
+			"aborts": common.MapStr{
+				"on_data":    int64(5812),
+				"on_close":   int64(1023),
+				"on_memory":  int64(0),
+				"on_timeout": int64(87),
+				"on_linger":  int64(0),
+				"failed":     int64(0),
+			},
+		},
+	}, event)
+}
+
+func TestFetchWithoutNetstat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netstat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(procRoot, "net", "snmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "net", "snmp"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := mbtest.NewEventFetcher(t, getConfig())
+	f.(*MetricSet).procRoot = dir
+
+	event, err := f.Fetch()
+	if assert.NoError(t, err) {
+		value, _ := event.GetValue("tcp.segments.retransmitted")
+		assert.Equal(t, int64(51234), value)
+		value, _ = event.GetValue("tcp.listen")
+		assert.Equal(t, common.MapStr{}, value)
+	}
+
+	// The snmp file is required.
+	os.Remove(filepath.Join(dir, "net", "snmp"))
+	_, err = f.Fetch()
+	assert.Error(t, err)
+}
+
+func TestReadCountersInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netstat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, content := range []string{
+		// Missing values.
+		"Tcp: ActiveOpens PassiveOpens\n",
+		// Values of another protocol.
+		"Tcp: ActiveOpens PassiveOpens\nUdp: 1 2\n",
+		// Missing value.
+		"Tcp: ActiveOpens PassiveOpens\nTcp: 1\n",
+	} {
+		path := filepath.Join(dir, "snmp")
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := readCounters(path, map[string]interface{}{})
+		assert.Error(t, err, content)
+	}
+}
+
+func TestRetransmits(t *testing.T) {
+	event := common.MapStr{
+		"tcp": common.MapStr{
+			"segments": common.MapStr{
+				"out_per_sec":           200.0,
+				"retransmitted_per_sec": 3.0,
+			},
+		},
+	}
+	retransmits(event)
+	value, _ := event.GetValue("tcp.retransmits.pct")
+	assert.Equal(t, 0.015, value)
+
+	// No segments sent.
+	event.Put("tcp.segments.out_per_sec", 0.0)
+	retransmits(event)
+	value, _ = event.GetValue("tcp.retransmits.pct")
+	assert.Equal(t, 0.0, value)
+}
+
+func getConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"module":     "system",
+		"metricsets": []string{"netstat"},
+	}
+}
@@ -81,6 +81,15 @@ func (m *MetricSet) Fetch() ([]common.MapStr, error) {
 	return events, nil
 }
 
+// Counters declares the counters of the interfaces for which the rates are
+// reported.
+func (m *MetricSet) Counters() mb.Counters {
+	return mb.Counters{
+		Fields: []string{"in.bytes", "in.packets", "out.bytes", "out.packets"},
+		Keys:   []string{"name"},
+	}
+}
+
 func ioCountersToMapStr(counters net.IOCountersStat) common.MapStr {
 	return common.MapStr{
 		"name": counters.Name,
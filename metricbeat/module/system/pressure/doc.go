@@ -0,0 +1,8 @@
+/*
+Package pressure collects the pressure stall information (PSI) of the CPU,
+memory and IO from /proc/pressure. It is implemented for linux.
+
+The format of the PSI files is described here:
+https://www.kernel.org/doc/html/latest/accounting/psi.html
+*/
+package pressure
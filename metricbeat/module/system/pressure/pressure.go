@@ -0,0 +1,137 @@
+// +build linux
+
+package pressure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/elastic/beats/metricbeat/mb/parse"
+	"github.com/elastic/beats/metricbeat/module/system"
+
+	"github.com/pkg/errors"
+)
+
+// resources are the resources for which the kernel reports the pressure.
+var resources = []string{"cpu", "memory", "io"}
+
+func init() {
+	if err := mb.Registry.AddMetricSet("system", "pressure", New, parse.EmptyHostParser); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet for fetching the pressure stall information.
+type MetricSet struct {
+	mb.BaseMetricSet
+	procRoot string
+}
+
+// New is a mb.MetricSetFactory that returns a new MetricSet.
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	logp.Beta("The system pressure metricset is beta")
+
+	systemModule, ok := base.Module().(*system.Module)
+	if !ok {
+		return nil, errors.New("unexpected module type")
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		procRoot:      filepath.Join(systemModule.HostFS, "/proc"),
+	}, nil
+}
+
+// Fetch fetches the pressure of the resources. The resources without PSI
+// file are not reported.
+func (m *MetricSet) Fetch() (common.MapStr, error) {
+	event := common.MapStr{}
+	for _, resource := range resources {
+		stats, err := readPressure(filepath.Join(m.procRoot, "pressure", resource))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "%s pressure", resource)
+		}
+		event[resource] = stats
+	}
+
+	if len(event) == 0 {
+		return nil, fmt.Errorf("no pressure stall information found in %s, "+
+			"it requires Linux 4.20 or newer built with CONFIG_PSI",
+			filepath.Join(m.procRoot, "pressure"))
+	}
+	return event, nil
+}
+
+// Counters declares the total stall times, for which the rates are reported.
+func (m *MetricSet) Counters() mb.Counters {
+	var fields []string
+	for _, resource := range resources {
+		for _, kind := range []string{"some", "full"} {
+			fields = append(fields, resource+"."+kind+".total.time.us")
+		}
+	}
+	return mb.Counters{Fields: fields}
+}
+
+// readPressure reads a PSI file, made of a line for the stalls of some tasks
+// and one for the stalls of all tasks:
+//
+//	some avg10=1.53 avg60=0.87 avg300=0.32 total=58761459
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//
+// The averages are percentages of time, and the totals are in microseconds.
+func readPressure(path string) (common.MapStr, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pressure := common.MapStr{}
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		kind := fields[0]
+		if kind != "some" && kind != "full" {
+			return nil, fmt.Errorf("unexpected line '%s'", line)
+		}
+
+		stats := common.MapStr{}
+		for _, field := range fields[1:] {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("unexpected field '%s'", field)
+			}
+
+			switch parts[0] {
+			case "avg10", "avg60", "avg300":
+				value, err := strconv.ParseFloat(parts[1], 64)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid %s", parts[0])
+				}
+				// The averages have two decimals.
+				stats[parts[0]] = common.MapStr{"pct": system.Round(value/100, .5, 4)}
+			case "total":
+				value, err := strconv.ParseInt(parts[1], 10, 64)
+				if err != nil {
+					return nil, errors.Wrap(err, "invalid total")
+				}
+				stats["total"] = common.MapStr{"time": common.MapStr{"us": value}}
+			}
+		}
+		pressure[kind] = stats
+	}
+	return pressure, nil
+}
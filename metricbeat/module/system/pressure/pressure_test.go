@@ -0,0 +1,124 @@
+// +build linux
+
+package pressure
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	mbtest "github.com/elastic/beats/metricbeat/mb/testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// procRoot is a fake /proc with the PSI files of the three resources.
+var procRoot = filepath.Join("..", "_meta", "testdata", "proc")
+
+func TestData(t *testing.T) {
+	f := mbtest.NewEventFetcher(t, getConfig())
+	f.(*MetricSet).procRoot = procRoot
+
+	err := mbtest.WriteEvent(f, t)
+	if err != nil {
+		t.Fatal("write", err)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	f := mbtest.NewEventFetcher(t, getConfig())
+	f.(*MetricSet).procRoot = procRoot
+
+	event, err := f.Fetch()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for field, expected := range map[string]interface{}{
+		"cpu.some.avg10.pct":        0.0153,
+		"cpu.some.total.time.us":    int64(58761459),
+		"cpu.full.avg300.pct":       0.0,
+		"memory.full.avg60.pct":     0.0002,
+		"memory.some.total.time.us": int64(1876543),
+		"io.some.avg300.pct":        0.0095,
+		"io.full.total.time.us":     int64(289345112),
+	} {
+		value, err := event.GetValue(field)
+		if assert.NoError(t, err, field) {
+			if f, ok := expected.(float64); ok {
+				assert.InDelta(t, f, value, 1e-9, field)
+			} else {
+				assert.Equal(t, expected, value, field)
+			}
+		}
+	}
+}
+
+func TestFetchMissingResource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pressure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := mbtest.NewEventFetcher(t, getConfig())
+	f.(*MetricSet).procRoot = dir
+
+	// Without PSI.
+	_, err = f.Fetch()
+	assert.Error(t, err)
+
+	// Older kernels don't report the full CPU stalls.
+	if err := os.Mkdir(filepath.Join(dir, "pressure"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("some avg10=0.50 avg60=0.25 avg300=0.10 total=1234\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "pressure", "cpu"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := f.Fetch()
+	if assert.NoError(t, err) {
+		assert.Equal(t, common.MapStr{
+			"cpu": common.MapStr{
+				"some": common.MapStr{
+					"avg10":  common.MapStr{"pct": 0.005},
+					"avg60":  common.MapStr{"pct": 0.0025},
+					"avg300": common.MapStr{"pct": 0.001},
+					"total":  common.MapStr{"time": common.MapStr{"us": int64(1234)}},
+				},
+			},
+		}, event)
+	}
+}
+
+func TestReadPressureInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pressure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, content := range []string{
+		"other avg10=0.00\n",
+		"some avg10\n",
+		"some avg10=abc total=0\n",
+		"some avg10=0.00 total=-\n",
+	} {
+		path := filepath.Join(dir, "cpu")
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := readPressure(path)
+		assert.Error(t, err, content)
+	}
+}
+
+func getConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"module":     "system",
+		"metricsets": []string{"pressure"},
+	}
+}
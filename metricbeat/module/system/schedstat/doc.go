@@ -0,0 +1,9 @@
+/*
+Package schedstat collects the scheduler statistics of each CPU from
+/proc/schedstat, like the time the tasks spent waiting on the run queue. It is
+implemented for linux.
+
+The format of /proc/schedstat is described here:
+https://www.kernel.org/doc/Documentation/scheduler/sched-stats.txt
+*/
+package schedstat
@@ -0,0 +1,178 @@
+// +build linux
+
+package schedstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/elastic/beats/metricbeat/mb/parse"
+	"github.com/elastic/beats/metricbeat/module/system"
+
+	"github.com/pkg/errors"
+)
+
+// minVersion is the oldest version of /proc/schedstat with the format of the
+// CPU lines parsed by the metricset.
+const minVersion = 15
+
+func init() {
+	if err := mb.Registry.AddMetricSet("system", "schedstat", New, parse.EmptyHostParser); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet for fetching the scheduler statistics of the CPUs.
+type MetricSet struct {
+	mb.BaseMetricSet
+	procRoot string
+}
+
+// New is a mb.MetricSetFactory that returns a new MetricSet.
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	logp.Beta("The system schedstat metricset is beta")
+
+	systemModule, ok := base.Module().(*system.Module)
+	if !ok {
+		return nil, errors.New("unexpected module type")
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		procRoot:      filepath.Join(systemModule.HostFS, "/proc"),
+	}, nil
+}
+
+// Fetch fetches the scheduler statistics, one event per CPU.
+func (m *MetricSet) Fetch() ([]common.MapStr, error) {
+	events, err := readSchedstat(filepath.Join(m.procRoot, "schedstat"))
+	if err != nil {
+		return nil, errors.Wrap(err, "schedstat")
+	}
+	return events, nil
+}
+
+// Counters declares the counters of the CPUs, for which the rates are
+// reported.
+func (m *MetricSet) Counters() mb.Counters {
+	return mb.Counters{
+		Fields: []string{"running.time.ns", "waiting.time.ns", "timeslices"},
+		Keys:   []string{"id"},
+		Derive: latency,
+	}
+}
+
+// latency adds the average time the tasks waited on the run queue before
+// running, and the average number of tasks waiting, computed from the rates
+// of the counters.
+func latency(event common.MapStr) {
+	rate := func(field string) (float64, bool) {
+		v, err := event.GetValue(field + mb.RateSuffix)
+		if err != nil {
+			return 0, false
+		}
+		f, ok := v.(float64)
+		return f, ok
+	}
+
+	waiting, ok1 := rate("waiting.time.ns")
+	timeslices, ok2 := rate("timeslices")
+	if !(ok1 && ok2) {
+		// First sample or reset counters.
+		return
+	}
+
+	var avg float64
+	if timeslices > 0 {
+		avg = waiting / timeslices
+	}
+	event.Put("latency.avg.ns", avg)
+	// Each waiting task adds a second of waiting time per second.
+	event.Put("queue.avg_size", waiting/1e9)
+}
+
+// readSchedstat reads the CPU lines of /proc/schedstat. After the name of the
+// CPU, the 7th, 8th and 9th fields are the time spent running, the time spent
+// waiting on the run queue, both in nanoseconds, and the number of timeslices
+// run:
+//
+//	cpu0 0 0 0 0 0 0 2483925011735 48271023471 31862951
+func readSchedstat(path string) ([]common.MapStr, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []common.MapStr
+	version := 0
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case fields[0] == "version" && len(fields) == 2:
+			version, err = strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid version")
+			}
+			if version < minVersion {
+				return nil, fmt.Errorf("unsupported version %d, version %d or newer is required", version, minVersion)
+			}
+		case strings.HasPrefix(fields[0], "cpu"):
+			if version == 0 {
+				return nil, errors.New("version not found")
+			}
+			event, err := parseCPU(fields)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid line '%s'", scanner.Text())
+			}
+			events = append(events, event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// parseCPU parses the fields of a CPU line.
+func parseCPU(fields []string) (common.MapStr, error) {
+	if len(fields) < 10 {
+		return nil, fmt.Errorf("expected at least 10 fields, found %d", len(fields))
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(fields[0], "cpu"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid CPU id")
+	}
+
+	var values [3]int64
+	for i := range values {
+		values[i], err = strconv.ParseInt(fields[7+i], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return common.MapStr{
+		"id": id,
+		"running": common.MapStr{
+			"time": common.MapStr{"ns": values[0]},
+		},
+		"waiting": common.MapStr{
+			"time": common.MapStr{"ns": values[1]},
+		},
+		"timeslices": values[2],
+	}, nil
+}
@@ -0,0 +1,104 @@
+// +build linux
+
+package schedstat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	mbtest "github.com/elastic/beats/metricbeat/mb/testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// procRoot is a fake /proc with the schedstat file of a host with 2 CPUs.
+var procRoot = filepath.Join("..", "_meta", "testdata", "proc")
+
+func TestData(t *testing.T) {
+	f := mbtest.NewEventsFetcher(t, getConfig())
+	f.(*MetricSet).procRoot = procRoot
+
+	err := mbtest.WriteEvents(f, t)
+	if err != nil {
+		t.Fatal("write", err)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	f := mbtest.NewEventsFetcher(t, getConfig())
+	f.(*MetricSet).procRoot = procRoot
+
+	events, err := f.Fetch()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []common.MapStr{
+		{
+			"id":         0,
+			"running":    common.MapStr{"time": common.MapStr{"ns": int64(2483925011735)}},
+			"waiting":    common.MapStr{"time": common.MapStr{"ns": int64(48271023471)}},
+			"timeslices": int64(31862951),
+		},
+		{
+			"id":         1,
+			"running":    common.MapStr{"time": common.MapStr{"ns": int64(2411983275312)}},
+			"waiting":    common.MapStr{"time": common.MapStr{"ns": int64(51029384712)}},
+			"timeslices": int64(30987211),
+		},
+	}, events)
+}
+
+func TestReadSchedstatInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schedstat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, content := range []string{
+		// Old format.
+		"version 14\ncpu0 0 0 0 0 0 0 1 2 3\n",
+		// Without version.
+		"cpu0 0 0 0 0 0 0 1 2 3 4 5 6\n",
+		// Missing fields.
+		"version 15\ncpu0 0 0 0 0 0 0 1 2\n",
+		"version 15\ncpu0 0 0 0 0 0 0 1 2 x\n",
+		"version 15\ncpux 0 0 0 0 0 0 1 2 3\n",
+	} {
+		path := filepath.Join(dir, "schedstat")
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := readSchedstat(path)
+		assert.Error(t, err, content)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	event := common.MapStr{
+		"waiting": common.MapStr{
+			"time": common.MapStr{"ns_per_sec": 5e8},
+		},
+		"timeslices_per_sec": 1000.0,
+	}
+	latency(event)
+
+	assert.Equal(t, common.MapStr{"avg": common.MapStr{"ns": 5e5}}, event["latency"])
+	assert.Equal(t, common.MapStr{"avg_size": 0.5}, event["queue"])
+
+	// No rates in the first event.
+	event = common.MapStr{"timeslices": int64(10)}
+	latency(event)
+	assert.Equal(t, common.MapStr{"timeslices": int64(10)}, event)
+}
+
+func getConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"module":     "system",
+		"metricsets": []string{"schedstat"},
+	}
+}
@@ -0,0 +1,43 @@
+package vmstat
+
+import (
+	s "github.com/elastic/beats/metricbeat/schema"
+	c "github.com/elastic/beats/metricbeat/schema/mapstrstr"
+)
+
+var (
+	// schema maps the counters of /proc/vmstat. The counters added by recent
+	// kernels are optional.
+	schema = s.Schema{
+		"fault": s.Object{
+			"total": c.Int("pgfault"),
+			"major": c.Int("pgmajfault"),
+		},
+		"swap": s.Object{
+			"in":  s.Object{"pages": c.Int("pswpin")},
+			"out": s.Object{"pages": c.Int("pswpout")},
+		},
+		"reclaim": s.Object{
+			"scan": s.Object{
+				"kswapd": c.Int("pgscan_kswapd", s.Optional),
+				"direct": c.Int("pgscan_direct", s.Optional),
+			},
+			"steal": s.Object{
+				"kswapd": c.Int("pgsteal_kswapd", s.Optional),
+				"direct": c.Int("pgsteal_direct", s.Optional),
+			},
+		},
+		"oom": s.Object{
+			"kills": c.Int("oom_kill", s.Optional),
+		},
+	}
+
+	// counters are the fields for which the rates are reported.
+	counters = []string{
+		"fault.total", "fault.major",
+		"swap.in.pages", "swap.out.pages",
+		"reclaim.scan.kswapd", "reclaim.scan.direct",
+		"reclaim.steal.kswapd", "reclaim.steal.direct",
+		"oom.kills",
+	}
+)
@@ -0,0 +1,5 @@
+/*
+Package vmstat collects the virtual memory statistics of /proc/vmstat, like
+the page faults, the swapping and the OOM kills. It is implemented for linux.
+*/
+package vmstat
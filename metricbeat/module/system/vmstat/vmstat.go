@@ -0,0 +1,89 @@
+// +build linux
+
+package vmstat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/metricbeat/mb"
+	"github.com/elastic/beats/metricbeat/mb/parse"
+	"github.com/elastic/beats/metricbeat/module/system"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	if err := mb.Registry.AddMetricSet("system", "vmstat", New, parse.EmptyHostParser); err != nil {
+		panic(err)
+	}
+}
+
+// MetricSet for fetching the virtual memory statistics.
+type MetricSet struct {
+	mb.BaseMetricSet
+	procRoot string
+}
+
+// New is a mb.MetricSetFactory that returns a new MetricSet.
+func New(base mb.BaseMetricSet) (mb.MetricSet, error) {
+	logp.Beta("The system vmstat metricset is beta")
+
+	systemModule, ok := base.Module().(*system.Module)
+	if !ok {
+		return nil, errors.New("unexpected module type")
+	}
+
+	return &MetricSet{
+		BaseMetricSet: base,
+		procRoot:      filepath.Join(systemModule.HostFS, "/proc"),
+	}, nil
+}
+
+// Fetch fetches the virtual memory statistics.
+func (m *MetricSet) Fetch() (common.MapStr, error) {
+	vmstat, err := readVMStat(filepath.Join(m.procRoot, "vmstat"))
+	if err != nil {
+		return nil, errors.Wrap(err, "vmstat")
+	}
+
+	event, errs := schema.Apply(vmstat)
+	if errs.HasRequiredErrors() {
+		return nil, errs
+	}
+	return event, nil
+}
+
+// Counters declares the counters of the virtual memory statistics, for which
+// the rates are reported.
+func (m *MetricSet) Counters() mb.Counters {
+	return mb.Counters{Fields: counters}
+}
+
+// readVMStat reads the "name value" lines of /proc/vmstat.
+func readVMStat(path string) (map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	vmstat := map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("unexpected line '%s'", scanner.Text())
+		}
+		vmstat[fields[0]] = fields[1]
+	}
+	return vmstat, scanner.Err()
+}
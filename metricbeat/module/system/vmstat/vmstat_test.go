@@ -0,0 +1,103 @@
+// +build linux
+
+package vmstat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	mbtest "github.com/elastic/beats/metricbeat/mb/testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// procRoot is a fake /proc with a vmstat file.
+var procRoot = filepath.Join("..", "_meta", "testdata", "proc")
+
+func TestData(t *testing.T) {
+	f := mbtest.NewEventFetcher(t, getConfig())
+	f.(*MetricSet).procRoot = procRoot
+
+	err := mbtest.WriteEvent(f, t)
+	if err != nil {
+		t.Fatal("write", err)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	f := mbtest.NewEventFetcher(t, getConfig())
+	f.(*MetricSet).procRoot = procRoot
+
+	event, err := f.Fetch()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, common.MapStr{
+		"fault": common.MapStr{
+			"total": int64(723984561),
+			"major": int64(41287),
+		},
+		"swap": common.MapStr{
+			"in":  common.MapStr{"pages": int64(1834)},
+			"out": common.MapStr{"pages": int64(4211)},
+		},
+		"reclaim": common.MapStr{
+			"scan": common.MapStr{
+				"kswapd": int64(1523876),
+				"direct": int64(10234),
+			},
+			"steal": common.MapStr{
+				"kswapd": int64(1298734),
+				"direct": int64(8712),
+			},
+		},
+		"oom": common.MapStr{
+			"kills": int64(3),
+		},
+	}, event)
+}
+
+func TestFetchOldKernel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vmstat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := mbtest.NewEventFetcher(t, getConfig())
+	f.(*MetricSet).procRoot = dir
+
+	// The OOM kills and the reclaim counters are optional.
+	content := []byte("pswpin 1\npswpout 2\npgfault 3\npgmajfault 4\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "vmstat"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	event, err := f.Fetch()
+	if assert.NoError(t, err) {
+		assert.Equal(t, common.MapStr{
+			"fault":   common.MapStr{"total": int64(3), "major": int64(4)},
+			"swap":    common.MapStr{"in": common.MapStr{"pages": int64(1)}, "out": common.MapStr{"pages": int64(2)}},
+			"reclaim": common.MapStr{"scan": common.MapStr{}, "steal": common.MapStr{}},
+			"oom":     common.MapStr{},
+		}, event)
+	}
+
+	// The page faults are required.
+	content = []byte("pswpin 1\npswpout 2\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "vmstat"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Fetch()
+	assert.Error(t, err)
+}
+
+func getConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"module":     "system",
+		"metricsets": []string{"vmstat"},
+	}
+}
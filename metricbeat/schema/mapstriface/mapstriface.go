@@ -194,6 +194,38 @@ func Int(key string, opts ...schema.SchemaOption) schema.Conv {
 	return schema.SetOptions(schema.Conv{Key: key, Func: toInteger}, opts)
 }
 
+func toFloat(key string, data map[string]interface{}) (interface{}, error) {
+	emptyIface, exists := data[key]
+	if !exists {
+		return 0.0, fmt.Errorf("Key %s not found", key)
+	}
+	switch emptyIface.(type) {
+	case float64:
+		return emptyIface.(float64), nil
+	case float32:
+		return float64(emptyIface.(float32)), nil
+	case int64:
+		return float64(emptyIface.(int64)), nil
+	case int:
+		return float64(emptyIface.(int)), nil
+	case json.Number:
+		num := emptyIface.(json.Number)
+		f64, err := num.Float64()
+		if err != nil {
+			return 0.0, fmt.Errorf("Expected float, found json.Number (%v) that cannot be converted", num)
+		}
+		return f64, nil
+	default:
+		return 0.0, fmt.Errorf("Expected float, found %T", emptyIface)
+	}
+}
+
+// Float creates a Conv object for converting floats. Acceptable input
+// types are float64, float32, int64, and int.
+func Float(key string, opts ...schema.SchemaOption) schema.Conv {
+	return schema.SetOptions(schema.Conv{Key: key, Func: toFloat}, opts)
+}
+
 func toTime(key string, data map[string]interface{}) (interface{}, error) {
 	emptyIface, exists := data[key]
 	if !exists {
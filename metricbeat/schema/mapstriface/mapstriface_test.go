@@ -22,6 +22,8 @@ func TestConversions(t *testing.T) {
 		"testIntFromInt32": int32(32),
 		"testIntFromInt64": int64(42),
 		"testJsonNumber":   json.Number("3910564293633576924"),
+		"testFloat":        42.1,
+		"testFloatFromInt": 42,
 		"testBool":         true,
 		"testObj": map[string]interface{}{
 			"testObjString": "hello, object",
@@ -34,6 +36,7 @@ func TestConversions(t *testing.T) {
 		"testErrorInt":    "42",
 		"testErrorTime":   12,
 		"testErrorBool":   "false",
+		"testErrorFloat":  "42.1",
 		"testErrorString": 32,
 	}
 
@@ -45,6 +48,9 @@ func TestConversions(t *testing.T) {
 		"test_int_from_json":        Int("testJsonNumber"),
 		"test_string_from_num":      StrFromNum("testIntFromInt32"),
 		"test_string_from_json_num": StrFromNum("testJsonNumber"),
+		"test_float":                Float("testFloat"),
+		"test_float_from_int":       Float("testFloatFromInt"),
+		"test_float_from_json":      Float("testJsonNumber"),
 		"test_bool":                 Bool("testBool"),
 		"test_time":                 Time("testTime"),
 		"common_time":               Time("commonTime"),
@@ -57,6 +63,7 @@ func TestConversions(t *testing.T) {
 		"test_error_int":    Int("testErrorInt", s.Optional),
 		"test_error_time":   Time("testErrorTime", s.Optional),
 		"test_error_bool":   Bool("testErrorBool", s.Optional),
+		"test_error_float":  Float("testErrorFloat", s.Optional),
 		"test_error_string": Str("testErrorString", s.Optional),
 	}
 
@@ -68,6 +75,9 @@ func TestConversions(t *testing.T) {
 		"test_int_from_json":        int64(3910564293633576924),
 		"test_string_from_num":      "32",
 		"test_string_from_json_num": "3910564293633576924",
+		"test_float":                42.1,
+		"test_float_from_int":       42.0,
+		"test_float_from_json":      3910564293633576924.0,
 		"test_bool":                 true,
 		"test_time":                 common.Time(ts),
 		"common_time":               cTs,
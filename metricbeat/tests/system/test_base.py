@@ -28,3 +28,34 @@ class Test(BaseTest):
         assert self.log_contains("Setup Beat: metricbeat")
         assert self.log_contains("metricbeat start running")
         assert self.log_contains("metricbeat stopped")
+
+    @unittest.skipUnless(re.match("(?i)win|linux|darwin|freebsd|openbsd", sys.platform), "os")
+    def test_test_module(self):
+        """
+        Metricbeat fetches a module once with -test-module and exits.
+        """
+        self.render_config_template(modules=[{
+            "name": "system",
+            "metricsets": ["cpu", "load"],
+            "period": "5s"
+        }])
+        self.run_beat(extra_args=["-test-module", "system/cpu"], exit_code=0)
+
+        assert self.log_contains('"cpu": {')
+        assert self.log_contains("system/cpu: 1 events, 0 errors")
+        assert not self.log_contains("system/load")
+        assert not self.log_contains("start running")
+
+    def test_test_module_error(self):
+        """
+        Metricbeat exits with an error when a fetch of -test-module fails.
+        """
+        self.render_config_template(modules=[{
+            "name": "redis",
+            "metricsets": ["info"],
+            "hosts": ["127.0.0.1:1"],
+        }])
+        self.run_beat(extra_args=["-test-module", "redis"], exit_code=1)
+
+        assert self.log_contains("redis/info for host 127.0.0.1:1: 1 events, 1 errors")
+        assert self.log_contains("errors fetching module redis")
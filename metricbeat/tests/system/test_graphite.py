@@ -0,0 +1,47 @@
+import socket
+import metricbeat
+
+GRAPHITE_FIELDS = metricbeat.COMMON_FIELDS + ["graphite"]
+
+GRAPHITE_PORT = 12003
+
+
+class Test(metricbeat.BaseTest):
+
+    def test_server(self):
+        """
+        graphite server metricset test
+        """
+        self.render_config_template(modules=[{
+            "name": "graphite",
+            "metricsets": ["server"],
+            "port": GRAPHITE_PORT,
+        }])
+        proc = self.start_beat()
+        self.wait_until(lambda: self.log_contains("Graphite server listening"))
+
+        sock = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+
+        def send():
+            sock.sendto(b"servers.web01.cpu.load 0.5 1500000000\n", ("127.0.0.1", GRAPHITE_PORT))
+            return self.output_lines() > 0
+
+        self.wait_until(send)
+        proc.check_kill_and_wait()
+        sock.close()
+
+        # Ensure no errors or warnings exist in the log.
+        log = self.get_log()
+        self.assertNotRegexpMatches(log.replace("WARN BETA", ""), "ERR|WARN")
+
+        output = self.read_output_json()
+        evt = output[0]
+
+        metric = evt["graphite"]["server"]
+        assert metric["namespace"] == "graphite"
+        assert metric["metric"] == "servers.web01.cpu.load"
+        assert metric["value"] == 0.5
+
+        self.assertItemsEqual(self.de_dot(GRAPHITE_FIELDS), evt.keys(), evt)
+
+        self.assert_fields_are_documented(evt)
@@ -0,0 +1,52 @@
+import socket
+import metricbeat
+
+STATSD_FIELDS = metricbeat.COMMON_FIELDS + ["statsd"]
+
+STATSD_PORT = 18125
+
+
+class Test(metricbeat.BaseTest):
+
+    def test_metrics(self):
+        """
+        statsd metrics metricset test
+        """
+        self.render_config_template(modules=[{
+            "name": "statsd",
+            "metricsets": ["metrics"],
+            "period": "1s",
+            "port": STATSD_PORT,
+        }])
+        proc = self.start_beat()
+        self.wait_until(lambda: self.log_contains("StatsD server listening"))
+
+        sock = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+
+        def send():
+            sock.sendto(b"test.latency:320|ms|#endpoint:/users\n", ("127.0.0.1", STATSD_PORT))
+            return self.output_lines() > 0
+
+        self.wait_until(send)
+        proc.check_kill_and_wait()
+        sock.close()
+
+        # Ensure no errors or warnings exist in the log.
+        log = self.get_log()
+        self.assertNotRegexpMatches(log.replace("WARN BETA", ""), "ERR|WARN")
+
+        output = self.read_output_json()
+        evt = output[0]
+
+        metric = evt["statsd"]["metrics"]
+        assert metric["name"] == "test.latency"
+        assert metric["metric_type"] == "timer"
+        assert metric["timer"]["max"] == 320
+
+        # Tags and percentiles are dynamic
+        del metric["tags"]
+        del metric["timer"]["percentiles"]
+
+        self.assertItemsEqual(self.de_dot(STATSD_FIELDS), evt.keys(), evt)
+
+        self.assert_fields_are_documented(evt)
@@ -204,8 +204,38 @@ class Test(metricbeat.BaseTest):
         for evt in output:
             self.assert_fields_are_documented(evt)
             diskio = evt["system"]["diskio"]
+            # iostat is only reported from the second event of a disk.
+            diskio.pop("iostat", None)
             self.assertItemsEqual(self.de_dot(SYSTEM_DISKIO_FIELDS), diskio.keys())
 
+    @unittest.skipUnless(re.match("(?i)linux", sys.platform), "os")
+    def test_diskio_rates(self):
+        """
+        Test system/diskio rates and iostat output.
+        """
+        self.render_config_template(modules=[{
+            "name": "system",
+            "metricsets": ["diskio"],
+            "period": "1s"
+        }])
+        proc = self.start_beat()
+        self.wait_until(lambda: self.output_lines() > 0 and
+                        any("iostat" in evt["system"]["diskio"]
+                            for evt in self.read_output_json()),
+                        max_timeout=15)
+        proc.check_kill_and_wait()
+
+        output = self.read_output_json()
+        events = [evt for evt in output if "iostat" in evt["system"]["diskio"]]
+        self.assertGreater(len(events), 0)
+
+        for evt in events:
+            self.assert_fields_are_documented(evt)
+            diskio = evt["system"]["diskio"]
+            self.assertIn("bytes_per_sec", diskio["read"])
+            self.assertItemsEqual(["await", "queue", "read", "utilization", "write"],
+                                  diskio["iostat"].keys())
+
     @unittest.skipUnless(re.match("(?i)win|linux|darwin|freebsd|openbsd", sys.platform), "os")
     def test_filesystem(self):
         """
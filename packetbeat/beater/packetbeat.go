@@ -17,12 +17,14 @@ import (
 	"github.com/elastic/beats/packetbeat/config"
 	"github.com/elastic/beats/packetbeat/decoder"
 	"github.com/elastic/beats/packetbeat/flows"
+	"github.com/elastic/beats/packetbeat/netflow"
 	"github.com/elastic/beats/packetbeat/procs"
 	"github.com/elastic/beats/packetbeat/protos"
 	"github.com/elastic/beats/packetbeat/protos/icmp"
 	"github.com/elastic/beats/packetbeat/protos/tcp"
 	"github.com/elastic/beats/packetbeat/protos/udp"
 	"github.com/elastic/beats/packetbeat/publish"
+	"github.com/elastic/beats/packetbeat/snapshot"
 	"github.com/elastic/beats/packetbeat/sniffer"
 )
 
@@ -32,6 +34,7 @@ type packetbeat struct {
 	cmdLineArgs flags
 	pub         *publish.PacketbeatPublisher
 	sniff       *sniffer.SnifferSetup
+	snapshots   *snapshot.Recorder
 
 	services []interface {
 		Start()
@@ -107,12 +110,31 @@ func (pb *packetbeat) init(b *beat.Beat) error {
 		return fmt.Errorf("Initializing publisher failed: %v", err)
 	}
 
+	if cfg.Snapshots.Enabled() {
+		logp.Debug("main", "Initializing packet snapshots")
+		pb.snapshots, err = snapshot.New(cfg.Snapshots)
+		if err != nil {
+			return fmt.Errorf("Initializing packet snapshots failed: %v", err)
+		}
+		pb.pub.SetSnapshotter(pb.snapshots)
+		pb.services = append(pb.services, pb.snapshots)
+	}
+
 	logp.Debug("main", "Initializing protocol plugins")
 	err = protos.Protos.Init(false, pb.pub, cfg.Protocols, cfg.ProtocolsList)
 	if err != nil {
 		return fmt.Errorf("Initializing protocol analyzers failed: %v", err)
 	}
 
+	if cfg.NetFlow.Enabled() {
+		logp.Debug("main", "Initializing NetFlow collector")
+		collector, err := netflow.New(cfg.NetFlow, pb.pub)
+		if err != nil {
+			return fmt.Errorf("Initializing NetFlow collector failed: %v", err)
+		}
+		pb.services = append(pb.services, collector)
+	}
+
 	logp.Debug("main", "Initializing sniffer")
 	err = pb.setupSniffer()
 	if err != nil {
@@ -171,6 +193,7 @@ func (pb *packetbeat) Run(b *beat.Beat) error {
 	for _, service := range pb.services {
 		service.Stop()
 	}
+	protos.Protos.Stop()
 
 	waitShutdown := pb.cmdLineArgs.waitShutdown
 	if waitShutdown != nil && *waitShutdown > 0 {
@@ -199,7 +222,9 @@ func (pb *packetbeat) setupSniffer() error {
 	withICMP := icmp.Enabled()
 
 	filter := config.Interfaces.BpfFilter
-	if filter == "" && !config.Flows.IsEnabled() {
+	// flows and tunnels need all the packets, not only those to the ports
+	// of the protocols
+	if filter == "" && !config.Flows.IsEnabled() && !config.Tunnels.Enabled() {
 		filter = protos.Protos.BpfFilter(withVlans, withICMP)
 	}
 
@@ -250,9 +275,18 @@ func (pb *packetbeat) createWorker(dl layers.LinkType) (sniffer.Worker, error) {
 		return nil, err
 	}
 
+	if config.Tunnels.Enabled() {
+		if err := worker.EnableTunnels(config.Tunnels); err != nil {
+			return nil, err
+		}
+	}
+
 	if f != nil {
 		pb.services = append(pb.services, f)
 	}
+	if pb.snapshots != nil {
+		return pb.snapshots.Worker(dl, worker), nil
+	}
 	return worker, nil
 }
 
@@ -5,12 +5,16 @@ import (
 
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/common/droppriv"
+	"github.com/elastic/beats/packetbeat/geoip"
 	"github.com/elastic/beats/packetbeat/procs"
 )
 
 type Config struct {
 	Interfaces     InterfacesConfig          `config:"interfaces"`
 	Flows          *Flows                    `config:"flows"`
+	NetFlow        *common.Config            `config:"netflow"`
+	Tunnels        *common.Config            `config:"tunnels"`
+	Snapshots      *common.Config            `config:"snapshots"`
 	Protocols      map[string]*common.Config `config:"protocols"`
 	ProtocolsList  []*common.Config          `config:"protocols"`
 	Procs          procs.ProcsConfig         `config:"procs"`
@@ -33,9 +37,10 @@ type InterfacesConfig struct {
 }
 
 type Flows struct {
-	Enabled *bool  `config:"enabled"`
-	Timeout string `config:"timeout"`
-	Period  string `config:"period"`
+	Enabled *bool        `config:"enabled"`
+	Timeout string       `config:"timeout"`
+	Period  string       `config:"period"`
+	GeoIP   geoip.Config `config:"geoip"`
 }
 
 type ProtocolCommon struct {
@@ -3,6 +3,7 @@ package decoder
 import (
 	"fmt"
 
+	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
 	"github.com/elastic/beats/packetbeat/flows"
 	"github.com/elastic/beats/packetbeat/protos"
@@ -30,9 +31,15 @@ type Decoder struct {
 	icmp4     layers.ICMPv4
 	icmp6     layers.ICMPv6
 	tcp       layers.TCP
-	udp       layers.UDP
+	udp       udpLayer
 	truncated bool
 
+	// tunnel decapsulation, only registered if enabled
+	vxlan  vxlanLayer
+	geneve geneveLayer
+	gre    greLayer
+	mpls   mplsLayer
+
 	stD1Q, stIP4, stIP6 multiLayer
 
 	icmp4Proc icmp.ICMPv4Processor
@@ -249,10 +256,27 @@ func (d *Decoder) process(
 		return true, nil
 
 	case layers.LayerTypeUDP:
+		if d.udp.isTunnel() {
+			debugf("UDP tunnel packet")
+			return false, nil
+		}
+
 		debugf("UDP packet")
 		d.onUDP(packet)
 		return true, nil
 
+	case LayerTypeVXLAN:
+		debugf("VXLAN packet")
+		d.onTunnel(packet, &d.vxlan.tunnelLayer)
+
+	case LayerTypeGENEVE:
+		debugf("GENEVE packet")
+		d.onTunnel(packet, &d.geneve.tunnelLayer)
+
+	case layers.LayerTypeGRE:
+		debugf("GRE packet")
+		d.onTunnel(packet, &d.gre.tunnelLayer)
+
 	case layers.LayerTypeTCP:
 		debugf("TCP packet")
 		d.onTCP(packet)
@@ -262,6 +286,16 @@ func (d *Decoder) process(
 	return false, nil
 }
 
+// onTunnel records the tunnel the following layers are encapsulated in. The
+// tunnel is part of the tuple of the packet, such that the streams and
+// transactions of different tunnels are kept apart.
+func (d *Decoder) onTunnel(packet *protos.Packet, t *tunnelLayer) {
+	if d.flowID != nil {
+		d.flowID.AddTunnel(t.tunnel, t.id)
+	}
+	packet.Tuple.Tunnel = common.TunnelID{Type: uint8(t.tunnel), ID: t.id}
+}
+
 func (d *Decoder) onICMPv4(packet *protos.Packet) {
 	if d.icmp4Proc != nil {
 		packet.Payload = d.icmp4.Payload
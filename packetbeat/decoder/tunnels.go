@@ -0,0 +1,302 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/packetbeat/flows"
+
+	"github.com/tsg/gopacket"
+	"github.com/tsg/gopacket/layers"
+)
+
+// Layer types of the UDP encapsulations not supported by gopacket.
+var (
+	LayerTypeVXLAN = gopacket.RegisterLayerType(1000,
+		gopacket.LayerTypeMetadata{Name: "VXLAN", Decoder: gopacket.DecodeUnknown})
+	LayerTypeGENEVE = gopacket.RegisterLayerType(1001,
+		gopacket.LayerTypeMetadata{Name: "GENEVE", Decoder: gopacket.DecodeUnknown})
+)
+
+const (
+	etherTypeTransparentBridging layers.EthernetType = 0x6558
+	etherTypeERSPAN2             layers.EthernetType = 0x88be
+	etherTypeERSPAN3             layers.EthernetType = 0x22eb
+)
+
+var (
+	errTunnelTruncated = errors.New("tunnel header truncated")
+	errGRERouting      = errors.New("GRE source routing not supported")
+)
+
+// TunnelConfig selects the encapsulations decoded by the decoder.
+type TunnelConfig struct {
+	VXLANPorts  []int `config:"vxlan_ports"`
+	GENEVEPorts []int `config:"geneve_ports"`
+	GRE         bool  `config:"gre"`
+	MPLS        bool  `config:"mpls"`
+}
+
+var defaultTunnelConfig = TunnelConfig{
+	VXLANPorts:  []int{4789},
+	GENEVEPorts: []int{6081},
+	GRE:         true,
+	MPLS:        true,
+}
+
+func (c *TunnelConfig) Validate() error {
+	for _, ports := range [][]int{c.VXLANPorts, c.GENEVEPorts} {
+		for _, port := range ports {
+			if port <= 0 || port > 65535 {
+				return fmt.Errorf("invalid tunnel port %d", port)
+			}
+		}
+	}
+	return nil
+}
+
+// EnableTunnels configures the decoder to decapsulate the packets tunneled
+// in VXLAN, GENEVE, GRE (including ERSPAN) and MPLS. The inner packets are
+// processed as if they were captured directly, with the tunnel identifier
+// added to the flow ID.
+func (d *Decoder) EnableTunnels(cfg *common.Config) error {
+	config := defaultTunnelConfig
+	if err := cfg.Unpack(&config); err != nil {
+		return err
+	}
+
+	d.udp.tunnels = make(map[layers.UDPPort]gopacket.LayerType)
+	for _, port := range config.VXLANPorts {
+		d.udp.tunnels[layers.UDPPort(port)] = LayerTypeVXLAN
+	}
+	for _, port := range config.GENEVEPorts {
+		d.udp.tunnels[layers.UDPPort(port)] = LayerTypeGENEVE
+	}
+	d.AddLayers([]gopacket.DecodingLayer{&d.vxlan, &d.geneve})
+
+	if config.GRE {
+		d.AddLayer(&d.gre)
+	}
+	if config.MPLS {
+		d.AddLayer(&d.mpls)
+	}
+	return nil
+}
+
+// udpLayer decodes UDP, passing the payload sent to the configured tunnel
+// ports to the tunnel decoders.
+type udpLayer struct {
+	layers.UDP
+	tunnels map[layers.UDPPort]gopacket.LayerType
+}
+
+func (u *udpLayer) isTunnel() bool {
+	_, ok := u.tunnels[u.DstPort]
+	return ok
+}
+
+func (u *udpLayer) NextLayerType() gopacket.LayerType {
+	if typ, ok := u.tunnels[u.DstPort]; ok {
+		return typ
+	}
+	return u.UDP.NextLayerType()
+}
+
+// tunnelLayer holds the common state of the tunnel decoding layers.
+type tunnelLayer struct {
+	payload []byte
+	next    gopacket.LayerType
+
+	tunnel flows.TunnelType
+	id     uint32
+}
+
+func (t *tunnelLayer) NextLayerType() gopacket.LayerType { return t.next }
+func (t *tunnelLayer) LayerPayload() []byte              { return t.payload }
+
+// vxlanLayer decodes the VXLAN header (RFC 7348).
+type vxlanLayer struct {
+	tunnelLayer
+}
+
+func (v *vxlanLayer) CanDecode() gopacket.LayerClass { return LayerTypeVXLAN }
+
+func (v *vxlanLayer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 8 {
+		df.SetTruncated()
+		return errTunnelTruncated
+	}
+
+	v.tunnel = flows.TunnelVXLAN
+	v.id = binary.BigEndian.Uint32(data[4:8]) >> 8
+	v.next = layers.LayerTypeEthernet
+	v.payload = data[8:]
+	return nil
+}
+
+// geneveLayer decodes the GENEVE header (RFC 8926), skipping the options.
+type geneveLayer struct {
+	tunnelLayer
+}
+
+func (g *geneveLayer) CanDecode() gopacket.LayerClass { return LayerTypeGENEVE }
+
+func (g *geneveLayer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 8 {
+		df.SetTruncated()
+		return errTunnelTruncated
+	}
+	length := 8 + int(data[0]&0x3f)*4
+	if len(data) < length {
+		df.SetTruncated()
+		return errTunnelTruncated
+	}
+
+	g.tunnel = flows.TunnelGENEVE
+	g.id = binary.BigEndian.Uint32(data[4:8]) >> 8
+	g.next = payloadType(layers.EthernetType(binary.BigEndian.Uint16(data[2:4])))
+	g.payload = data[length:]
+	return nil
+}
+
+// greLayer decodes the GRE header (RFC 2784, RFC 2890) and the ERSPAN
+// headers of the mirrored traffic. The identifier of the tunnel is the GRE
+// key, or the ERSPAN session ID.
+type greLayer struct {
+	tunnelLayer
+}
+
+func (g *greLayer) CanDecode() gopacket.LayerClass { return layers.LayerTypeGRE }
+
+func (g *greLayer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 4 {
+		df.SetTruncated()
+		return errTunnelTruncated
+	}
+
+	flags := data[0]
+	checksum := flags&0x80 != 0
+	routing := flags&0x40 != 0
+	key := flags&0x20 != 0
+	seq := flags&0x10 != 0
+	protocol := layers.EthernetType(binary.BigEndian.Uint16(data[2:4]))
+	if routing {
+		return errGRERouting
+	}
+
+	length := 4
+	if checksum {
+		length += 4
+	}
+	g.tunnel = flows.TunnelGRE
+	g.id = 0
+	if key {
+		if len(data) < length+4 {
+			df.SetTruncated()
+			return errTunnelTruncated
+		}
+		g.id = binary.BigEndian.Uint32(data[length:])
+		length += 4
+	}
+	if seq {
+		length += 4
+	}
+
+	switch protocol {
+	case etherTypeERSPAN2:
+		g.tunnel = flows.TunnelERSPAN
+		// ERSPAN type I has no header and no sequence number
+		if seq {
+			if len(data) < length+8 {
+				df.SetTruncated()
+				return errTunnelTruncated
+			}
+			g.id = uint32(binary.BigEndian.Uint16(data[length+2:]) & 0x3ff)
+			length += 8
+		}
+		g.next = layers.LayerTypeEthernet
+
+	case etherTypeERSPAN3:
+		g.tunnel = flows.TunnelERSPAN
+		if len(data) < length+12 {
+			df.SetTruncated()
+			return errTunnelTruncated
+		}
+		g.id = uint32(binary.BigEndian.Uint16(data[length+2:]) & 0x3ff)
+		// optional platform specific subheader
+		if data[length+11]&0x01 != 0 {
+			length += 8
+		}
+		length += 12
+		g.next = layers.LayerTypeEthernet
+
+	default:
+		g.next = payloadType(protocol)
+	}
+
+	if len(data) < length {
+		df.SetTruncated()
+		return errTunnelTruncated
+	}
+	g.payload = data[length:]
+	return nil
+}
+
+// mplsLayer skips the MPLS label stack. The labels are not added to the flow
+// ID, as they are usually different in both directions of a flow.
+type mplsLayer struct {
+	payload []byte
+	next    gopacket.LayerType
+}
+
+func (m *mplsLayer) CanDecode() gopacket.LayerClass    { return layers.LayerTypeMPLS }
+func (m *mplsLayer) NextLayerType() gopacket.LayerType { return m.next }
+func (m *mplsLayer) LayerPayload() []byte              { return m.payload }
+
+func (m *mplsLayer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	for {
+		if len(data) < 4 {
+			df.SetTruncated()
+			return errTunnelTruncated
+		}
+		bottom := data[2]&0x01 != 0
+		data = data[4:]
+		if bottom {
+			break
+		}
+	}
+	if len(data) == 0 {
+		df.SetTruncated()
+		return errTunnelTruncated
+	}
+
+	// The payload type is not part of the label stack, guess it from the
+	// first nibble like most routers do.
+	switch data[0] >> 4 {
+	case 4:
+		m.next = layers.LayerTypeIPv4
+	case 6:
+		m.next = layers.LayerTypeIPv6
+	case 0:
+		// Ethernet pseudowire with control word
+		if len(data) < 4 {
+			df.SetTruncated()
+			return errTunnelTruncated
+		}
+		data = data[4:]
+		m.next = layers.LayerTypeEthernet
+	default:
+		m.next = layers.LayerTypeEthernet
+	}
+	m.payload = data
+	return nil
+}
+
+func payloadType(protocol layers.EthernetType) gopacket.LayerType {
+	if protocol == etherTypeTransparentBridging {
+		return layers.LayerTypeEthernet
+	}
+	return protocol.LayerType()
+}
@@ -0,0 +1,223 @@
+// +build !integration
+
+package decoder
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/packetbeat/config"
+	"github.com/elastic/beats/packetbeat/flows"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tsg/gopacket"
+	"github.com/tsg/gopacket/layers"
+)
+
+func ethFrame(etherType uint16, payload []byte) []byte {
+	frame := []byte{
+		0x00, 0x00, 0x5e, 0x00, 0x53, 0x01, 0x00, 0x00, 0x5e, 0x00, 0x53, 0x02,
+		byte(etherType >> 8), byte(etherType),
+	}
+	return append(frame, payload...)
+}
+
+// outer IPv4 header from 10.0.0.1 to 10.0.0.2
+func ipv4Packet(protocol byte, payload []byte) []byte {
+	packet := []byte{
+		0x45, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0x00, 0x40, protocol, 0x00, 0x00,
+		10, 0, 0, 1, 10, 0, 0, 2,
+	}
+	binary.BigEndian.PutUint16(packet[2:], uint16(len(packet)+len(payload)))
+	return append(packet, payload...)
+}
+
+func udpDatagram(src, dst uint16, payload []byte) []byte {
+	datagram := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(datagram[0:], src)
+	binary.BigEndian.PutUint16(datagram[2:], dst)
+	binary.BigEndian.PutUint16(datagram[4:], uint16(8+len(payload)))
+	return append(datagram, payload...)
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// VXLAN with VNI 42 to the default port
+var vxlanDNS = ethFrame(0x0800, ipv4Packet(17, udpDatagram(51234, 4789, concatBytes(
+	[]byte{0x08, 0, 0, 0, 0, 0, 42, 0},
+	ipv4UdpDNS,
+))))
+
+// GENEVE with VNI 7 and 8 bytes of options
+var geneveDNS = ethFrame(0x0800, ipv4Packet(17, udpDatagram(51234, 6081, concatBytes(
+	[]byte{0x02, 0x00, 0x65, 0x58, 0, 0, 7, 0},
+	[]byte{0x01, 0x02, 0x03, 0x01, 0xde, 0xad, 0xbe, 0xef},
+	ipv4UdpDNS,
+))))
+
+// GRE with checksum and key 0x01020304, transparent Ethernet bridging
+var greDNS = ethFrame(0x0800, ipv4Packet(47, concatBytes(
+	[]byte{0xa0, 0x00, 0x65, 0x58, 0, 0, 0, 0, 0x01, 0x02, 0x03, 0x04},
+	ipv4UdpDNS,
+)))
+
+// ERSPAN type II with session ID 100
+var erspanDNS = ethFrame(0x0800, ipv4Packet(47, concatBytes(
+	[]byte{0x10, 0x00, 0x88, 0xbe, 0, 0, 0, 1},
+	[]byte{0x10, 0x00, 0x00, 100, 0, 0, 0, 0},
+	ipv4UdpDNS,
+)))
+
+// ERSPAN type III with session ID 5 and the platform specific subheader
+var erspan3DNS = ethFrame(0x0800, ipv4Packet(47, concatBytes(
+	[]byte{0x10, 0x00, 0x22, 0xeb, 0, 0, 0, 1},
+	[]byte{0x20, 0x00, 0x00, 5, 0, 0, 0, 0, 0, 0, 0, 0x01},
+	[]byte{0, 0, 0, 0, 0, 0, 0, 0},
+	ipv4UdpDNS,
+)))
+
+// two MPLS labels followed by an IPv4 packet
+var mplsDNS = ethFrame(0x8847, concatBytes(
+	[]byte{0x00, 0x01, 0x00, 0x40, 0x00, 0x02, 0x01, 0x40},
+	ipv4UdpDNS[14:],
+))
+
+func newTunnelTestDecoder(t *testing.T, settings map[string]interface{}) (*Decoder, *TestUDPProcessor) {
+	f, err := flows.NewFlows(nil, &config.Flows{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	udp := &TestUDPProcessor{}
+	d, err := New(f, layers.LinkTypeEthernet, nil, nil, &TestTCPProcessor{}, udp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if settings != nil {
+		cfg, err := common.NewConfigFrom(settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.EnableTunnels(cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d, udp
+}
+
+func onPacket(d *Decoder, data []byte) {
+	d.OnPacket(data, &gopacket.CaptureInfo{Length: len(data), CaptureLength: len(data)})
+}
+
+func TestDecodeTunnels(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet []byte
+		tunnel flows.TunnelType
+		id     uint32
+		outer  bool
+	}{
+		{"vxlan", vxlanDNS, flows.TunnelVXLAN, 42, true},
+		{"geneve", geneveDNS, flows.TunnelGENEVE, 7, true},
+		{"gre", greDNS, flows.TunnelGRE, 0x01020304, true},
+		{"erspan", erspanDNS, flows.TunnelERSPAN, 100, true},
+		{"erspan3", erspan3DNS, flows.TunnelERSPAN, 5, true},
+		{"mpls", mplsDNS, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		d, udp := newTunnelTestDecoder(t, map[string]interface{}{})
+		onPacket(d, test.packet)
+
+		if !assert.NotNil(t, udp.pkt, test.name) {
+			continue
+		}
+		assert.Equal(t, "192.168.170.8", udp.pkt.Tuple.SrcIP.String(), test.name)
+		assert.Equal(t, "192.168.170.20", udp.pkt.Tuple.DstIP.String(), test.name)
+		assert.Equal(t, uint16(53), udp.pkt.Tuple.DstPort, test.name)
+		assert.Equal(t, ipv4UdpDNS[42:], udp.pkt.Payload, test.name)
+		assert.Equal(t, common.TunnelID{Type: uint8(test.tunnel), ID: test.id},
+			udp.pkt.Tuple.Tunnel, test.name)
+
+		typ, id, ok := d.flowID.TunnelInfo()
+		assert.Equal(t, test.tunnel != 0, ok, test.name)
+		assert.Equal(t, test.tunnel, typ, test.name)
+		assert.Equal(t, test.id, id, test.name)
+
+		src, dst, ok := d.flowID.IPv4Addr()
+		assert.True(t, ok, test.name)
+		assert.Equal(t, "192.168.170.8", net.IP(src).String(), test.name)
+		assert.Equal(t, "192.168.170.20", net.IP(dst).String(), test.name)
+
+		_, _, ok = d.flowID.OutterIPv4Addr()
+		assert.Equal(t, test.outer, ok, test.name)
+
+		// the ports of the outer UDP header are not part of the flow ID
+		src, dst, _ = d.flowID.UDPAddr()
+		assert.Equal(t, uint16(32795), binary.LittleEndian.Uint16(src), test.name)
+		assert.Equal(t, uint16(53), binary.LittleEndian.Uint16(dst), test.name)
+	}
+}
+
+func TestDecodeTunnelsDisabled(t *testing.T) {
+	d, udp := newTunnelTestDecoder(t, nil)
+	onPacket(d, vxlanDNS)
+
+	assert.NotNil(t, udp.pkt)
+	assert.Equal(t, "10.0.0.1", udp.pkt.Tuple.SrcIP.String())
+	assert.Equal(t, uint16(4789), udp.pkt.Tuple.DstPort)
+
+	_, _, ok := d.flowID.TunnelInfo()
+	assert.False(t, ok)
+}
+
+func TestDecodeTunnelsConfig(t *testing.T) {
+	d, udp := newTunnelTestDecoder(t, map[string]interface{}{
+		"vxlan_ports": []int{8472},
+		"gre":         false,
+	})
+
+	// not a VXLAN port anymore
+	onPacket(d, vxlanDNS)
+	assert.Equal(t, uint16(4789), udp.pkt.Tuple.DstPort)
+
+	udp.pkt = nil
+	onPacket(d, greDNS)
+	assert.Nil(t, udp.pkt)
+
+	vxlan := make([]byte, len(vxlanDNS))
+	copy(vxlan, vxlanDNS)
+	binary.BigEndian.PutUint16(vxlan[14+20+2:], 8472)
+	onPacket(d, vxlan)
+	assert.Equal(t, "192.168.170.8", udp.pkt.Tuple.SrcIP.String())
+
+	cfg, _ := common.NewConfigFrom(map[string]interface{}{"geneve_ports": []int{70000}})
+	assert.Error(t, d.EnableTunnels(cfg))
+}
+
+func TestDecodeTunnelsTruncated(t *testing.T) {
+	for _, packet := range [][]byte{vxlanDNS, geneveDNS, greDNS, erspanDNS, mplsDNS} {
+		d, udp := newTunnelTestDecoder(t, map[string]interface{}{})
+
+		// cut in the tunnel header
+		var truncated []byte
+		if packet[12] == 0x88 {
+			truncated = packet[:14+2]
+		} else if packet[14+9] == 17 {
+			truncated = packet[:14+20+8+4]
+		} else {
+			truncated = packet[:14+20+6]
+		}
+		onPacket(d, truncated)
+		assert.Nil(t, udp.pkt)
+	}
+}
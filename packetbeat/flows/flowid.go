@@ -34,6 +34,7 @@ type flowIDMeta struct {
 	offUDP        uint8
 	offTCP        uint8
 	offID         uint8
+	offTunnel     uint8
 
 	cntEth  uint8
 	cntVlan uint8
@@ -55,8 +56,33 @@ const (
 	UDPFlow
 	TCPFlow
 	ConnectionID
+	TunnelFlow
 )
 
+// TunnelType identifies the encapsulation of the packets of a flow.
+type TunnelType uint8
+
+const (
+	TunnelVXLAN TunnelType = iota + 1
+	TunnelGENEVE
+	TunnelGRE
+	TunnelERSPAN
+)
+
+var tunnelTypeNames = map[TunnelType]string{
+	TunnelVXLAN:  "vxlan",
+	TunnelGENEVE: "geneve",
+	TunnelGRE:    "gre",
+	TunnelERSPAN: "erspan",
+}
+
+func (t TunnelType) String() string {
+	if name, ok := tunnelTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
 const (
 	SizeEthAddr    = 6
 	SizeVlan       = 2
@@ -73,13 +99,15 @@ const (
 	SizeTCPFlowID    = 2 * SizePortNumber // source + dest port
 	SizeUDPFlowID    = 2 * SizePortNumber // source + dest port
 	SizeConnectionID = 8                  // 64bit internal connection id
+	SizeTunnelFlowID = 1 + 4              // tunnel type + identifier (VNI, key)
 
 	SizeFlowIDMax int = SizeEthFlowID +
 		2*(SizeVlanFlowID+SizeIPv4FlowID+SizeIPv6FlowID) +
 		SizeICMPFlowID +
 		SizeTCPFlowID +
 		SizeUDPFlowID +
-		SizeConnectionID
+		SizeConnectionID +
+		SizeTunnelFlowID
 )
 
 const offUnset uint8 = 0xff
@@ -99,6 +127,7 @@ var flowIDEmptyMeta = flowIDMeta{
 	offUDP:        offUnset,
 	offTCP:        offUnset,
 	offID:         offUnset,
+	offTunnel:     offUnset,
 
 	cntEth:  0,
 	cntVlan: 0,
@@ -223,6 +252,20 @@ func (f *FlowID) AddConnectionID(id uint64) {
 	f.addID(&f.offID, ConnectionID, tmp[:], nil, flowDirUnset)
 }
 
+// AddTunnel adds the identifier of the tunnel the following layers are
+// encapsulated in. The direction of the flow is reset, so that the packets of
+// both directions of the inner flow are accounted to the same flow, even if
+// the outer addresses don't change (e.g. mirrored traffic).
+func (f *FlowID) AddTunnel(typ TunnelType, id uint32) {
+	debugf("flowid: add tunnel")
+
+	var tmp [SizeTunnelFlowID]byte
+	tmp[0] = byte(typ)
+	binary.LittleEndian.PutUint32(tmp[1:], id)
+	f.dir = flowDirUnset
+	f.addID(&f.offTunnel, TunnelFlow, tmp[:], nil, flowDirUnset)
+}
+
 func (f *FlowID) addMultLayerID(
 	off, outerOff *uint8,
 	flag, outerFlag FlowIDFlag,
@@ -347,6 +390,8 @@ func (f *rawFlowID) Get(i FlowIDFlag) []byte {
 		return f.UDP()
 	case TCPFlow:
 		return f.TCP()
+	case TunnelFlow:
+		return f.Tunnel()
 	default:
 		return nil
 	}
@@ -371,6 +416,7 @@ func (f *rawFlowID) Serialize() []byte {
 		f.offUDP,
 		f.offTCP,
 		f.offID,
+		f.offTunnel,
 		f.cntEth,
 		f.cntVlan,
 		f.cntIP,
@@ -457,6 +503,19 @@ func (f *rawFlowID) ConnectionID() []byte {
 	return f.extractID(f.offID, SizeConnectionID)
 }
 
+func (f *rawFlowID) Tunnel() []byte {
+	return f.extractID(f.offTunnel, SizeTunnelFlowID)
+}
+
+// TunnelInfo returns the type and identifier of the innermost tunnel.
+func (f *rawFlowID) TunnelInfo() (TunnelType, uint32, bool) {
+	t := f.Tunnel()
+	if t == nil {
+		return 0, 0, false
+	}
+	return TunnelType(t[0]), binary.LittleEndian.Uint32(t[1:]), true
+}
+
 func (f *rawFlowID) extractID(off, sz uint8) []byte {
 	if off == offUnset {
 		return nil
@@ -280,3 +280,46 @@ func TestSimilarWithOffsets(t *testing.T) {
 	assert.Equal(t, id1.flags, id2.flags)
 	assert.NotEqual(t, id1.flowIDMeta, id2.flowIDMeta)
 }
+
+func TestFlowIDTunnel(t *testing.T) {
+	mac1 := []byte{1, 2, 3, 4, 5, 6}
+	mac2 := []byte{6, 5, 4, 3, 2, 1}
+	outer1 := []byte{10, 0, 0, 1}
+	outer2 := []byte{10, 0, 0, 2}
+	ip1 := []byte{192, 168, 0, 1}
+	ip2 := []byte{192, 168, 0, 2}
+
+	tunnel := func(id uint32, a, b []byte) applyAddr {
+		return func(f *FlowID) {
+			addAll(addEther(mac1, mac2), addIP(outer1, outer2))(f)
+			f.AddTunnel(TunnelVXLAN, id)
+			addIP(a, b)(f)
+		}
+	}
+
+	// both directions of the inner flow are accounted to the same flow, even
+	// if the outer addresses are the same (e.g. mirrored traffic)
+	forward, reversed := newFlowID(), newFlowID()
+	tunnel(42, ip1, ip2)(forward)
+	tunnel(42, ip2, ip1)(reversed)
+	assert.True(t, FlowIDsEqual(forward, reversed))
+	assert.True(t, forward.Flags()&TunnelFlow != 0)
+
+	src, dst, _ := reversed.IPv4Addr()
+	assert.Equal(t, ip2, src)
+	assert.Equal(t, ip1, dst)
+
+	typ, id, ok := forward.TunnelInfo()
+	assert.True(t, ok)
+	assert.Equal(t, TunnelVXLAN, typ)
+	assert.Equal(t, "vxlan", typ.String())
+	assert.Equal(t, uint32(42), id)
+
+	// inner flows are separated per tunnel
+	other := newFlowID()
+	tunnel(43, ip1, ip2)(other)
+	assert.False(t, FlowIDsEqual(forward, other))
+
+	_, _, ok = newFlowID().TunnelInfo()
+	assert.False(t, ok)
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/elastic/beats/libbeat/logp"
 	"github.com/elastic/beats/packetbeat/config"
+	"github.com/elastic/beats/packetbeat/geoip"
 	"github.com/elastic/beats/packetbeat/publish"
 )
 
@@ -12,6 +13,7 @@ type Flows struct {
 	worker     *worker
 	table      *flowMetaTable
 	counterReg *counterReg
+	enricher   *geoip.Enricher
 }
 
 var debugf = logp.MakeDebug("flows")
@@ -45,9 +47,18 @@ func NewFlows(pub publish.Flows, config *config.Flows) (*Flows, error) {
 		table: make(map[flowIDMeta]*flowTable),
 	}
 
+	var enricher *geoip.Enricher
+	if config.GeoIP.IsEnabled() {
+		enricher, err = geoip.New(config.GeoIP)
+		if err != nil {
+			logp.Err("failed to load the GeoIP databases: %v", err)
+			return nil, err
+		}
+	}
+
 	counter := &counterReg{}
 
-	worker, err := newFlowsWorker(pub, table, counter, timeout, period)
+	worker, err := newFlowsWorker(pub, table, counter, enricher, timeout, period)
 	if err != nil {
 		logp.Err("failed to configure flows processing intervals: %v", err)
 		return nil, err
@@ -57,6 +68,7 @@ func NewFlows(pub publish.Flows, config *config.Flows) (*Flows, error) {
 		table:      table,
 		worker:     worker,
 		counterReg: counter,
+		enricher:   enricher,
 	}, nil
 }
 
@@ -85,6 +97,11 @@ func (f *Flows) Start() {
 
 func (f *Flows) Stop() {
 	f.worker.Stop()
+	if f.enricher != nil {
+		if err := f.enricher.Close(); err != nil {
+			logp.Err("failed to close the GeoIP databases: %v", err)
+		}
+	}
 }
 
 func (f *Flows) NewInt(name string) (*Int, error) {
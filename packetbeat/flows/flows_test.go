@@ -124,6 +124,7 @@ func TestFlowsCounting(t *testing.T) {
 	assert.Equal(t, uint16(256), source["port"])
 	assert.Equal(t, uint16(512), dest["port"])
 	assert.Equal(t, "tcp", event["transport"])
+	assert.Equal(t, "1:lov+6OShb6bXMyK0gZjGX3RabtQ=", event["community_id"])
 
 	stat := source["stats"].(map[string]interface{})
 	assert.Equal(t, int64(-1), stat["int1"])
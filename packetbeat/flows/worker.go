@@ -8,6 +8,9 @@ import (
 	"time"
 
 	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/common/flowhash"
+	"github.com/elastic/beats/packetbeat/geoip"
+	"github.com/elastic/beats/packetbeat/procs"
 	"github.com/elastic/beats/packetbeat/publish"
 )
 
@@ -15,6 +18,7 @@ type flowsProcessor struct {
 	spool    spool
 	table    *flowMetaTable
 	counters *counterReg
+	geoip    *geoip.Enricher
 	timeout  time.Duration
 }
 
@@ -27,6 +31,7 @@ func newFlowsWorker(
 	pub publish.Flows,
 	table *flowMetaTable,
 	counters *counterReg,
+	enricher *geoip.Enricher,
 	timeout, period time.Duration,
 ) (*worker, error) {
 	oneSecond := 1 * time.Second
@@ -66,6 +71,7 @@ func newFlowsWorker(
 	processor := &flowsProcessor{
 		table:    table,
 		counters: counters,
+		geoip:    enricher,
 		timeout:  timeout,
 	}
 	processor.spool.init(pub, defaultBatchSize)
@@ -175,7 +181,7 @@ func (fw *flowsProcessor) report(
 	isOver bool,
 	intNames, uintNames, floatNames []string,
 ) {
-	event := createEvent(ts, flow, isOver, intNames, uintNames, floatNames)
+	event := createEvent(ts, flow, isOver, fw.geoip, intNames, uintNames, floatNames)
 	if event != nil {
 		debugf("add event: %v", event)
 		fw.spool.publish(event)
@@ -185,6 +191,7 @@ func (fw *flowsProcessor) report(
 func createEvent(
 	ts time.Time, f *biFlow,
 	isOver bool,
+	enricher *geoip.Enricher,
 	intNames, uintNames, floatNames []string,
 ) common.MapStr {
 	event := common.MapStr{
@@ -199,6 +206,11 @@ func createEvent(
 	source := common.MapStr{}
 	dest := common.MapStr{}
 
+	// innermost addresses, used to find the processes of local endpoints
+	var srcIP, dstIP net.IP
+	var srcPort, dstPort uint16
+	var protocol uint8
+
 	// add ethernet layer meta data
 	if src, dst, ok := f.id.EthAddr(); ok {
 		source["mac"] = net.HardwareAddr(src).String()
@@ -213,6 +225,14 @@ func createEvent(
 		event["vlan"] = binary.LittleEndian.Uint16(vlan)
 	}
 
+	// add tunnel the flow is encapsulated in
+	if typ, id, ok := f.id.TunnelInfo(); ok {
+		event["tunnel"] = common.MapStr{
+			"type": typ.String(),
+			"id":   id,
+		}
+	}
+
 	// add icmp
 	if icmp := f.id.ICMPv4(); icmp != nil {
 		event["icmp_id"] = binary.LittleEndian.Uint16(icmp)
@@ -226,8 +246,9 @@ func createEvent(
 		dest["outer_ip"] = net.IP(dst).String()
 	}
 	if src, dst, ok := f.id.IPv4Addr(); ok {
-		source["ip"] = net.IP(src).String()
-		dest["ip"] = net.IP(dst).String()
+		srcIP, dstIP = net.IP(src), net.IP(dst)
+		source["ip"] = srcIP.String()
+		dest["ip"] = dstIP.String()
 	}
 
 	// ipv6 layer meta data
@@ -236,22 +257,52 @@ func createEvent(
 		dest["outer_ipv6"] = net.IP(dst).String()
 	}
 	if src, dst, ok := f.id.IPv6Addr(); ok {
-		source["ipv6"] = net.IP(src).String()
-		dest["ipv6"] = net.IP(dst).String()
+		srcIP, dstIP = net.IP(src), net.IP(dst)
+		source["ipv6"] = srcIP.String()
+		dest["ipv6"] = dstIP.String()
 	}
 
 	// udp layer meta data
 	if src, dst, ok := f.id.UDPAddr(); ok {
-		source["port"] = binary.LittleEndian.Uint16(src)
-		dest["port"] = binary.LittleEndian.Uint16(dst)
+		srcPort, dstPort = binary.LittleEndian.Uint16(src), binary.LittleEndian.Uint16(dst)
+		source["port"] = srcPort
+		dest["port"] = dstPort
 		event["transport"] = "udp"
+		protocol = flowhash.UDP
 	}
 
 	// tcp layer meta data
 	if src, dst, ok := f.id.TCPAddr(); ok {
-		source["port"] = binary.LittleEndian.Uint16(src)
-		dest["port"] = binary.LittleEndian.Uint16(dst)
+		srcPort, dstPort = binary.LittleEndian.Uint16(src), binary.LittleEndian.Uint16(dst)
+		source["port"] = srcPort
+		dest["port"] = dstPort
 		event["transport"] = "tcp"
+		protocol = flowhash.TCP
+	}
+
+	if protocol != 0 {
+		transport := procs.TransportTCP
+		if protocol == flowhash.UDP {
+			transport = procs.TransportUDP
+		}
+		addProcess(source, transport, srcIP, srcPort)
+		addProcess(dest, transport, dstIP, dstPort)
+
+		communityID := flowhash.DefaultCommunityID.Hash(flowhash.Flow{
+			SourceIP:        srcIP,
+			DestinationIP:   dstIP,
+			SourcePort:      srcPort,
+			DestinationPort: dstPort,
+			Protocol:        protocol,
+		})
+		if communityID != "" {
+			event["community_id"] = communityID
+		}
+	}
+
+	if enricher != nil {
+		addGeoIP(source, enricher, srcIP)
+		addGeoIP(dest, enricher, dstIP)
 	}
 
 	if id := f.id.ConnectionID(); id != nil {
@@ -271,6 +322,22 @@ func createEvent(
 	return event
 }
 
+// addProcess adds the process owning a local endpoint, if all the processes
+// are monitored.
+func addProcess(endpoint common.MapStr, transport procs.Transport, ip net.IP, port uint16) {
+	if p := procs.ProcWatcher.FindProcess(transport, ip, port); p != nil {
+		endpoint["proc"] = p.Name
+		endpoint["process"] = p.ToMapStr()
+	}
+}
+
+// addGeoIP adds the location and the autonomous system of an endpoint.
+func addGeoIP(endpoint common.MapStr, enricher *geoip.Enricher, ip net.IP) {
+	if fields := enricher.Lookup(ip); fields != nil {
+		endpoint["geoip"] = fields
+	}
+}
+
 func encodeStats(
 	stats *flowStats,
 	ints, uints, floats []string,
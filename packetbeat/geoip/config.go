@@ -0,0 +1,13 @@
+package geoip
+
+// Config sets the MaxMind databases used to enrich the events. Lookups are
+// disabled if no database is set.
+type Config struct {
+	CityDatabase string `config:"city_database"`
+	ASNDatabase  string `config:"asn_database"`
+}
+
+// IsEnabled returns true if a database is configured.
+func (c *Config) IsEnabled() bool {
+	return c != nil && (c.CityDatabase != "" || c.ASNDatabase != "")
+}
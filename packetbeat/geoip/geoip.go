@@ -0,0 +1,178 @@
+// Package geoip adds the location and the autonomous system of IP
+// addresses to the events, looked up in local MaxMind GeoLite2 or GeoIP2
+// City and ASN databases.
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+
+	"github.com/joeshaw/multierror"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// maximum number of addresses with cached lookups
+const maxCacheSize = 10000
+
+// Enricher looks up IP addresses in the configured databases. It is safe
+// for concurrent use.
+type Enricher struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+
+	mutex sync.Mutex
+	cache map[string]common.MapStr
+}
+
+// New opens the configured databases.
+func New(config Config) (*Enricher, error) {
+	e := &Enricher{cache: map[string]common.MapStr{}}
+
+	var err error
+	if config.CityDatabase != "" {
+		if e.city, err = open(config.CityDatabase); err != nil {
+			return nil, err
+		}
+	}
+	if config.ASNDatabase != "" {
+		if e.asn, err = open(config.ASNDatabase); err != nil {
+			e.Close()
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func open(path string) (*maxminddb.Reader, error) {
+	r, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	logp.Info("Loaded GeoIP database %s (%s)", path, r.Metadata.DatabaseType)
+	return r, nil
+}
+
+// Close releases the databases.
+func (e *Enricher) Close() error {
+	var errs multierror.Errors
+	for _, r := range []*maxminddb.Reader{e.city, e.asn} {
+		if r == nil {
+			continue
+		}
+		if err := r.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.Err()
+}
+
+// Lookup returns the geoip fields of an address, or nil if the address is
+// not found in any database.
+func (e *Enricher) Lookup(ip net.IP) common.MapStr {
+	if e == nil || ip == nil {
+		return nil
+	}
+
+	key := string(ip.To16())
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if fields, found := e.cache[key]; found {
+		return clone(fields)
+	}
+
+	fields := common.MapStr{}
+	if e.city != nil {
+		e.addCity(fields, ip)
+	}
+	if e.asn != nil {
+		e.addASN(fields, ip)
+	}
+	if len(fields) == 0 {
+		fields = nil
+	}
+
+	if len(e.cache) >= maxCacheSize {
+		e.cache = map[string]common.MapStr{}
+	}
+	e.cache[key] = fields
+	return clone(fields)
+}
+
+// clone copies the cached fields, so that the events can be modified.
+func clone(fields common.MapStr) common.MapStr {
+	if fields == nil {
+		return nil
+	}
+	return fields.Clone()
+}
+
+// namesRecord holds the localized names of a continent, region or city.
+type namesRecord struct {
+	Names map[string]string `maxminddb:"names"`
+}
+
+// cityRecord holds the fields of the City databases used by the enricher.
+type cityRecord struct {
+	Continent namesRecord `maxminddb:"continent"`
+	Country   struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []namesRecord `maxminddb:"subdivisions"`
+	City         namesRecord   `maxminddb:"city"`
+	Location     struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+// asnRecord holds the fields of the ASN databases.
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+func (e *Enricher) addCity(fields common.MapStr, ip net.IP) {
+	var record cityRecord
+	if err := e.city.Lookup(ip, &record); err != nil {
+		logp.Debug("geoip", "Lookup of %v failed: %v", ip, err)
+		return
+	}
+
+	if name := record.Continent.Names["en"]; name != "" {
+		fields["continent_name"] = name
+	}
+	if record.Country.ISOCode != "" {
+		fields["country_iso_code"] = record.Country.ISOCode
+	}
+	if len(record.Subdivisions) > 0 {
+		if name := record.Subdivisions[0].Names["en"]; name != "" {
+			fields["region_name"] = name
+		}
+	}
+	if name := record.City.Names["en"]; name != "" {
+		fields["city_name"] = name
+	}
+	if lat, lon := record.Location.Latitude, record.Location.Longitude; lat != nil && lon != nil {
+		fields["location"] = common.MapStr{"lat": *lat, "lon": *lon}
+	}
+}
+
+func (e *Enricher) addASN(fields common.MapStr, ip net.IP) {
+	var record asnRecord
+	if err := e.asn.Lookup(ip, &record); err != nil {
+		logp.Debug("geoip", "Lookup of %v failed: %v", ip, err)
+		return
+	}
+
+	if record.Number != 0 {
+		fields["asn"] = record.Number
+	}
+	if record.Organization != "" {
+		fields["organization_name"] = record.Organization
+	}
+}
@@ -0,0 +1,346 @@
+// +build !integration
+
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// data types of the MaxMind DB format
+const (
+	typePointer = 1
+	typeString  = 2
+	typeDouble  = 3
+	typeUint16  = 5
+	typeUint32  = 6
+	typeMap     = 7
+	typeArray   = 11
+)
+
+// the metadata section follows the marker
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// the search tree and the data section are separated by 16 zero bytes
+const dataSectionSeparator = 16
+
+// rawValue is written as is in the data section.
+type rawValue []byte
+
+// dbWriter builds small MaxMind databases with 24 bits records.
+type dbWriter struct {
+	ipVersion int
+	nodes     [][2]int // child node indexes, or -(data index + 1)
+	records   []interface{}
+}
+
+type network struct {
+	cidr   string
+	record interface{}
+}
+
+func buildDatabase(t *testing.T, ipVersion int, dbType string, networks []network) []byte {
+	w := &dbWriter{ipVersion: ipVersion, nodes: [][2]int{{0, 0}}}
+	for _, n := range networks {
+		_, ipnet, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.insert(ipnet, n.record)
+	}
+
+	// data section, records are referenced by their offsets
+	var data bytes.Buffer
+	offsets := make([]int, len(w.records))
+	for i, r := range w.records {
+		offsets[i] = data.Len()
+		data.Write(encodeValue(r))
+	}
+
+	nodeCount := len(w.nodes)
+	var buf bytes.Buffer
+	for _, node := range w.nodes {
+		for _, child := range node {
+			var record int
+			switch {
+			case child > 0:
+				record = child
+			case child < 0:
+				record = nodeCount + dataSectionSeparator + offsets[-child-1]
+			default:
+				record = nodeCount
+			}
+			buf.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	buf.Write(make([]byte, dataSectionSeparator))
+	buf.Write(data.Bytes())
+	buf.Write(metadataMarker)
+	buf.Write(encodeValue(map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+		"ip_version":                  uint16(ipVersion),
+		"database_type":               dbType,
+		"description":                 map[string]interface{}{"en": "test database"},
+	}))
+	return buf.Bytes()
+}
+
+func (w *dbWriter) insert(ipnet *net.IPNet, record interface{}) {
+	ip := ipnet.IP
+	ones, _ := ipnet.Mask.Size()
+	if w.ipVersion == 6 {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4.To16()
+			ip[10], ip[11] = 0, 0 // IPv4 addresses are in ::/96
+			ones += 96
+		}
+	}
+
+	w.records = append(w.records, record)
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		if i == ones-1 {
+			w.nodes[node][bit] = -len(w.records)
+			return
+		}
+		if w.nodes[node][bit] <= 0 {
+			w.nodes = append(w.nodes, [2]int{})
+			w.nodes[node][bit] = len(w.nodes) - 1
+		}
+		node = w.nodes[node][bit]
+	}
+}
+
+func encodeValue(v interface{}) []byte {
+	var buf bytes.Buffer
+	control := func(typ int, size int) {
+		var ctrl byte
+		if typ > 7 {
+			ctrl = 0
+		} else {
+			ctrl = byte(typ << 5)
+		}
+		switch {
+		case size < 29:
+			ctrl |= byte(size)
+		case size < 285:
+			ctrl |= 29
+		default:
+			ctrl |= 30
+		}
+		buf.WriteByte(ctrl)
+		if typ > 7 {
+			buf.WriteByte(byte(typ - 7))
+		}
+		switch {
+		case size < 29:
+		case size < 285:
+			buf.WriteByte(byte(size - 29))
+		default:
+			buf.Write([]byte{byte((size - 285) >> 8), byte(size - 285)})
+		}
+	}
+	uintBytes := func(u uint64) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, u)
+		return bytes.TrimLeft(b, "\x00")
+	}
+
+	switch v := v.(type) {
+	case rawValue:
+		buf.Write(v)
+	case string:
+		control(typeString, len(v))
+		buf.WriteString(v)
+	case float64:
+		control(typeDouble, 8)
+		binary.Write(&buf, binary.BigEndian, math.Float64bits(v))
+	case uint16:
+		b := uintBytes(uint64(v))
+		control(typeUint16, len(b))
+		buf.Write(b)
+	case uint32:
+		b := uintBytes(uint64(v))
+		control(typeUint32, len(b))
+		buf.Write(b)
+	case []interface{}:
+		control(typeArray, len(v))
+		for _, e := range v {
+			buf.Write(encodeValue(e))
+		}
+	case map[string]interface{}:
+		control(typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			buf.Write(encodeValue(k))
+			buf.Write(encodeValue(v[k]))
+		}
+	}
+	return buf.Bytes()
+}
+
+func names(en string) map[string]interface{} {
+	return map[string]interface{}{"names": map[string]interface{}{"en": en, "fr": "-"}}
+}
+
+var cityNetworks = []network{
+	{"81.2.69.0/24", map[string]interface{}{
+		"continent":    names("Europe"),
+		"country":      map[string]interface{}{"iso_code": "GB"},
+		"subdivisions": []interface{}{names("England")},
+		"city":         names("London"),
+		"location":     map[string]interface{}{"latitude": 51.5142, "longitude": -0.0931},
+	}},
+	{"2001:db8::/32", map[string]interface{}{
+		"continent": names("North America"),
+		"country":   map[string]interface{}{"iso_code": "US"},
+	}},
+}
+
+var asnNetworks = []network{
+	{"81.2.69.0/24", map[string]interface{}{
+		"autonomous_system_number":       uint32(20712),
+		"autonomous_system_organization": "Andrews & Arnold Ltd",
+	}},
+}
+
+func writeDatabase(t *testing.T, dir, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEnricher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e, err := New(Config{
+		CityDatabase: writeDatabase(t, dir, "city.mmdb", buildDatabase(t, 6, "GeoLite2-City", cityNetworks)),
+		ASNDatabase:  writeDatabase(t, dir, "asn.mmdb", buildDatabase(t, 6, "GeoLite2-ASN", asnNetworks)),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expected := common.MapStr{
+		"continent_name":    "Europe",
+		"country_iso_code":  "GB",
+		"region_name":       "England",
+		"city_name":         "London",
+		"location":          common.MapStr{"lat": 51.5142, "lon": -0.0931},
+		"asn":               uint(20712),
+		"organization_name": "Andrews & Arnold Ltd",
+	}
+	assert.Equal(t, expected, e.Lookup(net.ParseIP("81.2.69.142")))
+
+	// cached
+	fields := e.Lookup(net.ParseIP("81.2.69.142"))
+	assert.Equal(t, expected, fields)
+	fields["city_name"] = "modified"
+	assert.Equal(t, expected, e.Lookup(net.ParseIP("81.2.69.142")))
+
+	assert.Equal(t, common.MapStr{"continent_name": "North America", "country_iso_code": "US"},
+		e.Lookup(net.ParseIP("2001:db8::1")))
+	assert.Nil(t, e.Lookup(net.ParseIP("10.0.0.1")))
+}
+
+func TestEnricherMissingDatabase(t *testing.T) {
+	_, err := New(Config{CityDatabase: "/nonexistent/GeoLite2-City.mmdb"})
+	assert.Error(t, err)
+}
+
+func TestEnricherIPv4Database(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e, err := New(Config{
+		CityDatabase: writeDatabase(t, dir, "city.mmdb", buildDatabase(t, 4, "GeoLite2-City", cityNetworks[:1])),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer e.Close()
+
+	assert.Equal(t, "GB", e.Lookup(net.ParseIP("81.2.69.142"))["country_iso_code"])
+	assert.Nil(t, e.Lookup(net.ParseIP("81.2.70.1")))
+	assert.Nil(t, e.Lookup(net.ParseIP("2001:db8::1")))
+}
+
+// Verify that the corrupted databases are rejected when they are opened, or
+// that their lookups fail without adding any field.
+func TestEnricherCorruptDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := buildDatabase(t, 6, "GeoLite2-City", cityNetworks)
+	invalid := map[string][]byte{
+		"not a database": []byte("not a database"),
+		"truncated":      db[:len(db)/2],
+	}
+	for name, data := range invalid {
+		_, err := New(Config{CityDatabase: writeDatabase(t, dir, "invalid.mmdb", data)})
+		assert.Error(t, err, name)
+		os.Remove(filepath.Join(dir, "invalid.mmdb"))
+	}
+
+	cityKey := encodeValue("city")
+	corrupt := map[string]rawValue{
+		// map with a pointer beyond the data section
+		"bad pointer": append(append(rawValue{0xe1}, cityKey...), 0x38, 0xff, 0xff, 0xff, 0xff),
+		// map with a string larger than the data section
+		"oversized string": append(append(rawValue{0xe1}, cityKey...), 0x5f, 0xff, 0xff, 0xff),
+		// pointer to itself
+		"cyclic pointer": rawValue{typePointer << 5, 0x00},
+	}
+	for name, record := range corrupt {
+		path := writeDatabase(t, dir, "corrupt.mmdb", buildDatabase(t, 6, "GeoLite2-City", []network{
+			{"81.2.69.0/24", record},
+		}))
+		e, err := New(Config{CityDatabase: path})
+		if assert.NoError(t, err, name) {
+			assert.Nil(t, e.Lookup(net.ParseIP("81.2.69.142")), name)
+			e.Close()
+		}
+		os.Remove(path)
+	}
+
+	// truncated data section
+	marker := bytes.LastIndex(db, metadataMarker)
+	treeEnd := bytes.Index(db, make([]byte, dataSectionSeparator)) + dataSectionSeparator
+	truncated := append(db[:treeEnd+5:treeEnd+5], db[marker:]...)
+	e, err := New(Config{CityDatabase: writeDatabase(t, dir, "truncated.mmdb", truncated)})
+	if assert.NoError(t, err) {
+		assert.Nil(t, e.Lookup(net.ParseIP("81.2.69.142")))
+		e.Close()
+	}
+}
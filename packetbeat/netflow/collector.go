@@ -0,0 +1,103 @@
+// Package netflow implements a collector of the flow records exported by
+// routers and switches with NetFlow v5, NetFlow v9 and IPFIX. The records are
+// published with the layout of the flows reported by Packetbeat.
+package netflow
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/publish"
+)
+
+// maximum size of an export packet over UDP
+const maxPacketSize = 65535
+
+var debugf = logp.MakeDebug("netflow")
+
+// Collector receives the export packets over UDP.
+type Collector struct {
+	conn    net.PacketConn
+	decoder *decoder
+	results publish.Flows
+
+	wg sync.WaitGroup
+}
+
+// New creates a collector listening on the configured address. The socket
+// is opened before the privileges are dropped.
+func New(cfg *common.Config, results publish.Flows) (*Collector, error) {
+	config := defaultConfig
+	if err := cfg.Unpack(&config); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenPacket("udp", config.Host)
+	if err != nil {
+		return nil, err
+	}
+	if config.ReadBuffer > 0 {
+		if udp, ok := conn.(*net.UDPConn); ok {
+			if err := udp.SetReadBuffer(config.ReadBuffer); err != nil {
+				logp.Warn("Failed to set the NetFlow read buffer size: %v", err)
+			}
+		}
+	}
+	logp.Info("NetFlow collector listening on %v", conn.LocalAddr())
+
+	return &Collector{
+		conn:    conn,
+		decoder: newDecoder(&config),
+		results: results,
+	}, nil
+}
+
+// Start receives the export packets in background.
+func (c *Collector) Start() {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.run()
+	}()
+}
+
+// Stop closes the socket and waits for the collector to finish.
+func (c *Collector) Stop() {
+	c.conn.Close()
+	c.wg.Wait()
+}
+
+func (c *Collector) run() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			// the socket is closed when stopping
+			debugf("Stop receiving: %v", err)
+			return
+		}
+		c.process(addr.String(), buf[:n], time.Now())
+	}
+}
+
+func (c *Collector) process(exporter string, data []byte, now time.Time) {
+	records, err := c.decoder.decode(exporter, data, now)
+	if err != nil {
+		debugf("Invalid export packet from %s: %v", exporter, err)
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	events := make([]common.MapStr, len(records))
+	for i, r := range records {
+		events[i] = r.toEvent()
+	}
+	c.results.PublishFlows(events)
+}
@@ -0,0 +1,84 @@
+// +build !integration
+
+package netflow
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+type flowsChan struct {
+	ch chan []common.MapStr
+}
+
+func (f *flowsChan) PublishFlows(events []common.MapStr) bool {
+	f.ch <- events
+	return true
+}
+
+func TestCollector(t *testing.T) {
+	cfg, err := common.NewConfigFrom(map[string]interface{}{
+		"host": "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := &flowsChan{make(chan []common.MapStr, 1)}
+	collector, err := New(cfg, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector.Start()
+	defer collector.Stop()
+
+	conn, err := net.Dial("udp", collector.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, payload := range readPcap(t, "testdata/netflow_v5.pcap") {
+		if _, err := conn.Write(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case events := <-results.ch:
+		if assert.Len(t, events, 2) {
+			assert.Equal(t, "10.0.0.1", events[0]["source"].(common.MapStr)["ip"])
+			exporter := events[0]["netflow"].(common.MapStr)["exporter"].(common.MapStr)
+			assert.Equal(t, conn.LocalAddr().String(), exporter["address"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the flows")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg, err := common.NewConfigFrom(map[string]interface{}{
+		"custom_fields": []map[string]interface{}{
+			{"enterprise": 9, "id": 12235, "name": "cisco_application_tag", "type": "text"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := defaultConfig
+	assert.Error(t, cfg.Unpack(&config))
+
+	cfg, err = common.NewConfigFrom(map[string]interface{}{
+		"template_timeout": "100ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = defaultConfig
+	assert.Error(t, cfg.Unpack(&config))
+}
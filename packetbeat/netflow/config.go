@@ -0,0 +1,58 @@
+package netflow
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Config of the NetFlow and IPFIX collector.
+type Config struct {
+	Host            string        `config:"host"`
+	ReadBuffer      int           `config:"read_buffer"`
+	TemplateTimeout time.Duration `config:"template_timeout"`
+	CustomFields    []CustomField `config:"custom_fields"`
+}
+
+// CustomField decodes an information element not known by the collector,
+// like the vendor specific elements of an enterprise.
+type CustomField struct {
+	Enterprise uint32 `config:"enterprise"`
+	ID         uint16 `config:"id" validate:"required"`
+	Name       string `config:"name" validate:"required"`
+	Type       string `config:"type"`
+}
+
+var (
+	defaultConfig = Config{
+		Host:            ":2055",
+		TemplateTimeout: 30 * time.Minute,
+	}
+)
+
+var (
+	ErrInvalidTemplateTimeout = errors.New("template_timeout must be >= 1s")
+	ErrInvalidReadBuffer      = errors.New("read_buffer must not be negative")
+)
+
+func (c *Config) Validate() error {
+	if c.TemplateTimeout < time.Second {
+		return ErrInvalidTemplateTimeout
+	}
+	if c.ReadBuffer < 0 {
+		return ErrInvalidReadBuffer
+	}
+	for _, f := range c.CustomFields {
+		if _, found := fieldTypes[f.typeName()]; !found {
+			return fmt.Errorf("unknown type '%s' of custom field %s", f.Type, f.Name)
+		}
+	}
+	return nil
+}
+
+func (f *CustomField) typeName() string {
+	if f.Type == "" {
+		return "octets"
+	}
+	return f.Type
+}
@@ -0,0 +1,399 @@
+package netflow
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	v5HeaderLength    = 24
+	v5RecordLength    = 48
+	v9HeaderLength    = 20
+	ipfixHeaderLength = 16
+
+	// set IDs of the template sets, data sets have IDs >= 256
+	v9TemplateSetID    = 0
+	v9OptionsSetID     = 1
+	ipfixTemplateSetID = 2
+	ipfixOptionsSetID  = 3
+	minDataSetID       = 256
+
+	// IPFIX field specifiers with this bit set are followed by the
+	// enterprise number
+	enterpriseBit = 0x8000
+
+	// variable length values of 255 bytes or more have a 3 bytes length
+	longVariableLength = 255
+
+	// the 2 high bits of the NetFlow v5 sampling interval are the mode
+	v5SamplingModeShift = 14
+)
+
+var (
+	errTruncated        = errors.New("truncated packet")
+	errInvalidSetLength = errors.New("invalid set length")
+	errInvalidTemplate  = errors.New("invalid template")
+)
+
+// record is a decoded flow record with the header of its export packet.
+type record struct {
+	exporter   string
+	version    uint16
+	domain     uint32 // v9 source ID or IPFIX observation domain
+	exportTime time.Time
+
+	// uptime of the exporter, the flow times of NetFlow v5 and v9 are
+	// relative to it
+	uptime    time.Duration
+	hasUptime bool
+
+	fields map[string]interface{}
+}
+
+type decoder struct {
+	fields    map[fieldKey]fieldDef
+	templates *templateCache
+}
+
+func newDecoder(config *Config) *decoder {
+	fields := make(map[fieldKey]fieldDef, len(ianaFields)+len(config.CustomFields))
+	for id, def := range ianaFields {
+		fields[fieldKey{id: id}] = def
+	}
+	for _, f := range config.CustomFields {
+		key := fieldKey{enterprise: f.Enterprise, id: f.ID}
+		fields[key] = fieldDef{name: f.Name, typ: fieldTypes[f.typeName()]}
+	}
+
+	return &decoder{
+		fields:    fields,
+		templates: newTemplateCache(config.TemplateTimeout),
+	}
+}
+
+// decode decodes an export packet. The records decoded before an error are
+// returned with the error.
+func (d *decoder) decode(exporter string, data []byte, now time.Time) ([]*record, error) {
+	if len(data) < 2 {
+		return nil, errTruncated
+	}
+	d.templates.expire(now)
+
+	switch version := binary.BigEndian.Uint16(data); version {
+	case 5:
+		return d.decodeV5(exporter, data)
+	case 9:
+		return d.decodeV9(exporter, data, now)
+	case 10:
+		return d.decodeIPFIX(exporter, data, now)
+	default:
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+}
+
+func (d *decoder) decodeV5(exporter string, data []byte) ([]*record, error) {
+	if len(data) < v5HeaderLength {
+		return nil, errTruncated
+	}
+	count := int(binary.BigEndian.Uint16(data[2:]))
+	if len(data) < v5HeaderLength+count*v5RecordLength {
+		return nil, errTruncated
+	}
+
+	engineType, engineID := data[20], data[21]
+	sampling := binary.BigEndian.Uint16(data[22:])
+	header := record{
+		exporter: exporter,
+		version:  5,
+		domain:   uint32(engineType)<<8 | uint32(engineID),
+		exportTime: time.Unix(int64(binary.BigEndian.Uint32(data[8:])),
+			int64(binary.BigEndian.Uint32(data[12:]))).UTC(),
+		uptime:    time.Duration(binary.BigEndian.Uint32(data[4:])) * time.Millisecond,
+		hasUptime: true,
+	}
+
+	records := make([]*record, 0, count)
+	for i := 0; i < count; i++ {
+		b := data[v5HeaderLength+i*v5RecordLength:]
+		fields := map[string]interface{}{
+			"source_ipv4_address":            ipv4Type.decode(b[0:4]),
+			"destination_ipv4_address":       ipv4Type.decode(b[4:8]),
+			"ip_next_hop_ipv4_address":       ipv4Type.decode(b[8:12]),
+			"ingress_interface":              decodeUint(b[12:14]),
+			"egress_interface":               decodeUint(b[14:16]),
+			"packet_delta_count":             decodeUint(b[16:20]),
+			"octet_delta_count":              decodeUint(b[20:24]),
+			"flow_start_sys_up_time":         decodeUint(b[24:28]),
+			"flow_end_sys_up_time":           decodeUint(b[28:32]),
+			"source_transport_port":          decodeUint(b[32:34]),
+			"destination_transport_port":     decodeUint(b[34:36]),
+			"tcp_control_bits":               uint64(b[37]),
+			"protocol_identifier":            uint64(b[38]),
+			"ip_class_of_service":            uint64(b[39]),
+			"bgp_source_as_number":           decodeUint(b[40:42]),
+			"bgp_destination_as_number":      decodeUint(b[42:44]),
+			"source_ipv4_prefix_length":      uint64(b[44]),
+			"destination_ipv4_prefix_length": uint64(b[45]),
+			"engine_type":                    uint64(engineType),
+			"engine_id":                      uint64(engineID),
+		}
+		if sampling != 0 {
+			fields["sampling_algorithm"] = uint64(sampling >> v5SamplingModeShift)
+			fields["sampling_interval"] = uint64(sampling & (1<<v5SamplingModeShift - 1))
+		}
+
+		r := header
+		r.fields = fields
+		records = append(records, &r)
+	}
+	return records, nil
+}
+
+func (d *decoder) decodeV9(exporter string, data []byte, now time.Time) ([]*record, error) {
+	if len(data) < v9HeaderLength {
+		return nil, errTruncated
+	}
+
+	header := &record{
+		exporter:   exporter,
+		version:    9,
+		domain:     binary.BigEndian.Uint32(data[16:]),
+		exportTime: time.Unix(int64(binary.BigEndian.Uint32(data[8:])), 0).UTC(),
+		uptime:     time.Duration(binary.BigEndian.Uint32(data[4:])) * time.Millisecond,
+		hasUptime:  true,
+	}
+	return d.decodeSets(header, data[v9HeaderLength:], now)
+}
+
+func (d *decoder) decodeIPFIX(exporter string, data []byte, now time.Time) ([]*record, error) {
+	if len(data) < ipfixHeaderLength {
+		return nil, errTruncated
+	}
+	length := int(binary.BigEndian.Uint16(data[2:]))
+	if length < ipfixHeaderLength || length > len(data) {
+		return nil, errTruncated
+	}
+
+	header := &record{
+		exporter:   exporter,
+		version:    10,
+		domain:     binary.BigEndian.Uint32(data[12:]),
+		exportTime: time.Unix(int64(binary.BigEndian.Uint32(data[4:])), 0).UTC(),
+	}
+	return d.decodeSets(header, data[ipfixHeaderLength:length], now)
+}
+
+// decodeSets decodes the sets (flowsets in NetFlow v9) of a packet.
+func (d *decoder) decodeSets(header *record, data []byte, now time.Time) ([]*record, error) {
+	var records []*record
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return records, errTruncated
+		}
+		id := binary.BigEndian.Uint16(data)
+		length := int(binary.BigEndian.Uint16(data[2:]))
+		if length < 4 || length > len(data) {
+			return records, errInvalidSetLength
+		}
+		body := data[4:length]
+		data = data[length:]
+
+		var err error
+		switch {
+		case header.version == 9 && id == v9TemplateSetID:
+			err = d.decodeV9Templates(header, body, now)
+		case header.version == 9 && id == v9OptionsSetID:
+			err = d.decodeV9OptionsTemplates(header, body, now)
+		case header.version == 10 && id == ipfixTemplateSetID:
+			err = d.decodeIPFIXTemplates(header, body, false, now)
+		case header.version == 10 && id == ipfixOptionsSetID:
+			err = d.decodeIPFIXTemplates(header, body, true, now)
+		case id >= minDataSetID:
+			records, err = d.decodeData(header, id, body, records, now)
+		default:
+			debugf("Ignoring set %d from %s", id, header.exporter)
+		}
+		if err != nil {
+			return records, err
+		}
+	}
+	return records, nil
+}
+
+func (d *decoder) decodeV9Templates(header *record, b []byte, now time.Time) error {
+	for len(b) >= 4 {
+		id := binary.BigEndian.Uint16(b)
+		count := int(binary.BigEndian.Uint16(b[2:]))
+		if id < minDataSetID {
+			// padding
+			return nil
+		}
+		b = b[4:]
+
+		fields, rest, err := decodeV9Fields(b, count)
+		if err != nil {
+			return err
+		}
+		b = rest
+		d.addTemplate(header, id, newTemplate(fields, false, now))
+	}
+	return nil
+}
+
+func (d *decoder) decodeV9OptionsTemplates(header *record, b []byte, now time.Time) error {
+	for len(b) >= 6 {
+		id := binary.BigEndian.Uint16(b)
+		scopeLength := int(binary.BigEndian.Uint16(b[2:]))
+		optionLength := int(binary.BigEndian.Uint16(b[4:]))
+		if id < minDataSetID {
+			return nil
+		}
+		b = b[6:]
+
+		fields, rest, err := decodeV9Fields(b, (scopeLength+optionLength)/4)
+		if err != nil {
+			return err
+		}
+		b = rest
+		d.addTemplate(header, id, newTemplate(fields, true, now))
+	}
+	return nil
+}
+
+func decodeV9Fields(b []byte, count int) ([]templateField, []byte, error) {
+	if len(b) < count*4 {
+		return nil, nil, errTruncated
+	}
+	fields := make([]templateField, count)
+	for i := range fields {
+		fields[i] = templateField{
+			key:    fieldKey{id: binary.BigEndian.Uint16(b[i*4:])},
+			length: binary.BigEndian.Uint16(b[i*4+2:]),
+		}
+		if fields[i].length == variableLength {
+			return nil, nil, errInvalidTemplate
+		}
+	}
+	return fields, b[count*4:], nil
+}
+
+func (d *decoder) decodeIPFIXTemplates(header *record, b []byte, options bool, now time.Time) error {
+	for len(b) >= 4 {
+		id := binary.BigEndian.Uint16(b)
+		count := int(binary.BigEndian.Uint16(b[2:]))
+		b = b[4:]
+
+		if count == 0 {
+			// the set ID withdraws all the templates of the domain
+			if id == ipfixTemplateSetID || id == ipfixOptionsSetID {
+				d.templates.removeAll(header.exporter, header.version, header.domain)
+			} else {
+				d.templates.remove(d.templateKey(header, id))
+			}
+			continue
+		}
+		if id < minDataSetID {
+			return errInvalidTemplate
+		}
+
+		if options {
+			// scope field count, the scope fields are decoded as the other
+			// fields
+			if len(b) < 2 {
+				return errTruncated
+			}
+			b = b[2:]
+		}
+
+		fields := make([]templateField, 0, count)
+		for i := 0; i < count; i++ {
+			if len(b) < 4 {
+				return errTruncated
+			}
+			f := templateField{
+				key:    fieldKey{id: binary.BigEndian.Uint16(b)},
+				length: binary.BigEndian.Uint16(b[2:]),
+			}
+			b = b[4:]
+
+			if f.key.id&enterpriseBit != 0 {
+				if len(b) < 4 {
+					return errTruncated
+				}
+				f.key.id &^= enterpriseBit
+				f.key.enterprise = binary.BigEndian.Uint32(b)
+				b = b[4:]
+			}
+			fields = append(fields, f)
+		}
+		d.addTemplate(header, id, newTemplate(fields, options, now))
+	}
+	return nil
+}
+
+func (d *decoder) templateKey(header *record, id uint16) templateKey {
+	return templateKey{
+		exporter: header.exporter,
+		version:  header.version,
+		domain:   header.domain,
+		id:       id,
+	}
+}
+
+func (d *decoder) addTemplate(header *record, id uint16, t *template) {
+	debugf("Template %d from %s (domain %d) with %d fields",
+		id, header.exporter, header.domain, len(t.fields))
+	d.templates.add(d.templateKey(header, id), t)
+}
+
+func (d *decoder) decodeData(
+	header *record,
+	id uint16,
+	b []byte,
+	records []*record,
+	now time.Time,
+) ([]*record, error) {
+	t := d.templates.get(d.templateKey(header, id), now)
+	if t == nil {
+		debugf("Missing template %d from %s (domain %d)", id, header.exporter, header.domain)
+		return records, nil
+	}
+	if t.options || t.minLength == 0 {
+		return records, nil
+	}
+
+	// the end of the set is padded with less bytes than a record
+	for len(b) >= t.minLength {
+		fields := make(map[string]interface{}, len(t.fields))
+		for _, f := range t.fields {
+			length := int(f.length)
+			if f.length == variableLength {
+				if len(b) < 1 {
+					return records, errTruncated
+				}
+				length, b = int(b[0]), b[1:]
+				if length == longVariableLength {
+					if len(b) < 2 {
+						return records, errTruncated
+					}
+					length, b = int(binary.BigEndian.Uint16(b)), b[2:]
+				}
+			}
+			if len(b) < length {
+				return records, errTruncated
+			}
+
+			if def, found := d.fields[f.key]; found {
+				fields[def.name] = def.typ.decode(b[:length])
+			}
+			b = b[length:]
+		}
+
+		r := *header
+		r.fields = fields
+		records = append(records, &r)
+	}
+	return records, nil
+}
@@ -0,0 +1,222 @@
+// +build !integration
+
+package netflow
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tsg/gopacket"
+	"github.com/tsg/gopacket/layers"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const exporter = "192.0.2.1:50000"
+
+// readPcap returns the UDP payloads of the export packets captured in a
+// pcap file.
+func readPcap(t *testing.T, path string) [][]byte {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var payloads [][]byte
+	for data = data[24:]; len(data) >= 16; {
+		length := int(binary.LittleEndian.Uint32(data[8:]))
+		frame := data[16 : 16+length]
+		data = data[16+length:]
+
+		packet := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default)
+		udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		if !ok {
+			t.Fatalf("no UDP layer in %s", path)
+		}
+		payloads = append(payloads, udp.Payload)
+	}
+	return payloads
+}
+
+// replay decodes the export packets of a pcap file and returns the events
+// of each packet.
+func replay(t *testing.T, d *decoder, path string) [][]common.MapStr {
+	var events [][]common.MapStr
+	for _, payload := range readPcap(t, path) {
+		records, err := d.decode(exporter, payload, time.Now())
+		if !assert.NoError(t, err) {
+			continue
+		}
+		var packetEvents []common.MapStr
+		for _, r := range records {
+			packetEvents = append(packetEvents, r.toEvent())
+		}
+		events = append(events, packetEvents)
+	}
+	return events
+}
+
+func newTestDecoder(customFields ...CustomField) *decoder {
+	config := defaultConfig
+	config.CustomFields = customFields
+	return newDecoder(&config)
+}
+
+func TestNetflowV5(t *testing.T) {
+	events := replay(t, newTestDecoder(), "testdata/netflow_v5.pcap")
+	if !assert.Len(t, events, 1) || !assert.Len(t, events[0], 2) {
+		return
+	}
+
+	event := events[0][0]
+	assert.Equal(t, "flow", event["type"])
+	assert.Equal(t, "tcp", event["transport"])
+	assert.Equal(t, common.Time(time.Unix(1500000000, 0).UTC()), event["@timestamp"])
+	assert.Equal(t, common.Time(time.Unix(1499999990, 0).UTC()), event["start_time"])
+	assert.Equal(t, common.Time(time.Unix(1499999999, 0).UTC()), event["last_time"])
+	assert.Equal(t, common.MapStr{
+		"ip":    "10.0.0.1",
+		"port":  uint64(40000),
+		"stats": common.MapStr{"net_bytes_total": uint64(1500), "net_packets_total": uint64(10)},
+	}, event["source"])
+	assert.Equal(t, common.MapStr{"ip": "10.0.0.2", "port": uint64(443)}, event["dest"])
+	assert.NotEmpty(t, event["community_id"])
+	assert.NotEmpty(t, event["flow_id"])
+
+	netflow := event["netflow"].(common.MapStr)
+	assert.Equal(t, "10.0.0.254", netflow["ip_next_hop_ipv4_address"])
+	assert.Equal(t, uint64(64513), netflow["bgp_destination_as_number"])
+	assert.Equal(t, uint64(100), netflow["sampling_interval"])
+	assert.Equal(t, common.MapStr{
+		"address":       exporter,
+		"version":       uint16(5),
+		"source_id":     uint32(0x102),
+		"uptime_millis": int64(3600000),
+	}, netflow["exporter"])
+
+	// ICMP type and code in the destination port
+	event = events[0][1]
+	assert.Nil(t, event["transport"])
+	assert.Equal(t, common.MapStr{"ip": "8.8.8.8"}, event["dest"])
+	assert.Equal(t, "1:BOUVryuJaU7sglBpPefJgFhDQDA=", event["community_id"])
+}
+
+func TestNetflowV9(t *testing.T) {
+	events := replay(t, newTestDecoder(), "testdata/netflow_v9.pcap")
+	if !assert.Len(t, events, 3) {
+		return
+	}
+
+	// data received before the templates and the templates
+	assert.Empty(t, events[0])
+	assert.Empty(t, events[1])
+
+	// options records are not published
+	if !assert.Len(t, events[2], 2) {
+		return
+	}
+
+	event := events[2][0]
+	assert.Equal(t, "udp", event["transport"])
+	assert.Equal(t, common.Time(time.Unix(1500000100, 0).UTC()), event["@timestamp"])
+	assert.Equal(t, common.Time(time.Unix(1500000000, 0).UTC()), event["start_time"])
+	assert.Equal(t, common.Time(time.Unix(1500000050, 0).UTC()), event["last_time"])
+	assert.Equal(t, common.MapStr{
+		"ip":    "172.16.0.1",
+		"mac":   "00:50:56:aa:bb:cc",
+		"port":  uint64(53000),
+		"stats": common.MapStr{"net_bytes_total": uint64(120), "net_packets_total": uint64(2)},
+	}, event["source"])
+	assert.Equal(t, common.MapStr{"ip": "172.16.0.2", "port": uint64(53)}, event["dest"])
+
+	netflow := event["netflow"].(common.MapStr)
+	assert.Equal(t, uint64(3), netflow["ingress_interface"])
+	assert.Equal(t, uint32(42), netflow["exporter"].(common.MapStr)["source_id"])
+
+	event = events[2][1]
+	assert.Equal(t, "tcp", event["transport"])
+	assert.Equal(t, uint64(80), event["dest"].(common.MapStr)["port"])
+}
+
+func TestIPFIX(t *testing.T) {
+	d := newTestDecoder(CustomField{Enterprise: 9, ID: 12235, Name: "cisco_application_tag", Type: "string"})
+	events := replay(t, d, "testdata/ipfix.pcap")
+	if !assert.Len(t, events, 3) {
+		return
+	}
+
+	assert.Empty(t, events[0])
+	if !assert.Len(t, events[1], 1) {
+		return
+	}
+
+	event := events[1][0]
+	assert.Equal(t, "tcp", event["transport"])
+	assert.Equal(t, common.Time(time.Unix(1500000190, 123000000).UTC()), event["start_time"])
+	assert.Equal(t, common.Time(time.Unix(1500000199, 456000000).UTC()), event["last_time"])
+	assert.Equal(t, common.MapStr{
+		"ipv6":  "2001:db8::1",
+		"port":  uint64(50123),
+		"stats": common.MapStr{"net_bytes_total": uint64(3000), "net_packets_total": uint64(20)},
+	}, event["source"])
+	assert.Equal(t, common.MapStr{
+		"ipv6":  "2001:db8::2",
+		"port":  uint64(443),
+		"stats": common.MapStr{"net_bytes_total": uint64(150000), "net_packets_total": uint64(110)},
+	}, event["dest"])
+
+	netflow := event["netflow"].(common.MapStr)
+	assert.Equal(t, "https", netflow["application_name"])
+	assert.Equal(t, "web", netflow["cisco_application_tag"])
+	assert.Equal(t, uint32(7), netflow["exporter"].(common.MapStr)["source_id"])
+	assert.Nil(t, netflow["exporter"].(common.MapStr)["uptime_millis"])
+
+	// the template is withdrawn before the data set
+	assert.Empty(t, events[2])
+}
+
+func TestTemplateExpiration(t *testing.T) {
+	d := newTestDecoder()
+	payloads := readPcap(t, "testdata/netflow_v9.pcap")
+	now := time.Now()
+
+	_, err := d.decode(exporter, payloads[1], now)
+	assert.NoError(t, err)
+
+	records, err := d.decode(exporter, payloads[2], now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	// templates are scoped by exporter
+	records, err = d.decode("192.0.2.2:50000", payloads[2], now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, records, 0)
+
+	records, err = d.decode(exporter, payloads[2], now.Add(defaultConfig.TemplateTimeout+time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, records, 0)
+	assert.Len(t, d.templates.templates, 0)
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	d := newTestDecoder()
+
+	_, err := d.decode(exporter, []byte{0, 7, 0, 0}, time.Now())
+	assert.Error(t, err)
+
+	v5 := readPcap(t, "testdata/netflow_v5.pcap")[0]
+	_, err = d.decode(exporter, v5[:len(v5)-1], time.Now())
+	assert.Equal(t, errTruncated, err)
+
+	ipfix := readPcap(t, "testdata/ipfix.pcap")[0]
+	_, err = d.decode(exporter, ipfix[:len(ipfix)-4], time.Now())
+	assert.Equal(t, errTruncated, err)
+
+	v9 := readPcap(t, "testdata/netflow_v9.pcap")[1]
+	binary.BigEndian.PutUint16(v9[22:], 1000)
+	_, err = d.decode(exporter, v9, time.Now())
+	assert.Equal(t, errInvalidSetLength, err)
+}
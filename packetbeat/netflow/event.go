@@ -0,0 +1,235 @@
+package netflow
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"hash/fnv"
+	"net"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/common/flowhash"
+)
+
+// toEvent converts a flow record to an event with the layout of the flows
+// reported by packet capture, so that the same dashboards can be used. All
+// the decoded fields are added to the netflow group.
+func (r *record) toEvent() common.MapStr {
+	f := r.fields
+	start, end := r.flowTimes()
+
+	event := common.MapStr{
+		"@timestamp": common.Time(r.exportTime),
+		"start_time": common.Time(start),
+		"last_time":  common.Time(end),
+		"type":       "flow",
+		"final":      true,
+	}
+	source := common.MapStr{}
+	dest := common.MapStr{}
+
+	srcIP, dstIP := r.ip("source"), r.ip("destination")
+	if srcIP != nil {
+		source[ipField(srcIP)] = srcIP.String()
+	}
+	if dstIP != nil {
+		dest[ipField(dstIP)] = dstIP.String()
+	}
+
+	if mac, ok := f["source_mac_address"].(net.HardwareAddr); ok {
+		source["mac"] = mac.String()
+	}
+	if mac, ok := f["destination_mac_address"].(net.HardwareAddr); ok {
+		dest["mac"] = mac.String()
+	} else if mac, ok := f["post_destination_mac_address"].(net.HardwareAddr); ok {
+		dest["mac"] = mac.String()
+	}
+
+	if vlan, ok := r.uint("vlan_id", "dot1q_vlan_id"); ok {
+		event["vlan"] = vlan
+	}
+
+	protocol, _ := r.uint("protocol_identifier")
+	srcPort, _ := r.uint("source_transport_port", "tcp_source_port", "udp_source_port")
+	dstPort, _ := r.uint("destination_transport_port", "tcp_destination_port", "udp_destination_port")
+
+	flow := flowhash.Flow{
+		SourceIP:      srcIP,
+		DestinationIP: dstIP,
+		Protocol:      uint8(protocol),
+	}
+	switch protocol {
+	case flowhash.TCP, flowhash.UDP, flowhash.SCTP:
+		source["port"] = srcPort
+		dest["port"] = dstPort
+		flow.SourcePort, flow.DestinationPort = uint16(srcPort), uint16(dstPort)
+		switch protocol {
+		case flowhash.TCP:
+			event["transport"] = "tcp"
+		case flowhash.UDP:
+			event["transport"] = "udp"
+		}
+	case flowhash.ICMP, flowhash.ICMPv6:
+		flow.ICMPType, flow.ICMPCode = r.icmpTypeCode(uint16(dstPort))
+	}
+	if communityID := flowhash.DefaultCommunityID.Hash(flow); communityID != "" {
+		event["community_id"] = communityID
+	}
+	event["flow_id"] = r.flowID(flow, start)
+
+	// unidirectional flows only count the packets sent by the source
+	if stats := r.stats("octet_delta_count", "packet_delta_count"); stats != nil {
+		source["stats"] = stats
+	} else if stats := r.stats("octet_total_count", "packet_total_count"); stats != nil {
+		source["stats"] = stats
+	} else if stats := r.stats("initiator_octets", "initiator_packets"); stats != nil {
+		source["stats"] = stats
+	}
+	if stats := r.stats("responder_octets", "responder_packets"); stats != nil {
+		dest["stats"] = stats
+	}
+
+	event["source"] = source
+	event["dest"] = dest
+	event["netflow"] = r.netflowFields()
+	return event
+}
+
+func (r *record) netflowFields() common.MapStr {
+	fields := make(common.MapStr, len(r.fields)+1)
+	for name, value := range r.fields {
+		switch v := value.(type) {
+		case net.IP:
+			fields[name] = v.String()
+		case net.HardwareAddr:
+			fields[name] = v.String()
+		case time.Time:
+			fields[name] = common.Time(v)
+		default:
+			fields[name] = v
+		}
+	}
+
+	exporter := common.MapStr{
+		"address":   r.exporter,
+		"version":   r.version,
+		"source_id": r.domain,
+	}
+	if r.hasUptime {
+		exporter["uptime_millis"] = int64(r.uptime / time.Millisecond)
+	}
+	fields["exporter"] = exporter
+	return fields
+}
+
+// flowTimes returns the start and end times of the flow, or the export
+// time if the exporter doesn't report them.
+func (r *record) flowTimes() (start, end time.Time) {
+	start, end = r.exportTime, r.exportTime
+	if t, ok := r.time("flow_start"); ok {
+		start = t
+	}
+	if t, ok := r.time("flow_end"); ok {
+		end = t
+	}
+	if end.Before(start) {
+		start = end
+	}
+	return start, end
+}
+
+func (r *record) time(prefix string) (time.Time, bool) {
+	for _, suffix := range []string{"_milliseconds", "_seconds", "_microseconds", "_nanoseconds"} {
+		if t, ok := r.fields[prefix+suffix].(time.Time); ok {
+			return t, true
+		}
+	}
+
+	uptime, ok := r.fields[prefix+"_sys_up_time"].(uint64)
+	if !ok {
+		return time.Time{}, false
+	}
+	millis := time.Duration(uptime) * time.Millisecond
+	if r.hasUptime {
+		return r.exportTime.Add(millis - r.uptime), true
+	}
+	if init, ok := r.fields["system_init_time_milliseconds"].(time.Time); ok {
+		return init.Add(millis), true
+	}
+	return time.Time{}, false
+}
+
+func (r *record) ip(prefix string) net.IP {
+	if ip, ok := r.fields[prefix+"_ipv4_address"].(net.IP); ok {
+		return ip
+	}
+	if ip, ok := r.fields[prefix+"_ipv6_address"].(net.IP); ok {
+		return ip
+	}
+	return nil
+}
+
+// ipField returns the field name used by the flows for an address.
+func ipField(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ip"
+	}
+	return "ipv6"
+}
+
+// uint returns the first unsigned field found.
+func (r *record) uint(names ...string) (uint64, bool) {
+	for _, name := range names {
+		if v, ok := r.fields[name].(uint64); ok {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// icmpTypeCode returns the ICMP type and code of the flow. NetFlow v5 and
+// many v9 exporters report them in the destination port.
+func (r *record) icmpTypeCode(dstPort uint16) (uint8, uint8) {
+	if v, ok := r.uint("icmp_type_code_ipv4", "icmp_type_code_ipv6"); ok {
+		return uint8(v >> 8), uint8(v)
+	}
+	typ, typeOK := r.uint("icmp_type_ipv4", "icmp_type_ipv6")
+	code, _ := r.uint("icmp_code_ipv4", "icmp_code_ipv6")
+	if typeOK {
+		return uint8(typ), uint8(code)
+	}
+	return uint8(dstPort >> 8), uint8(dstPort)
+}
+
+func (r *record) stats(octets, packets string) common.MapStr {
+	bytes, bytesOK := r.fields[octets].(uint64)
+	pkts, pktsOK := r.fields[packets].(uint64)
+	if !bytesOK && !pktsOK {
+		return nil
+	}
+	return common.MapStr{
+		"net_bytes_total":   bytes,
+		"net_packets_total": pkts,
+	}
+}
+
+// flowID identifies the flows of an exporter, so that the records of a flow
+// exported at each active timeout have the same ID.
+func (r *record) flowID(flow flowhash.Flow, start time.Time) string {
+	h := fnv.New64a()
+	h.Write([]byte(r.exporter))
+
+	var buf [8]byte
+	binary.BigEndian.PutUint32(buf[:], r.domain)
+	h.Write(buf[:4])
+	h.Write(flow.SourceIP)
+	h.Write(flow.DestinationIP)
+	binary.BigEndian.PutUint16(buf[:], flow.SourcePort)
+	binary.BigEndian.PutUint16(buf[2:], flow.DestinationPort)
+	buf[4], buf[5], buf[6] = flow.Protocol, flow.ICMPType, flow.ICMPCode
+	h.Write(buf[:7])
+	binary.BigEndian.PutUint64(buf[:], uint64(start.UnixNano()))
+	h.Write(buf[:])
+
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,266 @@
+package netflow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"net"
+	"time"
+	"unicode/utf8"
+)
+
+// fieldType is the abstract data type of an information element, as
+// defined in RFC 7012.
+type fieldType uint8
+
+const (
+	octetsType fieldType = iota
+	unsignedType
+	signedType
+	floatType
+	boolType
+	macType
+	stringType
+	ipv4Type
+	ipv6Type
+	secondsType
+	millisecondsType
+	ntpType
+)
+
+// type names accepted in the custom fields
+var fieldTypes = map[string]fieldType{
+	"octets":       octetsType,
+	"unsigned":     unsignedType,
+	"signed":       signedType,
+	"float":        floatType,
+	"boolean":      boolType,
+	"mac":          macType,
+	"string":       stringType,
+	"ipv4":         ipv4Type,
+	"ipv6":         ipv6Type,
+	"seconds":      secondsType,
+	"milliseconds": millisecondsType,
+}
+
+type fieldKey struct {
+	enterprise uint32
+	id         uint16
+}
+
+type fieldDef struct {
+	name string
+	typ  fieldType
+}
+
+// ianaFields are the information elements of the IANA registry used by
+// NetFlow v9 and IPFIX. The v9 field types share the same identifiers.
+// Unknown elements, like paddingOctets, are not published.
+var ianaFields = map[uint16]fieldDef{
+	1:   {"octet_delta_count", unsignedType},
+	2:   {"packet_delta_count", unsignedType},
+	3:   {"delta_flow_count", unsignedType},
+	4:   {"protocol_identifier", unsignedType},
+	5:   {"ip_class_of_service", unsignedType},
+	6:   {"tcp_control_bits", unsignedType},
+	7:   {"source_transport_port", unsignedType},
+	8:   {"source_ipv4_address", ipv4Type},
+	9:   {"source_ipv4_prefix_length", unsignedType},
+	10:  {"ingress_interface", unsignedType},
+	11:  {"destination_transport_port", unsignedType},
+	12:  {"destination_ipv4_address", ipv4Type},
+	13:  {"destination_ipv4_prefix_length", unsignedType},
+	14:  {"egress_interface", unsignedType},
+	15:  {"ip_next_hop_ipv4_address", ipv4Type},
+	16:  {"bgp_source_as_number", unsignedType},
+	17:  {"bgp_destination_as_number", unsignedType},
+	18:  {"bgp_next_hop_ipv4_address", ipv4Type},
+	19:  {"post_mcast_packet_delta_count", unsignedType},
+	20:  {"post_mcast_octet_delta_count", unsignedType},
+	21:  {"flow_end_sys_up_time", unsignedType},
+	22:  {"flow_start_sys_up_time", unsignedType},
+	23:  {"post_octet_delta_count", unsignedType},
+	24:  {"post_packet_delta_count", unsignedType},
+	25:  {"minimum_ip_total_length", unsignedType},
+	26:  {"maximum_ip_total_length", unsignedType},
+	27:  {"source_ipv6_address", ipv6Type},
+	28:  {"destination_ipv6_address", ipv6Type},
+	29:  {"source_ipv6_prefix_length", unsignedType},
+	30:  {"destination_ipv6_prefix_length", unsignedType},
+	31:  {"flow_label_ipv6", unsignedType},
+	32:  {"icmp_type_code_ipv4", unsignedType},
+	33:  {"igmp_type", unsignedType},
+	34:  {"sampling_interval", unsignedType},
+	35:  {"sampling_algorithm", unsignedType},
+	36:  {"flow_active_timeout", unsignedType},
+	37:  {"flow_idle_timeout", unsignedType},
+	38:  {"engine_type", unsignedType},
+	39:  {"engine_id", unsignedType},
+	40:  {"exported_octet_total_count", unsignedType},
+	41:  {"exported_message_total_count", unsignedType},
+	42:  {"exported_flow_record_total_count", unsignedType},
+	44:  {"source_ipv4_prefix", ipv4Type},
+	45:  {"destination_ipv4_prefix", ipv4Type},
+	46:  {"mpls_top_label_type", unsignedType},
+	47:  {"mpls_top_label_ipv4_address", ipv4Type},
+	52:  {"minimum_ttl", unsignedType},
+	53:  {"maximum_ttl", unsignedType},
+	54:  {"fragment_identification", unsignedType},
+	55:  {"post_ip_class_of_service", unsignedType},
+	56:  {"source_mac_address", macType},
+	57:  {"post_destination_mac_address", macType},
+	58:  {"vlan_id", unsignedType},
+	59:  {"post_vlan_id", unsignedType},
+	60:  {"ip_version", unsignedType},
+	61:  {"flow_direction", unsignedType},
+	62:  {"ip_next_hop_ipv6_address", ipv6Type},
+	63:  {"bgp_next_hop_ipv6_address", ipv6Type},
+	64:  {"ipv6_extension_headers", unsignedType},
+	70:  {"mpls_top_label_stack_section", octetsType},
+	80:  {"destination_mac_address", macType},
+	81:  {"post_source_mac_address", macType},
+	82:  {"interface_name", stringType},
+	83:  {"interface_description", stringType},
+	85:  {"octet_total_count", unsignedType},
+	86:  {"packet_total_count", unsignedType},
+	88:  {"fragment_offset", unsignedType},
+	89:  {"forwarding_status", unsignedType},
+	90:  {"mpls_vpn_route_distinguisher", octetsType},
+	94:  {"application_description", stringType},
+	95:  {"application_id", octetsType},
+	96:  {"application_name", stringType},
+	98:  {"post_ip_diff_serv_code_point", unsignedType},
+	130: {"exporter_ipv4_address", ipv4Type},
+	131: {"exporter_ipv6_address", ipv6Type},
+	132: {"dropped_octet_delta_count", unsignedType},
+	133: {"dropped_packet_delta_count", unsignedType},
+	136: {"flow_end_reason", unsignedType},
+	137: {"common_properties_id", unsignedType},
+	138: {"observation_point_id", unsignedType},
+	139: {"icmp_type_code_ipv6", unsignedType},
+	144: {"exporting_process_id", unsignedType},
+	145: {"template_id", unsignedType},
+	148: {"flow_id", unsignedType},
+	149: {"observation_domain_id", unsignedType},
+	150: {"flow_start_seconds", secondsType},
+	151: {"flow_end_seconds", secondsType},
+	152: {"flow_start_milliseconds", millisecondsType},
+	153: {"flow_end_milliseconds", millisecondsType},
+	154: {"flow_start_microseconds", ntpType},
+	155: {"flow_end_microseconds", ntpType},
+	156: {"flow_start_nanoseconds", ntpType},
+	157: {"flow_end_nanoseconds", ntpType},
+	160: {"system_init_time_milliseconds", millisecondsType},
+	161: {"flow_duration_milliseconds", unsignedType},
+	176: {"icmp_type_ipv4", unsignedType},
+	177: {"icmp_code_ipv4", unsignedType},
+	178: {"icmp_type_ipv6", unsignedType},
+	179: {"icmp_code_ipv6", unsignedType},
+	180: {"udp_source_port", unsignedType},
+	181: {"udp_destination_port", unsignedType},
+	182: {"tcp_source_port", unsignedType},
+	183: {"tcp_destination_port", unsignedType},
+	184: {"tcp_sequence_number", unsignedType},
+	192: {"ip_ttl", unsignedType},
+	195: {"ip_diff_serv_code_point", unsignedType},
+	196: {"ip_precedence", unsignedType},
+	197: {"fragment_flags", unsignedType},
+	205: {"udp_message_length", unsignedType},
+	206: {"is_multicast", unsignedType},
+	224: {"ip_total_length", unsignedType},
+	225: {"post_nat_source_ipv4_address", ipv4Type},
+	226: {"post_nat_destination_ipv4_address", ipv4Type},
+	227: {"post_napt_source_transport_port", unsignedType},
+	228: {"post_napt_destination_transport_port", unsignedType},
+	230: {"nat_event", unsignedType},
+	231: {"initiator_octets", unsignedType},
+	232: {"responder_octets", unsignedType},
+	233: {"firewall_event", unsignedType},
+	234: {"ingress_vrf_id", unsignedType},
+	235: {"egress_vrf_id", unsignedType},
+	236: {"vrf_name", stringType},
+	239: {"biflow_direction", unsignedType},
+	243: {"dot1q_vlan_id", unsignedType},
+	244: {"dot1q_priority", unsignedType},
+	245: {"dot1q_customer_vlan_id", unsignedType},
+	256: {"ethernet_type", unsignedType},
+	281: {"post_nat_source_ipv6_address", ipv6Type},
+	282: {"post_nat_destination_ipv6_address", ipv6Type},
+	298: {"initiator_packets", unsignedType},
+	299: {"responder_packets", unsignedType},
+	323: {"observation_time_milliseconds", millisecondsType},
+}
+
+// seconds between the NTP epoch (1900) and the Unix epoch
+const ntpEpochOffset = 2208988800
+
+// decode converts the value of an information element. Values not matching
+// the size of their type are returned as hex strings.
+func (t fieldType) decode(b []byte) interface{} {
+	switch t {
+	case unsignedType:
+		if len(b) <= 8 {
+			return decodeUint(b)
+		}
+	case signedType:
+		if len(b) > 0 && len(b) <= 8 {
+			v := int64(decodeUint(b))
+			shift := uint(64 - 8*len(b))
+			return v << shift >> shift
+		}
+	case floatType:
+		switch len(b) {
+		case 4:
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+		case 8:
+			return math.Float64frombits(binary.BigEndian.Uint64(b))
+		}
+	case boolType:
+		// true is encoded as 1 and false as 2
+		if len(b) == 1 {
+			return b[0] == 1
+		}
+	case macType:
+		if len(b) == 6 {
+			return net.HardwareAddr(append([]byte(nil), b...))
+		}
+	case stringType:
+		s := bytes.TrimRight(b, "\x00")
+		if utf8.Valid(s) {
+			return string(s)
+		}
+	case ipv4Type:
+		if len(b) == 4 {
+			return net.IP(append([]byte(nil), b...))
+		}
+	case ipv6Type:
+		if len(b) == 16 {
+			return net.IP(append([]byte(nil), b...))
+		}
+	case secondsType:
+		if len(b) <= 8 {
+			return time.Unix(int64(decodeUint(b)), 0).UTC()
+		}
+	case millisecondsType:
+		if len(b) <= 8 {
+			ms := int64(decodeUint(b))
+			return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)).UTC()
+		}
+	case ntpType:
+		if len(b) == 8 {
+			secs := int64(binary.BigEndian.Uint32(b)) - ntpEpochOffset
+			frac := uint64(binary.BigEndian.Uint32(b[4:]))
+			return time.Unix(secs, int64(frac*uint64(time.Second)>>32)).UTC()
+		}
+	}
+	return hex.EncodeToString(b)
+}
+
+func decodeUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
@@ -0,0 +1,100 @@
+package netflow
+
+import "time"
+
+// variable length fields of IPFIX templates
+const variableLength = 0xffff
+
+type templateField struct {
+	key    fieldKey
+	length uint16
+}
+
+type template struct {
+	fields []templateField
+
+	// options templates describe records about the exporter, like the
+	// sampling settings, and not flows
+	options bool
+
+	// minimum length of the records, to detect the padding at the end of
+	// the sets
+	minLength int
+
+	updated time.Time
+}
+
+func newTemplate(fields []templateField, options bool, now time.Time) *template {
+	t := &template{fields: fields, options: options, updated: now}
+	for _, f := range fields {
+		if f.length == variableLength {
+			t.minLength++
+		} else {
+			t.minLength += int(f.length)
+		}
+	}
+	return t
+}
+
+// templates are scoped by exporter and observation domain (source ID in
+// NetFlow v9)
+type templateKey struct {
+	exporter string
+	version  uint16
+	domain   uint32
+	id       uint16
+}
+
+// templateCache stores the templates received from the exporters. The
+// templates not refreshed during the timeout are removed.
+type templateCache struct {
+	timeout    time.Duration
+	templates  map[templateKey]*template
+	lastExpire time.Time
+}
+
+func newTemplateCache(timeout time.Duration) *templateCache {
+	return &templateCache{
+		timeout:   timeout,
+		templates: map[templateKey]*template{},
+	}
+}
+
+func (c *templateCache) add(key templateKey, t *template) {
+	c.templates[key] = t
+}
+
+func (c *templateCache) remove(key templateKey) {
+	delete(c.templates, key)
+}
+
+// removeAll withdraws all the templates of an observation domain.
+func (c *templateCache) removeAll(exporter string, version uint16, domain uint32) {
+	for key := range c.templates {
+		if key.exporter == exporter && key.version == version && key.domain == domain {
+			delete(c.templates, key)
+		}
+	}
+}
+
+func (c *templateCache) get(key templateKey, now time.Time) *template {
+	t := c.templates[key]
+	if t != nil && now.Sub(t.updated) > c.timeout {
+		delete(c.templates, key)
+		return nil
+	}
+	return t
+}
+
+// expire removes the expired templates, at most once per minute.
+func (c *templateCache) expire(now time.Time) {
+	if now.Sub(c.lastExpire) < time.Minute {
+		return
+	}
+	c.lastExpire = now
+	for key, t := range c.templates {
+		if now.Sub(t.updated) > c.timeout {
+			delete(c.templates, key)
+		}
+	}
+}
@@ -7,6 +7,7 @@ type ProcsConfig struct {
 	MaxProcReadFreq time.Duration `config:"max_proc_read_freq"`
 	Monitored       []ProcConfig  `config:"monitored"`
 	RefreshPidsFreq time.Duration `config:"refresh_pids_freq"`
+	MonitorAll      bool          `config:"monitor_all"`
 }
 
 type ProcConfig struct {
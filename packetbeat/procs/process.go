@@ -0,0 +1,191 @@
+package procs
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// Process holds the details of a process owning sockets, as read from
+// /proc/<pid>.
+type Process struct {
+	PID       int
+	PPID      int
+	Name      string
+	Exe       string
+	Args      []string
+	UID       int
+	User      string
+	Cgroup    string
+	Container string
+
+	// start time in clock ticks since boot, used to detect reused PIDs
+	startTime uint64
+}
+
+// container IDs are 64 hex digits, found in the cgroup path of docker,
+// containerd, CRI-O and Kubernetes containers
+var containerIDRegexp = regexp.MustCompile(`([0-9a-f]{64})(\.scope)?$`)
+
+// readProcess reads the details of a process. Only the stat file is
+// required, missing details are left empty.
+func readProcess(prefix string, pid int) (*Process, error) {
+	dir := filepath.Join(prefix, "/proc", strconv.Itoa(pid))
+
+	p := &Process{PID: pid, UID: -1}
+	if err := p.readStat(dir); err != nil {
+		return nil, err
+	}
+
+	if comm, err := ioutil.ReadFile(filepath.Join(dir, "comm")); err == nil {
+		p.Name = strings.TrimSpace(string(comm))
+	}
+	if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		p.Exe = exe
+	}
+	if cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+		cmdline = bytes.TrimRight(cmdline, "\x00")
+		if len(cmdline) > 0 {
+			p.Args = strings.Split(string(cmdline), "\x00")
+		}
+	}
+	p.readStatus(dir)
+	p.readCgroup(dir)
+
+	if p.UID >= 0 {
+		p.User = lookupUser(p.UID)
+	}
+	return p, nil
+}
+
+// readProcessStartTime reads the start time of a process, to check if a
+// cached process still owns its PID.
+func readProcessStartTime(prefix string, pid int) (uint64, error) {
+	p := Process{}
+	err := p.readStat(filepath.Join(prefix, "/proc", strconv.Itoa(pid)))
+	return p.startTime, err
+}
+
+func (p *Process) readStat(dir string) error {
+	stat, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return err
+	}
+
+	// the command name is in parentheses and can contain spaces
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return errInvalidStat
+	}
+	if start := bytes.IndexByte(stat, '('); start >= 0 && start < end {
+		p.Name = string(stat[start+1 : end])
+	}
+
+	// fields following the command name, starting with the state (3rd)
+	fields := bytes.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return errInvalidStat
+	}
+	p.PPID, _ = strconv.Atoi(string(fields[1]))
+	p.startTime, _ = strconv.ParseUint(string(fields[19]), 10, 64)
+	return nil
+}
+
+func (p *Process) readStatus(dir string) {
+	file, err := os.Open(filepath.Join(dir, "status"))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Uid:") {
+			continue
+		}
+		// real, effective, saved set and filesystem UIDs
+		fields := strings.Fields(line[4:])
+		if len(fields) > 0 {
+			if uid, err := strconv.Atoi(fields[0]); err == nil {
+				p.UID = uid
+			}
+		}
+		return
+	}
+}
+
+func (p *Process) readCgroup(dir string) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "cgroup"))
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 || parts[2] == "" {
+			continue
+		}
+		if p.Cgroup == "" || parts[0] == "0" {
+			// prefer the unified hierarchy
+			p.Cgroup = parts[2]
+		}
+		if p.Container == "" {
+			if m := containerIDRegexp.FindStringSubmatch(parts[2]); m != nil {
+				p.Container = m[1]
+			}
+		}
+	}
+}
+
+// ToMapStr returns the process details as published in the events.
+func (p *Process) ToMapStr() common.MapStr {
+	event := common.MapStr{
+		"pid":  p.PID,
+		"ppid": p.PPID,
+		"name": p.Name,
+	}
+	if p.Exe != "" {
+		event["exe"] = p.Exe
+	}
+	if len(p.Args) > 0 {
+		event["cmdline"] = strings.Join(p.Args, " ")
+	}
+	if p.UID >= 0 {
+		u := common.MapStr{"id": p.UID}
+		if p.User != "" {
+			u["name"] = p.User
+		}
+		event["user"] = u
+	}
+	if p.Cgroup != "" {
+		event["cgroup"] = p.Cgroup
+	}
+	if p.Container != "" {
+		event["container"] = common.MapStr{"id": p.Container}
+	}
+	return event
+}
+
+// user names are cached, the lookup can require reading /etc/passwd
+var userNames = map[int]string{}
+
+func lookupUser(uid int) string {
+	name, found := userNames[uid]
+	if !found {
+		if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+			name = u.Username
+		}
+		userNames[uid] = name
+	}
+	return name
+}
@@ -13,6 +13,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/elastic/beats/libbeat/common"
@@ -51,9 +52,18 @@ type ProcessesWatcher struct {
 
 	// config
 	readFromProc    bool
+	monitorAll      bool
 	maxReadFreq     time.Duration
 	refreshPidsFreq time.Duration
 
+	// owners of all the local sockets, if monitorAll is set
+	mutex          sync.Mutex
+	sockets        map[socketKey]*socketOwner
+	inodeOwners    map[uint64]*Process
+	unowned        map[uint64]bool
+	procCache      map[int]*Process
+	lastSocketScan time.Time
+
 	// test helpers
 	procPrefix  string
 	testSignals *chan bool
@@ -61,6 +71,8 @@ type ProcessesWatcher struct {
 
 var ProcWatcher ProcessesWatcher
 
+var errInvalidStat = errors.New("invalid stat file")
+
 func (proc *ProcessesWatcher) Init(config ProcsConfig) error {
 
 	proc.procPrefix = ""
@@ -79,6 +91,11 @@ func (proc *ProcessesWatcher) Init(config ProcsConfig) error {
 		logp.Info("Process matching disabled")
 	}
 
+	if proc.readFromProc && config.MonitorAll {
+		logp.Info("Monitoring the processes of all local sockets")
+		proc.initMonitorAll()
+	}
+
 	if config.MaxProcReadFreq == 0 {
 		proc.maxReadFreq = 10 * time.Millisecond
 	} else {
@@ -140,7 +157,7 @@ func (p *process) refreshPids() {
 		if err != nil {
 			logp.Err("Error finding PID files for %s: %s", p.name, err)
 		}
-		logp.Debug("procs", "RefreshPids found pids %s for process %s", p.pids, p.name)
+		logp.Debug("procs", "RefreshPids found pids %v for process %s", p.pids, p.name)
 
 		if p.proc.testSignals != nil {
 			*p.proc.testSignals <- true
@@ -182,7 +199,10 @@ func findPidsByCmdlineGrep(prefix string, process string) ([]int, error) {
 	return pids, nil
 }
 
-func (proc *ProcessesWatcher) FindProcessesTuple(tuple *common.IPPortTuple) (procTuple *common.CmdlineTuple) {
+func (proc *ProcessesWatcher) FindProcessesTuple(
+	tuple *common.IPPortTuple,
+	transport Transport,
+) (procTuple *common.CmdlineTuple) {
 	procTuple = &common.CmdlineTuple{}
 
 	if !proc.readFromProc {
@@ -191,7 +211,7 @@ func (proc *ProcessesWatcher) FindProcessesTuple(tuple *common.IPPortTuple) (pro
 
 	if proc.isLocalIP(tuple.SrcIP) {
 		logp.Debug("procs", "Looking for port %d", tuple.SrcPort)
-		procTuple.Src = []byte(proc.findProc(tuple.SrcPort))
+		procTuple.Src = []byte(proc.findProc(transport, tuple.SrcIP, tuple.SrcPort))
 		if len(procTuple.Src) > 0 {
 			logp.Debug("procs", "Found device %s for port %d", procTuple.Src, tuple.SrcPort)
 		}
@@ -199,7 +219,7 @@ func (proc *ProcessesWatcher) FindProcessesTuple(tuple *common.IPPortTuple) (pro
 
 	if proc.isLocalIP(tuple.DstIP) {
 		logp.Debug("procs", "Looking for port %d", tuple.DstPort)
-		procTuple.Dst = []byte(proc.findProc(tuple.DstPort))
+		procTuple.Dst = []byte(proc.findProc(transport, tuple.DstIP, tuple.DstPort))
 		if len(procTuple.Dst) > 0 {
 			logp.Debug("procs", "Found device %s for port %d", procTuple.Dst, tuple.DstPort)
 		}
@@ -208,7 +228,7 @@ func (proc *ProcessesWatcher) FindProcessesTuple(tuple *common.IPPortTuple) (pro
 	return
 }
 
-func (proc *ProcessesWatcher) findProc(port uint16) (procname string) {
+func (proc *ProcessesWatcher) findProc(transport Transport, ip net.IP, port uint16) (procname string) {
 	procname = ""
 	defer logp.Recover("FindProc exception")
 
@@ -230,6 +250,12 @@ func (proc *ProcessesWatcher) findProc(port uint16) (procname string) {
 		}
 	}
 
+	if proc.monitorAll {
+		if p := proc.findSocketOwner(transport, ip, port); p != nil {
+			return p.Name
+		}
+	}
+
 	return ""
 }
 
@@ -285,12 +311,12 @@ func hexToIPPort(str []byte, ipv6 bool) (net.IP, uint16, error) {
 func (proc *ProcessesWatcher) updateMap() {
 
 	logp.Debug("procs", "UpdateMap()")
-	ipv4socks, err := socketsFromProc("/proc/net/tcp", false)
+	ipv4socks, err := socketsFromProc(filepath.Join(proc.procPrefix, "/proc/net/tcp"), false)
 	if err != nil {
 		logp.Err("Parse_Proc_Net_Tcp: %s", err)
 		return
 	}
-	ipv6socks, err := socketsFromProc("/proc/net/tcp6", true)
+	ipv6socks, err := socketsFromProc(filepath.Join(proc.procPrefix, "/proc/net/tcp6"), true)
 	if err != nil {
 		logp.Err("Parse_Proc_Net_Tcp ipv6: %s", err)
 		return
@@ -324,15 +350,16 @@ func (proc *ProcessesWatcher) updateMap() {
 }
 
 func socketsFromProc(filename string, ipv6 bool) ([]*socketInfo, error) {
-	file, err := os.Open("/proc/net/tcp")
+	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
-	return parseProcNetTCP(file, false)
+	return parseProcNetTCP(file, ipv6)
 }
 
-// Parses the /proc/net/tcp file
+// Parses the /proc/net/tcp file. The UDP sockets listed in /proc/net/udp use
+// the same format.
 func parseProcNetTCP(input io.Reader, ipv6 bool) ([]*socketInfo, error) {
 	buf := bufio.NewReader(input)
 
@@ -385,7 +412,7 @@ func (proc *ProcessesWatcher) updateMappingEntry(port uint16, pid int, p *proces
 	// reasonable.
 	proc.portProcMap[port] = entry
 
-	logp.Debug("procsdetailed", "UpdateMappingEntry(): port=%d pid=%d", port, p.name)
+	logp.Debug("procsdetailed", "UpdateMappingEntry(): port=%d pid=%d process=%s", port, pid, p.name)
 }
 
 func findSocketsOfPid(prefix string, pid int) (inodes []uint64, err error) {
@@ -0,0 +1,296 @@
+package procs
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// Closed sockets stay attributed to their process for this duration, so that
+// the transactions and flows published after the end of a connection still
+// report the process.
+const socketExpiration = 1 * time.Minute
+
+// Transport is the transport protocol of a socket.
+type Transport uint8
+
+// Transport protocols of the sockets.
+const (
+	TransportTCP Transport = iota
+	TransportUDP
+)
+
+// socket tables read when all the processes are monitored
+var procNetFiles = []struct {
+	path      string
+	transport Transport
+	ipv6      bool
+}{
+	{"/proc/net/tcp", TransportTCP, false},
+	{"/proc/net/tcp6", TransportTCP, true},
+	{"/proc/net/udp", TransportUDP, false},
+	{"/proc/net/udp6", TransportUDP, true},
+}
+
+// socketKey identifies a local socket. The IPv4 addresses are stored as
+// IPv4-mapped IPv6 addresses, as listed for the dual-stack sockets.
+type socketKey struct {
+	transport Transport
+	ip        [net.IPv6len]byte
+	port      uint16
+}
+
+type socketOwner struct {
+	proc     *Process
+	inode    uint64
+	lastSeen time.Time
+}
+
+// wildcard addresses of the sockets bound to all the local addresses
+var (
+	anyIPv4 = net.IPv4zero.To16()
+	anyIPv6 = net.IPv6unspecified
+)
+
+func newSocketKey(transport Transport, ip net.IP, port uint16) socketKey {
+	key := socketKey{transport: transport, port: port}
+	copy(key.ip[:], ip.To16())
+	return key
+}
+
+// FindProcess returns the process owning the local socket bound to the
+// given transport, address and port, or nil. Processes are only looked up
+// if `monitor_all` is enabled. It is safe for concurrent use.
+func (proc *ProcessesWatcher) FindProcess(transport Transport, ip net.IP, port uint16) *Process {
+	if !proc.readFromProc || !proc.monitorAll || ip == nil {
+		return nil
+	}
+	if !proc.isLocalIP(ip) {
+		return nil
+	}
+	return proc.findSocketOwner(transport, ip, port)
+}
+
+func (proc *ProcessesWatcher) initMonitorAll() {
+	proc.monitorAll = true
+	proc.sockets = map[socketKey]*socketOwner{}
+	proc.inodeOwners = map[uint64]*Process{}
+	proc.unowned = map[uint64]bool{}
+	proc.procCache = map[int]*Process{}
+}
+
+// findSocketOwner returns the owner of a socket. The owners seen in the
+// socket tables less than maxReadFreq ago are trusted, the others are
+// checked by scanning the socket tables again, in case the port has been
+// reused by another socket.
+func (proc *ProcessesWatcher) findSocketOwner(transport Transport, ip net.IP, port uint16) *Process {
+	proc.mutex.Lock()
+	defer proc.mutex.Unlock()
+
+	now := time.Now()
+	owner := proc.lookupSocket(transport, ip, port)
+	if owner != nil && now.Sub(owner.lastSeen) <= proc.maxReadFreq {
+		return owner.proc
+	}
+
+	if now.Sub(proc.lastSocketScan) > proc.maxReadFreq {
+		proc.lastSocketScan = now
+		proc.scanSockets(now)
+		owner = proc.lookupSocket(transport, ip, port)
+	}
+	if owner != nil {
+		return owner.proc
+	}
+	return nil
+}
+
+// lookupSocket returns the owner of the socket bound to the address, or to
+// all the local addresses.
+func (proc *ProcessesWatcher) lookupSocket(transport Transport, ip net.IP, port uint16) *socketOwner {
+	for _, addr := range []net.IP{ip, anyIPv4, anyIPv6} {
+		if owner, found := proc.sockets[newSocketKey(transport, addr, port)]; found {
+			return owner
+		}
+	}
+	return nil
+}
+
+// scanSockets reads the socket tables and looks for the owners of the
+// sockets created since the last scan. The owners of known sockets are not
+// looked up again.
+func (proc *ProcessesWatcher) scanSockets(now time.Time) {
+	var sockets []*socketInfo
+	var keys []socketKey
+	for _, f := range procNetFiles {
+		socks, err := socketsFromProc(filepath.Join(proc.procPrefix, f.path), f.ipv6)
+		if err != nil {
+			// IPv6 might be disabled
+			logp.Debug("procs", "Reading %s: %s", f.path, err)
+			continue
+		}
+		sockets = append(sockets, socks...)
+		for _, s := range socks {
+			keys = append(keys, newSocketKey(f.transport, s.srcIP, s.srcPort))
+		}
+	}
+
+	current := make(map[uint64]bool, len(sockets))
+	pending := map[uint64]bool{}
+	for _, s := range sockets {
+		// sockets in TIME_WAIT state have no inode
+		if s.inode == 0 {
+			continue
+		}
+		current[s.inode] = true
+		if _, found := proc.inodeOwners[s.inode]; !found && !proc.unowned[s.inode] {
+			pending[s.inode] = true
+		}
+	}
+
+	if len(pending) > 0 {
+		proc.findSocketOwners(pending)
+
+		// don't look again for sockets without owner, like the sockets of
+		// the processes we are not allowed to inspect
+		for inode := range pending {
+			proc.unowned[inode] = true
+		}
+	}
+
+	for i, s := range sockets {
+		key := keys[i]
+		if p := proc.inodeOwners[s.inode]; p != nil {
+			proc.sockets[key] = &socketOwner{proc: p, inode: s.inode, lastSeen: now}
+		} else if owner, found := proc.sockets[key]; found && s.inode != 0 && owner.inode != s.inode {
+			// the port has been reused by a socket of an unknown owner
+			delete(proc.sockets, key)
+		}
+	}
+
+	proc.expireSockets(now, current)
+}
+
+// findSocketOwners scans the file descriptors of the processes until the
+// owners of all pending sockets are found.
+func (proc *ProcessesWatcher) findSocketOwners(pending map[uint64]bool) {
+	pids, err := listPids(proc.procPrefix)
+	if err != nil {
+		logp.Err("Listing processes: %s", err)
+		return
+	}
+
+	// processes already owning sockets are scanned first, then the most
+	// recent processes
+	sort.Slice(pids, func(i, j int) bool {
+		_, iKnown := proc.procCache[pids[i]]
+		_, jKnown := proc.procCache[pids[j]]
+		if iKnown != jKnown {
+			return iKnown
+		}
+		return pids[i] > pids[j]
+	})
+
+	for _, pid := range pids {
+		inodes, err := findSocketsOfPid(proc.procPrefix, pid)
+		if err != nil {
+			logp.Debug("procsdetailed", "FindSocketsOfPid: %s", err)
+			continue
+		}
+
+		var owner *Process
+		for _, inode := range inodes {
+			if !pending[inode] {
+				continue
+			}
+			if owner == nil {
+				if owner = proc.cachedProcess(pid); owner == nil {
+					break
+				}
+			}
+			proc.inodeOwners[inode] = owner
+			delete(pending, inode)
+		}
+
+		if len(pending) == 0 {
+			return
+		}
+	}
+}
+
+// cachedProcess returns the details of a process, read again if the PID was
+// reused.
+func (proc *ProcessesWatcher) cachedProcess(pid int) *Process {
+	if p, found := proc.procCache[pid]; found {
+		startTime, err := readProcessStartTime(proc.procPrefix, pid)
+		if err == nil && startTime == p.startTime {
+			return p
+		}
+	}
+
+	p, err := readProcess(proc.procPrefix, pid)
+	if err != nil {
+		logp.Debug("procs", "Reading process %d: %s", pid, err)
+		delete(proc.procCache, pid)
+		return nil
+	}
+	logp.Debug("procsdetailed", "Found process %d: %s", pid, p.Name)
+	proc.procCache[pid] = p
+	return p
+}
+
+// expireSockets forgets the closed sockets and the processes not owning
+// sockets anymore.
+func (proc *ProcessesWatcher) expireSockets(now time.Time, current map[uint64]bool) {
+	for inode := range proc.inodeOwners {
+		if !current[inode] {
+			delete(proc.inodeOwners, inode)
+		}
+	}
+	for inode := range proc.unowned {
+		if !current[inode] {
+			delete(proc.unowned, inode)
+		}
+	}
+	for key, owner := range proc.sockets {
+		if now.Sub(owner.lastSeen) > socketExpiration {
+			delete(proc.sockets, key)
+		}
+	}
+
+	owning := map[int]bool{}
+	for _, p := range proc.inodeOwners {
+		owning[p.PID] = true
+	}
+	for pid := range proc.procCache {
+		if !owning[pid] {
+			delete(proc.procCache, pid)
+		}
+	}
+}
+
+func listPids(prefix string) ([]int, error) {
+	dir, err := os.Open(filepath.Join(prefix, "/proc"))
+	if err != nil {
+		return nil, fmt.Errorf("Open /proc: %s", err)
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(0)
+	if err != nil {
+		return nil, fmt.Errorf("Readdirnames: %s", err)
+	}
+
+	pids := make([]int, 0, len(names))
+	for _, name := range names {
+		if pid, err := strconv.Atoi(name); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
@@ -0,0 +1,295 @@
+// +build !integration
+
+package procs
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	procNetHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+	containerID = "3f4b1a5b2c7e8d9f0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f6071"
+)
+
+// procStat returns the content of /proc/<pid>/stat with the given parent
+// and start time.
+func procStat(pid, name, ppid, startTime string) string {
+	fields := append([]string{pid, "(" + name + ")", "S", ppid}, strings.Fields(strings.Repeat("0 ", 17))...)
+	return strings.Join(append(fields, startTime, "0", "0"), " ") + "\n"
+}
+
+func fakeProcess(pid, name, cmdline, uid, cgroup string, sockets ...string) []testProcFile {
+	dir := "/proc/" + pid
+	files := []testProcFile{
+		{path: dir + "/stat", contents: procStat(pid, name, "1", "100")},
+		{path: dir + "/comm", contents: name + "\n"},
+		{path: dir + "/cmdline", contents: cmdline},
+		{path: dir + "/status", contents: "Name:\t" + name + "\nUid:\t" + uid + "\t" + uid + "\t" + uid + "\t" + uid + "\n"},
+		{path: dir + "/cgroup", contents: cgroup},
+		{path: dir + "/exe", isLink: true, contents: "/usr/sbin/" + name},
+		{path: dir + "/fd/0", isLink: true, contents: "/dev/null"},
+	}
+	for i, inode := range sockets {
+		files = append(files, testProcFile{
+			path:     dir + "/fd/" + strconv.Itoa(3+i),
+			isLink:   true,
+			contents: "socket:[" + inode + "]",
+		})
+	}
+	return files
+}
+
+func newTestWatcher(t *testing.T, files []testProcFile) (*ProcessesWatcher, string) {
+	prefix, err := ioutil.TempDir("", "monitor-all")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	if err := createFakeDirectoryStructure(prefix, files); err != nil {
+		os.RemoveAll(prefix)
+		t.Fatal("CreateFakeDirectoryStructure failed:", err)
+	}
+
+	proc := &ProcessesWatcher{
+		readFromProc: true,
+		procPrefix:   prefix,
+		portProcMap:  map[uint16]portProcMapping{},
+	}
+	proc.initMonitorAll()
+	return proc, prefix
+}
+
+// localKey returns the key of a local TCP socket bound to 127.0.0.1.
+func localKey(port uint16) socketKey {
+	return newSocketKey(TransportTCP, net.ParseIP("127.0.0.1"), port)
+}
+
+func TestMonitorAll(t *testing.T) {
+	files := []testProcFile{
+		{path: "/proc/net/tcp", contents: procNetHeader +
+			"   0: 00000000:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 7001 1 0000000000000000 100 0 0 10 0\n" +
+			"   1: 0100007F:0CEA 0100007F:D431 01 00000000:00000000 00:00000000 00000000   999        0 7002 1 0000000000000000 20 4 30 10 -1\n" +
+			"   2: 0100007F:D431 0100007F:0CEA 01 00000000:00000000 00:00000000 00000000  1000        0 7003 1 0000000000000000 20 4 30 10 -1\n" +
+			"   3: 0100007F:D432 0100007F:0CEA 06 00000000:00000000 03:00000ED8 00000000     0        0 0 3 0000000000000000\n"},
+		{path: "/proc/net/tcp6", contents: procNetHeader},
+		{path: "/proc/net/udp", contents: procNetHeader +
+			"  10: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 7004 2 0000000000000000 0\n"},
+		{path: "/proc/net/udp6", contents: procNetHeader +
+			"  11: 00000000000000000000000001000000:1F90 00000000000000000000000000000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 7005 2 0000000000000000 0\n"},
+	}
+	files = append(files, fakeProcess("1200", "mysqld", "/usr/sbin/mysqld\x00--user=mysql\x00", "999",
+		"12:cpu,cpuacct:/docker/"+containerID+"\n0::/docker/"+containerID+"\n", "7001", "7002")...)
+	files = append(files, fakeProcess("1300", "app", "/usr/bin/app\x00", "1000", "0::/user.slice\n", "7003")...)
+	files = append(files, fakeProcess("1400", "dnsmasq", "dnsmasq\x00", "101", "", "7004", "7005")...)
+
+	proc, prefix := newTestWatcher(t, files)
+	defer os.RemoveAll(prefix)
+
+	localhost := net.ParseIP("127.0.0.1")
+
+	mysqld := proc.FindProcess(TransportTCP, localhost, 3306)
+	if assert.NotNil(t, mysqld) {
+		event := mysqld.ToMapStr()
+		assert.Equal(t, 999, event["user"].(common.MapStr)["id"])
+		delete(event, "user")
+		assert.Equal(t, common.MapStr{
+			"pid":       1200,
+			"ppid":      1,
+			"name":      "mysqld",
+			"exe":       "/usr/sbin/mysqld",
+			"cmdline":   "/usr/sbin/mysqld --user=mysql",
+			"cgroup":    "/docker/" + containerID,
+			"container": common.MapStr{"id": containerID},
+		}, event)
+	}
+
+	app := proc.FindProcess(TransportTCP, localhost, 54321)
+	if assert.NotNil(t, app) {
+		assert.Equal(t, "app", app.Name)
+		assert.Equal(t, "/user.slice", app.Cgroup)
+		assert.Equal(t, "", app.Container)
+	}
+
+	// UDP over IPv4 and IPv6
+	assert.Equal(t, 1400, proc.FindProcess(TransportUDP, localhost, 53).PID)
+	assert.Equal(t, 1400, proc.FindProcess(TransportUDP, net.ParseIP("::1"), 8080).PID)
+
+	// TIME_WAIT socket and remote addresses
+	assert.Nil(t, proc.FindProcess(TransportTCP, localhost, 54322))
+	assert.Nil(t, proc.FindProcess(TransportTCP, net.ParseIP("8.8.8.8"), 3306))
+
+	// process names of the transactions
+	procTuple := proc.FindProcessesTuple(&common.IPPortTuple{
+		IPLength: 4,
+		SrcIP:    localhost, SrcPort: 54321,
+		DstIP: localhost, DstPort: 3306,
+	}, TransportTCP)
+	assert.Equal(t, "app", string(procTuple.Src))
+	assert.Equal(t, "mysqld", string(procTuple.Dst))
+}
+
+func TestMonitorAll_incremental(t *testing.T) {
+	files := []testProcFile{
+		{path: "/proc/net/tcp", contents: procNetHeader +
+			"   0: 0100007F:0050 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 8001 1 0000000000000000 100 0 0 10 0\n"},
+	}
+	files = append(files, fakeProcess("10", "nginx", "nginx\x00", "0", "", "8001")...)
+	files = append(files, fakeProcess("20", "other", "other\x00", "0", "")...)
+
+	proc, prefix := newTestWatcher(t, files)
+	defer os.RemoveAll(prefix)
+
+	now := time.Now()
+	proc.scanSockets(now)
+	assert.Equal(t, 10, proc.sockets[localKey(80)].proc.PID)
+
+	// a new socket owned by an unknown process
+	err := createFakeDirectoryStructure(prefix, []testProcFile{
+		{path: "/proc/20/fd/3", isLink: true, contents: "socket:[8002]"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(prefix, "/proc/net/tcp"), []byte(procNetHeader+
+		"   0: 0100007F:0050 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 8001 1 0000000000000000 100 0 0 10 0\n"+
+		"   1: 0100007F:0051 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 8002 1 0000000000000000 100 0 0 10 0\n"+
+		"   2: 0100007F:0052 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 8003 1 0000000000000000 100 0 0 10 0\n"),
+		0644)
+
+	nginx := proc.procCache[10]
+	proc.scanSockets(now.Add(time.Second))
+	assert.Equal(t, 20, proc.sockets[localKey(81)].proc.PID)
+	assert.True(t, nginx == proc.sockets[localKey(80)].proc, "known process is read again")
+
+	// the owner of socket 8003 is unknown, it's not looked up again
+	assert.Nil(t, proc.sockets[localKey(82)])
+	assert.True(t, proc.unowned[8003])
+
+	// the PID of nginx is reused by another process
+	ioutil.WriteFile(filepath.Join(prefix, "/proc/10/stat"), []byte(procStat("10", "nginx", "1", "200")), 0644)
+	ioutil.WriteFile(filepath.Join(prefix, "/proc/net/tcp"), []byte(procNetHeader+
+		"   0: 0100007F:0050 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 8004 1 0000000000000000 100 0 0 10 0\n"),
+		0644)
+	createFakeDirectoryStructure(prefix, []testProcFile{
+		{path: "/proc/10/fd/4", isLink: true, contents: "socket:[8004]"},
+	})
+
+	proc.scanSockets(now.Add(2 * time.Second))
+	assert.False(t, nginx == proc.sockets[localKey(80)].proc, "reused PID not detected")
+	assert.Equal(t, uint64(200), proc.sockets[localKey(80)].proc.startTime)
+
+	// closed sockets are forgotten after a while
+	assert.NotNil(t, proc.sockets[localKey(81)])
+	assert.Empty(t, proc.unowned)
+	proc.scanSockets(now.Add(2*time.Second + socketExpiration + time.Second))
+	assert.Nil(t, proc.sockets[localKey(81)])
+	assert.NotNil(t, proc.sockets[localKey(80)])
+	assert.Len(t, proc.procCache, 1)
+}
+
+func TestMonitorAll_sharedPorts(t *testing.T) {
+	files := []testProcFile{
+		{path: "/proc/net/tcp", contents: procNetHeader +
+			"   0: 00000000:0035 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 9001 1 0000000000000000 100 0 0 10 0\n" +
+			"   1: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 9004 1 0000000000000000 100 0 0 10 0\n"},
+		{path: "/proc/net/tcp6", contents: procNetHeader +
+			"   0: 00000000000000000000000001000000:1F90 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 9003 1 0000000000000000 100 0 0 10 0\n"},
+		{path: "/proc/net/udp", contents: procNetHeader +
+			"   0: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 9002 2 0000000000000000 0\n"},
+	}
+	files = append(files, fakeProcess("30", "tcpdns", "tcpdns\x00", "0", "", "9001")...)
+	files = append(files, fakeProcess("40", "udpdns", "udpdns\x00", "0", "", "9002")...)
+	files = append(files, fakeProcess("50", "app6", "app6\x00", "0", "", "9003")...)
+	files = append(files, fakeProcess("60", "app4", "app4\x00", "0", "", "9004")...)
+	files = append(files, fakeProcess("70", "other", "other\x00", "0", "")...)
+
+	proc, prefix := newTestWatcher(t, files)
+	defer os.RemoveAll(prefix)
+
+	localhost := net.ParseIP("127.0.0.1")
+	assert.Equal(t, 30, proc.FindProcess(TransportTCP, localhost, 53).PID)
+	assert.Equal(t, 40, proc.FindProcess(TransportUDP, localhost, 53).PID)
+	assert.Equal(t, 50, proc.FindProcess(TransportTCP, net.ParseIP("::1"), 8080).PID)
+	assert.Equal(t, 60, proc.FindProcess(TransportTCP, localhost, 8080).PID)
+
+	// the port is reused by another process
+	createFakeDirectoryStructure(prefix, []testProcFile{
+		{path: "/proc/70/fd/3", isLink: true, contents: "socket:[9005]"},
+	})
+	ioutil.WriteFile(filepath.Join(prefix, "/proc/net/tcp"), []byte(procNetHeader+
+		"   1: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 9005 1 0000000000000000 100 0 0 10 0\n"),
+		0644)
+	assert.Equal(t, 70, proc.FindProcess(TransportTCP, localhost, 8080).PID)
+}
+
+func TestFindProcess_disabled(t *testing.T) {
+	proc := ProcessesWatcher{readFromProc: true}
+	assert.Nil(t, proc.FindProcess(TransportTCP, net.ParseIP("127.0.0.1"), 80))
+}
+
+func TestReadProcess_cgroup(t *testing.T) {
+	tests := []struct {
+		cgroup, path, container string
+	}{
+		{
+			"11:memory:/kubepods/burstable/pod1234/" + containerID + "\n",
+			"/kubepods/burstable/pod1234/" + containerID,
+			containerID,
+		},
+		{
+			"0::/system.slice/docker-" + containerID + ".scope\n",
+			"/system.slice/docker-" + containerID + ".scope",
+			containerID,
+		},
+		{
+			"1:name=systemd:/init.scope\n0::/init.scope\n",
+			"/init.scope",
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		var p Process
+		dir, err := ioutil.TempDir("", "cgroup")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(filepath.Join(dir, "cgroup"), []byte(test.cgroup), 0644)
+		p.readCgroup(dir)
+		os.RemoveAll(dir)
+
+		assert.Equal(t, test.path, p.Cgroup)
+		assert.Equal(t, test.container, p.Container)
+	}
+}
+
+func TestReadProcess_stat(t *testing.T) {
+	var p Process
+	dir, err := ioutil.TempDir("", "stat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// command names can contain spaces and parentheses
+	ioutil.WriteFile(filepath.Join(dir, "stat"), []byte(procStat("42", "my (app)", "7", "12345")), 0644)
+	if assert.NoError(t, p.readStat(dir)) {
+		assert.Equal(t, "my (app)", p.Name)
+		assert.Equal(t, 7, p.PPID)
+		assert.Equal(t, uint64(12345), p.startTime)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "stat"), []byte("42 (app) S 1"), 0644)
+	assert.Equal(t, errInvalidStat, p.readStat(dir))
+}
@@ -455,6 +455,7 @@ func (amqp *amqpPlugin) publishTransaction(t *amqpTransaction) {
 	event["@timestamp"] = common.Time(t.ts)
 	event["src"] = &t.src
 	event["dst"] = &t.dst
+	protos.AddTunnel(event, t.tuple.Tunnel)
 
 	//let's try to convert request/response to a readable format
 	if amqp.sendRequest {
@@ -8,6 +8,7 @@ import (
 
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/common/streambuf"
+	"github.com/elastic/beats/packetbeat/protos"
 )
 
 // A Message its direction indicator
@@ -217,6 +218,7 @@ func (t *Transaction) Event(event common.MapStr) error {
 	event["src"] = &t.Src
 	event["dst"] = &t.Dst
 	event["transport"] = t.Transport.String()
+	protos.AddTunnel(event, t.Tuple.Tunnel)
 	event["bytes_out"] = t.BytesOut
 	event["bytes_in"] = t.BytesIn
 	event["status"] = t.Status
@@ -2,6 +2,7 @@ package cassandra
 
 import (
 	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/packetbeat/protos"
 	"github.com/elastic/beats/packetbeat/publish"
 )
 
@@ -86,6 +87,7 @@ func (pub *transPub) createEvent(requ, resp *message) common.MapStr {
 			Proc: string(requ.CmdlineTuple.Dst),
 		}
 		event["dst"] = dst
+		protos.AddTunnel(event, requ.Tuple.Tunnel)
 
 	} else {
 		//dealing with PUSH message
@@ -98,6 +100,7 @@ func (pub *transPub) createEvent(requ, resp *message) common.MapStr {
 			Proc: string(resp.CmdlineTuple.Dst),
 		}
 		event["dst"] = dst
+		protos.AddTunnel(event, resp.Tuple.Tunnel)
 	}
 
 	event["bytes_out"] = resp.Size
@@ -42,7 +42,7 @@ func (trans *transactions) onMessage(
 	var err error
 	msg.Tuple = *tuple
 	msg.Transport = applayer.TransportTCP
-	msg.CmdlineTuple = procs.ProcWatcher.FindProcessesTuple(&msg.Tuple)
+	msg.CmdlineTuple = procs.ProcWatcher.FindProcessesTuple(&msg.Tuple, procs.TransportTCP)
 
 	if msg.IsRequest {
 		if isDebug {
@@ -0,0 +1,18 @@
+package dhcpv4
+
+import (
+	"github.com/elastic/beats/packetbeat/config"
+	"github.com/elastic/beats/packetbeat/protos"
+)
+
+type dhcpv4Config struct {
+	config.ProtocolCommon `config:",inline"`
+}
+
+var (
+	defaultConfig = dhcpv4Config{
+		ProtocolCommon: config.ProtocolCommon{
+			TransactionTimeout: protos.DefaultTransactionExpiration,
+		},
+	}
+)
@@ -0,0 +1,205 @@
+package dhcpv4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// BOOTP operation codes
+const (
+	opBootRequest = 1
+	opBootReply   = 2
+)
+
+const (
+	// size of the fixed part of a message, including the magic cookie
+	minMessageLen = 240
+
+	hardwareTypeEthernet = 1
+
+	flagBroadcast = 0x8000
+)
+
+var magicCookie = []byte{99, 130, 83, 99}
+
+var (
+	errTooShort         = errors.New("message too short")
+	errInvalidOpCode    = errors.New("invalid op code")
+	errNoMagicCookie    = errors.New("no DHCP magic cookie, BOOTP message")
+	errTruncatedOption  = errors.New("option exceeds message")
+	errNoMessageType    = errors.New("no DHCP message type option")
+	errInvalidHWAddrLen = errors.New("invalid hardware address length")
+)
+
+// message is a decoded DHCPv4 message (RFC 2131).
+type message struct {
+	op           uint8
+	hardwareType uint8
+	hops         uint8
+	xid          uint32
+	secs         uint16
+	flags        uint16
+	clientIP     net.IP // ciaddr
+	yourIP       net.IP // yiaddr
+	serverIP     net.IP // siaddr
+	relayIP      net.IP // giaddr
+	clientHWAddr net.HardwareAddr
+	serverName   string
+	bootFile     string
+
+	messageType messageType
+	options     []option
+}
+
+type option struct {
+	code uint8
+	data []byte
+}
+
+// decodeMessage decodes a DHCPv4 message. The options reference the
+// given buffer.
+func decodeMessage(data []byte) (*message, error) {
+	if len(data) < minMessageLen {
+		return nil, errTooShort
+	}
+
+	m := &message{
+		op:           data[0],
+		hardwareType: data[1],
+		hops:         data[3],
+		xid:          binary.BigEndian.Uint32(data[4:8]),
+		secs:         binary.BigEndian.Uint16(data[8:10]),
+		flags:        binary.BigEndian.Uint16(data[10:12]),
+		clientIP:     net.IP(data[12:16]),
+		yourIP:       net.IP(data[16:20]),
+		serverIP:     net.IP(data[20:24]),
+		relayIP:      net.IP(data[24:28]),
+	}
+	if m.op != opBootRequest && m.op != opBootReply {
+		return nil, errInvalidOpCode
+	}
+
+	hlen := int(data[2])
+	if hlen > 16 {
+		return nil, errInvalidHWAddrLen
+	}
+	m.clientHWAddr = net.HardwareAddr(data[28 : 28+hlen])
+
+	sname := data[44:108]
+	file := data[108:236]
+	if !bytes.Equal(data[236:240], magicCookie) {
+		return nil, errNoMagicCookie
+	}
+
+	overload, err := m.decodeOptions(data[240:])
+	if err != nil {
+		return nil, err
+	}
+
+	// the file and sname fields can carry options instead (RFC 2132, 9.3)
+	if overload&1 != 0 {
+		if _, err := m.decodeOptions(file); err != nil {
+			return nil, err
+		}
+	} else {
+		m.bootFile = cString(file)
+	}
+	if overload&2 != 0 {
+		if _, err := m.decodeOptions(sname); err != nil {
+			return nil, err
+		}
+	} else {
+		m.serverName = cString(sname)
+	}
+
+	if m.messageType == 0 {
+		return nil, errNoMessageType
+	}
+	return m, nil
+}
+
+// decodeOptions decodes the options in data and returns the value of the
+// option overload option.
+func (m *message) decodeOptions(data []byte) (overload uint8, err error) {
+	for len(data) > 0 {
+		code := data[0]
+		if code == optPad {
+			data = data[1:]
+			continue
+		}
+		if code == optEnd {
+			break
+		}
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			return 0, errTruncatedOption
+		}
+
+		value := data[2 : 2+int(data[1])]
+		data = data[2+len(value):]
+
+		switch code {
+		case optMessageType:
+			if len(value) == 1 {
+				m.messageType = messageType(value[0])
+			}
+			continue
+		case optOverload:
+			if len(value) == 1 {
+				overload = value[0]
+			}
+			continue
+		}
+
+		// long options can be split into several instances (RFC 3396)
+		if i := m.findOption(code); i >= 0 {
+			joined := append([]byte(nil), m.options[i].data...)
+			m.options[i].data = append(joined, value...)
+			continue
+		}
+		m.options = append(m.options, option{code: code, data: value})
+	}
+	return overload, nil
+}
+
+func (m *message) findOption(code uint8) int {
+	for i, o := range m.options {
+		if o.code == code {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *message) option(code uint8) []byte {
+	if i := m.findOption(code); i >= 0 {
+		return m.options[i].data
+	}
+	return nil
+}
+
+func (m *message) isBroadcast() bool {
+	return m.flags&flagBroadcast != 0
+}
+
+// cString returns the null terminated string in data.
+func cString(data []byte) string {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return string(data)
+}
+
+func hardwareTypeName(t uint8) string {
+	switch t {
+	case hardwareTypeEthernet:
+		return "Ethernet"
+	case 6:
+		return "IEEE 802"
+	case 32:
+		return "InfiniBand"
+	}
+	return fmt.Sprintf("%d", t)
+}
@@ -0,0 +1,379 @@
+// Package dhcpv4 provides support for parsing DHCPv4 messages (RFC 2131)
+// and reporting the DHCP exchanges. Replies are matched with the client
+// requests based on the transaction ID and the client hardware address, as
+// most requests are broadcast and replies might be sent by relay agents.
+package dhcpv4
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+
+	"github.com/elastic/beats/packetbeat/procs"
+	"github.com/elastic/beats/packetbeat/protos"
+	"github.com/elastic/beats/packetbeat/publish"
+)
+
+type dhcpv4Plugin struct {
+	// Configuration data.
+	ports              []int
+	transactionTimeout time.Duration
+
+	// Cache of requests waiting for a reply, by transactionKey.
+	transactions *common.Cache
+
+	results publish.Transactions
+}
+
+var (
+	debugf = logp.MakeDebug("dhcpv4")
+)
+
+var (
+	unmatchedRequests  = monitoring.NewInt(nil, "dhcpv4.unmatched_requests")
+	unmatchedResponses = monitoring.NewInt(nil, "dhcpv4.unmatched_responses")
+)
+
+const (
+	noteNoResponse       = "No response to this request was received"
+	noteOrphanedResponse = "Response: received without an associated request"
+)
+
+// dhcpMessage contains a single DHCP message.
+type dhcpMessage struct {
+	ts           time.Time
+	tuple        common.IPPortTuple
+	cmdlineTuple *common.CmdlineTuple
+	data         *message
+	length       int
+}
+
+// transactionKey identifies the requests of a client.
+type transactionKey struct {
+	xid         uint32
+	hwAddr      string
+	messageType messageType
+	tunnel      common.TunnelID
+}
+
+type transaction struct {
+	ts       time.Time
+	src, dst common.Endpoint
+	tunnel   common.TunnelID
+	notes    []string
+
+	request  *dhcpMessage
+	response *dhcpMessage
+
+	// set once a reply has been published for the request
+	answered bool
+
+	// set if the request expired without a reply
+	noResponse bool
+}
+
+func init() {
+	protos.Register("dhcpv4", New)
+}
+
+func New(
+	testMode bool,
+	results publish.Transactions,
+	cfg *common.Config,
+) (protos.Plugin, error) {
+	p := &dhcpv4Plugin{}
+	config := defaultConfig
+	if !testMode {
+		if err := cfg.Unpack(&config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.init(results, &config); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (dhcp *dhcpv4Plugin) init(results publish.Transactions, config *dhcpv4Config) error {
+	dhcp.setFromConfig(config)
+	dhcp.transactions = common.NewCacheWithRemovalListener(
+		dhcp.transactionTimeout,
+		protos.DefaultTransactionHashSize,
+		func(k common.Key, v common.Value) {
+			trans, ok := v.(*transaction)
+			if !ok {
+				logp.Err("Expired value is not a *transaction.")
+				return
+			}
+			dhcp.expireTransaction(trans)
+		})
+	dhcp.transactions.StartJanitor(dhcp.transactionTimeout)
+
+	dhcp.results = results
+	return nil
+}
+
+func (dhcp *dhcpv4Plugin) setFromConfig(config *dhcpv4Config) {
+	dhcp.ports = config.Ports
+	dhcp.transactionTimeout = config.TransactionTimeout
+}
+
+func (dhcp *dhcpv4Plugin) GetPorts() []int {
+	return dhcp.ports
+}
+
+func (dhcp *dhcpv4Plugin) ParseUDP(pkt *protos.Packet) {
+	defer logp.Recover("DHCPv4 ParseUDP exception")
+
+	// requests are kept until the reply is received
+	payload := append([]byte(nil), pkt.Payload...)
+	data, err := decodeMessage(payload)
+	if err != nil {
+		debugf("Ignoring packet from %s: %v", pkt.Tuple.String(), err)
+		return
+	}
+
+	msg := &dhcpMessage{
+		ts:           pkt.Ts,
+		tuple:        pkt.Tuple,
+		cmdlineTuple: procs.ProcWatcher.FindProcessesTuple(&pkt.Tuple, procs.TransportUDP),
+		data:         data,
+		length:       len(pkt.Payload),
+	}
+
+	debugf("Received DHCP %v (xid=0x%08x) from %s",
+		data.messageType, data.xid, pkt.Tuple.String())
+	if data.op == opBootRequest {
+		dhcp.receivedRequest(msg)
+	} else {
+		dhcp.receivedReply(msg)
+	}
+}
+
+func newTransactionKey(msg *dhcpMessage, requestType messageType) transactionKey {
+	return transactionKey{
+		xid:         msg.data.xid,
+		hwAddr:      string(msg.data.clientHWAddr),
+		messageType: requestType,
+		tunnel:      msg.tuple.Tunnel,
+	}
+}
+
+func newTransaction(msg *dhcpMessage, reverse bool) *transaction {
+	tuple := &msg.tuple
+	cmd := msg.cmdlineTuple
+	src := common.Endpoint{
+		IP:   tuple.SrcIP.String(),
+		Port: tuple.SrcPort,
+		Proc: string(cmd.Src),
+	}
+	dst := common.Endpoint{
+		IP:   tuple.DstIP.String(),
+		Port: tuple.DstPort,
+		Proc: string(cmd.Dst),
+	}
+	if reverse {
+		src, dst = dst, src
+	}
+	return &transaction{ts: msg.ts, src: src, dst: dst, tunnel: tuple.Tunnel}
+}
+
+// requestTypes returns the types of the requests the reply answers.
+func requestTypes(reply messageType) []messageType {
+	switch reply {
+	case msgOffer:
+		return []messageType{msgDiscover}
+	case msgAck, msgNak:
+		return []messageType{msgRequest, msgInform}
+	}
+	return nil
+}
+
+func (dhcp *dhcpv4Plugin) receivedRequest(msg *dhcpMessage) {
+	msgType := msg.data.messageType
+	switch msgType {
+	case msgDiscover, msgRequest, msgInform:
+	default:
+		// no reply expected (e.g. DECLINE, RELEASE)
+		trans := newTransaction(msg, false)
+		trans.request = msg
+		dhcp.publishTransaction(trans)
+		return
+	}
+
+	key := newTransactionKey(msg, msgType)
+	if trans := dhcp.getTransaction(key); trans != nil && !trans.answered {
+		// retransmission of a request still waiting for a reply
+		debugf("Retransmission of DHCP %v (xid=0x%08x)", msgType, msg.data.xid)
+		return
+	}
+
+	trans := newTransaction(msg, false)
+	trans.request = msg
+	dhcp.transactions.Put(key, trans)
+}
+
+func (dhcp *dhcpv4Plugin) receivedReply(msg *dhcpMessage) {
+	var key transactionKey
+	var trans *transaction
+	for _, requestType := range requestTypes(msg.data.messageType) {
+		key = newTransactionKey(msg, requestType)
+		if trans = dhcp.getTransaction(key); trans != nil {
+			break
+		}
+	}
+
+	if trans == nil {
+		debugf("%s (xid=0x%08x)", noteOrphanedResponse, msg.data.xid)
+		unmatchedResponses.Add(1)
+
+		trans = newTransaction(msg, true)
+		trans.notes = append(trans.notes, noteOrphanedResponse)
+		trans.response = msg
+		dhcp.publishTransaction(trans)
+		return
+	}
+
+	trans.response = msg
+	trans.answered = true
+	dhcp.publishTransaction(trans)
+	trans.response = nil
+
+	// several servers might answer a DISCOVER, so keep the request until it
+	// expires
+	if msg.data.messageType != msgOffer {
+		dhcp.transactions.Delete(key)
+	}
+}
+
+func (dhcp *dhcpv4Plugin) getTransaction(k transactionKey) *transaction {
+	v := dhcp.transactions.Get(k)
+	if v != nil {
+		return v.(*transaction)
+	}
+	return nil
+}
+
+func (dhcp *dhcpv4Plugin) expireTransaction(t *transaction) {
+	if t.answered {
+		return
+	}
+
+	debugf("%s (xid=0x%08x)", noteNoResponse, t.request.data.xid)
+	unmatchedRequests.Add(1)
+
+	t.notes = append(t.notes, noteNoResponse)
+	t.noResponse = true
+	dhcp.publishTransaction(t)
+}
+
+func (dhcp *dhcpv4Plugin) publishTransaction(t *transaction) {
+	if dhcp.results == nil {
+		return
+	}
+
+	requ, resp := t.request, t.response
+	msg := requ
+	if msg == nil {
+		msg = resp
+	}
+
+	event := common.MapStr{
+		"@timestamp": common.Time(t.ts),
+		"type":       "dhcpv4",
+		"transport":  "udp",
+		"src":        &t.src,
+		"dst":        &t.dst,
+		"status":     common.OK_STATUS,
+	}
+	protos.AddTunnel(event, t.tunnel)
+	if len(t.notes) > 0 {
+		event["notes"] = t.notes
+	}
+
+	dhcpEvent := common.MapStr{
+		"transaction_id": fmt.Sprintf("0x%08x", msg.data.xid),
+		"client_mac":     msg.data.clientHWAddr.String(),
+		"hardware_type":  hardwareTypeName(msg.data.hardwareType),
+	}
+	event["dhcpv4"] = dhcpEvent
+
+	if requ != nil {
+		event["method"] = requ.data.messageType.String()
+		event["bytes_in"] = requ.length
+		dhcpEvent["request"] = messageToMapStr(requ.data)
+	} else {
+		event["method"] = resp.data.messageType.String()
+	}
+
+	if resp != nil {
+		event["bytes_out"] = resp.length
+		dhcpEvent["response"] = messageToMapStr(resp.data)
+		if requ != nil {
+			event["responsetime"] = int32(resp.ts.Sub(requ.ts).Nanoseconds() / 1e6)
+		}
+		if !isUnspecified(resp.data.yourIP) {
+			dhcpEvent["assigned_ip"] = resp.data.yourIP.String()
+		}
+		if resp.data.messageType == msgNak {
+			event["status"] = common.ERROR_STATUS
+		}
+	}
+	if t.noResponse {
+		event["status"] = common.ERROR_STATUS
+	}
+
+	dhcp.results.PublishTransaction(event)
+}
+
+// messageToMapStr converts the header fields and options of a message to
+// event fields. Unset addresses are omitted.
+func messageToMapStr(m *message) common.MapStr {
+	fields := common.MapStr{
+		"seconds": m.secs,
+		"hops":    m.hops,
+		"option":  optionsToMapStr(m),
+	}
+	if m.op == opBootRequest {
+		fields["op_code"] = "bootrequest"
+	} else {
+		fields["op_code"] = "bootreply"
+	}
+	if m.isBroadcast() {
+		fields["flags"] = "broadcast"
+	} else {
+		fields["flags"] = "unicast"
+	}
+
+	addresses := []struct {
+		name string
+		ip   net.IP
+	}{
+		{"client_ip", m.clientIP},
+		{"assigned_ip", m.yourIP},
+		{"server_ip", m.serverIP},
+		{"relay_ip", m.relayIP},
+	}
+	for _, addr := range addresses {
+		if !isUnspecified(addr.ip) {
+			fields[addr.name] = addr.ip.String()
+		}
+	}
+
+	if m.serverName != "" {
+		fields["server_name"] = m.serverName
+	}
+	if m.bootFile != "" {
+		fields["boot_file_name"] = m.bootFile
+	}
+	return fields
+}
+
+func isUnspecified(ip net.IP) bool {
+	return ip == nil || ip.Equal(net.IPv4zero)
+}
@@ -0,0 +1,429 @@
+// +build !integration
+
+package dhcpv4
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/protos"
+	"github.com/elastic/beats/packetbeat/publish"
+)
+
+// Verify that the interface for UDP has been satisfied.
+var _ protos.UDPPlugin = &dhcpv4Plugin{}
+
+var (
+	clientMAC = net.HardwareAddr{0x00, 0x0b, 0x82, 0x01, 0xfc, 0x42}
+
+	clientTuple = common.NewIPPortTuple(4,
+		net.ParseIP("0.0.0.0"), 68,
+		net.ParseIP("255.255.255.255"), 67)
+	serverTuple = common.NewIPPortTuple(4,
+		net.ParseIP("192.168.0.1"), 67,
+		net.ParseIP("192.168.0.10"), 68)
+)
+
+// testMessage describes a DHCP message to be encoded by build.
+type testMessage struct {
+	op       uint8
+	xid      uint32
+	flags    uint16
+	ciaddr   string
+	yiaddr   string
+	siaddr   string
+	giaddr   string
+	sname    []byte
+	file     []byte
+	msgType  messageType
+	options  []option
+	noCookie bool
+}
+
+func (tm testMessage) build() []byte {
+	buf := make([]byte, minMessageLen)
+	buf[0] = tm.op
+	buf[1] = hardwareTypeEthernet
+	buf[2] = byte(len(clientMAC))
+	binary.BigEndian.PutUint32(buf[4:], tm.xid)
+	binary.BigEndian.PutUint16(buf[10:], tm.flags)
+	for i, addr := range []string{tm.ciaddr, tm.yiaddr, tm.siaddr, tm.giaddr} {
+		if addr != "" {
+			copy(buf[12+4*i:], net.ParseIP(addr).To4())
+		}
+	}
+	copy(buf[28:], clientMAC)
+	copy(buf[44:108], tm.sname)
+	copy(buf[108:236], tm.file)
+	if !tm.noCookie {
+		copy(buf[236:], magicCookie)
+	}
+
+	if tm.msgType != 0 {
+		buf = append(buf, optMessageType, 1, byte(tm.msgType))
+	}
+	for _, o := range tm.options {
+		buf = append(buf, o.code, byte(len(o.data)))
+		buf = append(buf, o.data...)
+	}
+	return append(buf, optEnd)
+}
+
+func request(xid uint32, t messageType, options ...option) []byte {
+	return testMessage{
+		op:      opBootRequest,
+		xid:     xid,
+		flags:   flagBroadcast,
+		msgType: t,
+		options: options,
+	}.build()
+}
+
+func reply(xid uint32, t messageType, yiaddr string, options ...option) []byte {
+	return testMessage{
+		op:      opBootReply,
+		xid:     xid,
+		yiaddr:  yiaddr,
+		msgType: t,
+		options: options,
+	}.build()
+}
+
+func newDHCP(t testing.TB, timeout time.Duration) *dhcpv4Plugin {
+	if testing.Verbose() {
+		logp.LogInit(logp.LOG_DEBUG, "", false, true, []string{"dhcpv4"})
+	}
+
+	results := &publish.ChanTransactions{Channel: make(chan common.MapStr, 10)}
+	cfg, _ := common.NewConfigFrom(map[string]interface{}{
+		"ports":               []int{67, 68},
+		"transaction_timeout": timeout,
+	})
+	dhcp, err := New(false, results, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dhcp.(*dhcpv4Plugin)
+}
+
+func newPacket(tuple common.IPPortTuple, payload []byte) *protos.Packet {
+	return &protos.Packet{
+		Ts:      time.Now(),
+		Tuple:   tuple,
+		Payload: payload,
+	}
+}
+
+func expectEvent(t testing.TB, dhcp *dhcpv4Plugin) common.MapStr {
+	client := dhcp.results.(*publish.ChanTransactions)
+	select {
+	case event := <-client.Channel:
+		return event
+	default:
+		t.Fatal("Expected an event to be published.")
+	}
+	return nil
+}
+
+func expectNoEvent(t testing.TB, dhcp *dhcpv4Plugin) {
+	client := dhcp.results.(*publish.ChanTransactions)
+	select {
+	case event := <-client.Channel:
+		t.Fatalf("Unexpected event: %v", event)
+	default:
+	}
+}
+
+func getValue(t testing.TB, event common.MapStr, key string) interface{} {
+	v, err := event.GetValue(key)
+	if err != nil {
+		t.Fatalf("%s: %v", key, err)
+	}
+	return v
+}
+
+func TestDecodeMessage(t *testing.T) {
+	data := testMessage{
+		op:      opBootRequest,
+		xid:     0x3903f326,
+		flags:   flagBroadcast,
+		ciaddr:  "192.168.0.10",
+		giaddr:  "10.0.0.1",
+		msgType: msgRequest,
+		options: []option{
+			{optHostname, []byte("host1")},
+			{optRequestedIP, []byte{192, 168, 0, 10}},
+		},
+	}.build()
+
+	m, err := decodeMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint8(opBootRequest), m.op)
+	assert.Equal(t, uint32(0x3903f326), m.xid)
+	assert.True(t, m.isBroadcast())
+	assert.Equal(t, "192.168.0.10", m.clientIP.String())
+	assert.Equal(t, "10.0.0.1", m.relayIP.String())
+	assert.Equal(t, clientMAC, m.clientHWAddr)
+	assert.Equal(t, msgRequest, m.messageType)
+	assert.Equal(t, []byte("host1"), m.option(optHostname))
+	assert.Equal(t, []byte{192, 168, 0, 10}, m.option(optRequestedIP))
+}
+
+func TestDecodeMessageErrors(t *testing.T) {
+	valid := request(1, msgDiscover)
+
+	_, err := decodeMessage(valid[:100])
+	assert.Equal(t, errTooShort, err)
+
+	invalidOp := append([]byte(nil), valid...)
+	invalidOp[0] = 3
+	_, err = decodeMessage(invalidOp)
+	assert.Equal(t, errInvalidOpCode, err)
+
+	_, err = decodeMessage(testMessage{op: opBootRequest, noCookie: true}.build())
+	assert.Equal(t, errNoMagicCookie, err)
+
+	_, err = decodeMessage(testMessage{op: opBootRequest}.build())
+	assert.Equal(t, errNoMessageType, err)
+
+	truncated := append(request(1, msgDiscover)[:minMessageLen+3], optHostname, 10, 'a')
+	_, err = decodeMessage(truncated)
+	assert.Equal(t, errTruncatedOption, err)
+}
+
+func TestDecodeOptionOverload(t *testing.T) {
+	// hostname split between the options field and the file field
+	file := []byte{optHostname, 3, 'b', 'a', 'r', optEnd}
+	data := testMessage{
+		op:      opBootRequest,
+		xid:     1,
+		file:    file,
+		sname:   []byte("ignored"),
+		msgType: msgDiscover,
+		options: []option{
+			{optOverload, []byte{1}},
+			{optHostname, []byte("foo")},
+		},
+	}.build()
+
+	m, err := decodeMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []byte("foobar"), m.option(optHostname))
+	assert.Equal(t, "", m.bootFile)
+	assert.Equal(t, "ignored", m.serverName)
+}
+
+func TestDiscoverOffer(t *testing.T) {
+	dhcp := newDHCP(t, time.Minute)
+
+	dhcp.ParseUDP(newPacket(clientTuple, request(0x1234, msgDiscover,
+		option{optParameterRequestList, []byte{optSubnetMask, optRouter, optDNSServers, 252}},
+		option{optClientIdentifier, append([]byte{1}, clientMAC...)},
+	)))
+	expectNoEvent(t, dhcp)
+
+	dhcp.ParseUDP(newPacket(serverTuple, reply(0x1234, msgOffer, "192.168.0.10",
+		option{optSubnetMask, []byte{255, 255, 255, 0}},
+		option{optRouter, []byte{192, 168, 0, 1}},
+		option{optDNSServers, []byte{8, 8, 8, 8, 8, 8, 4, 4}},
+		option{optLeaseTime, []byte{0, 0, 0x0e, 0x10}},
+		option{optServerIdentifier, []byte{192, 168, 0, 1}},
+	)))
+	event := expectEvent(t, dhcp)
+
+	assert.Equal(t, "dhcpv4", event["type"])
+	assert.Equal(t, "udp", event["transport"])
+	assert.Equal(t, "DISCOVER", event["method"])
+	assert.Equal(t, common.OK_STATUS, event["status"])
+	assert.Equal(t, "0.0.0.0", event["src"].(*common.Endpoint).IP)
+	assert.Equal(t, "255.255.255.255", event["dst"].(*common.Endpoint).IP)
+	assert.Equal(t, "0x00001234", getValue(t, event, "dhcpv4.transaction_id"))
+	assert.Equal(t, "00:0b:82:01:fc:42", getValue(t, event, "dhcpv4.client_mac"))
+	assert.Equal(t, "Ethernet", getValue(t, event, "dhcpv4.hardware_type"))
+	assert.Equal(t, "192.168.0.10", getValue(t, event, "dhcpv4.assigned_ip"))
+
+	assert.Equal(t, "broadcast", getValue(t, event, "dhcpv4.request.flags"))
+	assert.Equal(t, "DISCOVER", getValue(t, event, "dhcpv4.request.option.message_type"))
+	assert.Equal(t,
+		[]string{"subnet_mask", "router", "domain_name_server", "252"},
+		getValue(t, event, "dhcpv4.request.option.parameter_request_list"))
+	assert.Equal(t, "01000b8201fc42",
+		getValue(t, event, "dhcpv4.request.option.client_identifier"))
+
+	assert.Equal(t, "OFFER", getValue(t, event, "dhcpv4.response.option.message_type"))
+	assert.Equal(t, "bootreply", getValue(t, event, "dhcpv4.response.op_code"))
+	assert.Equal(t, "192.168.0.10", getValue(t, event, "dhcpv4.response.assigned_ip"))
+	assert.Equal(t, "255.255.255.0", getValue(t, event, "dhcpv4.response.option.subnet_mask"))
+	assert.Equal(t, []string{"192.168.0.1"}, getValue(t, event, "dhcpv4.response.option.router"))
+	assert.Equal(t, []string{"8.8.8.8", "8.8.4.4"},
+		getValue(t, event, "dhcpv4.response.option.dns_servers"))
+	assert.Equal(t, uint32(3600),
+		getValue(t, event, "dhcpv4.response.option.ip_address_lease_time_sec"))
+	assert.Equal(t, "192.168.0.1",
+		getValue(t, event, "dhcpv4.response.option.server_identifier"))
+}
+
+func TestMultipleOffers(t *testing.T) {
+	dhcp := newDHCP(t, time.Minute)
+
+	dhcp.ParseUDP(newPacket(clientTuple, request(1, msgDiscover)))
+	dhcp.ParseUDP(newPacket(serverTuple, reply(1, msgOffer, "192.168.0.10")))
+	dhcp.ParseUDP(newPacket(serverTuple, reply(1, msgOffer, "192.168.0.20")))
+
+	event := expectEvent(t, dhcp)
+	assert.Equal(t, "192.168.0.10", getValue(t, event, "dhcpv4.assigned_ip"))
+	event = expectEvent(t, dhcp)
+	assert.Equal(t, "192.168.0.20", getValue(t, event, "dhcpv4.assigned_ip"))
+	assert.Nil(t, event["notes"])
+}
+
+func TestRequestAck(t *testing.T) {
+	dhcp := newDHCP(t, time.Minute)
+
+	dhcp.ParseUDP(newPacket(clientTuple, request(2, msgRequest,
+		option{optRequestedIP, []byte{192, 168, 0, 10}},
+		option{optHostname, []byte("host1")},
+	)))
+	// retransmission
+	dhcp.ParseUDP(newPacket(clientTuple, request(2, msgRequest,
+		option{optRequestedIP, []byte{192, 168, 0, 10}},
+	)))
+	dhcp.ParseUDP(newPacket(serverTuple, reply(2, msgAck, "192.168.0.10")))
+
+	event := expectEvent(t, dhcp)
+	assert.Equal(t, "REQUEST", event["method"])
+	assert.Equal(t, common.OK_STATUS, event["status"])
+	assert.Equal(t, "host1", getValue(t, event, "dhcpv4.request.option.hostname"))
+	assert.Equal(t, "192.168.0.10",
+		getValue(t, event, "dhcpv4.request.option.requested_ip_address"))
+	assert.Equal(t, "ACK", getValue(t, event, "dhcpv4.response.option.message_type"))
+	expectNoEvent(t, dhcp)
+
+	// the transaction is complete, so another ACK is unmatched
+	dhcp.ParseUDP(newPacket(serverTuple, reply(2, msgAck, "192.168.0.10")))
+	event = expectEvent(t, dhcp)
+	assert.Equal(t, []string{noteOrphanedResponse}, event["notes"])
+}
+
+func TestRequestNak(t *testing.T) {
+	dhcp := newDHCP(t, time.Minute)
+
+	dhcp.ParseUDP(newPacket(clientTuple, request(3, msgRequest)))
+	dhcp.ParseUDP(newPacket(serverTuple, reply(3, msgNak, "",
+		option{optMessage, []byte("requested address not available")},
+	)))
+
+	event := expectEvent(t, dhcp)
+	assert.Equal(t, common.ERROR_STATUS, event["status"])
+	assert.Equal(t, "requested address not available",
+		getValue(t, event, "dhcpv4.response.option.message"))
+	_, err := event.GetValue("dhcpv4.assigned_ip")
+	assert.Error(t, err)
+}
+
+func TestInformAck(t *testing.T) {
+	dhcp := newDHCP(t, time.Minute)
+
+	dhcp.ParseUDP(newPacket(clientTuple, request(4, msgInform)))
+	dhcp.ParseUDP(newPacket(serverTuple, reply(4, msgAck, "",
+		option{optDomainName, []byte("example.com")},
+	)))
+
+	event := expectEvent(t, dhcp)
+	assert.Equal(t, "INFORM", event["method"])
+	assert.Equal(t, "example.com", getValue(t, event, "dhcpv4.response.option.domain_name"))
+}
+
+func TestRelease(t *testing.T) {
+	dhcp := newDHCP(t, time.Minute)
+
+	data := testMessage{
+		op:      opBootRequest,
+		xid:     5,
+		ciaddr:  "192.168.0.10",
+		msgType: msgRelease,
+	}.build()
+	dhcp.ParseUDP(newPacket(clientTuple, data))
+
+	event := expectEvent(t, dhcp)
+	assert.Equal(t, "RELEASE", event["method"])
+	assert.Equal(t, common.OK_STATUS, event["status"])
+	assert.Equal(t, "unicast", getValue(t, event, "dhcpv4.request.flags"))
+	assert.Equal(t, "192.168.0.10", getValue(t, event, "dhcpv4.request.client_ip"))
+	assert.Nil(t, event["notes"])
+}
+
+func TestOrphanedReply(t *testing.T) {
+	dhcp := newDHCP(t, time.Minute)
+
+	dhcp.ParseUDP(newPacket(serverTuple, reply(6, msgOffer, "192.168.0.10")))
+
+	event := expectEvent(t, dhcp)
+	assert.Equal(t, "OFFER", event["method"])
+	assert.Equal(t, []string{noteOrphanedResponse}, event["notes"])
+	// the client is reported as the source
+	assert.Equal(t, "192.168.0.10", event["src"].(*common.Endpoint).IP)
+	assert.Equal(t, 68, int(event["src"].(*common.Endpoint).Port))
+}
+
+func TestExpiredRequest(t *testing.T) {
+	dhcp := newDHCP(t, 10*time.Millisecond)
+
+	dhcp.ParseUDP(newPacket(clientTuple, request(7, msgDiscover)))
+	dhcp.ParseUDP(newPacket(clientTuple, request(8, msgRequest)))
+	dhcp.ParseUDP(newPacket(serverTuple, reply(7, msgOffer, "192.168.0.10")))
+	expectEvent(t, dhcp)
+
+	time.Sleep(20 * time.Millisecond)
+	dhcp.transactions.CleanUp()
+
+	// only the unanswered request is reported
+	event := expectEvent(t, dhcp)
+	assert.Equal(t, "REQUEST", event["method"])
+	assert.Equal(t, common.ERROR_STATUS, event["status"])
+	assert.Equal(t, []string{noteNoResponse}, event["notes"])
+	expectNoEvent(t, dhcp)
+}
+
+func TestRelayAgentInformation(t *testing.T) {
+	dhcp := newDHCP(t, time.Minute)
+
+	data := testMessage{
+		op:      opBootRequest,
+		xid:     9,
+		giaddr:  "10.0.0.1",
+		msgType: msgDecline,
+		options: []option{
+			{optRelayAgentInfo, []byte{1, 2, 0x00, 0x05, 2, 3, 'a', 'b', 'c'}},
+			{optClientFQDN, []byte{0x05, 0, 0, 4, 'h', 'o', 's', 't', 3, 'c', 'o', 'm', 0}},
+		},
+	}.build()
+	dhcp.ParseUDP(newPacket(clientTuple, data))
+
+	event := expectEvent(t, dhcp)
+	assert.Equal(t, "DECLINE", event["method"])
+	assert.Equal(t, "10.0.0.1", getValue(t, event, "dhcpv4.request.relay_ip"))
+	assert.Equal(t, "0005",
+		getValue(t, event, "dhcpv4.request.option.relay_agent_information.circuit_id"))
+	assert.Equal(t, "616263",
+		getValue(t, event, "dhcpv4.request.option.relay_agent_information.remote_id"))
+	assert.Equal(t, "host.com", getValue(t, event, "dhcpv4.request.option.client_fqdn"))
+}
+
+func TestIgnoreBOOTP(t *testing.T) {
+	dhcp := newDHCP(t, time.Minute)
+
+	dhcp.ParseUDP(newPacket(clientTuple, testMessage{op: opBootRequest, noCookie: true}.build()))
+	dhcp.ParseUDP(newPacket(clientTuple, []byte{1, 2, 3}))
+	expectNoEvent(t, dhcp)
+}
@@ -0,0 +1,279 @@
+package dhcpv4
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// DHCP message types (RFC 2132, RFC 4388, RFC 6926)
+type messageType uint8
+
+const (
+	msgDiscover messageType = 1
+	msgOffer    messageType = 2
+	msgRequest  messageType = 3
+	msgDecline  messageType = 4
+	msgAck      messageType = 5
+	msgNak      messageType = 6
+	msgRelease  messageType = 7
+	msgInform   messageType = 8
+)
+
+var messageTypeNames = map[messageType]string{
+	msgDiscover: "DISCOVER",
+	msgOffer:    "OFFER",
+	msgRequest:  "REQUEST",
+	msgDecline:  "DECLINE",
+	msgAck:      "ACK",
+	msgNak:      "NAK",
+	msgRelease:  "RELEASE",
+	msgInform:   "INFORM",
+	9:           "FORCERENEW",
+	10:          "LEASEQUERY",
+	11:          "LEASEUNASSIGNED",
+	12:          "LEASEUNKNOWN",
+	13:          "LEASEACTIVE",
+	14:          "BULKLEASEQUERY",
+	15:          "LEASEQUERYDONE",
+	16:          "ACTIVELEASEQUERY",
+	17:          "LEASEQUERYSTATUS",
+	18:          "TLS",
+}
+
+func (t messageType) String() string {
+	if name, found := messageTypeNames[t]; found {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", uint8(t))
+}
+
+// Option codes (RFC 2132 and extensions)
+const (
+	optPad                   = 0
+	optSubnetMask            = 1
+	optTimeOffset            = 2
+	optRouter                = 3
+	optTimeServer            = 4
+	optDNSServers            = 6
+	optHostname              = 12
+	optDomainName            = 15
+	optBroadcastAddress      = 28
+	optNTPServers            = 42
+	optVendorSpecific        = 43
+	optRequestedIP           = 50
+	optLeaseTime             = 51
+	optOverload              = 52
+	optMessageType           = 53
+	optServerIdentifier      = 54
+	optParameterRequestList  = 55
+	optMessage               = 56
+	optMaxMessageSize        = 57
+	optRenewalTime           = 58
+	optRebindingTime         = 59
+	optVendorClassIdentifier = 60
+	optClientIdentifier      = 61
+	optTFTPServerName        = 66
+	optBootFileName          = 67
+	optUserClass             = 77
+	optClientFQDN            = 81
+	optRelayAgentInfo        = 82
+	optDomainSearch          = 119
+	optClasslessRoutes       = 121
+	optEnd                   = 255
+)
+
+type optionKind uint8
+
+const (
+	kindIP optionKind = iota
+	kindIPList
+	kindString
+	kindUint16
+	kindUint32
+	kindInt32
+	kindHex
+)
+
+type optionInfo struct {
+	name string
+	kind optionKind
+}
+
+// options reported in the events. Other options are only reported by their
+// code.
+var optionInfos = map[uint8]optionInfo{
+	optSubnetMask:            {"subnet_mask", kindIP},
+	optTimeOffset:            {"utc_time_offset_sec", kindInt32},
+	optRouter:                {"router", kindIPList},
+	optTimeServer:            {"time_servers", kindIPList},
+	optDNSServers:            {"dns_servers", kindIPList},
+	optHostname:              {"hostname", kindString},
+	optDomainName:            {"domain_name", kindString},
+	optBroadcastAddress:      {"broadcast_address", kindIP},
+	optNTPServers:            {"ntp_servers", kindIPList},
+	optRequestedIP:           {"requested_ip_address", kindIP},
+	optLeaseTime:             {"ip_address_lease_time_sec", kindUint32},
+	optServerIdentifier:      {"server_identifier", kindIP},
+	optMessage:               {"message", kindString},
+	optMaxMessageSize:        {"max_dhcp_message_size", kindUint16},
+	optRenewalTime:           {"renewal_time_sec", kindUint32},
+	optRebindingTime:         {"rebinding_time_sec", kindUint32},
+	optVendorClassIdentifier: {"class_identifier", kindString},
+	optClientIdentifier:      {"client_identifier", kindHex},
+	optTFTPServerName:        {"tftp_server_name", kindString},
+	optBootFileName:          {"boot_file_name", kindString},
+	optUserClass:             {"user_class", kindHex},
+}
+
+var optionNames = map[uint8]string{
+	optSubnetMask:            "subnet_mask",
+	optTimeOffset:            "time_offset",
+	optRouter:                "router",
+	optTimeServer:            "time_server",
+	optDNSServers:            "domain_name_server",
+	optHostname:              "hostname",
+	optDomainName:            "domain_name",
+	optBroadcastAddress:      "broadcast_address",
+	optNTPServers:            "ntp_servers",
+	optVendorSpecific:        "vendor_specific_information",
+	optRequestedIP:           "requested_ip_address",
+	optLeaseTime:             "ip_address_lease_time",
+	optServerIdentifier:      "server_identifier",
+	optMessage:               "message",
+	optMaxMessageSize:        "max_dhcp_message_size",
+	optRenewalTime:           "renewal_time",
+	optRebindingTime:         "rebinding_time",
+	optVendorClassIdentifier: "class_identifier",
+	optClientIdentifier:      "client_identifier",
+	optTFTPServerName:        "tftp_server_name",
+	optBootFileName:          "boot_file_name",
+	optUserClass:             "user_class",
+	optClientFQDN:            "client_fqdn",
+	optRelayAgentInfo:        "relay_agent_information",
+	optDomainSearch:          "domain_search",
+	optClasslessRoutes:       "classless_static_route",
+}
+
+func optionName(code uint8) string {
+	if name, found := optionNames[code]; found {
+		return name
+	}
+	return fmt.Sprintf("%d", code)
+}
+
+// optionsToMapStr converts the options of a message to event fields.
+func optionsToMapStr(m *message) common.MapStr {
+	fields := common.MapStr{
+		"message_type": m.messageType.String(),
+	}
+
+	for _, o := range m.options {
+		if info, found := optionInfos[o.code]; found {
+			if value, ok := decodeOption(info.kind, o.data); ok {
+				fields[info.name] = value
+			}
+			continue
+		}
+
+		switch o.code {
+		case optParameterRequestList:
+			params := make([]string, len(o.data))
+			for i, code := range o.data {
+				params[i] = optionName(code)
+			}
+			fields["parameter_request_list"] = params
+		case optClientFQDN:
+			// flags and two deprecated rcode bytes, followed by the name
+			if len(o.data) > 3 {
+				fields["client_fqdn"] = decodeDomainName(o.data[3:], o.data[0]&0x4 != 0)
+			}
+		case optRelayAgentInfo:
+			if info := decodeRelayAgentInfo(o.data); len(info) > 0 {
+				fields["relay_agent_information"] = info
+			}
+		case optVendorSpecific:
+			fields["vendor_specific_information"] = hex.EncodeToString(o.data)
+		}
+	}
+	return fields
+}
+
+func decodeOption(kind optionKind, data []byte) (interface{}, bool) {
+	switch kind {
+	case kindIP:
+		if len(data) == 4 {
+			return net.IP(data).String(), true
+		}
+	case kindIPList:
+		if len(data) > 0 && len(data)%4 == 0 {
+			ips := make([]string, 0, len(data)/4)
+			for i := 0; i < len(data); i += 4 {
+				ips = append(ips, net.IP(data[i:i+4]).String())
+			}
+			return ips, true
+		}
+	case kindString:
+		return cString(data), true
+	case kindUint16:
+		if len(data) == 2 {
+			return binary.BigEndian.Uint16(data), true
+		}
+	case kindUint32:
+		if len(data) == 4 {
+			return binary.BigEndian.Uint32(data), true
+		}
+	case kindInt32:
+		if len(data) == 4 {
+			return int32(binary.BigEndian.Uint32(data)), true
+		}
+	case kindHex:
+		return hex.EncodeToString(data), true
+	}
+	return nil, false
+}
+
+// decodeDomainName decodes the name of the client FQDN option, which is
+// either in DNS wire format or ASCII encoded.
+func decodeDomainName(data []byte, wireFormat bool) string {
+	if !wireFormat {
+		return cString(data)
+	}
+
+	var labels []string
+	for len(data) > 0 {
+		n := int(data[0])
+		if n == 0 || n+1 > len(data) {
+			break
+		}
+		labels = append(labels, string(data[1:n+1]))
+		data = data[n+1:]
+	}
+	return strings.Join(labels, ".")
+}
+
+// decodeRelayAgentInfo decodes the circuit and remote ID sub-options of the
+// relay agent information option (RFC 3046).
+func decodeRelayAgentInfo(data []byte) common.MapStr {
+	info := common.MapStr{}
+	for len(data) >= 2 {
+		code, n := data[0], int(data[1])
+		if len(data) < 2+n {
+			break
+		}
+		value := data[2 : 2+n]
+		data = data[2+n:]
+
+		switch code {
+		case 1:
+			info["circuit_id"] = hex.EncodeToString(value)
+		case 2:
+			info["remote_id"] = hex.EncodeToString(value)
+		}
+	}
+	return info
+}
@@ -1,6 +1,9 @@
 package dns
 
 import (
+	"errors"
+	"time"
+
 	"github.com/elastic/beats/packetbeat/config"
 	"github.com/elastic/beats/packetbeat/protos"
 )
@@ -9,12 +12,77 @@ type dnsConfig struct {
 	config.ProtocolCommon `config:",inline"`
 	IncludeAuthorities    bool `config:"include_authorities"`
 	IncludeAdditionals    bool `config:"include_additionals"`
+
+	Passive    passiveConfig    `config:"passive"`
+	Heuristics heuristicsConfig `config:"heuristics"`
+}
+
+// passiveConfig configures the passive DNS aggregation.
+type passiveConfig struct {
+	// If enabled, the unique (name, type, answer) tuples are aggregated and
+	// published every period.
+	Enabled bool          `config:"enabled"`
+	Period  time.Duration `config:"period"`
+
+	// Maximum number of tuples kept in memory. The least recently seen
+	// tuples are evicted.
+	MaxEntries int `config:"max_entries"`
+
+	// Transactions published in passive mode: all, suspicious (flagged by
+	// the heuristics) or none.
+	Transactions string `config:"transactions"`
+}
+
+// heuristicsConfig configures the detection of DNS tunneling.
+type heuristicsConfig struct {
+	Enabled            bool     `config:"enabled"`
+	MaxEntropy         float64  `config:"max_entropy"`
+	MaxSubdomainLength int      `config:"max_subdomain_length"`
+	UnusualTypes       []string `config:"unusual_types"`
 }
 
+const (
+	passiveTransactionsAll        = "all"
+	passiveTransactionsSuspicious = "suspicious"
+	passiveTransactionsNone       = "none"
+)
+
 var (
 	defaultConfig = dnsConfig{
 		ProtocolCommon: config.ProtocolCommon{
 			TransactionTimeout: protos.DefaultTransactionExpiration,
 		},
+		Passive: passiveConfig{
+			Enabled:      false,
+			Period:       1 * time.Minute,
+			MaxEntries:   100000,
+			Transactions: passiveTransactionsSuspicious,
+		},
+		Heuristics: heuristicsConfig{
+			Enabled:            false,
+			MaxEntropy:         4.0,
+			MaxSubdomainLength: 50,
+			UnusualTypes:       []string{"NULL", "ANY", "AXFR", "IXFR", "HINFO", "WKS"},
+		},
 	}
 )
+
+var (
+	errInvalidPassivePeriod       = errors.New("passive period must be >= 1s")
+	errInvalidPassiveMaxEntries   = errors.New("passive max_entries must be > 0")
+	errInvalidPassiveTransactions = errors.New("passive transactions must be one of all, suspicious or none")
+)
+
+func (c *passiveConfig) Validate() error {
+	if c.Period < time.Second {
+		return errInvalidPassivePeriod
+	}
+	if c.MaxEntries <= 0 {
+		return errInvalidPassiveMaxEntries
+	}
+	switch c.Transactions {
+	case passiveTransactionsAll, passiveTransactionsSuspicious, passiveTransactionsNone:
+		return nil
+	}
+	return errInvalidPassiveTransactions
+}
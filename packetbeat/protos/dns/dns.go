@@ -40,13 +40,18 @@ type dnsPlugin struct {
 	transactionTimeout time.Duration
 
 	results publish.Transactions // Channel where results are pushed.
+
+	// Optional passive DNS aggregation and tunneling heuristics.
+	passive             *passiveDNS
+	passiveTransactions string
+	heuristics          *heuristics
 }
 
 var (
 	debugf = logp.MakeDebug("dns")
 )
 
-const maxDNSTupleRawSize = 16 + 16 + 2 + 2 + 4 + 1
+const maxDNSTupleRawSize = 16 + 16 + 2 + 2 + 4 + 1 + 5
 
 // Constants used to associate the DNS QR flag with a meaningful value.
 const (
@@ -98,9 +103,10 @@ type dnsTuple struct {
 	srcPort, dstPort uint16
 	transport        transport
 	id               uint16
+	tunnel           common.TunnelID
 
-	raw    hashableDNSTuple // Src_ip:Src_port:Dst_ip:Dst_port:Transport:Id
-	revRaw hashableDNSTuple // Dst_ip:Dst_port:Src_ip:Src_port:Transport:Id
+	raw    hashableDNSTuple // Src_ip:Src_port:Dst_ip:Dst_port:Transport:Id:Tunnel
+	revRaw hashableDNSTuple // Dst_ip:Dst_port:Src_ip:Src_port:Transport:Id:Tunnel
 }
 
 func dnsTupleFromIPPort(t *common.IPPortTuple, trans transport, id uint16) dnsTuple {
@@ -112,6 +118,7 @@ func dnsTupleFromIPPort(t *common.IPPortTuple, trans transport, id uint16) dnsTu
 		dstPort:   t.DstPort,
 		transport: trans,
 		id:        id,
+		tunnel:    t.Tunnel,
 	}
 	tuple.computeHashebles()
 
@@ -127,6 +134,7 @@ func (t dnsTuple) reverse() dnsTuple {
 		dstPort:   t.srcPort,
 		transport: t.transport,
 		id:        t.id,
+		tunnel:    t.tunnel,
 		raw:       t.revRaw,
 		revRaw:    t.raw,
 	}
@@ -139,6 +147,7 @@ func (t *dnsTuple) computeHashebles() {
 	copy(t.raw[34:36], []byte{byte(t.dstPort >> 8), byte(t.dstPort)})
 	copy(t.raw[36:38], []byte{byte(t.id >> 8), byte(t.id)})
 	t.raw[39] = byte(t.transport)
+	putTunnel(t.raw[40:45], t.tunnel)
 
 	copy(t.revRaw[0:16], t.dstIP)
 	copy(t.revRaw[16:18], []byte{byte(t.dstPort >> 8), byte(t.dstPort)})
@@ -146,6 +155,13 @@ func (t *dnsTuple) computeHashebles() {
 	copy(t.revRaw[34:36], []byte{byte(t.srcPort >> 8), byte(t.srcPort)})
 	copy(t.revRaw[36:38], []byte{byte(t.id >> 8), byte(t.id)})
 	t.revRaw[39] = byte(t.transport)
+	putTunnel(t.revRaw[40:45], t.tunnel)
+}
+
+func putTunnel(b []byte, tunnel common.TunnelID) {
+	b[0] = tunnel.Type
+	copy(b[1:5], []byte{byte(tunnel.ID >> 24), byte(tunnel.ID >> 16),
+		byte(tunnel.ID >> 8), byte(tunnel.ID)})
 }
 
 func (t *dnsTuple) String() string {
@@ -235,9 +251,31 @@ func (dns *dnsPlugin) init(results publish.Transactions, config *dnsConfig) erro
 
 	dns.results = results
 
+	if config.Passive.Enabled {
+		dns.passive = newPassiveDNS(&config.Passive, results)
+		dns.passiveTransactions = config.Passive.Transactions
+		if dns.passiveTransactions == passiveTransactionsSuspicious && !config.Heuristics.Enabled {
+			// without the heuristics no transaction would ever be published
+			logp.Warn("dns: passive transactions set to suspicious, but the " +
+				"heuristics are disabled. Publishing all the transactions.")
+			dns.passiveTransactions = passiveTransactionsAll
+		}
+		dns.passive.Start()
+	}
+	if config.Heuristics.Enabled {
+		dns.heuristics = newHeuristics(&config.Heuristics)
+	}
+
 	return nil
 }
 
+// Stop stops publishing the passive DNS tuples.
+func (dns *dnsPlugin) Stop() {
+	if dns.passive != nil {
+		dns.passive.Stop()
+	}
+}
+
 func (dns *dnsPlugin) setFromConfig(config *dnsConfig) error {
 	dns.ports = config.Ports
 	dns.sendRequest = config.SendRequest
@@ -362,6 +400,7 @@ func (dns *dnsPlugin) publishTransaction(t *dnsTransaction) {
 	event["transport"] = t.transport.String()
 	event["src"] = &t.src
 	event["dst"] = &t.dst
+	protos.AddTunnel(event, t.tuple.tunnel)
 	event["status"] = common.ERROR_STATUS
 	if len(t.notes) == 1 {
 		event["notes"] = t.notes[0]
@@ -421,9 +460,45 @@ func (dns *dnsPlugin) publishTransaction(t *dnsTransaction) {
 		}
 	}
 
+	suspicious := false
+	if dns.heuristics != nil {
+		suspicious = dns.addHeuristics(dnsEvent, t)
+	}
+
+	if dns.passive != nil {
+		if t.response != nil {
+			dns.passive.add(t.response.data, t.response.ts)
+		}
+
+		switch dns.passiveTransactions {
+		case passiveTransactionsNone:
+			return
+		case passiveTransactionsSuspicious:
+			if !suspicious {
+				return
+			}
+		}
+	}
+
 	dns.results.PublishTransaction(event)
 }
 
+// addHeuristics adds the tunneling heuristics of the question to the event,
+// and returns true if the question is suspicious.
+func (dns *dnsPlugin) addHeuristics(dnsEvent common.MapStr, t *dnsTransaction) bool {
+	msg := t.request
+	if msg == nil {
+		msg = t.response
+	}
+	if len(msg.data.Question) == 0 {
+		return false
+	}
+
+	fields, suspicious := dns.heuristics.check(msg.data.Question[0])
+	dnsEvent["heuristics"] = fields
+	return suspicious
+}
+
 func (dns *dnsPlugin) expireTransaction(t *dnsTransaction) {
 	t.notes = append(t.notes, noResponse.Error())
 	debugf("%s %s", noResponse.Error(), t.tuple.String())
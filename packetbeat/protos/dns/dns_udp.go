@@ -30,7 +30,7 @@ func (dns *dnsPlugin) ParseUDP(pkt *protos.Packet) {
 	dnsMsg := &dnsMessage{
 		ts:           pkt.Ts,
 		tuple:        pkt.Tuple,
-		cmdlineTuple: procs.ProcWatcher.FindProcessesTuple(&pkt.Tuple),
+		cmdlineTuple: procs.ProcWatcher.FindProcessesTuple(&pkt.Tuple, procs.TransportUDP),
 		data:         dnsPkt,
 		length:       packetSize,
 	}
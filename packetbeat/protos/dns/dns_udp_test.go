@@ -23,6 +23,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/elastic/beats/packetbeat/flows"
 	"github.com/elastic/beats/packetbeat/protos"
 	"github.com/elastic/beats/packetbeat/publish"
 
@@ -310,6 +311,30 @@ func TestParseUdp_duplicateRequests(t *testing.T) {
 	assert.Equal(t, duplicateQueryMsg.Error(), mapValue(t, m, "notes"))
 }
 
+// Verify that the same request sent in different tunnels creates two
+// transactions and that the reply is matched with the request of its tunnel.
+func TestParseUdp_tunnels(t *testing.T) {
+	dns := newDNS(testing.Verbose())
+	q := elasticA
+	tunnel := common.TunnelID{Type: uint8(flows.TunnelVXLAN), ID: 42}
+
+	tunneled := forward
+	tunneled.Tunnel = tunnel
+	dns.ParseUDP(newPacket(forward, q.request))
+	dns.ParseUDP(newPacket(tunneled, q.request))
+	assert.Equal(t, 2, dns.transactions.Size(), "There should be two transactions.")
+
+	tunneled = reverse
+	tunneled.Tunnel = tunnel
+	dns.ParseUDP(newPacket(tunneled, q.response))
+	assert.Equal(t, 1, dns.transactions.Size(), "There should be one transaction.")
+
+	m := expectResult(t, dns)
+	assert.Equal(t, common.OK_STATUS, mapValue(t, m, "status"))
+	assert.Equal(t, "vxlan", mapValue(t, m, "tunnel.type"))
+	assert.Equal(t, uint32(42), mapValue(t, m, "tunnel.id"))
+}
+
 // Verify that the request/response pair are parsed and that a result
 // is published.
 func TestParseUdp_requestResponse(t *testing.T) {
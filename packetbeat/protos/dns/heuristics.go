@@ -0,0 +1,118 @@
+package dns
+
+import (
+	"math"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+
+	mkdns "github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Flags set by the heuristics on the transactions of suspicious queries.
+const (
+	flagHighEntropy   = "high_entropy"
+	flagLongSubdomain = "long_subdomain"
+	flagUnusualType   = "unusual_type"
+)
+
+// minimum length of the subdomain for the entropy to be significant
+const minEntropyLength = 16
+
+// heuristics flags the queries that look like DNS tunneling or data
+// exfiltration: data encoded in long, random looking subdomains, or record
+// types rarely used by legitimate clients.
+type heuristics struct {
+	maxEntropy         float64
+	maxSubdomainLength int
+	unusualTypes       map[string]bool
+}
+
+func newHeuristics(config *heuristicsConfig) *heuristics {
+	h := &heuristics{
+		maxEntropy:         config.MaxEntropy,
+		maxSubdomainLength: config.MaxSubdomainLength,
+		unusualTypes:       map[string]bool{},
+	}
+	for _, typ := range config.UnusualTypes {
+		h.unusualTypes[strings.ToUpper(typ)] = true
+	}
+	return h
+}
+
+// check returns the heuristics fields of a question, and if the question is
+// suspicious.
+func (h *heuristics) check(q mkdns.Question) (common.MapStr, bool) {
+	subdomain := subdomainOf(q.Name)
+	entropy := labelEntropy(subdomain)
+
+	longest := 0
+	for _, label := range strings.Split(subdomain, ".") {
+		if len(label) > longest {
+			longest = len(label)
+		}
+	}
+
+	var flags []string
+	if len(subdomain) >= minEntropyLength && entropy > h.maxEntropy {
+		flags = append(flags, flagHighEntropy)
+	}
+	if len(subdomain) > h.maxSubdomainLength {
+		flags = append(flags, flagLongSubdomain)
+	}
+	if h.unusualTypes[dnsTypeToString(q.Qtype)] {
+		flags = append(flags, flagUnusualType)
+	}
+
+	fields := common.MapStr{
+		"entropy":          entropy,
+		"subdomain_length": len(subdomain),
+		"longest_label":    longest,
+		"suspicious":       len(flags) > 0,
+	}
+	if len(flags) > 0 {
+		fields["flags"] = flags
+	}
+	return fields, len(flags) > 0
+}
+
+// subdomainOf returns the part of a name below the registered domain (the
+// effective TLD plus one label), where tunneling tools encode the data.
+func subdomainOf(name string) string {
+	name = strings.TrimSuffix(name, ".")
+	domain, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil || len(domain) >= len(name) {
+		return ""
+	}
+	return strings.TrimSuffix(name[:len(name)-len(domain)], ".")
+}
+
+// labelEntropy returns the Shannon entropy of the characters of the labels,
+// in bits per character.
+func labelEntropy(s string) float64 {
+	s = strings.Replace(s, ".", "", -1)
+	if len(s) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		// names are case insensitive
+		c := s[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		counts[c]++
+	}
+
+	entropy := 0.0
+	n := float64(len(s))
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
@@ -0,0 +1,52 @@
+// +build !integration
+
+package dns
+
+import (
+	"testing"
+
+	mkdns "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubdomainOf(t *testing.T) {
+	assert.Equal(t, "", subdomainOf("elastic.co."))
+	assert.Equal(t, "www", subdomainOf("www.elastic.co."))
+	assert.Equal(t, "a.b", subdomainOf("a.b.example.co.uk."))
+	assert.Equal(t, "", subdomainOf("co.uk."))
+}
+
+func TestLabelEntropy(t *testing.T) {
+	assert.Equal(t, 0.0, labelEntropy(""))
+	assert.Equal(t, 0.0, labelEntropy("aaaa.AAAA"))
+	assert.Equal(t, 1.0, labelEntropy("abab"))
+	assert.Equal(t, 4.0, labelEntropy("0123456789abcdef"))
+}
+
+func newTestHeuristics() *heuristics {
+	config := defaultConfig.Heuristics
+	return newHeuristics(&config)
+}
+
+func TestHeuristicsCheck(t *testing.T) {
+	h := newTestHeuristics()
+
+	fields, suspicious := h.check(mkdns.Question{Name: "www.elastic.co.", Qtype: mkdns.TypeA})
+	assert.False(t, suspicious)
+	assert.Equal(t, 3, fields["subdomain_length"])
+	assert.Equal(t, 3, fields["longest_label"])
+	assert.Equal(t, false, fields["suspicious"])
+	assert.Nil(t, fields["flags"])
+
+	// base32 encoded data, as sent by the tunneling tools
+	name := "mfzxi5dfonzxizlfmzxs4ltsmvzxi3dbnzsws.nzqxi2lpnzsxgzlbmrsxe43ffvqw.tunnel.example.com."
+	fields, suspicious = h.check(mkdns.Question{Name: name, Qtype: mkdns.TypeTXT})
+	assert.True(t, suspicious)
+	assert.Equal(t, 73, fields["subdomain_length"])
+	assert.Equal(t, 37, fields["longest_label"])
+	assert.Equal(t, []string{flagHighEntropy, flagLongSubdomain}, fields["flags"])
+
+	fields, suspicious = h.check(mkdns.Question{Name: "etas.com.", Qtype: mkdns.TypeIXFR})
+	assert.True(t, suspicious)
+	assert.Equal(t, []string{flagUnusualType}, fields["flags"])
+}
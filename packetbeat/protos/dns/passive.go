@@ -0,0 +1,171 @@
+package dns
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/publish"
+
+	mkdns "github.com/miekg/dns"
+)
+
+// passiveDNS aggregates the unique (name, type, answer) tuples of the
+// responses, and publishes the tuples seen during the period. The number of
+// tuples kept in memory is bounded, the least recently seen tuples are
+// evicted first.
+type passiveDNS struct {
+	period     time.Duration
+	maxEntries int
+	results    publish.Transactions
+	done       chan struct{}
+	wg         sync.WaitGroup
+
+	mutex   sync.Mutex
+	entries map[passiveKey]*list.Element
+	lru     *list.List // front is the most recently seen
+	evicted int
+}
+
+type passiveKey struct {
+	name string
+	typ  string
+	data string
+}
+
+type passiveEntry struct {
+	key       passiveKey
+	firstSeen time.Time
+	lastSeen  time.Time
+	count     int
+
+	// seen since the last publish
+	updated bool
+}
+
+func newPassiveDNS(config *passiveConfig, results publish.Transactions) *passiveDNS {
+	return &passiveDNS{
+		period:     config.Period,
+		maxEntries: config.MaxEntries,
+		results:    results,
+		done:       make(chan struct{}),
+		entries:    map[passiveKey]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+// Start publishes the tuples every period, until Stop is called.
+func (p *passiveDNS) Start() {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.done:
+				return
+			case ts := <-ticker.C:
+				p.flush(ts)
+			}
+		}
+	}()
+}
+
+// Stop stops publishing the tuples periodically.
+func (p *passiveDNS) Stop() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+// add accounts the answers of a response.
+func (p *passiveDNS) add(msg *mkdns.Msg, ts time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, rr := range msg.Answer {
+		if rr == nil {
+			continue
+		}
+		data, _ := rrToMapStr(rr)["data"].(string)
+		hdr := rr.Header()
+		p.addTuple(passiveKey{
+			name: hdr.Name,
+			typ:  dnsTypeToString(hdr.Rrtype),
+			data: data,
+		}, ts)
+	}
+}
+
+func (p *passiveDNS) addTuple(key passiveKey, ts time.Time) {
+	if elem, exists := p.entries[key]; exists {
+		e := elem.Value.(*passiveEntry)
+		e.count++
+		e.updated = true
+		if ts.After(e.lastSeen) {
+			e.lastSeen = ts
+		}
+		p.lru.MoveToFront(elem)
+		return
+	}
+
+	if len(p.entries) >= p.maxEntries {
+		oldest := p.lru.Back()
+		delete(p.entries, oldest.Value.(*passiveEntry).key)
+		p.lru.Remove(oldest)
+		p.evicted++
+	}
+
+	p.entries[key] = p.lru.PushFront(&passiveEntry{
+		key:       key,
+		firstSeen: ts,
+		lastSeen:  ts,
+		count:     1,
+		updated:   true,
+	})
+}
+
+// flush publishes the tuples seen since the last flush.
+func (p *passiveDNS) flush(ts time.Time) {
+	var events []common.MapStr
+
+	p.mutex.Lock()
+	for elem := p.lru.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(*passiveEntry)
+		if !e.updated {
+			continue
+		}
+		e.updated = false
+		events = append(events, e.toEvent(ts))
+	}
+	evicted := p.evicted
+	p.evicted = 0
+	p.mutex.Unlock()
+
+	if evicted > 0 {
+		logp.Warn("dns: %d passive DNS tuples evicted, more than %d tuples seen",
+			evicted, p.maxEntries)
+	}
+
+	debugf("publishing %d passive DNS tuples", len(events))
+	for _, event := range events {
+		p.results.PublishTransaction(event)
+	}
+}
+
+func (e *passiveEntry) toEvent(ts time.Time) common.MapStr {
+	return common.MapStr{
+		"@timestamp": common.Time(ts),
+		"type":       "passive_dns",
+		"passive_dns": common.MapStr{
+			"name":       e.key.name,
+			"type":       e.key.typ,
+			"data":       e.key.data,
+			"first_seen": common.Time(e.firstSeen),
+			"last_seen":  common.Time(e.lastSeen),
+			"count":      e.count,
+		},
+	}
+}
@@ -0,0 +1,182 @@
+// +build !integration
+
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/publish"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPassiveDNS(maxEntries int) (*passiveDNS, *publish.ChanTransactions) {
+	results := &publish.ChanTransactions{Channel: make(chan common.MapStr, 100)}
+	config := defaultConfig.Passive
+	config.MaxEntries = maxEntries
+	return newPassiveDNS(&config, results), results
+}
+
+// passiveEvents returns the passive_dns fields of the published events.
+func passiveEvents(results *publish.ChanTransactions) []common.MapStr {
+	var events []common.MapStr
+	for {
+		select {
+		case event := <-results.Channel:
+			events = append(events, event["passive_dns"].(common.MapStr))
+		default:
+			return events
+		}
+	}
+}
+
+func TestPassiveDNSAggregate(t *testing.T) {
+	p, results := newTestPassiveDNS(100)
+	ts := time.Unix(1500000000, 0)
+
+	p.addTuple(passiveKey{"elastic.co.", "A", "54.148.130.30"}, ts)
+	p.addTuple(passiveKey{"elastic.co.", "A", "54.69.104.66"}, ts)
+	p.addTuple(passiveKey{"elastic.co.", "A", "54.148.130.30"}, ts.Add(time.Minute))
+	p.flush(ts.Add(time.Minute))
+
+	events := passiveEvents(results)
+	if assert.Len(t, events, 2) {
+		// most recently seen first
+		assert.Equal(t, common.MapStr{
+			"name":       "elastic.co.",
+			"type":       "A",
+			"data":       "54.148.130.30",
+			"first_seen": common.Time(ts),
+			"last_seen":  common.Time(ts.Add(time.Minute)),
+			"count":      2,
+		}, events[0])
+		assert.Equal(t, "54.69.104.66", events[1]["data"])
+		assert.Equal(t, 1, events[1]["count"])
+	}
+
+	// only the tuples seen since the last flush are published
+	p.addTuple(passiveKey{"elastic.co.", "A", "54.69.104.66"}, ts.Add(2*time.Minute))
+	p.flush(ts.Add(2 * time.Minute))
+
+	events = passiveEvents(results)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "54.69.104.66", events[0]["data"])
+		assert.Equal(t, common.Time(ts), events[0]["first_seen"])
+		assert.Equal(t, 2, events[0]["count"])
+	}
+}
+
+func TestPassiveDNSEviction(t *testing.T) {
+	logp.LogInit(logp.LOG_EMERG, "", false, true, []string{"dns"})
+	p, results := newTestPassiveDNS(2)
+	ts := time.Unix(1500000000, 0)
+
+	p.addTuple(passiveKey{"a.example.com.", "A", "192.0.2.1"}, ts)
+	p.addTuple(passiveKey{"b.example.com.", "A", "192.0.2.2"}, ts)
+	p.addTuple(passiveKey{"a.example.com.", "A", "192.0.2.1"}, ts)
+	p.addTuple(passiveKey{"c.example.com.", "A", "192.0.2.3"}, ts)
+	assert.Len(t, p.entries, 2)
+	assert.Equal(t, 1, p.evicted)
+	p.flush(ts)
+
+	// the least recently seen tuple is evicted
+	events := passiveEvents(results)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "c.example.com.", events[0]["name"])
+		assert.Equal(t, "a.example.com.", events[1]["name"])
+	}
+	assert.Equal(t, 0, p.evicted)
+}
+
+func TestPassiveDNSResponse(t *testing.T) {
+	p, results := newTestPassiveDNS(100)
+	for _, q := range []dnsTestMessage{elasticA, githubPtr} {
+		msg, err := decodeDNSData(transportUDP, q.response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.add(msg, time.Now())
+	}
+	p.flush(time.Now())
+
+	events := passiveEvents(results)
+	assert.Len(t, events, len(elasticA.answers)+len(githubPtr.answers))
+	for _, event := range events {
+		assert.NotEmpty(t, event["name"])
+		assert.NotEmpty(t, event["data"])
+	}
+}
+
+// Verify that only the suspicious transactions are published in passive mode.
+func TestPassiveDNSSuspiciousTransactions(t *testing.T) {
+	dns := newDNS(false)
+	dns.heuristics = newTestHeuristics()
+	dns.passive, _ = newTestPassiveDNS(100)
+	dns.passiveTransactions = passiveTransactionsSuspicious
+
+	dns.ParseUDP(newPacket(forward, zoneIxfr.request))
+	dns.ParseUDP(newPacket(reverse, zoneIxfr.response))
+	m := expectResult(t, dns)
+	assert.Equal(t, true, mapValue(t, m, "dns.heuristics.suspicious"))
+	assert.Equal(t, []string{flagUnusualType}, mapValue(t, m, "dns.heuristics.flags"))
+
+	dns.ParseUDP(newPacket(forward, elasticA.request))
+	dns.ParseUDP(newPacket(reverse, elasticA.response))
+	client := dns.results.(*publish.ChanTransactions)
+	assert.Empty(t, client.Channel)
+
+	// the SOA records of the zone transfer are aggregated
+	assert.Len(t, dns.passive.entries, 4)
+	soa := dns.passive.entries[passiveKey{"etas.com.", "SOA", "training2003p."}]
+	if assert.NotNil(t, soa) {
+		assert.Equal(t, 4, soa.Value.(*passiveEntry).count)
+	}
+}
+
+func TestPassiveDNSStop(t *testing.T) {
+	p, results := newTestPassiveDNS(100)
+	p.period = 10 * time.Millisecond
+	p.Start()
+
+	p.mutex.Lock()
+	p.addTuple(passiveKey{"elastic.co.", "A", "54.148.130.30"}, time.Now())
+	p.mutex.Unlock()
+	select {
+	case <-results.Channel:
+	case <-time.After(time.Second):
+		t.Fatal("tuples not published")
+	}
+
+	p.Stop()
+	p.mutex.Lock()
+	p.addTuple(passiveKey{"elastic.co.", "A", "54.148.130.30"}, time.Now())
+	p.mutex.Unlock()
+	time.Sleep(5 * p.period)
+	assert.Len(t, results.Channel, 0)
+}
+
+// Verify that all the transactions are published in passive mode if the
+// heuristics flagging the suspicious transactions are disabled.
+func TestPassiveDNSSuspiciousWithoutHeuristics(t *testing.T) {
+	logp.LogInit(logp.LOG_EMERG, "", false, true, []string{"dns"})
+	results := &publish.ChanTransactions{Channel: make(chan common.MapStr, 100)}
+	cfg, _ := common.NewConfigFrom(map[string]interface{}{
+		"ports":   []int{serverPort},
+		"passive": map[string]interface{}{"enabled": true},
+	})
+	plugin, err := New(false, results, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dns := plugin.(*dnsPlugin)
+	defer dns.Stop()
+	assert.Equal(t, passiveTransactionsAll, dns.passiveTransactions)
+
+	dns.ParseUDP(newPacket(forward, elasticA.request))
+	dns.ParseUDP(newPacket(reverse, elasticA.response))
+	m := expectResult(t, dns)
+	assert.Equal(t, common.OK_STATUS, mapValue(t, m, "status"))
+}
@@ -0,0 +1,87 @@
+package http
+
+import (
+	"bytes"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+var grpcStatusCodes = []string{
+	"OK",
+	"CANCELLED",
+	"UNKNOWN",
+	"INVALID_ARGUMENT",
+	"DEADLINE_EXCEEDED",
+	"NOT_FOUND",
+	"ALREADY_EXISTS",
+	"PERMISSION_DENIED",
+	"RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION",
+	"ABORTED",
+	"OUT_OF_RANGE",
+	"UNIMPLEMENTED",
+	"INTERNAL",
+	"UNAVAILABLE",
+	"DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+var contentTypeGRPC = []byte("application/grpc")
+
+func isGRPC(m *message) bool {
+	return bytes.HasPrefix(m.contentType, contentTypeGRPC)
+}
+
+func grpcStatusName(code int) string {
+	if code >= 0 && code < len(grpcStatusCodes) {
+		return grpcStatusCodes[code]
+	}
+	return "CODE_" + strconv.Itoa(code)
+}
+
+// splitGRPCPath splits the request path (/package.Service/Method) into the
+// service and method names.
+func splitGRPCPath(path string) (service, method string) {
+	path = strings.TrimPrefix(path, "/")
+	if i := strings.LastIndexByte(path, '/'); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
+
+// addGRPCFields adds the gRPC service, method and status to the event. The
+// status is reported in the trailers of the response, or in the headers of
+// responses without a body.
+func addGRPCFields(event common.MapStr, requ, resp *message) {
+	if !isGRPC(requ) {
+		return
+	}
+
+	grpc := common.MapStr{}
+	service, method := splitGRPCPath(string(requ.requestURI))
+	grpc["service"] = service
+	if method != "" {
+		grpc["method"] = method
+	}
+
+	if resp.hasGRPCStatus {
+		grpc["status_code"] = resp.grpcStatus
+		grpc["status"] = grpcStatusName(resp.grpcStatus)
+		if resp.grpcStatus != 0 {
+			event["status"] = common.ERROR_STATUS
+		}
+	}
+	if resp.grpcMessage != "" {
+		// the message is percent-encoded
+		msg, err := url.PathUnescape(resp.grpcMessage)
+		if err != nil {
+			msg = resp.grpcMessage
+		}
+		grpc["message"] = msg
+	}
+
+	event["grpc"] = grpc
+}
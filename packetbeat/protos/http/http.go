@@ -52,6 +52,12 @@ type httpConnectionData struct {
 	streams   [2]*stream
 	requests  messageList
 	responses messageList
+
+	// HTTP/2 connection state, set once the connection uses HTTP/2
+	h2 *http2Connection
+
+	// request of an accepted h2c upgrade, pending the switch to HTTP/2
+	upgrade *message
 }
 
 type messageList struct {
@@ -267,6 +273,19 @@ func (http *httpPlugin) doParse(
 		detailedf("Payload received: [%s]", pkt.Payload)
 	}
 
+	if conn.upgrade != nil {
+		http.upgradeToHTTP2(conn)
+	}
+	if conn.h2 == nil && isHTTP2Preface(conn.streams[dir], pkt.Payload) {
+		http.switchToHTTP2(conn, dir)
+
+		// frames buffered before the preface has been seen
+		http.parseHTTP2(conn, pkt.Ts, tcptuple, 1-dir, nil)
+	}
+	if conn.h2 != nil {
+		return http.parseHTTP2(conn, pkt.Ts, tcptuple, dir, pkt.Payload)
+	}
+
 	extraMsgSize := 0 // size of a "seen" packet for which we don't store the actual bytes
 
 	st := conn.streams[dir]
@@ -309,6 +328,12 @@ func (http *httpPlugin) doParse(
 
 		// and reset stream for next message
 		st.PrepareForNewMessage()
+
+		if conn.upgrade != nil {
+			// remaining data is HTTP/2
+			http.upgradeToHTTP2(conn)
+			return http.parseHTTP2(conn, pkt.Ts, tcptuple, dir, nil)
+		}
 	}
 
 	return conn
@@ -328,7 +353,7 @@ func (http *httpPlugin) ReceivedFin(tcptuple *common.TCPTuple, dir uint8,
 
 	debugf("Received FIN")
 	conn := getHTTPConnection(private)
-	if conn == nil {
+	if conn == nil || conn.h2 != nil {
 		return private
 	}
 
@@ -362,6 +387,14 @@ func (http *httpPlugin) GapInStream(tcptuple *common.TCPTuple, dir uint8,
 		return private, false
 	}
 
+	if conn.h2 != nil {
+		if !conn.h2.failed && !conn.h2.gap(dir, nbytes) {
+			// HPACK state is lost
+			conn.h2.fail(errHTTP2Gap)
+		}
+		return private, false
+	}
+
 	stream := conn.streams[dir]
 	if stream == nil || stream.message == nil {
 		// nothing to do
@@ -396,7 +429,7 @@ func (http *httpPlugin) handleHTTP(
 
 	m.tcpTuple = *tcptuple
 	m.direction = dir
-	m.cmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort())
+	m.cmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort(), procs.TransportTCP)
 	http.hideHeaders(m)
 
 	if m.isRequest {
@@ -434,6 +467,10 @@ func (http *httpPlugin) correlate(conn *httpConnectionData) {
 			debugf("HTTP transaction completed")
 		}
 		http.publishTransaction(trans)
+
+		if isHTTP2Upgrade(resp) {
+			conn.upgrade = requ
+		}
 	}
 }
 
@@ -494,6 +531,7 @@ func (http *httpPlugin) newTransaction(requ, resp *message) common.MapStr {
 		"src":          &src,
 		"dst":          &dst,
 	}
+	protos.AddTunnel(event, requ.tcpTuple.Tunnel)
 
 	if http.sendRequest {
 		event["request"] = string(http.cutMessageBody(requ))
@@ -0,0 +1,780 @@
+package http
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/packetbeat/procs"
+)
+
+// HTTP/2 frame types
+const (
+	http2FrameData         = 0x0
+	http2FrameHeaders      = 0x1
+	http2FramePriority     = 0x2
+	http2FrameRSTStream    = 0x3
+	http2FrameSettings     = 0x4
+	http2FramePushPromise  = 0x5
+	http2FramePing         = 0x6
+	http2FrameGoAway       = 0x7
+	http2FrameWindowUpdate = 0x8
+	http2FrameContinuation = 0x9
+)
+
+// HTTP/2 frame flags
+const (
+	http2FlagEndStream  = 0x1
+	http2FlagAck        = 0x1
+	http2FlagEndHeaders = 0x4
+	http2FlagPadded     = 0x8
+	http2FlagPriority   = 0x20
+)
+
+const (
+	http2FrameHeaderLen = 9
+
+	http2SettingHeaderTableSize = 0x1
+	http2DefaultHeaderTableSize = 4096
+
+	// maximum number of streams tracked per connection
+	http2MaxStreams = 1000
+)
+
+var http2Preface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+var (
+	errHTTP2Preface         = errors.New("invalid HTTP/2 connection preface")
+	errHTTP2FrameSize       = errors.New("invalid HTTP/2 frame size")
+	errHTTP2Padding         = errors.New("invalid HTTP/2 frame padding")
+	errHTTP2Continuation    = errors.New("HTTP/2 header block not continued")
+	errHTTP2FrameTooLarge   = errors.New("HTTP/2 frame too large")
+	errHTTP2HeadersTooLarge = errors.New("HTTP/2 header block too large")
+	errHTTP2UnexpectedFrame = errors.New("unexpected HTTP/2 CONTINUATION frame")
+	errHTTP2Gap             = errors.New("gap in HTTP/2 stream")
+)
+
+var http2ErrorCodes = map[uint32]string{
+	0x0: "NO_ERROR",
+	0x1: "PROTOCOL_ERROR",
+	0x2: "INTERNAL_ERROR",
+	0x3: "FLOW_CONTROL_ERROR",
+	0x4: "SETTINGS_TIMEOUT",
+	0x5: "STREAM_CLOSED",
+	0x6: "FRAME_SIZE_ERROR",
+	0x7: "REFUSED_STREAM",
+	0x8: "CANCEL",
+	0x9: "COMPRESSION_ERROR",
+	0xa: "CONNECT_ERROR",
+	0xb: "ENHANCE_YOUR_CALM",
+	0xc: "INADEQUATE_SECURITY",
+	0xd: "HTTP_1_1_REQUIRED",
+}
+
+type http2FrameHeader struct {
+	length   int
+	typ      uint8
+	flags    uint8
+	streamID uint32
+}
+
+// http2Connection is the state of a cleartext HTTP/2 connection (h2c).
+type http2Connection struct {
+	// TCP direction of the client, which sent the connection preface
+	clientDir uint8
+
+	// set when the connection can not be parsed anymore, e.g. because the
+	// HPACK state got lost.
+	failed bool
+
+	dirs    [2]http2Direction
+	streams map[uint32]*http2Stream
+}
+
+// http2Direction is the parser state of one direction of the connection.
+// Each direction uses its own HPACK decoding context.
+type http2Direction struct {
+	data          []byte
+	expectPreface bool
+	decoder       *hpack.Decoder
+
+	// HEADERS or PUSH_PROMISE frame waiting for CONTINUATION frames
+	headers     *http2FrameHeader
+	headersSize int
+	headerBlock []byte
+	promisedID  uint32
+
+	// number of bytes of a DATA frame still to be skipped. DATA frames
+	// larger than max_message_size are not buffered.
+	skip      int
+	skipFrame http2FrameHeader
+}
+
+// http2Stream is a request/response exchange. Each stream is published as a
+// separate transaction.
+type http2Stream struct {
+	id       uint32
+	ts       time.Time
+	request  *http2Message
+	response *http2Message
+	reset    bool
+}
+
+// http2Message collects the frames of a request or a response. Once complete,
+// it is turned into a message formatted like an HTTP/1 message, such that
+// the HTTP/1 transaction handling (body, redaction, raw message) applies.
+type http2Message struct {
+	msg     *message
+	header  []byte
+	body    []byte
+	bodyLen int
+	done    bool
+}
+
+func newHTTP2Connection(clientDir uint8, maxMessageSize int) *http2Connection {
+	h2 := &http2Connection{
+		clientDir: clientDir,
+		streams:   map[uint32]*http2Stream{},
+	}
+	for i := range h2.dirs {
+		decoder := hpack.NewDecoder(http2DefaultHeaderTableSize, nil)
+		decoder.SetMaxStringLength(maxMessageSize)
+		h2.dirs[i].decoder = decoder
+	}
+	h2.dirs[clientDir].expectPreface = true
+	return h2
+}
+
+// isHTTP2Preface checks if a client starts an HTTP/2 connection with prior
+// knowledge.
+func isHTTP2Preface(st *stream, payload []byte) bool {
+	if st != nil && len(st.data) > 0 {
+		return false
+	}
+	return bytes.HasPrefix(payload, http2Preface)
+}
+
+// isHTTP2Upgrade checks if the server accepted to upgrade the connection
+// to HTTP/2.
+func isHTTP2Upgrade(resp *message) bool {
+	return resp.statusCode == 101 && bytes.EqualFold(resp.upgrade, []byte("h2c"))
+}
+
+// switchToHTTP2 parses all data of the connection as HTTP/2 from now on.
+// Data already buffered by the HTTP/1 parser is handed over.
+func (http *httpPlugin) switchToHTTP2(conn *httpConnectionData, clientDir uint8) {
+	if isDebug {
+		debugf("Switching connection to HTTP/2")
+	}
+
+	h2 := newHTTP2Connection(clientDir, http.maxMessageSize)
+	for dir, st := range conn.streams {
+		if st != nil && len(st.data) > 0 {
+			h2.dirs[dir].data = append([]byte(nil), st.data...)
+		}
+		conn.streams[dir] = nil
+	}
+	conn.h2 = h2
+}
+
+// upgradeToHTTP2 switches the connection to HTTP/2 after a successful h2c
+// upgrade. The response to the upgrade request is sent on stream 1.
+func (http *httpPlugin) upgradeToHTTP2(conn *httpConnectionData) {
+	requ := conn.upgrade
+	conn.upgrade = nil
+
+	http.switchToHTTP2(conn, requ.direction)
+	conn.h2.streams[1] = &http2Stream{
+		id:      1,
+		ts:      requ.ts,
+		request: &http2Message{msg: requ, done: true},
+	}
+}
+
+func (http *httpPlugin) parseHTTP2(
+	conn *httpConnectionData,
+	ts time.Time,
+	tcptuple *common.TCPTuple,
+	dir uint8,
+	payload []byte,
+) *httpConnectionData {
+	h2 := conn.h2
+	if h2.failed {
+		return conn
+	}
+
+	d := &h2.dirs[dir]
+	d.data = append(d.data, payload...)
+	for {
+		if d.skip > 0 {
+			n := d.skip
+			if n > len(d.data) {
+				n = len(d.data)
+			}
+			d.data = d.data[n:]
+			d.skip -= n
+			if d.skip > 0 {
+				break
+			}
+
+			hdr := d.skipFrame
+			if err := http.onHTTP2Frame(h2, ts, tcptuple, dir, &hdr, nil); err != nil {
+				h2.fail(err)
+				return conn
+			}
+		}
+
+		if d.expectPreface {
+			if len(d.data) < len(http2Preface) {
+				if !bytes.HasPrefix(http2Preface, d.data) {
+					h2.fail(errHTTP2Preface)
+					return conn
+				}
+				break
+			}
+			if !bytes.HasPrefix(d.data, http2Preface) {
+				h2.fail(errHTTP2Preface)
+				return conn
+			}
+			d.data = d.data[len(http2Preface):]
+			d.expectPreface = false
+		}
+
+		if len(d.data) < http2FrameHeaderLen {
+			break
+		}
+
+		hdr := parseHTTP2FrameHeader(d.data)
+		if hdr.length > http.maxMessageSize {
+			if hdr.typ != http2FrameData {
+				h2.fail(errHTTP2FrameTooLarge)
+				return conn
+			}
+
+			if isDebug {
+				debugf("Skipping HTTP/2 DATA frame of %d bytes", hdr.length)
+			}
+			d.data = d.data[http2FrameHeaderLen:]
+			d.skip = hdr.length
+			d.skipFrame = hdr
+			continue
+		}
+
+		end := http2FrameHeaderLen + hdr.length
+		if len(d.data) < end {
+			// wait for more data
+			break
+		}
+
+		frame := d.data[http2FrameHeaderLen:end]
+		d.data = d.data[end:]
+		if err := http.onHTTP2Frame(h2, ts, tcptuple, dir, &hdr, frame); err != nil {
+			h2.fail(err)
+			return conn
+		}
+	}
+
+	if len(d.data) == 0 {
+		d.data = nil
+	}
+	return conn
+}
+
+func parseHTTP2FrameHeader(data []byte) http2FrameHeader {
+	return http2FrameHeader{
+		length:   int(data[0])<<16 | int(data[1])<<8 | int(data[2]),
+		typ:      data[3],
+		flags:    data[4],
+		streamID: binary.BigEndian.Uint32(data[5:]) & 0x7fffffff,
+	}
+}
+
+// fail stops parsing the connection. Without the HPACK state of the
+// connection, no further headers can be decoded.
+func (h2 *http2Connection) fail(err error) {
+	if isDebug {
+		debugf("Ignore HTTP/2 connection: %v", err)
+	}
+	h2.failed = true
+	h2.streams = nil
+	for i := range h2.dirs {
+		h2.dirs[i] = http2Direction{}
+	}
+}
+
+// gap updates the parser state after nbytes have been lost. It returns false
+// if the parser can not recover from the gap.
+func (h2 *http2Connection) gap(dir uint8, nbytes int) bool {
+	d := &h2.dirs[dir]
+	if d.skip == 0 || len(d.data) > 0 || nbytes > d.skip {
+		return false
+	}
+
+	// lost bytes are part of a DATA frame being skipped anyway
+	d.skip -= nbytes
+	return true
+}
+
+func (http *httpPlugin) onHTTP2Frame(
+	h2 *http2Connection,
+	ts time.Time,
+	tcptuple *common.TCPTuple,
+	dir uint8,
+	hdr *http2FrameHeader,
+	payload []byte,
+) error {
+	d := &h2.dirs[dir]
+	if d.headers != nil && hdr.typ != http2FrameContinuation {
+		return errHTTP2Continuation
+	}
+
+	switch hdr.typ {
+	case http2FrameData:
+		return http.onHTTP2Data(h2, dir, hdr, payload)
+
+	case http2FrameHeaders, http2FramePushPromise:
+		block, promisedID, err := http2HeaderBlock(hdr, payload)
+		if err != nil {
+			return err
+		}
+		tmp := *hdr
+		d.headers = &tmp
+		d.headersSize = http2FrameHeaderLen + hdr.length
+		d.headerBlock = append(d.headerBlock[:0], block...)
+		d.promisedID = promisedID
+
+	case http2FrameContinuation:
+		if d.headers == nil || d.headers.streamID != hdr.streamID {
+			return errHTTP2UnexpectedFrame
+		}
+		d.headersSize += http2FrameHeaderLen + hdr.length
+		if d.headersSize > http.maxMessageSize {
+			return errHTTP2HeadersTooLarge
+		}
+		d.headerBlock = append(d.headerBlock, payload...)
+
+	case http2FrameRSTStream:
+		if len(payload) != 4 {
+			return errHTTP2FrameSize
+		}
+		http.onHTTP2Reset(h2, ts, tcptuple, dir, hdr.streamID, binary.BigEndian.Uint32(payload))
+		return nil
+
+	case http2FrameSettings:
+		if hdr.flags&http2FlagAck != 0 {
+			return nil
+		}
+		return h2.onSettings(dir, payload)
+
+	default:
+		// PRIORITY, PING, GOAWAY, WINDOW_UPDATE and unknown frames carry no
+		// information about the transactions
+		return nil
+	}
+
+	if hdr.flags&http2FlagEndHeaders == 0 {
+		// wait for CONTINUATION frames
+		return nil
+	}
+
+	fields, err := d.decoder.DecodeFull(d.headerBlock)
+	if err != nil {
+		return err
+	}
+	headers := d.headers
+	d.headers = nil
+	http.onHTTP2Headers(h2, ts, tcptuple, dir, headers, d.promisedID, d.headersSize, fields)
+	return nil
+}
+
+// http2HeaderBlock returns the header block fragment of a HEADERS or
+// PUSH_PROMISE frame.
+func http2HeaderBlock(hdr *http2FrameHeader, payload []byte) ([]byte, uint32, error) {
+	var err error
+	if hdr.flags&http2FlagPadded != 0 {
+		if payload, err = http2StripPadding(payload); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var promisedID uint32
+	switch hdr.typ {
+	case http2FrameHeaders:
+		if hdr.flags&http2FlagPriority != 0 {
+			// stream dependency and weight
+			if len(payload) < 5 {
+				return nil, 0, errHTTP2FrameSize
+			}
+			payload = payload[5:]
+		}
+	case http2FramePushPromise:
+		if len(payload) < 4 {
+			return nil, 0, errHTTP2FrameSize
+		}
+		promisedID = binary.BigEndian.Uint32(payload) & 0x7fffffff
+		payload = payload[4:]
+	}
+	return payload, promisedID, nil
+}
+
+func http2StripPadding(payload []byte) ([]byte, error) {
+	if len(payload) == 0 {
+		return nil, errHTTP2Padding
+	}
+	padding := int(payload[0])
+	if padding >= len(payload) {
+		return nil, errHTTP2Padding
+	}
+	return payload[1 : len(payload)-padding], nil
+}
+
+func (h2 *http2Connection) onSettings(dir uint8, payload []byte) error {
+	if len(payload)%6 != 0 {
+		return errHTTP2FrameSize
+	}
+
+	for ; len(payload) > 0; payload = payload[6:] {
+		id := binary.BigEndian.Uint16(payload)
+		value := binary.BigEndian.Uint32(payload[2:])
+		if id == http2SettingHeaderTableSize {
+			// the setting limits the dynamic table of the peer's encoder
+			h2.dirs[1-dir].decoder.SetAllowedMaxDynamicTableSize(value)
+		}
+	}
+	return nil
+}
+
+func (h2 *http2Connection) newStream(id uint32, ts time.Time, timeout time.Duration) *http2Stream {
+	if s := h2.streams[id]; s != nil {
+		return s
+	}
+	if len(h2.streams) >= http2MaxStreams {
+		h2.expireStreams(ts, timeout)
+	}
+	if id == 0 || len(h2.streams) >= http2MaxStreams {
+		if isDebug {
+			debugf("Ignoring HTTP/2 stream %d", id)
+		}
+		return nil
+	}
+
+	s := &http2Stream{id: id, ts: ts}
+	h2.streams[id] = s
+	return s
+}
+
+// expireStreams removes the streams started more than the transaction timeout
+// before ts, e.g. because their response has been lost.
+func (h2 *http2Connection) expireStreams(ts time.Time, timeout time.Duration) {
+	for id, s := range h2.streams {
+		if ts.Sub(s.ts) > timeout {
+			if isDebug {
+				debugf("HTTP/2 stream %d timed out", id)
+			}
+			delete(h2.streams, id)
+		}
+	}
+}
+
+func (http *httpPlugin) newHTTP2Message(
+	ts time.Time,
+	tcptuple *common.TCPTuple,
+	dir uint8,
+	isRequest bool,
+	streamID uint32,
+) *http2Message {
+	return &http2Message{
+		msg: &message{
+			ts:           ts,
+			isRequest:    isRequest,
+			version:      version{major: 2},
+			tcpTuple:     *tcptuple,
+			cmdlineTuple: procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort(), procs.TransportTCP),
+			direction:    dir,
+			streamID:     streamID,
+		},
+	}
+}
+
+func (http *httpPlugin) onHTTP2Headers(
+	h2 *http2Connection,
+	ts time.Time,
+	tcptuple *common.TCPTuple,
+	dir uint8,
+	hdr *http2FrameHeader,
+	promisedID uint32,
+	size int,
+	fields []hpack.HeaderField,
+) {
+	if hdr.typ == http2FramePushPromise {
+		// server push. The promised request is sent by the server, on
+		// behalf of the client.
+		s := h2.newStream(promisedID, ts, http.transactionTimeout)
+		if s == nil || s.request != nil {
+			return
+		}
+		s.request = http.newHTTP2Message(ts, tcptuple, 1-dir, true, promisedID)
+		http.addHTTP2Headers(s.request, fields)
+		s.request.done = true
+		return
+	}
+
+	var m *http2Message
+	s := h2.streams[hdr.streamID]
+	if dir == h2.clientDir {
+		if s == nil {
+			if s = h2.newStream(hdr.streamID, ts, http.transactionTimeout); s == nil {
+				return
+			}
+		}
+		if s.request == nil {
+			s.request = http.newHTTP2Message(ts, tcptuple, dir, true, s.id)
+		}
+		m = s.request
+	} else {
+		if s == nil || s.request == nil {
+			debugf("Response from unknown HTTP/2 stream. Ignoring.")
+			unmatchedResponses.Add(1)
+			return
+		}
+		if s.response == nil {
+			status := http2Status(fields)
+			if status >= 100 && status < 200 {
+				// ignore informational responses
+				return
+			}
+			s.response = http.newHTTP2Message(ts, tcptuple, dir, false, s.id)
+		}
+		m = s.response
+	}
+
+	m.msg.size += uint64(size)
+	http.addHTTP2Headers(m, fields)
+	if hdr.flags&http2FlagEndStream != 0 {
+		http.endHTTP2Message(h2, s, m)
+	}
+}
+
+func http2Status(fields []hpack.HeaderField) int {
+	for _, f := range fields {
+		if f.Name == ":status" {
+			status, _ := strconv.Atoi(f.Value)
+			return status
+		}
+	}
+	return 0
+}
+
+// addHTTP2Headers adds the decoded headers (or trailers) to the message.
+func (http *httpPlugin) addHTTP2Headers(m *http2Message, fields []hpack.HeaderField) {
+	msg := m.msg
+	config := &http.parserConfig
+
+	for _, f := range fields {
+		name := f.Name
+		value := common.NetString(f.Value)
+
+		switch name {
+		case ":method":
+			msg.method = value
+		case ":path":
+			msg.requestURI = value
+		case ":status":
+			code, _ := strconv.Atoi(f.Value)
+			msg.statusCode = uint16(code)
+			msg.statusPhrase = common.NetString(nethttp.StatusText(code))
+		case ":authority":
+			name = "host"
+		case "content-length":
+			msg.contentLength, _ = strconv.Atoi(f.Value)
+			msg.hasContentLength = true
+		case "content-type":
+			msg.contentType = value
+		case "grpc-status":
+			code, err := strconv.Atoi(f.Value)
+			msg.grpcStatus = code
+			msg.hasGRPCStatus = err == nil
+		case "grpc-message":
+			msg.grpcMessage = f.Value
+		}
+		if name[0] == ':' {
+			continue
+		}
+
+		m.header = append(m.header, name...)
+		m.header = append(m.header, ": "...)
+		m.header = append(m.header, f.Value...)
+		m.header = append(m.header, "\r\n"...)
+
+		if len(config.realIPHeader) > 0 && name == config.realIPHeader && len(msg.realIP) == 0 {
+			if ips := bytes.SplitN(value, []byte{','}, 2); len(ips) > 0 {
+				msg.realIP = trim(ips[0])
+			}
+		}
+
+		if !config.sendHeaders {
+			continue
+		}
+		if !config.sendAllHeaders && !config.headersWhitelist[name] {
+			continue
+		}
+		if msg.headers == nil {
+			msg.headers = map[string]common.NetString{}
+		}
+		if val, ok := msg.headers[name]; ok {
+			msg.headers[name] = common.NetString(fmt.Sprintf("%s, %s", val, value))
+		} else {
+			msg.headers[name] = value
+		}
+	}
+}
+
+func (http *httpPlugin) onHTTP2Data(
+	h2 *http2Connection,
+	dir uint8,
+	hdr *http2FrameHeader,
+	payload []byte,
+) error {
+	s := h2.streams[hdr.streamID]
+	if s == nil {
+		return nil
+	}
+	m := s.request
+	if dir != h2.clientDir {
+		m = s.response
+	}
+	if m == nil || m.done {
+		return nil
+	}
+
+	// payload is nil if the frame has been skipped
+	data, bodyLen := payload, hdr.length
+	if payload != nil && hdr.flags&http2FlagPadded != 0 {
+		var err error
+		if data, err = http2StripPadding(payload); err != nil {
+			return err
+		}
+		bodyLen = len(data)
+	}
+
+	m.msg.size += uint64(http2FrameHeaderLen + hdr.length)
+	m.bodyLen += bodyLen
+	if room := http.maxMessageSize - len(m.body); room > 0 {
+		if len(data) > room {
+			data = data[:room]
+		}
+		m.body = append(m.body, data...)
+	}
+
+	if hdr.flags&http2FlagEndStream != 0 {
+		http.endHTTP2Message(h2, s, m)
+	}
+	return nil
+}
+
+func (http *httpPlugin) onHTTP2Reset(
+	h2 *http2Connection,
+	ts time.Time,
+	tcptuple *common.TCPTuple,
+	dir uint8,
+	id uint32,
+	code uint32,
+) {
+	s := h2.streams[id]
+	if s == nil {
+		return
+	}
+	if s.request == nil {
+		delete(h2.streams, id)
+		return
+	}
+
+	peer := "client"
+	if dir != h2.clientDir {
+		peer = "server"
+	}
+	if s.response == nil {
+		s.response = http.newHTTP2Message(ts, tcptuple, 1-h2.clientDir, false, id)
+	}
+	resp := s.response.msg
+	resp.notes = append(resp.notes,
+		fmt.Sprintf("Stream reset by %s (%s)", peer, http2ErrorName(code)))
+
+	s.reset = true
+	http.publishHTTP2Stream(h2, s)
+}
+
+func http2ErrorName(code uint32) string {
+	if name, found := http2ErrorCodes[code]; found {
+		return name
+	}
+	return fmt.Sprintf("0x%x", code)
+}
+
+// endHTTP2Message marks the message as complete. The stream is published
+// once the response is complete.
+func (http *httpPlugin) endHTTP2Message(h2 *http2Connection, s *http2Stream, m *http2Message) {
+	m.done = true
+	if m == s.response {
+		http.publishHTTP2Stream(h2, s)
+	}
+}
+
+func (http *httpPlugin) publishHTTP2Stream(h2 *http2Connection, s *http2Stream) {
+	delete(h2.streams, s.id)
+
+	requ := s.request.finish()
+	resp := s.response.finish()
+	http.hideHeaders(requ)
+
+	if isDebug {
+		debugf("HTTP/2 transaction completed (stream %d)", s.id)
+	}
+
+	event := http.newTransaction(requ, resp)
+	event["http"].(common.MapStr)["stream_id"] = s.id
+	if s.reset {
+		event["status"] = common.ERROR_STATUS
+	}
+	addGRPCFields(event, requ, resp)
+	http.publishTransaction(event)
+}
+
+// finish formats the message like an HTTP/1 message.
+func (m *http2Message) finish() *message {
+	msg := m.msg
+	if msg.raw != nil {
+		// HTTP/1 request of an upgraded connection
+		return msg
+	}
+
+	if !msg.hasContentLength {
+		msg.contentLength = m.bodyLen
+	}
+
+	var line string
+	if msg.isRequest {
+		line = fmt.Sprintf("%s %s HTTP/2\r\n", msg.method, msg.requestURI)
+	} else {
+		line = fmt.Sprintf("HTTP/2 %d\r\n", msg.statusCode)
+	}
+
+	raw := make([]byte, 0, len(line)+len(m.header)+2+len(m.body))
+	raw = append(raw, line...)
+	msg.headerOffset = len(raw)
+	raw = append(raw, m.header...)
+	raw = append(raw, "\r\n"...)
+	msg.bodyOffset = len(raw)
+	raw = append(raw, m.body...)
+
+	msg.raw = raw
+	msg.end = len(raw)
+	return msg
+}
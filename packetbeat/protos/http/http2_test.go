@@ -0,0 +1,537 @@
+// +build !integration
+
+package http
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/protos"
+	"github.com/elastic/beats/packetbeat/publish"
+)
+
+const (
+	h2Client = 0
+	h2Server = 1
+)
+
+// h2Peer encodes the frames sent by one side of an HTTP/2 connection.
+type h2Peer struct {
+	buf     bytes.Buffer
+	encoder *hpack.Encoder
+}
+
+func newH2Peer() *h2Peer {
+	p := &h2Peer{}
+	p.encoder = hpack.NewEncoder(&p.buf)
+	return p
+}
+
+func (p *h2Peer) headerBlock(fields ...string) []byte {
+	p.buf.Reset()
+	for i := 0; i < len(fields); i += 2 {
+		p.encoder.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+	}
+	return append([]byte(nil), p.buf.Bytes()...)
+}
+
+func (p *h2Peer) headers(streamID uint32, flags uint8, fields ...string) []byte {
+	return h2Frame(http2FrameHeaders, flags|http2FlagEndHeaders, streamID, p.headerBlock(fields...))
+}
+
+func h2Frame(typ, flags uint8, streamID uint32, payload []byte) []byte {
+	frame := make([]byte, http2FrameHeaderLen, http2FrameHeaderLen+len(payload))
+	frame[0] = byte(len(payload) >> 16)
+	frame[1] = byte(len(payload) >> 8)
+	frame[2] = byte(len(payload))
+	frame[3] = typ
+	frame[4] = flags
+	binary.BigEndian.PutUint32(frame[5:], streamID)
+	return append(frame, payload...)
+}
+
+func h2Data(streamID uint32, flags uint8, data string) []byte {
+	return h2Frame(http2FrameData, flags, streamID, []byte(data))
+}
+
+func h2Settings(settings ...uint32) []byte {
+	var payload []byte
+	for i := 0; i < len(settings); i += 2 {
+		var tmp [6]byte
+		binary.BigEndian.PutUint16(tmp[:], uint16(settings[i]))
+		binary.BigEndian.PutUint32(tmp[2:], settings[i+1])
+		payload = append(payload, tmp[:]...)
+	}
+	return h2Frame(http2FrameSettings, 0, 0, payload)
+}
+
+func h2Reset(streamID uint32, code uint32) []byte {
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], code)
+	return h2Frame(http2FrameRSTStream, 0, streamID, payload[:])
+}
+
+func h2Concat(frames ...[]byte) []byte {
+	return bytes.Join(frames, nil)
+}
+
+func http2ModForTests(config *httpConfig) *httpPlugin {
+	if config == nil {
+		tmp := defaultConfig
+		config = &tmp
+	}
+
+	var http httpPlugin
+	results := &publish.ChanTransactions{Channel: make(chan common.MapStr, 10)}
+	http.init(results, config)
+	return &http
+}
+
+type h2Packet struct {
+	dir  uint8
+	data []byte
+}
+
+func h2Replay(http *httpPlugin, private protos.ProtocolData, packets ...h2Packet) protos.ProtocolData {
+	tcptuple := testCreateTCPTuple()
+	ts := time.Now()
+	for i, p := range packets {
+		pkt := &protos.Packet{
+			Ts:      ts.Add(time.Duration(i) * time.Millisecond),
+			Payload: p.data,
+		}
+		private = http.Parse(pkt, tcptuple, p.dir, private)
+	}
+	return private
+}
+
+func expectNoHTTPTransaction(t *testing.T, http *httpPlugin) {
+	client := http.results.(*publish.ChanTransactions)
+	select {
+	case trans := <-client.Channel:
+		t.Errorf("Unexpected transaction: %v", trans)
+	default:
+	}
+}
+
+func TestHttp2_simpleTransaction(t *testing.T) {
+	if testing.Verbose() {
+		logp.LogInit(logp.LOG_DEBUG, "", false, true, []string{"http"})
+	}
+
+	config := defaultConfig
+	config.SendAllHeaders = true
+	http := http2ModForTests(&config)
+	client, server := newH2Peer(), newH2Peer()
+
+	requ := h2Concat(http2Preface, h2Settings(),
+		client.headers(1, http2FlagEndStream,
+			":method", "GET", ":scheme", "http", ":path", "/index.html?a=1",
+			":authority", "example.com", "user-agent", "curl/7.54"))
+	resp := h2Concat(h2Settings(),
+		server.headers(1, 0,
+			":status", "404", "content-type", "text/plain", "server", "test"),
+		h2Data(1, 0, "not "),
+		h2Data(1, http2FlagEndStream, "found"))
+
+	h2Replay(http, nil, h2Packet{h2Client, requ}, h2Packet{h2Server, resp})
+
+	trans := expectTransaction(t, http)
+	expectNoHTTPTransaction(t, http)
+	if trans == nil {
+		return
+	}
+
+	assert.Equal(t, "http", trans["type"])
+	assert.Equal(t, common.ERROR_STATUS, trans["status"])
+	assert.Equal(t, common.NetString("GET"), trans["method"])
+	assert.Equal(t, "/index.html", trans["path"])
+	assert.Equal(t, int32(1), trans["responsetime"])
+
+	details := trans["http"].(common.MapStr)
+	assert.Equal(t, uint32(1), details["stream_id"])
+
+	request := details["request"].(common.MapStr)
+	assert.Equal(t, "a=1", request["params"])
+	headers := request["headers"].(map[string]interface{})
+	assert.Equal(t, common.NetString("example.com"), headers["host"])
+	assert.Equal(t, common.NetString("curl/7.54"), headers["user-agent"])
+
+	response := details["response"].(common.MapStr)
+	assert.Equal(t, uint16(404), response["code"])
+	assert.Equal(t, common.NetString("Not Found"), response["phrase"])
+	headers = response["headers"].(map[string]interface{})
+	assert.Equal(t, 9, headers["content-length"])
+	assert.Equal(t, common.NetString("text/plain"), headers["content-type"])
+	assert.Equal(t, common.NetString("test"), headers["server"])
+	assert.NotContains(t, trans, "grpc")
+}
+
+func TestHttp2_grpc(t *testing.T) {
+	config := defaultConfig
+	config.SendResponse = true
+	config.IncludeBodyFor = []string{"application/grpc"}
+	http := http2ModForTests(&config)
+	client, server := newH2Peer(), newH2Peer()
+
+	requ := h2Concat(http2Preface, h2Settings(),
+		client.headers(1, 0,
+			":method", "POST", ":scheme", "http", ":path", "/helloworld.Greeter/SayHello",
+			":authority", "greeter:50051", "content-type", "application/grpc",
+			"te", "trailers"),
+		h2Data(1, http2FlagEndStream, "\x00\x00\x00\x00\x03abc"))
+	resp := h2Concat(h2Settings(),
+		server.headers(1, 0, ":status", "200", "content-type", "application/grpc"),
+		h2Data(1, 0, "\x00\x00\x00\x00\x00"),
+		server.headers(1, http2FlagEndStream,
+			"grpc-status", "5", "grpc-message", "user%20not%20found"))
+
+	h2Replay(http, nil, h2Packet{h2Client, requ}, h2Packet{h2Server, resp})
+
+	trans := expectTransaction(t, http)
+	if trans == nil {
+		return
+	}
+
+	assert.Equal(t, common.ERROR_STATUS, trans["status"])
+	assert.Equal(t, common.NetString("POST"), trans["method"])
+	assert.Equal(t, "/helloworld.Greeter/SayHello", trans["path"])
+	assert.Equal(t, common.MapStr{
+		"service":     "helloworld.Greeter",
+		"method":      "SayHello",
+		"status_code": 5,
+		"status":      "NOT_FOUND",
+		"message":     "user not found",
+	}, trans["grpc"])
+
+	response := trans["http"].(common.MapStr)["response"].(common.MapStr)
+	assert.Equal(t, uint16(200), response["code"])
+	assert.Equal(t, "\x00\x00\x00\x00\x00", response["body"])
+	assert.Equal(t, "HTTP/2 200\r\n"+
+		"content-type: application/grpc\r\n"+
+		"grpc-status: 5\r\n"+
+		"grpc-message: user%20not%20found\r\n"+
+		"\r\n"+
+		"\x00\x00\x00\x00\x00", trans["response"])
+}
+
+func TestHttp2_grpcTrailersOnly(t *testing.T) {
+	http := http2ModForTests(nil)
+	client, server := newH2Peer(), newH2Peer()
+
+	requ := h2Concat(http2Preface,
+		client.headers(1, 0,
+			":method", "POST", ":path", "/helloworld.Greeter/SayHello",
+			"content-type", "application/grpc+proto"),
+		h2Data(1, http2FlagEndStream, "\x00\x00\x00\x00\x00"))
+	resp := server.headers(1, http2FlagEndStream,
+		":status", "200", "content-type", "application/grpc", "grpc-status", "0")
+
+	h2Replay(http, nil, h2Packet{h2Client, requ}, h2Packet{h2Server, resp})
+
+	trans := expectTransaction(t, http)
+	if trans == nil {
+		return
+	}
+	assert.Equal(t, common.OK_STATUS, trans["status"])
+	assert.Equal(t, common.MapStr{
+		"service":     "helloworld.Greeter",
+		"method":      "SayHello",
+		"status_code": 0,
+		"status":      "OK",
+	}, trans["grpc"])
+}
+
+func TestHttp2_multiplexedStreams(t *testing.T) {
+	http := http2ModForTests(nil)
+	client, server := newH2Peer(), newH2Peer()
+
+	request := func(id uint32, path string) []byte {
+		return client.headers(id, http2FlagEndStream,
+			":method", "GET", ":scheme", "http", ":path", path,
+			":authority", "example.com")
+	}
+	response := func(id uint32, status string) []byte {
+		return server.headers(id, 0, ":status", status, "server", "test")
+	}
+
+	// the response of stream 3 completes first, stream 5 is never answered
+	private := h2Replay(http, nil,
+		h2Packet{h2Client, h2Concat(http2Preface, request(1, "/a"), request(3, "/b"))},
+		h2Packet{h2Server, h2Concat(response(1, "200"), response(3, "201"))},
+		h2Packet{h2Client, request(5, "/c")},
+		h2Packet{h2Server, h2Data(3, http2FlagEndStream, "b")},
+		h2Packet{h2Server, h2Data(1, http2FlagEndStream, "a")})
+
+	for _, expected := range []struct {
+		path     string
+		code     uint16
+		streamID uint32
+	}{
+		{"/b", 201, 3},
+		{"/a", 200, 1},
+	} {
+		trans := expectTransaction(t, http)
+		if trans == nil {
+			return
+		}
+		details := trans["http"].(common.MapStr)
+		assert.Equal(t, expected.path, trans["path"])
+		assert.Equal(t, expected.code, details["response"].(common.MapStr)["code"])
+		assert.Equal(t, expected.streamID, details["stream_id"])
+	}
+	expectNoHTTPTransaction(t, http)
+
+	conn := private.(*httpConnectionData)
+	assert.Len(t, conn.h2.streams, 1)
+	assert.Contains(t, conn.h2.streams, uint32(5))
+}
+
+func TestHttp2_continuationAndPadding(t *testing.T) {
+	config := defaultConfig
+	config.SendHeaders = []string{"x-long"}
+	http := http2ModForTests(&config)
+	client, server := newH2Peer(), newH2Peer()
+
+	long := string(bytes.Repeat([]byte("x"), 100))
+	block := client.headerBlock(
+		":method", "GET", ":path", "/", "x-long", long)
+
+	// padded HEADERS frame with priority, continued by two CONTINUATION frames
+	first := append([]byte{3}, 0, 0, 0, 0, 16)
+	first = append(first, block[:10]...)
+	first = append(first, 0, 0, 0)
+	requ := h2Concat(http2Preface,
+		h2Frame(http2FrameHeaders, http2FlagPadded|http2FlagPriority|http2FlagEndStream, 1, first),
+		h2Frame(http2FrameContinuation, 0, 1, block[10:50]),
+		h2Frame(http2FrameContinuation, http2FlagEndHeaders, 1, block[50:]))
+
+	resp := h2Concat(
+		server.headers(1, 0, ":status", "200"),
+		h2Frame(http2FrameData, http2FlagPadded|http2FlagEndStream, 1, []byte("\x02ok\x00\x00")))
+
+	// split the request in the middle of the CONTINUATION frame
+	h2Replay(http, nil,
+		h2Packet{h2Client, requ[:60]},
+		h2Packet{h2Client, requ[60:]},
+		h2Packet{h2Server, resp})
+
+	trans := expectTransaction(t, http)
+	if trans == nil {
+		return
+	}
+	details := trans["http"].(common.MapStr)
+	headers := details["request"].(common.MapStr)["headers"].(map[string]interface{})
+	assert.Equal(t, common.NetString(long), headers["x-long"])
+	headers = details["response"].(common.MapStr)["headers"].(map[string]interface{})
+	assert.Equal(t, 2, headers["content-length"])
+}
+
+func TestHttp2_streamReset(t *testing.T) {
+	http := http2ModForTests(nil)
+	client, server := newH2Peer(), newH2Peer()
+
+	requ := h2Concat(http2Preface,
+		client.headers(1, 0,
+			":method", "POST", ":path", "/routeguide.RouteGuide/RouteChat",
+			"content-type", "application/grpc"),
+		h2Data(1, 0, "\x00\x00\x00\x00\x00"))
+
+	h2Replay(http, nil,
+		h2Packet{h2Client, requ},
+		h2Packet{h2Server, server.headers(1, 0, ":status", "200")},
+		h2Packet{h2Client, h2Reset(1, 0x8)})
+
+	trans := expectTransaction(t, http)
+	if trans == nil {
+		return
+	}
+	assert.Equal(t, common.ERROR_STATUS, trans["status"])
+	assert.Equal(t, []string{"Stream reset by client (CANCEL)"}, trans["notes"])
+	assert.Equal(t, common.MapStr{
+		"service": "routeguide.RouteGuide",
+		"method":  "RouteChat",
+	}, trans["grpc"])
+}
+
+func TestHttp2_headerTableSize(t *testing.T) {
+	http := http2ModForTests(nil)
+	client, server := newH2Peer(), newH2Peer()
+
+	// the server allows the client to use a larger dynamic table
+	server.encoder.SetMaxDynamicTableSizeLimit(8192)
+	client.encoder.SetMaxDynamicTableSizeLimit(8192)
+	client.encoder.SetMaxDynamicTableSize(8192)
+
+	h2Replay(http, nil,
+		h2Packet{h2Server, h2Settings(http2SettingHeaderTableSize, 8192)},
+		h2Packet{h2Client, h2Concat(http2Preface,
+			client.headers(1, http2FlagEndStream, ":method", "GET", ":path", "/"))},
+		h2Packet{h2Server, server.headers(1, http2FlagEndStream, ":status", "204")})
+
+	trans := expectTransaction(t, http)
+	if trans == nil {
+		return
+	}
+	assert.Equal(t, common.OK_STATUS, trans["status"])
+}
+
+func TestHttp2_upgrade(t *testing.T) {
+	http := http2ModForTests(nil)
+	server := newH2Peer()
+
+	requ := []byte("GET /upgrade HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\n" +
+		"HTTP2-Settings: AAMAAABkAARAAAAAAAIAAAAA\r\n" +
+		"\r\n")
+	resp := h2Concat([]byte("HTTP/1.1 101 Switching Protocols\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Upgrade: h2c\r\n"+
+		"\r\n"),
+		h2Settings(),
+		server.headers(1, 0, ":status", "200"))
+
+	h2Replay(http, nil,
+		h2Packet{h2Client, requ},
+		h2Packet{h2Server, resp},
+		h2Packet{h2Client, h2Concat(http2Preface, h2Settings())},
+		h2Packet{h2Server, h2Data(1, http2FlagEndStream, "hello")})
+
+	trans := expectTransaction(t, http)
+	if trans == nil {
+		return
+	}
+	response := trans["http"].(common.MapStr)["response"].(common.MapStr)
+	assert.Equal(t, uint16(101), response["code"])
+
+	trans = expectTransaction(t, http)
+	if trans == nil {
+		return
+	}
+	assert.Equal(t, "/upgrade", trans["path"])
+	details := trans["http"].(common.MapStr)
+	assert.Equal(t, uint32(1), details["stream_id"])
+	response = details["response"].(common.MapStr)
+	assert.Equal(t, uint16(200), response["code"])
+	assert.Equal(t, 5, response["headers"].(map[string]interface{})["content-length"])
+}
+
+func TestHttp2_serverSettingsFirst(t *testing.T) {
+	http := http2ModForTests(nil)
+	client, server := newH2Peer(), newH2Peer()
+
+	h2Replay(http, nil,
+		h2Packet{h2Server, h2Settings(0x3, 100)},
+		h2Packet{h2Client, h2Concat(http2Preface, h2Settings(),
+			client.headers(1, http2FlagEndStream, ":method", "GET", ":path", "/"))},
+		h2Packet{h2Server, server.headers(1, http2FlagEndStream, ":status", "200")})
+
+	trans := expectTransaction(t, http)
+	if trans == nil {
+		return
+	}
+	assert.Equal(t, "/", trans["path"])
+}
+
+func TestHttp2_largeDataFrame(t *testing.T) {
+	config := defaultConfig
+	config.MaxMessageSize = 100
+	http := http2ModForTests(&config)
+	client, server := newH2Peer(), newH2Peer()
+
+	requ := h2Concat(http2Preface,
+		client.headers(1, http2FlagEndStream, ":method", "GET", ":path", "/large"))
+	data := h2Data(1, http2FlagEndStream, string(bytes.Repeat([]byte("x"), 1000)))
+
+	tcptuple := testCreateTCPTuple()
+	private := h2Replay(http, nil,
+		h2Packet{h2Client, requ},
+		h2Packet{h2Server, server.headers(1, 0, ":status", "200")},
+		h2Packet{h2Server, data[:200]})
+
+	// lost bytes are part of the skipped frame
+	private, drop := http.GapInStream(tcptuple, h2Server, 500, private)
+	assert.False(t, drop)
+	assert.False(t, private.(*httpConnectionData).h2.failed)
+	expectNoHTTPTransaction(t, http)
+
+	h2Replay(http, private, h2Packet{h2Server, data[700:]})
+	trans := expectTransaction(t, http)
+	if trans == nil {
+		return
+	}
+	response := trans["http"].(common.MapStr)["response"].(common.MapStr)
+	assert.Equal(t, 1000, response["headers"].(map[string]interface{})["content-length"])
+
+	// gaps in frame headers can not be recovered from
+	private, drop = http.GapInStream(tcptuple, h2Server, 10, private)
+	assert.False(t, drop)
+	assert.True(t, private.(*httpConnectionData).h2.failed)
+}
+
+func TestHttp2_invalidPreface(t *testing.T) {
+	http := http2ModForTests(nil)
+
+	h2 := newHTTP2Connection(h2Client, http.maxMessageSize)
+	conn := &httpConnectionData{h2: h2}
+	h2Replay(http, conn, h2Packet{h2Client, []byte("PRI * HTTP/2.0\r\n\r\nXX\r\n\r\n")})
+	assert.True(t, h2.failed)
+}
+
+func TestHttp2_headerBlockTooLarge(t *testing.T) {
+	config := defaultConfig
+	config.MaxMessageSize = 100
+	http := http2ModForTests(&config)
+
+	// CONTINUATION frames never ending the header block
+	frames := [][]byte{http2Preface, h2Frame(http2FrameHeaders, 0, 1, make([]byte, 50))}
+	for i := 0; i < 10; i++ {
+		frames = append(frames, h2Frame(http2FrameContinuation, 0, 1, make([]byte, 50)))
+	}
+	private := h2Replay(http, nil, h2Packet{h2Client, h2Concat(frames...)})
+	h2 := private.(*httpConnectionData).h2
+	assert.True(t, h2.failed)
+	assert.Nil(t, h2.dirs[h2Client].headerBlock)
+}
+
+func TestHttp2_streamTimeout(t *testing.T) {
+	http := http2ModForTests(nil)
+	client, server := newH2Peer(), newH2Peer()
+	tcptuple := testCreateTCPTuple()
+	ts := time.Now()
+
+	// requests never answered
+	var private protos.ProtocolData
+	frames := [][]byte{http2Preface}
+	for i := 0; i < http2MaxStreams; i++ {
+		frames = append(frames, client.headers(uint32(2*i+1), http2FlagEndStream,
+			":method", "GET", ":path", "/lost"))
+	}
+	pkt := &protos.Packet{Ts: ts, Payload: h2Concat(frames...)}
+	private = http.Parse(pkt, tcptuple, h2Client, private)
+	assert.Len(t, private.(*httpConnectionData).h2.streams, http2MaxStreams)
+
+	id := uint32(2*http2MaxStreams + 1)
+	ts = ts.Add(http.transactionTimeout + time.Second)
+	pkt = &protos.Packet{Ts: ts, Payload: client.headers(id, http2FlagEndStream,
+		":method", "GET", ":path", "/")}
+	private = http.Parse(pkt, tcptuple, h2Client, private)
+	pkt = &protos.Packet{Ts: ts, Payload: server.headers(id, http2FlagEndStream, ":status", "204")}
+	private = http.Parse(pkt, tcptuple, h2Server, private)
+
+	trans := expectTransaction(t, http)
+	if trans == nil {
+		return
+	}
+	assert.Equal(t, "/", trans["path"])
+	assert.Empty(t, private.(*httpConnectionData).h2.streams)
+}
@@ -18,6 +18,7 @@ type message struct {
 	headerOffset     int
 	version          version
 	connection       common.NetString
+	upgrade          common.NetString
 	chunkedLength    int
 	chunkedBody      []byte
 
@@ -40,6 +41,12 @@ type message struct {
 	headers          map[string]common.NetString
 	size             uint64
 
+	// HTTP/2 and gRPC
+	streamID      uint32
+	grpcStatus    int
+	hasGRPCStatus bool
+	grpcMessage   string
+
 	//Raw Data
 	raw []byte
 
@@ -81,6 +88,7 @@ var (
 	nameContentType      = []byte("content-type")
 	nameTransferEncoding = []byte("transfer-encoding")
 	nameConnection       = []byte("connection")
+	nameUpgrade          = []byte("upgrade")
 )
 
 func newParser(config *parserConfig) *parser {
@@ -337,6 +345,8 @@ func (parser *parser) parseHeader(m *message, data []byte) (bool, bool, int) {
 				m.transferEncoding = common.NetString(headerVal)
 			} else if bytes.Equal(headerName, nameConnection) {
 				m.connection = headerVal
+			} else if bytes.Equal(headerName, nameUpgrade) {
+				m.upgrade = headerVal
 			}
 			if len(config.realIPHeader) > 0 && bytes.Equal(headerName, []byte(config.realIPHeader)) {
 				if ips := bytes.SplitN(headerVal, []byte{','}, 2); len(ips) > 0 {
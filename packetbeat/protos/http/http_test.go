@@ -12,6 +12,7 @@ import (
 
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/flows"
 	"github.com/elastic/beats/packetbeat/protos"
 	"github.com/elastic/beats/packetbeat/publish"
 	"github.com/stretchr/testify/assert"
@@ -1114,6 +1115,26 @@ func Test_gap_in_body_http1dot0_fin(t *testing.T) {
 	assert.Equal(t, trans["notes"], []string{"Packet loss while capturing the response"})
 }
 
+func TestHttp_tunnel(t *testing.T) {
+	http := httpModForTests()
+
+	tcptuple := testCreateTCPTuple()
+	tcptuple.Tunnel = common.TunnelID{Type: uint8(flows.TunnelGENEVE), ID: 7}
+	tcptuple.ComputeHashebles()
+
+	req := protos.Packet{Payload: []byte("GET / HTTP/1.1\r\n\r\n")}
+	resp := protos.Packet{Payload: []byte("HTTP/1.1 204 No Content\r\n\r\n")}
+
+	private := protos.ProtocolData(new(httpConnectionData))
+	private = http.Parse(&req, tcptuple, 0, private)
+	http.Parse(&resp, tcptuple, 1, private)
+
+	trans := expectTransaction(t, http)
+	if assert.NotNil(t, trans) {
+		assert.Equal(t, common.MapStr{"type": "geneve", "id": uint32(7)}, trans["tunnel"])
+	}
+}
+
 func TestHttp_configsSettingAll(t *testing.T) {
 
 	http := httpModForTests()
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/common/flowhash"
 	"github.com/elastic/beats/libbeat/logp"
 	"github.com/elastic/beats/libbeat/monitoring"
 
@@ -307,6 +308,10 @@ func (icmp *icmpPlugin) publishTransaction(trans *icmpTransaction) {
 		}
 	}
 
+	if communityID := communityID(trans); communityID != "" {
+		event["community_id"] = communityID
+	}
+
 	// event fields - group "icmp"
 	icmpEvent := common.MapStr{}
 	event["icmp"] = icmpEvent
@@ -343,3 +348,27 @@ func (icmp *icmpPlugin) publishTransaction(trans *icmpTransaction) {
 
 	icmp.results.PublishTransaction(event)
 }
+
+// communityID returns the Community ID of the transaction, computed from the
+// request type and code, or from the response if the request is missing.
+func communityID(trans *icmpTransaction) string {
+	flow := flowhash.Flow{
+		SourceIP:      trans.tuple.srcIP,
+		DestinationIP: trans.tuple.dstIP,
+		Protocol:      flowhash.ICMP,
+	}
+	switch {
+	case trans.request != nil:
+		flow.ICMPType, flow.ICMPCode = trans.request.Type, trans.request.code
+	case trans.response != nil:
+		// responses are sent from the destination of the request
+		flow.SourceIP, flow.DestinationIP = flow.DestinationIP, flow.SourceIP
+		flow.ICMPType, flow.ICMPCode = trans.response.Type, trans.response.code
+	default:
+		return ""
+	}
+	if trans.tuple.icmpVersion == 6 {
+		flow.Protocol = flowhash.ICMPv6
+	}
+	return flowhash.DefaultCommunityID.Hash(flow)
+}
@@ -40,6 +40,17 @@ func TestIcmpDirection(t *testing.T) {
 	assert.Equal(t, uint8(directionFromInside), icmp.direction(trans3), "server to client")
 }
 
+func TestIcmpCommunityID(t *testing.T) {
+	tuple := icmpTuple{icmpVersion: 4, srcIP: net.ParseIP("192.168.0.89"), dstIP: net.ParseIP("192.168.0.1")}
+
+	trans := &icmpTransaction{tuple: tuple, request: &icmpMessage{Type: 8}, response: &icmpMessage{Type: 0}}
+	assert.Equal(t, "1:X0snYXpgwiv9TZtqg64sgzUn6Dk=", communityID(trans))
+
+	// orphaned response
+	trans = &icmpTransaction{tuple: tuple, response: &icmpMessage{Type: 0}}
+	assert.Equal(t, "1:X0snYXpgwiv9TZtqg64sgzUn6Dk=", communityID(trans))
+}
+
 func BenchmarkIcmpProcessICMPv4(b *testing.B) {
 	if testing.Verbose() {
 		logp.LogInit(logp.LOG_DEBUG, "", false, true, []string{"icmp", "icmpdetailed"})
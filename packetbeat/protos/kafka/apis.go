@@ -0,0 +1,689 @@
+package kafka
+
+import (
+	"strconv"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+type apiKey int16
+
+const (
+	apiProduce         apiKey = 0
+	apiFetch           apiKey = 1
+	apiListOffsets     apiKey = 2
+	apiMetadata        apiKey = 3
+	apiOffsetCommit    apiKey = 8
+	apiOffsetFetch     apiKey = 9
+	apiFindCoordinator apiKey = 10
+	apiJoinGroup       apiKey = 11
+	apiHeartbeat       apiKey = 12
+	apiLeaveGroup      apiKey = 13
+	apiSyncGroup       apiKey = 14
+	apiAPIVersions     apiKey = 18
+	apiCreateTopics    apiKey = 19
+	apiDeleteTopics    apiKey = 20
+	apiInitProducerID  apiKey = 22
+
+	// highest API key and version accepted when validating request headers
+	maxAPIKey     apiKey = 100
+	maxAPIVersion int16  = 50
+)
+
+type bodyDecoder func(d *decoder, version int16, msg *message)
+
+// apiInfo describes how to decode the messages of one API. Only the request
+// and response bodies of the most common APIs are decoded, all others are
+// reported by name only.
+type apiInfo struct {
+	// first version using the flexible encoding, -1 if none
+	flexibleVersion int16
+
+	// highest version the decoders support
+	maxVersion int16
+
+	request  bodyDecoder
+	response bodyDecoder
+}
+
+var apiNames = map[apiKey]string{
+	0:  "Produce",
+	1:  "Fetch",
+	2:  "ListOffsets",
+	3:  "Metadata",
+	4:  "LeaderAndIsr",
+	5:  "StopReplica",
+	6:  "UpdateMetadata",
+	7:  "ControlledShutdown",
+	8:  "OffsetCommit",
+	9:  "OffsetFetch",
+	10: "FindCoordinator",
+	11: "JoinGroup",
+	12: "Heartbeat",
+	13: "LeaveGroup",
+	14: "SyncGroup",
+	15: "DescribeGroups",
+	16: "ListGroups",
+	17: "SaslHandshake",
+	18: "ApiVersions",
+	19: "CreateTopics",
+	20: "DeleteTopics",
+	21: "DeleteRecords",
+	22: "InitProducerId",
+	23: "OffsetForLeaderEpoch",
+	24: "AddPartitionsToTxn",
+	25: "AddOffsetsToTxn",
+	26: "EndTxn",
+	27: "WriteTxnMarkers",
+	28: "TxnOffsetCommit",
+	29: "DescribeAcls",
+	30: "CreateAcls",
+	31: "DeleteAcls",
+	32: "DescribeConfigs",
+	33: "AlterConfigs",
+	34: "AlterReplicaLogDirs",
+	35: "DescribeLogDirs",
+	36: "SaslAuthenticate",
+	37: "CreatePartitions",
+	38: "CreateDelegationToken",
+	39: "RenewDelegationToken",
+	40: "ExpireDelegationToken",
+	41: "DescribeDelegationToken",
+	42: "DeleteGroups",
+	43: "ElectLeaders",
+	44: "IncrementalAlterConfigs",
+	45: "AlterPartitionReassignments",
+	46: "ListPartitionReassignments",
+	47: "OffsetDelete",
+	48: "DescribeClientQuotas",
+	49: "AlterClientQuotas",
+	50: "DescribeUserScramCredentials",
+	51: "AlterUserScramCredentials",
+	55: "DescribeQuorum",
+	57: "UpdateFeatures",
+	60: "DescribeCluster",
+	61: "DescribeProducers",
+	64: "UnregisterBroker",
+	65: "DescribeTransactions",
+	66: "ListTransactions",
+	68: "ConsumerGroupHeartbeat",
+	69: "ConsumerGroupDescribe",
+	71: "GetTelemetrySubscriptions",
+	72: "PushTelemetry",
+	74: "ListClientMetricsResources",
+	75: "DescribeTopicPartitions",
+}
+
+var apis = map[apiKey]apiInfo{
+	apiProduce:         {9, 12, decodeProduceRequest, decodeProduceResponse},
+	apiFetch:           {12, 16, decodeFetchRequest, decodeFetchResponse},
+	apiListOffsets:     {6, 9, decodeListOffsetsRequest, decodeListOffsetsResponse},
+	apiMetadata:        {9, 12, decodeMetadataRequest, decodeMetadataResponse},
+	apiOffsetCommit:    {8, 9, decodeOffsetCommitRequest, decodeOffsetCommitResponse},
+	apiOffsetFetch:     {6, 7, decodeOffsetFetchRequest, decodeOffsetFetchResponse},
+	apiFindCoordinator: {3, 3, decodeFindCoordinatorRequest, decodeFindCoordinatorResponse},
+	apiJoinGroup:       {6, 9, decodeGroupRequest, decodeThrottleAndError(2)},
+	apiHeartbeat:       {4, 4, decodeGroupMemberRequest, decodeThrottleAndError(1)},
+	apiLeaveGroup:      {4, 5, decodeGroupRequest, decodeThrottleAndError(1)},
+	apiSyncGroup:       {4, 5, decodeGroupMemberRequest, decodeThrottleAndError(1)},
+	apiAPIVersions:     {3, 3, nil, decodeAPIVersionsResponse},
+	apiCreateTopics:    {5, 7, decodeCreateTopicsRequest, decodeCreateTopicsResponse},
+	apiDeleteTopics:    {4, 6, decodeDeleteTopicsRequest, decodeDeleteTopicsResponse},
+	apiInitProducerID:  {2, 5, decodeInitProducerIDRequest, decodeThrottleAndError(0)},
+}
+
+func (k apiKey) String() string {
+	if name, found := apiNames[k]; found {
+		return name
+	}
+	return "Unknown(" + strconv.Itoa(int(k)) + ")"
+}
+
+// isFlexible checks if the messages of an API version use the flexible
+// encoding introduced by KIP-482.
+func (k apiKey) isFlexible(version int16) bool {
+	info, found := apis[k]
+	return found && info.flexibleVersion >= 0 && version >= info.flexibleVersion
+}
+
+// Produce
+
+func decodeProduceRequest(d *decoder, version int16, msg *message) {
+	if version >= 3 {
+		if id, ok := d.nullableString(); ok {
+			msg.fields["transactional_id"] = id
+		}
+	}
+	msg.fields["acks"] = d.int16()
+	msg.fields["timeout_ms"] = d.int32()
+
+	var recordBytes int
+	msg.topics = decodeTopics(d, false, func(d *decoder) partition {
+		p := partition{index: d.int32()}
+		if n := d.bytes(); n > 0 {
+			recordBytes += n
+		}
+		return p
+	})
+	msg.fields["record_bytes"] = recordBytes
+}
+
+func decodeProduceResponse(d *decoder, version int16, msg *message) {
+	msg.topics = decodeTopics(d, false, func(d *decoder) partition {
+		p := partition{index: d.int32()}
+		p.setError(d.int16())
+		d.int64() // base offset
+		if version >= 2 {
+			d.int64() // log append time
+		}
+		if version >= 5 {
+			d.int64() // log start offset
+		}
+		if version >= 8 {
+			for i, n := 0, d.arrayLen(); i < n && d.err == nil; i++ {
+				d.int32()          // batch index
+				d.nullableString() // batch index error message
+				d.taggedFields()
+			}
+			d.nullableString() // error message
+		}
+		return p
+	})
+	if version >= 1 {
+		msg.throttleTime = d.int32()
+	}
+}
+
+// Fetch
+
+func decodeFetchRequest(d *decoder, version int16, msg *message) {
+	if version < 15 {
+		d.int32() // replica id
+	}
+	msg.fields["max_wait_ms"] = d.int32()
+	msg.fields["min_bytes"] = d.int32()
+	if version >= 3 {
+		msg.fields["max_bytes"] = d.int32()
+	}
+	if version >= 4 {
+		msg.fields["isolation_level"] = d.int8()
+	}
+	if version >= 7 {
+		d.int32() // session id
+		d.int32() // session epoch
+	}
+	msg.topics = decodeTopics(d, version >= 13, func(d *decoder) partition {
+		p := partition{index: d.int32()}
+		if version >= 9 {
+			d.int32() // current leader epoch
+		}
+		d.int64() // fetch offset
+		if version >= 12 {
+			d.int32() // last fetched epoch
+		}
+		if version >= 5 {
+			d.int64() // log start offset
+		}
+		d.int32() // partition max bytes
+		return p
+	})
+}
+
+func decodeFetchResponse(d *decoder, version int16, msg *message) {
+	if version >= 1 {
+		msg.throttleTime = d.int32()
+	}
+	if version >= 7 {
+		msg.setError(d.int16())
+		d.int32() // session id
+	}
+
+	var recordBytes int
+	msg.topics = decodeTopics(d, version >= 13, func(d *decoder) partition {
+		p := partition{index: d.int32()}
+		p.setError(d.int16())
+		d.int64() // high watermark
+		if version >= 4 {
+			d.int64() // last stable offset
+		}
+		if version >= 5 {
+			d.int64() // log start offset
+		}
+		if version >= 4 {
+			for i, n := 0, d.arrayLen(); i < n && d.err == nil; i++ {
+				d.int64() // producer id
+				d.int64() // first offset
+				d.taggedFields()
+			}
+		}
+		if version >= 11 {
+			d.int32() // preferred read replica
+		}
+		if n := d.bytes(); n > 0 {
+			recordBytes += n
+		}
+		return p
+	})
+	msg.fields["record_bytes"] = recordBytes
+}
+
+// ListOffsets
+
+func decodeListOffsetsRequest(d *decoder, version int16, msg *message) {
+	d.int32() // replica id
+	if version >= 2 {
+		msg.fields["isolation_level"] = d.int8()
+	}
+	msg.topics = decodeTopics(d, false, func(d *decoder) partition {
+		p := partition{index: d.int32()}
+		if version >= 4 {
+			d.int32() // current leader epoch
+		}
+		d.int64() // timestamp
+		if version == 0 {
+			d.int32() // max num offsets
+		}
+		return p
+	})
+}
+
+func decodeListOffsetsResponse(d *decoder, version int16, msg *message) {
+	if version >= 2 {
+		msg.throttleTime = d.int32()
+	}
+	msg.topics = decodeTopics(d, false, func(d *decoder) partition {
+		p := partition{index: d.int32()}
+		p.setError(d.int16())
+		if version == 0 {
+			d.int64Array() // old style offsets
+		} else {
+			d.int64() // timestamp
+			d.int64() // offset
+		}
+		if version >= 4 {
+			d.int32() // leader epoch
+		}
+		return p
+	})
+}
+
+// Metadata
+
+func decodeMetadataRequest(d *decoder, version int16, msg *message) {
+	n := d.arrayLen()
+	if n < 0 || (n == 0 && version == 0) {
+		msg.fields["all_topics"] = true
+		return
+	}
+
+	for i := 0; i < n && d.err == nil; i++ {
+		var t topic
+		if version >= 10 {
+			t.id = d.uuid()
+			t.name, _ = d.nullableString()
+		} else {
+			t.name = d.string()
+		}
+		d.taggedFields()
+		if d.err == nil {
+			msg.topics = append(msg.topics, t)
+		}
+	}
+}
+
+func decodeMetadataResponse(d *decoder, version int16, msg *message) {
+	if version >= 3 {
+		msg.throttleTime = d.int32()
+	}
+
+	brokers := d.arrayLen()
+	for i := 0; i < brokers && d.err == nil; i++ {
+		d.int32()  // node id
+		d.string() // host
+		d.int32()  // port
+		if version >= 1 {
+			d.nullableString() // rack
+		}
+		d.taggedFields()
+	}
+	msg.fields["brokers"] = brokers
+
+	if version >= 2 {
+		if id, ok := d.nullableString(); ok {
+			msg.fields["cluster_id"] = id
+		}
+	}
+	if version >= 1 {
+		msg.fields["controller_id"] = d.int32()
+	}
+
+	n := d.arrayLen()
+	for i := 0; i < n && d.err == nil; i++ {
+		var t topic
+		t.setError(d.int16())
+		if version >= 10 {
+			t.name, _ = d.nullableString()
+			t.id = d.uuid()
+		} else {
+			t.name = d.string()
+		}
+		if version >= 1 {
+			d.bool() // is internal
+		}
+
+		partitions := d.arrayLen()
+		for j := 0; j < partitions && d.err == nil; j++ {
+			var p partition
+			p.setError(d.int16())
+			p.index = d.int32()
+			d.int32() // leader id
+			if version >= 7 {
+				d.int32() // leader epoch
+			}
+			d.int32Array() // replica nodes
+			d.int32Array() // isr nodes
+			if version >= 5 {
+				d.int32Array() // offline replicas
+			}
+			d.taggedFields()
+			if d.err == nil {
+				t.partitions = append(t.partitions, p)
+			}
+		}
+
+		if version >= 8 {
+			d.int32() // topic authorized operations
+		}
+		d.taggedFields()
+		msg.topics = append(msg.topics, t)
+	}
+}
+
+// OffsetCommit
+
+func decodeOffsetCommitRequest(d *decoder, version int16, msg *message) {
+	msg.fields["group_id"] = d.string()
+	if version >= 1 {
+		msg.fields["generation_id"] = d.int32()
+		msg.fields["member_id"] = d.string()
+	}
+	if version >= 7 {
+		d.nullableString() // group instance id
+	}
+	if version >= 2 && version <= 4 {
+		d.int64() // retention time
+	}
+	msg.topics = decodeTopics(d, false, func(d *decoder) partition {
+		p := partition{index: d.int32()}
+		d.int64() // committed offset
+		if version >= 6 {
+			d.int32() // committed leader epoch
+		}
+		if version == 1 {
+			d.int64() // commit timestamp
+		}
+		d.nullableString() // committed metadata
+		return p
+	})
+}
+
+func decodeOffsetCommitResponse(d *decoder, version int16, msg *message) {
+	if version >= 3 {
+		msg.throttleTime = d.int32()
+	}
+	msg.topics = decodeTopics(d, false, func(d *decoder) partition {
+		p := partition{index: d.int32()}
+		p.setError(d.int16())
+		return p
+	})
+}
+
+// OffsetFetch
+
+func decodeOffsetFetchRequest(d *decoder, version int16, msg *message) {
+	msg.fields["group_id"] = d.string()
+
+	n := d.arrayLen()
+	if n < 0 {
+		msg.fields["all_topics"] = true
+		return
+	}
+	for i := 0; i < n && d.err == nil; i++ {
+		t := topic{name: d.string()}
+		for _, index := range d.int32Array() {
+			t.partitions = append(t.partitions, partition{index: index})
+		}
+		d.taggedFields()
+		if d.err == nil {
+			msg.topics = append(msg.topics, t)
+		}
+	}
+}
+
+func decodeOffsetFetchResponse(d *decoder, version int16, msg *message) {
+	if version >= 3 {
+		msg.throttleTime = d.int32()
+	}
+	msg.topics = decodeTopics(d, false, func(d *decoder) partition {
+		p := partition{index: d.int32()}
+		d.int64() // committed offset
+		if version >= 5 {
+			d.int32() // committed leader epoch
+		}
+		d.nullableString() // metadata
+		p.setError(d.int16())
+		return p
+	})
+	if version >= 2 {
+		msg.setError(d.int16())
+	}
+}
+
+// FindCoordinator
+
+func decodeFindCoordinatorRequest(d *decoder, version int16, msg *message) {
+	msg.fields["key"] = d.string()
+	if version >= 1 {
+		msg.fields["key_type"] = d.int8()
+	}
+}
+
+func decodeFindCoordinatorResponse(d *decoder, version int16, msg *message) {
+	if version >= 1 {
+		msg.throttleTime = d.int32()
+	}
+	msg.setError(d.int16())
+	if version >= 1 {
+		d.nullableString() // error message
+	}
+	nodeID := d.int32()
+	host := d.string()
+	port := d.int32()
+	if d.err == nil && msg.errorCode == 0 {
+		msg.fields["coordinator"] = common.MapStr{
+			"node_id": nodeID,
+			"host":    host,
+			"port":    port,
+		}
+	}
+}
+
+// Consumer group membership: JoinGroup, Heartbeat, LeaveGroup and SyncGroup
+
+func decodeGroupRequest(d *decoder, version int16, msg *message) {
+	msg.fields["group_id"] = d.string()
+}
+
+func decodeGroupMemberRequest(d *decoder, version int16, msg *message) {
+	msg.fields["group_id"] = d.string()
+	msg.fields["generation_id"] = d.int32()
+	msg.fields["member_id"] = d.string()
+}
+
+// decodeThrottleAndError decodes the throttle time and error code at the
+// beginning of many responses. The throttle time is included starting with
+// throttleVersion.
+func decodeThrottleAndError(throttleVersion int16) bodyDecoder {
+	return func(d *decoder, version int16, msg *message) {
+		if version >= throttleVersion {
+			msg.throttleTime = d.int32()
+		}
+		msg.setError(d.int16())
+	}
+}
+
+// ApiVersions
+
+func decodeAPIVersionsResponse(d *decoder, version int16, msg *message) {
+	msg.setError(d.int16())
+}
+
+// CreateTopics
+
+func decodeCreateTopicsRequest(d *decoder, version int16, msg *message) {
+	n := d.arrayLen()
+	for i := 0; i < n && d.err == nil; i++ {
+		t := topic{name: d.string()}
+		d.int32() // num partitions
+		d.int16() // replication factor
+		for j, assignments := 0, d.arrayLen(); j < assignments && d.err == nil; j++ {
+			d.int32()      // partition index
+			d.int32Array() // broker ids
+			d.taggedFields()
+		}
+		for j, configs := 0, d.arrayLen(); j < configs && d.err == nil; j++ {
+			d.string()         // name
+			d.nullableString() // value
+			d.taggedFields()
+		}
+		d.taggedFields()
+		if d.err == nil {
+			msg.topics = append(msg.topics, t)
+		}
+	}
+	msg.fields["timeout_ms"] = d.int32()
+	if version >= 1 {
+		msg.fields["validate_only"] = d.bool()
+	}
+}
+
+func decodeCreateTopicsResponse(d *decoder, version int16, msg *message) {
+	if version >= 2 {
+		msg.throttleTime = d.int32()
+	}
+
+	n := d.arrayLen()
+	for i := 0; i < n && d.err == nil; i++ {
+		t := topic{name: d.string()}
+		if version >= 7 {
+			t.id = d.uuid()
+		}
+		t.setError(d.int16())
+		if version >= 1 {
+			d.nullableString() // error message
+		}
+		if version >= 5 {
+			d.int32() // num partitions
+			d.int16() // replication factor
+			for j, configs := 0, d.arrayLen(); j < configs && d.err == nil; j++ {
+				d.string()         // name
+				d.nullableString() // value
+				d.bool()           // read only
+				d.int8()           // config source
+				d.bool()           // is sensitive
+				d.taggedFields()
+			}
+		}
+		d.taggedFields()
+		if d.err == nil {
+			msg.topics = append(msg.topics, t)
+		}
+	}
+}
+
+// DeleteTopics
+
+func decodeDeleteTopicsRequest(d *decoder, version int16, msg *message) {
+	n := d.arrayLen()
+	for i := 0; i < n && d.err == nil; i++ {
+		var t topic
+		if version >= 6 {
+			t.name, _ = d.nullableString()
+			t.id = d.uuid()
+			d.taggedFields()
+		} else {
+			t.name = d.string()
+		}
+		if d.err == nil {
+			msg.topics = append(msg.topics, t)
+		}
+	}
+	msg.fields["timeout_ms"] = d.int32()
+}
+
+func decodeDeleteTopicsResponse(d *decoder, version int16, msg *message) {
+	if version >= 1 {
+		msg.throttleTime = d.int32()
+	}
+
+	n := d.arrayLen()
+	for i := 0; i < n && d.err == nil; i++ {
+		var t topic
+		if version >= 6 {
+			t.name, _ = d.nullableString()
+			t.id = d.uuid()
+		} else {
+			t.name = d.string()
+		}
+		t.setError(d.int16())
+		if version >= 5 {
+			d.nullableString() // error message
+		}
+		d.taggedFields()
+		if d.err == nil {
+			msg.topics = append(msg.topics, t)
+		}
+	}
+}
+
+// InitProducerId
+
+func decodeInitProducerIDRequest(d *decoder, version int16, msg *message) {
+	if id, ok := d.nullableString(); ok {
+		msg.fields["transactional_id"] = id
+	}
+}
+
+// decodeTopics decodes the topic/partition arrays shared by most APIs. Topics
+// are identified by name or, in newer versions, by topic ID. Tagged fields
+// are skipped by decodeTopics.
+func decodeTopics(d *decoder, byID bool, decodePartition func(*decoder) partition) []topic {
+	var topics []topic
+
+	n := d.arrayLen()
+	for i := 0; i < n && d.err == nil; i++ {
+		var t topic
+		if byID {
+			t.id = d.uuid()
+		} else {
+			t.name = d.string()
+		}
+		if d.err != nil {
+			break
+		}
+
+		partitions := d.arrayLen()
+		for j := 0; j < partitions && d.err == nil; j++ {
+			p := decodePartition(d)
+			d.taggedFields()
+			if d.err == nil {
+				t.partitions = append(t.partitions, p)
+			}
+		}
+		d.taggedFields()
+
+		// keep partially decoded topics of truncated messages
+		topics = append(topics, t)
+	}
+	return topics
+}
@@ -0,0 +1,34 @@
+package kafka
+
+import (
+	"fmt"
+
+	"github.com/elastic/beats/packetbeat/config"
+	"github.com/elastic/beats/packetbeat/protos"
+	"github.com/elastic/beats/packetbeat/protos/tcp"
+)
+
+type kafkaConfig struct {
+	config.ProtocolCommon `config:",inline"`
+
+	// Maximum number of bytes buffered per message. Larger messages, like
+	// big produce requests or fetch responses, are only partially decoded.
+	MaxMessageSize int `config:"max_message_size"`
+}
+
+var (
+	defaultConfig = kafkaConfig{
+		ProtocolCommon: config.ProtocolCommon{
+			TransactionTimeout: protos.DefaultTransactionExpiration,
+		},
+		MaxMessageSize: 1 << 20,
+	}
+)
+
+func (c *kafkaConfig) Validate() error {
+	if c.MaxMessageSize <= 0 || c.MaxMessageSize >= tcp.TCPMaxDataInStream {
+		return fmt.Errorf("max_message_size must be between 1 and %v bytes",
+			tcp.TCPMaxDataInStream-1)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+var (
+	errTruncated     = errors.New("truncated kafka message")
+	errInvalidLength = errors.New("invalid length in kafka message")
+)
+
+// decoder reads the primitive types of the Kafka protocol from a message
+// body. Errors are sticky: once a read fails all following reads return zero
+// values and the error is reported by err.
+//
+// Flexible versions (KIP-482) encode strings, arrays and byte fields using
+// compact (varint based) encodings and add tagged fields to every structure.
+type decoder struct {
+	buf      []byte
+	flexible bool
+	err      error
+}
+
+func newDecoder(buf []byte, flexible bool) *decoder {
+	return &decoder{buf: buf, flexible: flexible}
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.buf = nil
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf) < n {
+		d.fail(errTruncated)
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) skip(n int) {
+	d.next(n)
+}
+
+func (d *decoder) bool() bool {
+	return d.int8() != 0
+}
+
+func (d *decoder) int8() int8 {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return int8(b[0])
+}
+
+func (d *decoder) int16() int16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (d *decoder) int32() int32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) int64() int64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail(errTruncated)
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) uuid() string {
+	return hex.EncodeToString(d.next(16))
+}
+
+// length reads the length of a string, bytes or array field. Null values are
+// reported as -1.
+func (d *decoder) length(int16Len bool) int {
+	if d.flexible {
+		// compact encoding, length + 1
+		return int(d.uvarint()) - 1
+	}
+	if int16Len {
+		return int(d.int16())
+	}
+	return int(d.int32())
+}
+
+func (d *decoder) string() string {
+	s, _ := d.nullableString()
+	return s
+}
+
+func (d *decoder) nullableString() (string, bool) {
+	n := d.length(true)
+	if n < 0 {
+		return "", false
+	}
+	return string(d.next(n)), d.err == nil
+}
+
+// bytes skips a bytes field, returning its length.
+func (d *decoder) bytes() int {
+	n := d.length(false)
+	if n > 0 {
+		d.skip(n)
+	}
+	return n
+}
+
+// arrayLen reads the number of elements in an array. Null arrays are reported
+// as -1.
+func (d *decoder) arrayLen() int {
+	n := d.length(false)
+	if d.err == nil && n > len(d.buf) {
+		// each element requires at least one byte
+		d.fail(errInvalidLength)
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) int32Array() []int32 {
+	n := d.arrayLen()
+	if n <= 0 {
+		return nil
+	}
+	values := make([]int32, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		values = append(values, d.int32())
+	}
+	return values
+}
+
+func (d *decoder) int64Array() {
+	n := d.arrayLen()
+	if n > 0 {
+		d.skip(8 * n)
+	}
+}
+
+// taggedFields skips the tagged fields section of a structure in flexible
+// versions.
+func (d *decoder) taggedFields() {
+	if !d.flexible {
+		return
+	}
+	n := int(d.uvarint())
+	for i := 0; i < n && d.err == nil; i++ {
+		d.uvarint() // tag
+		d.skip(int(d.uvarint()))
+	}
+}
@@ -0,0 +1,401 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+
+	"github.com/elastic/beats/packetbeat/procs"
+	"github.com/elastic/beats/packetbeat/protos"
+	"github.com/elastic/beats/packetbeat/protos/applayer"
+	"github.com/elastic/beats/packetbeat/protos/tcp"
+	"github.com/elastic/beats/packetbeat/publish"
+)
+
+type stream struct {
+	applayer.Stream
+	parser parser
+}
+
+type kafkaConnectionData struct {
+	streams [2]*stream
+
+	// requests waiting for a response, in order of arrival. Kafka brokers
+	// answer the requests of a connection in order.
+	requests messageList
+}
+
+type messageList struct {
+	head, tail *message
+}
+
+// message is a decoded Kafka request or response.
+type message struct {
+	applayer.Message
+	next *message
+
+	apiKey        apiKey
+	apiVersion    int16
+	correlationID int32
+	clientID      string
+	truncated     bool
+
+	// decoded body
+	topics       []topic
+	fields       common.MapStr
+	throttleTime int32
+	errorCode    errorCode
+	hasError     bool
+}
+
+type topic struct {
+	name       string
+	id         string
+	errorCode  errorCode
+	partitions []partition
+}
+
+type partition struct {
+	index     int32
+	errorCode errorCode
+}
+
+type transaction struct {
+	applayer.Transaction
+
+	request  *message
+	response *message
+}
+
+// Kafka protocol plugin
+type kafkaPlugin struct {
+	// config
+	ports              protos.PortsConfig
+	maxMessageSize     int
+	transactionTimeout time.Duration
+
+	results publish.Transactions
+}
+
+var (
+	debugf  = logp.MakeDebug("kafka")
+	isDebug = false
+)
+
+var (
+	unmatchedRequests  = monitoring.NewInt(nil, "kafka.unmatched_requests")
+	unmatchedResponses = monitoring.NewInt(nil, "kafka.unmatched_responses")
+)
+
+const (
+	noteMessageTruncated = "Message too large, only partially decoded"
+	noteDecodingFailed   = "Failed to decode message body"
+)
+
+func init() {
+	protos.Register("kafka", New)
+}
+
+func New(
+	testMode bool,
+	results publish.Transactions,
+	cfg *common.Config,
+) (protos.Plugin, error) {
+	p := &kafkaPlugin{}
+	config := defaultConfig
+	if !testMode {
+		if err := cfg.Unpack(&config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.init(results, &config); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (kafka *kafkaPlugin) init(results publish.Transactions, config *kafkaConfig) error {
+	if err := kafka.setFromConfig(config); err != nil {
+		return err
+	}
+
+	kafka.results = results
+	isDebug = logp.IsDebug("kafka")
+
+	return nil
+}
+
+func (kafka *kafkaPlugin) setFromConfig(config *kafkaConfig) error {
+	if err := kafka.ports.Set(config.Ports); err != nil {
+		return err
+	}
+	kafka.maxMessageSize = config.MaxMessageSize
+	kafka.transactionTimeout = config.TransactionTimeout
+	return nil
+}
+
+func (kafka *kafkaPlugin) GetPorts() []int {
+	return kafka.ports.Ports
+}
+
+func (kafka *kafkaPlugin) ConnectionTimeout() time.Duration {
+	return kafka.transactionTimeout
+}
+
+func (kafka *kafkaPlugin) Parse(
+	pkt *protos.Packet,
+	tcptuple *common.TCPTuple,
+	dir uint8,
+	private protos.ProtocolData,
+) protos.ProtocolData {
+	defer logp.Recover("ParseKafka exception")
+
+	conn := ensureKafkaConnection(private)
+	conn = kafka.doParse(conn, pkt, tcptuple, dir)
+	if conn == nil {
+		return nil
+	}
+	return conn
+}
+
+func ensureKafkaConnection(private protos.ProtocolData) *kafkaConnectionData {
+	if private == nil {
+		return &kafkaConnectionData{}
+	}
+
+	priv, ok := private.(*kafkaConnectionData)
+	if !ok {
+		logp.Warn("kafka connection data type error, create new one")
+		return &kafkaConnectionData{}
+	}
+	if priv == nil {
+		logp.Warn("Unexpected: kafka connection data not set, create new one")
+		return &kafkaConnectionData{}
+	}
+
+	return priv
+}
+
+func (kafka *kafkaPlugin) doParse(
+	conn *kafkaConnectionData,
+	pkt *protos.Packet,
+	tcptuple *common.TCPTuple,
+	dir uint8,
+) *kafkaConnectionData {
+	st := conn.streams[dir]
+	if st == nil {
+		st = &stream{}
+		st.parser.maxMessageSize = kafka.maxMessageSize
+		st.Stream.Init(tcp.TCPMaxDataInStream)
+		conn.streams[dir] = st
+		if isDebug {
+			debugf("new stream: %p (dir=%v, len=%v)", st, dir, len(pkt.Payload))
+		}
+	}
+
+	if err := st.Append(pkt.Payload); err != nil {
+		if isDebug {
+			debugf("%v, dropping TCP stream", err)
+		}
+		return nil
+	}
+
+	isRequest := kafka.isRequest(tcptuple, dir)
+	for st.Buf.Len() > 0 {
+		f, err := st.parser.parse(&st.Buf, pkt.Ts, isRequest)
+		if err != nil {
+			// drop this tcp stream. Will retry parsing with the next
+			// segment in it
+			conn.streams[dir] = nil
+			if isDebug {
+				debugf("Ignore Kafka message (%v). Drop tcp stream. Try parsing with the next segment", err)
+			}
+			return conn
+		}
+		if f == nil {
+			// wait for more data
+			break
+		}
+
+		msg := newMessage(f, tcptuple, dir, isRequest)
+		if isRequest {
+			err = kafka.onRequest(conn, msg, f.body)
+		} else {
+			kafka.onResponse(conn, msg, f.body)
+		}
+		st.Stream.Reset()
+
+		if err != nil {
+			conn.streams[dir] = nil
+			if isDebug {
+				debugf("Ignore Kafka message (%v). Drop tcp stream.", err)
+			}
+			return conn
+		}
+	}
+	st.Stream.Reset()
+
+	return conn
+}
+
+// isRequest checks if the packet is sent to one of the configured Kafka
+// ports.
+func (kafka *kafkaPlugin) isRequest(tcptuple *common.TCPTuple, dir uint8) bool {
+	port := tcptuple.DstPort
+	if dir == tcp.TCPDirectionReverse {
+		port = tcptuple.SrcPort
+	}
+	for _, p := range kafka.ports.Ports {
+		if int(port) == p {
+			return true
+		}
+	}
+	return false
+}
+
+func newMessage(f *frame, tcptuple *common.TCPTuple, dir uint8, isRequest bool) *message {
+	msg := &message{
+		truncated:    f.truncated,
+		fields:       common.MapStr{},
+		throttleTime: -1,
+	}
+	msg.Ts = f.ts
+	msg.Tuple = *tcptuple.IPPort()
+	msg.Transport = applayer.TransportTCP
+	msg.CmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort(), procs.TransportTCP)
+	msg.IsRequest = isRequest
+	msg.Size = uint64(f.size)
+
+	// direction of the request in the TCP connection
+	requestDir := dir
+	if !isRequest {
+		requestDir = 1 - dir
+	}
+	if requestDir == tcp.TCPDirectionOriginal {
+		msg.Direction = applayer.NetOriginalDirection
+	} else {
+		msg.Direction = applayer.NetReverseDirection
+	}
+
+	if f.truncated {
+		msg.AddNotes(noteMessageTruncated)
+	}
+	return msg
+}
+
+func (kafka *kafkaPlugin) onRequest(conn *kafkaConnectionData, msg *message, body []byte) error {
+	d, err := decodeRequestHeader(msg, body)
+	if err != nil {
+		return err
+	}
+	decodeBody(d, msg, true)
+
+	if isDebug {
+		debugf("kafka (%p) request: %v v%v, correlation_id=%v",
+			conn, msg.apiKey, msg.apiVersion, msg.correlationID)
+	}
+
+	if msg.expectsResponse() {
+		conn.requests.append(msg)
+	} else {
+		kafka.publish(&transaction{request: msg})
+	}
+	return nil
+}
+
+func (kafka *kafkaPlugin) onResponse(conn *kafkaConnectionData, msg *message, body []byte) {
+	d := decodeResponseHeader(msg, body)
+	if isDebug {
+		debugf("kafka (%p) response: correlation_id=%v", conn, msg.correlationID)
+	}
+
+	// drop requests whose response has been missed
+	var requ *message
+	for !conn.requests.empty() {
+		requ = conn.requests.pop()
+		if requ.correlationID == msg.correlationID {
+			break
+		}
+
+		debugf("Kafka request without response. Ignoring")
+		unmatchedRequests.Add(1)
+		requ = nil
+	}
+	if requ == nil {
+		debugf("Response from unknown transaction. Ignoring")
+		unmatchedResponses.Add(1)
+		return
+	}
+
+	decodeResponseBody(d, requ, msg)
+	kafka.publish(&transaction{request: requ, response: msg})
+}
+
+// expectsResponse checks if the broker will answer a request. Produce
+// requests with acks=0 are not acknowledged.
+func (msg *message) expectsResponse() bool {
+	if msg.apiKey != apiProduce {
+		return true
+	}
+	acks, ok := msg.fields["acks"].(int16)
+	return !ok || acks != 0
+}
+
+func (kafka *kafkaPlugin) publish(t *transaction) {
+	if kafka.results == nil {
+		return
+	}
+
+	t.init()
+	event := common.MapStr{}
+	t.Event(event)
+	kafka.results.PublishTransaction(event)
+}
+
+func (kafka *kafkaPlugin) GapInStream(tcptuple *common.TCPTuple, dir uint8,
+	nbytes int, private protos.ProtocolData) (priv protos.ProtocolData, drop bool) {
+
+	conn := ensureKafkaConnection(private)
+	st := conn.streams[dir]
+	if st != nil && st.parser.gap(st.Buf.Len(), nbytes) {
+		// gap is part of a large message not being buffered
+		return conn, false
+	}
+	return private, true
+}
+
+func (kafka *kafkaPlugin) ReceivedFin(tcptuple *common.TCPTuple, dir uint8,
+	private protos.ProtocolData) protos.ProtocolData {
+
+	return private
+}
+
+func (ml *messageList) append(msg *message) {
+	if ml.tail == nil {
+		ml.head = msg
+	} else {
+		ml.tail.next = msg
+	}
+	msg.next = nil
+	ml.tail = msg
+}
+
+func (ml *messageList) empty() bool {
+	return ml.head == nil
+}
+
+func (ml *messageList) pop() *message {
+	if ml.head == nil {
+		return nil
+	}
+
+	msg := ml.head
+	ml.head = ml.head.next
+	if ml.head == nil {
+		ml.tail = nil
+	}
+	return msg
+}
@@ -0,0 +1,556 @@
+// +build !integration
+
+package kafka
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/protos"
+	"github.com/elastic/beats/packetbeat/protos/tcp"
+	"github.com/elastic/beats/packetbeat/publish"
+)
+
+// encoder builds Kafka messages for testing.
+type encoder struct {
+	buf      []byte
+	flexible bool
+}
+
+func (e *encoder) int8(v int8) *encoder {
+	e.buf = append(e.buf, byte(v))
+	return e
+}
+
+func (e *encoder) int16(v int16) *encoder {
+	e.buf = append(e.buf, byte(v>>8), byte(v))
+	return e
+}
+
+func (e *encoder) int32(v int32) *encoder {
+	var tmp [4]byte
+	binary.BigEndian.PutUint32(tmp[:], uint32(v))
+	e.buf = append(e.buf, tmp[:]...)
+	return e
+}
+
+func (e *encoder) int64(v int64) *encoder {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], uint64(v))
+	e.buf = append(e.buf, tmp[:]...)
+	return e
+}
+
+func (e *encoder) uvarint(v uint64) *encoder {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	e.buf = append(e.buf, tmp[:n]...)
+	return e
+}
+
+func (e *encoder) length(n int, int16Len bool) *encoder {
+	switch {
+	case e.flexible:
+		return e.uvarint(uint64(n + 1))
+	case int16Len:
+		return e.int16(int16(n))
+	default:
+		return e.int32(int32(n))
+	}
+}
+
+func (e *encoder) string(s string) *encoder {
+	e.length(len(s), true)
+	e.buf = append(e.buf, s...)
+	return e
+}
+
+func (e *encoder) bytes(b []byte) *encoder {
+	e.length(len(b), false)
+	e.buf = append(e.buf, b...)
+	return e
+}
+
+func (e *encoder) array(n int) *encoder {
+	return e.length(n, false)
+}
+
+func (e *encoder) tags() *encoder {
+	if e.flexible {
+		e.uvarint(0)
+	}
+	return e
+}
+
+// frame prefixes the message with its size.
+func (e *encoder) frame() []byte {
+	var tmp [4]byte
+	binary.BigEndian.PutUint32(tmp[:], uint32(len(e.buf)))
+	return append(tmp[:], e.buf...)
+}
+
+func requestHeader(api apiKey, version int16, correlationID int32, clientID string) *encoder {
+	e := &encoder{}
+	e.int16(int16(api)).int16(version).int32(correlationID).string(clientID)
+	e.flexible = api.isFlexible(version)
+	return e.tags()
+}
+
+func responseHeader(api apiKey, version int16, correlationID int32) *encoder {
+	e := &encoder{}
+	e.int32(correlationID)
+	e.flexible = api.isFlexible(version)
+	if api != apiAPIVersions {
+		e.tags()
+	}
+	return e
+}
+
+func produceRequest(correlationID int32, acks int16, records int) []byte {
+	e := requestHeader(apiProduce, 7, correlationID, "producer-1")
+	e.string("txn-1").int16(acks).int32(30000)
+	e.array(2)
+	e.string("orders").array(2)
+	e.int32(0).bytes(make([]byte, records))
+	e.int32(1).bytes(make([]byte, records))
+	e.string("events").array(1)
+	e.int32(3).bytes(nil)
+	return e.frame()
+}
+
+func produceResponse(correlationID int32, errorCode int16) []byte {
+	partition := func(e *encoder, index int32, code int16) {
+		e.int32(index).int16(code).int64(100).int64(-1).int64(0)
+	}
+
+	e := responseHeader(apiProduce, 7, correlationID)
+	e.array(2)
+	e.string("orders").array(2)
+	partition(e, 0, 0)
+	partition(e, 1, errorCode)
+	e.string("events").array(1)
+	partition(e, 3, 0)
+	e.int32(0) // throttle time
+	return e.frame()
+}
+
+type testPacket struct {
+	dir  uint8
+	data []byte
+}
+
+func kafkaModForTests(config *kafkaConfig) *kafkaPlugin {
+	if config == nil {
+		tmp := defaultConfig
+		tmp.Ports = []int{9092}
+		config = &tmp
+	}
+
+	var kafka kafkaPlugin
+	results := &publish.ChanTransactions{Channel: make(chan common.MapStr, 10)}
+	kafka.init(results, config)
+	return &kafka
+}
+
+func testTCPTuple() *common.TCPTuple {
+	t := &common.TCPTuple{
+		IPLength: 4,
+		SrcIP:    net.IPv4(192, 168, 0, 1), DstIP: net.IPv4(192, 168, 0, 2),
+		SrcPort: 6512, DstPort: 9092,
+	}
+	t.ComputeHashebles()
+	return t
+}
+
+func request(data []byte) testPacket {
+	return testPacket{tcp.TCPDirectionOriginal, data}
+}
+
+func response(data []byte) testPacket {
+	return testPacket{tcp.TCPDirectionReverse, data}
+}
+
+// replay feeds the packets into the plugin, 5 milliseconds apart.
+func replay(kafka *kafkaPlugin, packets ...testPacket) protos.ProtocolData {
+	tcptuple := testTCPTuple()
+	ts := time.Now()
+
+	var private protos.ProtocolData
+	for i, p := range packets {
+		pkt := &protos.Packet{
+			Ts:      ts.Add(time.Duration(i) * 5 * time.Millisecond),
+			Tuple:   *tcptuple.IPPort(),
+			Payload: p.data,
+		}
+		private = kafka.Parse(pkt, tcptuple, p.dir, private)
+	}
+	return private
+}
+
+// Helper function to read from the Publisher Queue
+func expectTransaction(t *testing.T, kafka *kafkaPlugin) common.MapStr {
+	client := kafka.results.(*publish.ChanTransactions)
+	select {
+	case trans := <-client.Channel:
+		return trans
+	default:
+		t.Error("No transaction")
+	}
+	return nil
+}
+
+func expectNoTransaction(t *testing.T, kafka *kafkaPlugin) {
+	client := kafka.results.(*publish.ChanTransactions)
+	select {
+	case trans := <-client.Channel:
+		t.Errorf("Unexpected transaction: %v", trans)
+	default:
+	}
+}
+
+func expectFields(t *testing.T, event common.MapStr, expected map[string]interface{}) {
+	for field, value := range expected {
+		actual, err := event.GetValue(field)
+		if assert.NoError(t, err, field) {
+			assert.Equal(t, value, actual, field)
+		}
+	}
+}
+
+func TestProduceTransaction(t *testing.T) {
+	if testing.Verbose() {
+		logp.LogInit(logp.LOG_DEBUG, "", false, true, []string{"kafka"})
+	}
+
+	kafka := kafkaModForTests(nil)
+	requ := produceRequest(42, 1, 100)
+	resp := produceResponse(42, 0)
+	replay(kafka, request(requ), response(resp))
+
+	event := expectTransaction(t, kafka)
+	expectNoTransaction(t, kafka)
+	expectFields(t, event, map[string]interface{}{
+		"type":                           "kafka",
+		"status":                         common.OK_STATUS,
+		"method":                         "Produce",
+		"responsetime":                   int32(5),
+		"bytes_out":                      uint64(len(requ)),
+		"bytes_in":                       uint64(len(resp)),
+		"kafka.api":                      "Produce",
+		"kafka.api_key":                  int16(0),
+		"kafka.api_version":              int16(7),
+		"kafka.correlation_id":           int32(42),
+		"kafka.client_id":                "producer-1",
+		"kafka.topics":                   []string{"orders", "events"},
+		"kafka.request.acks":             int16(1),
+		"kafka.request.timeout_ms":       int32(30000),
+		"kafka.request.transactional_id": "txn-1",
+		"kafka.request.record_bytes":     200,
+		"kafka.request.topics": []common.MapStr{
+			{"name": "orders", "partitions": []int32{0, 1}},
+			{"name": "events", "partitions": []int32{3}},
+		},
+		"kafka.response.throttle_time_ms": int32(0),
+	})
+
+	topics, _ := event.GetValue("kafka.response.topics")
+	assert.Equal(t, []common.MapStr{
+		{
+			"name": "orders",
+			"partitions": []common.MapStr{
+				{"partition": int32(0), "error_code": int16(0), "error": "NONE"},
+				{"partition": int32(1), "error_code": int16(0), "error": "NONE"},
+			},
+		},
+		{
+			"name": "events",
+			"partitions": []common.MapStr{
+				{"partition": int32(3), "error_code": int16(0), "error": "NONE"},
+			},
+		},
+	}, topics)
+
+	src := event["src"].(*common.Endpoint)
+	assert.Equal(t, 6512, int(src.Port))
+}
+
+func TestProducePartitionError(t *testing.T) {
+	kafka := kafkaModForTests(nil)
+	replay(kafka,
+		request(produceRequest(1, -1, 10)),
+		response(produceResponse(1, 6)))
+
+	event := expectTransaction(t, kafka)
+	expectFields(t, event, map[string]interface{}{
+		"status":                common.ERROR_STATUS,
+		"kafka.response.errors": []string{"NOT_LEADER_OR_FOLLOWER"},
+	})
+}
+
+func TestProduceWithoutAcks(t *testing.T) {
+	kafka := kafkaModForTests(nil)
+	replay(kafka, request(produceRequest(1, 0, 10)))
+
+	event := expectTransaction(t, kafka)
+	expectFields(t, event, map[string]interface{}{
+		"status":       common.OK_STATUS,
+		"responsetime": int32(-1),
+		"bytes_in":     uint64(0),
+	})
+	assert.NotContains(t, event["kafka"], "response")
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	kafka := kafkaModForTests(nil)
+
+	// two requests in one segment, second response split over two segments
+	requests := append(produceRequest(1, 1, 10), produceRequest(2, 1, 10)...)
+	resp2 := produceResponse(2, 0)
+	replay(kafka,
+		request(requests),
+		response(produceResponse(1, 0)),
+		response(resp2[:7]),
+		response(resp2[7:]))
+
+	for _, id := range []int32{1, 2} {
+		event := expectTransaction(t, kafka)
+		expectFields(t, event, map[string]interface{}{
+			"kafka.correlation_id": id,
+			"status":               common.OK_STATUS,
+		})
+	}
+	expectNoTransaction(t, kafka)
+}
+
+func TestMissingResponse(t *testing.T) {
+	kafka := kafkaModForTests(nil)
+	replay(kafka,
+		response(produceResponse(7, 0)),
+		request(produceRequest(1, 1, 10)),
+		request(produceRequest(2, 1, 10)),
+		response(produceResponse(2, 0)))
+
+	event := expectTransaction(t, kafka)
+	expectNoTransaction(t, kafka)
+	expectFields(t, event, map[string]interface{}{
+		"kafka.correlation_id": int32(2),
+	})
+}
+
+func TestFetchTransaction(t *testing.T) {
+	kafka := kafkaModForTests(nil)
+
+	requ := requestHeader(apiFetch, 11, 5, "consumer-1")
+	requ.int32(-1).int32(500).int32(1).int32(52428800).int8(1)
+	requ.int32(0).int32(-1) // session
+	requ.array(1).string("orders").array(1)
+	requ.int32(0).int32(3).int64(1234).int64(0).int32(1048576)
+	requ.array(0).string("rack-1") // forgotten topics, rack
+
+	resp := responseHeader(apiFetch, 11, 5)
+	resp.int32(10).int16(0).int32(0)
+	resp.array(1).string("orders").array(1)
+	resp.int32(0).int16(1).int64(2000).int64(2000).int64(0)
+	resp.array(-1)               // aborted transactions
+	resp.int32(-1)               // preferred read replica
+	resp.bytes(make([]byte, 64)) // records
+
+	replay(kafka, request(requ.frame()), response(resp.frame()))
+
+	event := expectTransaction(t, kafka)
+	expectFields(t, event, map[string]interface{}{
+		"status":                          common.ERROR_STATUS,
+		"method":                          "Fetch",
+		"kafka.client_id":                 "consumer-1",
+		"kafka.topics":                    []string{"orders"},
+		"kafka.request.max_wait_ms":       int32(500),
+		"kafka.request.min_bytes":         int32(1),
+		"kafka.request.max_bytes":         int32(52428800),
+		"kafka.request.isolation_level":   int8(1),
+		"kafka.response.error_code":       int16(0),
+		"kafka.response.error":            "NONE",
+		"kafka.response.throttle_time_ms": int32(10),
+		"kafka.response.record_bytes":     64,
+		"kafka.response.errors":           []string{"OFFSET_OUT_OF_RANGE"},
+	})
+}
+
+func TestMetadataFlexibleVersion(t *testing.T) {
+	kafka := kafkaModForTests(nil)
+
+	requ := requestHeader(apiMetadata, 9, 3, "admin")
+	requ.array(2)
+	requ.string("orders").tags()
+	requ.string("missing").tags()
+	requ.int8(1).int8(0).int8(0).tags()
+
+	resp := responseHeader(apiMetadata, 9, 3)
+	resp.int32(0)
+	resp.array(1).int32(1).string("broker-1").int32(9092).string("rack-1").tags()
+	resp.string("cluster-1").int32(1)
+	resp.array(2)
+	resp.int16(0).string("orders").int8(0).array(1)
+	resp.int16(0).int32(0).int32(1).int32(5).array(1).int32(1).array(1).int32(1).array(0).tags()
+	resp.int32(0).tags()
+	resp.int16(3).string("missing").int8(0).array(0).int32(0).tags()
+	resp.int32(0).tags()
+	resp.tags()
+
+	replay(kafka, request(requ.frame()), response(resp.frame()))
+
+	event := expectTransaction(t, kafka)
+	expectFields(t, event, map[string]interface{}{
+		"status":                       common.ERROR_STATUS,
+		"kafka.api":                    "Metadata",
+		"kafka.client_id":              "admin",
+		"kafka.topics":                 []string{"orders", "missing"},
+		"kafka.response.brokers":       1,
+		"kafka.response.cluster_id":    "cluster-1",
+		"kafka.response.controller_id": int32(1),
+		"kafka.response.errors":        []string{"UNKNOWN_TOPIC_OR_PARTITION"},
+	})
+
+	topics, _ := event.GetValue("kafka.response.topics")
+	assert.Equal(t, []common.MapStr{
+		{
+			"name": "orders",
+			"partitions": []common.MapStr{
+				{"partition": int32(0), "error_code": int16(0), "error": "NONE"},
+			},
+		},
+		{
+			"name":       "missing",
+			"error_code": int16(3),
+			"error":      "UNKNOWN_TOPIC_OR_PARTITION",
+		},
+	}, topics)
+	assert.NotContains(t, event, "notes")
+}
+
+func TestAPIVersionsResponseHeader(t *testing.T) {
+	kafka := kafkaModForTests(nil)
+
+	requ := requestHeader(apiAPIVersions, 3, 1, "client")
+	requ.string("apache-kafka-java").string("3.6.0").tags()
+
+	// response header has no tagged fields
+	resp := responseHeader(apiAPIVersions, 3, 1)
+	resp.int16(35)
+	resp.array(0).int32(0).tags()
+
+	replay(kafka, request(requ.frame()), response(resp.frame()))
+
+	event := expectTransaction(t, kafka)
+	expectFields(t, event, map[string]interface{}{
+		"status":               common.ERROR_STATUS,
+		"kafka.api":            "ApiVersions",
+		"kafka.response.error": "UNSUPPORTED_VERSION",
+	})
+}
+
+func TestGroupAPIs(t *testing.T) {
+	kafka := kafkaModForTests(nil)
+
+	requ := requestHeader(apiHeartbeat, 3, 9, "consumer-1")
+	requ.string("group-1").int32(4).string("member-1").string("")
+
+	resp := responseHeader(apiHeartbeat, 3, 9)
+	resp.int32(0).int16(27)
+
+	replay(kafka, request(requ.frame()), response(resp.frame()))
+
+	event := expectTransaction(t, kafka)
+	expectFields(t, event, map[string]interface{}{
+		"status":                      common.ERROR_STATUS,
+		"kafka.api":                   "Heartbeat",
+		"kafka.request.group_id":      "group-1",
+		"kafka.request.generation_id": int32(4),
+		"kafka.request.member_id":     "member-1",
+		"kafka.response.error":        "REBALANCE_IN_PROGRESS",
+	})
+}
+
+func TestUnknownAPI(t *testing.T) {
+	kafka := kafkaModForTests(nil)
+
+	requ := requestHeader(apiKey(32), 1, 2, "admin")
+	requ.buf = append(requ.buf, 1, 2, 3, 4)
+	resp := responseHeader(apiKey(32), 1, 2)
+	resp.buf = append(resp.buf, 5, 6, 7, 8)
+
+	replay(kafka, request(requ.frame()), response(resp.frame()))
+
+	event := expectTransaction(t, kafka)
+	expectFields(t, event, map[string]interface{}{
+		"status":            common.OK_STATUS,
+		"kafka.api":         "DescribeConfigs",
+		"kafka.api_version": int16(1),
+	})
+}
+
+func TestLargeMessage(t *testing.T) {
+	config := defaultConfig
+	config.Ports = []int{9092}
+	config.MaxMessageSize = 512
+	kafka := kafkaModForTests(&config)
+
+	requ := produceRequest(1, 1, 1000)
+	private := replay(kafka,
+		request(requ[:300]),
+		request(requ[300:1000]),
+		request(requ[1000:]),
+		response(produceResponse(1, 0)))
+	assert.NotNil(t, private)
+
+	event := expectTransaction(t, kafka)
+	expectFields(t, event, map[string]interface{}{
+		"bytes_out": uint64(len(requ)),
+		"notes":     []string{noteMessageTruncated},
+		"kafka.request.topics": []common.MapStr{
+			{"name": "orders"},
+		},
+	})
+
+	// stream is still in sync
+	replay(kafka,
+		request(produceRequest(2, 1, 10)),
+		response(produceResponse(2, 0)))
+	event = expectTransaction(t, kafka)
+	expectFields(t, event, map[string]interface{}{
+		"kafka.correlation_id": int32(2),
+	})
+}
+
+func TestGapInLargeMessage(t *testing.T) {
+	config := defaultConfig
+	config.Ports = []int{9092}
+	config.MaxMessageSize = 512
+	kafka := kafkaModForTests(&config)
+
+	requ := produceRequest(1, 1, 1000)
+	private := replay(kafka, request(requ[:600]))
+
+	private, drop := kafka.GapInStream(testTCPTuple(), tcp.TCPDirectionOriginal, 500, private)
+	assert.False(t, drop)
+
+	conn := private.(*kafkaConnectionData)
+	assert.Equal(t, len(requ)-1100, conn.streams[tcp.TCPDirectionOriginal].parser.skip)
+
+	_, drop = kafka.GapInStream(testTCPTuple(), tcp.TCPDirectionOriginal, 5000, private)
+	assert.True(t, drop)
+}
+
+func TestNonKafkaTraffic(t *testing.T) {
+	kafka := kafkaModForTests(nil)
+	private := replay(kafka, request([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")))
+
+	conn := private.(*kafkaConnectionData)
+	assert.Nil(t, conn.streams[tcp.TCPDirectionOriginal])
+	expectNoTransaction(t, kafka)
+}
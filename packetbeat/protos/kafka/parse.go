@@ -0,0 +1,176 @@
+package kafka
+
+import (
+	"errors"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common/streambuf"
+)
+
+const (
+	// size of the length prefix of each message
+	sizeFieldLen = 4
+
+	// api key, api version and correlation ID
+	minRequestHeaderLen = 8
+
+	// correlation ID
+	minResponseHeaderLen = 4
+
+	// upper bound of the message size accepted by the parser, such that
+	// random binary data is not mistaken for Kafka messages
+	maxFrameSize = 1 << 30
+)
+
+var (
+	errInvalidFrameSize = errors.New("invalid kafka message size")
+	errInvalidHeader    = errors.New("invalid kafka request header")
+)
+
+type parser struct {
+	maxMessageSize int
+
+	// number of bytes of the current message still to be skipped. Only the
+	// first maxMessageSize bytes of larger messages are buffered.
+	skip int
+}
+
+// frame is a complete (or truncated) Kafka message, without the size prefix.
+type frame struct {
+	ts        time.Time
+	size      int
+	body      []byte
+	truncated bool
+}
+
+// parse returns the next message available in buf. The returned body is only
+// valid until the stream buffer is reset.
+func (p *parser) parse(buf *streambuf.Buffer, ts time.Time, isRequest bool) (*frame, error) {
+	if p.skip > 0 {
+		n := p.skip
+		if n > buf.Len() {
+			n = buf.Len()
+		}
+		buf.Advance(n)
+		p.skip -= n
+		if p.skip > 0 {
+			return nil, nil
+		}
+	}
+
+	if !buf.Avail(sizeFieldLen) {
+		return nil, nil
+	}
+
+	tmp, _ := buf.ReadNetUint32At(0)
+	size := int(tmp)
+	minSize := minResponseHeaderLen
+	if isRequest {
+		minSize = minRequestHeaderLen
+	}
+	if size < minSize || size > maxFrameSize {
+		return nil, errInvalidFrameSize
+	}
+
+	f := &frame{ts: ts, size: sizeFieldLen + size}
+	if size > p.maxMessageSize {
+		// only buffer the beginning of large messages
+		if !buf.Avail(sizeFieldLen + p.maxMessageSize) {
+			return nil, nil
+		}
+		buf.Advance(sizeFieldLen)
+		f.body, _ = buf.Collect(p.maxMessageSize)
+		f.truncated = true
+		p.skip = size - p.maxMessageSize
+		return f, nil
+	}
+
+	if !buf.Avail(sizeFieldLen + size) {
+		return nil, nil
+	}
+	buf.Advance(sizeFieldLen)
+	f.body, _ = buf.Collect(size)
+	return f, nil
+}
+
+// gap updates the parser state after nbytes have been lost. It returns false
+// if the parser can not recover from the gap.
+func (p *parser) gap(buffered, nbytes int) bool {
+	if p.skip == 0 || buffered > 0 {
+		return false
+	}
+
+	// lost bytes are part of a message being skipped anyway
+	if nbytes > p.skip {
+		return false
+	}
+	p.skip -= nbytes
+	return true
+}
+
+// decodeRequestHeader decodes the request header (versions 0 to 2). The
+// client ID is no compact string, even in flexible versions.
+func decodeRequestHeader(msg *message, body []byte) (*decoder, error) {
+	d := newDecoder(body, false)
+	msg.apiKey = apiKey(d.int16())
+	msg.apiVersion = d.int16()
+	msg.correlationID = d.int32()
+	if msg.apiKey < 0 || msg.apiKey > maxAPIKey ||
+		msg.apiVersion < 0 || msg.apiVersion > maxAPIVersion {
+		return nil, errInvalidHeader
+	}
+
+	if clientID, ok := d.nullableString(); ok {
+		msg.clientID = clientID
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	d.flexible = msg.apiKey.isFlexible(msg.apiVersion)
+	d.taggedFields()
+	return d, d.err
+}
+
+// decodeResponseHeader decodes the correlation ID of a response.
+func decodeResponseHeader(msg *message, body []byte) *decoder {
+	d := newDecoder(body, false)
+	msg.correlationID = d.int32()
+	return d
+}
+
+// decodeResponseBody decodes the remaining response header fields and the
+// response body, based on the API key and version of the matching request.
+func decodeResponseBody(d *decoder, requ, resp *message) {
+	resp.apiKey = requ.apiKey
+	resp.apiVersion = requ.apiVersion
+
+	// ApiVersions responses always use the version 0 header, such that
+	// clients can parse the response without knowing the supported versions.
+	d.flexible = requ.apiKey.isFlexible(requ.apiVersion)
+	if d.flexible && requ.apiKey != apiAPIVersions {
+		d.taggedFields()
+	}
+	decodeBody(d, resp, false)
+}
+
+func decodeBody(d *decoder, msg *message, isRequest bool) {
+	info, found := apis[msg.apiKey]
+	if !found || msg.apiVersion > info.maxVersion {
+		return
+	}
+
+	decode := info.response
+	if isRequest {
+		decode = info.request
+	}
+	if decode == nil {
+		return
+	}
+
+	decode(d, msg.apiVersion, msg)
+	if d.err != nil && !msg.truncated {
+		debugf("failed to decode kafka %v message: %v", msg.apiKey, d.err)
+		msg.AddNotes(noteDecodingFailed)
+	}
+}
@@ -0,0 +1,192 @@
+package kafka
+
+import (
+	"sort"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func (t *transaction) init() {
+	requ, resp := t.request, t.response
+
+	t.InitWithMsg("kafka", &requ.Message)
+	t.BytesOut = requ.Size
+	t.Notes = append(t.Notes, requ.Notes...)
+	t.Status = common.OK_STATUS
+	if resp == nil {
+		t.ResponseTime = -1
+		return
+	}
+
+	t.BytesIn = resp.Size
+	t.ResponseTime = int32(resp.Ts.Sub(requ.Ts).Nanoseconds() / 1e6) // [ms]
+	t.Notes = append(t.Notes, resp.Notes...)
+	if len(resp.errors()) > 0 {
+		t.Status = common.ERROR_STATUS
+	}
+}
+
+func (t *transaction) Event(event common.MapStr) error {
+	if err := t.Transaction.Event(event); err != nil {
+		return err
+	}
+
+	requ, resp := t.request, t.response
+	event["method"] = requ.apiKey.String()
+
+	kafka := common.MapStr{
+		"api":            requ.apiKey.String(),
+		"api_key":        int16(requ.apiKey),
+		"api_version":    requ.apiVersion,
+		"correlation_id": requ.correlationID,
+	}
+	if requ.clientID != "" {
+		kafka["client_id"] = requ.clientID
+	}
+
+	names := topicNames(nil, requ.topics)
+	kafka["request"] = requ.requestFields()
+	if resp != nil {
+		names = topicNames(names, resp.topics)
+		kafka["response"] = resp.responseFields()
+	}
+	if len(names) > 0 {
+		kafka["topics"] = names
+	}
+
+	event["kafka"] = kafka
+	return nil
+}
+
+func (msg *message) setError(code int16) {
+	msg.errorCode = errorCode(code)
+	msg.hasError = true
+}
+
+func (t *topic) setError(code int16) {
+	t.errorCode = errorCode(code)
+}
+
+func (p *partition) setError(code int16) {
+	p.errorCode = errorCode(code)
+}
+
+// errors returns the sorted list of all errors reported in a response.
+func (msg *message) errors() []string {
+	set := map[errorCode]struct{}{}
+	if msg.hasError && msg.errorCode != 0 {
+		set[msg.errorCode] = struct{}{}
+	}
+	for _, t := range msg.topics {
+		if t.errorCode != 0 {
+			set[t.errorCode] = struct{}{}
+		}
+		for _, p := range t.partitions {
+			if p.errorCode != 0 {
+				set[p.errorCode] = struct{}{}
+			}
+		}
+	}
+
+	if len(set) == 0 {
+		return nil
+	}
+	errors := make([]string, 0, len(set))
+	for code := range set {
+		errors = append(errors, code.String())
+	}
+	sort.Strings(errors)
+	return errors
+}
+
+func (msg *message) requestFields() common.MapStr {
+	fields := msg.fields
+	if len(msg.topics) > 0 {
+		topics := make([]common.MapStr, len(msg.topics))
+		for i, t := range msg.topics {
+			topics[i] = t.toMap()
+			if len(t.partitions) > 0 {
+				partitions := make([]int32, len(t.partitions))
+				for j, p := range t.partitions {
+					partitions[j] = p.index
+				}
+				topics[i]["partitions"] = partitions
+			}
+		}
+		fields["topics"] = topics
+	}
+	return fields
+}
+
+func (msg *message) responseFields() common.MapStr {
+	fields := msg.fields
+	if msg.hasError {
+		fields["error_code"] = int16(msg.errorCode)
+		fields["error"] = msg.errorCode.String()
+	}
+	if msg.throttleTime >= 0 {
+		fields["throttle_time_ms"] = msg.throttleTime
+	}
+	if errors := msg.errors(); len(errors) > 0 {
+		fields["errors"] = errors
+	}
+
+	if len(msg.topics) > 0 {
+		topics := make([]common.MapStr, len(msg.topics))
+		for i, t := range msg.topics {
+			topics[i] = t.toMap()
+			if t.errorCode != 0 {
+				topics[i]["error_code"] = int16(t.errorCode)
+				topics[i]["error"] = t.errorCode.String()
+			}
+			if len(t.partitions) > 0 {
+				partitions := make([]common.MapStr, len(t.partitions))
+				for j, p := range t.partitions {
+					partitions[j] = common.MapStr{
+						"partition":  p.index,
+						"error_code": int16(p.errorCode),
+						"error":      p.errorCode.String(),
+					}
+				}
+				topics[i]["partitions"] = partitions
+			}
+		}
+		fields["topics"] = topics
+	}
+	return fields
+}
+
+func (t *topic) toMap() common.MapStr {
+	m := common.MapStr{}
+	if t.name != "" {
+		m["name"] = t.name
+	}
+	if t.id != "" {
+		m["id"] = t.id
+	}
+	return m
+}
+
+// topicNames adds the names (or IDs) of the topics not yet in names.
+func topicNames(names []string, topics []topic) []string {
+	for _, t := range topics {
+		name := t.name
+		if name == "" {
+			name = t.id
+		}
+		if name == "" || containsString(names, name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -174,7 +174,7 @@ func (mc *memcache) onTCPMessage(
 ) error {
 	msg.Tuple = *tuple
 	msg.Transport = applayer.TransportTCP
-	msg.CmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tuple)
+	msg.CmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tuple, procs.TransportTCP)
 
 	if msg.IsRequest {
 		return mc.onTCPRequest(conn, tuple, dir, msg)
@@ -167,7 +167,7 @@ func (mc *memcache) onUDPMessage(
 	}
 	msg.Tuple = *tuple
 	msg.Transport = applayer.TransportUDP
-	msg.CmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tuple)
+	msg.CmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tuple, procs.TransportUDP)
 
 	done := false
 	var err error
@@ -200,7 +200,7 @@ func (mongodb *mongodbPlugin) handleMongodb(
 
 	m.tcpTuple = *tcptuple
 	m.direction = dir
-	m.cmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort())
+	m.cmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort(), procs.TransportTCP)
 
 	if m.isResponse {
 		debugf("MongoDB response message")
@@ -268,6 +268,7 @@ func newTransaction(requ, resp *mongodbMessage) *transaction {
 		trans.method = requ.method
 
 		trans.cmdline = requ.cmdlineTuple
+		trans.tunnel = requ.tcpTuple.Tunnel
 		trans.ts = requ.ts
 		trans.src = common.Endpoint{
 			IP:   requ.tcpTuple.SrcIP.String(),
@@ -386,6 +387,7 @@ func (mongodb *mongodbPlugin) publishTransaction(t *transaction) {
 	event["@timestamp"] = common.Time(t.ts)
 	event["src"] = &t.src
 	event["dst"] = &t.dst
+	protos.AddTunnel(event, t.tunnel)
 
 	if mongodb.sendRequest {
 		event["request"] = reconstructQuery(t, true)
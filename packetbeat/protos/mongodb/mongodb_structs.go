@@ -62,6 +62,7 @@ type mongodbConnectionData struct {
 // These transactions are the end product of this parser
 type transaction struct {
 	cmdline      *common.CmdlineTuple
+	tunnel       common.TunnelID
 	src          common.Endpoint
 	dst          common.Endpoint
 	responseTime int32
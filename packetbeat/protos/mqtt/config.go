@@ -0,0 +1,43 @@
+package mqtt
+
+import (
+	"fmt"
+
+	"github.com/elastic/beats/packetbeat/config"
+	"github.com/elastic/beats/packetbeat/protos"
+	"github.com/elastic/beats/packetbeat/protos/tcp"
+)
+
+type mqttConfig struct {
+	config.ProtocolCommon `config:",inline"`
+
+	// Maximum number of bytes buffered per message. Larger PUBLISH messages
+	// are only partially decoded, their payload is not reported.
+	MaxMessageSize int `config:"max_message_size"`
+
+	// If enabled, the payload of the PUBLISH messages is added to the events,
+	// up to max_payload_size bytes.
+	IncludePayload bool `config:"include_payload"`
+	MaxPayloadSize int  `config:"max_payload_size"`
+}
+
+var (
+	defaultConfig = mqttConfig{
+		ProtocolCommon: config.ProtocolCommon{
+			TransactionTimeout: protos.DefaultTransactionExpiration,
+		},
+		MaxMessageSize: 1 << 20,
+		MaxPayloadSize: 1024,
+	}
+)
+
+func (c *mqttConfig) Validate() error {
+	if c.MaxMessageSize <= 0 || c.MaxMessageSize >= tcp.TCPMaxDataInStream {
+		return fmt.Errorf("max_message_size must be between 1 and %v bytes",
+			tcp.TCPMaxDataInStream-1)
+	}
+	if c.MaxPayloadSize < 0 {
+		return fmt.Errorf("max_payload_size must be >= 0")
+	}
+	return nil
+}
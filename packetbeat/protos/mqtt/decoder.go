@@ -0,0 +1,191 @@
+package mqtt
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+var (
+	errTruncated       = errors.New("truncated mqtt message")
+	errInvalidVarint   = errors.New("invalid variable byte integer in mqtt message")
+	errInvalidProperty = errors.New("invalid property in mqtt message")
+)
+
+// maximum number of bytes of a variable byte integer
+const maxVarintLen = 4
+
+// decoder reads the data types of the MQTT protocol from a message body.
+// Errors are sticky: once a read fails all following reads return zero values
+// and the error is reported by err.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func newDecoder(buf []byte) *decoder {
+	return &decoder{buf: buf}
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.buf = nil
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf) < n {
+		d.fail(errTruncated)
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) empty() bool {
+	return len(d.buf) == 0
+}
+
+func (d *decoder) uint8() uint8 {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) uint16() uint16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+// varint reads a variable byte integer.
+func (d *decoder) varint() int {
+	v, n := decodeVarint(d.buf)
+	if n < 0 {
+		d.fail(errInvalidVarint)
+		return 0
+	}
+	if n == 0 {
+		d.fail(errTruncated)
+		return 0
+	}
+	d.next(n)
+	return v
+}
+
+// binary reads binary data prefixed by its 2 bytes length.
+func (d *decoder) binary() []byte {
+	n := d.uint16()
+	return d.next(int(n))
+}
+
+// string reads an UTF-8 string prefixed by its 2 bytes length.
+func (d *decoder) string() string {
+	return string(d.binary())
+}
+
+// rest returns the remaining bytes of the message.
+func (d *decoder) rest() []byte {
+	return d.next(len(d.buf))
+}
+
+// properties reads the properties of an MQTT 5 message. The properties
+// reported in the events are returned, the others are skipped.
+func (d *decoder) properties() common.MapStr {
+	n := d.varint()
+	props := newDecoder(d.next(n))
+	if d.err != nil {
+		return nil
+	}
+
+	fields := common.MapStr{}
+	for !props.empty() && props.err == nil {
+		id := props.varint()
+		switch id {
+		// byte
+		case 0x01, 0x17, 0x19, 0x24, 0x25, 0x28, 0x29, 0x2a:
+			props.uint8()
+
+		// two bytes integer
+		case 0x13, 0x21, 0x22:
+			props.uint16()
+		case 0x23:
+			fields["topic_alias"] = props.uint16()
+
+		// four bytes integer
+		case 0x18, 0x27:
+			props.uint32()
+		case 0x02:
+			fields["message_expiry_interval"] = props.uint32()
+		case 0x11:
+			fields["session_expiry_interval"] = props.uint32()
+
+		// variable byte integer
+		case 0x0b:
+			props.varint()
+
+		// string
+		case 0x15, 0x1a, 0x1c:
+			props.string()
+		case 0x03:
+			fields["content_type"] = props.string()
+		case 0x08:
+			fields["response_topic"] = props.string()
+		case 0x12:
+			fields["assigned_client_id"] = props.string()
+		case 0x1f:
+			fields["reason_string"] = props.string()
+
+		// binary data
+		case 0x09, 0x16:
+			props.binary()
+
+		// string pair
+		case 0x26:
+			props.string()
+			props.string()
+
+		default:
+			props.fail(errInvalidProperty)
+		}
+	}
+	if props.err != nil {
+		d.fail(props.err)
+	}
+	return fields
+}
+
+// decodeVarint decodes a variable byte integer. The number of bytes read is
+// 0 if more bytes are needed, or -1 if the encoding is invalid.
+func decodeVarint(buf []byte) (int, int) {
+	v, shift := 0, uint(0)
+	for i := 0; i < maxVarintLen; i++ {
+		if i >= len(buf) {
+			return 0, 0
+		}
+		v |= int(buf[i]&0x7f) << shift
+		if buf[i]&0x80 == 0 {
+			return v, i + 1
+		}
+		shift += 7
+	}
+	return 0, -1
+}
@@ -0,0 +1,524 @@
+package mqtt
+
+import (
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+
+	"github.com/elastic/beats/packetbeat/procs"
+	"github.com/elastic/beats/packetbeat/protos"
+	"github.com/elastic/beats/packetbeat/protos/applayer"
+	"github.com/elastic/beats/packetbeat/protos/tcp"
+	"github.com/elastic/beats/packetbeat/publish"
+)
+
+type stream struct {
+	applayer.Stream
+	parser parser
+}
+
+type mqttConnectionData struct {
+	streams [2]*stream
+
+	// protocol level and client identity, from the CONNECT message
+	connected bool
+	clientDir uint8
+	version   uint8
+	clientID  string
+	username  string
+
+	// CONNECT and PINGREQ messages waiting for a response. Both are only
+	// sent by the client.
+	connect *message
+	ping    *message
+
+	// PUBLISH, SUBSCRIBE and UNSUBSCRIBE messages waiting to be acknowledged,
+	// by direction and packet identifier. Both the client and the broker
+	// send PUBLISH messages, each using its own packet identifiers.
+	pending [2]map[uint16]*message
+
+	// topic aliases of MQTT 5, by direction
+	aliases [2]map[uint16]string
+}
+
+// message is a decoded MQTT packet.
+type message struct {
+	applayer.Message
+
+	typ         packetType
+	truncated   bool
+	packetID    uint16
+	hasPacketID bool
+
+	// CONNECT
+	protocolName  string
+	protocolLevel uint8
+	clientID      string
+	username      string
+	cleanSession  bool
+	keepAlive     uint16
+	will          common.MapStr
+
+	// CONNACK
+	sessionPresent bool
+
+	// reason code of acknowledgments, and of DISCONNECT and AUTH messages
+	reasonCode    uint8
+	hasReasonCode bool
+
+	// PUBLISH
+	topic       string
+	qos         uint8
+	retain      bool
+	dup         bool
+	payloadSize int
+	payload     []byte
+
+	// SUBSCRIBE and UNSUBSCRIBE
+	topics          []string
+	subscriptionQoS []uint8
+
+	// SUBACK and UNSUBACK
+	reasonCodes []uint8
+
+	// MQTT 5 properties
+	properties common.MapStr
+
+	// PUBREC and PUBREL messages of QoS 2 deliveries
+	ack, release *message
+}
+
+type transaction struct {
+	applayer.Transaction
+
+	version  uint8
+	clientID string
+	username string
+
+	request  *message
+	response *message
+}
+
+// MQTT protocol plugin
+type mqttPlugin struct {
+	// config
+	ports              protos.PortsConfig
+	maxMessageSize     int
+	includePayload     bool
+	maxPayloadSize     int
+	transactionTimeout time.Duration
+
+	results publish.Transactions
+}
+
+var (
+	debugf  = logp.MakeDebug("mqtt")
+	isDebug = false
+)
+
+var (
+	unmatchedRequests  = monitoring.NewInt(nil, "mqtt.unmatched_requests")
+	unmatchedResponses = monitoring.NewInt(nil, "mqtt.unmatched_responses")
+)
+
+const (
+	noteMessageTruncated = "Message too large, only partially decoded"
+	noteDecodingFailed   = "Failed to decode message"
+	noteUnknownVersion   = "CONNECT message not seen, assuming MQTT 3.1.1"
+)
+
+func init() {
+	protos.Register("mqtt", New)
+}
+
+func New(
+	testMode bool,
+	results publish.Transactions,
+	cfg *common.Config,
+) (protos.Plugin, error) {
+	p := &mqttPlugin{}
+	config := defaultConfig
+	if !testMode {
+		if err := cfg.Unpack(&config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.init(results, &config); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (mqtt *mqttPlugin) init(results publish.Transactions, config *mqttConfig) error {
+	if err := mqtt.setFromConfig(config); err != nil {
+		return err
+	}
+
+	mqtt.results = results
+	isDebug = logp.IsDebug("mqtt")
+
+	return nil
+}
+
+func (mqtt *mqttPlugin) setFromConfig(config *mqttConfig) error {
+	if err := mqtt.ports.Set(config.Ports); err != nil {
+		return err
+	}
+	mqtt.maxMessageSize = config.MaxMessageSize
+	mqtt.includePayload = config.IncludePayload
+	mqtt.maxPayloadSize = config.MaxPayloadSize
+	mqtt.transactionTimeout = config.TransactionTimeout
+	return nil
+}
+
+func (mqtt *mqttPlugin) GetPorts() []int {
+	return mqtt.ports.Ports
+}
+
+func (mqtt *mqttPlugin) ConnectionTimeout() time.Duration {
+	return mqtt.transactionTimeout
+}
+
+func (mqtt *mqttPlugin) Parse(
+	pkt *protos.Packet,
+	tcptuple *common.TCPTuple,
+	dir uint8,
+	private protos.ProtocolData,
+) protos.ProtocolData {
+	defer logp.Recover("ParseMqtt exception")
+
+	conn := ensureMqttConnection(private)
+	conn = mqtt.doParse(conn, pkt, tcptuple, dir)
+	if conn == nil {
+		return nil
+	}
+	return conn
+}
+
+func ensureMqttConnection(private protos.ProtocolData) *mqttConnectionData {
+	if private == nil {
+		return &mqttConnectionData{}
+	}
+
+	priv, ok := private.(*mqttConnectionData)
+	if !ok {
+		logp.Warn("mqtt connection data type error, create new one")
+		return &mqttConnectionData{}
+	}
+	if priv == nil {
+		logp.Warn("Unexpected: mqtt connection data not set, create new one")
+		return &mqttConnectionData{}
+	}
+
+	return priv
+}
+
+func (mqtt *mqttPlugin) doParse(
+	conn *mqttConnectionData,
+	pkt *protos.Packet,
+	tcptuple *common.TCPTuple,
+	dir uint8,
+) *mqttConnectionData {
+	st := conn.streams[dir]
+	if st == nil {
+		st = &stream{}
+		st.parser.maxMessageSize = mqtt.maxMessageSize
+		st.Stream.Init(tcp.TCPMaxDataInStream)
+		conn.streams[dir] = st
+		if isDebug {
+			debugf("new stream: %p (dir=%v, len=%v)", st, dir, len(pkt.Payload))
+		}
+	}
+
+	if err := st.Append(pkt.Payload); err != nil {
+		if isDebug {
+			debugf("%v, dropping TCP stream", err)
+		}
+		return nil
+	}
+
+	for st.Buf.Len() > 0 {
+		f, err := st.parser.parse(&st.Buf, pkt.Ts)
+		if err != nil {
+			// drop this tcp stream. Will retry parsing with the next
+			// segment in it
+			conn.streams[dir] = nil
+			if isDebug {
+				debugf("Ignore MQTT message (%v). Drop tcp stream. Try parsing with the next segment", err)
+			}
+			return conn
+		}
+		if f == nil {
+			// wait for more data
+			break
+		}
+
+		msg := mqtt.newMessage(conn, f, tcptuple, dir)
+		st.Stream.Reset()
+		mqtt.onMessage(conn, msg, dir)
+	}
+	st.Stream.Reset()
+
+	return conn
+}
+
+func (mqtt *mqttPlugin) newMessage(
+	conn *mqttConnectionData,
+	f *frame,
+	tcptuple *common.TCPTuple,
+	dir uint8,
+) *message {
+	msg := &message{typ: f.typ, truncated: f.truncated}
+	msg.Ts = f.ts
+	msg.Tuple = *tcptuple.IPPort()
+	msg.Transport = applayer.TransportTCP
+	msg.CmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort(), procs.TransportTCP)
+	msg.IsRequest = mqtt.isClient(conn, tcptuple, dir)
+	msg.Size = uint64(f.size)
+	if dir == tcp.TCPDirectionOriginal {
+		msg.Direction = applayer.NetOriginalDirection
+	} else {
+		msg.Direction = applayer.NetReverseDirection
+	}
+
+	if f.truncated {
+		msg.AddNotes(noteMessageTruncated)
+	}
+
+	version := conn.version
+	if f.typ == packetConnect {
+		version = 0
+	} else if version == 0 {
+		version = protocolLevel311
+		msg.AddNotes(noteUnknownVersion)
+	}
+	if err := decodeMessage(msg, f, version); err != nil {
+		if isDebug {
+			debugf("failed to decode mqtt %v message: %v", f.typ, err)
+		}
+		msg.AddNotes(noteDecodingFailed)
+	}
+
+	// copy the payload, the frame is only valid until the stream is reset
+	if mqtt.includePayload && len(msg.payload) > 0 {
+		payload := msg.payload
+		if len(payload) > mqtt.maxPayloadSize {
+			payload = payload[:mqtt.maxPayloadSize]
+		}
+		msg.payload = append([]byte(nil), payload...)
+	} else {
+		msg.payload = nil
+	}
+	return msg
+}
+
+// isClient checks if a message is sent by the client of the connection:
+// either the sender of the CONNECT message, or the peer of one of the
+// configured MQTT ports.
+func (mqtt *mqttPlugin) isClient(conn *mqttConnectionData, tcptuple *common.TCPTuple, dir uint8) bool {
+	if conn.connected {
+		return dir == conn.clientDir
+	}
+
+	port := tcptuple.DstPort
+	if dir == tcp.TCPDirectionReverse {
+		port = tcptuple.SrcPort
+	}
+	for _, p := range mqtt.ports.Ports {
+		if int(port) == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (mqtt *mqttPlugin) onMessage(conn *mqttConnectionData, msg *message, dir uint8) {
+	if isDebug {
+		debugf("mqtt (%p) %v: packet_id=%v", conn, msg.typ, msg.packetID)
+	}
+
+	switch msg.typ {
+	case packetConnect:
+		if conn.connect != nil {
+			unmatchedRequests.Add(1)
+		}
+		conn.connect = msg
+		conn.connected = true
+		conn.clientDir = dir
+		conn.version = msg.protocolLevel
+		conn.clientID = msg.clientID
+		conn.username = msg.username
+		conn.pending = [2]map[uint16]*message{}
+		conn.aliases = [2]map[uint16]string{}
+
+	case packetConnack:
+		requ := conn.connect
+		if requ == nil {
+			debugf("Response from unknown transaction. Ignoring")
+			unmatchedResponses.Add(1)
+			return
+		}
+		conn.connect = nil
+		if id, ok := msg.properties["assigned_client_id"].(string); ok && conn.clientID == "" {
+			conn.clientID = id
+		}
+		mqtt.publish(conn, requ, msg)
+
+	case packetPingreq:
+		if conn.ping != nil {
+			unmatchedRequests.Add(1)
+		}
+		conn.ping = msg
+
+	case packetPingresp:
+		requ := conn.ping
+		conn.ping = nil
+		if requ == nil {
+			debugf("Response from unknown transaction. Ignoring")
+			unmatchedResponses.Add(1)
+			return
+		}
+		mqtt.publish(conn, requ, msg)
+
+	case packetPublish:
+		conn.resolveTopicAlias(msg, dir)
+		if msg.qos == 0 {
+			mqtt.publish(conn, msg, nil)
+			return
+		}
+		conn.addPending(msg, dir)
+
+	case packetSubscribe, packetUnsubscribe:
+		conn.addPending(msg, dir)
+
+	case packetPuback, packetPubcomp, packetSuback, packetUnsuback:
+		requ := conn.removePending(msg, 1-dir)
+		if requ == nil {
+			return
+		}
+		mqtt.publish(conn, requ, msg)
+
+	case packetPubrec:
+		requ := conn.findPending(msg, 1-dir)
+		if requ == nil {
+			return
+		}
+		requ.ack = msg
+		if msg.hasReasonCode && isFailure(msg.typ, conn.version, msg.reasonCode) {
+			// the delivery stops on failure, no PUBREL is sent
+			delete(conn.pending[1-dir], msg.packetID)
+			mqtt.publish(conn, requ, msg)
+		}
+
+	case packetPubrel:
+		requ := conn.findPending(msg, dir)
+		if requ != nil {
+			requ.release = msg
+		}
+
+	case packetDisconnect, packetAuth:
+		mqtt.publish(conn, msg, nil)
+	}
+}
+
+// expectedRequests are the types of the requests matching an acknowledgment.
+var expectedRequests = map[packetType]packetType{
+	packetPuback:   packetPublish,
+	packetPubrec:   packetPublish,
+	packetPubrel:   packetPublish,
+	packetPubcomp:  packetPublish,
+	packetSuback:   packetSubscribe,
+	packetUnsuback: packetUnsubscribe,
+}
+
+func (conn *mqttConnectionData) addPending(msg *message, dir uint8) {
+	pending := conn.pending[dir]
+	if pending == nil {
+		pending = map[uint16]*message{}
+		conn.pending[dir] = pending
+	}
+	if _, exists := pending[msg.packetID]; exists {
+		debugf("Request without response. Ignoring")
+		unmatchedRequests.Add(1)
+	}
+	pending[msg.packetID] = msg
+}
+
+// findPending returns the request sent in direction dir, acknowledged by msg.
+func (conn *mqttConnectionData) findPending(msg *message, dir uint8) *message {
+	requ := conn.pending[dir][msg.packetID]
+	if requ == nil || requ.typ != expectedRequests[msg.typ] ||
+		(msg.typ != packetPuback && requ.typ == packetPublish && requ.qos != 2) ||
+		(msg.typ == packetPuback && requ.qos != 1) {
+
+		debugf("Response from unknown transaction. Ignoring")
+		unmatchedResponses.Add(1)
+		return nil
+	}
+	return requ
+}
+
+func (conn *mqttConnectionData) removePending(msg *message, dir uint8) *message {
+	requ := conn.findPending(msg, dir)
+	if requ != nil {
+		delete(conn.pending[dir], msg.packetID)
+	}
+	return requ
+}
+
+// resolveTopicAlias sets the topic of MQTT 5 PUBLISH messages sent with a
+// topic alias only, and records the new aliases.
+func (conn *mqttConnectionData) resolveTopicAlias(msg *message, dir uint8) {
+	alias, ok := msg.properties["topic_alias"].(uint16)
+	if !ok {
+		return
+	}
+
+	if msg.topic != "" {
+		if conn.aliases[dir] == nil {
+			conn.aliases[dir] = map[uint16]string{}
+		}
+		conn.aliases[dir][alias] = msg.topic
+		return
+	}
+	msg.topic = conn.aliases[dir][alias]
+}
+
+func (mqtt *mqttPlugin) publish(conn *mqttConnectionData, requ, resp *message) {
+	if mqtt.results == nil {
+		return
+	}
+
+	t := &transaction{
+		version:  conn.version,
+		clientID: conn.clientID,
+		username: conn.username,
+		request:  requ,
+		response: resp,
+	}
+	t.init()
+	event := common.MapStr{}
+	t.Event(event)
+	mqtt.results.PublishTransaction(event)
+}
+
+func (mqtt *mqttPlugin) GapInStream(tcptuple *common.TCPTuple, dir uint8,
+	nbytes int, private protos.ProtocolData) (priv protos.ProtocolData, drop bool) {
+
+	conn := ensureMqttConnection(private)
+	st := conn.streams[dir]
+	if st != nil && st.parser.gap(st.Buf.Len(), nbytes) {
+		// gap is part of a large message not being buffered
+		return conn, false
+	}
+	return private, true
+}
+
+func (mqtt *mqttPlugin) ReceivedFin(tcptuple *common.TCPTuple, dir uint8,
+	private protos.ProtocolData) protos.ProtocolData {
+
+	return private
+}
@@ -0,0 +1,462 @@
+// +build !integration
+
+package mqtt
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/protos"
+	"github.com/elastic/beats/packetbeat/protos/tcp"
+	"github.com/elastic/beats/packetbeat/publish"
+)
+
+// encoder builds MQTT packets for testing.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint8(v uint8) *encoder {
+	e.buf = append(e.buf, v)
+	return e
+}
+
+func (e *encoder) uint16(v uint16) *encoder {
+	e.buf = append(e.buf, byte(v>>8), byte(v))
+	return e
+}
+
+func (e *encoder) string(s string) *encoder {
+	e.uint16(uint16(len(s)))
+	e.buf = append(e.buf, s...)
+	return e
+}
+
+func (e *encoder) bytes(b []byte) *encoder {
+	e.buf = append(e.buf, b...)
+	return e
+}
+
+func (e *encoder) varint(v int) *encoder {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v > 0 {
+			e.buf = append(e.buf, b|0x80)
+			continue
+		}
+		e.buf = append(e.buf, b)
+		return e
+	}
+}
+
+// properties encodes MQTT 5 properties.
+func (e *encoder) properties(props ...byte) *encoder {
+	return e.varint(len(props)).bytes(props)
+}
+
+// packet prefixes the body with the fixed header.
+func (e *encoder) packet(typ packetType, flags uint8) []byte {
+	out := &encoder{}
+	out.uint8(uint8(typ)<<4 | flags).varint(len(e.buf))
+	return append(out.buf, e.buf...)
+}
+
+func connect(level uint8, clientID string, props ...byte) []byte {
+	e := &encoder{}
+	e.string("MQTT").uint8(level)
+	e.uint8(connectUsername | connectPassword | connectWill | 1<<3 | connectCleanSession)
+	e.uint16(60)
+	if level == protocolLevel5 {
+		e.properties(props...)
+	}
+	e.string(clientID)
+	if level == protocolLevel5 {
+		e.properties()
+	}
+	e.string("devices/sensor-1/status").string("offline")
+	e.string("sensor").string("secret")
+	return e.packet(packetConnect, 0)
+}
+
+func connack(level uint8, code uint8, props ...byte) []byte {
+	e := &encoder{}
+	e.uint8(0).uint8(code)
+	if level == protocolLevel5 {
+		e.properties(props...)
+	}
+	return e.packet(packetConnack, 0)
+}
+
+func publishPacket(level uint8, qos uint8, id uint16, topic string, payload string, props ...byte) []byte {
+	e := &encoder{}
+	e.string(topic)
+	if qos > 0 {
+		e.uint16(id)
+	}
+	if level == protocolLevel5 {
+		e.properties(props...)
+	}
+	e.bytes([]byte(payload))
+	return e.packet(packetPublish, qos<<1|publishRetain)
+}
+
+func ack(typ packetType, id uint16, reason ...byte) []byte {
+	e := &encoder{}
+	e.uint16(id).bytes(reason)
+	flags := uint8(0)
+	if typ == packetPubrel {
+		flags = 0x02
+	}
+	return e.packet(typ, flags)
+}
+
+func subscribe(id uint16, topics ...string) []byte {
+	e := &encoder{}
+	e.uint16(id)
+	for i, topic := range topics {
+		e.string(topic).uint8(uint8(i % 3))
+	}
+	return e.packet(packetSubscribe, 0x02)
+}
+
+func suback(id uint16, codes ...byte) []byte {
+	e := &encoder{}
+	e.uint16(id).bytes(codes)
+	return e.packet(packetSuback, 0)
+}
+
+type testPacket struct {
+	dir  uint8
+	data []byte
+}
+
+func mqttModForTests(config *mqttConfig) *mqttPlugin {
+	if config == nil {
+		tmp := defaultConfig
+		tmp.Ports = []int{1883}
+		config = &tmp
+	}
+
+	var mqtt mqttPlugin
+	results := &publish.ChanTransactions{Channel: make(chan common.MapStr, 10)}
+	mqtt.init(results, config)
+	return &mqtt
+}
+
+func testTCPTuple() *common.TCPTuple {
+	t := &common.TCPTuple{
+		IPLength: 4,
+		SrcIP:    net.IPv4(192, 168, 0, 1), DstIP: net.IPv4(192, 168, 0, 2),
+		SrcPort: 6512, DstPort: 1883,
+	}
+	t.ComputeHashebles()
+	return t
+}
+
+func client(data []byte) testPacket {
+	return testPacket{tcp.TCPDirectionOriginal, data}
+}
+
+func broker(data []byte) testPacket {
+	return testPacket{tcp.TCPDirectionReverse, data}
+}
+
+// replay feeds the packets into the plugin, 5 milliseconds apart.
+func replay(mqtt *mqttPlugin, private protos.ProtocolData, packets ...testPacket) protos.ProtocolData {
+	tcptuple := testTCPTuple()
+	ts := time.Now()
+
+	for i, p := range packets {
+		pkt := &protos.Packet{
+			Ts:      ts.Add(time.Duration(i) * 5 * time.Millisecond),
+			Tuple:   *tcptuple.IPPort(),
+			Payload: p.data,
+		}
+		private = mqtt.Parse(pkt, tcptuple, p.dir, private)
+	}
+	return private
+}
+
+// Helper function to read from the Publisher Queue
+func expectTransaction(t *testing.T, mqtt *mqttPlugin) common.MapStr {
+	client := mqtt.results.(*publish.ChanTransactions)
+	select {
+	case trans := <-client.Channel:
+		return trans
+	default:
+		t.Error("No transaction")
+	}
+	return nil
+}
+
+func expectNoTransaction(t *testing.T, mqtt *mqttPlugin) {
+	client := mqtt.results.(*publish.ChanTransactions)
+	select {
+	case trans := <-client.Channel:
+		t.Errorf("Unexpected transaction: %v", trans)
+	default:
+	}
+}
+
+func expectFields(t *testing.T, event common.MapStr, expected map[string]interface{}) {
+	for field, value := range expected {
+		actual, err := event.GetValue(field)
+		if assert.NoError(t, err, field) {
+			assert.Equal(t, value, actual, field)
+		}
+	}
+}
+
+func TestConnectTransaction(t *testing.T) {
+	if testing.Verbose() {
+		logp.LogInit(logp.LOG_DEBUG, "", false, true, []string{"mqtt"})
+	}
+
+	mqtt := mqttModForTests(nil)
+	requ := connect(protocolLevel311, "sensor-1")
+	resp := connack(protocolLevel311, 0)
+	replay(mqtt, nil, client(requ), broker(resp))
+
+	event := expectTransaction(t, mqtt)
+	expectNoTransaction(t, mqtt)
+	expectFields(t, event, map[string]interface{}{
+		"type":                          "mqtt",
+		"status":                        common.OK_STATUS,
+		"method":                        "CONNECT",
+		"responsetime":                  int32(5),
+		"bytes_out":                     uint64(len(requ)),
+		"bytes_in":                      uint64(len(resp)),
+		"mqtt.protocol_version":         "3.1.1",
+		"mqtt.client_id":                "sensor-1",
+		"mqtt.username":                 "sensor",
+		"mqtt.request.type":             "CONNECT",
+		"mqtt.request.protocol_name":    "MQTT",
+		"mqtt.request.protocol_level":   uint8(4),
+		"mqtt.request.clean_session":    true,
+		"mqtt.request.keep_alive":       uint16(60),
+		"mqtt.request.will.topic":       "devices/sensor-1/status",
+		"mqtt.request.will.qos":         uint8(1),
+		"mqtt.request.will.retain":      false,
+		"mqtt.response.type":            "CONNACK",
+		"mqtt.response.session_present": false,
+		"mqtt.response.reason_code":     uint8(0),
+		"mqtt.response.reason":          "CONNECTION_ACCEPTED",
+	})
+
+	src := event["src"].(*common.Endpoint)
+	assert.Equal(t, 6512, int(src.Port))
+}
+
+func TestConnectRefused(t *testing.T) {
+	mqtt := mqttModForTests(nil)
+	replay(mqtt, nil,
+		client(connect(protocolLevel311, "sensor-1")),
+		broker(connack(protocolLevel311, 5)))
+
+	event := expectTransaction(t, mqtt)
+	expectFields(t, event, map[string]interface{}{
+		"status":               common.ERROR_STATUS,
+		"mqtt.response.reason": "NOT_AUTHORIZED",
+	})
+}
+
+func TestPublishQoS0(t *testing.T) {
+	mqtt := mqttModForTests(nil)
+	conn := replay(mqtt, nil,
+		client(connect(protocolLevel311, "sensor-1")),
+		broker(connack(protocolLevel311, 0)))
+	expectTransaction(t, mqtt)
+
+	// the broker delivers the messages of the subscriptions to the client
+	replay(mqtt, conn,
+		client(publishPacket(protocolLevel311, 0, 0, "devices/sensor-1/temp", "21.5")),
+		broker(publishPacket(protocolLevel311, 0, 0, "devices/sensor-1/cmd", "reboot")))
+
+	event := expectTransaction(t, mqtt)
+	expectFields(t, event, map[string]interface{}{
+		"status":                    common.OK_STATUS,
+		"method":                    "PUBLISH",
+		"responsetime":              int32(-1),
+		"mqtt.client_id":            "sensor-1",
+		"mqtt.request.topic":        "devices/sensor-1/temp",
+		"mqtt.request.qos":          uint8(0),
+		"mqtt.request.retain":       true,
+		"mqtt.request.dup":          false,
+		"mqtt.request.payload_size": 4,
+	})
+	assert.NotContains(t, event["mqtt"], "response")
+	assert.NotContains(t, event["mqtt"], "packet_id")
+	assert.NotContains(t, event["mqtt"].(common.MapStr)["request"], "payload")
+
+	event = expectTransaction(t, mqtt)
+	expectFields(t, event, map[string]interface{}{
+		"mqtt.client_id":     "sensor-1",
+		"mqtt.request.topic": "devices/sensor-1/cmd",
+	})
+	src := event["src"].(*common.Endpoint)
+	assert.Equal(t, 1883, int(src.Port))
+}
+
+func TestPublishQoS1(t *testing.T) {
+	config := defaultConfig
+	config.Ports = []int{1883}
+	config.IncludePayload = true
+	config.MaxPayloadSize = 4
+	mqtt := mqttModForTests(&config)
+
+	// acknowledgments in a different order, and packet IDs used in both
+	// directions
+	replay(mqtt, nil,
+		client(publishPacket(protocolLevel311, 1, 10, "a", "payload-a")),
+		client(publishPacket(protocolLevel311, 1, 11, "b", "\xff\xfe")),
+		broker(publishPacket(protocolLevel311, 1, 10, "c", "payload-c")),
+		broker(ack(packetPuback, 11)),
+		client(ack(packetPuback, 10)),
+		broker(ack(packetPuback, 10)))
+
+	for _, expected := range []map[string]interface{}{
+		{"mqtt.packet_id": uint16(11), "mqtt.request.topic": "b", "mqtt.request.payload": "//4=",
+			"mqtt.request.payload_encoding": "base64", "responsetime": int32(10)},
+		{"mqtt.packet_id": uint16(10), "mqtt.request.topic": "c", "mqtt.request.payload": "payl",
+			"mqtt.response.type": "PUBACK"},
+		{"mqtt.packet_id": uint16(10), "mqtt.request.topic": "a", "mqtt.request.payload_size": 9,
+			"notes": []string{noteUnknownVersion, noteUnknownVersion}},
+	} {
+		expectFields(t, expectTransaction(t, mqtt), expected)
+	}
+	expectNoTransaction(t, mqtt)
+}
+
+func TestPublishQoS2(t *testing.T) {
+	mqtt := mqttModForTests(nil)
+	publish := publishPacket(protocolLevel311, 2, 7, "orders", "{}")
+	pubrec := ack(packetPubrec, 7)
+	pubrel := ack(packetPubrel, 7)
+	pubcomp := ack(packetPubcomp, 7)
+	replay(mqtt, nil,
+		client(publish),
+		broker(pubrec),
+		client(pubrel),
+		broker(pubcomp))
+
+	event := expectTransaction(t, mqtt)
+	expectNoTransaction(t, mqtt)
+	expectFields(t, event, map[string]interface{}{
+		"status":             common.OK_STATUS,
+		"responsetime":       int32(15),
+		"bytes_out":          uint64(len(publish) + len(pubrel)),
+		"bytes_in":           uint64(len(pubrec) + len(pubcomp)),
+		"mqtt.request.qos":   uint8(2),
+		"mqtt.response.type": "PUBCOMP",
+	})
+}
+
+func TestSubscribe(t *testing.T) {
+	mqtt := mqttModForTests(nil)
+	replay(mqtt, nil,
+		client(subscribe(1, "devices/+/temp", "devices/#")),
+		broker(suback(1, 0, 0x80)))
+
+	event := expectTransaction(t, mqtt)
+	expectFields(t, event, map[string]interface{}{
+		"status": common.ERROR_STATUS,
+		"method": "SUBSCRIBE",
+		"mqtt.request.subscriptions": []common.MapStr{
+			{"topic": "devices/+/temp", "qos": uint8(0)},
+			{"topic": "devices/#", "qos": uint8(1)},
+		},
+		"mqtt.response.reason_codes": []int{0, 0x80},
+		"mqtt.response.reasons":      []string{"GRANTED_QOS_0", "FAILURE"},
+	})
+}
+
+func TestPing(t *testing.T) {
+	mqtt := mqttModForTests(nil)
+	ping := (&encoder{}).packet(packetPingreq, 0)
+	pong := (&encoder{}).packet(packetPingresp, 0)
+	disconnect := (&encoder{}).packet(packetDisconnect, 0)
+	replay(mqtt, nil, client(append(ping, disconnect...)), broker(pong))
+
+	// DISCONNECT messages have no response
+	expectFields(t, expectTransaction(t, mqtt), map[string]interface{}{
+		"method":       "DISCONNECT",
+		"responsetime": int32(-1),
+	})
+	expectFields(t, expectTransaction(t, mqtt), map[string]interface{}{
+		"method":             "PINGREQ",
+		"mqtt.response.type": "PINGRESP",
+		"bytes_out":          uint64(2),
+	})
+}
+
+func TestMQTT5(t *testing.T) {
+	mqtt := mqttModForTests(nil)
+
+	// session expiry interval
+	conn := replay(mqtt, nil,
+		client(connect(protocolLevel5, "", 0x11, 0, 0, 0x0e, 0x10)),
+		broker(connack(protocolLevel5, 0, 0x12, 0, 3, 'i', 'd', '1')))
+
+	expectFields(t, expectTransaction(t, mqtt), map[string]interface{}{
+		"mqtt.protocol_version": "5.0",
+		"mqtt.client_id":        "id1",
+		"mqtt.request.properties.session_expiry_interval": uint32(3600),
+		"mqtt.response.reason":                            "SUCCESS",
+	})
+
+	// topic alias set by the first message, then used alone
+	replay(mqtt, conn,
+		client(publishPacket(protocolLevel5, 1, 1, "devices/1", "on", 0x23, 0, 5, 0x03, 0, 4, 't', 'e', 'x', 't')),
+		client(publishPacket(protocolLevel5, 1, 2, "", "off", 0x23, 0, 5)),
+		broker(ack(packetPuback, 1)),
+		broker(ack(packetPuback, 2, 0x87, 0)))
+
+	expectFields(t, expectTransaction(t, mqtt), map[string]interface{}{
+		"status":                               common.OK_STATUS,
+		"mqtt.request.topic":                   "devices/1",
+		"mqtt.request.properties.topic_alias":  uint16(5),
+		"mqtt.request.properties.content_type": "text",
+	})
+	expectFields(t, expectTransaction(t, mqtt), map[string]interface{}{
+		"status":                    common.ERROR_STATUS,
+		"mqtt.request.topic":        "devices/1",
+		"mqtt.response.reason_code": uint8(0x87),
+		"mqtt.response.reason":      "NOT_AUTHORIZED",
+	})
+}
+
+func TestSplitAndLargeMessages(t *testing.T) {
+	config := defaultConfig
+	config.Ports = []int{1883}
+	config.MaxMessageSize = 16
+	mqtt := mqttModForTests(&config)
+
+	large := publishPacket(protocolLevel311, 1, 3, "logs", string(make([]byte, 200)))
+	replay(mqtt, nil,
+		client(large[:10]),
+		client(large[10:100]),
+		client(large[100:]),
+		broker(ack(packetPuback, 3)[:1]),
+		broker(ack(packetPuback, 3)[1:]))
+
+	event := expectTransaction(t, mqtt)
+	expectFields(t, event, map[string]interface{}{
+		"bytes_out":                 uint64(len(large)),
+		"mqtt.request.topic":        "logs",
+		"mqtt.request.payload_size": 200,
+		"mqtt.response.type":        "PUBACK",
+	})
+	assert.Contains(t, event["notes"], noteMessageTruncated)
+}
+
+func TestInvalidFlags(t *testing.T) {
+	mqtt := mqttModForTests(nil)
+	replay(mqtt, nil, client([]byte{0x85, 0x02, 0x00, 0x01}))
+	expectNoTransaction(t, mqtt)
+}
@@ -0,0 +1,337 @@
+package mqtt
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/common/streambuf"
+)
+
+type packetType uint8
+
+const (
+	packetConnect     packetType = 1
+	packetConnack     packetType = 2
+	packetPublish     packetType = 3
+	packetPuback      packetType = 4
+	packetPubrec      packetType = 5
+	packetPubrel      packetType = 6
+	packetPubcomp     packetType = 7
+	packetSubscribe   packetType = 8
+	packetSuback      packetType = 9
+	packetUnsubscribe packetType = 10
+	packetUnsuback    packetType = 11
+	packetPingreq     packetType = 12
+	packetPingresp    packetType = 13
+	packetDisconnect  packetType = 14
+	packetAuth        packetType = 15
+)
+
+var packetTypeNames = map[packetType]string{
+	packetConnect:     "CONNECT",
+	packetConnack:     "CONNACK",
+	packetPublish:     "PUBLISH",
+	packetPuback:      "PUBACK",
+	packetPubrec:      "PUBREC",
+	packetPubrel:      "PUBREL",
+	packetPubcomp:     "PUBCOMP",
+	packetSubscribe:   "SUBSCRIBE",
+	packetSuback:      "SUBACK",
+	packetUnsubscribe: "UNSUBSCRIBE",
+	packetUnsuback:    "UNSUBACK",
+	packetPingreq:     "PINGREQ",
+	packetPingresp:    "PINGRESP",
+	packetDisconnect:  "DISCONNECT",
+	packetAuth:        "AUTH",
+}
+
+func (t packetType) String() string {
+	if name, found := packetTypeNames[t]; found {
+		return name
+	}
+	return "UNKNOWN_" + strconv.Itoa(int(t))
+}
+
+// Protocol levels sent in the CONNECT message.
+const (
+	protocolLevel31  = 3
+	protocolLevel311 = 4
+	protocolLevel5   = 5
+)
+
+var protocolVersions = map[uint8]string{
+	protocolLevel31:  "3.1",
+	protocolLevel311: "3.1.1",
+	protocolLevel5:   "5.0",
+}
+
+// CONNECT flags
+const (
+	connectUsername     = 0x80
+	connectPassword     = 0x40
+	connectWillRetain   = 0x20
+	connectWillQoS      = 0x18
+	connectWill         = 0x04
+	connectCleanSession = 0x02
+)
+
+// PUBLISH flags
+const (
+	publishDup    = 0x08
+	publishQoS    = 0x06
+	publishRetain = 0x01
+)
+
+const (
+	// upper bound of the remaining length, as encoded on 4 bytes
+	maxRemainingLength = 268435455
+
+	// maximum size of the fixed header
+	maxFixedHeaderLen = 1 + maxVarintLen
+)
+
+var (
+	errInvalidPacketType  = errors.New("invalid mqtt packet type")
+	errInvalidFlags       = errors.New("invalid mqtt fixed header flags")
+	errInvalidFrameLength = errors.New("invalid mqtt remaining length")
+)
+
+type parser struct {
+	maxMessageSize int
+
+	// number of bytes of the current message still to be skipped. Only the
+	// first maxMessageSize bytes of larger messages are buffered.
+	skip int
+}
+
+// frame is a complete (or truncated) MQTT packet, without the fixed header.
+type frame struct {
+	ts        time.Time
+	typ       packetType
+	flags     uint8
+	size      int // size of the packet including the fixed header
+	remaining int // size of the packet without the fixed header
+	body      []byte
+	truncated bool
+}
+
+// parse returns the next packet available in buf. The returned body is only
+// valid until the stream buffer is reset.
+func (p *parser) parse(buf *streambuf.Buffer, ts time.Time) (*frame, error) {
+	if p.skip > 0 {
+		n := p.skip
+		if n > buf.Len() {
+			n = buf.Len()
+		}
+		buf.Advance(n)
+		p.skip -= n
+		if p.skip > 0 {
+			return nil, nil
+		}
+	}
+
+	if !buf.Avail(2) {
+		return nil, nil
+	}
+
+	header := buf.Bytes()
+	if len(header) > maxFixedHeaderLen {
+		header = header[:maxFixedHeaderLen]
+	}
+	typ, flags := packetType(header[0]>>4), header[0]&0x0f
+	if err := checkFlags(typ, flags); err != nil {
+		return nil, err
+	}
+
+	remaining, n := decodeVarint(header[1:])
+	if n < 0 || remaining > maxRemainingLength {
+		return nil, errInvalidFrameLength
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	headerLen := 1 + n
+
+	f := &frame{
+		ts:        ts,
+		typ:       typ,
+		flags:     flags,
+		size:      headerLen + remaining,
+		remaining: remaining,
+	}
+	if remaining > p.maxMessageSize {
+		// only buffer the beginning of large messages
+		if !buf.Avail(headerLen + p.maxMessageSize) {
+			return nil, nil
+		}
+		buf.Advance(headerLen)
+		f.body, _ = buf.Collect(p.maxMessageSize)
+		f.truncated = true
+		p.skip = remaining - p.maxMessageSize
+		return f, nil
+	}
+
+	if !buf.Avail(headerLen + remaining) {
+		return nil, nil
+	}
+	buf.Advance(headerLen)
+	f.body, _ = buf.Collect(remaining)
+	return f, nil
+}
+
+// gap updates the parser state after nbytes have been lost. It returns false
+// if the parser can not recover from the gap.
+func (p *parser) gap(buffered, nbytes int) bool {
+	if p.skip == 0 || buffered > 0 {
+		return false
+	}
+
+	// lost bytes are part of a message being skipped anyway
+	if nbytes > p.skip {
+		return false
+	}
+	p.skip -= nbytes
+	return true
+}
+
+// checkFlags validates the flags of the fixed header, such that random binary
+// data is not mistaken for MQTT packets.
+func checkFlags(typ packetType, flags uint8) error {
+	switch typ {
+	case 0:
+		return errInvalidPacketType
+	case packetPublish:
+		// QoS 3 is reserved
+		if flags&publishQoS == publishQoS {
+			return errInvalidFlags
+		}
+	case packetPubrel, packetSubscribe, packetUnsubscribe:
+		if flags != 0x02 {
+			return errInvalidFlags
+		}
+	default:
+		if flags != 0 {
+			return errInvalidFlags
+		}
+	}
+	return nil
+}
+
+// decodeMessage decodes the variable header and the payload of a packet.
+// version is the protocol level of the connection.
+func decodeMessage(msg *message, f *frame, version uint8) error {
+	d := newDecoder(f.body)
+	v5 := version == protocolLevel5
+
+	switch f.typ {
+	case packetConnect:
+		decodeConnect(d, msg)
+
+	case packetConnack:
+		if d.uint8()&0x01 != 0 {
+			msg.sessionPresent = true
+		}
+		msg.setReasonCode(d.uint8())
+		if v5 {
+			msg.properties = d.properties()
+		}
+
+	case packetPublish:
+		msg.qos = (f.flags & publishQoS) >> 1
+		msg.retain = f.flags&publishRetain != 0
+		msg.dup = f.flags&publishDup != 0
+		msg.topic = d.string()
+		if msg.qos > 0 {
+			msg.setPacketID(d.uint16())
+		}
+		if v5 {
+			msg.properties = d.properties()
+		}
+		if d.err == nil {
+			msg.payloadSize = f.remaining - (len(f.body) - len(d.buf))
+			msg.payload = d.rest()
+		}
+
+	case packetPuback, packetPubrec, packetPubrel, packetPubcomp:
+		msg.setPacketID(d.uint16())
+		// the reason code and properties are omitted on success
+		if v5 && !d.empty() {
+			msg.setReasonCode(d.uint8())
+			if !d.empty() {
+				msg.properties = d.properties()
+			}
+		}
+
+	case packetSubscribe:
+		msg.setPacketID(d.uint16())
+		if v5 {
+			msg.properties = d.properties()
+		}
+		for !d.empty() {
+			msg.topics = append(msg.topics, d.string())
+			msg.subscriptionQoS = append(msg.subscriptionQoS, d.uint8()&0x03)
+		}
+
+	case packetUnsubscribe:
+		msg.setPacketID(d.uint16())
+		if v5 {
+			msg.properties = d.properties()
+		}
+		for !d.empty() {
+			msg.topics = append(msg.topics, d.string())
+		}
+
+	case packetSuback, packetUnsuback:
+		msg.setPacketID(d.uint16())
+		if v5 {
+			msg.properties = d.properties()
+		}
+		// MQTT 3 UNSUBACK messages have no payload
+		msg.reasonCodes = append([]uint8(nil), d.rest()...)
+
+	case packetDisconnect, packetAuth:
+		if v5 && !d.empty() {
+			msg.setReasonCode(d.uint8())
+			if !d.empty() {
+				msg.properties = d.properties()
+			}
+		}
+	}
+
+	if msg.truncated && d.err == errTruncated {
+		return nil
+	}
+	return d.err
+}
+
+func decodeConnect(d *decoder, msg *message) {
+	msg.protocolName = d.string()
+	msg.protocolLevel = d.uint8()
+	flags := d.uint8()
+	msg.keepAlive = d.uint16()
+	if msg.protocolLevel == protocolLevel5 {
+		msg.properties = d.properties()
+	}
+
+	msg.cleanSession = flags&connectCleanSession != 0
+	msg.clientID = d.string()
+	if flags&connectWill != 0 {
+		if msg.protocolLevel == protocolLevel5 {
+			d.properties()
+		}
+		msg.will = common.MapStr{
+			"topic":  d.string(),
+			"qos":    (flags & connectWillQoS) >> 3,
+			"retain": flags&connectWillRetain != 0,
+		}
+		d.binary()
+	}
+	if flags&connectUsername != 0 {
+		msg.username = d.string()
+	}
+	if flags&connectPassword != 0 {
+		d.binary()
+	}
+}
@@ -0,0 +1,168 @@
+package mqtt
+
+import (
+	"encoding/base64"
+	"unicode/utf8"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func (t *transaction) init() {
+	requ, resp := t.request, t.response
+
+	t.InitWithMsg("mqtt", &requ.Message)
+	t.BytesOut = requ.Size
+	if requ.release != nil {
+		t.BytesOut += requ.release.Size
+	}
+	t.Notes = append(t.Notes, requ.Notes...)
+	t.Status = common.OK_STATUS
+	if requ.hasReasonCode && isFailure(requ.typ, t.version, requ.reasonCode) {
+		t.Status = common.ERROR_STATUS
+	}
+	if resp == nil {
+		t.ResponseTime = -1
+		return
+	}
+
+	t.BytesIn = resp.Size
+	if requ.ack != nil && requ.ack != resp {
+		t.BytesIn += requ.ack.Size
+	}
+	t.ResponseTime = int32(resp.Ts.Sub(requ.Ts).Nanoseconds() / 1e6) // [ms]
+	t.Notes = append(t.Notes, resp.Notes...)
+	if resp.failed(t.version) {
+		t.Status = common.ERROR_STATUS
+	}
+}
+
+func (t *transaction) Event(event common.MapStr) error {
+	if err := t.Transaction.Event(event); err != nil {
+		return err
+	}
+
+	requ, resp := t.request, t.response
+	event["method"] = requ.typ.String()
+
+	mqtt := common.MapStr{}
+	if version, found := protocolVersions[t.version]; found {
+		mqtt["protocol_version"] = version
+	}
+	if t.clientID != "" {
+		mqtt["client_id"] = t.clientID
+	}
+	if t.username != "" {
+		mqtt["username"] = t.username
+	}
+	if requ.hasPacketID {
+		mqtt["packet_id"] = requ.packetID
+	}
+
+	mqtt["request"] = requ.fields(t.version)
+	if resp != nil {
+		fields := resp.fields(t.version)
+		if requ.ack != nil && requ.ack != resp && requ.ack.hasReasonCode {
+			// reason code of the PUBREC message of QoS 2 deliveries
+			fields["pubrec_reason_code"] = requ.ack.reasonCode
+		}
+		mqtt["response"] = fields
+	}
+
+	event["mqtt"] = mqtt
+	return nil
+}
+
+func (msg *message) setPacketID(id uint16) {
+	msg.packetID = id
+	msg.hasPacketID = true
+}
+
+func (msg *message) setReasonCode(code uint8) {
+	msg.reasonCode = code
+	msg.hasReasonCode = true
+}
+
+// failed checks if a response reports an error.
+func (msg *message) failed(version uint8) bool {
+	if msg.hasReasonCode && isFailure(msg.typ, version, msg.reasonCode) {
+		return true
+	}
+	if msg.typ == packetSuback || msg.typ == packetUnsuback {
+		for _, code := range msg.reasonCodes {
+			if isFailure(msg.typ, version, code) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (msg *message) fields(version uint8) common.MapStr {
+	fields := common.MapStr{
+		"type": msg.typ.String(),
+	}
+
+	switch msg.typ {
+	case packetConnect:
+		fields["protocol_name"] = msg.protocolName
+		fields["protocol_level"] = msg.protocolLevel
+		fields["clean_session"] = msg.cleanSession
+		fields["keep_alive"] = msg.keepAlive
+		if msg.will != nil {
+			fields["will"] = msg.will
+		}
+
+	case packetConnack:
+		fields["session_present"] = msg.sessionPresent
+
+	case packetPublish:
+		fields["topic"] = msg.topic
+		fields["qos"] = msg.qos
+		fields["retain"] = msg.retain
+		fields["dup"] = msg.dup
+		fields["payload_size"] = msg.payloadSize
+		if msg.payload != nil {
+			if utf8.Valid(msg.payload) {
+				fields["payload"] = string(msg.payload)
+			} else {
+				fields["payload"] = base64.StdEncoding.EncodeToString(msg.payload)
+				fields["payload_encoding"] = "base64"
+			}
+		}
+
+	case packetSubscribe:
+		subscriptions := make([]common.MapStr, len(msg.topics))
+		for i, topic := range msg.topics {
+			subscriptions[i] = common.MapStr{
+				"topic": topic,
+				"qos":   msg.subscriptionQoS[i],
+			}
+		}
+		fields["subscriptions"] = subscriptions
+
+	case packetUnsubscribe:
+		fields["topics"] = msg.topics
+
+	case packetSuback, packetUnsuback:
+		if len(msg.reasonCodes) > 0 {
+			// []uint8 would be encoded as a base64 string
+			codes := make([]int, len(msg.reasonCodes))
+			reasons := make([]string, len(msg.reasonCodes))
+			for i, code := range msg.reasonCodes {
+				codes[i] = int(code)
+				reasons[i] = reasonName(msg.typ, version, code)
+			}
+			fields["reason_codes"] = codes
+			fields["reasons"] = reasons
+		}
+	}
+
+	if msg.hasReasonCode {
+		fields["reason_code"] = msg.reasonCode
+		fields["reason"] = reasonName(msg.typ, version, msg.reasonCode)
+	}
+	if len(msg.properties) > 0 {
+		fields["properties"] = msg.properties
+	}
+	return fields
+}
@@ -3,12 +3,15 @@ package mysql
 import (
 	"github.com/elastic/beats/packetbeat/config"
 	"github.com/elastic/beats/packetbeat/protos"
+	"github.com/elastic/beats/packetbeat/sqlstats"
 )
 
 type mysqlConfig struct {
 	config.ProtocolCommon `config:",inline"`
 	MaxRowLength          int `config:"max_row_length"`
 	MaxRows               int `config:"max_rows"`
+
+	Aggregation sqlstats.Config `config:"aggregation"`
 }
 
 var (
@@ -18,5 +21,6 @@ var (
 		},
 		MaxRowLength: 1024,
 		MaxRows:      10,
+		Aggregation:  sqlstats.DefaultConfig,
 	}
 )
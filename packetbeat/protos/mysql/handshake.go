@@ -0,0 +1,291 @@
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// Capability flags exchanged during the handshake
+const (
+	clientConnectWithDB        = 0x00000008
+	clientProtocol41           = 0x00000200
+	clientSSL                  = 0x00000800
+	clientSecureConnection     = 0x00008000
+	clientPluginAuth           = 0x00080000
+	clientPluginAuthLenencData = 0x00200000
+	clientDeprecateEOF         = 0x01000000
+	clientQueryAttributes      = 0x08000000
+)
+
+const (
+	protocolVersion10 = 0x0a
+
+	// size of the fixed part of the handshake response, which is also the
+	// size of the SSL request
+	handshakeResponseFixedLen = 32
+)
+
+var errInvalidHandshake = errors.New("invalid handshake packet")
+
+type connectionPhase uint8
+
+const (
+	// the beginning of the connection has not been captured
+	phaseUnknown connectionPhase = iota
+
+	// server greeting received, waiting for the client handshake response
+	phaseHandshake
+
+	// waiting for the result of the authentication
+	phaseAuth
+
+	phaseCommand
+
+	// the client requested SSL, the rest of the connection is encrypted
+	phaseSSL
+)
+
+// mysqlHandshake holds the details of the connection phase reported with
+// the login transaction.
+type mysqlHandshake struct {
+	protocolVersion uint8
+	serverVersion   string
+	connectionID    uint32
+	authPlugin      string
+	user            string
+	database        string
+	ssl             bool
+}
+
+func (h *mysqlHandshake) toMapStr() common.MapStr {
+	fields := common.MapStr{
+		"protocol_version": h.protocolVersion,
+		"server_version":   h.serverVersion,
+		"connection_id":    h.connectionID,
+		"ssl":              h.ssl,
+	}
+	if h.authPlugin != "" {
+		fields["auth_plugin"] = h.authPlugin
+	}
+	if h.user != "" {
+		fields["user"] = h.user
+	}
+	if h.database != "" {
+		fields["database"] = h.database
+	}
+	return fields
+}
+
+// parseConnectionPhase checks if the message at the start of the stream
+// belongs to the connection phase and updates the message accordingly.
+func (s *mysqlStream) parseConnectionPhase(m *mysqlMessage) bool {
+	conn := s.conn
+	if conn == nil {
+		return false
+	}
+
+	switch conn.phase {
+	case phaseUnknown:
+		// COM_PROCESS_INFO has the same code, but no payload
+		if m.seq == 0 && m.typ == protocolVersion10 && m.packetLength > 1 {
+			m.isGreeting = true
+			conn.serverDir = s.dir
+			return true
+		}
+		return false
+
+	case phaseHandshake, phaseAuth:
+		if s.dir != conn.serverDir {
+			if m.seq == 0 {
+				// the end of the authentication was missed
+				conn.phase = phaseCommand
+				return false
+			}
+			if conn.phase == phaseHandshake && m.seq == 1 {
+				m.isLogin = true
+				m.isRequest = true
+			} else {
+				// authentication data
+				m.ignoreMessage = true
+			}
+			return true
+		}
+
+		switch {
+		case conn.phase == phaseHandshake:
+			m.ignoreMessage = true
+		case m.typ == 0x00:
+			m.isOK = true
+		case m.typ == 0xff:
+			m.isError = true
+		case m.typ == 0xfe:
+			m.isAuthSwitch = true
+			m.ignoreMessage = true
+		default:
+			// more authentication data
+			m.ignoreMessage = true
+		}
+		return true
+	}
+	return false
+}
+
+// connectionPhaseComplete decodes the handshake packets once complete.
+func (conn *mysqlConnection) connectionPhaseComplete(m *mysqlMessage, data []byte) error {
+	switch {
+	case m.isGreeting:
+		h, caps, err := decodeGreeting(data)
+		if err != nil {
+			return err
+		}
+		conn.handshake = h
+		conn.serverCaps = caps
+		conn.phase = phaseHandshake
+
+		// nothing is published for the greeting
+		m.ignoreMessage = true
+
+	case m.isLogin:
+		h := conn.handshake
+		caps, err := decodeHandshakeResponse(h, data)
+		if err != nil {
+			return err
+		}
+		conn.clientCaps = caps
+		conn.deprecateEOF = caps&conn.serverCaps&clientDeprecateEOF != 0
+		if h.ssl {
+			conn.phase = phaseSSL
+		} else {
+			conn.phase = phaseAuth
+		}
+		m.handshake = h
+		m.query = "CONNECT"
+
+	case m.isAuthSwitch:
+		// int<1> 0xfe, string<NUL> plugin name, string<EOF> plugin data
+		if plugin, _, ok := readNullString(data, 1); ok && conn.handshake != nil {
+			conn.handshake.authPlugin = plugin
+		}
+	}
+	return nil
+}
+
+// decodeGreeting decodes the initial handshake packet (protocol version 10)
+// sent by the server.
+func decodeGreeting(data []byte) (*mysqlHandshake, uint32, error) {
+	h := &mysqlHandshake{protocolVersion: data[0]}
+
+	version, off, ok := readNullString(data, 1)
+	if !ok || len(data) < off+15 {
+		return nil, 0, errInvalidHandshake
+	}
+	h.serverVersion = version
+	h.connectionID = binary.LittleEndian.Uint32(data[off:])
+
+	// auth-plugin-data-part-1 and filler
+	off += 4 + 8 + 1
+	caps := uint32(binary.LittleEndian.Uint16(data[off:]))
+	off += 2
+
+	// character set, status flags
+	if len(data) < off+3+2+1+10 {
+		return h, caps, nil
+	}
+	off += 3
+	caps |= uint32(binary.LittleEndian.Uint16(data[off:])) << 16
+	off += 2
+	authDataLen := int(data[off])
+	off += 1 + 10
+
+	if caps&clientSecureConnection != 0 {
+		n := authDataLen - 8
+		if n < 13 {
+			n = 13
+		}
+		off += n
+	}
+	if caps&clientPluginAuth != 0 {
+		if plugin, _, ok := readNullString(data, off); ok {
+			h.authPlugin = plugin
+		}
+	}
+	return h, caps, nil
+}
+
+// decodeHandshakeResponse decodes the handshake response or the SSL request
+// sent by the client and returns the client capabilities.
+func decodeHandshakeResponse(h *mysqlHandshake, data []byte) (uint32, error) {
+	if len(data) < 4 {
+		return 0, errInvalidHandshake
+	}
+	caps := binary.LittleEndian.Uint32(data)
+	if caps&clientProtocol41 == 0 {
+		// HandshakeResponse320: int<2> capabilities, int<3> max packet
+		// size, string<NUL> user name
+		if user, _, ok := readNullString(data, 5); ok {
+			h.user = user
+		}
+		return caps & 0xffff, nil
+	}
+
+	if len(data) < handshakeResponseFixedLen {
+		return 0, errInvalidHandshake
+	}
+	if len(data) == handshakeResponseFixedLen && caps&clientSSL != 0 {
+		h.ssl = true
+		return caps, nil
+	}
+
+	user, off, ok := readNullString(data, handshakeResponseFixedLen)
+	if !ok {
+		return 0, errInvalidHandshake
+	}
+	h.user = user
+
+	// auth response
+	switch {
+	case caps&clientPluginAuthLenencData != 0:
+		_, off, ok, _ = readLstring(data, off)
+	case caps&clientSecureConnection != 0:
+		if ok = off < len(data) && off+1+int(data[off]) <= len(data); ok {
+			off += 1 + int(data[off])
+		}
+	default:
+		_, off, ok = readNullString(data, off)
+	}
+	if !ok {
+		logp.Debug("mysql", "Truncated auth response in handshake response")
+		return caps, nil
+	}
+
+	if caps&clientConnectWithDB != 0 {
+		var database string
+		if database, off, ok = readNullString(data, off); !ok {
+			return caps, nil
+		}
+		h.database = database
+	}
+	if caps&clientPluginAuth != 0 {
+		if plugin, _, ok := readNullString(data, off); ok && plugin != "" {
+			h.authPlugin = plugin
+		}
+	}
+	return caps, nil
+}
+
+// readNullString reads a null terminated string. The string is also
+// terminated by the end of the packet.
+func readNullString(data []byte, offset int) (string, int, bool) {
+	if offset > len(data) {
+		return "", 0, false
+	}
+	i := bytes.IndexByte(data[offset:], 0)
+	if i < 0 {
+		return string(data[offset:]), len(data), true
+	}
+	return string(data[offset : offset+i]), offset + i + 1, true
+}
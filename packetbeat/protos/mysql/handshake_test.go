@@ -0,0 +1,216 @@
+// +build !integration
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/protos"
+)
+
+const (
+	testServerCaps = clientConnectWithDB | clientProtocol41 | clientSSL |
+		clientSecureConnection | clientPluginAuth | clientPluginAuthLenencData |
+		clientDeprecateEOF
+	testClientCaps = clientConnectWithDB | clientProtocol41 |
+		clientSecureConnection | clientPluginAuth | clientPluginAuthLenencData
+)
+
+// mysqlPacket builds a packet from the concatenated payload parts.
+func mysqlPacket(seq uint8, parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	n := len(payload)
+	return append([]byte{byte(n), byte(n >> 8), byte(n >> 16), seq}, payload...)
+}
+
+func lenencString(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func nullString(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func le16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return b
+}
+
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func greetingPacket(caps uint32) []byte {
+	return mysqlPacket(0,
+		[]byte{protocolVersion10},
+		nullString("8.0.32"),
+		le32(42),                     // connection ID
+		bytes.Repeat([]byte{'a'}, 8), // auth-plugin-data-part-1
+		[]byte{0},
+		le16(uint16(caps)),
+		[]byte{0xff}, // character set
+		le16(0x0002), // status flags
+		le16(uint16(caps>>16)),
+		[]byte{21},
+		make([]byte, 10),
+		append(bytes.Repeat([]byte{'b'}, 12), 0), // auth-plugin-data-part-2
+		nullString("caching_sha2_password"),
+	)
+}
+
+func handshakeResponsePacket(caps uint32, user, database string) []byte {
+	return mysqlPacket(1,
+		le32(caps),
+		le32(16777216), // max packet size
+		[]byte{0xff},
+		make([]byte, 23),
+		nullString(user),
+		lenencString("0123456789abcdef0123456789abcdef"),
+		nullString(database),
+		nullString("caching_sha2_password"),
+	)
+}
+
+func okPacket(seq uint8) []byte {
+	return mysqlPacket(seq, []byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00})
+}
+
+func parseMySQL(mysql *mysqlPlugin, private protos.ProtocolData, dir uint8, data []byte) protos.ProtocolData {
+	return mysql.Parse(&protos.Packet{Payload: data}, testTCPTuple(), dir, private)
+}
+
+func TestHandshake(t *testing.T) {
+	if testing.Verbose() {
+		logp.LogInit(logp.LOG_DEBUG, "", false, true, []string{"mysql", "mysqldetailed"})
+	}
+
+	mysql := mysqlModForTests()
+
+	var private protos.ProtocolData
+	private = parseMySQL(mysql, private, 1, greetingPacket(testServerCaps))
+	private = parseMySQL(mysql, private, 0, handshakeResponsePacket(testClientCaps, "app", "shop"))
+	// fast authentication success
+	private = parseMySQL(mysql, private, 1, mysqlPacket(2, []byte{0x01, 0x03}))
+	private = parseMySQL(mysql, private, 1, okPacket(3))
+
+	trans := expectTransaction(t, mysql)
+	if trans == nil {
+		return
+	}
+	assert.Equal(t, "CONNECT", trans["method"])
+	assert.Equal(t, common.OK_STATUS, trans["status"])
+	assert.Equal(t, common.MapStr{
+		"protocol_version": uint8(10),
+		"server_version":   "8.0.32",
+		"connection_id":    uint32(42),
+		"auth_plugin":      "caching_sha2_password",
+		"user":             "app",
+		"database":         "shop",
+		"ssl":              false,
+	}, trans["mysql"].(common.MapStr)["handshake"])
+
+	conn := private.(mysqlPrivateData).conn
+	assert.Equal(t, phaseCommand, conn.phase)
+	assert.False(t, conn.deprecateEOF)
+}
+
+func TestHandshake_authSwitch(t *testing.T) {
+	mysql := mysqlModForTests()
+
+	var private protos.ProtocolData
+	private = parseMySQL(mysql, private, 1, greetingPacket(testServerCaps))
+	private = parseMySQL(mysql, private, 0, handshakeResponsePacket(testClientCaps, "app", ""))
+	private = parseMySQL(mysql, private, 1, mysqlPacket(2,
+		[]byte{0xfe}, nullString("mysql_native_password"), bytes.Repeat([]byte{'c'}, 20)))
+	private = parseMySQL(mysql, private, 0, mysqlPacket(3, bytes.Repeat([]byte{'d'}, 20)))
+	private = parseMySQL(mysql, private, 1, mysqlPacket(4,
+		[]byte{0xff}, le16(1045), []byte("#28000"), []byte("Access denied for user 'app'")))
+
+	trans := expectTransaction(t, mysql)
+	if trans == nil {
+		return
+	}
+	assert.Equal(t, common.ERROR_STATUS, trans["status"])
+	handshake := trans["mysql"].(common.MapStr)["handshake"].(common.MapStr)
+	assert.Equal(t, "mysql_native_password", handshake["auth_plugin"])
+	assert.Equal(t, "app", handshake["user"])
+	assert.NotContains(t, handshake, "database")
+	assert.Equal(t, uint16(1045), trans["mysql"].(common.MapStr)["error_code"])
+}
+
+func TestHandshake_sslRequest(t *testing.T) {
+	mysql := mysqlModForTests()
+
+	var private protos.ProtocolData
+	private = parseMySQL(mysql, private, 1, greetingPacket(testServerCaps))
+	private = parseMySQL(mysql, private, 0, mysqlPacket(1,
+		le32(testClientCaps|clientSSL), le32(16777216), []byte{0xff}, make([]byte, 23)))
+
+	trans := expectTransaction(t, mysql)
+	if trans == nil {
+		return
+	}
+	assert.Equal(t, "CONNECT", trans["method"])
+	handshake := trans["mysql"].(common.MapStr)["handshake"].(common.MapStr)
+	assert.Equal(t, true, handshake["ssl"])
+	assert.NotContains(t, handshake, "user")
+
+	// the TLS handshake is not parsed
+	private = parseMySQL(mysql, private, 0, []byte{0x16, 0x03, 0x01, 0x02, 0x00, 0x01})
+	assert.Equal(t, phaseSSL, private.(mysqlPrivateData).conn.phase)
+	assert.Nil(t, private.(mysqlPrivateData).data[0])
+}
+
+func TestHandshake_deprecateEOF(t *testing.T) {
+	mysql := mysqlModForTests()
+
+	var private protos.ProtocolData
+	private = parseMySQL(mysql, private, 1, greetingPacket(testServerCaps))
+	private = parseMySQL(mysql, private, 0,
+		handshakeResponsePacket(testClientCaps|clientDeprecateEOF, "app", "shop"))
+	private = parseMySQL(mysql, private, 1, okPacket(2))
+	expectTransaction(t, mysql)
+
+	// result set without EOF packet after the column definitions
+	private = parseMySQL(mysql, private, 0, mysqlPacket(0, []byte{mysqlCmdQuery}, []byte("select name from users")))
+	private = parseMySQL(mysql, private, 1, bytes.Join([][]byte{
+		mysqlPacket(1, []byte{1}),
+		mysqlPacket(2, columnDefinition("users", "name", fieldTypeVarString, 0)),
+		mysqlPacket(3, lenencString("alice")),
+		mysqlPacket(4, lenencString("bob")),
+		mysqlPacket(5, []byte{0xfe, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00}),
+	}, nil))
+
+	trans := expectTransaction(t, mysql)
+	if trans == nil {
+		return
+	}
+	assert.Equal(t, "SELECT", trans["method"])
+	assert.Equal(t, 2, trans["mysql"].(common.MapStr)["num_rows"])
+	assert.Equal(t, 1, trans["mysql"].(common.MapStr)["num_fields"])
+	assert.Equal(t, "shop.users", trans["path"])
+}
+
+func TestDecodeGreeting_truncated(t *testing.T) {
+	greeting := greetingPacket(testServerCaps)[4:]
+
+	_, _, err := decodeGreeting(greeting[:10])
+	assert.Equal(t, errInvalidHandshake, err)
+
+	// pre 4.1 servers only send the lower capability flags
+	h, caps, err := decodeGreeting(greeting[:len("8.0.32")+2+4+8+1+2])
+	if assert.NoError(t, err) {
+		assert.Equal(t, "8.0.32", h.serverVersion)
+		assert.Equal(t, uint32(testServerCaps&0xffff), caps)
+		assert.Equal(t, "", h.authPlugin)
+	}
+}
@@ -14,11 +14,16 @@ import (
 	"github.com/elastic/beats/packetbeat/protos"
 	"github.com/elastic/beats/packetbeat/protos/tcp"
 	"github.com/elastic/beats/packetbeat/publish"
+	"github.com/elastic/beats/packetbeat/sqlstats"
 )
 
 // Packet types
 const (
-	mysqlCmdQuery = 3
+	mysqlCmdQuery            = 0x03
+	mysqlCmdStmtPrepare      = 0x16
+	mysqlCmdStmtExecute      = 0x17
+	mysqlCmdStmtSendLongData = 0x18
+	mysqlCmdStmtClose        = 0x19
 )
 
 const maxPayloadSize = 100 * 1024
@@ -50,8 +55,24 @@ type mysqlMessage struct {
 	errorCode      uint16
 	errorInfo      string
 	query          string
+	method         string
 	ignoreMessage  bool
 
+	// connection phase
+	isGreeting   bool
+	isLogin      bool
+	isAuthSwitch bool
+	handshake    *mysqlHandshake
+
+	// prepared statements
+	statementID        uint32
+	numParams          int
+	params             []string
+	isPrepareOK        bool
+	isBinary           bool
+	pendingDefinitions int
+	fieldsRead         int
+
 	direction    uint8
 	isTruncated  bool
 	tcpTuple     common.TCPTuple
@@ -73,7 +94,8 @@ type mysqlTransaction struct {
 	bytesIn      uint64
 	notes        []string
 
-	mysql common.MapStr
+	mysql     common.MapStr
+	handshake *mysqlHandshake
 
 	requestRaw  string
 	responseRaw string
@@ -87,6 +109,9 @@ type mysqlStream struct {
 	isClient    bool
 
 	message *mysqlMessage
+
+	conn *mysqlConnection
+	dir  uint8
 }
 
 type parseState int
@@ -96,6 +121,7 @@ const (
 	mysqlStateEatMessage
 	mysqlStateEatFields
 	mysqlStateEatRows
+	mysqlStateEatDefinitions
 
 	mysqlStateMax
 )
@@ -105,6 +131,7 @@ var stateStrings = []string{
 	"EatMessage",
 	"EatFields",
 	"EatRows",
+	"EatDefinitions",
 }
 
 func (state parseState) String() string {
@@ -123,7 +150,8 @@ type mysqlPlugin struct {
 	transactions       *common.Cache
 	transactionTimeout time.Duration
 
-	results publish.Transactions
+	results    publish.Transactions
+	aggregator *sqlstats.Aggregator
 
 	// function pointer for mocking
 	handleMysql func(mysql *mysqlPlugin, m *mysqlMessage, tcp *common.TCPTuple,
@@ -163,9 +191,21 @@ func (mysql *mysqlPlugin) init(results publish.Transactions, config *mysqlConfig
 	mysql.handleMysql = handleMysql
 	mysql.results = results
 
+	if config.Aggregation.Enabled {
+		mysql.aggregator = sqlstats.NewAggregator("mysql", config.Aggregation, results)
+		mysql.aggregator.Start()
+	}
+
 	return nil
 }
 
+// Stop stops publishing the aggregated query statistics.
+func (mysql *mysqlPlugin) Stop() {
+	if mysql.aggregator != nil {
+		mysql.aggregator.Stop()
+	}
+}
+
 func (mysql *mysqlPlugin) setFromConfig(config *mysqlConfig) {
 	mysql.ports = config.Ports
 	mysql.maxRowLength = config.MaxRowLength
@@ -215,10 +255,16 @@ func mysqlMessageParser(s *mysqlStream) (bool, bool) {
 
 			logp.Debug("mysqldetailed", "MySQL Header: Packet length %d, Seq %d, Type=%d", m.packetLength, m.seq, m.typ)
 
-			if m.seq == 0 {
+			if s.parseConnectionPhase(m) {
+				// handshake and authentication
+				m.start = s.parseOffset
+				s.parseState = mysqlStateEatMessage
+
+			} else if m.seq == 0 {
 				// starts Command Phase
 
-				if m.typ == mysqlCmdQuery {
+				if m.typ == mysqlCmdQuery || m.typ == mysqlCmdStmtPrepare ||
+					m.typ == mysqlCmdStmtExecute {
 					// parse request
 					m.isRequest = true
 					m.start = s.parseOffset
@@ -237,6 +283,9 @@ func mysqlMessageParser(s *mysqlStream) (bool, bool) {
 			} else if !s.isClient {
 				// parse response
 				m.isRequest = false
+				if s.conn != nil && s.conn.lastCommand == mysqlCmdStmtExecute {
+					m.isBinary = true
+				}
 
 				if hdr[4] == 0x00 || hdr[4] == 0xfe {
 					logp.Debug("mysqldetailed", "Received OK response")
@@ -275,8 +324,29 @@ func mysqlMessageParser(s *mysqlStream) (bool, bool) {
 			s.parseOffset += 4 //header
 			s.parseOffset += int(m.packetLength)
 			m.end = s.parseOffset
-			if m.isRequest {
-				m.query = string(s.data[m.start+5 : m.end])
+			if m.isGreeting || m.isLogin || m.isAuthSwitch {
+				err := s.conn.connectionPhaseComplete(m, s.data[m.start+4:m.end])
+				if err != nil {
+					logp.Debug("mysql", "Error on decoding the handshake: %s", err)
+					return false, false
+				}
+			} else if m.seq == 0 {
+				if m.isRequest {
+					m.query = string(s.data[m.start+5 : m.end])
+				}
+				if s.conn != nil {
+					s.conn.commandComplete(m, s.data[m.start+5:m.end])
+				}
+			} else if m.isOK && s.conn != nil && s.conn.lastCommand == mysqlCmdStmtPrepare {
+				err := s.conn.prepareOK(m, s.data[m.start+4:m.end])
+				if err != nil {
+					logp.Debug("mysql", "Error on decoding the prepare response: %s", err)
+					return false, false
+				}
+				if m.pendingDefinitions > 0 {
+					s.parseState = mysqlStateEatDefinitions
+					continue
+				}
 			} else if m.isOK {
 				// affected rows
 				affectedRows, off, complete, err := readLinteger(s.data, m.start+5)
@@ -309,6 +379,9 @@ func mysqlMessageParser(s *mysqlStream) (bool, bool) {
 
 				m.errorInfo = string(s.data[m.start+8:m.start+13]) + ": " + string(s.data[m.start+13:])
 			}
+			if s.conn != nil && s.conn.phase == phaseAuth && (m.isOK || m.isError) {
+				s.conn.phase = phaseCommand
+			}
 			m.size = uint64(m.end - m.start)
 			logp.Debug("mysqldetailed", "Message complete. remaining=%d",
 				len(s.data[s.parseOffset:]))
@@ -369,6 +442,12 @@ func mysqlMessageParser(s *mysqlStream) (bool, bool) {
 					}
 					logp.Debug("mysqldetailed", "db=%s, table=%s", db, table)
 					s.parseOffset += int(m.packetLength)
+
+					// without EOF packets, the rows follow the last field
+					m.fieldsRead++
+					if s.conn != nil && s.conn.deprecateEOF && m.fieldsRead == m.numberOfFields {
+						s.parseState = mysqlStateEatRows
+					}
 					// go to next field
 				}
 			} else {
@@ -419,6 +498,22 @@ func mysqlMessageParser(s *mysqlStream) (bool, bool) {
 			}
 			m.numberOfRows++
 			// go to next row
+
+		case mysqlStateEatDefinitions:
+			// parameter and column definitions of a prepared statement
+			length, err := readLength(s.data, s.parseOffset)
+			if err != nil || len(s.data[s.parseOffset:]) < length+4 {
+				// wait for more
+				return true, false
+			}
+			s.parseOffset += length + 4
+
+			m.pendingDefinitions--
+			if m.pendingDefinitions == 0 {
+				m.end = s.parseOffset
+				m.size = uint64(m.end - m.start)
+				return true, true
+			}
 		}
 	}
 
@@ -435,7 +530,7 @@ func (mysql *mysqlPlugin) messageGap(s *mysqlStream, nbytes int) (complete bool)
 	case mysqlStateStart, mysqlStateEatMessage:
 		// not enough data yet to be useful
 		return false
-	case mysqlStateEatFields, mysqlStateEatRows:
+	case mysqlStateEatFields, mysqlStateEatRows, mysqlStateEatDefinitions:
 		// enough data here
 		m.end = s.parseOffset
 		if m.isRequest {
@@ -451,6 +546,7 @@ func (mysql *mysqlPlugin) messageGap(s *mysqlStream, nbytes int) (complete bool)
 
 type mysqlPrivateData struct {
 	data [2]*mysqlStream
+	conn *mysqlConnection
 }
 
 // Called when the parser has identified a full message.
@@ -484,10 +580,20 @@ func (mysql *mysqlPlugin) Parse(pkt *protos.Packet, tcptuple *common.TCPTuple,
 		}
 	}
 
+	if priv.conn == nil {
+		priv.conn = newMysqlConnection()
+	}
+	if priv.conn.phase == phaseSSL {
+		// encrypted, nothing to parse
+		return priv
+	}
+
 	if priv.data[dir] == nil {
 		priv.data[dir] = &mysqlStream{
 			data:    pkt.Payload,
 			message: &mysqlMessage{ts: pkt.Ts},
+			conn:    priv.conn,
+			dir:     dir,
 		}
 	} else {
 		// concatenate bytes
@@ -517,6 +623,11 @@ func (mysql *mysqlPlugin) Parse(pkt *protos.Packet, tcptuple *common.TCPTuple,
 
 		if complete {
 			mysql.messageComplete(tcptuple, dir, stream)
+			if priv.conn.phase == phaseSSL {
+				logp.Debug("mysql", "Connection switched to SSL, stop parsing")
+				priv.data = [2]*mysqlStream{}
+				return priv
+			}
 		} else {
 			// wait for more data
 			break
@@ -567,7 +678,7 @@ func handleMysql(mysql *mysqlPlugin, m *mysqlMessage, tcptuple *common.TCPTuple,
 
 	m.tcpTuple = *tcptuple
 	m.direction = dir
-	m.cmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort())
+	m.cmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort(), procs.TransportTCP)
 	m.raw = rawMsg
 
 	if m.isRequest {
@@ -610,7 +721,9 @@ func (mysql *mysqlPlugin) receivedMysqlRequest(msg *mysqlMessage) {
 	query := strings.Trim(msg.query, " \n\t")
 	index := strings.IndexAny(query, " \n\t")
 	var method string
-	if index > 0 {
+	if msg.method != "" {
+		method = msg.method
+	} else if index > 0 {
 		method = strings.ToUpper(query[:index])
 	} else {
 		method = strings.ToUpper(query)
@@ -620,12 +733,38 @@ func (mysql *mysqlPlugin) receivedMysqlRequest(msg *mysqlMessage) {
 	trans.method = method
 
 	trans.mysql = common.MapStr{}
+	if msg.typ == mysqlCmdStmtExecute {
+		trans.mysql["statement_id"] = msg.statementID
+		if msg.params != nil {
+			trans.mysql["params"] = mysql.truncateParams(msg.params)
+		}
+	}
+	trans.handshake = msg.handshake
 
 	trans.notes = msg.notes
 
 	// save Raw message
 	trans.requestRaw = msg.query
 	trans.bytesIn = msg.size
+
+	if trans.handshake != nil && trans.handshake.ssl {
+		// no response is decoded once the connection is encrypted
+		trans.mysql["iserror"] = false
+		trans.mysql["handshake"] = trans.handshake.toMapStr()
+		mysql.publishTransaction(trans)
+		mysql.transactions.Delete(trans.tuple.Hashable())
+	}
+}
+
+// truncateParams limits the length of the statement parameters to
+// max_row_length.
+func (mysql *mysqlPlugin) truncateParams(params []string) []string {
+	for i, param := range params {
+		if len(param) > mysql.maxRowLength {
+			params[i] = param[:mysql.maxRowLength]
+		}
+	}
+	return params
 }
 
 func (mysql *mysqlPlugin) receivedMysqlResponse(msg *mysqlMessage) {
@@ -652,6 +791,13 @@ func (mysql *mysqlPlugin) receivedMysqlResponse(msg *mysqlMessage) {
 		"error_code":    msg.errorCode,
 		"error_message": msg.errorInfo,
 	})
+	if msg.isPrepareOK {
+		trans.mysql["statement_id"] = msg.statementID
+		trans.mysql["num_params"] = msg.numParams
+	}
+	if trans.handshake != nil {
+		trans.mysql["handshake"] = trans.handshake.toMapStr()
+	}
 	trans.bytesOut = msg.size
 	trans.path = msg.tables
 
@@ -659,7 +805,7 @@ func (mysql *mysqlPlugin) receivedMysqlResponse(msg *mysqlMessage) {
 
 	// save Raw message
 	if len(msg.raw) > 0 {
-		fields, rows := mysql.parseMysqlResponse(msg.raw)
+		fields, rows := mysql.parseMysqlResponse(msg.raw, msg.isBinary)
 
 		trans.responseRaw = common.DumpInCSVFormat(fields, rows)
 	}
@@ -673,7 +819,9 @@ func (mysql *mysqlPlugin) receivedMysqlResponse(msg *mysqlMessage) {
 	logp.Debug("mysql", "%s", trans.responseRaw)
 }
 
-func (mysql *mysqlPlugin) parseMysqlResponse(data []byte) ([]string, [][]string) {
+// parseMysqlResponse parses the fields and rows of a result set. Binary
+// result sets are returned by executed prepared statements.
+func (mysql *mysqlPlugin) parseMysqlResponse(data []byte, binary bool) ([]string, [][]string) {
 
 	length, err := readLength(data, 0)
 	if err != nil {
@@ -699,11 +847,18 @@ func (mysql *mysqlPlugin) parseMysqlResponse(data []byte) ([]string, [][]string)
 		// Error response
 	} else {
 		offset := 5
+		numFields := int(data[4])
+		var columns []columnType
 
 		logp.Debug("mysql", "Data len: %d", len(data))
 
 		// Read fields
 		for {
+			if len(fields) == numFields && len(data[offset:]) >= 5 && data[offset+4] != 0xfe {
+				// no EOF packet after the fields
+				break
+			}
+
 			length, err = readLength(data, offset)
 			if err != nil {
 				logp.Warn("Invalid response: %v", err)
@@ -746,12 +901,21 @@ func (mysql *mysqlPlugin) parseMysqlResponse(data []byte) ([]string, [][]string)
 				logp.Debug("mysql", "Reading field: %v %v", err, complete)
 				return fields, rows
 			}
-			_ /* org name */, _ /*off*/, complete, err = readLstring(data, off)
+			_ /* org name */, off, complete, err = readLstring(data, off)
 			if err != nil || !complete {
 				logp.Debug("mysql", "Reading field: %v %v", err, complete)
 				return fields, rows
 			}
 
+			// int<lenenc> length of the fixed fields (0x0c), int<2> character
+			// set, int<4> column length, int<1> type, int<2> flags
+			var column columnType
+			if len(data) >= off+10 {
+				column.typ = data[off+7]
+				column.unsigned = (uint16(data[off+8])|uint16(data[off+9])<<8)&unsignedFlag != 0
+			}
+			columns = append(columns, column)
+
 			fields = append(fields, string(name))
 
 			offset += length + 4
@@ -783,29 +947,45 @@ func (mysql *mysqlPlugin) parseMysqlResponse(data []byte) ([]string, [][]string)
 				break
 			}
 			off := offset + 4 // skip length + packet number
-			start := off
-			for off < start+length {
-				var text []byte
-
-				if data[off] == 0xfb {
-					text = []byte("NULL")
-					off++
-				} else {
-					var err error
-					var complete bool
-					text, off, complete, err = readLstring(data, off)
-					if err != nil || !complete {
-						logp.Debug("mysql", "Error parsing rows: %s %b", err, complete)
-						// nevertheless, return what we have so far
-						return fields, rows
+			var values []string
+			if binary {
+				if len(data) < off+length {
+					logp.Debug("mysql", "Error parsing rows: truncated row")
+					return fields, rows
+				}
+				values, err = decodeBinaryRow(data[off:off+length], columns)
+				if err != nil {
+					logp.Debug("mysql", "Error parsing rows: %s", err)
+					return fields, rows
+				}
+			} else {
+				start := off
+				for off < start+length {
+					var text []byte
+
+					if data[off] == 0xfb {
+						text = []byte("NULL")
+						off++
+					} else {
+						var err error
+						var complete bool
+						text, off, complete, err = readLstring(data, off)
+						if err != nil || !complete {
+							logp.Debug("mysql", "Error parsing rows: %s %v", err, complete)
+							// nevertheless, return what we have so far
+							return fields, rows
+						}
 					}
+					values = append(values, string(text))
 				}
+			}
 
+			for _, text := range values {
 				if rowLen < mysql.maxRowLength {
 					if rowLen+len(text) > mysql.maxRowLength {
 						text = text[:mysql.maxRowLength-rowLen]
 					}
-					row = append(row, string(text))
+					row = append(row, text)
 					rowLen += len(text)
 				}
 			}
@@ -831,6 +1011,28 @@ func (mysql *mysqlPlugin) publishTransaction(t *mysqlTransaction) {
 
 	logp.Debug("mysql", "mysql.results exists")
 
+	normalized, fingerprint := sqlstats.Normalize(t.query, sqlstats.MySQL)
+	t.mysql["normalized_query"] = normalized
+	t.mysql["fingerprint"] = fingerprint
+
+	if mysql.aggregator != nil {
+		rows, _ := t.mysql["num_rows"].(int)
+		affected, _ := t.mysql["affected_rows"].(uint64)
+		iserror, _ := t.mysql["iserror"].(bool)
+		mysql.aggregator.Add(&sqlstats.Transaction{
+			Server:       t.dst,
+			Query:        normalized,
+			Fingerprint:  fingerprint,
+			Method:       t.method,
+			IsError:      iserror,
+			ResponseTime: t.responseTime,
+			Rows:         rows + int(affected),
+			BytesIn:      t.bytesIn,
+			BytesOut:     t.bytesOut,
+		})
+		return
+	}
+
 	event := common.MapStr{}
 	event["type"] = "mysql"
 
@@ -861,6 +1063,7 @@ func (mysql *mysqlPlugin) publishTransaction(t *mysqlTransaction) {
 	event["@timestamp"] = common.Time(t.ts)
 	event["src"] = &t.src
 	event["dst"] = &t.dst
+	protos.AddTunnel(event, t.tuple.Tunnel)
 
 	mysql.results.PublishTransaction(event)
 }
@@ -13,6 +13,7 @@ import (
 
 	"github.com/elastic/beats/packetbeat/protos"
 	"github.com/elastic/beats/packetbeat/publish"
+	"github.com/elastic/beats/packetbeat/sqlstats"
 
 	"time"
 )
@@ -195,7 +196,7 @@ func TestMySQLParser_dataResponse(t *testing.T) {
 	if len(raw) == 0 {
 		t.Errorf("Empty raw data")
 	}
-	fields, rows := mysql.parseMysqlResponse(raw)
+	fields, rows := mysql.parseMysqlResponse(raw, false)
 	if len(fields) != stream.message.numberOfFields {
 		t.Errorf("Failed to parse the fields")
 	}
@@ -610,7 +611,7 @@ func Test_parseMysqlResponse_invalid(t *testing.T) {
 	}
 
 	for _, input := range tests {
-		fields, rows := mysql.parseMysqlResponse(input)
+		fields, rows := mysql.parseMysqlResponse(input, false)
 		assert.Equal(t, []string{}, fields)
 		assert.Equal(t, [][]string{}, rows)
 	}
@@ -628,8 +629,37 @@ func Test_parseMysqlResponse_invalid(t *testing.T) {
 	}
 
 	for _, input := range tests {
-		fields, rows := mysql.parseMysqlResponse(input)
+		fields, rows := mysql.parseMysqlResponse(input, false)
 		assert.Equal(t, []string{""}, fields)
 		assert.Equal(t, [][]string{}, rows)
 	}
 }
+
+func TestAggregation(t *testing.T) {
+	mysql := mysqlModForTests()
+	mysql.aggregator = sqlstats.NewAggregator("mysql", sqlstats.DefaultConfig, mysql.results)
+
+	var private protos.ProtocolData
+	for _, id := range []string{"1", "2"} {
+		private = parseMySQL(mysql, private, 0, mysqlPacket(0,
+			[]byte{mysqlCmdQuery}, []byte("DELETE FROM users WHERE id = "+id)))
+		private = parseMySQL(mysql, private, 1,
+			mysqlPacket(1, []byte{0x00, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00}))
+	}
+
+	// the transactions are aggregated instead of being published
+	client := mysql.results.(*publish.ChanTransactions)
+	assert.Len(t, client.Channel, 0)
+
+	mysql.aggregator.Flush(time.Now())
+	event := expectTransaction(t, mysql)
+	if event == nil {
+		return
+	}
+	stats := event["sql_stats"].(common.MapStr)
+	assert.Equal(t, "DELETE FROM users WHERE id = ?", stats["query"])
+	assert.Equal(t, "DELETE", stats["method"])
+	assert.Equal(t, 2, stats["count"])
+	assert.Equal(t, 2, stats["rows"])
+	assert.NotNil(t, event["dst"])
+}
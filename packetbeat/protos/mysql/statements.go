@@ -0,0 +1,394 @@
+package mysql
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// Column and parameter types of the binary protocol
+const (
+	fieldTypeDecimal    = 0x00
+	fieldTypeTiny       = 0x01
+	fieldTypeShort      = 0x02
+	fieldTypeLong       = 0x03
+	fieldTypeFloat      = 0x04
+	fieldTypeDouble     = 0x05
+	fieldTypeNull       = 0x06
+	fieldTypeTimestamp  = 0x07
+	fieldTypeLongLong   = 0x08
+	fieldTypeInt24      = 0x09
+	fieldTypeDate       = 0x0a
+	fieldTypeTime       = 0x0b
+	fieldTypeDateTime   = 0x0c
+	fieldTypeYear       = 0x0d
+	fieldTypeNewDate    = 0x0e
+	fieldTypeNewDecimal = 0xf6
+	fieldTypeBlob       = 0xfc
+	fieldTypeVarString  = 0xfd
+)
+
+const (
+	// column definition flag
+	unsignedFlag = 0x0020
+
+	// parameter type flag
+	paramUnsigned = 0x8000
+)
+
+// maximum number of prepared statements tracked per connection
+const maxPreparedStatements = 1024
+
+const noteUnknownStatement = "Statement prepared before the start of the capture"
+
+var errTruncatedValue = errors.New("truncated binary value")
+
+// mysqlConnection holds the connection state shared by both directions.
+type mysqlConnection struct {
+	phase     connectionPhase
+	serverDir uint8
+
+	handshake    *mysqlHandshake
+	serverCaps   uint32
+	clientCaps   uint32
+	deprecateEOF bool
+
+	// last command sent by the client, the response is decoded accordingly
+	lastCommand uint8
+	lastQuery   string
+
+	statements map[uint32]*preparedStatement
+}
+
+type preparedStatement struct {
+	query     string
+	numParams int
+
+	// parameter types bound by the last execution
+	paramTypes []uint16
+
+	// parameters sent with COM_STMT_SEND_LONG_DATA
+	longData map[int]bool
+}
+
+type columnType struct {
+	typ      uint8
+	unsigned bool
+}
+
+func newMysqlConnection() *mysqlConnection {
+	return &mysqlConnection{statements: map[uint32]*preparedStatement{}}
+}
+
+// commandComplete updates the connection state once a command sent by the
+// client is complete. data is the payload following the command byte.
+func (conn *mysqlConnection) commandComplete(m *mysqlMessage, data []byte) {
+	conn.phase = phaseCommand
+	conn.lastCommand = m.typ
+
+	switch m.typ {
+	case mysqlCmdStmtPrepare:
+		conn.lastQuery = strings.TrimSpace(m.query)
+		m.method = "PREPARE"
+
+	case mysqlCmdStmtExecute:
+		m.query = "EXECUTE"
+		if err := conn.decodeExecute(m, data); err != nil {
+			logp.Debug("mysql", "Failed to decode statement parameters: %v", err)
+		}
+
+	case mysqlCmdStmtClose:
+		if len(data) >= 4 {
+			delete(conn.statements, binary.LittleEndian.Uint32(data))
+		}
+
+	case mysqlCmdStmtSendLongData:
+		// int<4> statement ID, int<2> parameter ID, string<EOF> data
+		if len(data) >= 6 {
+			stmt := conn.statements[binary.LittleEndian.Uint32(data)]
+			if stmt != nil {
+				if stmt.longData == nil {
+					stmt.longData = map[int]bool{}
+				}
+				stmt.longData[int(binary.LittleEndian.Uint16(data[4:]))] = true
+			}
+		}
+	}
+}
+
+// prepareOK decodes a COM_STMT_PREPARE_OK response and registers the
+// statement.
+func (conn *mysqlConnection) prepareOK(m *mysqlMessage, data []byte) error {
+	// int<1> status, int<4> statement ID, int<2> number of columns,
+	// int<2> number of parameters, int<1> filler, int<2> warning count
+	if len(data) < 9 {
+		return errors.New("COM_STMT_PREPARE_OK response too short")
+	}
+	m.statementID = binary.LittleEndian.Uint32(data[1:])
+	m.numberOfFields = int(binary.LittleEndian.Uint16(data[5:]))
+	m.numParams = int(binary.LittleEndian.Uint16(data[7:]))
+	m.isPrepareOK = true
+
+	// parameter and column definitions follow, each block terminated by an
+	// EOF packet unless deprecated
+	m.pendingDefinitions = m.numParams + m.numberOfFields
+	if !conn.deprecateEOF {
+		if m.numParams > 0 {
+			m.pendingDefinitions++
+		}
+		if m.numberOfFields > 0 {
+			m.pendingDefinitions++
+		}
+	}
+
+	if len(conn.statements) >= maxPreparedStatements {
+		logp.Debug("mysql", "Too many prepared statements, not tracking statement %d", m.statementID)
+		return nil
+	}
+	conn.statements[m.statementID] = &preparedStatement{
+		query:     conn.lastQuery,
+		numParams: m.numParams,
+	}
+	return nil
+}
+
+// decodeExecute decodes the COM_STMT_EXECUTE request and its parameters.
+func (conn *mysqlConnection) decodeExecute(m *mysqlMessage, data []byte) error {
+	// int<4> statement ID, int<1> flags, int<4> iteration count
+	if len(data) < 9 {
+		return errors.New("COM_STMT_EXECUTE request too short")
+	}
+	m.statementID = binary.LittleEndian.Uint32(data)
+
+	stmt := conn.statements[m.statementID]
+	if stmt == nil {
+		m.notes = append(m.notes, noteUnknownStatement)
+		return nil
+	}
+	m.query = stmt.query
+
+	longData := stmt.longData
+	stmt.longData = nil
+
+	n := stmt.numParams
+	if n == 0 || conn.clientCaps&clientQueryAttributes != 0 {
+		// parameters are prefixed by query attributes, which are not
+		// decoded
+		return nil
+	}
+
+	off := 9
+	bitmapLen := (n + 7) / 8
+	if len(data) < off+bitmapLen+1 {
+		return errTruncatedValue
+	}
+	nullBitmap := data[off : off+bitmapLen]
+	off += bitmapLen
+
+	newParamsBound := data[off] == 1
+	off++
+	if newParamsBound {
+		if len(data) < off+2*n {
+			return errTruncatedValue
+		}
+		stmt.paramTypes = make([]uint16, n)
+		for i := range stmt.paramTypes {
+			stmt.paramTypes[i] = binary.LittleEndian.Uint16(data[off:])
+			off += 2
+		}
+	}
+	if len(stmt.paramTypes) != n {
+		// the parameter types were bound before the start of the capture
+		return nil
+	}
+
+	params := make([]string, n)
+	for i, typ := range stmt.paramTypes {
+		if nullBitmap[i/8]&(1<<uint(i%8)) != 0 {
+			params[i] = "NULL"
+			continue
+		}
+		if longData[i] {
+			params[i] = "(long data)"
+			continue
+		}
+
+		var err error
+		params[i], off, err = readBinaryValue(data, off,
+			columnType{uint8(typ), typ&paramUnsigned != 0})
+		if err != nil {
+			return err
+		}
+	}
+	m.params = params
+	return nil
+}
+
+// decodeBinaryRow decodes a row of a binary result set. data starts with
+// the packet header byte.
+func decodeBinaryRow(data []byte, columns []columnType) ([]string, error) {
+	// int<1> header (0x00), null bitmap with an offset of 2 bits
+	bitmapLen := (len(columns) + 7 + 2) / 8
+	if len(data) < 1+bitmapLen {
+		return nil, errTruncatedValue
+	}
+	nullBitmap := data[1 : 1+bitmapLen]
+	off := 1 + bitmapLen
+
+	row := make([]string, len(columns))
+	for i, col := range columns {
+		bit := i + 2
+		if nullBitmap[bit/8]&(1<<uint(bit%8)) != 0 {
+			row[i] = "NULL"
+			continue
+		}
+
+		var err error
+		row[i], off, err = readBinaryValue(data, off, col)
+		if err != nil {
+			return row[:i], err
+		}
+	}
+	return row, nil
+}
+
+// readBinaryValue reads a value of the binary protocol and returns its text
+// representation and the offset following the value.
+func readBinaryValue(data []byte, off int, col columnType) (string, int, error) {
+	need := func(n int) bool { return len(data) >= off+n }
+
+	switch col.typ {
+	case fieldTypeNull:
+		return "NULL", off, nil
+
+	case fieldTypeTiny:
+		if !need(1) {
+			break
+		}
+		if col.unsigned {
+			return strconv.FormatUint(uint64(data[off]), 10), off + 1, nil
+		}
+		return strconv.FormatInt(int64(int8(data[off])), 10), off + 1, nil
+
+	case fieldTypeShort, fieldTypeYear:
+		if !need(2) {
+			break
+		}
+		v := binary.LittleEndian.Uint16(data[off:])
+		if col.unsigned || col.typ == fieldTypeYear {
+			return strconv.FormatUint(uint64(v), 10), off + 2, nil
+		}
+		return strconv.FormatInt(int64(int16(v)), 10), off + 2, nil
+
+	case fieldTypeLong, fieldTypeInt24:
+		if !need(4) {
+			break
+		}
+		v := binary.LittleEndian.Uint32(data[off:])
+		if col.unsigned {
+			return strconv.FormatUint(uint64(v), 10), off + 4, nil
+		}
+		return strconv.FormatInt(int64(int32(v)), 10), off + 4, nil
+
+	case fieldTypeLongLong:
+		if !need(8) {
+			break
+		}
+		v := binary.LittleEndian.Uint64(data[off:])
+		if col.unsigned {
+			return strconv.FormatUint(v, 10), off + 8, nil
+		}
+		return strconv.FormatInt(int64(v), 10), off + 8, nil
+
+	case fieldTypeFloat:
+		if !need(4) {
+			break
+		}
+		v := math.Float32frombits(binary.LittleEndian.Uint32(data[off:]))
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), off + 4, nil
+
+	case fieldTypeDouble:
+		if !need(8) {
+			break
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(data[off:]))
+		return strconv.FormatFloat(v, 'g', -1, 64), off + 8, nil
+
+	case fieldTypeDate, fieldTypeNewDate, fieldTypeDateTime, fieldTypeTimestamp:
+		if !need(1) || !need(1+int(data[off])) {
+			break
+		}
+		return formatDateTime(data[off+1:off+1+int(data[off])], col.typ), off + 1 + int(data[off]), nil
+
+	case fieldTypeTime:
+		if !need(1) || !need(1+int(data[off])) {
+			break
+		}
+		return formatTime(data[off+1 : off+1+int(data[off])]), off + 1 + int(data[off]), nil
+
+	default:
+		// strings, decimals, blobs, JSON, enums and sets are length encoded
+		value, next, complete, err := readLstring(data, off)
+		if err != nil {
+			return "", 0, err
+		}
+		if complete {
+			return string(value), next, nil
+		}
+	}
+	return "", 0, errTruncatedValue
+}
+
+// formatDateTime formats a binary DATE, DATETIME or TIMESTAMP value of 0, 4,
+// 7 or 11 bytes.
+func formatDateTime(data []byte, typ uint8) string {
+	var year, month, day, hour, min, sec, usec int
+	if len(data) >= 4 {
+		year = int(binary.LittleEndian.Uint16(data))
+		month, day = int(data[2]), int(data[3])
+	}
+	if len(data) >= 7 {
+		hour, min, sec = int(data[4]), int(data[5]), int(data[6])
+	}
+	if len(data) >= 11 {
+		usec = int(binary.LittleEndian.Uint32(data[7:]))
+	}
+
+	s := fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+	if typ == fieldTypeDate || typ == fieldTypeNewDate {
+		return s
+	}
+	s += fmt.Sprintf(" %02d:%02d:%02d", hour, min, sec)
+	if usec != 0 {
+		s += fmt.Sprintf(".%06d", usec)
+	}
+	return s
+}
+
+// formatTime formats a binary TIME value of 0, 8 or 12 bytes.
+func formatTime(data []byte) string {
+	var negative bool
+	var days, hour, min, sec, usec int
+	if len(data) >= 8 {
+		negative = data[0] == 1
+		days = int(binary.LittleEndian.Uint32(data[1:]))
+		hour, min, sec = int(data[5]), int(data[6]), int(data[7])
+	}
+	if len(data) >= 12 {
+		usec = int(binary.LittleEndian.Uint32(data[8:]))
+	}
+
+	s := fmt.Sprintf("%02d:%02d:%02d", days*24+hour, min, sec)
+	if usec != 0 {
+		s += fmt.Sprintf(".%06d", usec)
+	}
+	if negative {
+		s = "-" + s
+	}
+	return s
+}
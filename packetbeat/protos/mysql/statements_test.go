@@ -0,0 +1,213 @@
+// +build !integration
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/protos"
+)
+
+const testQuery = "SELECT id, name FROM users WHERE id = ? AND name = ?"
+
+func columnDefinition(table, name string, typ uint8, flags uint16) []byte {
+	return bytes.Join([][]byte{
+		lenencString("def"),
+		lenencString("shop"),
+		lenencString(table),
+		lenencString(table),
+		lenencString(name),
+		lenencString(name),
+		{0x0c},
+		le16(0x21), // character set
+		le32(255),  // column length
+		{typ},
+		le16(flags),
+		{0x00, 0x00, 0x00}, // decimals, filler
+	}, nil)
+}
+
+func eofPacket(seq uint8) []byte {
+	return mysqlPacket(seq, []byte{0xfe, 0x00, 0x00, 0x02, 0x00})
+}
+
+func prepareStatement(mysql *mysqlPlugin, private protos.ProtocolData, id uint32) protos.ProtocolData {
+	private = parseMySQL(mysql, private, 0, mysqlPacket(0, []byte{mysqlCmdStmtPrepare}, []byte(testQuery)))
+	return parseMySQL(mysql, private, 1, bytes.Join([][]byte{
+		mysqlPacket(1, []byte{0x00}, le32(id), le16(2), le16(2), []byte{0x00}, le16(0)),
+		mysqlPacket(2, columnDefinition("", "?", fieldTypeLongLong, 0)),
+		mysqlPacket(3, columnDefinition("", "?", fieldTypeVarString, 0)),
+		eofPacket(4),
+		mysqlPacket(5, columnDefinition("users", "id", fieldTypeLong, unsignedFlag)),
+		mysqlPacket(6, columnDefinition("users", "name", fieldTypeVarString, 0)),
+		eofPacket(7),
+	}, nil))
+}
+
+func TestPreparedStatement(t *testing.T) {
+	if testing.Verbose() {
+		logp.LogInit(logp.LOG_DEBUG, "", false, true, []string{"mysql", "mysqldetailed"})
+	}
+
+	mysql := mysqlModForTests()
+	mysql.sendResponse = true
+
+	var private protos.ProtocolData
+	private = prepareStatement(mysql, private, 7)
+
+	trans := expectTransaction(t, mysql)
+	if trans == nil {
+		return
+	}
+	assert.Equal(t, "PREPARE", trans["method"])
+	assert.Equal(t, testQuery, trans["query"])
+	assert.Equal(t, uint32(7), trans["mysql"].(common.MapStr)["statement_id"])
+	assert.Equal(t, 2, trans["mysql"].(common.MapStr)["num_params"])
+	assert.Equal(t, 2, trans["mysql"].(common.MapStr)["num_fields"])
+
+	// execute with id = 42 and name = 'bob'
+	private = parseMySQL(mysql, private, 0, mysqlPacket(0,
+		[]byte{mysqlCmdStmtExecute}, le32(7), []byte{0x00}, le32(1),
+		[]byte{0x00}, // null bitmap
+		[]byte{0x01}, // new params bound
+		le16(fieldTypeLongLong), le16(fieldTypeVarString),
+		[]byte{42, 0, 0, 0, 0, 0, 0, 0},
+		lenencString("bob"),
+	))
+	private = parseMySQL(mysql, private, 1, bytes.Join([][]byte{
+		mysqlPacket(1, []byte{2}),
+		mysqlPacket(2, columnDefinition("users", "id", fieldTypeLong, unsignedFlag)),
+		mysqlPacket(3, columnDefinition("users", "name", fieldTypeVarString, 0)),
+		eofPacket(4),
+		mysqlPacket(5, []byte{0x00, 0x00}, le32(42), lenencString("bob")),
+		eofPacket(6),
+	}, nil))
+
+	trans = expectTransaction(t, mysql)
+	if trans == nil {
+		return
+	}
+	assert.Equal(t, "SELECT", trans["method"])
+	assert.Equal(t, testQuery, trans["query"])
+	assert.Equal(t, "shop.users", trans["path"])
+	assert.Equal(t, uint32(7), trans["mysql"].(common.MapStr)["statement_id"])
+	assert.Equal(t, []string{"42", "bob"}, trans["mysql"].(common.MapStr)["params"])
+	assert.Equal(t, testQuery, trans["mysql"].(common.MapStr)["normalized_query"])
+	assert.NotEmpty(t, trans["mysql"].(common.MapStr)["fingerprint"])
+	assert.Equal(t, 1, trans["mysql"].(common.MapStr)["num_rows"])
+	assert.Equal(t, "id,name\n42,bob\n", trans["response"])
+
+	// the parameter types are kept from the previous execution
+	private = parseMySQL(mysql, private, 0, mysqlPacket(0,
+		[]byte{mysqlCmdStmtExecute}, le32(7), []byte{0x00}, le32(1),
+		[]byte{0x02}, // name is NULL
+		[]byte{0x00},
+		[]byte{43, 0, 0, 0, 0, 0, 0, 0},
+	))
+	private = parseMySQL(mysql, private, 1, bytes.Join([][]byte{
+		mysqlPacket(1, []byte{2}),
+		mysqlPacket(2, columnDefinition("users", "id", fieldTypeLong, unsignedFlag)),
+		mysqlPacket(3, columnDefinition("users", "name", fieldTypeVarString, 0)),
+		eofPacket(4),
+		eofPacket(5),
+	}, nil))
+
+	trans = expectTransaction(t, mysql)
+	if trans == nil {
+		return
+	}
+	assert.Equal(t, []string{"43", "NULL"}, trans["mysql"].(common.MapStr)["params"])
+	assert.Equal(t, 0, trans["mysql"].(common.MapStr)["num_rows"])
+
+	// closing the statement doesn't publish anything
+	private = parseMySQL(mysql, private, 0, mysqlPacket(0, []byte{mysqlCmdStmtClose}, le32(7)))
+	assert.Empty(t, private.(mysqlPrivateData).conn.statements)
+}
+
+func TestPreparedStatement_unknown(t *testing.T) {
+	mysql := mysqlModForTests()
+
+	var private protos.ProtocolData
+	private = parseMySQL(mysql, private, 0, mysqlPacket(0,
+		[]byte{mysqlCmdStmtExecute}, le32(3), []byte{0x00}, le32(1), []byte{0x00, 0x01}))
+	private = parseMySQL(mysql, private, 1, okPacket(1))
+
+	trans := expectTransaction(t, mysql)
+	if trans == nil {
+		return
+	}
+	assert.Equal(t, "EXECUTE", trans["method"])
+	assert.Equal(t, uint32(3), trans["mysql"].(common.MapStr)["statement_id"])
+	assert.Equal(t, []string{noteUnknownStatement}, trans["notes"])
+}
+
+func TestPreparedStatement_longData(t *testing.T) {
+	mysql := mysqlModForTests()
+
+	var private protos.ProtocolData
+	private = prepareStatement(mysql, private, 1)
+	expectTransaction(t, mysql)
+
+	private = parseMySQL(mysql, private, 0, mysqlPacket(0,
+		[]byte{mysqlCmdStmtSendLongData}, le32(1), le16(1), []byte("a long name")))
+	private = parseMySQL(mysql, private, 0, mysqlPacket(0,
+		[]byte{mysqlCmdStmtExecute}, le32(1), []byte{0x00}, le32(1),
+		[]byte{0x00}, []byte{0x01},
+		le16(fieldTypeLongLong), le16(fieldTypeBlob),
+		[]byte{1, 0, 0, 0, 0, 0, 0, 0},
+	))
+	private = parseMySQL(mysql, private, 1, okPacket(1))
+
+	trans := expectTransaction(t, mysql)
+	if trans == nil {
+		return
+	}
+	assert.Equal(t, []string{"1", "(long data)"}, trans["mysql"].(common.MapStr)["params"])
+}
+
+func TestReadBinaryValue(t *testing.T) {
+	double := make([]byte, 8)
+	binary.LittleEndian.PutUint64(double, math.Float64bits(2.5))
+
+	tests := []struct {
+		data     []byte
+		col      columnType
+		expected string
+	}{
+		{[]byte{0xff}, columnType{fieldTypeTiny, false}, "-1"},
+		{[]byte{0xff}, columnType{fieldTypeTiny, true}, "255"},
+		{le16(0xfffe), columnType{fieldTypeShort, false}, "-2"},
+		{le16(2017), columnType{fieldTypeYear, false}, "2017"},
+		{le32(0xffffffff), columnType{fieldTypeLong, true}, "4294967295"},
+		{[]byte{0xfd, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, columnType{fieldTypeLongLong, false}, "-3"},
+		{double, columnType{fieldTypeDouble, false}, "2.5"},
+		{lenencString("12.50"), columnType{fieldTypeNewDecimal, false}, "12.50"},
+		{[]byte{4, 0xe1, 0x07, 10, 18}, columnType{fieldTypeDate, false}, "2017-10-18"},
+		{[]byte{7, 0xe1, 0x07, 10, 18, 13, 5, 9}, columnType{fieldTypeDateTime, false}, "2017-10-18 13:05:09"},
+		{[]byte{11, 0xe1, 0x07, 10, 18, 13, 5, 9, 0x40, 0xe2, 0x01, 0x00}, columnType{fieldTypeTimestamp, false},
+			"2017-10-18 13:05:09.123456"},
+		{[]byte{0}, columnType{fieldTypeDateTime, false}, "0000-00-00 00:00:00"},
+		{[]byte{8, 1, 1, 0, 0, 0, 2, 3, 4}, columnType{fieldTypeTime, false}, "-26:03:04"},
+	}
+
+	for _, test := range tests {
+		value, off, err := readBinaryValue(test.data, 0, test.col)
+		if assert.NoError(t, err) {
+			assert.Equal(t, test.expected, value)
+			assert.Equal(t, len(test.data), off)
+		}
+	}
+
+	_, _, err := readBinaryValue([]byte{0x01, 0x02}, 0, columnType{fieldTypeLong, false})
+	assert.Equal(t, errTruncatedValue, err)
+
+	_, _, err = readBinaryValue([]byte{0x05, 'a'}, 0, columnType{fieldTypeVarString, false})
+	assert.Equal(t, errTruncatedValue, err)
+}
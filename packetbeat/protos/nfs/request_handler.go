@@ -8,6 +8,7 @@ import (
 
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/monitoring"
+	"github.com/elastic/beats/packetbeat/protos"
 	"github.com/elastic/beats/packetbeat/protos/tcp"
 )
 
@@ -104,6 +105,7 @@ func (r *rpc) handleCall(xid string, xdr *xdr, ts time.Time, tcptuple *common.TC
 
 	event["type"] = "nfs"
 	event["rpc"] = rpcInfo
+	protos.AddTunnel(event, tcptuple.Tunnel)
 	nfs := nfs{vers: nfsVers, proc: nfsProc, event: event}
 	event["nfs"] = nfs.getRequestInfo(xdr)
 
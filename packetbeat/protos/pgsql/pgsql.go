@@ -13,6 +13,7 @@ import (
 	"github.com/elastic/beats/packetbeat/protos"
 	"github.com/elastic/beats/packetbeat/protos/tcp"
 	"github.com/elastic/beats/packetbeat/publish"
+	"github.com/elastic/beats/packetbeat/sqlstats"
 )
 
 type pgsqlPlugin struct {
@@ -27,7 +28,8 @@ type pgsqlPlugin struct {
 	transactions       *common.Cache
 	transactionTimeout time.Duration
 
-	results publish.Transactions
+	results    publish.Transactions
+	aggregator *sqlstats.Aggregator
 
 	// function pointer for mocking
 	handlePgsql func(pgsql *pgsqlPlugin, m *pgsqlMessage, tcp *common.TCPTuple,
@@ -149,9 +151,21 @@ func (pgsql *pgsqlPlugin) init(results publish.Transactions, config *pgsqlConfig
 	pgsql.handlePgsql = handlePgsql
 	pgsql.results = results
 
+	if config.Aggregation.Enabled {
+		pgsql.aggregator = sqlstats.NewAggregator("pgsql", config.Aggregation, results)
+		pgsql.aggregator.Start()
+	}
+
 	return nil
 }
 
+// Stop stops publishing the aggregated query statistics.
+func (pgsql *pgsqlPlugin) Stop() {
+	if pgsql.aggregator != nil {
+		pgsql.aggregator.Stop()
+	}
+}
+
 func (pgsql *pgsqlPlugin) setFromConfig(config *pgsqlConfig) {
 	pgsql.ports = config.Ports
 	pgsql.maxRowLength = config.MaxRowLength
@@ -345,7 +359,7 @@ var handlePgsql = func(pgsql *pgsqlPlugin, m *pgsqlMessage, tcptuple *common.TCP
 
 	m.tcpTuple = *tcptuple
 	m.direction = dir
-	m.cmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort())
+	m.cmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort(), procs.TransportTCP)
 
 	if m.isRequest {
 		pgsql.receivedPgsqlRequest(m)
@@ -448,6 +462,26 @@ func (pgsql *pgsqlPlugin) publishTransaction(t *pgsqlTransaction) {
 		return
 	}
 
+	normalized, fingerprint := sqlstats.Normalize(t.query, sqlstats.PostgreSQL)
+	t.pgsql["normalized_query"] = normalized
+	t.pgsql["fingerprint"] = fingerprint
+
+	if pgsql.aggregator != nil {
+		rows, _ := t.pgsql["num_rows"].(int)
+		pgsql.aggregator.Add(&sqlstats.Transaction{
+			Server:       t.dst,
+			Query:        normalized,
+			Fingerprint:  fingerprint,
+			Method:       t.method,
+			IsError:      t.pgsql["iserror"].(bool),
+			ResponseTime: t.responseTime,
+			Rows:         rows,
+			BytesIn:      t.bytesIn,
+			BytesOut:     t.bytesOut,
+		})
+		return
+	}
+
 	event := common.MapStr{}
 
 	event["type"] = "pgsql"
@@ -472,6 +506,7 @@ func (pgsql *pgsqlPlugin) publishTransaction(t *pgsqlTransaction) {
 	event["@timestamp"] = common.Time(t.ts)
 	event["src"] = &t.src
 	event["dst"] = &t.dst
+	protos.AddTunnel(event, t.tuple.Tunnel)
 
 	if len(t.notes) > 0 {
 		event["notes"] = t.notes
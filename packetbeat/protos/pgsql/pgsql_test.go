@@ -359,4 +359,6 @@ func Test_gap_in_response(t *testing.T) {
 	trans := expectTransaction(t, pgsql)
 	assert.NotNil(t, trans)
 	assert.Equal(t, trans["notes"], []string{"Packet loss while capturing the response"})
+	assert.Equal(t, "select * from test", trans["pgsql"].(common.MapStr)["normalized_query"])
+	assert.NotEmpty(t, trans["pgsql"].(common.MapStr)["fingerprint"])
 }
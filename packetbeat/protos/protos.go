@@ -206,6 +206,15 @@ func (s ProtocolsStruct) GetAllUDP() map[Protocol]UDPPlugin {
 	return s.udp
 }
 
+// Stop stops the background tasks of the registered plugins.
+func (s ProtocolsStruct) Stop() {
+	for _, plugin := range s.all {
+		if p, ok := plugin.(StoppablePlugin); ok {
+			p.Stop()
+		}
+	}
+}
+
 // BpfFilter returns a Berkeley Packer Filter (BFP) expression that
 // will match against packets for the registered protocols. If with_vlans is
 // true the filter will match against both IEEE 802.1Q VLAN encapsulated
@@ -246,6 +255,15 @@ func (s ProtocolsStruct) BpfFilter(withVlans bool, withICMP bool) string {
 		}
 	}
 
+	// streams on dynamic ports can use any UDP port
+	for _, key := range protos {
+		plugin, ok := s.udp[Protocol(key)].(DynamicUDPPlugin)
+		if ok && plugin.HasDynamicPorts() {
+			expressions = append(expressions, "udp")
+			break
+		}
+	}
+
 	if withICMP {
 		expressions = append(expressions, "icmp", "icmp6")
 	}
@@ -88,6 +88,18 @@ func (proto *TCPUDPProtocol) ParseUDP(pkt *Packet) {
 
 func (proto *TCPUDPProtocol) ConnectionTimeout() time.Duration { return 0 }
 
+type DynamicUDPProtocol struct {
+	UDPProtocol
+}
+
+func (proto *DynamicUDPProtocol) HasDynamicPorts() bool {
+	return true
+}
+
+func (proto *DynamicUDPProtocol) ExpectsUDP(tuple *common.IPPortTuple) bool {
+	return false
+}
+
 func TestProtocolNames(t *testing.T) {
 	assert.Equal(t, "unknown", UnknownProtocol.String())
 	assert.Equal(t, "impossible", Protocol(100).String())
@@ -145,6 +157,18 @@ func TestBpfFilterWithVlanWithIcmp(t *testing.T) {
 		"(vlan and (tcp port 80 or udp port 5060 or port 53 or icmp or icmp6))", filter)
 }
 
+func TestBpfFilterWithDynamicPorts(t *testing.T) {
+	p := ProtocolsStruct{}
+	p.all = make(map[Protocol]Plugin)
+	p.tcp = make(map[Protocol]TCPPlugin)
+	p.udp = make(map[Protocol]UDPPlugin)
+	p.register(1, &TCPProtocol{Ports: []int{80}})
+	p.register(2, &DynamicUDPProtocol{UDPProtocol{Ports: []int{5060}}})
+
+	filter := p.BpfFilter(false, false)
+	assert.Equal(t, "tcp port 80 or udp port 5060 or udp", filter)
+}
+
 func TestGetAll(t *testing.T) {
 	p := newProtocols()
 	all := p.GetAll()
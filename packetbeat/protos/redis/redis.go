@@ -224,7 +224,7 @@ func (redis *redisPlugin) handleRedis(
 ) {
 	m.tcpTuple = *tcptuple
 	m.direction = dir
-	m.cmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort())
+	m.cmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort(), procs.TransportTCP)
 
 	if m.isRequest {
 		conn.requests.append(m) // wait for response
@@ -305,6 +305,7 @@ func (redis *redisPlugin) newTransaction(requ, resp *redisMessage) common.MapStr
 		"src":          src,
 		"dst":          dst,
 	}
+	protos.AddTunnel(event, requ.tcpTuple.Tunnel)
 	if redis.sendRequest {
 		event["request"] = requ.message
 	}
@@ -47,6 +47,29 @@ type UDPPlugin interface {
 	ParseUDP(pkt *Packet)
 }
 
+// DynamicUDPPlugin is implemented by the UDP plugins following streams on
+// ports negotiated at runtime, like the RTP streams of SIP calls. The packets
+// not matching the configured ports of any plugin are passed to ParseUDP if
+// the plugin expects them.
+type DynamicUDPPlugin interface {
+	UDPPlugin
+
+	// HasDynamicPorts returns true if the plugin follows dynamic streams.
+	HasDynamicPorts() bool
+
+	// ExpectsUDP checks if a packet belongs to one of the dynamic streams.
+	ExpectsUDP(tuple *common.IPPortTuple) bool
+}
+
+// StoppablePlugin is implemented by the plugins running background tasks,
+// which are stopped when Packetbeat shuts down.
+type StoppablePlugin interface {
+	Plugin
+
+	// Stop stops the background tasks of the plugin.
+	Stop()
+}
+
 // Protocol identifier.
 type Protocol uint16
 
@@ -0,0 +1,45 @@
+package sip
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/elastic/beats/packetbeat/config"
+)
+
+type sipConfig struct {
+	config.ProtocolCommon `config:",inline"`
+
+	// Maximum time a call is kept without seeing any SIP or RTP packet of
+	// the call.
+	CallTimeout time.Duration `config:"call_timeout"`
+
+	RTP rtpConfig `config:"rtp"`
+}
+
+type rtpConfig struct {
+	// If enabled, the RTP streams negotiated in the SDP bodies are followed
+	// to report packet loss and jitter per call.
+	Enabled bool `config:"enabled"`
+}
+
+var (
+	defaultConfig = sipConfig{
+		ProtocolCommon: config.ProtocolCommon{
+			// 64*T1, the timeout of the non-INVITE client transactions
+			// (RFC 3261, section 17.1.2.2)
+			TransactionTimeout: 32 * time.Second,
+		},
+		CallTimeout: time.Hour,
+	}
+)
+
+func (c *sipConfig) Validate() error {
+	if c.TransactionTimeout <= 0 {
+		return fmt.Errorf("transaction_timeout must be > 0")
+	}
+	if c.CallTimeout <= 0 {
+		return fmt.Errorf("call_timeout must be > 0")
+	}
+	return nil
+}
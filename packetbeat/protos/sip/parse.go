@@ -0,0 +1,230 @@
+package sip
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const sipVersion = "SIP/2.0"
+
+var (
+	errInvalidStartLine = errors.New("invalid sip start line")
+	errInvalidHeader    = errors.New("invalid sip header")
+	errInvalidLength    = errors.New("invalid sip content length")
+)
+
+// compact forms of the header names (RFC 3261, section 7.3.3)
+var compactHeaders = map[string]string{
+	"i": "call-id",
+	"f": "from",
+	"t": "to",
+	"m": "contact",
+	"l": "content-length",
+	"c": "content-type",
+	"v": "via",
+	"s": "subject",
+	"k": "supported",
+	"e": "content-encoding",
+}
+
+// address is the value of the From and To headers.
+type address struct {
+	displayName string
+	uri         string
+	tag         string
+}
+
+// parseMessage parses the SIP message at the beginning of data, and returns
+// the number of bytes consumed. If the message is not complete yet, nil is
+// returned. On datagram transports a message without Content-Length header
+// extends to the end of the datagram, and messages with a short body are
+// reported as truncated.
+func parseMessage(data []byte, datagram bool) (*message, int, error) {
+	headerEnd, sepLen := bytes.Index(data, []byte("\r\n\r\n")), 4
+	if headerEnd < 0 {
+		// tolerate bare line feeds
+		headerEnd, sepLen = bytes.Index(data, []byte("\n\n")), 2
+	}
+	if headerEnd < 0 {
+		if datagram {
+			return nil, 0, errInvalidHeader
+		}
+		return nil, 0, nil
+	}
+
+	lines := strings.Split(string(data[:headerEnd]), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
+
+	msg := &message{contentLength: -1}
+	if err := msg.parseStartLine(lines[0]); err != nil {
+		return nil, 0, err
+	}
+	if err := msg.parseHeaders(lines[1:]); err != nil {
+		return nil, 0, err
+	}
+
+	bodyStart := headerEnd + sepLen
+	size := len(data)
+	switch {
+	case msg.contentLength >= 0 && bodyStart+msg.contentLength <= len(data):
+		size = bodyStart + msg.contentLength
+	case msg.contentLength >= 0 && !datagram:
+		// wait for the body
+		return nil, 0, nil
+	case msg.contentLength >= 0:
+		msg.truncated = true
+	case !datagram:
+		// Content-Length is mandatory on stream transports
+		size = bodyStart
+	}
+	msg.body = data[bodyStart:size]
+	return msg, size, nil
+}
+
+func (msg *message) parseStartLine(line string) error {
+	if strings.HasPrefix(line, sipVersion+" ") {
+		// Status-Line: SIP-Version SP Status-Code SP Reason-Phrase
+		rest := line[len(sipVersion)+1:]
+		if len(rest) < 3 {
+			return errInvalidStartLine
+		}
+		code, err := strconv.Atoi(rest[:3])
+		if err != nil || code < 100 || code > 699 {
+			return errInvalidStartLine
+		}
+		msg.statusCode = code
+		msg.statusPhrase = strings.TrimSpace(rest[3:])
+		return nil
+	}
+
+	// Request-Line: Method SP Request-URI SP SIP-Version
+	parts := strings.Split(line, " ")
+	if len(parts) != 3 || parts[2] != sipVersion || !isToken(parts[0]) {
+		return errInvalidStartLine
+	}
+	msg.IsRequest = true
+	msg.method = parts[0]
+	msg.requestURI = parts[1]
+	return nil
+}
+
+func (msg *message) parseHeaders(lines []string) error {
+	var name, value string
+	for i := 0; i <= len(lines); i++ {
+		if i < len(lines) {
+			line := lines[i]
+			if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+				// folded header value
+				if name == "" {
+					return errInvalidHeader
+				}
+				value += " " + strings.TrimSpace(line)
+				continue
+			}
+		}
+		if name != "" {
+			if err := msg.setHeader(name, value); err != nil {
+				return err
+			}
+		}
+		if i == len(lines) {
+			break
+		}
+
+		colon := strings.IndexByte(lines[i], ':')
+		if colon <= 0 {
+			return errInvalidHeader
+		}
+		name = strings.ToLower(strings.TrimSpace(lines[i][:colon]))
+		value = strings.TrimSpace(lines[i][colon+1:])
+		if long, found := compactHeaders[name]; found {
+			name = long
+		}
+	}
+	return nil
+}
+
+func (msg *message) setHeader(name, value string) error {
+	switch name {
+	case "call-id":
+		msg.callID = value
+	case "from":
+		msg.from = parseAddress(value)
+	case "to":
+		msg.to = parseAddress(value)
+	case "cseq":
+		fields := strings.Fields(value)
+		if len(fields) != 2 {
+			return errInvalidHeader
+		}
+		n, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return errInvalidHeader
+		}
+		msg.cseq = uint32(n)
+		msg.cseqMethod = fields[1]
+	case "content-length":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return errInvalidLength
+		}
+		msg.contentLength = n
+	case "content-type":
+		msg.contentType = strings.ToLower(value)
+	case "user-agent", "server":
+		msg.userAgent = value
+	}
+	return nil
+}
+
+// parseAddress parses name-addr and addr-spec values, like
+// `"Alice" <sip:alice@example.com>;tag=1928301774`.
+func parseAddress(value string) address {
+	var addr address
+	var params string
+	if lt := strings.IndexByte(value, '<'); lt >= 0 {
+		addr.displayName = strings.Trim(strings.TrimSpace(value[:lt]), `"`)
+		rest := value[lt+1:]
+		if gt := strings.IndexByte(rest, '>'); gt >= 0 {
+			addr.uri = rest[:gt]
+			params = rest[gt+1:]
+		} else {
+			addr.uri = rest
+		}
+	} else {
+		// parameters of an addr-spec are header parameters
+		// (RFC 3261, section 20.10)
+		addr.uri = value
+		if semi := strings.IndexByte(value, ';'); semi >= 0 {
+			addr.uri = value[:semi]
+			params = value[semi:]
+		}
+	}
+	addr.uri = strings.TrimSpace(addr.uri)
+
+	for _, param := range strings.Split(params, ";") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], "tag") {
+			addr.tag = kv[1]
+		}
+	}
+	return addr
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && (c < '0' || c > '9') &&
+			strings.IndexByte("-.!%*_+`'~", c) < 0 {
+			return false
+		}
+	}
+	return true
+}
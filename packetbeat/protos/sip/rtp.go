@@ -0,0 +1,162 @@
+package sip
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const rtpHeaderLen = 12
+
+var errInvalidRTP = errors.New("invalid rtp packet")
+
+type rtpHeader struct {
+	payloadType uint8
+	seq         uint16
+	timestamp   uint32
+	ssrc        uint32
+}
+
+func parseRTPHeader(data []byte) (rtpHeader, error) {
+	if len(data) < rtpHeaderLen || data[0]>>6 != 2 {
+		return rtpHeader{}, errInvalidRTP
+	}
+	// RTCP packets multiplexed on the RTP port (RFC 5761)
+	if pt := data[1]; pt >= 192 && pt <= 223 {
+		return rtpHeader{}, errInvalidRTP
+	}
+	return rtpHeader{
+		payloadType: data[1] & 0x7f,
+		seq:         binary.BigEndian.Uint16(data[2:]),
+		timestamp:   binary.BigEndian.Uint32(data[4:]),
+		ssrc:        binary.BigEndian.Uint32(data[8:]),
+	}, nil
+}
+
+type rtpStreamKey struct {
+	tuple common.HashableIPPortTuple
+	ssrc  uint32
+}
+
+// rtpStream holds the statistics of an RTP stream, computed as described in
+// RFC 3550, appendix A.
+type rtpStream struct {
+	tuple       common.IPPortTuple
+	ssrc        uint32
+	payloadType uint8
+	encoding    string
+	clockRate   int
+
+	packets uint64
+	bytes   uint64
+	first   time.Time
+	last    time.Time
+
+	// extended sequence numbers
+	baseSeq uint16
+	maxSeq  uint16
+	cycles  uint32
+
+	// interarrival jitter, in timestamp units
+	lastArrival   time.Time
+	lastTimestamp uint32
+	jitter        float64
+	maxJitter     float64
+}
+
+func newRTPStream(tuple *common.IPPortTuple, hdr rtpHeader, format rtpFormat) *rtpStream {
+	return &rtpStream{
+		tuple:       *tuple,
+		ssrc:        hdr.ssrc,
+		payloadType: hdr.payloadType,
+		encoding:    format.encoding,
+		clockRate:   format.clockRate,
+		baseSeq:     hdr.seq,
+		maxSeq:      hdr.seq,
+	}
+}
+
+func (s *rtpStream) update(hdr rtpHeader, size int, ts time.Time) {
+	if s.packets > 0 {
+		if delta := hdr.seq - s.maxSeq; delta > 0 && delta < 0x8000 {
+			if hdr.seq < s.maxSeq {
+				s.cycles += 1 << 16
+			}
+			s.maxSeq = hdr.seq
+		}
+
+		// D(i-1,i) = (Rj - Ri) - (Sj - Si), with the arrival times in
+		// timestamp units
+		arrival := ts.Sub(s.lastArrival).Seconds() * float64(s.clockRate)
+		d := arrival - float64(int32(hdr.timestamp-s.lastTimestamp))
+		if d < 0 {
+			d = -d
+		}
+		s.jitter += (d - s.jitter) / 16
+		if s.jitter > s.maxJitter {
+			s.maxJitter = s.jitter
+		}
+	} else {
+		s.first = ts
+	}
+
+	s.packets++
+	s.bytes += uint64(size)
+	s.last = ts
+	s.lastArrival = ts
+	s.lastTimestamp = hdr.timestamp
+}
+
+// expected returns the number of packets expected from the sequence numbers.
+func (s *rtpStream) expected() uint64 {
+	if s.packets == 0 {
+		return 0
+	}
+	return uint64(s.cycles) + uint64(s.maxSeq) - uint64(s.baseSeq) + 1
+}
+
+// lost returns the number of lost packets. Duplicated packets can make up
+// for lost ones, the result is never negative.
+func (s *rtpStream) lost() uint64 {
+	expected := s.expected()
+	if s.packets >= expected {
+		return 0
+	}
+	return expected - s.packets
+}
+
+// jitterMillis converts a jitter in timestamp units to milliseconds.
+func (s *rtpStream) jitterMillis(jitter float64) float64 {
+	return jitter * 1000 / float64(s.clockRate)
+}
+
+func (s *rtpStream) fields() common.MapStr {
+	fields := common.MapStr{
+		"src": common.MapStr{
+			"ip":   s.tuple.SrcIP.String(),
+			"port": s.tuple.SrcPort,
+		},
+		"dst": common.MapStr{
+			"ip":   s.tuple.DstIP.String(),
+			"port": s.tuple.DstPort,
+		},
+		"ssrc":         s.ssrc,
+		"payload_type": s.payloadType,
+		"packets":      s.packets,
+		"bytes":        s.bytes,
+		"expected":     s.expected(),
+		"lost":         s.lost(),
+		"jitter":       s.jitterMillis(s.jitter),
+		"max_jitter":   s.jitterMillis(s.maxJitter),
+		"duration":     int64(s.last.Sub(s.first) / time.Millisecond),
+	}
+	if s.encoding != "" {
+		fields["codec"] = s.encoding
+	}
+	if expected := s.expected(); expected > 0 {
+		fields["loss_rate"] = float64(s.lost()) / float64(expected)
+	}
+	return fields
+}
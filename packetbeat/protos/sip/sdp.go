@@ -0,0 +1,158 @@
+package sip
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// default RTP clock rate, used by most audio codecs
+const defaultClockRate = 8000
+
+// sdpMedia is a media description of an SDP body (RFC 4566).
+type sdpMedia struct {
+	typ     string
+	address string // from the c= line of the media or of the session
+	port    int
+	proto   string
+	formats []string
+
+	// encoding names and clock rates by payload type, from a=rtpmap lines
+	rtpmap map[string]rtpFormat
+}
+
+type rtpFormat struct {
+	encoding  string
+	clockRate int
+}
+
+// clock rates of the static audio payload types (RFC 3551)
+var staticFormats = map[string]rtpFormat{
+	"0":  {"PCMU", 8000},
+	"3":  {"GSM", 8000},
+	"4":  {"G723", 8000},
+	"8":  {"PCMA", 8000},
+	"9":  {"G722", 8000},
+	"18": {"G729", 8000},
+}
+
+// parseSDP returns the media descriptions of an SDP body.
+func parseSDP(body []byte) []*sdpMedia {
+	var sessionAddr string
+	var media []*sdpMedia
+	var current *sdpMedia
+
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) < 2 || line[1] != '=' {
+			continue
+		}
+		value := line[2:]
+
+		switch line[0] {
+		case 'c':
+			// c=IN IP4 192.0.2.10[/ttl]
+			fields := strings.Fields(value)
+			if len(fields) != 3 {
+				continue
+			}
+			addr := fields[2]
+			if slash := strings.IndexByte(addr, '/'); slash >= 0 {
+				addr = addr[:slash]
+			}
+			if current == nil {
+				sessionAddr = addr
+			} else {
+				current.address = addr
+			}
+
+		case 'm':
+			// m=audio 49170 RTP/AVP 0 8 101
+			fields := strings.Fields(value)
+			if len(fields) < 3 {
+				current = nil
+				continue
+			}
+			portField := fields[1]
+			if slash := strings.IndexByte(portField, '/'); slash >= 0 {
+				portField = portField[:slash]
+			}
+			port, err := strconv.Atoi(portField)
+			if err != nil || port < 0 || port > 65535 {
+				current = nil
+				continue
+			}
+			current = &sdpMedia{
+				typ:     fields[0],
+				port:    port,
+				proto:   fields[2],
+				formats: fields[3:],
+				rtpmap:  map[string]rtpFormat{},
+			}
+			media = append(media, current)
+
+		case 'a':
+			// a=rtpmap:0 PCMU/8000
+			if current == nil || !strings.HasPrefix(value, "rtpmap:") {
+				continue
+			}
+			fields := strings.Fields(value[len("rtpmap:"):])
+			if len(fields) != 2 {
+				continue
+			}
+			parts := strings.Split(fields[1], "/")
+			format := rtpFormat{encoding: parts[0]}
+			if len(parts) > 1 {
+				format.clockRate, _ = strconv.Atoi(parts[1])
+			}
+			current.rtpmap[fields[0]] = format
+		}
+	}
+
+	for _, m := range media {
+		if m.address == "" {
+			m.address = sessionAddr
+		}
+	}
+	return media
+}
+
+// isRTP checks if a media description negotiates an RTP stream.
+func (m *sdpMedia) isRTP() bool {
+	return m.port != 0 && m.address != "" && strings.HasPrefix(m.proto, "RTP/")
+}
+
+// format returns the encoding of a payload type.
+func (m *sdpMedia) format(payloadType string) rtpFormat {
+	format, found := m.rtpmap[payloadType]
+	if !found {
+		format = staticFormats[payloadType]
+	}
+	if format.clockRate <= 0 {
+		format.clockRate = defaultClockRate
+	}
+	return format
+}
+
+func (m *sdpMedia) fields() common.MapStr {
+	fields := common.MapStr{
+		"type":     m.typ,
+		"address":  m.address,
+		"port":     m.port,
+		"protocol": m.proto,
+	}
+	var codecs []string
+	for _, pt := range m.formats {
+		if format := m.format(pt); format.encoding != "" {
+			codecs = append(codecs, format.encoding+"/"+strconv.Itoa(format.clockRate))
+		}
+	}
+	if len(m.formats) > 0 {
+		fields["formats"] = m.formats
+	}
+	if len(codecs) > 0 {
+		fields["codecs"] = codecs
+	}
+	return fields
+}
@@ -0,0 +1,392 @@
+package sip
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+
+	"github.com/elastic/beats/packetbeat/procs"
+	"github.com/elastic/beats/packetbeat/protos"
+	"github.com/elastic/beats/packetbeat/protos/applayer"
+	"github.com/elastic/beats/packetbeat/protos/tcp"
+	"github.com/elastic/beats/packetbeat/publish"
+)
+
+// message is a parsed SIP request or response.
+type message struct {
+	applayer.Message
+
+	// request line
+	method     string
+	requestURI string
+
+	// status line
+	statusCode   int
+	statusPhrase string
+
+	callID        string
+	from, to      address
+	cseq          uint32
+	cseqMethod    string
+	contentType   string
+	contentLength int
+	userAgent     string
+
+	truncated bool
+
+	// body of the message, only valid while parsing
+	body []byte
+
+	// media descriptions of the SDP body
+	media []*sdpMedia
+}
+
+type stream struct {
+	applayer.Stream
+}
+
+type sipConnectionData struct {
+	streams [2]*stream
+}
+
+// SIP protocol plugin
+type sipPlugin struct {
+	// config
+	ports              protos.PortsConfig
+	transactionTimeout time.Duration
+	callTimeout        time.Duration
+	rtpEnabled         bool
+
+	results publish.Transactions
+
+	// now returns the current time, used for the expiration of the
+	// transactions and calls
+	now func() time.Time
+
+	mutex        sync.Mutex
+	transactions map[transactionKey]*transaction
+	calls        map[string]*call
+
+	// RTP endpoints negotiated in the SDP bodies of the calls
+	endpoints map[endpointKey]*rtpEndpoint
+}
+
+var (
+	debugf  = logp.MakeDebug("sip")
+	isDebug = false
+)
+
+var (
+	unmatchedResponses = monitoring.NewInt(nil, "sip.unmatched_responses")
+)
+
+const (
+	noteMessageTruncated = "Message truncated, the body is incomplete"
+	noteNoResponse       = "No final response received"
+)
+
+// interval between two checks for expired transactions and calls
+const expirationInterval = time.Second
+
+func init() {
+	protos.Register("sip", New)
+}
+
+func New(
+	testMode bool,
+	results publish.Transactions,
+	cfg *common.Config,
+) (protos.Plugin, error) {
+	p := &sipPlugin{}
+	config := defaultConfig
+	if !testMode {
+		if err := cfg.Unpack(&config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.init(results, &config); err != nil {
+		return nil, err
+	}
+	go p.expireLoop()
+	return p, nil
+}
+
+func (sip *sipPlugin) init(results publish.Transactions, config *sipConfig) error {
+	if err := sip.setFromConfig(config); err != nil {
+		return err
+	}
+
+	sip.results = results
+	sip.now = time.Now
+	sip.transactions = map[transactionKey]*transaction{}
+	sip.calls = map[string]*call{}
+	sip.endpoints = map[endpointKey]*rtpEndpoint{}
+	isDebug = logp.IsDebug("sip")
+
+	return nil
+}
+
+func (sip *sipPlugin) setFromConfig(config *sipConfig) error {
+	if err := sip.ports.Set(config.Ports); err != nil {
+		return err
+	}
+	sip.transactionTimeout = config.TransactionTimeout
+	sip.callTimeout = config.CallTimeout
+	sip.rtpEnabled = config.RTP.Enabled
+	return nil
+}
+
+func (sip *sipPlugin) GetPorts() []int {
+	return sip.ports.Ports
+}
+
+// HasDynamicPorts returns true if the RTP streams of the calls are followed.
+func (sip *sipPlugin) HasDynamicPorts() bool {
+	return sip.rtpEnabled
+}
+
+// ExpectsUDP checks if a packet is sent to one of the RTP endpoints of the
+// active calls.
+func (sip *sipPlugin) ExpectsUDP(tuple *common.IPPortTuple) bool {
+	if !sip.rtpEnabled {
+		return false
+	}
+
+	sip.mutex.Lock()
+	defer sip.mutex.Unlock()
+	_, found := sip.endpoints[newEndpointKey(tuple.DstIP, tuple.DstPort)]
+	return found
+}
+
+func (sip *sipPlugin) isSIPPort(port uint16) bool {
+	for _, p := range sip.ports.Ports {
+		if uint16(p) == port {
+			return true
+		}
+	}
+	return false
+}
+
+func (sip *sipPlugin) ParseUDP(pkt *protos.Packet) {
+	defer logp.Recover("ParseSip(UDP) exception")
+
+	if !sip.isSIPPort(pkt.Tuple.SrcPort) && !sip.isSIPPort(pkt.Tuple.DstPort) {
+		sip.onRTP(pkt)
+		return
+	}
+
+	// keep-alive datagrams (RFC 5626, section 4.4.1)
+	if len(bytes.TrimSpace(pkt.Payload)) == 0 {
+		return
+	}
+
+	msg, size, err := parseMessage(pkt.Payload, true)
+	if err != nil {
+		if isDebug {
+			debugf("Ignore SIP datagram from %v: %v", pkt.Tuple.String(), err)
+		}
+		return
+	}
+
+	msg.Ts = pkt.Ts
+	msg.Tuple = pkt.Tuple
+	msg.Transport = applayer.TransportUDP
+	msg.CmdlineTuple = procs.ProcWatcher.FindProcessesTuple(&pkt.Tuple, procs.TransportUDP)
+	msg.Direction = applayer.NetOriginalDirection
+	msg.Size = uint64(size)
+	sip.onMessage(msg)
+}
+
+func (sip *sipPlugin) ConnectionTimeout() time.Duration {
+	return sip.transactionTimeout
+}
+
+func (sip *sipPlugin) Parse(
+	pkt *protos.Packet,
+	tcptuple *common.TCPTuple,
+	dir uint8,
+	private protos.ProtocolData,
+) protos.ProtocolData {
+	defer logp.Recover("ParseSip exception")
+
+	conn := ensureSipConnection(private)
+	conn = sip.doParse(conn, pkt, tcptuple, dir)
+	if conn == nil {
+		return nil
+	}
+	return conn
+}
+
+func ensureSipConnection(private protos.ProtocolData) *sipConnectionData {
+	if private == nil {
+		return &sipConnectionData{}
+	}
+
+	priv, ok := private.(*sipConnectionData)
+	if !ok {
+		logp.Warn("sip connection data type error, create new one")
+		return &sipConnectionData{}
+	}
+	if priv == nil {
+		logp.Warn("Unexpected: sip connection data not set, create new one")
+		return &sipConnectionData{}
+	}
+
+	return priv
+}
+
+func (sip *sipPlugin) doParse(
+	conn *sipConnectionData,
+	pkt *protos.Packet,
+	tcptuple *common.TCPTuple,
+	dir uint8,
+) *sipConnectionData {
+	st := conn.streams[dir]
+	if st == nil {
+		st = &stream{}
+		st.Stream.Init(tcp.TCPMaxDataInStream)
+		conn.streams[dir] = st
+		if isDebug {
+			debugf("new stream: %p (dir=%v, len=%v)", st, dir, len(pkt.Payload))
+		}
+	}
+
+	if err := st.Append(pkt.Payload); err != nil {
+		if isDebug {
+			debugf("%v, dropping TCP stream", err)
+		}
+		return nil
+	}
+
+	for st.Buf.Len() > 0 {
+		// skip the CRLF keep-alives (RFC 5626, section 3.5.1)
+		data := st.Buf.Bytes()
+		trimmed := bytes.TrimLeft(data, "\r\n")
+		if len(trimmed) < len(data) {
+			st.Buf.Advance(len(data) - len(trimmed))
+			continue
+		}
+
+		msg, size, err := parseMessage(data, false)
+		if err != nil {
+			// drop this tcp stream. Will retry parsing with the next
+			// segment in it
+			conn.streams[dir] = nil
+			if isDebug {
+				debugf("Ignore SIP message (%v). Drop tcp stream. Try parsing with the next segment", err)
+			}
+			return conn
+		}
+		if msg == nil {
+			// wait for more data
+			break
+		}
+
+		msg.Ts = pkt.Ts
+		msg.Tuple = *tcptuple.IPPort()
+		msg.Transport = applayer.TransportTCP
+		msg.CmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort(), procs.TransportTCP)
+		msg.Size = uint64(size)
+		if dir == tcp.TCPDirectionOriginal {
+			msg.Direction = applayer.NetOriginalDirection
+		} else {
+			msg.Direction = applayer.NetReverseDirection
+		}
+		sip.onMessage(msg)
+
+		st.Buf.Advance(size)
+		st.Stream.Reset()
+	}
+	st.Stream.Reset()
+
+	return conn
+}
+
+func (sip *sipPlugin) GapInStream(tcptuple *common.TCPTuple, dir uint8,
+	nbytes int, private protos.ProtocolData) (priv protos.ProtocolData, drop bool) {
+
+	return private, true
+}
+
+func (sip *sipPlugin) ReceivedFin(tcptuple *common.TCPTuple, dir uint8,
+	private protos.ProtocolData) protos.ProtocolData {
+
+	return private
+}
+
+// onMessage correlates a message with its transaction and call, and publishes
+// the completed transactions and calls.
+func (sip *sipPlugin) onMessage(msg *message) {
+	if msg.truncated {
+		msg.AddNotes(noteMessageTruncated)
+	} else if isSDP(msg) {
+		msg.media = parseSDP(msg.body)
+	}
+	msg.body = nil
+
+	sip.mutex.Lock()
+	events := sip.handleMessage(msg)
+	sip.mutex.Unlock()
+
+	sip.publish(events)
+}
+
+func isSDP(msg *message) bool {
+	if len(msg.body) == 0 {
+		return false
+	}
+	if msg.contentType != "" {
+		return strings.HasPrefix(msg.contentType, "application/sdp")
+	}
+	return bytes.HasPrefix(msg.body, []byte("v=0"))
+}
+
+func (sip *sipPlugin) onRTP(pkt *protos.Packet) {
+	hdr, err := parseRTPHeader(pkt.Payload)
+	if err != nil {
+		return
+	}
+
+	sip.mutex.Lock()
+	defer sip.mutex.Unlock()
+
+	ep := sip.endpoints[newEndpointKey(pkt.Tuple.DstIP, pkt.Tuple.DstPort)]
+	if ep == nil {
+		return
+	}
+	ep.call.lastSeen = sip.now()
+	ep.call.addRTP(&pkt.Tuple, hdr, len(pkt.Payload), pkt.Ts, ep.media)
+}
+
+func (sip *sipPlugin) expireLoop() {
+	ticker := time.NewTicker(expirationInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sip.expire()
+	}
+}
+
+// expire publishes the transactions without final response and the calls
+// which timed out.
+func (sip *sipPlugin) expire() {
+	sip.mutex.Lock()
+	events := sip.expireTransactions(sip.now())
+	sip.mutex.Unlock()
+
+	sip.publish(events)
+}
+
+func (sip *sipPlugin) publish(events []common.MapStr) {
+	if sip.results == nil {
+		return
+	}
+	for _, event := range events {
+		sip.results.PublishTransaction(event)
+	}
+}
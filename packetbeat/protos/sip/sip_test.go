@@ -0,0 +1,428 @@
+// +build !integration
+
+package sip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/packetbeat/protos"
+	"github.com/elastic/beats/packetbeat/protos/tcp"
+	"github.com/elastic/beats/packetbeat/publish"
+)
+
+var (
+	callerIP = net.IPv4(192, 168, 0, 1)
+	proxyIP  = net.IPv4(192, 168, 0, 2)
+	calleeIP = net.IPv4(192, 168, 0, 3)
+)
+
+const (
+	testCallID = "a84b4c76e66710@pc33.example.com"
+	fromHeader = `"Alice" <sip:alice@example.com>;tag=1928301774`
+	toHeader   = `Bob <sip:bob@example.com>`
+)
+
+func sdpBody(ip net.IP, port int) string {
+	return fmt.Sprintf("v=0\r\n"+
+		"o=- 2890844526 2890844526 IN IP4 %v\r\n"+
+		"s=-\r\n"+
+		"c=IN IP4 %v\r\n"+
+		"t=0 0\r\n"+
+		"m=audio %v RTP/AVP 0 101\r\n"+
+		"a=rtpmap:0 PCMU/8000\r\n"+
+		"a=rtpmap:101 telephone-event/8000\r\n", ip, ip, port)
+}
+
+func request(method string, cseq int, body string) []byte {
+	msg := fmt.Sprintf("%s sip:bob@example.com SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP pc33.example.com;branch=z9hG4bK776asdhds\r\n"+
+		"Max-Forwards: 70\r\n"+
+		"To: %s\r\n"+
+		"From: %s\r\n"+
+		"Call-ID: %s\r\n"+
+		"CSeq: %d %s\r\n"+
+		"User-Agent: softphone/1.0\r\n", method, toHeader, fromHeader, testCallID, cseq, method)
+	return withBody(msg, body)
+}
+
+func response(code int, phrase string, method string, cseq int, body string) []byte {
+	msg := fmt.Sprintf("SIP/2.0 %d %s\r\n"+
+		"Via: SIP/2.0/UDP pc33.example.com;branch=z9hG4bK776asdhds\r\n"+
+		"To: %s;tag=a6c85cf\r\n"+
+		"From: %s\r\n"+
+		"Call-ID: %s\r\n"+
+		"CSeq: %d %s\r\n"+
+		"Server: pbx/2.1\r\n", code, phrase, toHeader, fromHeader, testCallID, cseq, method)
+	return withBody(msg, body)
+}
+
+func withBody(msg, body string) []byte {
+	if body != "" {
+		msg += "Content-Type: application/sdp\r\n"
+	}
+	msg += fmt.Sprintf("Content-Length: %d\r\n\r\n", len(body))
+	return []byte(msg + body)
+}
+
+func rtpPacket(seq uint16, timestamp uint32, ssrc uint32) []byte {
+	pkt := make([]byte, rtpHeaderLen+160)
+	pkt[0] = 0x80
+	pkt[1] = 0 // PCMU
+	binary.BigEndian.PutUint16(pkt[2:], seq)
+	binary.BigEndian.PutUint32(pkt[4:], timestamp)
+	binary.BigEndian.PutUint32(pkt[8:], ssrc)
+	return pkt
+}
+
+type testPlugin struct {
+	*sipPlugin
+	clock time.Time
+	ts    time.Time
+}
+
+func sipModForTests(rtp bool) *testPlugin {
+	config := defaultConfig
+	config.Ports = []int{5060}
+	config.RTP.Enabled = rtp
+
+	p := &testPlugin{sipPlugin: &sipPlugin{}, clock: time.Now(), ts: time.Now()}
+	results := &publish.ChanTransactions{Channel: make(chan common.MapStr, 100)}
+	p.init(results, &config)
+	p.now = func() time.Time { return p.clock }
+	return p
+}
+
+// send feeds a datagram into the plugin, 10 milliseconds after the previous
+// one.
+func (p *testPlugin) send(src net.IP, srcPort uint16, dst net.IP, dstPort uint16, payload []byte) {
+	p.ts = p.ts.Add(10 * time.Millisecond)
+	p.clock = p.clock.Add(10 * time.Millisecond)
+	pkt := &protos.Packet{
+		Ts:      p.ts,
+		Tuple:   common.NewIPPortTuple(4, src, srcPort, dst, dstPort),
+		Payload: payload,
+	}
+	p.ParseUDP(pkt)
+}
+
+func (p *testPlugin) toCallee(payload []byte) {
+	p.send(callerIP, 5060, proxyIP, 5060, payload)
+}
+
+func (p *testPlugin) toCaller(payload []byte) {
+	p.send(proxyIP, 5060, callerIP, 5060, payload)
+}
+
+func (p *testPlugin) events() []common.MapStr {
+	var events []common.MapStr
+	client := p.results.(*publish.ChanTransactions)
+	for {
+		select {
+		case event := <-client.Channel:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestParseMessage_request(t *testing.T) {
+	data := []byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"v: SIP/2.0/UDP pc33.example.com;branch=z9hG4bK776asdhds\r\n" +
+		"t: sip:bob@example.com\r\n" +
+		"f: \"Alice Liddell\"\r\n <sip:alice@example.com>;tag=1928301774\r\n" +
+		"i: a84b4c76e66710\r\n" +
+		"CSeq: 314159 INVITE\r\n" +
+		"c: application/sdp\r\n" +
+		"l: 4\r\n" +
+		"\r\n" +
+		"v=0\r\n")
+
+	msg, size, err := parseMessage(data, false)
+	if assert.NoError(t, err) && assert.NotNil(t, msg) {
+		assert.True(t, msg.IsRequest)
+		assert.Equal(t, "INVITE", msg.method)
+		assert.Equal(t, "sip:bob@example.com", msg.requestURI)
+		assert.Equal(t, "a84b4c76e66710", msg.callID)
+		assert.Equal(t, address{"Alice Liddell", "sip:alice@example.com", "1928301774"}, msg.from)
+		assert.Equal(t, address{"", "sip:bob@example.com", ""}, msg.to)
+		assert.Equal(t, uint32(314159), msg.cseq)
+		assert.Equal(t, "INVITE", msg.cseqMethod)
+		assert.Equal(t, "v=0\r", string(msg.body))
+		assert.Equal(t, len(data)-1, size)
+	}
+
+	// incomplete body
+	msg, _, err = parseMessage(data[:len(data)-3], false)
+	assert.NoError(t, err)
+	assert.Nil(t, msg)
+
+	// datagrams are never completed
+	msg, _, err = parseMessage(data[:len(data)-3], true)
+	if assert.NoError(t, err) && assert.NotNil(t, msg) {
+		assert.True(t, msg.truncated)
+	}
+}
+
+func TestParseMessage_invalid(t *testing.T) {
+	for _, data := range []string{
+		"GET / HTTP/1.1\r\n\r\n",
+		"SIP/2.0 abc OK\r\n\r\n",
+		"INVITE sip:bob@example.com SIP/2.0\r\nno header\r\n\r\n",
+		"INVITE sip:bob@example.com SIP/2.0\r\nCSeq: one INVITE\r\n\r\n",
+		"INVITE sip:bob@example.com SIP/2.0\r\nContent-Length: -1\r\n\r\n",
+	} {
+		_, _, err := parseMessage([]byte(data), true)
+		assert.Error(t, err, data)
+	}
+}
+
+func TestParseSDP(t *testing.T) {
+	body := "v=0\r\n" +
+		"c=IN IP4 192.0.2.1/127\r\n" +
+		"m=audio 49170 RTP/AVP 0 96\r\n" +
+		"a=rtpmap:96 opus/48000/2\r\n" +
+		"m=video 51372 RTP/AVP 31\r\n" +
+		"c=IN IP4 192.0.2.2\r\n"
+
+	media := parseSDP([]byte(body))
+	if assert.Len(t, media, 2) {
+		assert.Equal(t, "audio", media[0].typ)
+		assert.Equal(t, "192.0.2.1", media[0].address)
+		assert.Equal(t, 49170, media[0].port)
+		assert.Equal(t, rtpFormat{"PCMU", 8000}, media[0].format("0"))
+		assert.Equal(t, rtpFormat{"opus", 48000}, media[0].format("96"))
+		assert.Equal(t, []string{"PCMU/8000", "opus/48000"}, media[0].fields()["codecs"])
+
+		assert.Equal(t, "video", media[1].typ)
+		assert.Equal(t, "192.0.2.2", media[1].address)
+		assert.Equal(t, defaultClockRate, media[1].format("31").clockRate)
+	}
+}
+
+func TestCall_answered(t *testing.T) {
+	p := sipModForTests(true)
+
+	p.toCallee(request("INVITE", 1, sdpBody(callerIP, 49170)))
+	p.toCaller(response(100, "Trying", "INVITE", 1, ""))
+	p.toCaller(response(180, "Ringing", "INVITE", 1, ""))
+	p.toCaller(response(200, "OK", "INVITE", 1, sdpBody(calleeIP, 3456)))
+	// retransmission of the 200 response
+	p.toCaller(response(200, "OK", "INVITE", 1, sdpBody(calleeIP, 3456)))
+	p.toCallee(request("ACK", 1, ""))
+
+	events := p.events()
+	if !assert.Len(t, events, 1) {
+		return
+	}
+	invite := events[0]
+	assert.Equal(t, "sip", invite["type"])
+	assert.Equal(t, "INVITE", invite["method"])
+	assert.Equal(t, common.OK_STATUS, invite["status"])
+	assert.Equal(t, int32(30), invite["responsetime"])
+	sip := invite["sip"].(common.MapStr)
+	assert.Equal(t, testCallID, sip["call_id"])
+	assert.Equal(t, 200, sip["status_code"])
+	assert.Equal(t, []int{100, 180}, sip["provisional_responses"])
+	assert.Equal(t, int32(20), sip["ringing_time"])
+	assert.Equal(t, "a6c85cf", sip["to"].(common.MapStr)["tag"])
+	assert.Equal(t, "Alice", sip["from"].(common.MapStr)["display_name"])
+	sdp := sip["sdp"].(common.MapStr)
+	assert.Equal(t, 49170, sdp["request"].([]common.MapStr)[0]["port"])
+	assert.Equal(t, 3456, sdp["response"].([]common.MapStr)[0]["port"])
+
+	// RTP streams in both directions, with a lost packet
+	assert.True(t, p.ExpectsUDP(&common.IPPortTuple{DstIP: calleeIP, DstPort: 3456}))
+	assert.False(t, p.ExpectsUDP(&common.IPPortTuple{DstIP: calleeIP, DstPort: 3458}))
+	for i := 0; i < 10; i++ {
+		if i != 4 {
+			p.send(callerIP, 49170, calleeIP, 3456, rtpPacket(uint16(100+i), uint32(i*80), 1))
+		}
+		p.send(calleeIP, 3456, callerIP, 49170, rtpPacket(uint16(65530+i), uint32(i*80), 2))
+	}
+
+	p.toCallee(request("BYE", 2, ""))
+	p.toCaller(response(200, "OK", "BYE", 2, ""))
+
+	events = p.events()
+	if !assert.Len(t, events, 2) {
+		return
+	}
+	summary := events[0]
+	assert.Equal(t, "sip_call", summary["type"])
+	assert.Equal(t, common.OK_STATUS, summary["status"])
+	call := summary["sip_call"].(common.MapStr)
+	assert.Equal(t, endReasonBye, call["end_reason"])
+	assert.Equal(t, 200, call["status_code"])
+	assert.Equal(t, int32(30), call["setup_time"])
+	assert.Equal(t, int32(20), call["ringing_time"])
+	assert.Equal(t, "a6c85cf", call["to"].(common.MapStr)["tag"])
+
+	streams := call["rtp"].([]common.MapStr)
+	if assert.Len(t, streams, 2) {
+		assert.Equal(t, uint32(1), streams[0]["ssrc"])
+		assert.Equal(t, uint64(9), streams[0]["packets"])
+		assert.Equal(t, uint64(10), streams[0]["expected"])
+		assert.Equal(t, uint64(1), streams[0]["lost"])
+		assert.Equal(t, "PCMU", streams[0]["codec"])
+
+		// sequence numbers wrapping around
+		assert.Equal(t, uint64(10), streams[1]["packets"])
+		assert.Equal(t, uint64(0), streams[1]["lost"])
+	}
+
+	assert.Equal(t, "BYE", events[1]["method"])
+	assert.False(t, p.ExpectsUDP(&common.IPPortTuple{DstIP: calleeIP, DstPort: 3456}))
+}
+
+func TestCall_cancelled(t *testing.T) {
+	p := sipModForTests(false)
+
+	p.toCallee(request("INVITE", 1, sdpBody(callerIP, 49170)))
+	p.toCaller(response(180, "Ringing", "INVITE", 1, ""))
+	p.toCallee(request("CANCEL", 1, ""))
+	p.toCaller(response(200, "OK", "CANCEL", 1, ""))
+	p.toCaller(response(487, "Request Terminated", "INVITE", 1, ""))
+
+	events := p.events()
+	if !assert.Len(t, events, 3) {
+		return
+	}
+	assert.Equal(t, "CANCEL", events[0]["method"])
+	assert.Equal(t, common.CLIENT_ERROR_STATUS, events[1]["status"])
+	assert.Equal(t, 487, events[1]["sip"].(common.MapStr)["status_code"])
+
+	call := events[2]["sip_call"].(common.MapStr)
+	assert.Equal(t, endReasonCancel, call["end_reason"])
+	assert.Equal(t, 487, call["status_code"])
+	assert.Nil(t, call["setup_time"])
+	assert.Nil(t, call["rtp"])
+	assert.False(t, p.ExpectsUDP(&common.IPPortTuple{DstIP: callerIP, DstPort: 49170}))
+}
+
+func TestCall_rejected(t *testing.T) {
+	p := sipModForTests(false)
+
+	p.toCallee(request("INVITE", 1, ""))
+	p.toCaller(response(486, "Busy Here", "INVITE", 1, ""))
+
+	events := p.events()
+	if !assert.Len(t, events, 2) {
+		return
+	}
+	assert.Equal(t, common.CLIENT_ERROR_STATUS, events[1]["status"])
+	call := events[1]["sip_call"].(common.MapStr)
+	assert.Equal(t, endReasonRejected, call["end_reason"])
+	assert.Equal(t, "Busy Here", call["status_phrase"])
+	assert.Equal(t, int32(10), call["setup_time"])
+}
+
+func TestExpiration(t *testing.T) {
+	p := sipModForTests(false)
+
+	p.toCallee(request("INVITE", 1, ""))
+	p.toCaller(response(100, "Trying", "INVITE", 1, ""))
+	p.toCallee(request("OPTIONS", 2, ""))
+
+	// OPTIONS expired, the INVITE is still proceeding
+	p.clock = p.clock.Add(time.Minute)
+	p.expire()
+	events := p.events()
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "OPTIONS", events[0]["method"])
+		assert.Equal(t, common.ERROR_STATUS, events[0]["status"])
+		assert.Equal(t, int32(-1), events[0]["responsetime"])
+		assert.Equal(t, []string{noteNoResponse}, events[0]["notes"])
+	}
+
+	p.clock = p.clock.Add(3 * time.Minute)
+	p.expire()
+	events = p.events()
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "INVITE", events[0]["method"])
+		assert.Equal(t, common.ERROR_STATUS, events[1]["status"])
+		call := events[1]["sip_call"].(common.MapStr)
+		assert.Equal(t, endReasonTimeout, call["end_reason"])
+	}
+	assert.Empty(t, p.calls)
+	assert.Empty(t, p.transactions)
+}
+
+func TestParseTCP(t *testing.T) {
+	p := sipModForTests(false)
+	tcptuple := &common.TCPTuple{
+		IPLength: 4,
+		SrcIP:    callerIP, DstIP: proxyIP,
+		SrcPort: 51234, DstPort: 5060,
+	}
+	tcptuple.ComputeHashebles()
+
+	invite := request("INVITE", 1, sdpBody(callerIP, 49170))
+	ok := response(200, "OK", "INVITE", 1, "")
+	segments := []struct {
+		dir  uint8
+		data []byte
+	}{
+		{tcp.TCPDirectionOriginal, []byte("\r\n\r\n")},
+		{tcp.TCPDirectionOriginal, invite[:50]},
+		{tcp.TCPDirectionOriginal, invite[50 : len(invite)-10]},
+		{tcp.TCPDirectionOriginal, invite[len(invite)-10:]},
+		{tcp.TCPDirectionReverse, append(ok, response(200, "OK", "INVITE", 1, "")...)},
+	}
+
+	var private protos.ProtocolData
+	for _, seg := range segments {
+		pkt := &protos.Packet{Ts: time.Now(), Tuple: *tcptuple.IPPort(), Payload: seg.data}
+		private = p.Parse(pkt, tcptuple, seg.dir, private)
+	}
+
+	events := p.events()
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "tcp", events[0]["transport"])
+		assert.Equal(t, uint64(len(invite)), events[0]["bytes_out"])
+		assert.Equal(t, "192.168.0.1", events[0]["src"].(*common.Endpoint).IP)
+		sdp := events[0]["sip"].(common.MapStr)["sdp"].(common.MapStr)
+		assert.Equal(t, "192.168.0.1", sdp["request"].([]common.MapStr)[0]["address"])
+	}
+}
+
+func TestRTPStream_jitter(t *testing.T) {
+	tuple := common.NewIPPortTuple(4, callerIP, 49170, calleeIP, 3456)
+	ts := time.Now()
+	var s *rtpStream
+
+	// packets of 20ms, every other one arriving 4ms late
+	for i := 0; i < 100; i++ {
+		hdr, err := parseRTPHeader(rtpPacket(uint16(i), uint32(i*160), 1))
+		if !assert.NoError(t, err) {
+			return
+		}
+		if s == nil {
+			s = newRTPStream(&tuple, hdr, rtpFormat{"PCMU", 8000})
+		}
+		arrival := ts.Add(time.Duration(i) * 20 * time.Millisecond)
+		if i%2 == 1 {
+			arrival = arrival.Add(4 * time.Millisecond)
+		}
+		s.update(hdr, 172, arrival)
+	}
+
+	fields := s.fields()
+	assert.Equal(t, uint64(0), fields["lost"])
+	assert.InDelta(t, 4.0, fields["jitter"], 0.01)
+	assert.InDelta(t, 4.0, fields["max_jitter"], 0.01)
+	assert.Equal(t, int64(1984), fields["duration"])
+
+	// RTCP packets are ignored
+	rtcp := rtpPacket(0, 0, 1)
+	rtcp[1] = 200
+	_, err := parseRTPHeader(rtcp)
+	assert.Error(t, err)
+}
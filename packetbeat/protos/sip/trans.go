@@ -0,0 +1,441 @@
+package sip
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+
+	"github.com/elastic/beats/packetbeat/protos/applayer"
+)
+
+// The INVITE transactions stay in the proceeding state until the call is
+// answered or rejected, up to 3 minutes (timer C of RFC 3261, section 16.6).
+const inviteProceedingTimeout = 3 * time.Minute
+
+// Reasons of the end of a call.
+const (
+	endReasonBye      = "bye"
+	endReasonCancel   = "cancel"
+	endReasonRejected = "rejected"
+	endReasonTimeout  = "timeout"
+)
+
+// transactionKey identifies a SIP transaction: the requests and responses of
+// a transaction share the Call-ID and CSeq headers. CANCEL requests have the
+// CSeq number of the INVITE they cancel, but their own method.
+type transactionKey struct {
+	callID string
+	cseq   uint32
+	method string
+}
+
+type transaction struct {
+	applayer.Transaction
+
+	request     *message
+	response    *message
+	provisional []int
+	ringing     time.Time
+
+	// expiration time of the transaction. Completed transactions are kept
+	// until then to absorb the retransmissions.
+	deadline time.Time
+}
+
+// call is an INVITE dialog, from the initial INVITE to the BYE.
+type call struct {
+	callID string
+	invite *message
+	to     address
+
+	ringing      time.Time
+	answered     time.Time
+	cancelled    bool
+	statusCode   int
+	statusPhrase string
+
+	// last time a SIP or RTP packet of the call was seen
+	lastSeen time.Time
+
+	endpoints []endpointKey
+	streams   map[rtpStreamKey]*rtpStream
+	order     []*rtpStream
+}
+
+type endpointKey struct {
+	ip   string
+	port uint16
+}
+
+func newEndpointKey(ip net.IP, port uint16) endpointKey {
+	return endpointKey{ip: string(ip.To16()), port: port}
+}
+
+// rtpEndpoint is an address receiving the RTP stream of a media description.
+type rtpEndpoint struct {
+	call  *call
+	media *sdpMedia
+}
+
+// handleMessage updates the state of the transactions and calls, and returns
+// the events to be published.
+func (sip *sipPlugin) handleMessage(msg *message) []common.MapStr {
+	if msg.IsRequest {
+		return sip.handleRequest(msg)
+	}
+	return sip.handleResponse(msg)
+}
+
+func (sip *sipPlugin) handleRequest(msg *message) []common.MapStr {
+	now := sip.now()
+	c := sip.calls[msg.callID]
+	if c != nil {
+		c.lastSeen = now
+	}
+
+	// ACKs are not answered. The SDP answer of a late offer is sent in the
+	// ACK of the 2xx response.
+	if msg.method == "ACK" {
+		if c != nil {
+			sip.setMedia(c, msg.media)
+		}
+		return nil
+	}
+
+	key := transactionKey{msg.callID, msg.cseq, msg.method}
+	if _, found := sip.transactions[key]; found {
+		// retransmission
+		return nil
+	}
+	sip.transactions[key] = &transaction{
+		request:  msg,
+		deadline: now.Add(sip.transactionTimeout),
+	}
+
+	switch msg.method {
+	case "INVITE":
+		if c == nil {
+			c = &call{
+				callID:   msg.callID,
+				invite:   msg,
+				to:       msg.to,
+				lastSeen: now,
+				streams:  map[rtpStreamKey]*rtpStream{},
+			}
+			sip.calls[msg.callID] = c
+		}
+		sip.setMedia(c, msg.media)
+
+	case "CANCEL":
+		if c != nil {
+			c.cancelled = true
+		}
+
+	case "BYE":
+		if c != nil {
+			return []common.MapStr{sip.endCall(c, msg.Ts, endReasonBye)}
+		}
+	}
+	return nil
+}
+
+func (sip *sipPlugin) handleResponse(msg *message) []common.MapStr {
+	now := sip.now()
+	key := transactionKey{msg.callID, msg.cseq, msg.cseqMethod}
+	t := sip.transactions[key]
+	if t == nil {
+		if isDebug {
+			debugf("Response without request: %v %v", msg.statusCode, msg.cseqMethod)
+		}
+		unmatchedResponses.Add(1)
+		return nil
+	}
+	if t.response != nil {
+		// retransmission
+		return nil
+	}
+
+	c := sip.calls[msg.callID]
+	if c != nil {
+		c.lastSeen = now
+	}
+	isInitialInvite := c != nil && c.invite == t.request
+
+	if msg.statusCode < 200 {
+		t.provisional = append(t.provisional, msg.statusCode)
+		if isRinging(msg.statusCode) && t.ringing.IsZero() {
+			t.ringing = msg.Ts
+			if isInitialInvite {
+				c.ringing = msg.Ts
+			}
+		}
+		if msg.cseqMethod == "INVITE" {
+			t.deadline = now.Add(inviteProceedingTimeout)
+			if c != nil {
+				// early media
+				sip.setMedia(c, msg.media)
+			}
+		}
+		return nil
+	}
+
+	t.response = msg
+	t.deadline = now.Add(sip.transactionTimeout)
+	events := []common.MapStr{t.event()}
+
+	if c != nil && msg.cseqMethod == "INVITE" {
+		if msg.statusCode < 300 {
+			sip.setMedia(c, msg.media)
+		}
+		if isInitialInvite {
+			c.statusCode = msg.statusCode
+			c.statusPhrase = msg.statusPhrase
+			if msg.statusCode < 300 {
+				c.answered = msg.Ts
+				c.to = msg.to
+			} else {
+				reason := endReasonRejected
+				if c.cancelled {
+					reason = endReasonCancel
+				}
+				events = append(events, sip.endCall(c, msg.Ts, reason))
+			}
+		}
+	}
+	return events
+}
+
+// isRinging checks if a provisional response reports that the callee is
+// being alerted.
+func isRinging(code int) bool {
+	return code == 180 || code == 183
+}
+
+// setMedia registers the RTP endpoints of the media descriptions of a call.
+func (sip *sipPlugin) setMedia(c *call, media []*sdpMedia) {
+	if !sip.rtpEnabled {
+		return
+	}
+	for _, m := range media {
+		if !m.isRTP() {
+			continue
+		}
+		ip := net.ParseIP(m.address)
+		if ip == nil {
+			continue
+		}
+		key := newEndpointKey(ip, uint16(m.port))
+		if ep, found := sip.endpoints[key]; found && ep.call == c {
+			ep.media = m
+			continue
+		}
+		sip.endpoints[key] = &rtpEndpoint{call: c, media: m}
+		c.endpoints = append(c.endpoints, key)
+	}
+}
+
+// endCall removes a call and returns its event.
+func (sip *sipPlugin) endCall(c *call, ts time.Time, reason string) common.MapStr {
+	delete(sip.calls, c.callID)
+	for _, key := range c.endpoints {
+		if ep, found := sip.endpoints[key]; found && ep.call == c {
+			delete(sip.endpoints, key)
+		}
+	}
+	return c.event(ts, reason)
+}
+
+// expireTransactions removes the expired transactions and calls, and returns
+// the events of the transactions and calls ended without final response.
+func (sip *sipPlugin) expireTransactions(now time.Time) []common.MapStr {
+	var events []common.MapStr
+	for key, t := range sip.transactions {
+		if now.Before(t.deadline) {
+			continue
+		}
+		delete(sip.transactions, key)
+		if t.response != nil {
+			continue
+		}
+
+		events = append(events, t.event())
+		if c := sip.calls[key.callID]; c != nil && c.invite == t.request {
+			events = append(events, sip.endCall(c, t.request.Ts, endReasonTimeout))
+		}
+	}
+
+	for _, c := range sip.calls {
+		if now.Sub(c.lastSeen) >= sip.callTimeout {
+			events = append(events, sip.endCall(c, c.invite.Ts, endReasonTimeout))
+		}
+	}
+	return events
+}
+
+func (c *call) addRTP(tuple *common.IPPortTuple, hdr rtpHeader, size int, ts time.Time, media *sdpMedia) {
+	key := rtpStreamKey{tuple.Hashable(), hdr.ssrc}
+	s := c.streams[key]
+	if s == nil {
+		s = newRTPStream(tuple, hdr, media.format(strconv.Itoa(int(hdr.payloadType))))
+		c.streams[key] = s
+		c.order = append(c.order, s)
+	}
+	s.update(hdr, size, ts)
+}
+
+func (t *transaction) event() common.MapStr {
+	requ, resp := t.request, t.response
+
+	t.InitWithMsg("sip", &requ.Message)
+	t.BytesOut = requ.Size
+	t.Notes = append(t.Notes, requ.Notes...)
+	if resp == nil {
+		t.ResponseTime = -1
+		t.Status = common.ERROR_STATUS
+		t.Notes = append(t.Notes, noteNoResponse)
+	} else {
+		t.BytesIn = resp.Size
+		t.ResponseTime = millis(resp.Ts.Sub(requ.Ts))
+		t.Status = statusOf(resp.statusCode)
+		t.Notes = append(t.Notes, resp.Notes...)
+	}
+
+	event := common.MapStr{}
+	t.Transaction.Event(event)
+	event["method"] = requ.method
+	event["path"] = requ.requestURI
+
+	sip := common.MapStr{
+		"call_id":     requ.callID,
+		"from":        requ.from.fields(),
+		"to":          requ.to.fields(),
+		"request_uri": requ.requestURI,
+		"cseq": common.MapStr{
+			"number": requ.cseq,
+			"method": requ.cseqMethod,
+		},
+	}
+	if requ.userAgent != "" {
+		sip["user_agent"] = requ.userAgent
+	}
+	if len(t.provisional) > 0 {
+		sip["provisional_responses"] = t.provisional
+	}
+	if !t.ringing.IsZero() {
+		sip["ringing_time"] = millis(t.ringing.Sub(requ.Ts))
+	}
+	sdp := common.MapStr{}
+	if len(requ.media) > 0 {
+		sdp["request"] = mediaFields(requ.media)
+	}
+	if resp != nil {
+		sip["status_code"] = resp.statusCode
+		sip["status_phrase"] = resp.statusPhrase
+		sip["to"] = resp.to.fields()
+		if resp.userAgent != "" {
+			sip["server"] = resp.userAgent
+		}
+		if len(resp.media) > 0 {
+			sdp["response"] = mediaFields(resp.media)
+		}
+	}
+	if len(sdp) > 0 {
+		sip["sdp"] = sdp
+	}
+
+	event["sip"] = sip
+	return event
+}
+
+// event returns the summary of a call, ended at ts.
+func (c *call) event(ts time.Time, reason string) common.MapStr {
+	invite := c.invite
+
+	t := &applayer.Transaction{}
+	t.InitWithMsg("sip_call", &invite.Message)
+	t.ResponseTime = -1
+	t.Status = common.OK_STATUS
+	if !c.answered.IsZero() {
+		t.ResponseTime = millis(c.answered.Sub(invite.Ts))
+	} else if c.statusCode > 0 && reason != endReasonCancel {
+		t.ResponseTime = millis(ts.Sub(invite.Ts))
+		t.Status = statusOf(c.statusCode)
+	} else if reason == endReasonTimeout {
+		t.Status = common.ERROR_STATUS
+	}
+
+	event := common.MapStr{}
+	t.Event(event)
+	event["method"] = invite.method
+	event["path"] = invite.requestURI
+
+	fields := common.MapStr{
+		"call_id":     c.callID,
+		"from":        invite.from.fields(),
+		"to":          c.to.fields(),
+		"request_uri": invite.requestURI,
+		"end_reason":  reason,
+	}
+	if c.statusCode > 0 {
+		fields["status_code"] = c.statusCode
+		fields["status_phrase"] = c.statusPhrase
+	}
+	if t.ResponseTime >= 0 {
+		fields["setup_time"] = t.ResponseTime
+	}
+	if !c.ringing.IsZero() {
+		fields["ringing_time"] = millis(c.ringing.Sub(invite.Ts))
+	}
+	if !c.answered.IsZero() && reason != endReasonTimeout {
+		fields["duration"] = millis(ts.Sub(c.answered))
+	}
+	if len(c.order) > 0 {
+		streams := make([]common.MapStr, len(c.order))
+		for i, s := range c.order {
+			streams[i] = s.fields()
+		}
+		fields["rtp"] = streams
+	}
+
+	event["sip_call"] = fields
+	return event
+}
+
+func (addr address) fields() common.MapStr {
+	fields := common.MapStr{"uri": addr.uri}
+	if addr.displayName != "" {
+		fields["display_name"] = addr.displayName
+	}
+	if addr.tag != "" {
+		fields["tag"] = addr.tag
+	}
+	return fields
+}
+
+func mediaFields(media []*sdpMedia) []common.MapStr {
+	fields := make([]common.MapStr, len(media))
+	for i, m := range media {
+		fields[i] = m.fields()
+	}
+	return fields
+}
+
+// statusOf maps the class of a final response to a transaction status.
+func statusOf(code int) string {
+	switch {
+	case code < 400:
+		return common.OK_STATUS
+	case code < 500:
+		return common.CLIENT_ERROR_STATUS
+	case code < 600:
+		return common.SERVER_ERROR_STATUS
+	default:
+		return common.ERROR_STATUS
+	}
+}
+
+func millis(d time.Duration) int32 {
+	return int32(d.Nanoseconds() / 1e6)
+}
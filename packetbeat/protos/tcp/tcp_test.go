@@ -309,6 +309,42 @@ func TestTCSeqPayload(t *testing.T) {
 	}
 }
 
+// The streams of the same addresses in different tunnels are kept apart.
+func TestTCPTunnels(t *testing.T) {
+	var tuples []*common.TCPTuple
+	tcp, err := NewTCP(protocols{
+		tcp: map[protos.Protocol]protos.TCPPlugin{
+			httpProtocol: &TestProtocol{
+				Ports: []int{ServerPort},
+				parse: func(p *protos.Packet, t *common.TCPTuple, d uint8, priv protos.ProtocolData) protos.ProtocolData {
+					tuples = append(tuples, t)
+					return priv
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, vni := range []uint32{1, 2} {
+		addr := common.NewIPPortTuple(4,
+			net.ParseIP(ServerIP), ServerPort,
+			net.ParseIP(ClientIP), 34567)
+		addr.Tunnel = common.TunnelID{Type: 1, ID: vni}
+		addr.ComputeHashebles()
+
+		pkt := &protos.Packet{Ts: time.Now(), Tuple: addr, Payload: []byte{1, 2}}
+		tcp.Process(nil, &layers.TCP{Seq: 1}, pkt)
+	}
+
+	if assert.Len(t, tuples, 2) {
+		assert.NotEqual(t, tuples[0].StreamID, tuples[1].StreamID)
+		assert.Equal(t, uint32(1), tuples[0].Tunnel.ID)
+		assert.Equal(t, uint32(2), tuples[1].Tunnel.ID)
+	}
+}
+
 // Benchmark that runs with parallelism to help find concurrency related
 // issues. To run with parallelism, the 'go test' cpu flag must be set
 // greater than 1, otherwise it just runs concurrently but not in parallel.
@@ -880,7 +880,7 @@ func (thrift *thriftPlugin) messageComplete(tcptuple *common.TCPTuple, dir uint8
 	// all ok, go to next level
 	stream.message.tcpTuple = *tcptuple
 	stream.message.direction = dir
-	stream.message.cmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort())
+	stream.message.cmdlineTuple = procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort(), procs.TransportTCP)
 	if stream.message.frameSize == 0 {
 		stream.message.frameSize = uint32(stream.parseOffset - stream.message.start)
 	}
@@ -1136,6 +1136,7 @@ func (thrift *thriftPlugin) publishTransactions() {
 		event["@timestamp"] = common.Time(t.ts)
 		event["src"] = &t.src
 		event["dst"] = &t.dst
+		protos.AddTunnel(event, t.tuple.Tunnel)
 
 		if thrift.results != nil {
 			thrift.results.PublishTransaction(event)
@@ -0,0 +1,257 @@
+package tls
+
+import "fmt"
+
+type tlsVersion struct {
+	major, minor uint8
+}
+
+type extensionType uint16
+
+type compressionMethod uint8
+
+type namedGroup uint16
+
+type signatureScheme uint16
+
+type pointsFormat uint8
+
+type alertSeverity uint8
+
+type alertCode uint8
+
+const (
+	alertSeverityWarning alertSeverity = 1
+	alertSeverityFatal   alertSeverity = 2
+)
+
+var extensionNames = map[extensionType]string{
+	0:     "server_name_indication",
+	1:     "max_fragment_length",
+	5:     "status_request",
+	10:    "supported_groups",
+	11:    "ec_points_formats",
+	13:    "signature_algorithms",
+	15:    "heartbeat",
+	16:    "application_layer_protocol_negotiation",
+	18:    "signed_certificate_timestamp",
+	21:    "padding",
+	22:    "encrypt_then_mac",
+	23:    "extended_master_secret",
+	27:    "compress_certificate",
+	28:    "record_size_limit",
+	35:    "session_ticket",
+	41:    "pre_shared_key",
+	42:    "early_data",
+	43:    "supported_versions",
+	44:    "cookie",
+	45:    "psk_key_exchange_modes",
+	47:    "certificate_authorities",
+	49:    "post_handshake_auth",
+	50:    "signature_algorithms_cert",
+	51:    "key_share",
+	13172: "next_protocol_negotiation",
+	17513: "application_settings",
+	65281: "renegotiation_info",
+}
+
+var compressionMethods = map[compressionMethod]string{
+	0:  "NULL",
+	1:  "DEFLATE",
+	64: "LZS",
+}
+
+var namedGroups = map[namedGroup]string{
+	1:      "sect163k1",
+	2:      "sect163r1",
+	3:      "sect163r2",
+	4:      "sect193r1",
+	5:      "sect193r2",
+	6:      "sect233k1",
+	7:      "sect233r1",
+	8:      "sect239k1",
+	9:      "sect283k1",
+	10:     "sect283r1",
+	11:     "sect409k1",
+	12:     "sect409r1",
+	13:     "sect571k1",
+	14:     "sect571r1",
+	15:     "secp160k1",
+	16:     "secp160r1",
+	17:     "secp160r2",
+	18:     "secp192k1",
+	19:     "secp192r1",
+	20:     "secp224k1",
+	21:     "secp224r1",
+	22:     "secp256k1",
+	23:     "secp256r1",
+	24:     "secp384r1",
+	25:     "secp521r1",
+	26:     "brainpoolP256r1",
+	27:     "brainpoolP384r1",
+	28:     "brainpoolP512r1",
+	29:     "x25519",
+	30:     "x448",
+	256:    "ffdhe2048",
+	257:    "ffdhe3072",
+	258:    "ffdhe4096",
+	259:    "ffdhe6144",
+	260:    "ffdhe8192",
+	0x11ec: "X25519MLKEM768",
+	0x6399: "X25519Kyber768Draft00",
+}
+
+var signatureSchemes = map[signatureScheme]string{
+	0x0201: "rsa_pkcs1_sha1",
+	0x0202: "dsa_sha1",
+	0x0203: "ecdsa_sha1",
+	0x0301: "rsa_pkcs1_sha224",
+	0x0302: "dsa_sha224",
+	0x0303: "ecdsa_sha224",
+	0x0401: "rsa_pkcs1_sha256",
+	0x0402: "dsa_sha256",
+	0x0403: "ecdsa_secp256r1_sha256",
+	0x0501: "rsa_pkcs1_sha384",
+	0x0502: "dsa_sha384",
+	0x0503: "ecdsa_secp384r1_sha384",
+	0x0601: "rsa_pkcs1_sha512",
+	0x0602: "dsa_sha512",
+	0x0603: "ecdsa_secp521r1_sha512",
+	0x0804: "rsa_pss_rsae_sha256",
+	0x0805: "rsa_pss_rsae_sha384",
+	0x0806: "rsa_pss_rsae_sha512",
+	0x0807: "ed25519",
+	0x0808: "ed448",
+	0x0809: "rsa_pss_pss_sha256",
+	0x080a: "rsa_pss_pss_sha384",
+	0x080b: "rsa_pss_pss_sha512",
+	0x0904: "mldsa44",
+	0x0905: "mldsa65",
+	0x0906: "mldsa87",
+}
+
+var pointsFormats = map[pointsFormat]string{
+	0: "uncompressed",
+	1: "ansiX962_compressed_prime",
+	2: "ansiX962_compressed_char2",
+}
+
+var alertSeverities = map[alertSeverity]string{
+	alertSeverityWarning: "warning",
+	alertSeverityFatal:   "fatal",
+}
+
+var alertCodes = map[alertCode]string{
+	0:   "close_notify",
+	10:  "unexpected_message",
+	20:  "bad_record_mac",
+	21:  "decryption_failed",
+	22:  "record_overflow",
+	30:  "decompression_failure",
+	40:  "handshake_failure",
+	41:  "no_certificate",
+	42:  "bad_certificate",
+	43:  "unsupported_certificate",
+	44:  "certificate_revoked",
+	45:  "certificate_expired",
+	46:  "certificate_unknown",
+	47:  "illegal_parameter",
+	48:  "unknown_ca",
+	49:  "access_denied",
+	50:  "decode_error",
+	51:  "decrypt_error",
+	60:  "export_restriction",
+	70:  "protocol_version",
+	71:  "insufficient_security",
+	80:  "internal_error",
+	86:  "inappropriate_fallback",
+	90:  "user_canceled",
+	100: "no_renegotiation",
+	109: "missing_extension",
+	110: "unsupported_extension",
+	111: "certificate_unobtainable",
+	112: "unrecognized_name",
+	113: "bad_certificate_status_response",
+	114: "bad_certificate_hash_value",
+	115: "unknown_psk_identity",
+	116: "certificate_required",
+	120: "no_application_protocol",
+}
+
+func (v tlsVersion) String() string {
+	if v.major == 3 {
+		switch v.minor {
+		case 0:
+			return "SSL 3.0"
+		case 1, 2, 3, 4:
+			return fmt.Sprintf("TLS 1.%d", v.minor-1)
+		}
+	}
+	if v.major == 0x7f {
+		return fmt.Sprintf("TLS 1.3 (draft %d)", v.minor)
+	}
+	if isGREASE(uint16(v.major)<<8 | uint16(v.minor)) {
+		return "(GREASE)"
+	}
+	return fmt.Sprintf("(unknown:0x%02x%02x)", v.major, v.minor)
+}
+
+func (v tlsVersion) code() uint16 {
+	return uint16(v.major)<<8 | uint16(v.minor)
+}
+
+func (t extensionType) String() string {
+	if name, found := extensionNames[t]; found {
+		return name
+	}
+	return unknownName(uint16(t))
+}
+
+func (m compressionMethod) String() string {
+	if name, found := compressionMethods[m]; found {
+		return name
+	}
+	return fmt.Sprintf("(unknown:0x%02x)", uint8(m))
+}
+
+func (g namedGroup) String() string {
+	if name, found := namedGroups[g]; found {
+		return name
+	}
+	return unknownName(uint16(g))
+}
+
+func (s signatureScheme) String() string {
+	if name, found := signatureSchemes[s]; found {
+		return name
+	}
+	return unknownName(uint16(s))
+}
+
+func (f pointsFormat) String() string {
+	if name, found := pointsFormats[f]; found {
+		return name
+	}
+	return fmt.Sprintf("(unknown:0x%02x)", uint8(f))
+}
+
+func (s alertSeverity) String() string {
+	if name, found := alertSeverities[s]; found {
+		return name
+	}
+	return fmt.Sprintf("(unknown:%d)", uint8(s))
+}
+
+func (c alertCode) String() string {
+	if name, found := alertCodes[c]; found {
+		return name
+	}
+	return fmt.Sprintf("(unknown:%d)", uint8(c))
+}
+
+func unknownName(value uint16) string {
+	if isGREASE(value) {
+		return "(GREASE)"
+	}
+	return fmt.Sprintf("(unknown:0x%04x)", value)
+}
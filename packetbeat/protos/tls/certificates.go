@@ -0,0 +1,100 @@
+package tls
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+var publicKeyAlgorithms = map[x509.PublicKeyAlgorithm]string{
+	x509.RSA:   "RSA",
+	x509.DSA:   "DSA",
+	x509.ECDSA: "ECDSA",
+}
+
+// certToMap reports the details of an x509 certificate. If includeRaw is set,
+// the PEM encoded certificate is added to the fields.
+func certToMap(cert *x509.Certificate, includeRaw bool) common.MapStr {
+	m := common.MapStr{
+		"version":             cert.Version,
+		"serial_number":       cert.SerialNumber.String(),
+		"not_before":          common.Time(cert.NotBefore),
+		"not_after":           common.Time(cert.NotAfter),
+		"signature_algorithm": cert.SignatureAlgorithm.String(),
+		"subject":             nameToMap(cert.Subject),
+		"issuer":              nameToMap(cert.Issuer),
+	}
+
+	if name, found := publicKeyAlgorithms[cert.PublicKeyAlgorithm]; found {
+		m["public_key_algorithm"] = name
+	}
+	if size := publicKeySize(cert.PublicKey); size > 0 {
+		m["public_key_size"] = size
+	}
+
+	var altNames []string
+	altNames = append(altNames, cert.DNSNames...)
+	altNames = append(altNames, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		altNames = append(altNames, ip.String())
+	}
+	if len(altNames) > 0 {
+		m["alternative_names"] = altNames
+	}
+
+	if includeRaw {
+		m["raw"] = string(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: cert.Raw,
+		}))
+	}
+	return m
+}
+
+func certChainToList(chain []*x509.Certificate, includeRaw bool) []common.MapStr {
+	list := make([]common.MapStr, len(chain))
+	for i, cert := range chain {
+		list[i] = certToMap(cert, includeRaw)
+	}
+	return list
+}
+
+func nameToMap(name pkix.Name) common.MapStr {
+	m := common.MapStr{}
+	fields := []struct {
+		key    string
+		values []string
+	}{
+		{"country", name.Country},
+		{"organization", name.Organization},
+		{"organizational_unit", name.OrganizationalUnit},
+		{"locality", name.Locality},
+		{"province", name.Province},
+	}
+	for _, f := range fields {
+		if len(f.values) > 0 {
+			m[f.key] = f.values[0]
+		}
+	}
+	if name.CommonName != "" {
+		m["common_name"] = name.CommonName
+	}
+	return m
+}
+
+func publicKeySize(key interface{}) int {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		return pub.N.BitLen()
+	case *dsa.PublicKey:
+		return pub.P.BitLen()
+	case *ecdsa.PublicKey:
+		return pub.Curve.Params().BitSize
+	}
+	return 0
+}
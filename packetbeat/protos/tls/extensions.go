@@ -0,0 +1,225 @@
+package tls
+
+import (
+	"encoding/binary"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/common/streambuf"
+)
+
+const (
+	extensionServerName          extensionType = 0
+	extensionSupportedGroups     extensionType = 10
+	extensionPointsFormats       extensionType = 11
+	extensionSignatureAlgorithms extensionType = 13
+	extensionALPN                extensionType = 16
+	extensionSessionTicket       extensionType = 35
+	extensionPreSharedKey        extensionType = 41
+	extensionSupportedVersions   extensionType = 43
+)
+
+// helloExtensions holds the extensions found in a ClientHello or ServerHello
+// message. Only extensions relevant for auditing TLS setups are parsed, all
+// others are reported by name only.
+type helloExtensions struct {
+	// all extension types in order of appearance
+	types []extensionType
+
+	serverName          []string
+	alpn                []string
+	supportedVersions   []tlsVersion
+	supportedGroups     []namedGroup
+	signatureAlgorithms []signatureScheme
+	pointsFormats       []pointsFormat
+	sessionTicket       bool
+	sessionTicketLen    int
+}
+
+func (e *helloExtensions) parse(data []byte, isClient bool) error {
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return errTruncated
+		}
+
+		typ := extensionType(binary.BigEndian.Uint16(data))
+		length := int(binary.BigEndian.Uint16(data[2:]))
+		if len(data) < 4+length {
+			return errTruncated
+		}
+
+		ext := data[4 : 4+length]
+		data = data[4+length:]
+
+		e.types = append(e.types, typ)
+		if err := e.parseExtension(typ, ext, isClient); err != nil {
+			debugf("failed to parse TLS extension %v: %v", typ, err)
+		}
+	}
+	return nil
+}
+
+func (e *helloExtensions) parseExtension(typ extensionType, ext []byte, isClient bool) error {
+	buf := streambuf.NewFixed(ext)
+
+	switch typ {
+	case extensionServerName:
+		if len(ext) == 0 {
+			// server acknowledges SNI with an empty extension
+			return nil
+		}
+		list, err := collectList16(buf)
+		if err != nil {
+			return err
+		}
+		for len(list) >= 3 {
+			nameType := list[0]
+			nameLen := int(binary.BigEndian.Uint16(list[1:]))
+			if len(list) < 3+nameLen {
+				return errTruncated
+			}
+			if nameType == 0 {
+				e.serverName = append(e.serverName, string(list[3:3+nameLen]))
+			}
+			list = list[3+nameLen:]
+		}
+
+	case extensionALPN:
+		list, err := collectList16(buf)
+		if err != nil {
+			return err
+		}
+		for len(list) > 0 {
+			protoLen := int(list[0])
+			if len(list) < 1+protoLen {
+				return errTruncated
+			}
+			e.alpn = append(e.alpn, string(list[1:1+protoLen]))
+			list = list[1+protoLen:]
+		}
+
+	case extensionSupportedVersions:
+		var list []byte
+		if isClient {
+			length, _ := buf.ReadNetUint8()
+			tmp, err := buf.Collect(int(length))
+			if err != nil {
+				return err
+			}
+			list = tmp
+		} else {
+			list = ext
+		}
+		for ; len(list) >= 2; list = list[2:] {
+			e.supportedVersions = append(e.supportedVersions, tlsVersion{list[0], list[1]})
+		}
+
+	case extensionSupportedGroups:
+		list, err := collectList16(buf)
+		if err != nil {
+			return err
+		}
+		for ; len(list) >= 2; list = list[2:] {
+			e.supportedGroups = append(e.supportedGroups, namedGroup(binary.BigEndian.Uint16(list)))
+		}
+
+	case extensionSignatureAlgorithms:
+		list, err := collectList16(buf)
+		if err != nil {
+			return err
+		}
+		for ; len(list) >= 2; list = list[2:] {
+			e.signatureAlgorithms = append(e.signatureAlgorithms,
+				signatureScheme(binary.BigEndian.Uint16(list)))
+		}
+
+	case extensionPointsFormats:
+		length, _ := buf.ReadNetUint8()
+		list, err := buf.Collect(int(length))
+		if err != nil {
+			return err
+		}
+		for _, f := range list {
+			e.pointsFormats = append(e.pointsFormats, pointsFormat(f))
+		}
+
+	case extensionSessionTicket:
+		e.sessionTicket = true
+		e.sessionTicketLen = len(ext)
+	}
+
+	return nil
+}
+
+func (e *helloExtensions) has(typ extensionType) bool {
+	for _, t := range e.types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// toMap reports the parsed extensions. Extensions without parser are listed by
+// name in `_unparsed_`.
+func (e *helloExtensions) toMap() common.MapStr {
+	m := common.MapStr{}
+
+	var unparsed []string
+	for _, typ := range e.types {
+		switch typ {
+		case extensionServerName:
+			if len(e.serverName) > 0 {
+				m[typ.String()] = e.serverName
+			}
+		case extensionALPN:
+			m[typ.String()] = e.alpn
+		case extensionSupportedVersions:
+			m[typ.String()] = versionNames(e.supportedVersions)
+		case extensionSupportedGroups:
+			m[typ.String()] = stringers(len(e.supportedGroups), func(i int) string {
+				return e.supportedGroups[i].String()
+			})
+		case extensionSignatureAlgorithms:
+			m[typ.String()] = stringers(len(e.signatureAlgorithms), func(i int) string {
+				return e.signatureAlgorithms[i].String()
+			})
+		case extensionPointsFormats:
+			m[typ.String()] = stringers(len(e.pointsFormats), func(i int) string {
+				return e.pointsFormats[i].String()
+			})
+		case extensionSessionTicket:
+			m[typ.String()] = common.MapStr{
+				"length": e.sessionTicketLen,
+			}
+		default:
+			unparsed = append(unparsed, typ.String())
+		}
+	}
+
+	if len(unparsed) > 0 {
+		m["_unparsed_"] = unparsed
+	}
+	return m
+}
+
+func collectList16(buf *streambuf.Buffer) ([]byte, error) {
+	length, err := buf.ReadNetUint16()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Collect(int(length))
+}
+
+func versionNames(versions []tlsVersion) []string {
+	return stringers(len(versions), func(i int) string {
+		return versions[i].String()
+	})
+}
+
+func stringers(n int, f func(int) string) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = f(i)
+	}
+	return names
+}
@@ -0,0 +1,313 @@
+package tls
+
+import (
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/elastic/beats/libbeat/common/streambuf"
+)
+
+type recordType uint8
+
+type handshakeType uint8
+
+const (
+	recordTypeChangeCipherSpec recordType = 20
+	recordTypeAlert            recordType = 21
+	recordTypeHandshake        recordType = 22
+	recordTypeApplicationData  recordType = 23
+	recordTypeHeartbeat        recordType = 24
+)
+
+const (
+	handshakeTypeHelloRequest       handshakeType = 0
+	handshakeTypeClientHello        handshakeType = 1
+	handshakeTypeServerHello        handshakeType = 2
+	handshakeTypeNewSessionTicket   handshakeType = 4
+	handshakeTypeCertificate        handshakeType = 11
+	handshakeTypeServerKeyExchange  handshakeType = 12
+	handshakeTypeCertificateRequest handshakeType = 13
+	handshakeTypeServerHelloDone    handshakeType = 14
+	handshakeTypeCertificateVerify  handshakeType = 15
+	handshakeTypeClientKeyExchange  handshakeType = 16
+	handshakeTypeFinished           handshakeType = 20
+)
+
+const (
+	recordHeaderSize    = 5
+	handshakeHeaderSize = 4
+	randomSize          = 32
+
+	// maximum size of an encrypted record payload (RFC 5246, section 6.2.3)
+	maxRecordSize = (1 << 14) + 2048
+
+	// limit the amount of handshake data being buffered. Large enough to
+	// hold the certificate chains commonly seen in the wild.
+	maxHandshakeSize = 1 << 20
+)
+
+var (
+	errInvalidRecord    = errors.New("invalid TLS record")
+	errHandshakeTooLong = errors.New("TLS handshake message too long")
+	errTruncated        = errors.New("truncated TLS handshake message")
+)
+
+// parser keeps the handshake state of one direction in a TLS connection.
+type parser struct {
+	// buffered handshake data. Handshake messages can be fragmented over
+	// multiple records
+	handshake []byte
+
+	// set once ChangeCipherSpec or application data have been seen.
+	// Handshake records are encrypted from now on.
+	encrypted bool
+
+	hello         *helloMessage
+	certificates  []*x509.Certificate
+	certRequested bool
+	alerts        []alert
+	notes         []string
+}
+
+type helloMessage struct {
+	isClient    bool
+	version     tlsVersion
+	sessionID   []byte
+	ciphers     []cipherSuite
+	compression []compressionMethod
+	extensions  helloExtensions
+}
+
+type alert struct {
+	severity  alertSeverity
+	code      alertCode
+	encrypted bool
+}
+
+func (t recordType) isValid() bool {
+	return t >= recordTypeChangeCipherSpec && t <= recordTypeHeartbeat
+}
+
+// parse consumes all complete TLS records available in buf. It returns false
+// if the stream does not contain valid TLS records.
+func (p *parser) parse(buf *streambuf.Buffer) bool {
+	for buf.Avail(recordHeaderSize) {
+		header := buf.Bytes()[:recordHeaderSize]
+		typ := recordType(header[0])
+		version := tlsVersion{header[1], header[2]}
+		length := int(binary.BigEndian.Uint16(header[3:]))
+		if !typ.isValid() || version.major != 3 || length > maxRecordSize {
+			debugf("invalid TLS record header: %v", header)
+			return false
+		}
+
+		if !buf.Avail(recordHeaderSize + length) {
+			break
+		}
+
+		buf.Advance(recordHeaderSize)
+		payload, err := buf.Collect(length)
+		if err != nil {
+			return false
+		}
+
+		if err := p.parseRecord(typ, payload); err != nil {
+			debugf("failed to parse TLS record: %v", err)
+			return false
+		}
+		buf.Reset()
+	}
+	return true
+}
+
+func (p *parser) parseRecord(typ recordType, payload []byte) error {
+	switch typ {
+	case recordTypeChangeCipherSpec:
+		p.encrypted = true
+
+	case recordTypeApplicationData:
+		p.encrypted = true
+
+	case recordTypeAlert:
+		if p.encrypted || len(payload) != 2 {
+			p.alerts = append(p.alerts, alert{encrypted: true})
+			return nil
+		}
+		p.alerts = append(p.alerts, alert{
+			severity: alertSeverity(payload[0]),
+			code:     alertCode(payload[1]),
+		})
+
+	case recordTypeHandshake:
+		if p.encrypted {
+			// encrypted Finished or post handshake messages
+			return nil
+		}
+
+		p.handshake = append(p.handshake, payload...)
+		return p.parseHandshakes()
+	}
+	return nil
+}
+
+func (p *parser) parseHandshakes() error {
+	for len(p.handshake) >= handshakeHeaderSize {
+		typ := handshakeType(p.handshake[0])
+		length := int(p.handshake[1])<<16 | int(p.handshake[2])<<8 | int(p.handshake[3])
+		if length > maxHandshakeSize {
+			return errHandshakeTooLong
+		}
+
+		end := handshakeHeaderSize + length
+		if len(p.handshake) < end {
+			// wait for more records
+			return nil
+		}
+
+		msg := p.handshake[handshakeHeaderSize:end]
+		p.handshake = p.handshake[end:]
+		if err := p.parseHandshake(typ, msg); err != nil {
+			p.notes = append(p.notes, err.Error())
+		}
+	}
+
+	if len(p.handshake) == 0 {
+		p.handshake = nil
+	}
+	return nil
+}
+
+func (p *parser) parseHandshake(typ handshakeType, msg []byte) error {
+	debugf("TLS handshake message type=%v, length=%v", typ, len(msg))
+
+	switch typ {
+	case handshakeTypeClientHello:
+		hello, err := parseHello(msg, true)
+		if err != nil {
+			return fmt.Errorf("failed to parse client hello: %v", err)
+		}
+		p.hello = hello
+
+	case handshakeTypeServerHello:
+		hello, err := parseHello(msg, false)
+		if err != nil {
+			return fmt.Errorf("failed to parse server hello: %v", err)
+		}
+		p.hello = hello
+
+	case handshakeTypeCertificate:
+		certs, err := parseCertificates(msg)
+		if err != nil {
+			return fmt.Errorf("failed to parse certificates: %v", err)
+		}
+		p.certificates = certs
+
+	case handshakeTypeCertificateRequest:
+		p.certRequested = true
+	}
+	return nil
+}
+
+// parseHello parses the ClientHello and ServerHello handshake messages. Both
+// only differ in the number of cipher suites and compression methods being
+// reported.
+func parseHello(msg []byte, isClient bool) (*helloMessage, error) {
+	buf := streambuf.NewFixed(msg)
+	hello := &helloMessage{isClient: isClient}
+
+	major, _ := buf.ReadNetUint8()
+	minor, _ := buf.ReadNetUint8()
+	hello.version = tlsVersion{major, minor}
+	buf.Advance(randomSize)
+
+	sessionIDLen, _ := buf.ReadNetUint8()
+	sessionID, err := buf.Collect(int(sessionIDLen))
+	if err != nil {
+		return nil, errTruncated
+	}
+	if len(sessionID) > 0 {
+		hello.sessionID = append([]byte(nil), sessionID...)
+	}
+
+	if isClient {
+		ciphersLen, _ := buf.ReadNetUint16()
+		ciphers, err := buf.Collect(int(ciphersLen))
+		if err != nil || len(ciphers)%2 != 0 {
+			return nil, errTruncated
+		}
+		for i := 0; i < len(ciphers); i += 2 {
+			cs := cipherSuite(binary.BigEndian.Uint16(ciphers[i:]))
+			hello.ciphers = append(hello.ciphers, cs)
+		}
+
+		compressionLen, _ := buf.ReadNetUint8()
+		methods, err := buf.Collect(int(compressionLen))
+		if err != nil {
+			return nil, errTruncated
+		}
+		for _, m := range methods {
+			hello.compression = append(hello.compression, compressionMethod(m))
+		}
+	} else {
+		cs, _ := buf.ReadNetUint16()
+		method, err := buf.ReadNetUint8()
+		if err != nil {
+			return nil, errTruncated
+		}
+		hello.ciphers = []cipherSuite{cipherSuite(cs)}
+		hello.compression = []compressionMethod{compressionMethod(method)}
+	}
+
+	// extensions are optional
+	if buf.Len() == 0 {
+		return hello, nil
+	}
+
+	extensionsLen, _ := buf.ReadNetUint16()
+	extensions, err := buf.Collect(int(extensionsLen))
+	if err != nil {
+		return nil, errTruncated
+	}
+
+	if err := hello.extensions.parse(extensions, isClient); err != nil {
+		return nil, err
+	}
+	return hello, nil
+}
+
+// parseCertificates parses the certificate chain send in a Certificate
+// handshake message (TLS 1.2 and older).
+func parseCertificates(msg []byte) ([]*x509.Certificate, error) {
+	if len(msg) < 3 {
+		return nil, errTruncated
+	}
+
+	length := int(msg[0])<<16 | int(msg[1])<<8 | int(msg[2])
+	if length != len(msg)-3 {
+		return nil, errTruncated
+	}
+
+	var certs []*x509.Certificate
+	for data := msg[3:]; len(data) > 0; {
+		if len(data) < 3 {
+			return nil, errTruncated
+		}
+
+		certLen := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+		if len(data) < 3+certLen {
+			return nil, errTruncated
+		}
+
+		der := append([]byte(nil), data[3:3+certLen]...)
+		data = data[3+certLen:]
+
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
@@ -0,0 +1,489 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+
+	"github.com/elastic/beats/packetbeat/procs"
+	"github.com/elastic/beats/packetbeat/protos"
+	"github.com/elastic/beats/packetbeat/protos/applayer"
+	"github.com/elastic/beats/packetbeat/protos/tcp"
+	"github.com/elastic/beats/packetbeat/publish"
+)
+
+type stream struct {
+	applayer.Stream
+	parser parser
+
+	// number of bytes send in this direction during handshake
+	bytes uint64
+}
+
+type tlsConnectionData struct {
+	streams [2]*stream
+
+	// set once the handshake has been reported or the connection has been
+	// found not to be TLS. All further data in the connection is ignored.
+	done bool
+
+	startTime, endTime time.Time
+}
+
+// TLS protocol plugin
+type tlsPlugin struct {
+	ports                  []int
+	sendCertificates       bool
+	includeRawCertificates bool
+	includeDetailedFields  bool
+	transactionTimeout     time.Duration
+
+	results publish.Transactions
+}
+
+var (
+	debugf  = logp.MakeDebug("tls")
+	isDebug = false
+)
+
+var (
+	unmatchedHandshakes = monitoring.NewInt(nil, "tls.unmatched_handshakes")
+)
+
+func init() {
+	protos.Register("tls", New)
+}
+
+func New(
+	testMode bool,
+	results publish.Transactions,
+	cfg *common.Config,
+) (protos.Plugin, error) {
+	p := &tlsPlugin{}
+	config := defaultConfig
+	if !testMode {
+		if err := cfg.Unpack(&config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.init(results, &config); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (plugin *tlsPlugin) init(results publish.Transactions, config *tlsConfig) error {
+	plugin.setFromConfig(config)
+
+	plugin.results = results
+	isDebug = logp.IsDebug("tls")
+
+	return nil
+}
+
+func (plugin *tlsPlugin) setFromConfig(config *tlsConfig) {
+	plugin.ports = config.Ports
+	plugin.sendCertificates = config.SendCertificates
+	plugin.includeRawCertificates = config.IncludeRawCertificates
+	plugin.includeDetailedFields = config.IncludeDetailedFields
+	plugin.transactionTimeout = config.TransactionTimeout
+}
+
+func (plugin *tlsPlugin) GetPorts() []int {
+	return plugin.ports
+}
+
+func (plugin *tlsPlugin) ConnectionTimeout() time.Duration {
+	return plugin.transactionTimeout
+}
+
+func (plugin *tlsPlugin) Parse(
+	pkt *protos.Packet,
+	tcptuple *common.TCPTuple,
+	dir uint8,
+	private protos.ProtocolData,
+) protos.ProtocolData {
+	defer logp.Recover("ParseTLS exception")
+
+	conn := ensureTLSConnection(private)
+	if conn.done {
+		return conn
+	}
+
+	conn = plugin.doParse(conn, pkt, tcptuple, dir)
+	if conn == nil {
+		return nil
+	}
+	return conn
+}
+
+func ensureTLSConnection(private protos.ProtocolData) *tlsConnectionData {
+	if private == nil {
+		return &tlsConnectionData{}
+	}
+
+	priv, ok := private.(*tlsConnectionData)
+	if !ok {
+		logp.Warn("tls connection data type error, create new one")
+		return &tlsConnectionData{}
+	}
+	if priv == nil {
+		logp.Warn("Unexpected: tls connection data not set, create new one")
+		return &tlsConnectionData{}
+	}
+
+	return priv
+}
+
+func (plugin *tlsPlugin) doParse(
+	conn *tlsConnectionData,
+	pkt *protos.Packet,
+	tcptuple *common.TCPTuple,
+	dir uint8,
+) *tlsConnectionData {
+	st := conn.streams[dir]
+	if st == nil {
+		st = &stream{}
+		st.Stream.Init(tcp.TCPMaxDataInStream)
+		conn.streams[dir] = st
+		if isDebug {
+			debugf("new stream: %p (dir=%v, len=%v)", st, dir, len(pkt.Payload))
+		}
+	}
+
+	if conn.startTime.IsZero() {
+		conn.startTime = pkt.Ts
+	}
+	conn.endTime = pkt.Ts
+	st.bytes += uint64(len(pkt.Payload))
+
+	if err := st.Append(pkt.Payload); err != nil {
+		if isDebug {
+			debugf("%v, dropping TCP stream", err)
+		}
+		return nil
+	}
+
+	if ok := st.parser.parse(&st.Buf); !ok {
+		if isDebug {
+			debugf("Non-TLS message. Ignore tcp stream.")
+		}
+		plugin.publish(conn, tcptuple)
+		conn.done = true
+		return conn
+	}
+
+	if conn.handshakeCompleted() || conn.hasFatalAlert() {
+		plugin.publish(conn, tcptuple)
+	}
+	return conn
+}
+
+func (plugin *tlsPlugin) GapInStream(tcptuple *common.TCPTuple, dir uint8,
+	nbytes int, private protos.ProtocolData) (priv protos.ProtocolData, drop bool) {
+
+	conn := ensureTLSConnection(private)
+	if conn.done {
+		// handshake already reported. No need to drop the connection.
+		return conn, false
+	}
+
+	plugin.publish(conn, tcptuple)
+	return private, true
+}
+
+func (plugin *tlsPlugin) ReceivedFin(tcptuple *common.TCPTuple, dir uint8,
+	private protos.ProtocolData) protos.ProtocolData {
+
+	conn := ensureTLSConnection(private)
+	plugin.publish(conn, tcptuple)
+	return conn
+}
+
+// handshakeCompleted checks both endpoints have finished the handshake and
+// switched to the negotiated cipher. TLS 1.3 handshakes, not using the middlebox
+// compatibility mode, complete once application data records have been seen.
+func (conn *tlsConnectionData) handshakeCompleted() bool {
+	client, server := conn.endpoints()
+	return client != nil && server != nil &&
+		server.parser.hello != nil &&
+		client.parser.encrypted && server.parser.encrypted
+}
+
+func (conn *tlsConnectionData) hasFatalAlert() bool {
+	for _, st := range conn.streams {
+		if st == nil {
+			continue
+		}
+		for _, a := range st.parser.alerts {
+			if a.severity == alertSeverityFatal {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// endpoints returns the client and server streams based on the hello
+// messages seen.
+func (conn *tlsConnectionData) endpoints() (client, server *stream) {
+	for i, st := range conn.streams {
+		if st == nil || st.parser.hello == nil {
+			continue
+		}
+
+		other := conn.streams[1-i]
+		if st.parser.hello.isClient {
+			return st, other
+		}
+		return other, st
+	}
+	return nil, nil
+}
+
+// clientDirection returns the direction of the stream the ClientHello has
+// been received from.
+func (conn *tlsConnectionData) clientDirection() uint8 {
+	for i, st := range conn.streams {
+		if st == nil || st.parser.hello == nil {
+			continue
+		}
+		if st.parser.hello.isClient {
+			return uint8(i)
+		}
+		return uint8(1 - i)
+	}
+	return tcp.TCPDirectionOriginal
+}
+
+func (plugin *tlsPlugin) publish(conn *tlsConnectionData, tcptuple *common.TCPTuple) {
+	if conn.done {
+		return
+	}
+
+	client, server := conn.endpoints()
+	if client == nil && server == nil {
+		// no hello message seen. Nothing to report.
+		if conn.streams[0] != nil || conn.streams[1] != nil {
+			unmatchedHandshakes.Add(1)
+		}
+		return
+	}
+
+	if plugin.results != nil {
+		event := plugin.createEvent(conn, tcptuple, client, server)
+		plugin.results.PublishTransaction(event)
+	}
+
+	// free handshake state, not required anymore
+	conn.done = true
+	conn.streams = [2]*stream{}
+}
+
+func (plugin *tlsPlugin) createEvent(
+	conn *tlsConnectionData,
+	tcptuple *common.TCPTuple,
+	client, server *stream,
+) common.MapStr {
+	var (
+		trans     applayer.Transaction
+		notes     []string
+		fatal     bool
+		completed = conn.handshakeCompleted()
+	)
+
+	cmdlineTuple := procs.ProcWatcher.FindProcessesTuple(tcptuple.IPPort(), procs.TransportTCP)
+	trans.Init("tls", *tcptuple.IPPort(), applayer.TransportTCP,
+		applayer.NetDirection(conn.clientDirection()),
+		conn.startTime, cmdlineTuple, nil)
+	trans.ResponseTime = int32(conn.endTime.Sub(conn.startTime).Nanoseconds() / 1e6)
+
+	tls := common.MapStr{
+		"handshake_completed": completed,
+	}
+
+	var clientHello, serverHello *helloMessage
+	if client != nil {
+		trans.BytesOut = client.bytes
+		clientHello = client.parser.hello
+		notes = append(notes, client.parser.notes...)
+	}
+	if server != nil {
+		trans.BytesIn = server.bytes
+		serverHello = server.parser.hello
+		notes = append(notes, server.parser.notes...)
+	}
+
+	if clientHello != nil {
+		tls["client_hello"] = plugin.helloFields(clientHello)
+		if ja3 := ja3Fingerprint(clientHello); ja3 != "" {
+			hash := md5.Sum([]byte(ja3))
+			tls["fingerprints"] = common.MapStr{
+				"ja3": common.MapStr{
+					"str":  ja3,
+					"hash": hex.EncodeToString(hash[:]),
+				},
+			}
+		}
+	}
+	if serverHello != nil {
+		tls["server_hello"] = plugin.helloFields(serverHello)
+		tls["version"] = negotiatedVersion(serverHello).String()
+		tls["resumed"] = isResumed(clientHello, serverHello, server, completed)
+	}
+
+	var alerts []common.MapStr
+	for _, st := range []struct {
+		name string
+		st   *stream
+	}{{"client", client}, {"server", server}} {
+		if st.st == nil {
+			continue
+		}
+
+		parser := &st.st.parser
+		if plugin.sendCertificates && len(parser.certificates) > 0 {
+			tls[st.name+"_certificate"] = certToMap(parser.certificates[0], plugin.includeRawCertificates)
+			if len(parser.certificates) > 1 {
+				tls[st.name+"_certificate_chain"] = certChainToList(
+					parser.certificates[1:], plugin.includeRawCertificates)
+			}
+		}
+
+		for _, a := range parser.alerts {
+			alert := common.MapStr{"source": st.name}
+			if a.encrypted {
+				alert["type"] = "encrypted"
+			} else {
+				alert["severity"] = a.severity.String()
+				alert["code"] = a.code
+				alert["type"] = a.code.String()
+				fatal = fatal || a.severity == alertSeverityFatal
+			}
+			alerts = append(alerts, alert)
+		}
+	}
+	if server != nil && server.parser.certRequested {
+		tls["client_certificate_requested"] = true
+	}
+	if len(alerts) > 0 {
+		tls["alerts"] = alerts
+	}
+
+	switch {
+	case fatal:
+		trans.Status = common.ERROR_STATUS
+	case !completed:
+		trans.Status = common.ERROR_STATUS
+		notes = append(notes, "TLS handshake not completed")
+	default:
+		trans.Status = common.OK_STATUS
+	}
+	trans.Notes = notes
+
+	event := common.MapStr{}
+	trans.Event(event)
+	event["tls"] = tls
+	return event
+}
+
+func (plugin *tlsPlugin) helloFields(hello *helloMessage) common.MapStr {
+	fields := common.MapStr{
+		"version": strconv.Itoa(int(hello.version.major)) + "." + strconv.Itoa(int(hello.version.minor)),
+	}
+	if len(hello.sessionID) > 0 {
+		fields["session_id"] = hex.EncodeToString(hello.sessionID)
+	}
+
+	if hello.isClient {
+		if plugin.includeDetailedFields {
+			fields["supported_ciphers"] = stringers(len(hello.ciphers), func(i int) string {
+				return hello.ciphers[i].String()
+			})
+			fields["supported_compression_methods"] = stringers(len(hello.compression), func(i int) string {
+				return hello.compression[i].String()
+			})
+		}
+	} else {
+		if len(hello.ciphers) > 0 {
+			fields["selected_cipher"] = hello.ciphers[0].String()
+		}
+		if len(hello.compression) > 0 {
+			fields["selected_compression_method"] = hello.compression[0].String()
+		}
+	}
+
+	extensions := hello.extensions.toMap()
+	if !plugin.includeDetailedFields {
+		// only keep the most relevant extensions
+		tmp := common.MapStr{}
+		for _, typ := range []extensionType{extensionServerName, extensionALPN, extensionSupportedVersions} {
+			if v, found := extensions[typ.String()]; found {
+				tmp[typ.String()] = v
+			}
+		}
+		extensions = tmp
+	}
+	if len(extensions) > 0 {
+		fields["extensions"] = extensions
+	}
+	return fields
+}
+
+// negotiatedVersion returns the protocol version selected by the server. TLS
+// 1.3 servers report the version in the supported_versions extension.
+func negotiatedVersion(serverHello *helloMessage) tlsVersion {
+	if versions := serverHello.extensions.supportedVersions; len(versions) > 0 {
+		return versions[0]
+	}
+	return serverHello.version
+}
+
+func isResumed(clientHello, serverHello *helloMessage, server *stream, completed bool) bool {
+	if negotiatedVersion(serverHello).code() >= 0x0304 {
+		// TLS 1.3 servers echo the legacy session ID. Only PSK based handshakes
+		// resume a session.
+		return serverHello.extensions.has(extensionPreSharedKey)
+	}
+	if clientHello == nil {
+		return false
+	}
+	if len(serverHello.sessionID) > 0 && bytes.Equal(clientHello.sessionID, serverHello.sessionID) {
+		return true
+	}
+
+	// session ticket based resumption skips the server certificate
+	return completed && clientHello.extensions.sessionTicketLen > 0 &&
+		len(server.parser.certificates) == 0
+}
+
+// ja3Fingerprint builds the JA3 string of a ClientHello, used to identify TLS
+// client implementations. GREASE values are ignored.
+func ja3Fingerprint(hello *helloMessage) string {
+	join := func(n int, get func(int) uint16) string {
+		values := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			if v := get(i); !isGREASE(v) {
+				values = append(values, strconv.Itoa(int(v)))
+			}
+		}
+		return strings.Join(values, "-")
+	}
+
+	ext := &hello.extensions
+	return strings.Join([]string{
+		strconv.Itoa(int(hello.version.code())),
+		join(len(hello.ciphers), func(i int) uint16 { return uint16(hello.ciphers[i]) }),
+		join(len(ext.types), func(i int) uint16 { return uint16(ext.types[i]) }),
+		join(len(ext.supportedGroups), func(i int) uint16 { return uint16(ext.supportedGroups[i]) }),
+		join(len(ext.pointsFormats), func(i int) uint16 { return uint16(ext.pointsFormats[i]) }),
+	}, ",")
+}
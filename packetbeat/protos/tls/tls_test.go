@@ -0,0 +1,473 @@
+// +build !integration
+
+package tls
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/protos"
+	"github.com/elastic/beats/packetbeat/protos/tcp"
+	"github.com/elastic/beats/packetbeat/publish"
+)
+
+type testPacket struct {
+	dir  uint8
+	data string
+}
+
+// TLS 1.2 handshake with an ECDSA server certificate, SNI and ALPN,
+// followed by application data in both directions.
+var tls12Handshake = []testPacket{
+	{
+		dir: tcp.TCPDirectionOriginal,
+		data: "16030100e6010000e2030384b5c071557f5f9500a9473e6b0557d5c67422393f" +
+			"7e8b2787feaacc13172a0120c47d22f1374b866e40952d71f28186838e7c91bf" +
+			"a60b3ef0c24896c2b22237120006c02bc030cca90100009300000010000e0000" +
+			"0b6578616d706c652e636f6d000b00020100ff01000100001700000012000000" +
+			"0500050100000000000a000a0008001d001700180019000d001a001808040403" +
+			"08070805080604010501060105030603020102030032001a0018080404030807" +
+			"0805080604010501060105030603020102030010000e000c0268320868747470" +
+			"2f312e31002b0003020303",
+	},
+	{
+		dir: tcp.TCPDirectionReverse,
+		data: "160303004802000044030386c0036ef7c876d52e4ee5c6ae286a77965be248f0" +
+			"329a1b1fc6d99d6a55256300c02b00001cff0100010000170000001000050003" +
+			"026832000b000201000000000016030301c00b0001bc0001b90001b6308201b2" +
+			"30820158a00302010202021092300a06082a8648ce3d0403023039310b300906" +
+			"035504061302555331143012060355040a130b4578616d706c65204f72673114" +
+			"30120603550403130b6578616d706c652e636f6d301e170d3137303130313030" +
+			"303030305a170d3237303130313030303030305a3039310b3009060355040613" +
+			"02555331143012060355040a130b4578616d706c65204f726731143012060355" +
+			"0403130b6578616d706c652e636f6d3059301306072a8648ce3d020106082a86" +
+			"48ce3d03010703420004baad1df0ee0cb9c27a9859dec30f40b46e526bae1879" +
+			"f3054c0a9a19ade7373bb3e37022ff0f98bfcf72c9ff80b53eda6528830545bd" +
+			"a225853441516f1baa51a350304e300e0603551d0f0101ff0404030207803013" +
+			"0603551d25040c300a06082b0601050507030130270603551d110420301e820b" +
+			"6578616d706c652e636f6d820f7777772e6578616d706c652e636f6d300a0608" +
+			"2a8648ce3d04030203480030450221009f35224b2d1f81a624fa51bb25666869" +
+			"8123f341a36f9e30f94c8529f5eaa4f9022001cca2d8daa7d8339435c45c9dd6" +
+			"3da099530eb75e4dfe3c1499146a0363146416030300720c00006e03001d2047" +
+			"ae822a25108a3f376c300a0d859869a0c99e0639b0d20e7dc8af0160e69b6604" +
+			"030046304402205a000a41ec7398dc05c6e3f0803ef14e5f0c46a0716cab0a04" +
+			"09b8176b18b62b022060637f017a37a39850b7e8dea930ef3439accab52ae894" +
+			"e47f5f5f91f46f8b2f16030300040e000000",
+	},
+	{
+		dir: tcp.TCPDirectionOriginal,
+		data: "160303002510000021209ba7e94f17ecc40ebd489c2533eba435e9dc9c8aea29" +
+			"491843435b5016f221141403030001011603030028000000000000000018e669" +
+			"dde1d412672acdfb27d2050be1df9cbfbada6976dcf376aaab5c25b959",
+	},
+	{
+		dir: tcp.TCPDirectionReverse,
+		data: "140303000101160303002800000000000000001279e11ca63eefcc3028ce950b" +
+			"f4dbef8e83dd8fc642b704c05ae9de5857c0e0",
+	},
+	{
+		dir: tcp.TCPDirectionOriginal,
+		data: "170303002a000000000000000199b81385e62f4c40f555fd859dcbe86f06372d" +
+			"81f7be68994fd7c483711e1ff64d02",
+	},
+	{
+		dir: tcp.TCPDirectionReverse,
+		data: "170303002b00000000000000012bae81ced53dd66be738b52f6d9f0f0aba25bb" +
+			"6347f503f260158c5cc4d3161fc15f0a",
+	},
+}
+
+// TLS 1.3 handshake using the middlebox compatibility mode.
+var tls13Handshake = []testPacket{
+	{
+		dir: tcp.TCPDirectionOriginal,
+		data: "1603010124010001200303ebe3fa0f8424ba6f85c6c7c50bcfaebc4853d50672" +
+			"cf79e6dbe838f7c9850fb6203f1daa39e064edf6fefdb5894def550bf38fbd8c" +
+			"29db04e86828e054b9a9b5b2000cc02bc030cca9130113021303010000cb0000" +
+			"0010000e00000b6578616d706c652e636f6d000b00020100ff01000100001700" +
+			"0000120000000500050100000000000a000a0008001d001700180019000d0020" +
+			"001e090409050906080404030807080508060401050106010503060302010203" +
+			"00320020001e0904090509060804040308070805080604010501060105030603" +
+			"020102030010000e000c02683208687474702f312e31002b0005040304030300" +
+			"3300260024001d002007a0711aeb67c0930f1a7e90d802b86df026a31eb0d4a3" +
+			"b183bb327852901954",
+	},
+	{
+		dir: tcp.TCPDirectionReverse,
+		data: "160303007a020000760303214f311b87d9744fd2aff25993f6374292e1477929" +
+			"1daf3b71ade92d02551a92203f1daa39e064edf6fefdb5894def550bf38fbd8c" +
+			"29db04e86828e054b9a9b5b2130100002e002b0002030400330024001d002068" +
+			"4494a750e2fba984c71a70de6a33a6be877e895fa65f815c309a51e783885f14" +
+			"03030001011703030024580b88eee4cf35528493339bc165a2247283d2fbcff4" +
+			"88eb6ea6bdc6998bbaed5815440517030301d3b1c1262afc6eec824130530d99" +
+			"95571368daef3eed22435a048e3e24238939f7117f22795ee45ffecd76569155" +
+			"bde247deb87e6229aad3b443dcbf52cafaec049e650337fe8189789d7d92dfec" +
+			"cb28efdd5316ceb316397568cd18b6dd38633f750b51039ede1f6d9cc2263e18" +
+			"e1570b00aa76b93d113cef28772c473f8b5125730014072697ade1ae4f7e93b1" +
+			"29268fb4c6423b71dcf2864daab59277c6ece1597f7c6906b7bfaf92bbf4a17b" +
+			"c28704a29d0b9ccb7725be8ae9640f1197bd4940981708d4a01c45ecc2ae6704" +
+			"ecb9ff0116a22d458d88df7529d0b0cb3ae6dc92360f4bcea859abe130f5bcf8" +
+			"a7ee3a96826cdfb24f427c12fd12c82f974243a4c1a6a2970d55683c7a3f79c0" +
+			"1469cca455791dc339e3e38d8f03ed0e7422c8bbad7c13b79f5b9aa583e97f5e" +
+			"f1da3a0c77d56c13bc8bc09dd795d38fe15c777dc3cd6c80dbee472614b6ef50" +
+			"d45d8db31dae058860eeb13b80dd5b6a557043f2e7ac1ec76c345a2b4db35d66" +
+			"51abccd7f1d3bb8ea29f4b1e6ab26b4de0fe4ee058208c3fde73bb7eec930cc6" +
+			"5c05cd3488adb597bdd70a5fe2bdedfeb9bb6154d80cea31a81c9b192ceaced3" +
+			"7d6230161dce6b500219a952d6f811dfc913abdb4e692a31539ab99df32fa310" +
+			"25ff39392e1f1703030060c959807f73265597ff56b073a1b3fad40b4cddb392" +
+			"a9878d087909bfdff31b801980dc4bd427a030bf22edc69e4ab66107ae3462a3" +
+			"504664c6f3d4d2837d2d15fe05c998aaeb0fcae7f722b9f12faba654c8c11510" +
+			"187f29529b644d28debb17170303003588c08eaa78d052a63c27f9df0fd80f4f" +
+			"2dc7e6b46c4efde2aa8fad78948a25f5759b4a047f94c3384594e9eec3f20606" +
+			"7bd2c05b4e",
+	},
+	{
+		dir: tcp.TCPDirectionOriginal,
+		data: "140303000101170303003553b624727b43224e2b9b48fc59ef647a47c0cad6d5" +
+			"2c3da2993151c4cb33fe65ac0566ed6cf0223682c46e6e1f3ed8835101340800",
+	},
+	{
+		dir: tcp.TCPDirectionOriginal,
+		data: "17030300230f8da42f377423a4a1b87ec26edd45b331345c9607ae3feb6d6fcd" +
+			"95cea1f5d13fba71",
+	},
+	{
+		dir: tcp.TCPDirectionReverse,
+		data: "17030300243af61ef65fabe820bc607605bdc21161a39a1336b3f9b689d8fec2" +
+			"eca9a6882e5305c2e3",
+	},
+}
+
+// TLS 1.2 handshake aborted by the client with a fatal bad_certificate
+// alert.
+var tlsAlertHandshake = []testPacket{
+	{
+		dir: tcp.TCPDirectionOriginal,
+		data: "16030100e2010000de03038b14622d04323d54eacc2d92fedb64f2d5d7ab4844" +
+			"195b520b9051d1a3d1e9132090223e95f0d16d113367a50511c0299154084df2" +
+			"ddb476001d0ff3d3a6634c7b0014c02bc02fc02cc030cca9cca8c009c013c00a" +
+			"c0140100008100000010000e00000b6578616d706c652e636f6d000b00020100" +
+			"ff010001000017000000120000000500050100000000000a000a0008001d0017" +
+			"00180019000d001a001808040403080708050806040105010601050306030201" +
+			"02030032001a0018080404030807080508060401050106010503060302010203" +
+			"002b0003020303",
+	},
+	{
+		dir: tcp.TCPDirectionReverse,
+		data: "160303003f0200003b0303d38dea973e7fcd659caa7739fb3b7890aa96686031" +
+			"267eb89cbb7aef278ca46100c02b000013ff0100010000170000000b00020100" +
+			"0000000016030301c00b0001bc0001b90001b6308201b230820158a003020102" +
+			"02021092300a06082a8648ce3d0403023039310b300906035504061302555331" +
+			"143012060355040a130b4578616d706c65204f7267311430120603550403130b" +
+			"6578616d706c652e636f6d301e170d3137303130313030303030305a170d3237" +
+			"303130313030303030305a3039310b3009060355040613025553311430120603" +
+			"55040a130b4578616d706c65204f7267311430120603550403130b6578616d70" +
+			"6c652e636f6d3059301306072a8648ce3d020106082a8648ce3d030107034200" +
+			"04d3d7675b7e73777ef384dc315c97030f4f01e364bbb8860b2db36f1f0711c9" +
+			"fc3c9c64930e89649cb1c996707ded0c013934d30bad29b34d54535d93f33022" +
+			"45a350304e300e0603551d0f0101ff04040302078030130603551d25040c300a" +
+			"06082b0601050507030130270603551d110420301e820b6578616d706c652e63" +
+			"6f6d820f7777772e6578616d706c652e636f6d300a06082a8648ce3d04030203" +
+			"4800304502201d767ec7796d61cca70ef5ab96af48e00b1c10bd5141a08846b7" +
+			"f605448076670221008109957f508c1bb492e1528cc3f3d20c7cb704e2343c78" +
+			"ae27a92b92f869552116030300740c00007003001d200ab9445c34b0c7e5a9d8" +
+			"635c5189a1ecb1b3c5ad182809129c0cf351692a9f12040300483046022100b9" +
+			"c7b4fc4314a31ec7b633b57b541a1d79613bc562118deadee48e4e6c48696402" +
+			"2100b5d66299c66ab4cf6315a60926cc1fcd7546fcf4ccb9023c702eb4a24a60" +
+			"51ee16030300040e000000",
+	},
+	{
+		dir:  tcp.TCPDirectionOriginal,
+		data: "1503030002022a",
+	},
+}
+
+func tlsModForTests(config tlsConfig) *tlsPlugin {
+	var tls tlsPlugin
+	results := &publish.ChanTransactions{Channel: make(chan common.MapStr, 10)}
+	tls.init(results, &config)
+	return &tls
+}
+
+func testTCPTuple() *common.TCPTuple {
+	t := &common.TCPTuple{
+		IPLength: 4,
+		SrcIP:    net.IPv4(192, 168, 0, 1), DstIP: net.IPv4(192, 168, 0, 2),
+		SrcPort: 6512, DstPort: 443,
+	}
+	t.ComputeHashebles()
+	return t
+}
+
+// replay feeds the packets into the plugin, one millisecond apart.
+func replay(t *testing.T, tls *tlsPlugin, packets []testPacket) protos.ProtocolData {
+	tcptuple := testTCPTuple()
+	ts := time.Now()
+
+	var private protos.ProtocolData
+	for i, p := range packets {
+		payload, err := hex.DecodeString(p.data)
+		if err != nil {
+			t.Fatalf("invalid test packet %v: %v", i, err)
+		}
+
+		pkt := &protos.Packet{
+			Ts:      ts.Add(time.Duration(i) * time.Millisecond),
+			Tuple:   *tcptuple.IPPort(),
+			Payload: payload,
+		}
+		private = tls.Parse(pkt, tcptuple, p.dir, private)
+	}
+	return private
+}
+
+// Helper function to read from the Publisher Queue
+func expectTransaction(t *testing.T, tls *tlsPlugin) common.MapStr {
+	client := tls.results.(*publish.ChanTransactions)
+	select {
+	case trans := <-client.Channel:
+		return trans
+	default:
+		t.Error("No transaction")
+	}
+	return nil
+}
+
+func expectNoTransaction(t *testing.T, tls *tlsPlugin) {
+	client := tls.results.(*publish.ChanTransactions)
+	select {
+	case trans := <-client.Channel:
+		t.Errorf("Unexpected transaction: %v", trans)
+	default:
+	}
+}
+
+func TestTLS12Handshake(t *testing.T) {
+	if testing.Verbose() {
+		logp.LogInit(logp.LOG_DEBUG, "", false, true, []string{"tls"})
+	}
+
+	tls := tlsModForTests(defaultConfig)
+	private := replay(t, tls, tls12Handshake)
+	assert.True(t, private.(*tlsConnectionData).done)
+
+	event := expectTransaction(t, tls)
+	expectNoTransaction(t, tls)
+	assert.Equal(t, "tls", event["type"])
+	assert.Equal(t, common.OK_STATUS, event["status"])
+	assert.Equal(t, uint64(328), event["bytes_out"])
+	assert.Equal(t, uint64(709), event["bytes_in"])
+
+	src := event["src"].(*common.Endpoint)
+	assert.Equal(t, 6512, int(src.Port))
+
+	expected := map[string]interface{}{
+		"tls.handshake_completed":  true,
+		"tls.version":              "TLS 1.2",
+		"tls.resumed":              false,
+		"tls.client_hello.version": "3.3",
+		"tls.client_hello.extensions.server_name_indication":                 []string{"example.com"},
+		"tls.client_hello.extensions.application_layer_protocol_negotiation": []string{"h2", "http/1.1"},
+		"tls.client_hello.extensions.supported_groups": []string{
+			"x25519", "secp256r1", "secp384r1", "secp521r1",
+		},
+		"tls.client_hello.supported_ciphers": []string{
+			"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+			"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+			"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+		},
+		"tls.server_hello.selected_cipher":                                   "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+		"tls.server_hello.selected_compression_method":                       "NULL",
+		"tls.server_hello.extensions.application_layer_protocol_negotiation": []string{"h2"},
+		"tls.server_certificate.subject.common_name":                         "example.com",
+		"tls.server_certificate.subject.organization":                        "Example Org",
+		"tls.server_certificate.issuer.country":                              "US",
+		"tls.server_certificate.serial_number":                               "4242",
+		"tls.server_certificate.public_key_algorithm":                        "ECDSA",
+		"tls.server_certificate.public_key_size":                             256,
+		"tls.server_certificate.alternative_names":                           []string{"example.com", "www.example.com"},
+		"tls.fingerprints.ja3.str":                                           "771,49195-49200-52393,0-11-65281-23-18-5-10-13-50-16-43,29-23-24-25,0",
+		"tls.fingerprints.ja3.hash":                                          "6172967d81e5e44e0e8c0eea4a48aeda",
+	}
+	for field, value := range expected {
+		actual, err := event.GetValue(field)
+		assert.NoError(t, err, field)
+		assert.Equal(t, value, actual, field)
+	}
+
+	for _, field := range []string{
+		"tls.client_certificate",
+		"tls.server_certificate_chain",
+		"tls.client_certificate_requested",
+		"tls.alerts",
+		"tls.server_certificate.raw",
+	} {
+		_, err := event.GetValue(field)
+		assert.Error(t, err, field)
+	}
+}
+
+func TestTLS13Handshake(t *testing.T) {
+	tls := tlsModForTests(defaultConfig)
+	replay(t, tls, tls13Handshake)
+
+	event := expectTransaction(t, tls)
+	assert.Equal(t, common.OK_STATUS, event["status"])
+
+	expected := map[string]interface{}{
+		"tls.handshake_completed": true,
+		"tls.version":             "TLS 1.3",
+		"tls.resumed":             false,
+		"tls.client_hello.extensions.supported_versions": []string{"TLS 1.3", "TLS 1.2"},
+		"tls.server_hello.extensions.supported_versions": []string{"TLS 1.3"},
+		"tls.server_hello.selected_cipher":               "TLS_AES_128_GCM_SHA256",
+	}
+	for field, value := range expected {
+		actual, err := event.GetValue(field)
+		assert.NoError(t, err, field)
+		assert.Equal(t, value, actual, field)
+	}
+
+	// certificates are encrypted in TLS 1.3
+	_, err := event.GetValue("tls.server_certificate")
+	assert.Error(t, err)
+}
+
+func TestFatalAlert(t *testing.T) {
+	tls := tlsModForTests(defaultConfig)
+	replay(t, tls, tlsAlertHandshake)
+
+	event := expectTransaction(t, tls)
+	assert.Equal(t, common.ERROR_STATUS, event["status"])
+
+	tlsFields := event["tls"].(common.MapStr)
+	assert.Equal(t, false, tlsFields["handshake_completed"])
+	assert.Equal(t, []common.MapStr{{
+		"source":   "client",
+		"severity": "fatal",
+		"code":     alertCode(42),
+		"type":     "bad_certificate",
+	}}, tlsFields["alerts"])
+}
+
+func TestFragmentedRecords(t *testing.T) {
+	// split every packet in the middle of a record
+	var packets []testPacket
+	for _, p := range tls12Handshake {
+		mid := len(p.data) / 4 * 2
+		packets = append(packets,
+			testPacket{dir: p.dir, data: p.data[:mid]},
+			testPacket{dir: p.dir, data: p.data[mid:]})
+	}
+
+	tls := tlsModForTests(defaultConfig)
+	replay(t, tls, packets)
+
+	event := expectTransaction(t, tls)
+	value, err := event.GetValue("tls.handshake_completed")
+	assert.NoError(t, err)
+	assert.Equal(t, true, value)
+
+	value, err = event.GetValue("tls.server_certificate.subject.common_name")
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", value)
+}
+
+func TestConnectionClosedDuringHandshake(t *testing.T) {
+	tls := tlsModForTests(defaultConfig)
+	private := replay(t, tls, tls12Handshake[:1])
+	expectNoTransaction(t, tls)
+
+	tls.ReceivedFin(testTCPTuple(), tcp.TCPDirectionOriginal, private)
+	event := expectTransaction(t, tls)
+	assert.Equal(t, common.ERROR_STATUS, event["status"])
+	assert.Equal(t, []string{"TLS handshake not completed"}, event["notes"])
+
+	tlsFields := event["tls"].(common.MapStr)
+	assert.Equal(t, false, tlsFields["handshake_completed"])
+	assert.Contains(t, tlsFields, "client_hello")
+	assert.NotContains(t, tlsFields, "server_hello")
+	assert.NotContains(t, tlsFields, "version")
+}
+
+func TestNonTLSTraffic(t *testing.T) {
+	tls := tlsModForTests(defaultConfig)
+	private := replay(t, tls, []testPacket{{
+		dir:  tcp.TCPDirectionOriginal,
+		data: hex.EncodeToString([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")),
+	}})
+
+	assert.True(t, private.(*tlsConnectionData).done)
+	expectNoTransaction(t, tls)
+}
+
+func TestDetailedFieldsDisabled(t *testing.T) {
+	config := defaultConfig
+	config.IncludeDetailedFields = false
+	config.IncludeRawCertificates = true
+	tls := tlsModForTests(config)
+	replay(t, tls, tls12Handshake)
+
+	event := expectTransaction(t, tls)
+	hello, err := event.GetValue("tls.client_hello")
+	assert.NoError(t, err)
+	assert.NotContains(t, hello, "supported_ciphers")
+	assert.Equal(t, common.MapStr{
+		"server_name_indication":                 []string{"example.com"},
+		"application_layer_protocol_negotiation": []string{"h2", "http/1.1"},
+		"supported_versions":                     []string{"TLS 1.2"},
+	}, hello.(common.MapStr)["extensions"])
+
+	raw, err := event.GetValue("tls.server_certificate.raw")
+	assert.NoError(t, err)
+	assert.Contains(t, raw, "-----BEGIN CERTIFICATE-----")
+}
+
+func TestCertificatesDisabled(t *testing.T) {
+	config := defaultConfig
+	config.SendCertificates = false
+	tls := tlsModForTests(config)
+	replay(t, tls, tls12Handshake)
+
+	event := expectTransaction(t, tls)
+	_, err := event.GetValue("tls.server_certificate")
+	assert.Error(t, err)
+}
+
+func TestJA3IgnoresGREASE(t *testing.T) {
+	hello := &helloMessage{
+		isClient: true,
+		version:  tlsVersion{3, 3},
+		ciphers:  []cipherSuite{0x0a0a, 0x1301, 0xc02b},
+		extensions: helloExtensions{
+			types:           []extensionType{0x1a1a, 0, 10, 11},
+			supportedGroups: []namedGroup{0x2a2a, 29, 23},
+			pointsFormats:   []pointsFormat{0},
+		},
+	}
+	assert.Equal(t, "771,4865-49195,0-10-11,29-23,0", ja3Fingerprint(hello))
+}
+
+func TestVersionNames(t *testing.T) {
+	tests := []struct {
+		version  tlsVersion
+		expected string
+	}{
+		{tlsVersion{3, 0}, "SSL 3.0"},
+		{tlsVersion{3, 1}, "TLS 1.0"},
+		{tlsVersion{3, 3}, "TLS 1.2"},
+		{tlsVersion{3, 4}, "TLS 1.3"},
+		{tlsVersion{0x7f, 28}, "TLS 1.3 (draft 28)"},
+		{tlsVersion{0x3a, 0x3a}, "(GREASE)"},
+		{tlsVersion{2, 0}, "(unknown:0x0200)"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.version.String())
+	}
+}
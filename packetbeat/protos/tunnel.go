@@ -0,0 +1,19 @@
+package protos
+
+import (
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/packetbeat/flows"
+)
+
+// AddTunnel adds the tunnel the packets of a transaction are encapsulated in
+// to its event, with the same fields as the flow events. Nothing is added
+// for the packets not encapsulated.
+func AddTunnel(event common.MapStr, tunnel common.TunnelID) {
+	if tunnel.Type == 0 {
+		return
+	}
+	event["tunnel"] = common.MapStr{
+		"type": flows.TunnelType(tunnel.Type).String(),
+		"id":   tunnel.ID,
+	}
+}
@@ -13,6 +13,7 @@ import (
 type UDP struct {
 	protocols protos.Protocols
 	portMap   map[uint16]protos.Protocol
+	dynamic   []protos.DynamicUDPPlugin
 }
 
 type Processor interface {
@@ -43,6 +44,10 @@ func (udp *UDP) decideProtocol(tuple *common.IPPortTuple) protos.Protocol {
 func (udp *UDP) Process(id *flows.FlowID, pkt *protos.Packet) {
 	protocol := udp.decideProtocol(&pkt.Tuple)
 	if protocol == protos.UnknownProtocol {
+		if plugin := udp.expectingPlugin(&pkt.Tuple); plugin != nil && len(pkt.Payload) > 0 {
+			plugin.ParseUDP(pkt)
+			return
+		}
 		logp.Debug("udp", "unknown protocol")
 		return
 	}
@@ -60,6 +65,17 @@ func (udp *UDP) Process(id *flows.FlowID, pkt *protos.Packet) {
 	}
 }
 
+// expectingPlugin returns the plugin following the stream of a packet on a
+// dynamic port, or nil.
+func (udp *UDP) expectingPlugin(tuple *common.IPPortTuple) protos.UDPPlugin {
+	for _, plugin := range udp.dynamic {
+		if plugin.ExpectsUDP(tuple) {
+			return plugin
+		}
+	}
+	return nil
+}
+
 // buildPortsMap creates a mapping of port numbers to protocol identifiers. If
 // any two UdpProtocolPlugins operate on the same port number then an error
 // will be returned.
@@ -91,6 +107,11 @@ func NewUDP(p protos.Protocols) (*UDP, error) {
 	}
 
 	udp := &UDP{protocols: p, portMap: portMap}
+	for _, plugin := range p.GetAllUDP() {
+		if dynamic, ok := plugin.(protos.DynamicUDPPlugin); ok && dynamic.HasDynamicPorts() {
+			udp.dynamic = append(udp.dynamic, dynamic)
+		}
+	}
 	logp.Debug("udp", "Port map: %v", portMap)
 
 	return udp, nil
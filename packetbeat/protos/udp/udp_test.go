@@ -242,3 +242,43 @@ func TestProcess_nonEmptyPayload(t *testing.T) {
 	test.udp.Process(nil, pkt)
 	assert.Equal(t, pkt, test.plugin.pkt)
 }
+
+type DynamicTestProtocol struct {
+	TestProtocol
+	expected common.IPPortTuple
+}
+
+func (proto *DynamicTestProtocol) HasDynamicPorts() bool {
+	return true
+}
+
+func (proto *DynamicTestProtocol) ExpectsUDP(tuple *common.IPPortTuple) bool {
+	return tuple.DstPort == proto.expected.DstPort
+}
+
+// Verify that Process passes the packets on unknown ports to the plugins
+// expecting them.
+func TestProcess_dynamicPorts(t *testing.T) {
+	protocols := &TestProtocols{}
+	plugin := &DynamicTestProtocol{TestProtocol: TestProtocol{Ports: []int{PORT}}}
+	plugin.expected = common.NewIPPortTuple(4,
+		net.ParseIP("192.168.0.1"), 40000,
+		net.ParseIP("10.0.0.1"), 40002)
+	protocols.udp = map[protos.Protocol]protos.UDPPlugin{PROTO: plugin}
+
+	udp, err := NewUDP(protocols)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkt := &protos.Packet{Ts: time.Now(), Tuple: plugin.expected, Payload: []byte{1}}
+	udp.Process(nil, pkt)
+	assert.Equal(t, pkt, plugin.pkt)
+
+	other := &protos.Packet{Ts: time.Now(), Payload: []byte{1},
+		Tuple: common.NewIPPortTuple(4,
+			net.ParseIP("192.168.0.1"), 40000,
+			net.ParseIP("10.0.0.1"), 40004)}
+	udp.Process(nil, other)
+	assert.Equal(t, pkt, plugin.pkt)
+}
@@ -2,11 +2,14 @@ package publish
 
 import (
 	"errors"
+	"net"
 	"sync"
 
 	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/common/flowhash"
 	"github.com/elastic/beats/libbeat/logp"
 	"github.com/elastic/beats/libbeat/publisher"
+	"github.com/elastic/beats/packetbeat/procs"
 )
 
 type Transactions interface {
@@ -17,11 +20,17 @@ type Flows interface {
 	PublishFlows([]common.MapStr) bool
 }
 
+// Snapshotter records the packets of the events matching a condition.
+type Snapshotter interface {
+	Snapshot(common.MapStr)
+}
+
 type PacketbeatPublisher struct {
 	beatPublisher *publisher.BeatPublisher
 	client        publisher.Client
 
 	ignoreOutgoing bool
+	snapshots      Snapshotter
 
 	wg   sync.WaitGroup
 	done chan struct{}
@@ -57,6 +66,12 @@ func NewPublisher(
 	}, nil
 }
 
+// SetSnapshotter sets the recorder of the packets of the published events.
+// It must be called before the publisher is started.
+func (p *PacketbeatPublisher) SetSnapshotter(s Snapshotter) {
+	p.snapshots = s
+}
+
 func (p *PacketbeatPublisher) PublishTransaction(event common.MapStr) bool {
 	select {
 	case p.trans <- event:
@@ -121,6 +136,10 @@ func (p *PacketbeatPublisher) onTransaction(event common.MapStr) {
 		return
 	}
 
+	if p.snapshots != nil {
+		p.snapshots.Snapshot(event)
+	}
+
 	p.client.PublishEvent(event)
 }
 
@@ -132,6 +151,10 @@ func (p *PacketbeatPublisher) onFlow(events []common.MapStr) {
 			continue
 		}
 
+		if p.snapshots != nil {
+			p.snapshots.Snapshot(event)
+		}
+
 		pub = append(pub, event)
 	}
 
@@ -195,6 +218,8 @@ func (p *PacketbeatPublisher) normalizeTransAddr(event common.MapStr) bool {
 	debugf("normalize address for: %v", event)
 
 	var srcServer, dstServer string
+	addCommunityID(event)
+
 	src, ok := event["src"].(*common.Endpoint)
 	debugf("has src: %v", ok)
 	if ok {
@@ -216,6 +241,7 @@ func (p *PacketbeatPublisher) normalizeTransAddr(event common.MapStr) bool {
 		event["client_port"] = src.Port
 		event["client_proc"] = src.Proc
 		event["client_server"] = srcServer
+		addProcess(event, "client_proc", "client_process", src)
 		delete(event, "src")
 	}
 
@@ -227,6 +253,7 @@ func (p *PacketbeatPublisher) normalizeTransAddr(event common.MapStr) bool {
 		event["port"] = dst.Port
 		event["proc"] = dst.Proc
 		event["server"] = dstServer
+		addProcess(event, "proc", "process", dst)
 		delete(event, "dst")
 
 		//check if it's incoming transaction (as server)
@@ -239,3 +266,50 @@ func (p *PacketbeatPublisher) normalizeTransAddr(event common.MapStr) bool {
 
 	return true
 }
+
+// addCommunityID adds the Community ID of the flow of a TCP or UDP
+// transaction.
+func addCommunityID(event common.MapStr) {
+	src, srcOK := event["src"].(*common.Endpoint)
+	dst, dstOK := event["dst"].(*common.Endpoint)
+	if !srcOK || !dstOK {
+		return
+	}
+	if _, exists := event["community_id"]; exists {
+		return
+	}
+
+	protocol := uint8(flowhash.TCP)
+	if transport, _ := event["transport"].(string); transport == "udp" {
+		protocol = flowhash.UDP
+	}
+	communityID := flowhash.DefaultCommunityID.Hash(flowhash.Flow{
+		SourceIP:        net.ParseIP(src.IP),
+		DestinationIP:   net.ParseIP(dst.IP),
+		SourcePort:      src.Port,
+		DestinationPort: dst.Port,
+		Protocol:        protocol,
+	})
+	if communityID != "" {
+		event["community_id"] = communityID
+	}
+}
+
+// addProcess adds the details of the process owning a local endpoint, if all
+// the processes are monitored.
+func addProcess(event common.MapStr, nameField, field string, endpoint *common.Endpoint) {
+	transport := procs.TransportTCP
+	if t, _ := event["transport"].(string); t == "udp" {
+		transport = procs.TransportUDP
+	}
+
+	p := procs.ProcWatcher.FindProcess(transport, net.ParseIP(endpoint.IP), endpoint.Port)
+	if p == nil {
+		return
+	}
+
+	if endpoint.Proc == "" {
+		event[nameField] = p.Name
+	}
+	event[field] = p.ToMapStr()
+}
@@ -113,6 +113,26 @@ func TestDirectionIn(t *testing.T) {
 	assert.True(t, event["direction"] == "in")
 }
 
+func TestCommunityID(t *testing.T) {
+	publisher := newTestPublisher(nil)
+	ppub, _ := NewPublisher(publisher, 1000, 1, false)
+
+	event := common.MapStr{
+		"src": &common.Endpoint{IP: "66.35.250.204", Port: 80},
+		"dst": &common.Endpoint{IP: "128.232.110.120", Port: 34855},
+	}
+	assert.True(t, ppub.normalizeTransAddr(event))
+	assert.Equal(t, "1:LQU9qZlK+B5F3KDmev6m5PMibrg=", event["community_id"])
+
+	event = common.MapStr{
+		"transport": "udp",
+		"src":       &common.Endpoint{IP: "192.168.1.52", Port: 54585},
+		"dst":       &common.Endpoint{IP: "8.8.8.8", Port: 53},
+	}
+	assert.True(t, ppub.normalizeTransAddr(event))
+	assert.Equal(t, "1:d/FP5EW3wiY1vCndhwleRRKHowQ=", event["community_id"])
+}
+
 func newTestPublisher(ips []string) *publisher.BeatPublisher {
 	p := &publisher.BeatPublisher{}
 	p.IPAddrs = ips
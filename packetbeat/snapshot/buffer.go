@@ -0,0 +1,80 @@
+package snapshot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tsg/gopacket"
+)
+
+type packet struct {
+	ci   gopacket.CaptureInfo
+	data []byte
+}
+
+// buffer keeps the most recent packets in memory. The oldest packets are
+// removed when the size of the buffered packets exceeds the limit, or if they
+// are older than the maximum age.
+type buffer struct {
+	sync.Mutex
+
+	maxSize int
+	maxAge  time.Duration
+
+	packets []packet
+	head    int // index of the oldest packet
+	size    int // sum of the buffered packet sizes
+}
+
+func newBuffer(maxSize int, maxAge time.Duration) *buffer {
+	return &buffer{maxSize: maxSize, maxAge: maxAge}
+}
+
+// add copies a packet into the buffer.
+func (b *buffer) add(data []byte, ci *gopacket.CaptureInfo) {
+	if len(data) > b.maxSize {
+		return
+	}
+
+	p := packet{ci: *ci, data: make([]byte, len(data))}
+	copy(p.data, data)
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.packets = append(b.packets, p)
+	b.size += len(data)
+
+	for b.size > b.maxSize || (b.maxAge > 0 && ci.Timestamp.Sub(b.packets[b.head].ci.Timestamp) > b.maxAge) {
+		b.size -= len(b.packets[b.head].data)
+		b.packets[b.head] = packet{}
+		b.head++
+	}
+
+	// reclaim the space of the removed packets once they make up half of
+	// the slice
+	if b.head > len(b.packets)/2 {
+		n := copy(b.packets, b.packets[b.head:])
+		for i := n; i < len(b.packets); i++ {
+			b.packets[i] = packet{}
+		}
+		b.packets = b.packets[:n]
+		b.head = 0
+	}
+}
+
+// snapshot returns the buffered packets matching the filter, or all the
+// packets if the filter is nil. The packet data is never modified, so that it
+// can be read after the buffer is unlocked.
+func (b *buffer) snapshot(filter func(data []byte) bool) []packet {
+	b.Lock()
+	defer b.Unlock()
+
+	var packets []packet
+	for _, p := range b.packets[b.head:] {
+		if filter == nil || filter(p.data) {
+			packets = append(packets, p)
+		}
+	}
+	return packets
+}
@@ -0,0 +1,66 @@
+// +build !integration
+
+package snapshot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tsg/gopacket"
+)
+
+func addPacket(b *buffer, size int, ts time.Time) {
+	b.add(make([]byte, size), &gopacket.CaptureInfo{Timestamp: ts, Length: size, CaptureLength: size})
+}
+
+func TestBufferSize(t *testing.T) {
+	b := newBuffer(1000, 0)
+	now := time.Now()
+
+	for i := 0; i < 10; i++ {
+		addPacket(b, 300, now)
+	}
+	packets := b.snapshot(nil)
+	assert.Len(t, packets, 3)
+	assert.Equal(t, 900, b.size)
+
+	// packets larger than the buffer are not kept
+	addPacket(b, 2000, now)
+	assert.Len(t, b.snapshot(nil), 3)
+}
+
+func TestBufferMaxAge(t *testing.T) {
+	b := newBuffer(1000, time.Minute)
+	now := time.Now()
+
+	addPacket(b, 10, now)
+	addPacket(b, 10, now.Add(30*time.Second))
+	addPacket(b, 10, now.Add(70*time.Second))
+
+	packets := b.snapshot(nil)
+	if assert.Len(t, packets, 2) {
+		assert.Equal(t, now.Add(30*time.Second), packets[0].ci.Timestamp)
+	}
+}
+
+func TestBufferCopy(t *testing.T) {
+	b := newBuffer(1000, 0)
+	data := []byte{1, 2, 3}
+	b.add(data, &gopacket.CaptureInfo{Timestamp: time.Now()})
+	data[0] = 9
+
+	assert.Equal(t, []byte{1, 2, 3}, b.snapshot(nil)[0].data)
+}
+
+func TestBufferFilter(t *testing.T) {
+	b := newBuffer(1000, 0)
+	for i := byte(0); i < 5; i++ {
+		b.add([]byte{i}, &gopacket.CaptureInfo{Timestamp: time.Now()})
+	}
+
+	packets := b.snapshot(func(data []byte) bool { return data[0]%2 == 0 })
+	if assert.Len(t, packets, 3) {
+		assert.Equal(t, []byte{2}, packets[1].data)
+	}
+}
@@ -0,0 +1,36 @@
+package snapshot
+
+import (
+	"errors"
+	"time"
+
+	"github.com/elastic/beats/libbeat/processors"
+)
+
+type Config struct {
+	BufferSizeMb int                          `config:"buffer_size_mb"`
+	MaxAge       time.Duration                `config:"max_age"`
+	Path         string                       `config:"path"`
+	MaxFiles     int                          `config:"max_files"`
+	Triggers     []processors.ConditionConfig `config:"triggers"`
+}
+
+var defaultConfig = Config{
+	BufferSizeMb: 32,
+	MaxAge:       5 * time.Minute,
+	Path:         "snapshots",
+	MaxFiles:     100,
+}
+
+func (c *Config) Validate() error {
+	if c.BufferSizeMb <= 0 {
+		return errors.New("buffer_size_mb must be greater than 0")
+	}
+	if c.MaxFiles <= 0 {
+		return errors.New("max_files must be greater than 0")
+	}
+	if len(c.Triggers) == 0 {
+		return errors.New("no snapshot triggers configured")
+	}
+	return nil
+}
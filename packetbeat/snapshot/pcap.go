@@ -0,0 +1,101 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"os"
+
+	"github.com/tsg/gopacket"
+	"github.com/tsg/gopacket/layers"
+)
+
+const (
+	pcapMagic        = 0xa1b2c3d4
+	pcapVersionMajor = 2
+	pcapVersionMinor = 4
+	pcapSnaplen      = 65535
+)
+
+// writePcap writes the packets to a file in the libpcap format.
+func writePcap(path string, linkType layers.LinkType, packets []packet) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:], pcapVersionMajor)
+	binary.LittleEndian.PutUint16(hdr[6:], pcapVersionMinor)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnaplen)
+	binary.LittleEndian.PutUint32(hdr[20:], uint32(linkType))
+	w.Write(hdr[:])
+
+	for _, p := range packets {
+		var rec [16]byte
+		ts := p.ci.Timestamp
+		binary.LittleEndian.PutUint32(rec[0:], uint32(ts.Unix()))
+		binary.LittleEndian.PutUint32(rec[4:], uint32(ts.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(rec[8:], uint32(len(p.data)))
+		length := p.ci.Length
+		if length < len(p.data) {
+			length = len(p.data)
+		}
+		binary.LittleEndian.PutUint32(rec[12:], uint32(length))
+		w.Write(rec[:])
+		w.Write(p.data)
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// endpoints selects the packets exchanged between two endpoints. A zero port
+// matches any port, or packets without ports like ICMP.
+type endpoints struct {
+	ip1, ip2     net.IP
+	port1, port2 uint16
+}
+
+// matchData checks if the packet data is exchanged between the endpoints. The
+// innermost network and transport layers of the packets are compared, so that
+// the packets of tunneled flows are matched too.
+func (e *endpoints) matchData(linkType layers.LinkType, data []byte) bool {
+	pkt := gopacket.NewPacket(data, linkType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	return e.match(pkt)
+}
+
+func (e *endpoints) match(pkt gopacket.Packet) bool {
+	var src, dst net.IP
+	var srcPort, dstPort uint16
+	for _, layer := range pkt.Layers() {
+		switch l := layer.(type) {
+		case *layers.IPv4:
+			src, dst = l.SrcIP, l.DstIP
+			srcPort, dstPort = 0, 0
+		case *layers.IPv6:
+			src, dst = l.SrcIP, l.DstIP
+			srcPort, dstPort = 0, 0
+		case *layers.TCP:
+			srcPort, dstPort = uint16(l.SrcPort), uint16(l.DstPort)
+		case *layers.UDP:
+			srcPort, dstPort = uint16(l.SrcPort), uint16(l.DstPort)
+		}
+	}
+	if src == nil {
+		return false
+	}
+
+	return e.matchDir(src, dst, srcPort, dstPort) || e.matchDir(dst, src, dstPort, srcPort)
+}
+
+func (e *endpoints) matchDir(src, dst net.IP, srcPort, dstPort uint16) bool {
+	return e.ip1.Equal(src) && e.ip2.Equal(dst) &&
+		(e.port1 == 0 || e.port1 == srcPort) &&
+		(e.port2 == 0 || e.port2 == dstPort)
+}
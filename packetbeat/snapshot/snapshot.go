@@ -0,0 +1,254 @@
+// Package snapshot keeps the recently captured packets in a ring buffer, and
+// writes the packets of a flow or transaction to a pcap file when the event
+// matches one of the configured triggers.
+package snapshot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/paths"
+	"github.com/elastic/beats/libbeat/processors"
+
+	"github.com/tsg/gopacket"
+	"github.com/tsg/gopacket/layers"
+)
+
+// maximum number of snapshots waiting to be written
+const queueSize = 16
+
+var debugf = logp.MakeDebug("snapshot")
+
+// Worker is the interface of the packet decoders.
+type Worker interface {
+	OnPacket(data []byte, ci *gopacket.CaptureInfo)
+}
+
+// Recorder buffers the captured packets and writes the snapshots.
+type Recorder struct {
+	config   Config
+	path     string
+	triggers []processors.Condition
+	buffer   *buffer
+
+	linkType layers.LinkType
+	seq      uint64
+
+	mutex  sync.Mutex // protects the queue from being closed while in use
+	closed bool
+	queue  chan *snapshot
+	wg     sync.WaitGroup
+}
+
+type snapshot struct {
+	path    string
+	packets []packet
+}
+
+type worker struct {
+	buffer *buffer
+	worker Worker
+}
+
+// New creates a recorder. The snapshots directory is created if it doesn't
+// exist.
+func New(cfg *common.Config) (*Recorder, error) {
+	config := defaultConfig
+	if err := cfg.Unpack(&config); err != nil {
+		return nil, err
+	}
+
+	triggers, err := processors.NewConditionList(config.Triggers)
+	if err != nil {
+		return nil, err
+	}
+
+	path := paths.Resolve(paths.Data, config.Path)
+	if err := os.MkdirAll(path, 0750); err != nil {
+		return nil, fmt.Errorf("Failed to create the snapshots directory: %v", err)
+	}
+
+	return &Recorder{
+		config:   config,
+		path:     path,
+		triggers: triggers,
+		buffer:   newBuffer(config.BufferSizeMb*1024*1024, config.MaxAge),
+		queue:    make(chan *snapshot, queueSize),
+	}, nil
+}
+
+// Worker returns a worker adding the packets to the buffer before passing
+// them to the decoder.
+func (r *Recorder) Worker(linkType layers.LinkType, w Worker) Worker {
+	r.linkType = linkType
+	return &worker{buffer: r.buffer, worker: w}
+}
+
+func (w *worker) OnPacket(data []byte, ci *gopacket.CaptureInfo) {
+	w.buffer.add(data, ci)
+	w.worker.OnPacket(data, ci)
+}
+
+// Start writes the snapshots in background.
+func (r *Recorder) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for s := range r.queue {
+			r.write(s)
+		}
+	}()
+}
+
+// Stop writes the pending snapshots.
+func (r *Recorder) Stop() {
+	r.mutex.Lock()
+	r.closed = true
+	close(r.queue)
+	r.mutex.Unlock()
+
+	r.wg.Wait()
+}
+
+// Snapshot checks if the event matches a trigger. If it does, the buffered
+// packets of the flow or transaction are written to a pcap file in background,
+// and the path of the file is added to the event in the pcap_file field.
+func (r *Recorder) Snapshot(event common.MapStr) {
+	if !r.triggered(event) {
+		return
+	}
+
+	e, ok := eventEndpoints(event)
+	if !ok {
+		debugf("No addresses in the event, skip snapshot")
+		return
+	}
+
+	typ, _ := event["type"].(string)
+	name := fmt.Sprintf("%s-%s-%06d.pcap",
+		time.Now().UTC().Format("20060102T150405.000"), typ, atomic.AddUint64(&r.seq, 1))
+	s := &snapshot{
+		path: filepath.Join(r.path, name),
+		packets: r.buffer.snapshot(func(data []byte) bool {
+			return e.matchData(r.linkType, data)
+		}),
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.queue <- s:
+		event["pcap_file"] = s.path
+	default:
+		logp.Warn("Too many pending snapshots, dropping snapshot of %v event", typ)
+	}
+}
+
+func (r *Recorder) triggered(event common.MapStr) bool {
+	for _, trigger := range r.triggers {
+		if trigger.Check(event) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Recorder) write(s *snapshot) {
+	debugf("Write %d packets to %s", len(s.packets), s.path)
+	if err := writePcap(s.path, r.linkType, s.packets); err != nil {
+		logp.Err("Failed to write the snapshot %s: %v", s.path, err)
+		return
+	}
+	r.removeOldFiles()
+}
+
+// removeOldFiles keeps the most recent snapshots only.
+func (r *Recorder) removeOldFiles() {
+	files, err := ioutil.ReadDir(r.path)
+	if err != nil {
+		logp.Err("Failed to list the snapshots: %v", err)
+		return
+	}
+
+	var snapshots []os.FileInfo
+	for _, f := range files {
+		if f.Mode().IsRegular() && strings.HasSuffix(f.Name(), ".pcap") {
+			snapshots = append(snapshots, f)
+		}
+	}
+	if len(snapshots) <= r.config.MaxFiles {
+		return
+	}
+
+	// names start with the creation time
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Name() < snapshots[j].Name()
+	})
+	for _, f := range snapshots[:len(snapshots)-r.config.MaxFiles] {
+		if err := os.Remove(filepath.Join(r.path, f.Name())); err != nil {
+			logp.Err("Failed to remove the snapshot: %v", err)
+		}
+	}
+}
+
+// eventEndpoints returns the addresses of a transaction or a flow.
+func eventEndpoints(event common.MapStr) (endpoints, bool) {
+	var e endpoints
+
+	// transactions
+	if client, ok := event["client_ip"].(string); ok {
+		e.ip1 = net.ParseIP(client)
+		e.ip2 = net.ParseIP(stringField(event, "ip"))
+		e.port1 = port(event["client_port"])
+		e.port2 = port(event["port"])
+		return e, e.ip1 != nil && e.ip2 != nil
+	}
+
+	// flows
+	source, _ := event["source"].(common.MapStr)
+	dest, _ := event["dest"].(common.MapStr)
+	if source == nil || dest == nil {
+		return e, false
+	}
+	for _, field := range []string{"ip", "ipv6"} {
+		if ip := stringField(source, field); ip != "" {
+			e.ip1 = net.ParseIP(ip)
+			e.ip2 = net.ParseIP(stringField(dest, field))
+		}
+	}
+	e.port1 = port(source["port"])
+	e.port2 = port(dest["port"])
+	return e, e.ip1 != nil && e.ip2 != nil
+}
+
+func stringField(m common.MapStr, field string) string {
+	s, _ := m[field].(string)
+	return s
+}
+
+func port(v interface{}) uint16 {
+	switch p := v.(type) {
+	case uint16:
+		return p
+	case int:
+		return uint16(p)
+	case uint32:
+		return uint16(p)
+	case uint64:
+		return uint16(p)
+	}
+	return 0
+}
@@ -0,0 +1,203 @@
+// +build !integration
+
+package snapshot
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tsg/gopacket"
+	"github.com/tsg/gopacket/layers"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+type nopWorker struct {
+	packets int
+}
+
+func (w *nopWorker) OnPacket(data []byte, ci *gopacket.CaptureInfo) {
+	w.packets++
+}
+
+// udpFrame returns an Ethernet frame of a UDP datagram between two IPv4
+// addresses.
+func udpFrame(src, dst [4]byte, srcPort, dstPort uint16) []byte {
+	frame := []byte{
+		0x00, 0x00, 0x5e, 0x00, 0x53, 0x01, 0x00, 0x00, 0x5e, 0x00, 0x53, 0x02, 0x08, 0x00,
+		0x45, 0x00, 0x00, 0x20, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11, 0x00, 0x00,
+	}
+	frame = append(frame, src[:]...)
+	frame = append(frame, dst[:]...)
+
+	udp := make([]byte, 12)
+	binary.BigEndian.PutUint16(udp[0:], srcPort)
+	binary.BigEndian.PutUint16(udp[2:], dstPort)
+	binary.BigEndian.PutUint16(udp[4:], 12)
+	copy(udp[8:], "test")
+	return append(frame, udp...)
+}
+
+var (
+	client = [4]byte{192, 168, 0, 1}
+	server = [4]byte{192, 168, 0, 2}
+	other  = [4]byte{192, 168, 0, 3}
+)
+
+func newTestRecorder(t *testing.T, settings map[string]interface{}) (*Recorder, string) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings["path"] = dir
+	if _, exists := settings["triggers"]; !exists {
+		settings["triggers"] = []map[string]interface{}{
+			{"equals": map[string]interface{}{"dns.response_code": "SERVFAIL"}},
+			{"range": map[string]interface{}{"http.response.code.gte": 500}},
+		}
+	}
+
+	cfg, err := common.NewConfigFrom(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := r.Worker(layers.LinkTypeEthernet, &nopWorker{})
+	ts := time.Unix(1500000000, 0)
+	for _, frame := range [][]byte{
+		udpFrame(client, server, 40000, 53),
+		udpFrame(client, other, 40001, 53),
+		udpFrame(server, client, 53, 40000),
+		udpFrame(client, server, 40002, 53),
+	} {
+		w.OnPacket(frame, &gopacket.CaptureInfo{Timestamp: ts, Length: len(frame), CaptureLength: len(frame)})
+		ts = ts.Add(time.Second)
+	}
+
+	r.Start()
+	return r, dir
+}
+
+// readPcap returns the timestamps of the packets of a pcap file.
+func readPcap(t *testing.T, path string) []time.Time {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint32(pcapMagic), binary.LittleEndian.Uint32(data))
+	assert.Equal(t, uint32(layers.LinkTypeEthernet), binary.LittleEndian.Uint32(data[20:]))
+
+	var timestamps []time.Time
+	for data = data[24:]; len(data) >= 16; {
+		ts := time.Unix(int64(binary.LittleEndian.Uint32(data)), 0)
+		length := int(binary.LittleEndian.Uint32(data[8:]))
+		timestamps = append(timestamps, ts)
+		data = data[16+length:]
+	}
+	assert.Empty(t, data)
+	return timestamps
+}
+
+func TestSnapshotTransaction(t *testing.T) {
+	r, dir := newTestRecorder(t, map[string]interface{}{})
+	defer os.RemoveAll(dir)
+
+	event := common.MapStr{
+		"type":        "dns",
+		"client_ip":   "192.168.0.1",
+		"client_port": uint16(40000),
+		"ip":          "192.168.0.2",
+		"port":        uint16(53),
+		"dns":         common.MapStr{"response_code": "SERVFAIL"},
+	}
+	r.Snapshot(event)
+
+	ignored := common.MapStr{
+		"type":      "dns",
+		"client_ip": "192.168.0.1",
+		"ip":        "192.168.0.2",
+		"dns":       common.MapStr{"response_code": "NOERROR"},
+	}
+	r.Snapshot(ignored)
+	r.Stop()
+
+	path, ok := event["pcap_file"].(string)
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Equal(t, dir, filepath.Dir(path))
+	assert.Nil(t, ignored["pcap_file"])
+
+	// both directions of the transaction only
+	assert.Equal(t, []time.Time{
+		time.Unix(1500000000, 0),
+		time.Unix(1500000002, 0),
+	}, readPcap(t, path))
+}
+
+func TestSnapshotFlow(t *testing.T) {
+	r, dir := newTestRecorder(t, map[string]interface{}{
+		"triggers": []map[string]interface{}{
+			{"equals": map[string]interface{}{"type": "flow"}},
+		},
+	})
+	defer os.RemoveAll(dir)
+
+	event := common.MapStr{
+		"type":   "flow",
+		"source": common.MapStr{"ip": "192.168.0.1"},
+		"dest":   common.MapStr{"ip": "192.168.0.2"},
+	}
+	r.Snapshot(event)
+	r.Stop()
+
+	// all the ports
+	assert.Len(t, readPcap(t, event["pcap_file"].(string)), 3)
+}
+
+func TestSnapshotMaxFiles(t *testing.T) {
+	r, dir := newTestRecorder(t, map[string]interface{}{"max_files": 2})
+	defer os.RemoveAll(dir)
+
+	var files []string
+	for i := 0; i < 4; i++ {
+		event := common.MapStr{
+			"type":      "http",
+			"client_ip": "192.168.0.1",
+			"ip":        "192.168.0.3",
+			"http":      common.MapStr{"response": common.MapStr{"code": 503}},
+		}
+		r.Snapshot(event)
+		files = append(files, event["pcap_file"].(string))
+	}
+	r.Stop()
+
+	// the most recent snapshots are kept
+	infos, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	if assert.Len(t, infos, 2) {
+		assert.Equal(t, filepath.Base(files[2]), infos[0].Name())
+		assert.Equal(t, filepath.Base(files[3]), infos[1].Name())
+	}
+
+	// snapshots are dropped after the recorder is stopped
+	event := common.MapStr{"type": "dns", "client_ip": "192.168.0.1", "ip": "192.168.0.2",
+		"dns": common.MapStr{"response_code": "SERVFAIL"}}
+	r.Snapshot(event)
+	assert.Nil(t, event["pcap_file"])
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := common.NewConfig()
+	_, err := New(cfg)
+	assert.Error(t, err)
+}
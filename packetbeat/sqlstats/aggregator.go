@@ -0,0 +1,236 @@
+package sqlstats
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/packetbeat/publish"
+)
+
+// number of response times kept per fingerprint to compute the percentiles
+const maxSamples = 512
+
+// Transaction holds the fields of a SQL transaction that are aggregated.
+type Transaction struct {
+	Server       common.Endpoint
+	Query        string
+	Fingerprint  string
+	Method       string
+	IsError      bool
+	ResponseTime int32 // milliseconds
+	Rows         int
+	BytesIn      uint64
+	BytesOut     uint64
+}
+
+// Aggregator collects the statistics of the transactions per server and
+// fingerprint and publishes them periodically.
+type Aggregator struct {
+	protocol string
+	period   time.Duration
+	maxKeys  int
+	results  publish.Transactions
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	mutex   sync.Mutex
+	start   time.Time
+	stats   map[statsKey]*queryStats
+	dropped int
+}
+
+type statsKey struct {
+	server      string
+	fingerprint string
+}
+
+type queryStats struct {
+	server common.Endpoint
+	query  string
+	method string
+
+	count    int
+	errors   int
+	rows     int
+	bytesIn  uint64
+	bytesOut uint64
+
+	minRT, maxRT int32
+	sumRT        int64
+	samples      []int32
+}
+
+var debugf = logp.MakeDebug("sqlstats")
+
+// NewAggregator creates an aggregator publishing the statistics of the
+// protocol transactions. Start must be called to publish them periodically.
+func NewAggregator(protocol string, cfg Config, results publish.Transactions) *Aggregator {
+	return &Aggregator{
+		protocol: protocol,
+		period:   cfg.Period,
+		maxKeys:  cfg.MaxFingerprints,
+		results:  results,
+		done:     make(chan struct{}),
+		start:    time.Now(),
+		stats:    map[statsKey]*queryStats{},
+	}
+}
+
+// Start publishes the statistics every period, until Stop is called.
+func (a *Aggregator) Start() {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ticker := time.NewTicker(a.period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.done:
+				return
+			case ts := <-ticker.C:
+				a.Flush(ts)
+			}
+		}
+	}()
+}
+
+// Stop stops publishing the statistics periodically.
+func (a *Aggregator) Stop() {
+	close(a.done)
+	a.wg.Wait()
+}
+
+// Add accounts the transaction.
+func (a *Aggregator) Add(t *Transaction) {
+	key := statsKey{
+		server:      t.Server.IP + ":" + strconv.Itoa(int(t.Server.Port)),
+		fingerprint: t.Fingerprint,
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	s := a.stats[key]
+	if s == nil {
+		if len(a.stats) >= a.maxKeys {
+			a.dropped++
+			return
+		}
+		s = &queryStats{
+			server: t.Server,
+			query:  t.Query,
+			method: t.Method,
+			minRT:  t.ResponseTime,
+			maxRT:  t.ResponseTime,
+		}
+		a.stats[key] = s
+	}
+	s.add(t)
+}
+
+// Flush publishes the statistics collected since the last flush and resets
+// them.
+func (a *Aggregator) Flush(ts time.Time) {
+	a.mutex.Lock()
+	start, stats, dropped := a.start, a.stats, a.dropped
+	a.start = ts
+	a.stats = map[statsKey]*queryStats{}
+	a.dropped = 0
+	a.mutex.Unlock()
+
+	if dropped > 0 {
+		logp.Warn("%s: %d transactions not aggregated, more than %d fingerprints",
+			a.protocol, dropped, a.maxKeys)
+	}
+
+	debugf("publishing %d %s query statistics", len(stats), a.protocol)
+	for key, s := range stats {
+		a.results.PublishTransaction(a.createEvent(ts, start, key.fingerprint, s))
+	}
+}
+
+func (a *Aggregator) createEvent(
+	ts, start time.Time,
+	fingerprint string,
+	s *queryStats,
+) common.MapStr {
+	server := s.server
+	return common.MapStr{
+		"@timestamp": common.Time(ts),
+		"type":       "sql_stats",
+		"dst":        &server,
+		"sql_stats": common.MapStr{
+			"protocol":    a.protocol,
+			"start_time":  common.Time(start),
+			"fingerprint": fingerprint,
+			"query":       s.query,
+			"method":      s.method,
+			"count":       s.count,
+			"error_count": s.errors,
+			"rows":        s.rows,
+			"bytes_in":    s.bytesIn,
+			"bytes_out":   s.bytesOut,
+			"responsetime": common.MapStr{
+				"min": s.minRT,
+				"max": s.maxRT,
+				"avg": float64(s.sumRT) / float64(s.count),
+				"p50": s.percentile(50),
+				"p90": s.percentile(90),
+				"p99": s.percentile(99),
+			},
+		},
+	}
+}
+
+func (s *queryStats) add(t *Transaction) {
+	s.count++
+	if t.IsError {
+		s.errors++
+	}
+	s.rows += t.Rows
+	s.bytesIn += t.BytesIn
+	s.bytesOut += t.BytesOut
+
+	if t.ResponseTime < s.minRT {
+		s.minRT = t.ResponseTime
+	}
+	if t.ResponseTime > s.maxRT {
+		s.maxRT = t.ResponseTime
+	}
+	s.sumRT += int64(t.ResponseTime)
+
+	// reservoir sampling, every response time has the same probability to
+	// be kept
+	if len(s.samples) < maxSamples {
+		s.samples = append(s.samples, t.ResponseTime)
+	} else if i := rand.Intn(s.count); i < maxSamples {
+		s.samples[i] = t.ResponseTime
+	}
+}
+
+// percentile returns the nearest-rank percentile of the sampled response
+// times.
+func (s *queryStats) percentile(p int) int32 {
+	if len(s.samples) == 0 {
+		return 0
+	}
+	if !sort.IsSorted(int32s(s.samples)) {
+		sort.Sort(int32s(s.samples))
+	}
+	rank := (p*len(s.samples) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return s.samples[rank-1]
+}
+
+type int32s []int32
+
+func (a int32s) Len() int           { return len(a) }
+func (a int32s) Less(i, j int) bool { return a[i] < a[j] }
+func (a int32s) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
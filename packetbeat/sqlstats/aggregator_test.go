@@ -0,0 +1,146 @@
+// +build !integration
+
+package sqlstats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/packetbeat/publish"
+)
+
+func newTestAggregator(maxFingerprints int) (*Aggregator, *publish.ChanTransactions) {
+	results := &publish.ChanTransactions{Channel: make(chan common.MapStr, 10)}
+	cfg := DefaultConfig
+	cfg.MaxFingerprints = maxFingerprints
+	return NewAggregator("mysql", cfg, results), results
+}
+
+func testTransaction(query string, responseTime int32) *Transaction {
+	normalized, fingerprint := Normalize(query, MySQL)
+	return &Transaction{
+		Server:       common.Endpoint{IP: "192.168.1.20", Port: 3306},
+		Query:        normalized,
+		Fingerprint:  fingerprint,
+		Method:       "SELECT",
+		ResponseTime: responseTime,
+		Rows:         1,
+		BytesIn:      10,
+		BytesOut:     100,
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	aggregator, results := newTestAggregator(10)
+
+	for i := int32(1); i <= 100; i++ {
+		aggregator.Add(testTransaction("SELECT name FROM users WHERE id = 1", i))
+	}
+	failed := testTransaction("SELECT name FROM users WHERE id = 2", 200)
+	failed.IsError = true
+	aggregator.Add(failed)
+
+	ts := time.Now()
+	aggregator.Flush(ts)
+	if !assert.Len(t, results.Channel, 1) {
+		return
+	}
+	event := <-results.Channel
+
+	assert.Equal(t, common.Time(ts), event["@timestamp"])
+	assert.Equal(t, "sql_stats", event["type"])
+	assert.Equal(t, "192.168.1.20", event["dst"].(*common.Endpoint).IP)
+
+	stats := event["sql_stats"].(common.MapStr)
+	assert.Equal(t, "mysql", stats["protocol"])
+	assert.Equal(t, "SELECT name FROM users WHERE id = ?", stats["query"])
+	assert.Equal(t, 101, stats["count"])
+	assert.Equal(t, 1, stats["error_count"])
+	assert.Equal(t, 101, stats["rows"])
+	assert.Equal(t, uint64(1010), stats["bytes_in"])
+	assert.Equal(t, uint64(10100), stats["bytes_out"])
+
+	rt := stats["responsetime"].(common.MapStr)
+	assert.Equal(t, int32(1), rt["min"])
+	assert.Equal(t, int32(200), rt["max"])
+	assert.InDelta(t, 51.98, rt["avg"], 0.01)
+	assert.Equal(t, int32(51), rt["p50"])
+	assert.Equal(t, int32(91), rt["p90"])
+	assert.Equal(t, int32(100), rt["p99"])
+
+	// the statistics are reset
+	aggregator.Flush(time.Now())
+	assert.Len(t, results.Channel, 0)
+}
+
+func TestAggregator_perServer(t *testing.T) {
+	aggregator, results := newTestAggregator(10)
+
+	trans := testTransaction("SELECT 1", 1)
+	aggregator.Add(trans)
+	other := *trans
+	other.Server.IP = "192.168.1.21"
+	aggregator.Add(&other)
+
+	aggregator.Flush(time.Now())
+	assert.Len(t, results.Channel, 2)
+}
+
+func TestAggregator_maxFingerprints(t *testing.T) {
+	aggregator, results := newTestAggregator(1)
+
+	aggregator.Add(testTransaction("SELECT a FROM t", 1))
+	aggregator.Add(testTransaction("SELECT b FROM t", 1))
+	aggregator.Add(testTransaction("SELECT a FROM t", 1))
+
+	aggregator.Flush(time.Now())
+	if assert.Len(t, results.Channel, 1) {
+		event := <-results.Channel
+		assert.Equal(t, 2, event["sql_stats"].(common.MapStr)["count"])
+	}
+}
+
+func TestAggregator_sampling(t *testing.T) {
+	aggregator, results := newTestAggregator(1)
+
+	for i := 0; i < 10*maxSamples; i++ {
+		aggregator.Add(testTransaction("SELECT 1", 5))
+	}
+	aggregator.Flush(time.Now())
+	event := <-results.Channel
+	rt := event["sql_stats"].(common.MapStr)["responsetime"].(common.MapStr)
+	assert.Equal(t, int32(5), rt["p99"])
+}
+
+func TestAggregator_stop(t *testing.T) {
+	aggregator, results := newTestAggregator(1)
+	aggregator.period = 10 * time.Millisecond
+	aggregator.Start()
+
+	aggregator.Add(testTransaction("SELECT 1", 5))
+	select {
+	case <-results.Channel:
+	case <-time.After(time.Second):
+		t.Fatal("statistics not published")
+	}
+
+	aggregator.Stop()
+	aggregator.Add(testTransaction("SELECT 1", 5))
+	time.Sleep(5 * aggregator.period)
+	assert.Len(t, results.Channel, 0)
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := DefaultConfig
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Period = 10 * time.Millisecond
+	assert.Equal(t, ErrInvalidPeriod, cfg.Validate())
+
+	cfg = DefaultConfig
+	cfg.MaxFingerprints = 0
+	assert.Equal(t, ErrInvalidMaxFingerprints, cfg.Validate())
+}
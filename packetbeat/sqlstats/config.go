@@ -0,0 +1,43 @@
+package sqlstats
+
+import (
+	"errors"
+	"time"
+)
+
+// Config configures the aggregation of the SQL transactions.
+type Config struct {
+	// If enabled, the transactions are not published but aggregated per
+	// server and fingerprint, and the statistics are published every
+	// period.
+	Enabled bool          `config:"enabled"`
+	Period  time.Duration `config:"period"`
+
+	// Maximum number of fingerprints tracked per period. The transactions
+	// of additional fingerprints are dropped.
+	MaxFingerprints int `config:"max_fingerprints"`
+}
+
+var (
+	// DefaultConfig is the default aggregation configuration.
+	DefaultConfig = Config{
+		Enabled:         false,
+		Period:          1 * time.Minute,
+		MaxFingerprints: 10000,
+	}
+)
+
+var (
+	ErrInvalidPeriod          = errors.New("aggregation period must be >= 1s")
+	ErrInvalidMaxFingerprints = errors.New("max_fingerprints must be > 0")
+)
+
+func (c *Config) Validate() error {
+	if c.Period < time.Second {
+		return ErrInvalidPeriod
+	}
+	if c.MaxFingerprints <= 0 {
+		return ErrInvalidMaxFingerprints
+	}
+	return nil
+}
@@ -0,0 +1,416 @@
+// Package sqlstats provides the SQL query normalization and the aggregation
+// of query statistics shared by the SQL protocol analyzers.
+package sqlstats
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// Dialect selects the lexical rules used to normalize queries.
+type Dialect uint8
+
+const (
+	// MySQL quotes strings with single or double quotes and identifiers with
+	// backticks.
+	MySQL Dialect = iota
+
+	// PostgreSQL quotes identifiers with double quotes and supports
+	// dollar-quoted strings and positional parameters.
+	PostgreSQL
+)
+
+type tokenKind uint8
+
+const (
+	tokenWord tokenKind = iota
+	tokenQuoted
+	tokenLiteral
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+
+	// true if the token was preceded by whitespace or a comment
+	space bool
+}
+
+const (
+	placeholder     = "?"
+	listPlaceholder = "..."
+)
+
+// operators of more than one character, longest first
+var operators = []string{
+	"<=>", "->>",
+	"<=", ">=", "<>", "!=", "||", "&&", "::", ":=", "->", "<<", ">>",
+}
+
+// Normalize replaces the literals of a query with placeholders, collapses
+// IN-lists and repeated VALUES tuples and removes comments and redundant
+// whitespace. It returns the normalized query and its fingerprint, a hash
+// that doesn't depend on the case and the formatting of the query.
+func Normalize(query string, dialect Dialect) (normalized, fingerprint string) {
+	tokens := tokenize(query, dialect)
+	tokens = collapseLists(tokens)
+	return format(tokens), hashTokens(tokens)
+}
+
+func tokenize(query string, dialect Dialect) []token {
+	var tokens []token
+	space := false
+
+	emit := func(kind tokenKind, text string) {
+		tokens = append(tokens, token{kind: kind, text: text, space: space})
+		space = false
+	}
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case isSpace(c):
+			space = true
+			i++
+
+		case c == '-' && strings.HasPrefix(query[i:], "--"),
+			c == '#' && dialect == MySQL:
+			i = skipLine(query, i)
+			space = true
+
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(query)
+			}
+			space = true
+
+		case c == '\'':
+			i = skipString(query, i, c, dialect == MySQL)
+			emit(tokenLiteral, placeholder)
+
+		case c == '"' && dialect == MySQL:
+			i = skipString(query, i, c, true)
+			emit(tokenLiteral, placeholder)
+
+		case c == '"' || c == '`':
+			end := skipString(query, i, c, false)
+			emit(tokenQuoted, query[i:end])
+			i = end
+
+		case c == '$' && dialect == PostgreSQL:
+			if end, ok := skipDollar(query, i); ok {
+				emit(tokenLiteral, placeholder)
+				i = end
+			} else {
+				emit(tokenPunct, "$")
+				i++
+			}
+
+		case c == '?':
+			emit(tokenLiteral, placeholder)
+			i++
+
+		case isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1])):
+			i = skipNumber(query, i)
+			emit(tokenLiteral, placeholder)
+
+		case c == '-' && i+1 < len(query) && isDigit(query[i+1]) && startsOperand(tokens):
+			// negative number
+			i = skipNumber(query, i+1)
+			emit(tokenLiteral, placeholder)
+
+		case isWordStart(c, dialect):
+			end := i + 1
+			for end < len(query) && isWordPart(query[end], dialect) {
+				end++
+			}
+			word := query[i:end]
+			i = end
+
+			// strings with a prefix, like E'...' or _utf8'...'
+			if i < len(query) && query[i] == '\'' && isStringPrefix(word) {
+				escapes := dialect == MySQL || strings.EqualFold(word, "e")
+				i = skipString(query, i, '\'', escapes)
+				emit(tokenLiteral, placeholder)
+				continue
+			}
+			emit(tokenWord, word)
+
+		default:
+			op := string(c)
+			for _, o := range operators {
+				if strings.HasPrefix(query[i:], o) {
+					op = o
+					break
+				}
+			}
+			emit(tokenPunct, op)
+			i += len(op)
+		}
+	}
+	return tokens
+}
+
+// collapseLists replaces IN-lists of literals with a single placeholder and
+// removes VALUES tuples identical to the first one.
+func collapseLists(tokens []token) []token {
+	out := tokens[:0]
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		out = append(out, tok)
+		if tok.kind != tokenWord {
+			continue
+		}
+
+		switch strings.ToUpper(tok.text) {
+		case "IN":
+			end := literalListEnd(tokens, i+1)
+			if end < 0 {
+				continue
+			}
+			out = append(out, tokens[i+1],
+				token{kind: tokenLiteral, text: listPlaceholder}, tokens[end])
+			i = end
+
+		case "VALUES", "VALUE":
+			end := tupleEnd(tokens, i+1)
+			if end < 0 {
+				continue
+			}
+			first := tokens[i+1 : end+1]
+			out = append(out, first...)
+			i = end
+			for i+1 < len(tokens) && tokens[i+1].text == "," {
+				next := tupleEnd(tokens, i+2)
+				if next < 0 || !sameTokens(first, tokens[i+2:next+1]) {
+					break
+				}
+				i = next
+			}
+		}
+	}
+	return out
+}
+
+// literalListEnd returns the index of the closing parenthesis of a list of
+// literals starting at i, or -1.
+func literalListEnd(tokens []token, i int) int {
+	if i >= len(tokens) || tokens[i].text != "(" {
+		return -1
+	}
+	expectLiteral := true
+	for j := i + 1; j < len(tokens); j++ {
+		tok := tokens[j]
+		switch {
+		case expectLiteral && tok.kind == tokenLiteral:
+			expectLiteral = false
+		case !expectLiteral && tok.text == ",":
+			expectLiteral = true
+		case !expectLiteral && tok.text == ")":
+			return j
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+// tupleEnd returns the index of the parenthesis closing the one at i, or -1.
+func tupleEnd(tokens []token, i int) int {
+	if i >= len(tokens) || tokens[i].text != "(" {
+		return -1
+	}
+	depth := 0
+	for j := i; j < len(tokens); j++ {
+		if tokens[j].kind != tokenPunct {
+			continue
+		}
+		switch tokens[j].text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+func sameTokens(a, b []token) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].kind != b[i].kind || !strings.EqualFold(a[i].text, b[i].text) {
+			return false
+		}
+	}
+	return true
+}
+
+func format(tokens []token) string {
+	var buf bytes.Buffer
+	for i, tok := range tokens {
+		if i > 0 && needsSpace(tokens[i-1], tok) {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(tok.text)
+	}
+	return buf.String()
+}
+
+func needsSpace(prev, cur token) bool {
+	switch cur.text {
+	case ",", ")", ";", ".", "::":
+		return false
+	case "(":
+		// keep function calls together
+		if prev.kind == tokenWord && !cur.space {
+			return false
+		}
+	}
+	switch prev.text {
+	case "(", ".", "::":
+		return false
+	}
+	return true
+}
+
+// hashTokens computes the fingerprint from the lower case tokens, so it
+// doesn't depend on the formatting.
+func hashTokens(tokens []token) string {
+	h := fnv.New64a()
+	for _, tok := range tokens {
+		h.Write([]byte(strings.ToLower(tok.text)))
+		h.Write([]byte{' '})
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// startsOperand returns true if a value is expected after the tokens, so
+// that a minus sign belongs to a number.
+func startsOperand(tokens []token) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	last := tokens[len(tokens)-1]
+	return last.kind == tokenPunct && last.text != ")"
+}
+
+func skipLine(s string, i int) int {
+	if end := strings.IndexByte(s[i:], '\n'); end >= 0 {
+		return i + end + 1
+	}
+	return len(s)
+}
+
+// skipString returns the index following the string or quoted identifier
+// starting at i. Quotes are escaped by doubling them and, if enabled, by
+// backslashes.
+func skipString(s string, i int, quote byte, backslash bool) int {
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			if backslash {
+				j++
+			}
+		case quote:
+			if j+1 < len(s) && s[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(s)
+}
+
+// skipDollar skips positional parameters ($1) and dollar-quoted strings
+// ($$...$$ or $tag$...$tag$).
+func skipDollar(s string, i int) (int, bool) {
+	j := i + 1
+	if j < len(s) && isDigit(s[j]) {
+		for j < len(s) && isDigit(s[j]) {
+			j++
+		}
+		return j, true
+	}
+
+	for j < len(s) && (isLetter(s[j]) || isDigit(s[j]) || s[j] == '_') {
+		j++
+	}
+	if j >= len(s) || s[j] != '$' {
+		return 0, false
+	}
+	tag := s[i : j+1]
+	if end := strings.Index(s[j+1:], tag); end >= 0 {
+		return j + 1 + end + len(tag), true
+	}
+	return len(s), true
+}
+
+func skipNumber(s string, i int) int {
+	if strings.HasPrefix(s[i:], "0x") || strings.HasPrefix(s[i:], "0X") {
+		j := i + 2
+		for j < len(s) && isHexDigit(s[j]) {
+			j++
+		}
+		return j
+	}
+
+	j := i
+	for j < len(s) && (isDigit(s[j]) || s[j] == '.') {
+		j++
+	}
+	if j < len(s) && (s[j] == 'e' || s[j] == 'E') {
+		k := j + 1
+		if k < len(s) && (s[k] == '+' || s[k] == '-') {
+			k++
+		}
+		if k < len(s) && isDigit(s[k]) {
+			for j = k; j < len(s) && isDigit(s[j]); j++ {
+			}
+		}
+	}
+	return j
+}
+
+func isStringPrefix(word string) bool {
+	switch strings.ToLower(word) {
+	case "e", "b", "x", "n", "u&":
+		return true
+	}
+	// character set introducer
+	return word[0] == '_'
+}
+
+func isWordStart(c byte, dialect Dialect) bool {
+	return isLetter(c) || c == '_' || c >= 0x80 || (dialect == MySQL && c == '@')
+}
+
+func isWordPart(c byte, dialect Dialect) bool {
+	return isWordStart(c, dialect) || isDigit(c) || c == '$'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
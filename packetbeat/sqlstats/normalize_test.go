@@ -0,0 +1,92 @@
+// +build !integration
+
+package sqlstats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		dialect  Dialect
+		query    string
+		expected string
+	}{
+		{
+			MySQL,
+			"SELECT * FROM users WHERE id = 42 AND name = 'bob'",
+			"SELECT * FROM users WHERE id = ? AND name = ?",
+		},
+		{
+			MySQL,
+			"select  name\n from users -- trailing comment\n where id in (1, 2, 3)",
+			"select name from users where id in (...)",
+		},
+		{
+			MySQL,
+			"SELECT /* hint */ `name` FROM t WHERE a = \"x\\\"y\" # comment",
+			"SELECT `name` FROM t WHERE a = ?",
+		},
+		{
+			MySQL,
+			"INSERT INTO t (a, b) VALUES (1, 'x'), (2, 'y'), (3, 'z')",
+			"INSERT INTO t (a, b) VALUES (?, ?)",
+		},
+		{
+			MySQL,
+			"SELECT COUNT(*) FROM t WHERE a > -1.5e3 AND b = 0xff AND c = _utf8'x' AND d = x - 1",
+			"SELECT COUNT(*) FROM t WHERE a > ? AND b = ? AND c = ? AND d = x - ?",
+		},
+		{
+			MySQL,
+			"SELECT id FROM users WHERE id = ? AND name = ?",
+			"SELECT id FROM users WHERE id = ? AND name = ?",
+		},
+		{
+			PostgreSQL,
+			"SELECT \"Name\" FROM users WHERE id = $1 AND note = E'it\\'s' AND body = $$a 'b'$$",
+			"SELECT \"Name\" FROM users WHERE id = ? AND note = ? AND body = ?",
+		},
+		{
+			PostgreSQL,
+			"SELECT created::date FROM t WHERE tag = $x$ text $x$ AND id IN ($1, $2)",
+			"SELECT created::date FROM t WHERE tag = ? AND id IN (...)",
+		},
+		{
+			PostgreSQL,
+			"SELECT 'it''s'",
+			"SELECT ?",
+		},
+	}
+
+	for _, test := range tests {
+		normalized, _ := Normalize(test.query, test.dialect)
+		assert.Equal(t, test.expected, normalized, test.query)
+	}
+}
+
+func TestNormalize_fingerprint(t *testing.T) {
+	_, f1 := Normalize("SELECT * FROM users WHERE id IN (1, 2)", MySQL)
+	_, f2 := Normalize("select *\n  from users\n where id in (7)", MySQL)
+	_, f3 := Normalize("SELECT * FROM orders WHERE id IN (1, 2)", MySQL)
+
+	assert.Len(t, f1, 16)
+	assert.Equal(t, f1, f2)
+	assert.NotEqual(t, f1, f3)
+}
+
+func TestNormalize_differentTuples(t *testing.T) {
+	// tuples with a different shape are kept
+	normalized, _ := Normalize("INSERT INTO t VALUES (1, 2), (3, NOW())", MySQL)
+	assert.Equal(t, "INSERT INTO t VALUES (?, ?), (?, NOW())", normalized)
+}
+
+func TestNormalize_unterminated(t *testing.T) {
+	normalized, _ := Normalize("SELECT 'abc", MySQL)
+	assert.Equal(t, "SELECT ?", normalized)
+
+	normalized, _ = Normalize("SELECT a /* comment", PostgreSQL)
+	assert.Equal(t, "SELECT a", normalized)
+}
@@ -0,0 +1,105 @@
+from packetbeat import BaseTest
+
+"""
+Tests for the TLS protocol analyzer.
+"""
+
+
+class Test(BaseTest):
+
+    def test_tls_1_2_handshake(self):
+        """
+        Should report the handshake details and the server certificate of a
+        TLS 1.2 session.
+        """
+        self.render_config_template()
+        self.run_packetbeat(pcap="tls_1_2.pcap")
+        objs = self.read_output()
+
+        assert len(objs) == 1
+        o = objs[0]
+
+        assert o["type"] == "tls"
+        assert o["status"] == "OK"
+        assert o["port"] == 443
+        assert o["tls.handshake_completed"]
+        assert o["tls.version"] == "TLS 1.2"
+        assert not o["tls.resumed"]
+        assert o["tls.client_hello.extensions.server_name_indication"] == ["example.com"]
+        assert o["tls.server_hello.selected_cipher"] == \
+            "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"
+        assert o["tls.server_hello.extensions.application_layer_protocol_negotiation"] == ["h2"]
+        assert o["tls.server_certificate.subject.common_name"] == "example.com"
+        assert o["tls.server_certificate.alternative_names"] == \
+            ["example.com", "www.example.com"]
+        assert o["tls.fingerprints.ja3.hash"] == "6172967d81e5e44e0e8c0eea4a48aeda"
+        assert "tls.server_certificate.raw" not in o
+
+    def test_tls_1_3_handshake(self):
+        """
+        Should report the version negotiated using the supported_versions
+        extension of a TLS 1.3 session.
+        """
+        self.render_config_template()
+        self.run_packetbeat(pcap="tls_1_3.pcap")
+        objs = self.read_output()
+
+        assert len(objs) == 1
+        o = objs[0]
+
+        assert o["status"] == "OK"
+        assert o["tls.handshake_completed"]
+        assert o["tls.version"] == "TLS 1.3"
+        assert o["tls.server_hello.selected_cipher"] == "TLS_AES_128_GCM_SHA256"
+        assert "tls.server_certificate.subject.common_name" not in o
+
+    def test_fatal_alert(self):
+        """
+        Should report a failed handshake with the alert sent by the client.
+        """
+        self.render_config_template()
+        self.run_packetbeat(pcap="tls_alert.pcap")
+        objs = self.read_output()
+
+        assert len(objs) == 1
+        o = objs[0]
+
+        assert o["status"] == "Error"
+        assert not o["tls.handshake_completed"]
+        assert o["tls.alerts"] == [{
+            "source": "client",
+            "severity": "fatal",
+            "code": 42,
+            "type": "bad_certificate",
+        }]
+
+    def test_certificates_disabled(self):
+        """
+        Should not report certificates if send_certificates is disabled.
+        """
+        self.render_config_template(
+            tls_send_certificates=False,
+        )
+        self.run_packetbeat(pcap="tls_1_2.pcap")
+        objs = self.read_output()
+
+        assert len(objs) == 1
+        o = objs[0]
+
+        assert o["tls.handshake_completed"]
+        assert "tls.server_certificate.subject.common_name" not in o
+
+    def test_raw_certificates(self):
+        """
+        Should include the PEM encoded certificate if include_raw_certificates
+        is enabled.
+        """
+        self.render_config_template(
+            tls_include_raw_certificates=True,
+        )
+        self.run_packetbeat(pcap="tls_1_2.pcap")
+        objs = self.read_output()
+
+        assert len(objs) == 1
+        assert objs[0]["tls.server_certificate.raw"].startswith(
+            "-----BEGIN CERTIFICATE-----")
@@ -0,0 +1,49 @@
+from packetbeat import BaseTest
+
+"""
+Tests for the Kafka protocol analyzer.
+"""
+
+
+class Test(BaseTest):
+
+    def test_kafka_transactions(self):
+        """
+        Should correlate Metadata and Produce requests with their responses.
+        """
+        self.render_config_template()
+        self.run_packetbeat(pcap="kafka.pcap")
+        objs = self.read_output()
+
+        assert len(objs) == 3
+        assert all([o["type"] == "kafka" for o in objs])
+        assert all([o["port"] == 9092 for o in objs])
+        assert all([o["kafka.client_id"] == "producer-1" for o in objs])
+        assert [o["kafka.correlation_id"] for o in objs] == [1, 2, 3]
+
+        o = objs[0]
+        assert o["method"] == "Metadata"
+        assert o["status"] == "OK"
+        assert o["kafka.api_version"] == 9
+        assert o["kafka.topics"] == ["orders"]
+        assert o["kafka.response.brokers"] == 1
+        assert o["kafka.response.cluster_id"] == "cluster-1"
+
+        o = objs[1]
+        assert o["method"] == "Produce"
+        assert o["status"] == "OK"
+        assert o["kafka.request.acks"] == -1
+        assert o["kafka.request.record_bytes"] == 64
+
+    def test_kafka_produce_error(self):
+        """
+        Should report partition errors of a Produce response.
+        """
+        self.render_config_template()
+        self.run_packetbeat(pcap="kafka.pcap")
+        objs = self.read_output()
+
+        o = objs[2]
+        assert o["method"] == "Produce"
+        assert o["status"] == "Error"
+        assert o["kafka.response.errors"] == ["NOT_LEADER_OR_FOLLOWER"]
@@ -0,0 +1,42 @@
+from packetbeat import BaseTest
+
+"""
+Tests for the HTTP/2 and gRPC support of the HTTP protocol analyzer.
+"""
+
+
+class Test(BaseTest):
+
+    def test_grpc_over_h2c(self):
+        """
+        Should report each stream of a cleartext HTTP/2 connection as a
+        separate transaction, including the gRPC call details.
+        """
+        self.render_config_template(
+            http_ports=[50051],
+        )
+        self.run_packetbeat(pcap="http2_grpc.pcap")
+        objs = self.read_output()
+
+        assert len(objs) == 2
+        assert all([o["type"] == "http" for o in objs])
+        assert all([o["port"] == 50051 for o in objs])
+        assert all([o["method"] == "POST" for o in objs])
+
+        objs = sorted(objs, key=lambda o: o["http.stream_id"])
+
+        o = objs[0]
+        assert o["status"] == "OK"
+        assert o["path"] == "/helloworld.Greeter/SayHello"
+        assert o["http.response.code"] == 200
+        assert o["grpc.service"] == "helloworld.Greeter"
+        assert o["grpc.method"] == "SayHello"
+        assert o["grpc.status"] == "OK"
+        assert o["grpc.status_code"] == 0
+
+        o = objs[1]
+        assert o["status"] == "Error"
+        assert o["http.stream_id"] == 3
+        assert o["grpc.method"] == "SayGoodbye"
+        assert o["grpc.status"] == "UNIMPLEMENTED"
+        assert o["grpc.message"] == "unknown method"
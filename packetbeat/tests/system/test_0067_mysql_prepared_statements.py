@@ -0,0 +1,42 @@
+from packetbeat import BaseTest
+
+
+class Test(BaseTest):
+
+    def test_mysql_prepared_statements(self):
+        """
+        Should decode the handshake and the prepared statements of a
+        connection.
+        """
+        self.render_config_template(
+            mysql_ports=[3306],
+            mysql_send_response=True,
+        )
+        self.run_packetbeat(pcap="mysql_prepared_statements.pcap",
+                            debug_selectors=["mysql,tcp,publish"])
+
+        objs = self.read_output()
+        assert all([o["type"] == "mysql" for o in objs])
+        assert len(objs) == 3
+
+        login, prepare, execute = objs
+
+        assert login["method"] == "CONNECT"
+        assert login["status"] == "OK"
+        assert login["mysql.handshake.server_version"] == "5.7.18"
+        assert login["mysql.handshake.user"] == "app"
+        assert login["mysql.handshake.database"] == "shop"
+        assert login["mysql.handshake.auth_plugin"] == "mysql_native_password"
+        assert login["mysql.handshake.ssl"] is False
+
+        assert prepare["method"] == "PREPARE"
+        assert prepare["query"] == "SELECT id, name FROM users WHERE id = ?"
+        assert prepare["mysql.statement_id"] == 1
+        assert prepare["mysql.num_params"] == 1
+
+        assert execute["method"] == "SELECT"
+        assert execute["query"] == "SELECT id, name FROM users WHERE id = ?"
+        assert execute["mysql.statement_id"] == 1
+        assert execute["mysql.params"] == ["42"]
+        assert execute["mysql.num_rows"] == 1
+        assert execute["response"] == "id,name\n42,bob\n"
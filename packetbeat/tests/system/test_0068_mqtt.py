@@ -0,0 +1,57 @@
+from packetbeat import BaseTest
+
+"""
+Tests for the MQTT protocol analyzer.
+"""
+
+
+class Test(BaseTest):
+
+    def test_mqtt_transactions(self):
+        """
+        Should correlate the acknowledgments with their request, and report
+        the client ID on all transactions of the connection.
+        """
+        self.render_config_template()
+        self.run_packetbeat(pcap="mqtt.pcap")
+        objs = self.read_output()
+
+        assert len(objs) == 6
+        assert all([o["type"] == "mqtt" for o in objs])
+        assert all([o["status"] == "OK" for o in objs])
+        assert all([o["mqtt.client_id"] == "sensor-1" for o in objs])
+        assert all([o["mqtt.protocol_version"] == "3.1.1" for o in objs])
+        assert [o["method"] for o in objs] == [
+            "CONNECT", "SUBSCRIBE", "PUBLISH", "PUBLISH", "PINGREQ",
+            "DISCONNECT"]
+
+        o = objs[1]
+        assert o["mqtt.packet_id"] == 1
+        assert o["mqtt.request.subscriptions"] == [
+            {"topic": "devices/sensor-1/cmd", "qos": 1}]
+        assert o["mqtt.response.reason_codes"] == [1]
+
+        o = objs[2]
+        assert o["mqtt.request.topic"] == "devices/sensor-1/temp"
+        assert o["mqtt.request.qos"] == 1
+        assert o["mqtt.request.payload_size"] == 13
+        assert o["mqtt.response.type"] == "PUBACK"
+        assert "mqtt.request.payload" not in o
+
+        # published by the broker
+        o = objs[3]
+        assert o["mqtt.request.topic"] == "devices/sensor-1/cmd"
+        assert o["port"] == 51234
+
+    def test_mqtt_payload(self):
+        """
+        Should add the payload of the PUBLISH messages if enabled.
+        """
+        self.render_config_template(
+            mqtt_include_payload=True,
+        )
+        self.run_packetbeat(pcap="mqtt.pcap")
+        objs = self.read_output()
+
+        assert objs[2]["mqtt.request.payload"] == '{"temp":21.5}'
+        assert objs[3]["mqtt.request.payload"] == "reboot"
@@ -0,0 +1,59 @@
+from packetbeat import BaseTest
+
+"""
+Tests for the SIP protocol analyzer.
+"""
+
+
+class Test(BaseTest):
+
+    def test_sip_call(self):
+        """
+        Should correlate the responses with their request, and publish the
+        summary of the call after the BYE request.
+        """
+        self.render_config_template()
+        self.run_packetbeat(pcap="sip_rtp.pcap")
+        objs = self.read_output()
+
+        assert [o["type"] for o in objs] == ["sip", "sip_call", "sip"]
+        assert [o["method"] for o in objs] == ["INVITE", "INVITE", "BYE"]
+        assert all([o["transport"] == "udp" for o in objs])
+        assert all([o["status"] == "OK" for o in objs])
+
+        o = objs[0]
+        assert o["sip.call_id"] == "3848276298220188511@10.0.0.10"
+        assert o["sip.status_code"] == 200
+        assert o["sip.provisional_responses"] == [100, 180]
+        assert o["sip.ringing_time"] == 505
+        assert o["sip.from.display_name"] == "Alice"
+        assert o["sip.to.tag"] == "8321234356"
+        assert o["sip.sdp.request"][0]["port"] == 40000
+        assert o["sip.sdp.response"][0]["codecs"] == [
+            "PCMU/8000", "telephone-event/8000"]
+
+        o = objs[1]
+        assert o["responsetime"] == 2505
+        assert o["sip_call.end_reason"] == "bye"
+        assert o["sip_call.setup_time"] == 2505
+        assert o["sip_call.duration"] == 1019
+        assert "sip_call.rtp" not in o
+
+    def test_sip_rtp(self):
+        """
+        Should report the packet loss of the RTP streams if enabled.
+        """
+        self.render_config_template(
+            sip_rtp=True,
+        )
+        self.run_packetbeat(pcap="sip_rtp.pcap")
+        objs = self.read_output()
+
+        streams = objs[1]["sip_call.rtp"]
+        assert len(streams) == 2
+        assert streams[0]["src"] == {"ip": "10.0.0.10", "port": 40000}
+        assert streams[0]["packets"] == 49
+        assert streams[0]["lost"] == 1
+        assert streams[0]["codec"] == "PCMU"
+        assert streams[1]["packets"] == 50
+        assert streams[1]["lost"] == 0